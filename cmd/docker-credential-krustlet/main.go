@@ -0,0 +1,118 @@
+// docker-credential-krustlet is a docker credential helper that gets
+// credentials from kubelet image credential provider plugins, so tools that
+// read the docker config, such as wasm2oci, can use them.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/credentialprovider"
+)
+
+// Docker runs credential helpers without arguments other than the action, so
+// the plugin configuration comes from the environment
+const (
+	configEnv = "KRUSTLET_CREDENTIAL_PROVIDER_CONFIG"
+	binDirEnv = "KRUSTLET_CREDENTIAL_PROVIDER_BIN_DIR"
+)
+
+// errNotFound is the message docker expects when a helper has no credentials
+var errNotFound = errors.New("credentials not found in native keychain")
+
+type options struct {
+	config string
+	binDir string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "docker-credential-krustlet",
+		Short: "Docker credential helper backed by kubelet credential provider plugins",
+		Long: `Docker credential helper backed by kubelet credential provider plugins.
+
+The plugins and the images they serve are configured with a kubelet
+CredentialProviderConfig file, given by --config or $` + configEnv + `.
+Plugins are looked up in --bin-dir or $` + binDirEnv + `.
+
+Only "get" is supported; credentials from plugins can't be stored or erased.`,
+		SilenceUsage: true,
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&opts.config, "config", os.Getenv(configEnv), "path to the credential provider config")
+	flags.StringVar(&opts.binDir, "bin-dir", os.Getenv(binDirEnv), "directory holding the credential provider plugins")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get",
+		Short: "Print the credentials for the server URL read from stdin",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return get(opts, cmd.InOrStdin(), cmd.OutOrStdout())
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "Print the stored credentials, which are always none",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			_, err := fmt.Fprintln(cmd.OutOrStdout(), "{}")
+			return err
+		},
+	})
+	for _, action := range []string{"store", "erase"} {
+		cmd.AddCommand(&cobra.Command{
+			Use:    action,
+			Hidden: true,
+			RunE: func(*cobra.Command, []string) error {
+				return fmt.Errorf("%s is not supported: credentials come from credential provider plugins", action)
+			},
+		})
+	}
+	return cmd
+}
+
+func get(opts *options, in io.Reader, out io.Writer) error {
+	if opts.config == "" {
+		return fmt.Errorf("no credential provider config: set --config or $%s", configEnv)
+	}
+	providers, err := credentialprovider.Load(opts.config, opts.binDir)
+	if err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("reading server URL from stdin: %w", err)
+	}
+	serverURL := strings.TrimSpace(line)
+	registry := strings.TrimPrefix(strings.TrimPrefix(serverURL, "https://"), "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+
+	cred, err := providers.CredentialFunc()(registry)
+	if err != nil {
+		return err
+	}
+	if cred.IsEmpty() {
+		// Docker reads the message from stdout, not stderr
+		fmt.Fprintln(out, errNotFound)
+		return errNotFound
+	}
+	return json.NewEncoder(out).Encode(struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}{serverURL, cred.Username, cred.Password})
+}
@@ -0,0 +1,100 @@
+// krustlet-admission serves the admission webhooks that prepare pods for
+// krustlet nodes.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/admission"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+type options struct {
+	addr        string
+	certFile    string
+	keyFile     string
+	arch        string
+	stripFields []string
+	kubeconfig  string
+	timeout     time.Duration
+	plainHTTP   []string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-admission",
+		Short: "Serve admission webhooks that prepare pods for krustlet nodes",
+		Long: `Serve admission webhooks that prepare pods for krustlet nodes.
+
+/mutate adds the node selector and tolerations that pods annotated with
+krustlet.dev/wasm: "true" need to be scheduled on krustlet nodes, and
+removes container fields krustlet doesn't support.
+
+/validate rejects pods targeting krustlet nodes whose images are container
+images rather than wasm modules.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := admission.ValidateStripFields(opts.stripFields); err != nil {
+				return err
+			}
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.addr, "addr", ":8443", "address to serve on")
+	flags.StringVar(&opts.certFile, "tls-cert-file", "/etc/krustlet-admission/tls.crt", "TLS certificate to serve with")
+	flags.StringVar(&opts.keyFile, "tls-private-key-file", "/etc/krustlet-admission/tls.key", "TLS private key to serve with")
+	flags.StringVar(&opts.arch, "arch", admission.DefaultArch, "architecture label of the krustlet nodes to target")
+	flags.StringSliceVar(&opts.stripFields, "strip-fields", admission.DefaultStrippedFields, "container fields to remove from wasm pods")
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "kubeconfig used to read image pull secrets (defaults to in-cluster config)")
+	flags.DurationVar(&opts.timeout, "registry-timeout", 5*time.Second, "how long /validate may spend checking a pod's images")
+	flags.StringSliceVar(&opts.plainHTTP, "plain-http", nil, "registries to reach over plain HTTP when checking images")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	mutator := admission.NewPodMutator(opts.arch)
+	mutator.StripFields = opts.stripFields
+
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-admission")
+	if err != nil {
+		return err
+	}
+	validator := admission.NewImageValidator(opts.arch, client,
+		oci.WithUserAgent("krustlet-admission"), oci.WithPlainHTTP(opts.plainHTTP...))
+	validator.Timeout = opts.timeout
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", admission.Handler(mutator))
+	mux.Handle("/validate", admission.Handler(validator))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	return admission.ListenAndServeTLS(ctx, opts.addr, opts.certFile, opts.keyFile, mux)
+}
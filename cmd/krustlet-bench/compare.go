@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/bench"
+)
+
+func newCompareCommand() *cobra.Command {
+	var threshold float64
+	cmd := &cobra.Command{
+		Use:   "compare OLD NEW",
+		Short: "Compare the results of two runs",
+		Long: `Compare the JSON results of two runs, such as of the last release and of a
+candidate, by the median of each metric they both have. It exits non-zero if
+any metric is worse by more than --threshold percent: slower, or a lower rate
+for throughputs.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			old, err := readReport(args[0])
+			if err != nil {
+				return err
+			}
+			new, err := readReport(args[1])
+			if err != nil {
+				return err
+			}
+			if !compare(cmd.OutOrStdout(), old, new, threshold/100) {
+				return errFailed
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Float64Var(&threshold, "threshold", 10, "percentage a metric may get worse by before it is a regression")
+	return cmd
+}
+
+func readReport(path string) (*bench.Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := bench.ReadJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// compare prints how each metric changed, returning false if any regressed
+func compare(out io.Writer, old, new *bench.Report, threshold float64) bool {
+	changes := bench.Compare(old, new)
+	fmt.Fprintf(out, "Comparing %s (%s) with %s (%s)\n\n", old.KubeletVersion, old.Node, new.KubeletVersion, new.Node)
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tUNIT\tOLD\tNEW\tCHANGE\t")
+	regressions := 0
+	for _, c := range changes {
+		mark := ""
+		if c.Regression(threshold) {
+			mark = "REGRESSION"
+			regressions++
+		}
+		// Shown as worse when positive, whichever way the metric goes
+		fmt.Fprintf(w, "%s\t%s\t%.3f\t%.3f\t%+.1f%%\t%s\n", c.Key, c.Unit, c.Old, c.New, c.Ratio*100, mark)
+	}
+	w.Flush()
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "\nThe runs have no metrics in common")
+		return true
+	}
+	if regressions > 0 {
+		fmt.Fprintf(out, "\n%d of %d metrics regressed by more than %.0f%%\n", regressions, len(changes), threshold*100)
+		return false
+	}
+	fmt.Fprintf(out, "\nNo regressions of more than %.0f%%\n", threshold*100)
+	return true
+}
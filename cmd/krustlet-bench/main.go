@@ -0,0 +1,43 @@
+// krustlet-bench measures how quickly a krustlet node pulls modules and
+// starts pods, and compares the results of two runs to catch regressions
+// between releases.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// errFailed is returned when a run or comparison has already reported why
+// it failed, so it is not printed again
+var errFailed = errors.New("failed")
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		if !errors.Is(err, errFailed) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:           "krustlet-bench",
+		Short:         "Benchmark module pulls and pod starts on a krustlet node",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	cmd.PersistentFlags().AddGoFlagSet(klogFlags)
+
+	cmd.AddCommand(newRunCommand(), newCompareCommand())
+	return cmd
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/krustlet/krustlet/pkg/bench"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+type runOptions struct {
+	kubeconfig   string
+	context      string
+	benchmarks   []string
+	repository   string
+	dockerConfig string
+	plainHTTP    bool
+	insecure     bool
+	sizes        []string
+	format       string
+	output       string
+	cfg          bench.Config
+}
+
+func newRunCommand() *cobra.Command {
+	opts := &runOptions{}
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the benchmarks on a node",
+		Long: `Run the benchmarks on a node and write the results as JSON or CSV.
+
+pull pushes modules of each --sizes to --repository, with random contents so
+nothing has them cached, and times the node pulling each one, then pulling it
+again from its cache, and the same pull from this host as a baseline. start
+times pods of a cached module from creation to running and finishing. scale
+starts --concurrency pods at once and times how long the node takes to start
+them all. Each scenario is measured --iterations times; the pods run in a
+namespace of their own, which is deleted afterwards.
+
+Without --repository, pull is skipped and start and scale run --image. The
+command exits non-zero if any scenario failed, after writing the results of the
+others.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !cmd.Flags().Changed("benchmarks") && opts.repository == "" {
+				opts.benchmarks = []string{bench.BenchmarkStart, bench.BenchmarkScale}
+			}
+			return run(cmd.Context(), cmd.OutOrStdout(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&opts.context, "context", "", "kubeconfig context to use")
+	flags.StringVar(&opts.cfg.NodeName, "node-name", "krustlet-wasi", "krustlet node to benchmark")
+	flags.StringVar(&opts.cfg.Arch, "arch", bench.DefaultArch, "architecture label of the node")
+	flags.StringSliceVar(&opts.benchmarks, "benchmarks", bench.Benchmarks, "benchmarks to run")
+	flags.StringVar(&opts.repository, "repository", "", "repository to push modules to, such as localhost:5000/krustlet-bench, which the node must be able to pull from under the same name")
+	flags.StringVar(&opts.dockerConfig, "docker-config", "", "docker config file with registry credentials (default ~/.docker/config.json)")
+	flags.BoolVar(&opts.plainHTTP, "plain-http", false, "use plain HTTP to reach the registry")
+	flags.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification for the registry")
+	flags.StringVar(&opts.cfg.Image, "image", bench.DefaultImage, "module start and scale run without --repository")
+	flags.StringSliceVar(&opts.sizes, "sizes", []string{"1Mi", "10Mi", "50Mi"}, "module sizes pull measures")
+	flags.IntSliceVar(&opts.cfg.Concurrency, "concurrency", bench.DefaultConcurrency, "numbers of pods scale starts at once")
+	flags.IntVar(&opts.cfg.Iterations, "iterations", 3, "times each scenario is measured")
+	flags.DurationVar(&opts.cfg.PodTimeout, "pod-timeout", 5*time.Minute, "how long a pod may take to finish")
+	flags.StringVar(&opts.cfg.NamespacePrefix, "namespace-prefix", "krustlet-bench", "prefix of the namespace the pods run in")
+	flags.BoolVar(&opts.cfg.KeepNamespace, "keep-namespace", false, "leave the namespace behind for debugging")
+	flags.StringVar(&opts.format, "format", "json", "format of the results: json, which has every sample, or csv")
+	flags.StringVarP(&opts.output, "output", "o", "", "file to write the results to (default stdout)")
+	return cmd
+}
+
+func run(ctx context.Context, stdout io.Writer, opts *runOptions) error {
+	write := bench.WriteJSON
+	switch opts.format {
+	case "json":
+	case "csv":
+		write = bench.WriteCSV
+	default:
+		return fmt.Errorf("unknown --format %q, expected json or csv", opts.format)
+	}
+	if opts.cfg.Iterations < 1 {
+		return fmt.Errorf("--iterations must be at least 1")
+	}
+	for _, s := range opts.sizes {
+		q, err := resource.ParseQuantity(s)
+		if err != nil {
+			return fmt.Errorf("invalid size %q: %w", s, err)
+		}
+		opts.cfg.Sizes = append(opts.cfg.Sizes, q.Value())
+	}
+	for _, n := range opts.cfg.Concurrency {
+		if n < 1 {
+			return fmt.Errorf("--concurrency must be at least 1, got %d", n)
+		}
+	}
+
+	if opts.repository != "" {
+		repo, err := oci.ParseRepository(opts.repository)
+		if err != nil {
+			return err
+		}
+		registry, err := opts.registry(repo)
+		if err != nil {
+			return err
+		}
+		opts.cfg.Registry, opts.cfg.Repository = registry, &repo
+	}
+	client, err := kubeclient.NewClientset(opts.kubeconfig, opts.context, "krustlet-bench")
+	if err != nil {
+		return err
+	}
+	opts.cfg.Client = client
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	report, err := bench.Run(ctx, opts.cfg, opts.benchmarks...)
+	if err != nil {
+		return err
+	}
+
+	out := stdout
+	if opts.output != "" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := write(out, report); err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		for _, e := range report.Errors {
+			fmt.Fprintln(os.Stderr, "Failed:", e)
+		}
+		return errFailed
+	}
+	return nil
+}
+
+// registry returns a registry client for pushing to repo
+func (o *runOptions) registry(repo oci.Reference) (*oci.Client, error) {
+	path := o.dockerConfig
+	if path == "" {
+		var err error
+		if path, err = oci.DockerConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := oci.LoadDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	opts := []oci.Option{oci.WithCredentials(oci.DockerCredentials(cfg)), oci.WithUserAgent("krustlet-bench")}
+	if o.plainHTTP {
+		opts = append(opts, oci.WithPlainHTTP(repo.Registry))
+	}
+	if o.insecure {
+		opts = append(opts, oci.WithInsecureSkipVerify())
+	}
+	return oci.NewClient(opts...), nil
+}
@@ -0,0 +1,150 @@
+// krustlet-bootstrap prepares a cluster for a new krustlet node: it creates a
+// bootstrap token and writes the bootstrap kubeconfig krustlet uses to request
+// its certificates. It replaces the old bootstrap.sh and bootstrap.ps1
+// scripts, and needs neither kubectl nor a shell.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/krustlet/krustlet/pkg/bootstrap"
+)
+
+type options struct {
+	kubeconfig  string
+	context     string
+	configDir   string
+	fileName    string
+	ttl         time.Duration
+	skipChecks  bool
+	printOutput bool
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-bootstrap",
+		Short: "Create a bootstrap token and kubeconfig for a krustlet node",
+		Long: `Create a bootstrap token and kubeconfig for a krustlet node.
+
+The token is created in the cluster of the current kubeconfig context (or
+--context), after checking that the cluster is new enough for krustlet and
+that you are allowed to create bootstrap tokens. The bootstrap kubeconfig is
+written to <config-dir>/<file-name>, which is where krustlet's
+--bootstrap-file flag should point.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig of the cluster (default $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&opts.context, "context", "", "kubeconfig context to use (default the current context)")
+	flags.StringVar(&opts.configDir, "config-dir", envOr("CONFIG_DIR", defaultConfigDir()), "directory to write the bootstrap kubeconfig to ($CONFIG_DIR)")
+	flags.StringVar(&opts.fileName, "file-name", envOr("FILE_NAME", "bootstrap.conf"), "name of the bootstrap kubeconfig file ($FILE_NAME)")
+	flags.DurationVar(&opts.ttl, "token-ttl", time.Hour, "how long the bootstrap token is valid for")
+	flags.BoolVar(&opts.skipChecks, "skip-checks", false, "skip the cluster version and permission checks")
+	flags.BoolVar(&opts.printOutput, "print", false, "print the bootstrap kubeconfig to stdout instead of writing it to a file")
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.kubeconfig
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: opts.context})
+
+	raw, err := loader.RawConfig()
+	if err != nil {
+		return fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	contextName := opts.context
+	if contextName == "" {
+		contextName = raw.CurrentContext
+	}
+	if contextName == "" {
+		return fmt.Errorf("the kubeconfig has no current context; pass --context to choose one")
+	}
+
+	restConfig, err := loader.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("loading kubeconfig context %q: %w", contextName, err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Using context %q (%s)\n", contextName, restConfig.Host)
+
+	if !opts.skipChecks {
+		if err := bootstrap.CheckCluster(ctx, client); err != nil {
+			return err
+		}
+	}
+
+	token, err := bootstrap.NewToken()
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := bootstrap.Kubeconfig(&raw, contextName, token)
+	if err != nil {
+		return err
+	}
+	if err := bootstrap.CreateTokenSecret(ctx, client, token, time.Now().Add(opts.ttl)); err != nil {
+		return err
+	}
+
+	if opts.printOutput {
+		data, err := clientcmd.Write(*kubeconfig)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.MkdirAll(opts.configDir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(opts.configDir, opts.fileName)
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		return fmt.Errorf("writing bootstrap kubeconfig: %w", err)
+	}
+	// The kubeconfig holds a credential, so keep it private
+	if err := os.Chmod(path, 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created bootstrap token %s, valid for %s\nWrote %s\n", token.ID, opts.ttl, path)
+	return nil
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// defaultConfigDir is krustlet's default config directory, ~/.krustlet/config
+func defaultConfigDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".krustlet", "config")
+	}
+	return filepath.Join(home, ".krustlet", "config")
+}
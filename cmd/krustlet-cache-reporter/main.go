@@ -0,0 +1,129 @@
+// krustlet-cache-reporter publishes the modules cached on a krustlet node in
+// an annotation on the node.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/imagecache"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/modulestore"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+type options struct {
+	kubeconfig string
+	nodeName   string
+	dataDir    string
+	maxSize    string
+	interval   time.Duration
+	once       bool
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-cache-reporter",
+		Short: "Publish the modules cached on a krustlet node in a node annotation",
+		Long: `Publish the modules cached on a krustlet node in a node annotation.
+
+The reporter runs on the krustlet host and, every --interval, reads
+krustlet's module store and writes an index of the modules in it, with their
+manifest digests, sizes and when they were pulled, to the node's
+` + imagecache.CachedModulesAnnotation + ` annotation as JSON. The node is only
+patched when the index changes. Modules still being pulled are left out, and
+so are the least recently pulled ones if the index would be larger than
+--max-size. With --once, the reporter reports once and exits.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig, usually krustlet's own (default in-cluster configuration)")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.StringVar(&opts.dataDir, "data-dir", dataDir, "krustlet's data directory")
+	flags.StringVar(&opts.maxSize, "max-size", "64Ki", "largest size of the annotation, leaving out the least recently pulled modules beyond it")
+	flags.DurationVar(&opts.interval, "interval", time.Minute, "how often to read the module store")
+	flags.BoolVar(&opts.once, "once", false, "report once and exit")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	if opts.interval <= 0 && !opts.once {
+		return fmt.Errorf("--interval must be positive")
+	}
+	maxSize, err := resource.ParseQuantity(opts.maxSize)
+	if err != nil {
+		return fmt.Errorf("invalid --max-size %q: %w", opts.maxSize, err)
+	}
+	// The API server allows 256Ki for all of a node's annotations
+	if maxSize.Value() <= 0 || maxSize.Value() > 256*1024 {
+		return fmt.Errorf("--max-size must be between 1 and 256Ki")
+	}
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-cache-reporter")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	r := imagecache.New(client, opts.nodeName, imagecache.Options{
+		ModuleDir: modulestore.Dir(opts.dataDir),
+		MaxSize:   int(maxSize.Value()),
+	})
+	if opts.once {
+		_, err := r.Report(ctx)
+		return err
+	}
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for {
+		// A failed report is retried at the next interval
+		if _, err := r.Report(ctx); err != nil {
+			klog.ErrorS(err, "Report failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
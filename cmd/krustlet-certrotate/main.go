@@ -0,0 +1,185 @@
+// krustlet-certrotate renews a krustlet node's client and serving
+// certificates before they expire.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/certrotate"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+type options struct {
+	kubeconfig      string
+	nodeName        string
+	certFile        string
+	keyFile         string
+	nodeIP          string
+	skipClient      bool
+	skipServing     bool
+	expiryWarning   time.Duration
+	approvalTimeout time.Duration
+	restartCommand  string
+	interval        time.Duration
+	once            bool
+	force           bool
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-certrotate",
+		Short: "Renew a krustlet node's certificates before they expire",
+		Long: `Renew a krustlet node's certificates before they expire.
+
+Every --interval, the client certificate in krustlet's kubeconfig and its
+serving certificate are checked. Once a certificate is between 70% and 90%
+of the way through its lifetime, a replacement for a new key is requested
+with a CSR signed in as the node, and the files are swapped atomically when
+it is issued. Client renewals are approved by the controller manager, or by
+krustlet-csr-approver; serving renewals need krustlet-csr-approver or
+kubectl certificate approve.
+
+Krustlet reads its certificates when it starts, so give --restart-command
+to restart it after a renewal. Renewals and failures are recorded as events
+on the node. With --once, the certificates are checked once, failing if one
+couldn't be renewed; --force renews them whether or not they are due.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+	kubeconfig := os.Getenv("KUBECONFIG")
+	if kubeconfig == "" {
+		home, _ := os.UserHomeDir()
+		kubeconfig = filepath.Join(home, ".kube", "config")
+	}
+	certFile := os.Getenv("KRUSTLET_CERT_FILE")
+	if certFile == "" {
+		certFile = filepath.Join(dataDir, "config", "krustlet.crt")
+	}
+	keyFile := os.Getenv("KRUSTLET_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		keyFile = filepath.Join(dataDir, "config", "krustlet.key")
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", kubeconfig, "krustlet's kubeconfig, holding its client certificate")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.StringVar(&opts.certFile, "cert-file", certFile, "krustlet's serving certificate")
+	flags.StringVar(&opts.keyFile, "private-key-file", keyFile, "krustlet's serving key")
+	flags.StringVar(&opts.nodeIP, "node-ip", "", "IP address to issue the renewed serving certificate for, if the node's address changed (default the current certificate's)")
+	flags.BoolVar(&opts.skipClient, "skip-client", false, "don't renew the client certificate")
+	flags.BoolVar(&opts.skipServing, "skip-serving", false, "don't renew the serving certificate")
+	flags.DurationVar(&opts.expiryWarning, "expiry-warning", certrotate.DefaultExpiryWarning, "how close to expiry a certificate that fails to renew is reported with a warning event")
+	flags.DurationVar(&opts.approvalTimeout, "approval-timeout", certrotate.DefaultApprovalTimeout, "how long to wait for a CSR to be approved before retrying at the next check")
+	flags.StringVar(&opts.restartCommand, "restart-command", "", "command to run after a renewal to restart krustlet, such as \"systemctl restart krustlet\"")
+	flags.DurationVar(&opts.interval, "interval", time.Hour, "how often to check the certificates")
+	flags.BoolVar(&opts.once, "once", false, "check once and exit")
+	flags.BoolVar(&opts.force, "force", false, "renew the certificates now, whether or not they are due; implies --once")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	if opts.interval <= 0 && !opts.once && !opts.force {
+		return fmt.Errorf("--interval must be positive")
+	}
+	rotateOpts := certrotate.Options{
+		NodeName:        opts.nodeName,
+		ExpiryWarning:   opts.expiryWarning,
+		ApprovalTimeout: opts.approvalTimeout,
+	}
+	if !opts.skipClient {
+		rotateOpts.Kubeconfig = opts.kubeconfig
+	}
+	if !opts.skipServing {
+		rotateOpts.ServingCert, rotateOpts.ServingKey = opts.certFile, opts.keyFile
+	}
+	if opts.nodeIP != "" {
+		ip := net.ParseIP(opts.nodeIP)
+		if ip == nil {
+			return fmt.Errorf("invalid --node-ip %q", opts.nodeIP)
+		}
+		// Krustlet's serving certificate is for its hostname, which is the
+		// node name unless --hostname was given, and its address
+		rotateOpts.ServingDNSNames, rotateOpts.ServingIPs = []string{opts.nodeName}, []net.IP{ip}
+	}
+	if opts.restartCommand != "" {
+		rotateOpts.AfterRotate = func(ctx context.Context, kinds []certrotate.Kind) error {
+			return restart(ctx, opts.restartCommand)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// The client is made anew for every check, so renewing the client
+	// certificate also renews the certificate this process signs in with
+	m := certrotate.New(func() (kubernetes.Interface, error) {
+		return kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-certrotate")
+	}, rotateOpts)
+	if opts.once || opts.force {
+		statuses, err := m.Check(ctx, opts.force)
+		for _, st := range statuses {
+			fmt.Printf("%s certificate %s: expires %s, renews %s, renewed %t\n",
+				st.Kind, st.Path, st.NotAfter.UTC().Format(time.RFC3339), st.RenewAt.UTC().Format(time.RFC3339), st.Renewed)
+		}
+		return err
+	}
+	return m.Run(ctx, opts.interval)
+}
+
+// restart runs the restart command through the shell
+func restart(ctx context.Context, command string) error {
+	klog.InfoS("Restarting krustlet", "command", command)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
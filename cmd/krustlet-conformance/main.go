@@ -0,0 +1,135 @@
+// krustlet-conformance checks that a krustlet node behaves the way
+// Kubernetes expects a node to, by running a suite of wasm workloads on it.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/conformance"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+)
+
+type options struct {
+	kubeconfig string
+	context    string
+	focus      string
+	skip       string
+	list       bool
+	cfg        conformance.Config
+}
+
+// errFailed is returned when a case fails. The report has already been
+// printed, so it is not printed again.
+var errFailed = errors.New("some conformance cases failed")
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		if !errors.Is(err, errFailed) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-conformance",
+		Short: "Check that a krustlet node conforms to what Kubernetes expects of a node",
+		Long: `Check that a krustlet node conforms to what Kubernetes expects of a node.
+
+Each case runs wasm workloads in a namespace of its own and checks pod phase
+transitions, logs, environment variables, volume mounts and deletion. The
+command exits non-zero if any case fails.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&opts.context, "context", "", "kubeconfig context to use")
+	flags.StringVar(&opts.cfg.NodeName, "node-name", "krustlet-wasi", "krustlet node to test; empty allows any node with the architecture")
+	flags.StringVar(&opts.cfg.Arch, "arch", conformance.DefaultArch, "architecture label of the node")
+	flags.StringVar(&opts.cfg.NodeArchitecture, "node-architecture", conformance.DefaultNodeArchitecture, "architecture the node reports in its node info")
+	flags.StringVar(&opts.cfg.WasmerciserImage, "wasmerciser-image", conformance.DefaultWasmerciserImage, "wasmerciser module the cases run")
+	flags.StringVar(&opts.cfg.HelloImage, "hello-image", conformance.DefaultHelloImage, "hello world module the cases run")
+	flags.StringVar(&opts.cfg.HostPath, "host-path", "/tmp", "existing directory on the node for hostPath volumes")
+	flags.DurationVar(&opts.cfg.PodTimeout, "pod-timeout", 2*time.Minute, "how long a pod may take to reach the phase a case waits for")
+	flags.StringVar(&opts.cfg.NamespacePrefix, "namespace-prefix", "krustlet-conformance", "prefix of the namespaces cases run in")
+	flags.BoolVar(&opts.cfg.KeepNamespaces, "keep-namespaces", false, "leave each case's namespace behind for debugging")
+	flags.StringVar(&opts.focus, "focus", "", "only run cases whose name matches this regular expression")
+	flags.StringVar(&opts.skip, "skip", "", "skip cases whose name matches this regular expression")
+	flags.BoolVar(&opts.list, "list", false, "list the selected cases without running them")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func compile(name, expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %w", name, err)
+	}
+	return re, nil
+}
+
+func run(ctx context.Context, opts *options) error {
+	focus, err := compile("focus", opts.focus)
+	if err != nil {
+		return err
+	}
+	skip, err := compile("skip", opts.skip)
+	if err != nil {
+		return err
+	}
+	cases := conformance.Select(conformance.Cases(), focus, skip)
+	if len(cases) == 0 {
+		return fmt.Errorf("no cases match --focus %q and --skip %q", opts.focus, opts.skip)
+	}
+	if opts.list {
+		for _, c := range cases {
+			fmt.Printf("%-26s %s\n", c.Name, c.Description)
+		}
+		return nil
+	}
+
+	client, err := kubeclient.NewClientset(opts.kubeconfig, opts.context, "krustlet-conformance")
+	if err != nil {
+		return err
+	}
+	opts.cfg.Client = client
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	results := make([]conformance.Result, 0, len(cases))
+	for _, c := range cases {
+		fmt.Printf("=== %s\n", c.Name)
+		results = append(results, conformance.RunCase(ctx, opts.cfg, c))
+	}
+	fmt.Println()
+	if !conformance.Report(os.Stdout, results) {
+		return errFailed
+	}
+	return nil
+}
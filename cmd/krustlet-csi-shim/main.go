@@ -0,0 +1,136 @@
+// krustlet-csi-shim publishes the inline CSI volumes of pods on a krustlet
+// node through their CSI drivers, so krustlet can preopen them for modules.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/csishim"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+type options struct {
+	kubeconfig string
+	nodeName   string
+	root       string
+	pluginDir  string
+	drivers    map[string]string
+	workers    int
+
+	providerDir string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-csi-shim",
+		Short: "Publish inline CSI volumes for pods on a krustlet node",
+		Long: `Publish inline CSI volumes for pods on a krustlet node.
+
+The shim runs on the krustlet host, watches the pods scheduled to the node and
+calls the CSI driver of each inline csi volume to stage and publish it under
+--root. Krustlet waits for the volume to be published and preopens it for the
+pod's modules. Volumes are unpublished once their pod finishes or is deleted.
+
+Secrets Store CSI volumes are published by the shim itself, unless the Secrets
+Store CSI driver is running on the node: it calls the provider of the volume's
+SecretProviderClass, found in --secrets-store-provider-dir, and writes the
+secrets it returns into the volume.
+
+--root must match krustlet's KRUSTLET_CSI_SHIM_DIR.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig, usually krustlet's own (default in-cluster configuration)")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.StringVar(&opts.root, "root", csishim.DefaultRoot, "directory to publish volumes under")
+	flags.StringVar(&opts.pluginDir, "plugin-dir", csishim.DefaultPluginDir, "directory CSI drivers put their sockets in, as <dir>/<driver>/csi.sock")
+	flags.StringToStringVar(&opts.drivers, "driver", nil, "socket of a CSI driver, as name=path, overriding --plugin-dir; may be repeated")
+	flags.IntVar(&opts.workers, "workers", 4, "number of pods to publish volumes for concurrently")
+	flags.StringVar(&opts.providerDir, "secrets-store-provider-dir", csishim.DefaultProviderDir, "directory Secrets Store CSI providers put their sockets in, as <dir>/<provider>.sock")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	if opts.root == "" {
+		return errors.New("--root is required")
+	}
+	if err := os.MkdirAll(opts.root, 0o750); err != nil {
+		return err
+	}
+
+	config, err := kubeclient.RESTConfig(opts.kubeconfig, "")
+	if err != nil {
+		return err
+	}
+	config = rest.AddUserAgent(config, "krustlet-csi-shim")
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Only the node's own pods are watched, which is also all the node
+	// authorizer allows when running with krustlet's credentials
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", opts.nodeName).String()
+		}),
+	)
+	controller, err := csishim.NewController(client, factory, &csishim.Config{
+		NodeName:     opts.nodeName,
+		Root:         opts.root,
+		PluginDir:    opts.pluginDir,
+		Endpoints:    opts.drivers,
+		SecretsStore: csishim.NewSecretsStore(dynamicClient, opts.providerDir),
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	return controller.Run(ctx, opts.workers)
+}
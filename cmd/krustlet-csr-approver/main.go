@@ -0,0 +1,107 @@
+// krustlet-csr-approver approves the certificate signing requests krustlet
+// nodes make while bootstrapping, according to a configurable policy.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/csrapprover"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+)
+
+type options struct {
+	kubeconfig      string
+	approveClient   bool
+	approveServing  bool
+	nodeNamePattern string
+	allowedCIDRs    []string
+	workers         int
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-csr-approver",
+		Short: "Approve the certificate signing requests of krustlet nodes",
+		Long: `Approve the certificate signing requests of krustlet nodes.
+
+Client certificate requests are approved when they come from a bootstrap
+token, or from the node renewing its own certificate. Serving certificate
+requests are approved when they come from the node they are for and only
+name that node. Requests that don't match the policy are left pending.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default in-cluster configuration)")
+	flags.BoolVar(&opts.approveClient, "approve-client", true, "approve node client certificates")
+	flags.BoolVar(&opts.approveServing, "approve-serving", true, "approve node serving certificates")
+	flags.StringVar(&opts.nodeNamePattern, "node-name-pattern", "", "only approve certificates for nodes whose name matches this regular expression")
+	flags.StringSliceVar(&opts.allowedCIDRs, "allowed-cidrs", nil, "only approve serving certificates whose IP addresses are in these CIDRs")
+	flags.IntVar(&opts.workers, "workers", 2, "number of CSRs to process concurrently")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func (o *options) policy() (*csrapprover.Policy, error) {
+	p := &csrapprover.Policy{ApproveClient: o.approveClient, ApproveServing: o.approveServing}
+	if o.nodeNamePattern != "" {
+		re, err := regexp.Compile(o.nodeNamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --node-name-pattern: %w", err)
+		}
+		p.NodeNamePattern = re
+	}
+	for _, c := range o.allowedCIDRs {
+		_, cidr, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --allowed-cidrs entry %q: %w", c, err)
+		}
+		p.AllowedCIDRs = append(p.AllowedCIDRs, cidr)
+	}
+	return p, nil
+}
+
+func run(ctx context.Context, opts *options) error {
+	policy, err := opts.policy()
+	if err != nil {
+		return err
+	}
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-csr-approver")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	controller := csrapprover.NewController(client, factory, policy)
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	return controller.Run(ctx, opts.workers)
+}
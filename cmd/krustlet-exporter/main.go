@@ -0,0 +1,109 @@
+// krustlet-exporter serves Prometheus metrics about krustlet nodes and the
+// pods running on them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/exporter"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+)
+
+type options struct {
+	kubeconfig    string
+	addr          string
+	arch          string
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-exporter",
+		Short: "Serve Prometheus metrics about krustlet nodes and their pods",
+		Long: `Serve Prometheus metrics about krustlet nodes and their pods.
+
+Node and pod metrics, including pod start latency and module pull durations,
+are derived from the objects krustlet updates in the API server. Each node's
+API is probed for health and for the expiry of its serving certificate.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default in-cluster configuration)")
+	flags.StringVar(&opts.addr, "addr", ":9090", "address to serve metrics on")
+	flags.StringVar(&opts.arch, "arch", exporter.DefaultArch, "architecture label of the krustlet nodes to report on")
+	flags.DurationVar(&opts.probeInterval, "probe-interval", 30*time.Second, "how often to probe each node's API")
+	flags.DurationVar(&opts.probeTimeout, "probe-timeout", 5*time.Second, "how long a node's API may take to answer a probe")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-exporter")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	e := exporter.New(factory, opts.arch)
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	for typ, ok := range factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return errors.New("timed out waiting for " + typ.String() + " cache to sync")
+		}
+	}
+	go e.RunProbes(ctx, opts.probeInterval, opts.probeTimeout)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	srv := &http.Server{Addr: opts.addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	klog.InfoS("Serving metrics", "addr", opts.addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+// krustlet-fleet installs krustlet on many hosts over ssh and registers them
+// as nodes, each with its own bootstrap token.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/bootstrap"
+	"github.com/krustlet/krustlet/pkg/csrapprover"
+	"github.com/krustlet/krustlet/pkg/fleet"
+)
+
+type options struct {
+	kubeconfig      string
+	context         string
+	inventory       string
+	krustlet        string
+	ssh             string
+	sshArgs         []string
+	sudo            bool
+	tokenTTL        time.Duration
+	labels          []string
+	timeout         time.Duration
+	parallel        int
+	approve         bool
+	nodeNamePattern string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-fleet [HOST...]",
+		Short: "Install krustlet on hosts and register them as nodes",
+		Long: `Install krustlet on hosts and register them as nodes.
+
+Each host, given as an argument or a line of --inventory, is reached with
+ssh and gets:
+
+  - the --krustlet binary, as /usr/local/bin/krustlet-wasi
+  - a krustlet account and /etc/krustlet
+  - a bootstrap kubeconfig with a bootstrap token of its own, unless it
+    already has its credentials
+  - a systemd unit, krustlet.service, which is enabled and restarted
+
+The host is done once its node is ready. While provisioning, the nodes'
+certificate signing requests are approved as krustlet-csr-approver would.
+Hosts are provisioned --parallel at a time, and a summary is printed at the
+end.`,
+		Args:         cobra.ArbitraryArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.Context(), opts, args, cmd.OutOrStdout())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig of the cluster (default $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&opts.context, "context", "", "kubeconfig context to use (default the current context)")
+	flags.StringVar(&opts.inventory, "inventory", "", "file of hosts, one per line, each followed by optional name=<node name> and ip=<node IP>")
+	flags.StringVar(&opts.krustlet, "krustlet", "", "krustlet binary to install, built for the hosts")
+	flags.StringVar(&opts.ssh, "ssh", "ssh", "ssh command")
+	flags.StringArrayVar(&opts.sshArgs, "ssh-arg", nil, "argument passed to ssh, such as -i=<key>; may be repeated")
+	flags.BoolVar(&opts.sudo, "sudo", true, "run commands on the hosts with sudo")
+	flags.DurationVar(&opts.tokenTTL, "token-ttl", time.Hour, "how long each host's bootstrap token is valid for")
+	flags.StringSliceVar(&opts.labels, "node-labels", nil, "labels to add to every node, as key=value")
+	flags.DurationVar(&opts.timeout, "timeout", 5*time.Minute, "how long to wait for each node to become ready")
+	flags.IntVar(&opts.parallel, "parallel", 10, "number of hosts to provision at once")
+	flags.BoolVar(&opts.approve, "approve", true, "approve the nodes' certificate signing requests")
+	flags.StringVar(&opts.nodeNamePattern, "node-name-pattern", "", "only approve certificates for nodes whose name matches this regular expression")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options, args []string, out io.Writer) error {
+	var targets []fleet.Target
+	if opts.inventory != "" {
+		f, err := os.Open(opts.inventory)
+		if err != nil {
+			return err
+		}
+		targets, err = fleet.ParseInventory(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", opts.inventory, err)
+		}
+	}
+	for _, host := range args {
+		targets = append(targets, fleet.Target{Host: host})
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no hosts given; pass them as arguments or with --inventory")
+	}
+	if opts.krustlet == "" {
+		return fmt.Errorf("--krustlet is required")
+	}
+	binary, err := os.ReadFile(opts.krustlet)
+	if err != nil {
+		return err
+	}
+	policy := &csrapprover.Policy{ApproveClient: true, ApproveServing: true}
+	if opts.nodeNamePattern != "" {
+		if policy.NodeNamePattern, err = regexp.Compile(opts.nodeNamePattern); err != nil {
+			return fmt.Errorf("invalid --node-name-pattern: %w", err)
+		}
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.kubeconfig
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: opts.context})
+	raw, err := loader.RawConfig()
+	if err != nil {
+		return fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	contextName := opts.context
+	if contextName == "" {
+		contextName = raw.CurrentContext
+	}
+	if contextName == "" {
+		return fmt.Errorf("the kubeconfig has no current context; pass --context to choose one")
+	}
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("loading kubeconfig context %q: %w", contextName, err)
+	}
+	config = rest.AddUserAgent(config, "krustlet-fleet")
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Using context %q (%s)\n", contextName, config.Host)
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := bootstrap.CheckCluster(ctx, client); err != nil {
+		return err
+	}
+	if opts.approve {
+		factory := informers.NewSharedInformerFactory(client, 0)
+		approver := csrapprover.NewController(client, factory, policy)
+		factory.Start(ctx.Done())
+		defer factory.Shutdown()
+		go func() { _ = approver.Run(ctx, 2) }()
+	}
+
+	results := fleet.Provision(ctx, &fleet.Config{
+		Client:      client,
+		Kubeconfig:  &raw,
+		Context:     contextName,
+		APIServerIP: apiServerIP(config.Host),
+		Runner:      &fleet.SSH{Command: opts.ssh, Args: opts.sshArgs, Sudo: opts.sudo},
+		Binary:      binary,
+		TokenTTL:    opts.tokenTTL,
+		Labels:      opts.labels,
+		Timeout:     opts.timeout,
+		Parallel:    opts.parallel,
+	}, targets)
+
+	return summarize(out, results)
+}
+
+// apiServerIP returns an address of the API server, which hosts find the
+// address they reach it from with, or empty if it can't be resolved
+func apiServerIP(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return ip.String()
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		klog.InfoS("Can't resolve the API server; nodes will register with krustlet's default address", "host", u.Hostname())
+		return ""
+	}
+	return ips[0].String()
+}
+
+// summarize prints a line for each host and returns an error if any host's
+// node isn't ready
+func summarize(out io.Writer, results []fleet.Result) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tNODE\tSTATUS\tBOOTSTRAPPED\tTIME\tERROR")
+	failed := 0
+	for _, r := range results {
+		msg := ""
+		if r.Err != nil {
+			msg = r.Step + ": " + r.Err.Error()
+		}
+		if r.Status != fleet.StatusReady {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", r.Target.Host, r.NodeName, r.Status, r.Bootstrapped, r.Duration.Round(time.Second), msg)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts are not ready", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// krustlet-hostcaps advertises host capabilities, such as sensor buses or
+// crypto tokens, as extended resources on a krustlet node.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/hostcaps"
+)
+
+type options struct {
+	config    string
+	pluginDir string
+	interval  time.Duration
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-hostcaps",
+		Short: "Advertise host capabilities as extended resources on a krustlet node",
+		Long: `Advertise host capabilities as extended resources on a krustlet node.
+
+Each capability in the config file is served as a device plugin and
+registered with krustlet, which adds it to the node's allocatable resources.
+Pods that request a capability in resources.limits get its host directories
+preopened and its environment variables set; pods that don't, see neither.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.config, "config", "/etc/krustlet/capabilities.yaml", "path to the capability config")
+	flags.StringVar(&opts.pluginDir, "device-plugins-dir", defaultPluginDir(), "krustlet's device plugin directory")
+	flags.DurationVar(&opts.interval, "interval", hostcaps.DefaultInterval, "how often to check capability health and whether krustlet restarted")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+// defaultPluginDir is krustlet's default: KRUSTLET_DEVICE_PLUGINS_DIR, or
+// device_plugins in its data directory
+func defaultPluginDir() string {
+	if dir := os.Getenv("KRUSTLET_DEVICE_PLUGINS_DIR"); dir != "" {
+		return dir
+	}
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+	return filepath.Join(dataDir, "device_plugins")
+}
+
+func run(ctx context.Context, opts *options) error {
+	config, err := hostcaps.LoadConfig(opts.config)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	agent := hostcaps.New(config, opts.pluginDir)
+	agent.Interval = opts.interval
+	return agent.Run(ctx)
+}
@@ -0,0 +1,146 @@
+// krustlet-janitor removes cached modules, volume directories and logs that
+// no pod on a krustlet node uses any more.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/janitor"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/modulestore"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+type options struct {
+	kubeconfig string
+	nodeName   string
+	dataDir    string
+	minAge     time.Duration
+	keep       []string
+	dryRun     bool
+	interval   time.Duration
+	once       bool
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-janitor",
+		Short: "Remove modules, volumes and logs no pod on a krustlet node uses",
+		Long: `Remove modules, volumes and logs no pod on a krustlet node uses.
+
+The janitor runs on the krustlet host and, every --interval, lists the pods
+bound to the node and removes:
+
+  modules   cached modules no pod on the node runs
+  volumes   volume directories of pods that are gone
+  logs      log files of pods that are gone
+
+Nothing that changed within --min-age is removed, and nothing at all if the
+node's pods can't be listed. Volume directories with anything mounted in
+them are left alone. With --once, the janitor collects once and exits, to be
+run from cron or a systemd timer.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig, usually krustlet's own (default in-cluster configuration)")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.StringVar(&opts.dataDir, "data-dir", dataDir, "krustlet's data directory")
+	flags.DurationVar(&opts.minAge, "min-age", janitor.DefaultMinAge, "how long anything is kept after it last changed")
+	flags.StringSliceVar(&opts.keep, "keep", nil, "pattern of modules never to remove, such as ghcr.io/example/* or ghcr.io/example/app:v1; may be repeated")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "report what would be removed without removing it")
+	flags.DurationVar(&opts.interval, "interval", time.Hour, "how often to collect")
+	flags.BoolVar(&opts.once, "once", false, "collect once and exit")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	if opts.interval <= 0 && !opts.once {
+		return fmt.Errorf("--interval must be positive")
+	}
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-janitor")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	j := janitor.New(client, opts.nodeName, janitor.Options{
+		ModuleDir: modulestore.Dir(opts.dataDir),
+		VolumeDir: filepath.Join(opts.dataDir, "volumes"),
+		LogDir:    filepath.Join(opts.dataDir, "wasi-logs"),
+		MinAge:    opts.minAge,
+		Keep:      opts.keep,
+		DryRun:    opts.dryRun,
+	})
+	if opts.once {
+		return collect(ctx, j, opts.dryRun)
+	}
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for {
+		// A failed collection is retried at the next interval
+		if err := collect(ctx, j, opts.dryRun); err != nil {
+			klog.ErrorS(err, "Collection failed")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func collect(ctx context.Context, j *janitor.Janitor, dryRun bool) error {
+	report, err := j.Collect(ctx)
+	if report != nil {
+		klog.InfoS("Collected",
+			"modules", len(report.Modules),
+			"volumes", len(report.Volumes),
+			"logs", len(report.Logs),
+			"freed", resource.NewQuantity(report.Bytes, resource.BinarySI).String(),
+			"skipped", len(report.Skipped),
+			"dryRun", dryRun)
+	}
+	return err
+}
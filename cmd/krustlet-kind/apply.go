@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const fieldManager = "krustlet-kind"
+
+// decodeManifests reads the objects in a YAML or JSON stream, skipping empty
+// documents
+func decodeManifests(r io.Reader) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		err := decoder.Decode(&obj.Object)
+		if errors.Is(err, io.EOF) {
+			return objs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, fmt.Errorf("object without a kind or name: %v", obj.Object)
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// applyManifests applies the objects in the files with server side apply,
+// putting namespaced objects without a namespace in the default namespace
+func applyManifests(ctx context.Context, config *rest.Config, out io.Writer, paths ...string) error {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		objs, err := decodeManifests(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		for _, obj := range objs {
+			gvk := obj.GroupVersionKind()
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			resource := client.Resource(mapping.Resource)
+			var ri dynamic.ResourceInterface = resource
+			if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+				if obj.GetNamespace() == "" {
+					obj.SetNamespace(metav1.NamespaceDefault)
+				}
+				ri = resource.Namespace(obj.GetNamespace())
+			}
+			data, err := obj.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			force := true
+			if _, err := ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{FieldManager: fieldManager, Force: &force}); err != nil {
+				return fmt.Errorf("applying %s %s from %s: %w", gvk.Kind, obj.GetName(), path, err)
+			}
+			fmt.Fprintf(out, "Applied %s %s\n", gvk.Kind, obj.GetName())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newDownCommand(global *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Delete the cluster and the krustlet nodes' data",
+		Long: `Delete the cluster and the krustlet nodes' data.
+
+The kind cluster is deleted, along with the state directory holding its
+kubeconfig and the krustlet nodes' certificates, modules and logs. Stop up
+first.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runDown(cmd.Context(), global)
+		},
+	}
+	return cmd
+}
+
+func runDown(ctx context.Context, opts *globalOptions) error {
+	exists, err := clusterExists(ctx, opts.name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := runTool(ctx, "kind", "delete", "cluster", "--name", opts.name); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(opts.dir()); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Removed %s\n", opts.dir())
+	return nil
+}
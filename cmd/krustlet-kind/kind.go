@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// kindNetwork is the docker network kind puts its nodes on
+func kindNetwork() string {
+	if n := os.Getenv("KIND_EXPERIMENTAL_DOCKER_NETWORK"); n != "" {
+		return n
+	}
+	return "kind"
+}
+
+// runTool runs a command line tool, sending its output to stderr
+func runTool(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// toolOutput runs a command line tool and returns what it writes to stdout
+func toolOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// clusterExists reports whether kind has a cluster with the name
+func clusterExists(ctx context.Context, name string) (bool, error) {
+	out, err := toolOutput(ctx, "kind", "get", "clusters")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.TrimSpace(line) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// createCluster creates a kind cluster, with the node image if one is given
+func createCluster(ctx context.Context, name, image string) error {
+	args := []string{"create", "cluster", "--name", name}
+	if image != "" {
+		args = append(args, "--image", image)
+	}
+	return runTool(ctx, "kind", args...)
+}
+
+// hostIP finds the address the kind cluster's containers reach the host at,
+// which is the address krustlet nodes must register with for the API server
+// to reach them for logs and exec. On Linux the host is the gateway of
+// kind's docker network. Docker Desktop runs containers in a VM, where the
+// host is only reachable through host.docker.internal.
+func hostIP(ctx context.Context, name string) (net.IP, error) {
+	if runtime.GOOS == "linux" {
+		out, err := toolOutput(ctx, "docker", "network", "inspect", kindNetwork(), "--format", "{{range .IPAM.Config}}{{.Gateway}} {{end}}")
+		if err != nil {
+			return nil, err
+		}
+		if ip := firstIPv4(string(out)); ip != nil {
+			return ip, nil
+		}
+		return nil, fmt.Errorf("docker network %s has no IPv4 gateway", kindNetwork())
+	}
+	out, err := toolOutput(ctx, "docker", "exec", name+"-control-plane", "getent", "ahostsv4", "host.docker.internal")
+	if err != nil {
+		return nil, err
+	}
+	if ip := firstIPv4(string(out)); ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("host.docker.internal doesn't resolve in the kind cluster")
+}
+
+// firstIPv4 returns the first IPv4 address among the whitespace separated
+// fields of s
+func firstIPv4(s string) net.IP {
+	for _, f := range strings.Fields(s) {
+		if ip := net.ParseIP(f); ip != nil && ip.To4() != nil {
+			return ip.To4()
+		}
+	}
+	return nil
+}
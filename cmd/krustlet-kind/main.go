@@ -0,0 +1,58 @@
+// krustlet-kind sets up a local development cluster: a kind cluster with one
+// or more krustlet nodes running on the host, bootstrapped, approved and
+// running the demo workloads.
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// globalOptions are the flags shared by every subcommand
+type globalOptions struct {
+	name     string
+	stateDir string
+}
+
+// dir returns the directory the cluster's kubeconfig and krustlet nodes are
+// kept in
+func (o *globalOptions) dir() string {
+	if o.stateDir != "" {
+		return o.stateDir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".krustlet", "kind", o.name)
+	}
+	return filepath.Join(home, ".krustlet", "kind", o.name)
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	global := &globalOptions{}
+	cmd := &cobra.Command{
+		Use:          "krustlet-kind",
+		Short:        "Run krustlet nodes in a local kind cluster",
+		SilenceUsage: true,
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&global.name, "name", "krustlet", "name of the kind cluster")
+	flags.StringVar(&global.stateDir, "state-dir", "", "directory for the cluster's kubeconfig and the krustlet nodes' data (default ~/.krustlet/kind/<name>)")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+
+	cmd.AddCommand(newUpCommand(global), newDownCommand(global))
+	return cmd
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/krustlet/krustlet/pkg/bootstrap"
+	"github.com/krustlet/krustlet/pkg/csrapprover"
+)
+
+// bootstrapTTL is how long the bootstrap token is valid for. Nodes only use it
+// the first time they start.
+const bootstrapTTL = time.Hour
+
+type upOptions struct {
+	*globalOptions
+	nodes    int
+	krustlet string
+	nodeIP   string
+	port     int
+	image    string
+	demos    []string
+	timeout  time.Duration
+}
+
+func newUpCommand(global *globalOptions) *cobra.Command {
+	opts := &upOptions{globalOptions: global}
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "Create the cluster and run krustlet nodes in it",
+		Long: `Create the cluster and run krustlet nodes in it.
+
+A kind cluster is created, unless it already exists, and --nodes krustlet
+processes are started on this machine, registered with the address the
+cluster's containers reach it at. Their certificate signing requests are
+approved, and once the nodes are ready the --demo manifests are applied.
+
+The krustlet nodes run until interrupted. Running up again restarts them
+with the certificates and modules they already have; down removes the
+cluster and everything up created.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runUp(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&opts.nodes, "nodes", 1, "number of krustlet nodes to run")
+	flags.StringVar(&opts.krustlet, "krustlet", "krustlet-wasi", "krustlet binary to run, such as target/debug/krustlet-wasi")
+	flags.StringVar(&opts.nodeIP, "node-ip", "", "address the cluster reaches this machine at (default found from docker)")
+	flags.IntVar(&opts.port, "port", 3000, "port of the first krustlet node; later nodes use the ports after it")
+	flags.StringVar(&opts.image, "image", "", "kind node image, to choose the Kubernetes version (default kind's own)")
+	flags.StringSliceVar(&opts.demos, "demo", []string{filepath.Join("demos", "wasi", "hello-world-rust", "k8s.yaml")}, "manifest to apply once the nodes are ready; may be repeated, or set to nothing to apply none")
+	flags.DurationVar(&opts.timeout, "timeout", 5*time.Minute, "how long to wait for the nodes to become ready")
+	return cmd
+}
+
+// node is a krustlet node run by up
+type node struct {
+	name string
+	// dir is the node's data directory, holding its certificates, modules
+	// and log
+	dir  string
+	port int
+}
+
+func (n node) kubeconfig() string { return filepath.Join(n.dir, "kubeconfig") }
+
+func (n node) logFile() string { return filepath.Join(n.dir, "krustlet.log") }
+
+// args are the flags krustlet is run with. The hostname is the node name,
+// so the serving certificate names the node it is requested by.
+func (n node) args(ip net.IP, bootstrapFile string) []string {
+	return []string{
+		"--node-name", n.name,
+		"--hostname", n.name,
+		"--node-ip", ip.String(),
+		"--port", strconv.Itoa(n.port),
+		"--data-dir", n.dir,
+		"--bootstrap-file", bootstrapFile,
+	}
+}
+
+// nodeList returns the krustlet nodes to run
+func (o *upOptions) nodeList() []node {
+	nodes := make([]node, o.nodes)
+	for i := range nodes {
+		name := fmt.Sprintf("krustlet-wasi-%d", i+1)
+		nodes[i] = node{name: name, dir: filepath.Join(o.dir(), name), port: o.port + i}
+	}
+	return nodes
+}
+
+func runUp(ctx context.Context, opts *upOptions) error {
+	if opts.nodes < 1 {
+		return fmt.Errorf("--nodes must be at least 1")
+	}
+	for _, tool := range []string{"kind", "docker"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("%s is needed to run the cluster: %w", tool, err)
+		}
+	}
+	krustlet, err := exec.LookPath(opts.krustlet)
+	if err != nil {
+		return fmt.Errorf("finding krustlet; build it with `cargo build --bin krustlet-wasi` and pass --krustlet: %w", err)
+	}
+	for _, demo := range opts.demos {
+		if _, err := os.Stat(demo); err != nil {
+			return fmt.Errorf("demo manifest: %w; run from a krustlet checkout or pass --demo", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	exists, err := clusterExists(ctx, opts.name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := createCluster(ctx, opts.name, opts.image); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(opts.dir(), 0o755); err != nil {
+		return err
+	}
+	kubeconfig, err := toolOutput(ctx, "kind", "get", "kubeconfig", "--name", opts.name)
+	if err != nil {
+		return err
+	}
+	kubeconfigPath := filepath.Join(opts.dir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfigPath, kubeconfig, 0o600); err != nil {
+		return err
+	}
+	raw, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return fmt.Errorf("loading the cluster's kubeconfig: %w", err)
+	}
+	config, err := clientcmd.NewDefaultClientConfig(*raw, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return err
+	}
+	config = rest.AddUserAgent(config, "krustlet-kind")
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	var ip net.IP
+	if opts.nodeIP != "" {
+		if ip = net.ParseIP(opts.nodeIP); ip == nil {
+			return fmt.Errorf("invalid --node-ip %q", opts.nodeIP)
+		}
+	} else if ip, err = hostIP(ctx, opts.name); err != nil {
+		return fmt.Errorf("finding the address the cluster reaches this machine at; pass --node-ip: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Krustlet nodes will register with address %s\n", ip)
+
+	nodes := opts.nodeList()
+	bootstrapFile := filepath.Join(opts.dir(), "bootstrap.conf")
+	if err := writeBootstrap(ctx, client, raw, bootstrapFile, nodes); err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	approver := csrapprover.NewController(client, factory, approvalPolicy(nodes, ip))
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	go func() { _ = approver.Run(ctx, 1) }()
+
+	exited := make(chan error, len(nodes))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	for _, n := range nodes {
+		if err := startKrustlet(ctx, &wg, krustlet, n, ip, bootstrapFile, exited); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Started %s on port %d, logging to %s\n", n.name, n.port, n.logFile())
+	}
+
+	if err := waitReady(ctx, client, nodes, exited, opts.timeout); err != nil {
+		// Interrupted while waiting
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if len(opts.demos) > 0 {
+		if err := applyManifests(ctx, config, os.Stderr, opts.demos...); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, `
+The cluster is ready. In another terminal, run:
+
+  export KUBECONFIG=%s
+  kubectl get nodes,pods -o wide
+
+Press Ctrl+C to stop the krustlet nodes.
+`, kubeconfigPath)
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-exited:
+		return err
+	}
+}
+
+// writeBootstrap writes the bootstrap kubeconfig for nodes that haven't got
+// their certificates yet, with a new bootstrap token
+func writeBootstrap(ctx context.Context, client kubernetes.Interface, raw *clientcmdapi.Config, path string, nodes []node) error {
+	needed := false
+	for _, n := range nodes {
+		if _, err := os.Stat(n.kubeconfig()); err != nil {
+			needed = true
+		}
+	}
+	if !needed {
+		return nil
+	}
+	if err := bootstrap.CheckCluster(ctx, client); err != nil {
+		return err
+	}
+	token, err := bootstrap.NewToken()
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := bootstrap.Kubeconfig(raw, raw.CurrentContext, token)
+	if err != nil {
+		return err
+	}
+	if err := bootstrap.CreateTokenSecret(ctx, client, token, time.Now().Add(bootstrapTTL)); err != nil {
+		return err
+	}
+	if err := clientcmd.WriteToFile(*kubeconfig, path); err != nil {
+		return fmt.Errorf("writing bootstrap kubeconfig: %w", err)
+	}
+	// The kubeconfig holds a credential, so keep it private
+	return os.Chmod(path, 0o600)
+}
+
+// approvalPolicy approves the certificates of the nodes, and only theirs
+func approvalPolicy(nodes []node, ip net.IP) *csrapprover.Policy {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = regexp.QuoteMeta(n.name)
+	}
+	bits := 8 * len(ip)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &csrapprover.Policy{
+		ApproveClient:   true,
+		ApproveServing:  true,
+		NodeNamePattern: regexp.MustCompile("^(" + strings.Join(names, "|") + ")$"),
+		AllowedCIDRs:    []*net.IPNet{{IP: ip, Mask: net.CIDRMask(bits, bits)}},
+	}
+}
+
+// startKrustlet runs krustlet for the node until ctx is done, when it is
+// interrupted so it can deregister. An error is sent on exited if it stops
+// before then.
+func startKrustlet(ctx context.Context, wg *sync.WaitGroup, krustlet string, n node, ip net.IP, bootstrapFile string, exited chan<- error) error {
+	if err := os.MkdirAll(n.dir, 0o755); err != nil {
+		return err
+	}
+	log, err := os.OpenFile(n.logFile(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, krustlet, n.args(ip, bootstrapFile)...)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+n.kubeconfig())
+	if os.Getenv("RUST_LOG") == "" {
+		cmd.Env = append(cmd.Env, "RUST_LOG=krustlet_wasi=info,kubelet=info,wasi_provider=info")
+	}
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	if err := cmd.Start(); err != nil {
+		log.Close()
+		return fmt.Errorf("starting krustlet for %s: %w", n.name, err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer log.Close()
+		err := cmd.Wait()
+		if ctx.Err() == nil {
+			exited <- fmt.Errorf("krustlet for %s stopped (%v); see %s", n.name, err, n.logFile())
+		}
+	}()
+	return nil
+}
+
+// waitReady waits for the nodes to register and become ready
+func waitReady(ctx context.Context, client kubernetes.Interface, nodes []node, exited <-chan error, timeout time.Duration) error {
+	fmt.Fprintf(os.Stderr, "Waiting for the nodes to become ready\n")
+	var stopped error
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		select {
+		case stopped = <-exited:
+			return false, stopped
+		default:
+		}
+		for _, n := range nodes {
+			node, err := client.CoreV1().Nodes().Get(ctx, n.name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			if !isReady(node) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if stopped != nil || err == nil || ctx.Err() != nil {
+		return stopped
+	}
+	logs := make([]string, len(nodes))
+	for i, n := range nodes {
+		logs[i] = n.logFile()
+	}
+	return fmt.Errorf("waiting for the nodes to become ready: %w; see %s", err, strings.Join(logs, ", "))
+}
+
+func isReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFirstIPv4(t *testing.T) {
+	for in, want := range map[string]string{
+		"172.18.0.1 fc00:f853:ccd:e793::1 ":                                    "172.18.0.1",
+		"fc00:f853:ccd:e793::1 172.18.0.1":                                     "172.18.0.1",
+		"192.168.65.254  STREAM host.docker.internal\n192.168.65.254  DGRAM\n": "192.168.65.254",
+	} {
+		if got := firstIPv4(in); got.String() != want {
+			t.Errorf("%q: got %v, want %s", in, got, want)
+		}
+	}
+	if got := firstIPv4("fc00::1"); got != nil {
+		t.Errorf("expected no address, got %v", got)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	opts := &upOptions{globalOptions: &globalOptions{name: "krustlet", stateDir: "/state"}, nodes: 2, port: 3000}
+	nodes := opts.nodeList()
+	if len(nodes) != 2 || nodes[1].name != "krustlet-wasi-2" || nodes[1].port != 3001 || nodes[1].dir != filepath.Join("/state", "krustlet-wasi-2") {
+		t.Fatalf("unexpected nodes %+v", nodes)
+	}
+	args := strings.Join(nodes[0].args(net.ParseIP("172.18.0.1"), "/state/bootstrap.conf"), " ")
+	for _, want := range []string{"--node-name krustlet-wasi-1", "--hostname krustlet-wasi-1", "--node-ip 172.18.0.1", "--port 3000"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected %q in %q", want, args)
+		}
+	}
+
+	policy := approvalPolicy(nodes, net.ParseIP("172.18.0.1"))
+	for name, want := range map[string]bool{"krustlet-wasi-1": true, "krustlet-wasi-2": true, "krustlet-wasi-10": false, "kind-control-plane": false} {
+		if got := policy.NodeNamePattern.MatchString(name); got != want {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	if len(policy.AllowedCIDRs) != 1 || policy.AllowedCIDRs[0].String() != "172.18.0.1/32" {
+		t.Errorf("unexpected CIDRs %v", policy.AllowedCIDRs)
+	}
+}
+
+func TestDecodeManifests(t *testing.T) {
+	objs, err := decodeManifests(strings.NewReader(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+# nothing here
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: hello
+  namespace: demo
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 2 || objs[0].GetKind() != "ConfigMap" || objs[1].GetName() != "hello" || objs[1].GetNamespace() != "demo" {
+		t.Fatalf("unexpected objects %v", objs)
+	}
+
+	if _, err := decodeManifests(strings.NewReader("apiVersion: v1\nkind: Pod\n")); err == nil {
+		t.Error("expected an object without a name to be rejected")
+	}
+}
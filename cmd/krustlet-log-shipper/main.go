@@ -0,0 +1,179 @@
+// krustlet-log-shipper ships the output of wasm pods on a krustlet node to
+// Fluentd, Loki or CloudWatch Logs.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/logship"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+type options struct {
+	kubeconfig    string
+	nodeName      string
+	logDir        string
+	positionsFile string
+	interval      time.Duration
+
+	fluentdURL string
+	fluentdTag string
+
+	lokiURL                string
+	lokiTenant             string
+	lokiStructuredMetadata bool
+
+	cloudWatchGroup  string
+	cloudWatchRegion string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-log-shipper",
+		Short: "Ship the logs of pods on a krustlet node",
+		Long: `Ship the logs of pods on a krustlet node.
+
+The shipper runs on the krustlet host, tails the log files krustlet's WASI
+provider writes for each container and sends every line, with the pod's
+namespace, name, UID, labels and image, to one of:
+
+  --fluentd-url       a Fluentd or Fluent Bit HTTP input
+  --loki-url          Loki's push API
+  --cloudwatch-group  a CloudWatch Logs log group, with credentials from the
+                      AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+                      AWS_SESSION_TOKEN environment variables`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig, usually krustlet's own (default in-cluster configuration)")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.StringVar(&opts.logDir, "log-dir", defaultLogDir(), "directory the WASI provider writes logs to")
+	flags.StringVar(&opts.positionsFile, "positions-file", "", "file to record how far each log was shipped in (default <log-dir>/../log-shipper-positions.json)")
+	flags.DurationVar(&opts.interval, "interval", logship.DefaultInterval, "how often to read the logs")
+	flags.StringVar(&opts.fluentdURL, "fluentd-url", "", "address of a Fluentd HTTP input, such as http://fluentd:9880")
+	flags.StringVar(&opts.fluentdTag, "fluentd-tag", "krustlet", "tag to post records to Fluentd with")
+	flags.StringVar(&opts.lokiURL, "loki-url", "", "address of Loki, such as http://loki:3100")
+	flags.StringVar(&opts.lokiTenant, "loki-tenant", "", "tenant ID to send to Loki")
+	flags.BoolVar(&opts.lokiStructuredMetadata, "loki-structured-metadata", false, "send pod UIDs, images and labels as Loki structured metadata")
+	flags.StringVar(&opts.cloudWatchGroup, "cloudwatch-group", "", "CloudWatch Logs log group to write to")
+	flags.StringVar(&opts.cloudWatchRegion, "cloudwatch-region", os.Getenv("AWS_REGION"), "AWS region of the log group")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func defaultLogDir() string {
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+	return filepath.Join(dataDir, "wasi-logs")
+}
+
+func newSink(opts *options) (logship.Sink, error) {
+	var sinks []logship.Sink
+	if opts.fluentdURL != "" {
+		sink, err := logship.NewFluentd(opts.fluentdURL, opts.fluentdTag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --fluentd-url: %w", err)
+		}
+		sinks = append(sinks, sink)
+	}
+	if opts.lokiURL != "" {
+		sink, err := logship.NewLoki(opts.lokiURL, opts.lokiTenant)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --loki-url: %w", err)
+		}
+		sink.StructuredMetadata = opts.lokiStructuredMetadata
+		sinks = append(sinks, sink)
+	}
+	if opts.cloudWatchGroup != "" {
+		if opts.cloudWatchRegion == "" {
+			return nil, errors.New("--cloudwatch-region or AWS_REGION is required with --cloudwatch-group")
+		}
+		creds, err := logship.AWSCredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, logship.NewCloudWatch(opts.cloudWatchRegion, opts.cloudWatchGroup, creds))
+	}
+	if len(sinks) != 1 {
+		return nil, errors.New("exactly one of --fluentd-url, --loki-url and --cloudwatch-group is required")
+	}
+	return sinks[0], nil
+}
+
+func run(ctx context.Context, opts *options) error {
+	sink, err := newSink(opts)
+	if err != nil {
+		return err
+	}
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	if opts.positionsFile == "" {
+		opts.positionsFile = filepath.Join(filepath.Dir(opts.logDir), "log-shipper-positions.json")
+	}
+
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-log-shipper")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Only the node's own pods are watched, which is also all the node
+	// authorizer allows when running with krustlet's credentials
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", opts.nodeName).String()
+		}),
+	)
+	shipper, err := logship.New(factory, sink, logship.Options{
+		Dir:           opts.logDir,
+		NodeName:      opts.nodeName,
+		PositionsFile: opts.positionsFile,
+		Interval:      opts.interval,
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	return shipper.Run(ctx)
+}
@@ -0,0 +1,153 @@
+// krustlet-mirror replicates wasm module repositories from a central registry
+// to registries at edge sites.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/mirror"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+type options struct {
+	config       string
+	dockerConfig string
+	plainHTTP    []string
+	insecure     bool
+	interval     time.Duration
+	once         bool
+	dryRun       bool
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-mirror",
+		Short: "Replicate wasm module repositories to edge registries",
+		Long: `Replicate wasm module repositories to edge registries.
+
+Every interval, the mirror lists the tags of each repository in the config,
+keeps the ones matching its tag patterns, and copies those an edge registry
+doesn't already have at the same digest to every site. Copies preserve
+digests. A site's uploads are limited to its bandwidth and sync windows, if
+it has them.
+
+Credentials for every registry come from the docker config, including
+credential helpers. With --once, the mirror syncs once and exits, failing if
+anything couldn't be synced.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.config, "config", "/etc/krustlet/mirror.yaml", "path to the mirror config")
+	flags.StringVar(&opts.dockerConfig, "docker-config", "", "path to the docker config file holding credentials (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	flags.StringSliceVar(&opts.plainHTTP, "plain-http", nil, "registry hosts to use plain HTTP rather than HTTPS with; may be repeated")
+	flags.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification")
+	flags.DurationVar(&opts.interval, "interval", 0, "how often to sync (default the config's interval)")
+	flags.BoolVar(&opts.once, "once", false, "sync once and exit")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "report what would be copied without copying it")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	cfg, err := mirror.LoadConfig(opts.config)
+	if err != nil {
+		return err
+	}
+	interval := cfg.Interval.Duration
+	if opts.interval != 0 {
+		interval = opts.interval
+	}
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	path := opts.dockerConfig
+	if path == "" {
+		if path, err = oci.DockerConfigPath(); err != nil {
+			return err
+		}
+	}
+	docker, err := oci.LoadDockerConfig(path)
+	if err != nil {
+		return err
+	}
+	mirrorOpts := mirror.Options{
+		ClientOptions: []oci.Option{
+			oci.WithCredentials(oci.DockerCredentials(docker)),
+			oci.WithPlainHTTP(opts.plainHTTP...),
+			oci.WithUserAgent("krustlet-mirror"),
+		},
+		DryRun: opts.dryRun,
+	}
+	if opts.insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		mirrorOpts.Transport = transport
+	}
+	m := mirror.New(cfg, mirrorOpts)
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if opts.once {
+		return syncOnce(ctx, m, opts.dryRun)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Whatever failed is retried at the next interval
+		if err := syncOnce(ctx, m, opts.dryRun); err != nil {
+			klog.ErrorS(err, "Sync incomplete")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func syncOnce(ctx context.Context, m *mirror.Mirror, dryRun bool) error {
+	report, err := m.Sync(ctx)
+	for _, s := range report.Sites {
+		klog.InfoS("Synced site",
+			"site", s.Name,
+			"copied", len(s.Copied),
+			"upToDate", s.UpToDate,
+			"deferred", s.Deferred,
+			"uploaded", resource.NewQuantity(s.Bytes, resource.BinarySI).String(),
+			"errors", len(s.Errors),
+			"dryRun", dryRun)
+		for _, c := range s.Copied {
+			klog.V(2).InfoS("Copied", "site", s.Name, "reference", c, "dryRun", dryRun)
+		}
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+// krustlet-node-labeler labels krustlet nodes with their role, runtime and
+// architecture, and keeps the labels in place.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/nodelabeler"
+)
+
+type options struct {
+	kubeconfig string
+	role       string
+	labels     map[string]string
+	workers    int
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-node-labeler",
+		Short: "Label krustlet nodes with their role, runtime and architecture",
+		Long: `Label krustlet nodes with their role, runtime and architecture.
+
+Every krustlet node gets node-role.kubernetes.io/<role>, so kubectl get nodes
+shows a role, and krustlet.dev/runtime and krustlet.dev/arch, split from its
+kubernetes.io/arch label, so pods can select nodes by runtime. Labels are set
+when a node registers and restored if they are changed or removed.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default in-cluster configuration)")
+	flags.StringVar(&opts.role, "role", nodelabeler.DefaultRole, "role to give krustlet nodes; empty for none")
+	flags.StringToStringVar(&opts.labels, "label", nil, "extra label to set on every krustlet node, as key=value; may be repeated")
+	flags.IntVar(&opts.workers, "workers", 2, "number of nodes to label concurrently")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	config := &nodelabeler.Config{Role: opts.role, Labels: opts.labels}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-node-labeler")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	controller := nodelabeler.NewController(client, factory, config)
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	return controller.Run(ctx, opts.workers)
+}
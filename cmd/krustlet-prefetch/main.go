@@ -0,0 +1,79 @@
+// krustlet-prefetch stages wasm modules in the module caches of krustlet
+// nodes ahead of a rollout.
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/prefetch"
+)
+
+type options struct {
+	kubeconfig string
+	arch       string
+	gateImage  string
+	gateArgs   []string
+	workers    int
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-prefetch",
+		Short: "Stage wasm modules on krustlet nodes ahead of a rollout",
+		Long: `Stage wasm modules on krustlet nodes ahead of a rollout.
+
+The modules listed in ConfigMaps labelled krustlet.dev/module-prefetch=true
+are pulled onto every krustlet node, including nodes that join later, by a
+short-lived pod per node that never runs them. Progress is recorded in each
+ConfigMap's krustlet.dev/prefetch-status annotation.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default in-cluster configuration)")
+	flags.StringVar(&opts.arch, "arch", "wasm32-wasi", "architecture label of the krustlet nodes to stage modules on")
+	flags.StringVar(&opts.gateImage, "gate-image", prefetch.DefaultGateImage, "module run as the init container that stops prefetch pods from running the modules they pull")
+	flags.StringSliceVar(&opts.gateArgs, "gate-args", prefetch.DefaultGateArgs, "arguments to the gate module, which must make it exit with an error")
+	flags.IntVar(&opts.workers, "workers", 2, "number of ConfigMaps to process concurrently")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-prefetch")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	controller := prefetch.NewController(client, factory, opts.arch, prefetch.Gate{Image: opts.gateImage, Args: opts.gateArgs})
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+	return controller.Run(ctx, opts.workers)
+}
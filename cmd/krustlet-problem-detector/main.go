@@ -0,0 +1,149 @@
+// krustlet-problem-detector reports disk and memory pressure, clock skew and
+// expiring certificates on a krustlet host as node conditions and events.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/modulestore"
+	"github.com/krustlet/krustlet/pkg/nodename"
+	"github.com/krustlet/krustlet/pkg/nodeproblem"
+)
+
+type options struct {
+	kubeconfig string
+	nodeName   string
+	interval   time.Duration
+
+	diskPath           string
+	diskMinFreePercent float64
+	diskMinFree        string
+	memoryMinAvailable string
+	maxClockSkew       time.Duration
+	certFile           string
+	certWarning        time.Duration
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-problem-detector",
+		Short: "Report problems on a krustlet host as node conditions",
+		Long: `Report problems on a krustlet host as node conditions.
+
+The detector runs on the krustlet host and sets these conditions on its node,
+recording an event whenever one changes:
+
+  DiskPressure         the module cache's filesystem is low on space
+  MemoryPressure       the host is low on available memory
+  ClockSkew            the host's clock is off the API server's
+  CertificateExpiring  krustlet's serving or client certificate expires soon
+
+DiskPressure and MemoryPressure are the kubelet's own conditions, so the node
+is tainted and the scheduler and autoscaler react to them as they would on
+any other node.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+	certFile := os.Getenv("KRUSTLET_CERT_FILE")
+	if certFile == "" {
+		certFile = filepath.Join(dataDir, "config", "krustlet.crt")
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig, usually krustlet's own (default in-cluster configuration)")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.DurationVar(&opts.interval, "interval", nodeproblem.DefaultInterval, "how often to check for problems")
+	flags.StringVar(&opts.diskPath, "disk-path", modulestore.Dir(dataDir), "directory whose filesystem is checked for disk pressure")
+	flags.Float64Var(&opts.diskMinFreePercent, "disk-min-free-percent", 10, "free space, as a percentage of the filesystem, below which there is disk pressure")
+	flags.StringVar(&opts.diskMinFree, "disk-min-free", "1Gi", "free space below which there is disk pressure")
+	flags.StringVar(&opts.memoryMinAvailable, "memory-min-available", "100Mi", "available memory below which there is memory pressure")
+	flags.DurationVar(&opts.maxClockSkew, "max-clock-skew", 10*time.Second, "how far the host's clock may be off the API server's")
+	flags.StringVar(&opts.certFile, "cert-file", certFile, "krustlet's serving certificate")
+	flags.DurationVar(&opts.certWarning, "cert-expiry-warning", 7*24*time.Hour, "how long before a certificate expires to report it")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	diskMinFree, err := resource.ParseQuantity(opts.diskMinFree)
+	if err != nil {
+		return fmt.Errorf("invalid --disk-min-free: %w", err)
+	}
+	memoryMinAvailable, err := resource.ParseQuantity(opts.memoryMinAvailable)
+	if err != nil {
+		return fmt.Errorf("invalid --memory-min-available: %w", err)
+	}
+
+	config, err := kubeclient.RESTConfig(opts.kubeconfig, "")
+	if err != nil {
+		return err
+	}
+	config = rest.AddUserAgent(config, "krustlet-problem-detector")
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	clock, err := nodeproblem.NewClockMonitor(config, opts.maxClockSkew)
+	if err != nil {
+		return err
+	}
+
+	certs := []nodeproblem.Certificate{nodeproblem.CertificateFile("serving certificate", opts.certFile)}
+	switch tls := config.TLSClientConfig; {
+	case len(tls.CertData) > 0:
+		certs = append(certs, nodeproblem.Certificate{Name: "client certificate", Load: func() ([]byte, error) { return tls.CertData, nil }})
+	case tls.CertFile != "":
+		certs = append(certs, nodeproblem.CertificateFile("client certificate", tls.CertFile))
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	detector := nodeproblem.New(client, opts.nodeName, opts.interval,
+		nodeproblem.NewDiskMonitor(opts.diskPath, opts.diskMinFreePercent, uint64(diskMinFree.Value())),
+		&nodeproblem.MemoryMonitor{MinAvailable: uint64(memoryMinAvailable.Value())},
+		clock,
+		&nodeproblem.CertificateMonitor{Certificates: certs, Warning: opts.certWarning},
+	)
+	return detector.Run(ctx)
+}
@@ -0,0 +1,172 @@
+// krustlet-registry-chaos fronts a registry with a proxy that injects faults,
+// such as timeouts, 429s, truncated blobs, slow transfers and connection
+// resets, to check how krustlet and other clients pull over an unreliable
+// network.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+type options struct {
+	addr     string
+	upstream string
+	faults   []string
+	seed     int64
+	certFile string
+	keyFile  string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-registry-chaos",
+		Short: "Proxy a registry, injecting network and registry faults",
+		Long: `Proxy a registry, injecting network and registry faults.
+
+Requests are forwarded to --upstream unless a --fault matches them. Faults are
+given as kind[:key=value,...]:
+
+  timeout    hold the request, for delay or until the client gives up, then
+             drop the connection
+  status     answer with status (429), sending retry-after if set; a status
+             code on its own, such as 503, is short for this
+  truncate   drop the connection after bytes bytes of the response body
+  slow       send the response body at rate bytes a second (1024)
+  reset      reset the connection without answering
+
+Every fault also takes path, a regular expression matched against the request
+path; method; probability, from 0 to 1 (default always); after, to skip the
+first matching requests; and times, to inject it at most that many times.
+Each request gets the first fault that matches it. For example:
+
+  --fault 429:path=/manifests/,times=2
+  --fault truncate:path=/blobs/,bytes=65536,probability=0.3
+  --fault slow:path=/blobs/,rate=10240`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.addr, "addr", "localhost:5001", "address to serve on")
+	flags.StringVar(&opts.upstream, "upstream", "", "URL of the registry to proxy, such as https://webassembly.azurecr.io; a host alone uses HTTPS")
+	flags.StringArrayVar(&opts.faults, "fault", nil, "fault to inject (may be repeated)")
+	flags.Int64Var(&opts.seed, "seed", 1, "seed for fault probabilities, so runs can be repeated")
+	flags.StringVar(&opts.certFile, "tls-cert-file", "", "serve TLS with this certificate")
+	flags.StringVar(&opts.keyFile, "tls-private-key-file", "", "private key of the TLS certificate")
+	_ = cmd.MarkFlagRequired("upstream")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if (opts.certFile == "") != (opts.keyFile == "") {
+		return errors.New("--tls-cert-file and --tls-private-key-file must be given together")
+	}
+	raw := opts.upstream
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	upstream, err := url.Parse(raw)
+	if err != nil || upstream.Host == "" {
+		return fmt.Errorf("invalid upstream %q", opts.upstream)
+	}
+	var faults []ocitest.Fault
+	for _, s := range opts.faults {
+		f, err := ocitest.ParseFault(s)
+		if err != nil {
+			return err
+		}
+		faults = append(faults, f)
+	}
+	chaos, err := ocitest.NewChaos(upstream, opts.seed, faults...)
+	if err != nil {
+		return err
+	}
+	chaos.OnFault = func(f ocitest.Fault, req *http.Request) {
+		klog.InfoS("Injected fault", "fault", f.String(), "method", req.Method, "path", req.URL.Path)
+	}
+
+	ln, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return err
+	}
+	scheme := "http"
+	if opts.certFile != "" {
+		scheme = "https"
+	}
+	fmt.Printf("Proxying %s on %s://%s with %d faults\n", upstream, scheme, ln.Addr(), len(faults))
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	srv := &http.Server{
+		Handler:           chaos,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Resets hijack the connection, which HTTP/2 doesn't allow
+		TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+	}
+	errs := make(chan error, 1)
+	go func() {
+		if opts.certFile != "" {
+			errs <- srv.ServeTLS(ln, opts.certFile, opts.keyFile)
+			return
+		}
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	printInjected(chaos.Injected())
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// printInjected prints how many of each kind of fault were injected
+func printInjected(injected map[ocitest.FaultKind]int) {
+	kinds := make([]string, 0, len(injected))
+	for kind := range injected {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	fmt.Println("Injected faults:")
+	for _, kind := range kinds {
+		fmt.Printf("  %-9s %d\n", kind, injected[ocitest.FaultKind(kind)])
+	}
+}
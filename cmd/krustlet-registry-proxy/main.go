@@ -0,0 +1,189 @@
+// krustlet-registry-proxy serves a pull-through registry proxy on localhost
+// that adds the credentials for each registry to the requests it forwards,
+// so krustlet can pull private modules without holding credentials itself.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/credentialprovider"
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/regproxy"
+)
+
+type options struct {
+	addr                     string
+	dockerConfig             string
+	credentialProviderConfig string
+	credentialProviderBinDir string
+	aliases                  []string
+	plainHTTP                []string
+	allow                    []string
+	cacheDir                 string
+	cacheSize                string
+	certFile                 string
+	keyFile                  string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-registry-proxy",
+		Short: "Proxy registry pulls, adding the credentials for each registry",
+		Long: `Proxy registry pulls, adding the credentials for each registry.
+
+Repositories are named through the proxy by the registry they are on, so a
+pod pulls ghcr.io/example/app:v1 as localhost:5000/ghcr.io/example/app:v1.
+Names whose first component isn't a host are on Docker Hub. Registries whose
+host has a port need an --alias, such as --alias local=registry.local:5000,
+to be pulled as localhost:5000/local/<repository>.
+
+Credentials come from --docker-config, which is read again whenever a
+registry asks for them so a mounted secret can be updated in place, and then
+from the kubelet credential provider plugins in
+--image-credential-provider-config. Only pulls are served. Give --cache-dir
+to cache blobs on disk, so modules pulled again aren't fetched upstream.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	dockerConfig, _ := oci.DockerConfigPath()
+	flags := cmd.Flags()
+	flags.StringVar(&opts.addr, "addr", "localhost:5000", "address to serve on")
+	flags.StringVar(&opts.dockerConfig, "docker-config", dockerConfig, "docker config holding registry credentials")
+	flags.StringVar(&opts.credentialProviderConfig, "image-credential-provider-config", "", "kubelet credential provider config used for registries the docker config doesn't cover")
+	flags.StringVar(&opts.credentialProviderBinDir, "image-credential-provider-bin-dir", "", "directory holding the credential provider plugins")
+	flags.StringArrayVar(&opts.aliases, "alias", nil, "name=host to pull from a registry whose host has a port as <name>/<repository> (may be repeated)")
+	flags.StringSliceVar(&opts.plainHTTP, "plain-http", nil, "registries to reach over plain HTTP")
+	flags.StringArrayVar(&opts.allow, "allow", nil, "pattern of <registry>/<repository> that may be pulled, as in path.Match (may be repeated; default any)")
+	flags.StringVar(&opts.cacheDir, "cache-dir", "", "directory to cache blobs in (default no cache)")
+	flags.StringVar(&opts.cacheSize, "cache-size", "", "largest size of the blob cache, such as 10Gi, evicting the least recently used blobs (default no limit)")
+	flags.StringVar(&opts.certFile, "tls-cert-file", "", "serve TLS with this certificate")
+	flags.StringVar(&opts.keyFile, "tls-private-key-file", "", "private key of the TLS certificate")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if (opts.certFile == "") != (opts.keyFile == "") {
+		return errors.New("--tls-cert-file and --tls-private-key-file must be given together")
+	}
+	proxyOpts := regproxy.Options{
+		Aliases:   map[string]string{},
+		PlainHTTP: opts.plainHTTP,
+		Allow:     opts.allow,
+		CacheDir:  opts.cacheDir,
+	}
+	for _, a := range opts.aliases {
+		name, host, ok := strings.Cut(a, "=")
+		if !ok {
+			return fmt.Errorf("invalid --alias %q: must be name=host", a)
+		}
+		proxyOpts.Aliases[name] = host
+	}
+	if opts.cacheSize != "" {
+		q, err := resource.ParseQuantity(opts.cacheSize)
+		if err != nil {
+			return fmt.Errorf("invalid --cache-size %q: %w", opts.cacheSize, err)
+		}
+		proxyOpts.CacheSize = q.Value()
+	}
+	creds, err := credentials(opts)
+	if err != nil {
+		return err
+	}
+	proxyOpts.Credentials = creds
+	proxy, err := regproxy.New(proxyOpts)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/v2/", proxy)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	ln, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Serving registry proxy", "addr", ln.Addr().String(), "tls", opts.certFile != "", "cache", opts.cacheDir)
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() {
+		if opts.certFile != "" {
+			errs <- srv.ServeTLS(ln, opts.certFile, opts.keyFile)
+			return
+		}
+		errs <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// credentials looks credentials up in the docker config and then the
+// credential provider plugins, if any are configured
+func credentials(opts *options) (oci.CredentialFunc, error) {
+	var providers oci.CredentialFunc
+	if opts.credentialProviderConfig != "" {
+		ps, err := credentialprovider.Load(opts.credentialProviderConfig, opts.credentialProviderBinDir)
+		if err != nil {
+			return nil, err
+		}
+		providers = ps.CredentialFunc()
+	}
+	// Fail at startup rather than on the first pull if the config is broken
+	if _, err := oci.LoadDockerConfig(opts.dockerConfig); err != nil {
+		return nil, err
+	}
+	return func(registry string) (oci.Credential, error) {
+		cfg, err := oci.LoadDockerConfig(opts.dockerConfig)
+		if err != nil {
+			return oci.Credential{}, err
+		}
+		cred, err := oci.DockerCredentials(cfg)(registry)
+		if err != nil || !cred.IsEmpty() || providers == nil {
+			return cred, err
+		}
+		return providers(registry)
+	}, nil
+}
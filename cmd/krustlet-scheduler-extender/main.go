@@ -0,0 +1,108 @@
+// krustlet-scheduler-extender is a kube-scheduler extender that keeps
+// WebAssembly pods off krustlet nodes whose runtime can't run them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/schedext"
+)
+
+type options struct {
+	kubeconfig string
+	addr       string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-scheduler-extender",
+		Short: "Filter and score krustlet nodes by the capabilities of their wasm runtime",
+		Long: `Filter and score krustlet nodes by the capabilities of their wasm runtime.
+
+Krustlet nodes advertise the WASI snapshots, host functions and memory limit
+of their runtime in node annotations. Pods that state what their modules need
+in pod annotations are only scheduled onto nodes that provide it, preferring
+nodes that allow modules the most memory. Pods without requirements are left
+alone.
+
+The extender serves the filter and prioritize verbs over HTTP; point the
+scheduler at it with an extenders entry in its configuration.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default in-cluster configuration)")
+	flags.StringVar(&opts.addr, "addr", ":8888", "address to serve the extender on")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-scheduler-extender")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	nodes := factory.Core().V1().Nodes()
+	extender := schedext.New(nodes.Lister())
+	synced := nodes.Informer().HasSynced
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	klog.Info("Waiting for node informer to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), synced) {
+		return fmt.Errorf("timed out waiting for the node informer to sync")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/filter", extender)
+	mux.Handle("/prioritize", extender)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	srv := &http.Server{Addr: opts.addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	klog.InfoS("Serving scheduler extender", "addr", opts.addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func newInstallCommand(name *string) *cobra.Command {
+	opts := &runOptions{}
+	var kubeconfig, kubeContext string
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install krustlet as a service",
+		Long: `Install krustlet as a service that starts with Windows.
+
+Unless krustlet already has a kubeconfig or a bootstrap kubeconfig in
+--config-dir, the current context of --kubeconfig is saved in the config
+directory, readable only by administrators, for the service to bootstrap the
+node with when it first starts. It is deleted once the node has its
+certificates. Run from an elevated prompt, then start the service with
+"Start-Service krustlet".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			krustlet, err := filepath.Abs(opts.krustlet)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(krustlet); err != nil {
+				return err
+			}
+			opts.krustlet = krustlet
+			if err := os.MkdirAll(opts.config(), 0o755); err != nil {
+				return err
+			}
+			if (!exists(opts.kubeconfig()) && !exists(opts.bootstrapFile())) || cmd.Flags().Changed("kubeconfig") {
+				if err := saveAdminKubeconfig(kubeconfig, kubeContext, opts.adminKubeconfig()); err != nil {
+					return err
+				}
+			}
+			if err := installService(*name, opts); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Installed service %s for node %s; start it with Start-Service %s\n", *name, opts.nodeName, *name)
+			return nil
+		},
+	}
+	addRunFlags(cmd, opts)
+	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "kubeconfig to bootstrap the node with (default $KUBECONFIG or ~/.kube/config)")
+	cmd.Flags().StringVar(&kubeContext, "context", "", "kubeconfig context to use (default the current context)")
+	return cmd
+}
+
+func newUninstallCommand(name *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the service",
+		Long: `Stop and remove the service. Krustlet's data and config directories are
+left in place, so reinstalling the service keeps the node's identity.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := uninstallService(*name); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed service %s\n", *name)
+			return nil
+		},
+	}
+}
+
+func newRunCommand(name *string) *cobra.Command {
+	opts := &runOptions{}
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run krustlet under the service control manager",
+		Long: `Run krustlet under the service control manager. This is what the installed
+service runs; run from a prompt, it runs in the foreground and logs to the
+console until interrupted.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return runService(*name, opts)
+		},
+	}
+	addRunFlags(cmd, opts)
+	return cmd
+}
+
+// saveAdminKubeconfig writes the context of the kubeconfig, with its
+// credentials inlined, for the service's first start
+func saveAdminKubeconfig(kubeconfig, kubeContext, path string) error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	raw, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	if kubeContext != "" {
+		raw.CurrentContext = kubeContext
+	}
+	if raw.CurrentContext == "" {
+		return errors.New("the kubeconfig has no current context; pass --context to choose one")
+	}
+	if err := clientcmdapi.MinifyConfig(&raw); err != nil {
+		return err
+	}
+	if err := clientcmdapi.FlattenConfig(&raw); err != nil {
+		return err
+	}
+	if err := clientcmd.WriteToFile(raw, path); err != nil {
+		return err
+	}
+	return restrictToAdmins(path)
+}
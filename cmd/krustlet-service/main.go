@@ -0,0 +1,136 @@
+// krustlet-service runs krustlet as a Windows service: it installs the
+// service, supervises krustlet under the service control manager, sends
+// krustlet's output to the event log, and bootstraps the node on its first
+// start.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+// defaultServiceName is the name the service is installed under
+const defaultServiceName = "krustlet"
+
+// runOptions configure how krustlet is run. install records them as the
+// arguments of the service's run command.
+type runOptions struct {
+	krustlet     string
+	dataDir      string
+	configDir    string
+	nodeName     string
+	nodeIP       string
+	port         int
+	nodeLabels   string
+	rustLog      string
+	krustletArgs []string
+	approve      bool
+	tokenTTL     time.Duration
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "krustlet-service",
+		Short: "Run krustlet as a Windows service",
+		Long: `Run krustlet as a Windows service.
+
+install registers the service, which starts with Windows and is restarted by
+the service control manager if krustlet exits. On its first start the service
+bootstraps the node with the kubeconfig given to install: it creates a
+bootstrap token, writes the bootstrap kubeconfig krustlet requests its
+certificates with and, with --approve, approves them. The kubeconfig is
+deleted once the node has its certificates. Krustlet's output goes to the
+Application event log, under the service's name.`,
+		SilenceUsage: true,
+	}
+	cmd.PersistentFlags().StringVar(&name, "name", defaultServiceName, "name of the service")
+	cmd.AddCommand(newInstallCommand(&name), newUninstallCommand(&name), newRunCommand(&name))
+	return cmd
+}
+
+// addRunFlags adds the flags that configure krustlet, shared by install and
+// run
+func addRunFlags(cmd *cobra.Command, opts *runOptions) {
+	flags := cmd.Flags()
+	flags.StringVar(&opts.krustlet, "krustlet", "", "path to the krustlet executable, such as krustlet-wasi.exe")
+	flags.StringVar(&opts.dataDir, "data-dir", defaultDataDir(), "krustlet's data directory")
+	flags.StringVar(&opts.configDir, "config-dir", "", "directory of krustlet's kubeconfig and certificates (default <data-dir>\\config)")
+	flags.StringVar(&opts.nodeName, "node-name", defaultNodeName(), "name of the node")
+	flags.StringVar(&opts.nodeIP, "node-ip", "", "IP address the node registers with (default krustlet's choice)")
+	flags.IntVar(&opts.port, "port", 3000, "port krustlet serves its API on")
+	flags.StringVar(&opts.nodeLabels, "node-labels", "", "labels to register the node with, in key=value form separated by commas")
+	flags.StringVar(&opts.rustLog, "rust-log", "krustlet_wasi=info,kubelet=info,wasi_provider=info", "RUST_LOG for krustlet")
+	flags.StringArrayVar(&opts.krustletArgs, "krustlet-arg", nil, "extra argument for krustlet (may be repeated)")
+	flags.BoolVar(&opts.approve, "approve", false, "approve the node's certificates on its first start, rather than waiting for kubectl certificate approve")
+	flags.DurationVar(&opts.tokenTTL, "token-ttl", time.Hour, "how long the bootstrap token created on the first start is valid for")
+	_ = cmd.MarkFlagRequired("krustlet")
+}
+
+// args returns the options as flags for the run command
+func (o *runOptions) args() []string {
+	args := []string{
+		"--krustlet", o.krustlet,
+		"--data-dir", o.dataDir,
+		"--config-dir", o.config(),
+		"--node-name", o.nodeName,
+		"--port", strconv.Itoa(o.port),
+		"--rust-log", o.rustLog,
+		"--token-ttl", o.tokenTTL.String(),
+	}
+	if o.nodeIP != "" {
+		args = append(args, "--node-ip", o.nodeIP)
+	}
+	if o.nodeLabels != "" {
+		args = append(args, "--node-labels", o.nodeLabels)
+	}
+	for _, a := range o.krustletArgs {
+		args = append(args, "--krustlet-arg", a)
+	}
+	if o.approve {
+		args = append(args, "--approve")
+	}
+	return args
+}
+
+func (o *runOptions) config() string {
+	if o.configDir != "" {
+		return o.configDir
+	}
+	return filepath.Join(o.dataDir, "config")
+}
+
+// Files in the config directory
+func (o *runOptions) kubeconfig() string      { return filepath.Join(o.config(), "kubeconfig") }
+func (o *runOptions) bootstrapFile() string   { return filepath.Join(o.config(), "bootstrap.conf") }
+func (o *runOptions) certFile() string        { return filepath.Join(o.config(), "krustlet.crt") }
+func (o *runOptions) keyFile() string         { return filepath.Join(o.config(), "krustlet.key") }
+func (o *runOptions) adminKubeconfig() string { return filepath.Join(o.config(), "admin.kubeconfig") }
+
+// defaultDataDir is %ProgramData%\krustlet. Krustlet's own default is in the
+// home directory, which for services running as LocalSystem is under
+// System32.
+func defaultDataDir() string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "krustlet")
+}
+
+func defaultNodeName() string {
+	name, _ := nodename.Default()
+	return name
+}
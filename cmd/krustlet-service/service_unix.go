@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errNotWindows is returned by the commands that need the service control
+// manager. Elsewhere, run krustlet under the platform's own service manager,
+// such as systemd.
+var errNotWindows = errors.New("krustlet-service only runs on Windows")
+
+func installService(string, *runOptions) error { return errNotWindows }
+
+func uninstallService(string) error { return errNotWindows }
+
+func runService(string, *runOptions) error { return errNotWindows }
+
+func configureProcess(*exec.Cmd) {}
+
+func interruptProcess(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
+
+func restrictToAdmins(path string) error {
+	return os.Chmod(path, 0o600)
+}
@@ -0,0 +1,218 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/debug"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService registers the service and its event log source
+func installService(name string, opts *runOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service control manager, which needs an elevated prompt: %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed; uninstall it first", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName:      "Krustlet",
+		Description:      fmt.Sprintf("Runs WebAssembly workloads for the Kubernetes node %s", opts.nodeName),
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, append([]string{"--name", name, "run"}, opts.args()...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Restart krustlet when it fails, backing off, and start counting
+	// afresh after a day without failures
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 2 * time.Minute},
+	}, uint32((24 * time.Hour).Seconds()))
+	if err == nil {
+		// Krustlet exiting with an error stops the service cleanly, which
+		// counts as a failure only with this set
+		err = s.SetRecoveryActionsOnNonCrashFailures(true)
+	}
+	if err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("setting the service's recovery actions: %w", err)
+	}
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return fmt.Errorf("registering the event log source: %w", err)
+	}
+	return nil
+}
+
+// uninstallService stops the service, waiting for krustlet to deregister,
+// and removes it
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service control manager, which needs an elevated prompt: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err == nil {
+		deadline := time.Now().Add(stopTimeout + 10*time.Second)
+		for status.State != svc.Stopped && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	// The source may not exist if installation failed part way
+	_ = eventlog.Remove(name)
+	return nil
+}
+
+// runService runs the supervisor under the service control manager, or in
+// the foreground when started from a prompt
+func runService(name string, opts *runOptions) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		log := debug.New(name)
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+		s := newSupervisor(opts, log)
+		// Krustlet shares the console, so it gets the Ctrl+C too
+		s.configure = func(*exec.Cmd) {}
+		s.interrupt = func(*os.Process) error { return nil }
+		return s.run(ctx)
+	}
+
+	log, err := eventlog.Open(name)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+	return svc.Run(name, &handler{supervisor: newSupervisor(opts, log)})
+}
+
+type handler struct {
+	supervisor *supervisor
+}
+
+// Execute implements svc.Handler
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.supervisor.run(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				h.supervisor.log.Error(eventService, err.Error())
+				// A service specific exit code makes the service control
+				// manager treat this as a failure and restart it
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((stopTimeout + 5*time.Second).Milliseconds())}
+				cancel()
+				if err := <-done; err != nil && !errors.Is(err, context.Canceled) {
+					h.supervisor.log.Error(eventService, err.Error())
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// configureProcess gives krustlet a console of its own, so it can be sent a
+// Ctrl+C to stop it
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_CONSOLE,
+		HideWindow:    true,
+	}
+}
+
+var (
+	kernel32              = windows.NewLazySystemDLL("kernel32.dll")
+	attachConsole         = kernel32.NewProc("AttachConsole")
+	freeConsole           = kernel32.NewProc("FreeConsole")
+	setConsoleCtrlHandler = kernel32.NewProc("SetConsoleCtrlHandler")
+)
+
+// interruptProcess sends krustlet a Ctrl+C, which it stops and deregisters
+// its node on. Windows only delivers Ctrl+C to the processes attached to a
+// console, so the service, which has none of its own, attaches to
+// krustlet's, ignoring the event itself while it is attached.
+func interruptProcess(p *os.Process) error {
+	if r, _, err := attachConsole.Call(uintptr(p.Pid)); r == 0 {
+		return fmt.Errorf("attaching to krustlet's console: %w", err)
+	}
+	if r, _, err := setConsoleCtrlHandler.Call(0, 1); r == 0 {
+		_, _, _ = freeConsole.Call()
+		return fmt.Errorf("ignoring Ctrl+C: %w", err)
+	}
+	err := windows.GenerateConsoleCtrlEvent(windows.CTRL_C_EVENT, 0)
+	// The event is delivered asynchronously, so it is ignored until well
+	// after it was sent and the console is left
+	time.Sleep(time.Second)
+	_, _, _ = freeConsole.Call()
+	_, _, _ = setConsoleCtrlHandler.Call(0, 0)
+	return err
+}
+
+// restrictToAdmins limits access to the file to SYSTEM and the
+// Administrators group, as it holds cluster credentials
+func restrictToAdmins(path string) error {
+	sd, err := windows.SecurityDescriptorFromString("D:P(A;;FA;;;SY)(A;;FA;;;BA)")
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	return windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION, nil, nil, dacl, nil)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/krustlet/krustlet/pkg/bootstrap"
+	"github.com/krustlet/krustlet/pkg/csrapprover"
+)
+
+// Event IDs of the service's event log entries
+const (
+	eventService  = 1
+	eventKrustlet = 2
+)
+
+// maxEventLength keeps entries under the event log's limit on the length of
+// a message
+const maxEventLength = 30000
+
+// stopTimeout is how long krustlet gets to deregister its node and exit
+// after being interrupted
+const stopTimeout = 30 * time.Second
+
+// logger writes to the event log, or to the console when the service is run
+// by hand
+type logger interface {
+	Info(eid uint32, msg string) error
+	Warning(eid uint32, msg string) error
+	Error(eid uint32, msg string) error
+}
+
+// supervisor runs krustlet and bootstraps the node the first time it starts
+type supervisor struct {
+	opts *runOptions
+	log  logger
+	// adminClient connects to the cluster with the kubeconfig given to
+	// install; replaced in tests
+	adminClient func(path string) (kubernetes.Interface, *clientcmdapi.Config, error)
+	// configure sets up the krustlet process, and interrupt asks it to stop;
+	// both depend on the platform
+	configure func(*exec.Cmd)
+	interrupt func(*os.Process) error
+}
+
+func newSupervisor(opts *runOptions, log logger) *supervisor {
+	return &supervisor{
+		opts:        opts,
+		log:         log,
+		adminClient: adminClient,
+		configure:   configureProcess,
+		interrupt:   interruptProcess,
+	}
+}
+
+// adminClient loads the kubeconfig install saved
+func adminClient(path string) (kubernetes.Interface, *clientcmdapi.Config, error) {
+	raw, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := clientcmd.NewDefaultClientConfig(*raw, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "krustlet-service"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, raw, nil
+}
+
+// run bootstraps the node if it needs it and runs krustlet until ctx is done
+// or krustlet exits
+func (s *supervisor) run(ctx context.Context) error {
+	for _, dir := range []string{s.opts.dataDir, s.opts.config()} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err := s.bootstrap(ctx, &wg); err != nil {
+		return fmt.Errorf("bootstrapping node %s: %w", s.opts.nodeName, err)
+	}
+	return s.runKrustlet(ctx)
+}
+
+// bootstrap writes a bootstrap kubeconfig for krustlet if it has no
+// kubeconfig of its own yet. With --approve, it approves the node's
+// certificates in the background until krustlet has them.
+func (s *supervisor) bootstrap(ctx context.Context, wg *sync.WaitGroup) error {
+	admin := s.opts.adminKubeconfig()
+	if exists(s.opts.kubeconfig()) {
+		s.removeAdminKubeconfig()
+		return nil
+	}
+	if !exists(admin) {
+		if exists(s.opts.bootstrapFile()) {
+			return nil
+		}
+		return fmt.Errorf("krustlet has no kubeconfig and there is no kubeconfig to bootstrap it with; reinstall the service with --kubeconfig or write %s", s.opts.bootstrapFile())
+	}
+
+	client, raw, err := s.adminClient(admin)
+	if err != nil {
+		return err
+	}
+	if err := bootstrap.CheckCluster(ctx, client); err != nil {
+		return err
+	}
+	token, err := bootstrap.NewToken()
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := bootstrap.Kubeconfig(raw, raw.CurrentContext, token)
+	if err != nil {
+		return err
+	}
+	if err := bootstrap.CreateTokenSecret(ctx, client, token, time.Now().Add(s.opts.tokenTTL)); err != nil {
+		return err
+	}
+	if err := clientcmd.WriteToFile(*kubeconfig, s.opts.bootstrapFile()); err != nil {
+		return fmt.Errorf("writing bootstrap kubeconfig: %w", err)
+	}
+	s.log.Info(eventService, fmt.Sprintf("Created bootstrap token %s, valid for %s, and wrote %s", token.ID, s.opts.tokenTTL, s.opts.bootstrapFile()))
+
+	if !s.opts.approve {
+		s.log.Warning(eventService, fmt.Sprintf("Krustlet's serving certificate needs approving: kubectl certificate approve %s-tls", s.opts.nodeName))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.finishBootstrap(ctx, client)
+	}()
+	return nil
+}
+
+// finishBootstrap approves the node's certificates if asked to, and removes
+// the admin kubeconfig once krustlet has them
+func (s *supervisor) finishBootstrap(ctx context.Context, client kubernetes.Interface) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if s.opts.approve {
+		factory := informers.NewSharedInformerFactory(client, 0)
+		approver := csrapprover.NewController(client, factory, &csrapprover.Policy{
+			ApproveClient:   true,
+			ApproveServing:  true,
+			NodeNamePattern: regexp.MustCompile("^" + regexp.QuoteMeta(s.opts.nodeName) + "$"),
+		})
+		factory.Start(ctx.Done())
+		defer factory.Shutdown()
+		go func() { _ = approver.Run(ctx, 1) }()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Krustlet writes its kubeconfig once its client certificate is
+		// issued, and its serving certificate once that is
+		if exists(s.opts.kubeconfig()) && exists(s.opts.certFile()) {
+			s.log.Info(eventService, fmt.Sprintf("Node %s has its certificates", s.opts.nodeName))
+			s.removeAdminKubeconfig()
+			return
+		}
+	}
+}
+
+// removeAdminKubeconfig deletes the kubeconfig install saved, which is only
+// needed to bootstrap the node
+func (s *supervisor) removeAdminKubeconfig() {
+	err := os.Remove(s.opts.adminKubeconfig())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warning(eventService, fmt.Sprintf("Removing %s: %v", s.opts.adminKubeconfig(), err))
+	}
+}
+
+// krustletArgs are krustlet's arguments
+func (s *supervisor) krustletArgs() []string {
+	o := s.opts
+	args := []string{
+		"--node-name", o.nodeName,
+		"--port", fmt.Sprint(o.port),
+		"--data-dir", o.dataDir,
+		"--bootstrap-file", o.bootstrapFile(),
+		"--cert-file", o.certFile(),
+		"--private-key-file", o.keyFile(),
+	}
+	if o.nodeIP != "" {
+		args = append(args, "--node-ip", o.nodeIP)
+	}
+	return append(args, o.krustletArgs...)
+}
+
+// krustletEnv is krustlet's environment
+func (s *supervisor) krustletEnv() []string {
+	env := append(os.Environ(), "KUBECONFIG="+s.opts.kubeconfig(), "RUST_LOG="+s.opts.rustLog)
+	if s.opts.nodeLabels != "" {
+		env = append(env, "NODE_LABELS="+s.opts.nodeLabels)
+	}
+	return env
+}
+
+// runKrustlet runs krustlet until ctx is done, when it is interrupted so it
+// can deregister its node, or until it exits
+func (s *supervisor) runKrustlet(ctx context.Context) error {
+	cmd := exec.Command(s.opts.krustlet, s.krustletArgs()...)
+	cmd.Env = s.krustletEnv()
+	cmd.Dir = s.opts.dataDir
+	s.configure(cmd)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.log.Info(eventService, fmt.Sprintf("Started %s %s", s.opts.krustlet, strings.Join(cmd.Args[1:], " ")))
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go s.forward(&output, stdout)
+	go s.forward(&output, stderr)
+	exited := make(chan error, 1)
+	go func() {
+		// Wait closes the pipes, so the output has to be read first
+		output.Wait()
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		if err == nil {
+			err = errors.New("exited")
+		}
+		return fmt.Errorf("krustlet stopped: %w", err)
+	case <-ctx.Done():
+	}
+	if err := s.interrupt(cmd.Process); err != nil {
+		s.log.Warning(eventService, fmt.Sprintf("Interrupting krustlet: %v; stopping it without deregistering the node", err))
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		s.log.Warning(eventService, fmt.Sprintf("Krustlet didn't stop within %s; killing it", stopTimeout))
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+	s.log.Info(eventService, "Stopped krustlet")
+	return nil
+}
+
+// forward writes krustlet's output to the log a line at a time, at the level
+// krustlet logged it at
+func (s *supervisor) forward(wg *sync.WaitGroup, r io.Reader) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if len(line) > maxEventLength {
+			line = line[:maxEventLength]
+		}
+		switch level(line) {
+		case "ERROR":
+			s.log.Error(eventKrustlet, line)
+		case "WARN":
+			s.log.Warning(eventKrustlet, line)
+		default:
+			s.log.Info(eventKrustlet, line)
+		}
+	}
+	// Keep the pipe drained if a line was too long to scan
+	_, _ = io.Copy(io.Discard, r)
+}
+
+// logLevel matches the level in krustlet's log lines, such as
+// "[2021-03-04T05:06:07Z WARN  kubelet::node] ..."
+var logLevel = regexp.MustCompile(`^\[\S+\s+(ERROR|WARN|INFO|DEBUG|TRACE)\s`)
+
+func level(line string) string {
+	if m := logLevel.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/krustlet/krustlet/pkg/bootstrap"
+)
+
+type entry struct {
+	level string
+	eid   uint32
+	msg   string
+}
+
+// fakeLog records what is logged
+type fakeLog struct {
+	mu      sync.Mutex
+	entries []entry
+}
+
+func (l *fakeLog) add(level string, eid uint32, msg string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry{level, eid, msg})
+	return nil
+}
+
+func (l *fakeLog) Info(eid uint32, msg string) error    { return l.add("info", eid, msg) }
+func (l *fakeLog) Warning(eid uint32, msg string) error { return l.add("warning", eid, msg) }
+func (l *fakeLog) Error(eid uint32, msg string) error   { return l.add("error", eid, msg) }
+
+func (l *fakeLog) find(eid uint32, substr string) *entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.eid == eid && strings.Contains(e.msg, substr) {
+			return &e
+		}
+	}
+	return nil
+}
+
+func newTestSupervisor(t *testing.T) (*supervisor, *fakeLog) {
+	dir := t.TempDir()
+	opts := &runOptions{dataDir: dir, nodeName: "win-node", port: 3000, tokenTTL: time.Hour, rustLog: "info"}
+	if err := os.MkdirAll(opts.config(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	log := &fakeLog{}
+	s := newSupervisor(opts, log)
+	s.adminClient = func(string) (kubernetes.Interface, *clientcmdapi.Config, error) {
+		t.Fatal("unexpected use of the admin kubeconfig")
+		return nil, nil, nil
+	}
+	return s, log
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func adminFixture() (*fake.Clientset, *clientcmdapi.Config) {
+	client := fake.NewSimpleClientset()
+	client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.31.0"}
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &authorizationv1.SelfSubjectAccessReview{Status: authorizationv1.SubjectAccessReviewStatus{Allowed: true}}, nil
+	})
+	raw := clientcmdapi.NewConfig()
+	raw.Clusters["cluster"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: []byte("ca")}
+	raw.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "admin-token"}
+	raw.Contexts["admin"] = &clientcmdapi.Context{Cluster: "cluster", AuthInfo: "admin"}
+	raw.CurrentContext = "admin"
+	return client, raw
+}
+
+func TestBootstrapFirstStart(t *testing.T) {
+	s, log := newTestSupervisor(t)
+	client, raw := adminFixture()
+	s.adminClient = func(string) (kubernetes.Interface, *clientcmdapi.Config, error) { return client, raw, nil }
+	touch(t, s.opts.adminKubeconfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	if err := s.bootstrap(ctx, &wg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(s.opts.bootstrapFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "https://10.0.0.1:6443") || strings.Contains(string(data), "admin-token") {
+		t.Errorf("unexpected bootstrap kubeconfig:\n%s", data)
+	}
+	secrets, _ := client.CoreV1().Secrets(bootstrap.TokenNamespace).List(ctx, metav1.ListOptions{})
+	if len(secrets.Items) != 1 {
+		t.Errorf("expected a bootstrap token secret, got %d secrets", len(secrets.Items))
+	}
+	if log.find(eventService, "kubectl certificate approve win-node-tls") == nil {
+		t.Error("expected a warning that the serving certificate needs approving")
+	}
+
+	// Krustlet gets its certificates
+	touch(t, s.opts.kubeconfig())
+	touch(t, s.opts.certFile())
+	deadline := time.Now().Add(5 * time.Second)
+	for exists(s.opts.adminKubeconfig()) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if exists(s.opts.adminKubeconfig()) {
+		t.Error("expected the admin kubeconfig to be removed once the node has its certificates")
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestBootstrapDone(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	touch(t, s.opts.kubeconfig())
+	touch(t, s.opts.adminKubeconfig())
+	if err := s.bootstrap(context.Background(), &sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if exists(s.opts.adminKubeconfig()) {
+		t.Error("expected a leftover admin kubeconfig to be removed")
+	}
+}
+
+func TestBootstrapWithoutKubeconfig(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	if err := s.bootstrap(context.Background(), &sync.WaitGroup{}); err == nil {
+		t.Fatal("expected an error with nothing to bootstrap with")
+	}
+	// A bootstrap kubeconfig written by hand is used as it is
+	touch(t, s.opts.bootstrapFile())
+	if err := s.bootstrap(context.Background(), &sync.WaitGroup{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunKrustlet(t *testing.T) {
+	if !exists("/bin/sh") {
+		t.Skip("uses a shell script as krustlet")
+	}
+	s, log := newTestSupervisor(t)
+	script := filepath.Join(t.TempDir(), "krustlet")
+	// Logs like krustlet and exits when interrupted, as it does once its
+	// node is deregistered
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+trap 'echo "[2021-03-04T05:06:07Z INFO  kubelet::kubelet] deregistered"; exit 0' INT
+echo "[2021-03-04T05:06:07Z INFO  kubelet::node] node-name=$2 kubeconfig=$KUBECONFIG"
+echo "[2021-03-04T05:06:07Z WARN  kubelet::node] slow"
+echo "[2021-03-04T05:06:07Z ERROR kubelet::pod] failed" >&2
+while true; do sleep 0.1; done
+`), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.opts.krustlet = script
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.runKrustlet(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for log.find(eventKrustlet, "failed") == nil && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	want := fmt.Sprintf("node-name=win-node kubeconfig=%s", s.opts.kubeconfig())
+	for substr, level := range map[string]string{want: "info", "slow": "warning", "failed": "error", "deregistered": "info"} {
+		e := log.find(eventKrustlet, substr)
+		if e == nil || e.level != level {
+			t.Errorf("expected %q to be logged at %s, got %+v", substr, level, e)
+		}
+	}
+}
+
+func TestRunKrustletExits(t *testing.T) {
+	if !exists("/bin/sh") {
+		t.Skip("uses a shell script as krustlet")
+	}
+	s, _ := newTestSupervisor(t)
+	s.opts.krustlet = "/bin/false"
+	if err := s.runKrustlet(context.Background()); err == nil {
+		t.Fatal("expected krustlet exiting to be an error, so the service is restarted")
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	// The flags install records for the service must parse back into the
+	// same options
+	in := &runOptions{
+		krustlet:     `C:\krustlet\krustlet-wasi.exe`,
+		dataDir:      `C:\ProgramData\krustlet`,
+		configDir:    `C:\ProgramData\krustlet\config`,
+		nodeName:     "win-node",
+		nodeIP:       "10.0.0.2",
+		port:         3001,
+		nodeLabels:   "zone=edge",
+		rustLog:      "debug",
+		krustletArgs: []string{"--insecure-registries", "localhost:5000"},
+		approve:      true,
+		tokenTTL:     2 * time.Hour,
+	}
+	cmd := &cobra.Command{}
+	out := &runOptions{}
+	addRunFlags(cmd, out)
+	if err := cmd.ParseFlags(in.args()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("got %+v, want %+v", out, in)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	for line, want := range map[string]string{
+		"[2021-03-04T05:06:07Z ERROR kubelet::pod] failed": "ERROR",
+		"[2021-03-04T05:06:07Z WARN  kubelet::pod] slow":   "WARN",
+		"[2021-03-04T05:06:07Z INFO  kubelet::pod] ok":     "INFO",
+		"thread 'main' panicked at src/main.rs":            "",
+	} {
+		if got := level(line); got != want {
+			t.Errorf("%q: got %q, want %q", line, got, want)
+		}
+	}
+}
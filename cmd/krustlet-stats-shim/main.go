@@ -0,0 +1,245 @@
+// krustlet-stats-shim serves the kubelet's stats summary, resource metrics,
+// port forwarding, exec and attach APIs for a krustlet node, in front of
+// krustlet's own server.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/execbridge"
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/modulestore"
+	"github.com/krustlet/krustlet/pkg/nodeapi"
+	"github.com/krustlet/krustlet/pkg/nodename"
+	"github.com/krustlet/krustlet/pkg/portforward"
+	"github.com/krustlet/krustlet/pkg/statsshim"
+)
+
+type options struct {
+	kubeconfig   string
+	nodeName     string
+	addr         string
+	upstream     string
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	dataDir      string
+	statsDir     string
+	execModules  map[string]string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-stats-shim",
+		Short: "Serve the kubelet stats and streaming APIs for a krustlet node",
+		Long: `Serve the kubelet stats and streaming APIs for a krustlet node.
+
+The shim serves /stats/summary and /metrics/resource, which metrics-server
+scrapes, and /portForward, /exec and /attach, which kubectl port-forward,
+exec and attach use, on the node's registered address and port, and passes
+every other request to krustlet. Run krustlet with KRUSTLET_ADDRESS=127.0.0.1
+so the shim can listen on the node's address instead.
+
+kubectl exec runs a debug module given with --exec-module, such as a shell
+compiled to WASI, with the container's environment and directories.
+
+Container CPU and memory usage come from the stats files krustlet's wasi
+provider writes for each running module and from /proc.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	dataDir := os.Getenv("KRUSTLET_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".krustlet")
+	}
+	certFile := os.Getenv("KRUSTLET_CERT_FILE")
+	if certFile == "" {
+		certFile = filepath.Join(dataDir, "config", "krustlet.crt")
+	}
+	keyFile := os.Getenv("KRUSTLET_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		keyFile = filepath.Join(dataDir, "config", "krustlet.key")
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig, usually krustlet's own (default in-cluster configuration)")
+	flags.StringVar(&opts.nodeName, "node-name", os.Getenv("KRUSTLET_NODE_NAME"), "name of the krustlet node (default the lower cased hostname)")
+	flags.StringVar(&opts.addr, "addr", "", "address to listen on (default the node's internal IP and kubelet port)")
+	flags.StringVar(&opts.upstream, "upstream", "", "krustlet's server (default https://127.0.0.1:<kubelet port>)")
+	flags.StringVar(&opts.certFile, "cert-file", certFile, "serving certificate, usually krustlet's")
+	flags.StringVar(&opts.keyFile, "key-file", keyFile, "serving certificate's private key")
+	flags.StringVar(&opts.clientCAFile, "client-ca-file", "", "CA that signs client certificates, such as the API server's kubelet client certificate")
+	flags.StringVar(&opts.dataDir, "data-dir", dataDir, "krustlet's data directory")
+	flags.StringVar(&opts.statsDir, "stats-dir", "", "where the wasi provider writes stats files (default <data-dir>/wasi-logs)")
+	flags.StringToStringVar(&opts.execModules, "exec-module", nil, "debug module kubectl exec runs for a command, as NAME=PATH (repeatable)")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	if opts.nodeName == "" {
+		name, err := nodename.Default()
+		if err != nil {
+			return err
+		}
+		opts.nodeName = name
+	}
+	if opts.statsDir == "" {
+		opts.statsDir = filepath.Join(opts.dataDir, "wasi-logs")
+	}
+	var clientCA *x509.CertPool
+	if opts.clientCAFile != "" {
+		data, err := os.ReadFile(opts.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading client CA: %w", err)
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(data) {
+			return errors.New("client CA contains no PEM certificates")
+		}
+	}
+	cert := &certificate{certFile: opts.certFile, keyFile: opts.keyFile}
+	if _, err := cert.get(nil); err != nil {
+		return err
+	}
+
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-stats-shim")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if opts.addr == "" || opts.upstream == "" {
+		node, err := client.CoreV1().Nodes().Get(ctx, opts.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("getting node: %w", err)
+		}
+		addr, ok := nodeapi.Address(node)
+		if !ok {
+			return fmt.Errorf("node %s has no address and kubelet port", node.Name)
+		}
+		if opts.addr == "" {
+			opts.addr = addr
+		}
+		if opts.upstream == "" {
+			_, port, _ := net.SplitHostPort(addr)
+			opts.upstream = "https://" + net.JoinHostPort("127.0.0.1", port)
+		}
+	}
+	upstream, err := url.Parse(opts.upstream)
+	if err != nil {
+		return fmt.Errorf("invalid --upstream: %w", err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", opts.nodeName).String()
+		}))
+	provider := statsshim.New(factory, statsshim.Options{
+		NodeName:  opts.nodeName,
+		StatsDir:  opts.statsDir,
+		DataDir:   opts.dataDir,
+		ModuleDir: modulestore.Dir(opts.dataDir),
+	})
+	forwarder := portforward.New(factory)
+	bridge := execbridge.New(factory, execbridge.Options{StatsDir: opts.statsDir, Modules: opts.execModules})
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	srv := &http.Server{
+		Addr: opts.addr,
+		Handler: statsshim.NewHandler(provider,
+			statsshim.Streaming{PortForward: forwarder, Exec: bridge, Attach: bridge},
+			statsshim.NewAuth(client, opts.nodeName, clientCA), upstream),
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: cert.get,
+			// Client certificates are checked by the stats endpoints only;
+			// krustlet's own endpoints don't ask for one
+			ClientAuth: tls.RequestClientCert,
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	klog.InfoS("Serving node API", "addr", opts.addr, "upstream", upstream.String(), "node", opts.nodeName)
+	if err := srv.ListenAndServeTLS("", ""); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// certificate loads the serving certificate again whenever its file changes,
+// so the shim picks up certificates krustlet renews
+type certificate struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cert    *tls.Certificate
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		if c.cert != nil {
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("loading serving certificate: %w", err)
+	}
+	if c.cert == nil || !info.ModTime().Equal(c.modTime) {
+		cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			if c.cert != nil {
+				klog.ErrorS(err, "Reloading serving certificate")
+				return c.cert, nil
+			}
+			return nil, fmt.Errorf("loading serving certificate: %w", err)
+		}
+		c.cert, c.modTime = &cert, info.ModTime()
+	}
+	return c.cert, nil
+}
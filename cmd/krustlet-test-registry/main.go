@@ -0,0 +1,102 @@
+// krustlet-test-registry serves an in-memory OCI registry seeded with wasm
+// fixtures, for local demos and tests that shouldn't depend on a public
+// registry.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+type options struct {
+	addr          string
+	publicKeyFile string
+	username      string
+	password      string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-test-registry",
+		Short: "Serve an in-memory OCI registry seeded with wasm fixtures",
+		Long: `Serve an in-memory OCI registry seeded with wasm fixtures.
+
+The registry serves plain HTTP, so krustlet must be started with
+--insecure-registries naming it. Anything pushed to it is lost when it stops.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.addr, "addr", "localhost:5000", "address to serve on")
+	flags.StringVar(&opts.publicKeyFile, "public-key-file", "", "write the public key the signed fixture is signed with to this file")
+	flags.StringVar(&opts.username, "username", "", "require basic auth with this username")
+	flags.StringVar(&opts.password, "password", "", "password to require with --username")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	reg := ocitest.New()
+	reg.Username, reg.Password = opts.username, opts.password
+	reg.Seed()
+	if opts.publicKeyFile != "" {
+		if err := os.WriteFile(opts.publicKeyFile, reg.PublicKeyPEM(), 0644); err != nil {
+			return err
+		}
+	}
+
+	ln, err := net.Listen("tcp", opts.addr)
+	if err != nil {
+		return err
+	}
+	host := ln.Addr().String()
+	fmt.Printf("Serving on http://%s with fixtures:\n", host)
+	for _, ref := range []string{ocitest.HelloRef, ocitest.MultiArchRef, ocitest.ContainerRef, ocitest.UnsignedRef, ocitest.SignedRef} {
+		fmt.Printf("  %s/%s\n", host, ref)
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	srv := &http.Server{Handler: reg, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
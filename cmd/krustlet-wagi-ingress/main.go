@@ -0,0 +1,126 @@
+// krustlet-wagi-ingress routes HTTP traffic to WAGI pods on krustlet nodes
+// according to Ingresses of its class.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/informers"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/wagiingress"
+)
+
+type options struct {
+	kubeconfig          string
+	addr                string
+	healthAddr          string
+	ingressClass        string
+	arch                string
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "krustlet-wagi-ingress",
+		Short: "Route HTTP traffic to WAGI pods on krustlet nodes",
+		Long: `Route HTTP traffic to WAGI pods on krustlet nodes.
+
+Ingresses of the gateway's class are read for routes. Each backend Service's
+selector is resolved to the running pods on krustlet nodes, which are proxied
+to at their node's address since krustlet doesn't give pods IPs of their own.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context(), opts)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to a kubeconfig (default in-cluster configuration)")
+	flags.StringVar(&opts.addr, "addr", ":8080", "address to serve proxied traffic on")
+	flags.StringVar(&opts.healthAddr, "health-addr", ":8081", "address to serve the gateway's own /healthz on")
+	flags.StringVar(&opts.ingressClass, "ingress-class", "krustlet-wagi", "class of the Ingresses to serve")
+	flags.StringVar(&opts.arch, "arch", "wasm32-wasi", "architecture label of the krustlet nodes to route to")
+	flags.DurationVar(&opts.healthCheckInterval, "health-check-interval", 10*time.Second, "how often to check pods of routes with a health check path")
+	flags.DurationVar(&opts.healthCheckTimeout, "health-check-timeout", 2*time.Second, "how long a pod may take to answer a health check")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+	return cmd
+}
+
+func run(ctx context.Context, opts *options) error {
+	client, err := kubeclient.NewClientset(opts.kubeconfig, "", "krustlet-wagi-ingress")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	gateway := wagiingress.New(factory, wagiingress.Options{
+		IngressClass:       opts.ingressClass,
+		Arch:               opts.arch,
+		HealthCheckTimeout: opts.healthCheckTimeout,
+	})
+	factory.Start(ctx.Done())
+	defer factory.Shutdown()
+
+	healthMux := http.NewServeMux()
+	healthMux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	ctx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, 3)
+	go func() { errs <- gateway.Run(ctx, opts.healthCheckInterval) }()
+	go func() { errs <- serve(ctx, opts.addr, gateway) }()
+	go func() { errs <- serve(ctx, opts.healthAddr, healthMux) }()
+
+	// The first to stop stops the others
+	err = <-errs
+	cancel()
+	for i := 0; i < 2; i++ {
+		if e := <-errs; err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// serve runs an HTTP server until the context is cancelled
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	klog.InfoS("Serving", "addr", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
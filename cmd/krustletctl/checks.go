@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	archLabel     = "kubernetes.io/arch"
+	roleLabel     = "node-role.kubernetes.io/"
+	typeLabel     = "type"
+	krustletType  = "krustlet"
+	servingSigner = "kubernetes.io/kubelet-serving"
+	clientSigner  = "kubernetes.io/kube-apiserver-client-kubelet"
+
+	// certExpiryWarning is how close to expiry a certificate is reported,
+	// matching krustlet-certrotate's warning
+	certExpiryWarning = 14 * 24 * time.Hour
+	// leaseStale is how long since a lease renewal a node is considered to
+	// have stopped heartbeating; krustlet renews every 10 seconds
+	leaseStale = time.Minute
+)
+
+type status int
+
+const (
+	statusPass status = iota
+	statusWarn
+	statusFail
+)
+
+func (s status) String() string {
+	switch s {
+	case statusPass:
+		return "PASS"
+	case statusWarn:
+		return "WARN"
+	}
+	return "FAIL"
+}
+
+// result is the outcome of one check, with what to do about it if it didn't
+// pass
+type result struct {
+	status  status
+	check   string
+	message string
+	fix     string
+}
+
+type report []result
+
+func (r *report) pass(check, format string, args ...interface{}) {
+	*r = append(*r, result{status: statusPass, check: check, message: fmt.Sprintf(format, args...)})
+}
+
+func (r *report) warn(check, fix, format string, args ...interface{}) {
+	*r = append(*r, result{status: statusWarn, check: check, message: fmt.Sprintf(format, args...), fix: fix})
+}
+
+func (r *report) fail(check, fix, format string, args ...interface{}) {
+	*r = append(*r, result{status: statusFail, check: check, message: fmt.Sprintf(format, args...), fix: fix})
+}
+
+// failed reports whether any check failed
+func (r report) failed() bool {
+	for _, res := range r {
+		if res.status == statusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// print writes the report with each fix indented under its check
+func (r report) print(w io.Writer) {
+	counts := map[status]int{}
+	for _, res := range r {
+		counts[res.status]++
+		fmt.Fprintf(w, "[%s] %s: %s\n", res.status, res.check, res.message)
+		if res.fix != "" {
+			for _, line := range strings.Split(res.fix, "\n") {
+				fmt.Fprintf(w, "       %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed\n", counts[statusPass], counts[statusWarn], counts[statusFail])
+}
+
+// checkConditions checks that the node is ready and still heartbeating.
+// lease may be nil.
+func checkConditions(r *report, node *corev1.Node, lease *coordinationv1.Lease, now time.Time) {
+	var ready *corev1.NodeCondition
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == corev1.NodeReady {
+			ready = &node.Status.Conditions[i]
+		}
+	}
+	switch {
+	case ready == nil:
+		r.fail("ready", "Check krustlet's log on the node; it sets the condition when it registers.",
+			"node %s has no Ready condition", node.Name)
+	case ready.Status != corev1.ConditionTrue:
+		r.fail("ready", "Check that krustlet is running on the node and can reach the API server.",
+			"node %s is not ready (%s: %s)", node.Name, ready.Reason, ready.Message)
+	default:
+		r.pass("ready", "node %s is ready", node.Name)
+	}
+
+	if lease == nil || lease.Spec.RenewTime == nil {
+		r.warn("heartbeat", "Krustlet renews a Lease in kube-node-lease named after the node; check that its credentials allow it.",
+			"node %s has no lease", node.Name)
+		return
+	}
+	if age := now.Sub(lease.Spec.RenewTime.Time); age > leaseStale {
+		r.fail("heartbeat", "Krustlet has stopped or lost contact with the API server. Check that it is running and its log on the node.",
+			"node %s last renewed its lease %s ago", node.Name, age.Round(time.Second))
+		return
+	}
+	r.pass("heartbeat", "lease renewed %s ago", now.Sub(lease.Spec.RenewTime.Time).Round(time.Second))
+}
+
+// checkLabels checks the labels krustlet sets and the role label it can't
+func checkLabels(r *report, node *corev1.Node, arch string) {
+	if got := node.Labels[archLabel]; got != arch {
+		r.fail("labels", fmt.Sprintf("Pods select krustlet nodes by %s=%s. If this node runs another provider, pass its architecture with --arch.", archLabel, arch),
+			"%s is %q, expected %q", archLabel, got, arch)
+	} else if node.Labels[typeLabel] != krustletType {
+		r.warn("labels", "Krustlet always sets this label; the node may not be running krustlet.",
+			"%s is %q, expected %q", typeLabel, node.Labels[typeLabel], krustletType)
+	} else {
+		r.pass("labels", "%s=%s, %s=%s", archLabel, arch, typeLabel, krustletType)
+	}
+
+	var roles []string
+	for k := range node.Labels {
+		if strings.HasPrefix(k, roleLabel) {
+			roles = append(roles, strings.TrimPrefix(k, roleLabel))
+		}
+	}
+	if len(roles) == 0 {
+		r.warn("role", fmt.Sprintf("This is expected: the NodeRestriction admission plugin stops any kubelet, krustlet included, from\n"+
+			"setting its own role, so --node-labels can't either. To show a role, label the node yourself:\n"+
+			"  kubectl label node %s %sagent=\n"+
+			"or deploy krustlet-node-labeler to label every krustlet node.", node.Name, roleLabel),
+			"node %s has no role, so kubectl get nodes shows <none> under ROLES", node.Name)
+		return
+	}
+	sort.Strings(roles)
+	r.pass("role", "%s", strings.Join(roles, ","))
+}
+
+// checkTaints checks that the node carries the taints that keep ordinary
+// container pods away from it
+func checkTaints(r *report, node *corev1.Node, arch string) {
+	var missing []string
+	for _, effect := range []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectNoExecute} {
+		found := false
+		for _, t := range node.Spec.Taints {
+			if t.Key == archLabel && t.Value == arch && t.Effect == effect {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("%s=%s:%s", archLabel, arch, effect))
+		}
+	}
+	if len(missing) > 0 {
+		r.warn("taints", fmt.Sprintf("Without them container pods, including DaemonSets, are scheduled onto the node and fail. Krustlet only\n"+
+			"sets taints when it first registers; restore them with:\n  kubectl taint node %s %s", node.Name, strings.Join(missing, " ")),
+			"node %s is missing %s", node.Name, strings.Join(missing, ", "))
+	} else {
+		r.pass("taints", "%s=%s:NoSchedule and NoExecute", archLabel, arch)
+	}
+	if node.Spec.Unschedulable {
+		r.warn("taints", fmt.Sprintf("Run kubectl uncordon %s when it should take pods again.", node.Name),
+			"node %s is cordoned", node.Name)
+	}
+}
+
+// checkNodeIP returns the node's internal IP, checking it is one other
+// machines can use
+func checkNodeIP(r *report, node *corev1.Node) (net.IP, bool) {
+	const fix = "Start krustlet with --node-ip (or KRUSTLET_NODE_IP) set to an address of the node the control plane\n" +
+		"can reach. Without it krustlet resolves its hostname, which often gives a loopback or wrong address."
+	var ip net.IP
+	for _, a := range node.Status.Addresses {
+		if a.Type == corev1.NodeInternalIP {
+			ip = net.ParseIP(a.Address)
+		}
+	}
+	switch {
+	case ip == nil:
+		r.fail("node-ip", fix, "node %s has no InternalIP address", node.Name)
+		return nil, false
+	case ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast():
+		r.fail("node-ip", fix, "node %s registered %s, which no other machine can reach", node.Name, ip)
+		return ip, false
+	}
+	r.pass("node-ip", "%s", ip)
+	return ip, true
+}
+
+// checkServingCertificate checks the node API's certificate is current and
+// valid for the node's address and name
+func checkServingCertificate(r *report, cert *x509.Certificate, node *corev1.Node, ip net.IP, now time.Time) {
+	const renewFix = "Krustlet doesn't renew its serving certificate itself. Run krustlet-certrotate --force on the node,\n" +
+		"then approve the new CSR; or delete krustlet.crt and krustlet.key from its data directory\n" +
+		"(~/.krustlet/config by default), restart krustlet and approve the new CSR."
+	switch {
+	case now.After(cert.NotAfter):
+		r.fail("serving-certificate", renewFix, "expired %s", cert.NotAfter.UTC().Format(time.RFC3339))
+		return
+	case cert.NotAfter.Sub(now) < certExpiryWarning:
+		r.warn("serving-certificate", renewFix, "expires %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	case now.Before(cert.NotBefore):
+		r.fail("serving-certificate", "Check the clocks of the node and the control plane.",
+			"not valid until %s", cert.NotBefore.UTC().Format(time.RFC3339))
+		return
+	default:
+		r.pass("serving-certificate", "valid until %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if ip != nil {
+		if err := cert.VerifyHostname(ip.String()); err != nil {
+			r.fail("serving-certificate", "The certificate was issued for a different address, usually because --node-ip changed after it\n"+
+				"was issued. Run krustlet-certrotate --force --node-ip "+ip.String()+" on the node, or delete krustlet.crt\n"+
+				"and krustlet.key, restart krustlet and approve the new CSR.",
+				"not valid for the node's address %s (valid for %s)", ip, certNames(cert))
+		}
+	}
+	if cert.VerifyHostname(node.Name) != nil {
+		r.warn("serving-certificate", "Clients that connect by node name will reject it. "+renewFix,
+			"not valid for the node name %s (valid for %s)", node.Name, certNames(cert))
+	}
+}
+
+func certNames(cert *x509.Certificate) string {
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		return "no names"
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkCSRs reports certificate requests from the node that are waiting for
+// approval or were denied. Krustlet waits for them before it registers or
+// serves its API.
+func checkCSRs(r *report, csrs []certificatesv1.CertificateSigningRequest, nodeName string) {
+	user := "system:node:" + nodeName
+	found := false
+	for _, csr := range csrs {
+		// Krustlet names its client request after the node and its serving
+		// request <node>-tls, and sends the latter as the node
+		if csr.Name != nodeName && csr.Name != nodeName+"-tls" && csr.Spec.Username != user {
+			continue
+		}
+		if csr.Spec.SignerName != servingSigner && csr.Spec.SignerName != clientSigner {
+			continue
+		}
+		found = true
+		kind := "serving"
+		if csr.Spec.SignerName == clientSigner {
+			kind = "client"
+		}
+		approved, denied := csrState(&csr)
+		switch {
+		case denied:
+			r.fail("csr", fmt.Sprintf("Delete it (kubectl delete csr %s) and the matching certificate from krustlet's data\n"+
+				"directory, then restart krustlet to request a new one.", csr.Name),
+				"%s certificate request %s was denied or failed", kind, csr.Name)
+		case approved && len(csr.Status.Certificate) == 0:
+			r.fail("csr", "The cluster has no signer for it. Check that kube-controller-manager runs with a cluster signing\n"+
+				"certificate (--cluster-signing-cert-file and --cluster-signing-key-file).",
+				"%s certificate request %s is approved but no certificate was issued", kind, csr.Name)
+		case approved:
+			r.pass("csr", "%s certificate request %s was issued", kind, csr.Name)
+		default:
+			r.fail("csr", fmt.Sprintf("Krustlet waits for this before it can start. Approve it with:\n  kubectl certificate approve %s\n"+
+				"or deploy krustlet-csr-approver to approve requests from krustlet nodes automatically.", csr.Name),
+				"%s certificate request %s is waiting for approval", kind, csr.Name)
+		}
+	}
+	if !found {
+		r.pass("csr", "no certificate requests from %s are outstanding", nodeName)
+	}
+}
+
+func csrState(csr *certificatesv1.CertificateSigningRequest) (approved, denied bool) {
+	for _, c := range csr.Status.Conditions {
+		switch c.Type {
+		case certificatesv1.CertificateApproved:
+			approved = true
+		case certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+			denied = true
+		}
+	}
+	return approved, denied
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+	"github.com/krustlet/krustlet/pkg/nodeapi"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+const (
+	leaseNamespace = "kube-node-lease"
+	tokenNamespace = "kube-system"
+)
+
+type diagnoseOptions struct {
+	*globalOptions
+	arch    string
+	local   bool
+	timeout time.Duration
+	paths   localPaths
+	// explicit records which path flags were set, so the config file
+	// doesn't override them
+	explicit map[string]bool
+}
+
+func newDiagnoseCommand(global *globalOptions) *cobra.Command {
+	opts := &diagnoseOptions{globalOptions: global}
+	cmd := &cobra.Command{
+		Use:   "diagnose [NODE]",
+		Short: "Check a krustlet node's setup and cluster registration",
+		Long: `Check a krustlet node's setup and cluster registration.
+
+The node's registration, conditions, labels, taints and address are checked,
+along with its serving certificate and whether its API can be reached directly
+and through the API server. Each problem is printed with how to fix it.
+
+Run on the krustlet host with --local to also check krustlet's config file,
+certificates and bootstrap kubeconfig. NODE defaults to the host's name when
+--local is set.`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.explicit = map[string]bool{}
+			for _, name := range []string{"data-dir", "cert-file", "key-file", "bootstrap-file"} {
+				opts.explicit[name] = cmd.Flags().Changed(name)
+			}
+			var node string
+			if len(args) > 0 {
+				node = args[0]
+			}
+			return runDiagnose(cmd.Context(), opts, node, cmd.OutOrStdout())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.arch, "arch", "wasm32-wasi", "architecture label the node should have")
+	flags.DurationVar(&opts.timeout, "timeout", 5*time.Second, "how long each connection to the node may take")
+	flags.BoolVar(&opts.local, "local", false, "also check the krustlet files on this machine")
+	flags.StringVar(&opts.paths.configFile, "config-file", "", "krustlet config file (default ~/.krustlet/config/config.json)")
+	flags.StringVar(&opts.paths.dataDir, "data-dir", "", "krustlet data directory (default $KRUSTLET_DATA_DIR or ~/.krustlet)")
+	flags.StringVar(&opts.paths.certFile, "cert-file", "", "krustlet serving certificate (default $KRUSTLET_CERT_FILE or <data-dir>/config/krustlet.crt)")
+	flags.StringVar(&opts.paths.keyFile, "key-file", "", "krustlet serving key (default $KRUSTLET_PRIVATE_KEY_FILE or <data-dir>/config/krustlet.key)")
+	flags.StringVar(&opts.paths.bootstrapFile, "bootstrap-file", "", "krustlet bootstrap kubeconfig (default $KRUSTLET_BOOTSTRAP_FILE or "+defaultBootstrapFile+")")
+	return cmd
+}
+
+func runDiagnose(ctx context.Context, opts *diagnoseOptions, nodeName string, out io.Writer) error {
+	r := &report{}
+	var localCert *x509.Certificate
+	var tokenID string
+	if opts.local {
+		paths := defaultLocalPaths(opts.paths)
+		checkConfigFile(r, &paths, opts.explicit)
+		localCert = checkLocalCertificate(r, paths, time.Now())
+		tokenID = checkBootstrap(r, paths)
+		if nodeName == "" {
+			nodeName = paths.nodeName
+		}
+		if nodeName == "" {
+			name, err := nodename.Default()
+			if err != nil {
+				return err
+			}
+			nodeName = name
+		}
+	}
+	if nodeName == "" {
+		return errors.New("a node name is required unless --local is set")
+	}
+
+	restConfig, err := kubeclient.RESTConfig(opts.kubeconfig, opts.context)
+	if err != nil {
+		return err
+	}
+	restConfig = rest.AddUserAgent(restConfig, "krustletctl")
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Discovery().ServerVersion(); err != nil {
+		r.fail("api-server", "Check the kubeconfig passed with --kubeconfig and --context.", "can't reach %s: %v", restConfig.Host, err)
+		r.print(out)
+		return errors.New("the API server is unreachable")
+	}
+
+	if tokenID != "" {
+		secret, err := client.CoreV1().Secrets(tokenNamespace).Get(ctx, "bootstrap-token-"+tokenID, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			checkTokenSecret(r, tokenID, nil, time.Now())
+		case err != nil:
+			r.warn("bootstrap-token", "Run with a kubeconfig allowed to read secrets in kube-system to check it.",
+				"can't check token %s: %v", tokenID, err)
+		default:
+			checkTokenSecret(r, tokenID, secret.Data, time.Now())
+		}
+	}
+
+	diagnoseCluster(ctx, r, client, restConfig, nodeName, localCert, opts)
+	r.print(out)
+	if r.failed() {
+		return errors.New("some checks failed")
+	}
+	return nil
+}
+
+// diagnoseCluster checks the node's registration and its API
+func diagnoseCluster(ctx context.Context, r *report, client kubernetes.Interface, restConfig *rest.Config, nodeName string, localCert *x509.Certificate, opts *diagnoseOptions) {
+	csrs, err := client.CertificatesV1().CertificateSigningRequests().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		r.warn("csr", "Run with a kubeconfig allowed to list certificatesigningrequests to check them.", "can't list CSRs: %v", err)
+	} else {
+		checkCSRs(r, csrs.Items, nodeName)
+	}
+
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		r.fail("registered", "Krustlet registers the node once its certificates are approved. Check the CSR results above and\n"+
+			"krustlet's log, and that the name matches the node's hostname or --node-name.",
+			"node %s isn't registered", nodeName)
+		return
+	}
+	if err != nil {
+		r.fail("registered", "Run with a kubeconfig allowed to get nodes.", "getting node %s: %v", nodeName, err)
+		return
+	}
+	r.pass("registered", "node %s is registered (%s)", nodeName, node.Status.NodeInfo.KubeletVersion)
+
+	lease, err := client.CoordinationV1().Leases(leaseNamespace).Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		lease = nil
+	}
+	checkConditions(r, node, lease, time.Now())
+	checkLabels(r, node, opts.arch)
+	checkTaints(r, node, opts.arch)
+	ip, _ := checkNodeIP(r, node)
+
+	addr, ok := nodeapi.Address(node)
+	if !ok {
+		r.fail("node-api", "Krustlet registers its port when it starts; check its log.", "node %s has no kubelet port", nodeName)
+		return
+	}
+	cert, err := servingCertificate(ctx, addr, opts.timeout)
+	if err != nil {
+		// This machine may just be outside the node's network; what matters
+		// is whether the API server can reach it, checked below
+		r.warn("node-api", fmt.Sprintf("If this machine should be able to reach the node, check that krustlet is running and that port %d\n"+
+			"is open in the node's firewall.", node.Status.DaemonEndpoints.KubeletEndpoint.Port),
+			"can't connect to %s from here: %v", addr, err)
+	} else {
+		r.pass("node-api", "%s is serving", addr)
+		checkServingCertificate(r, cert, node, ip, time.Now())
+		if localCert != nil && !localCert.Equal(cert) {
+			r.warn("serving-certificate", "Restart krustlet to serve the certificate on disk.",
+				"the node is serving a different certificate from the one on disk")
+		}
+	}
+
+	proxied, err := nodeapi.NewProxied(restConfig, nodeName)
+	if err != nil {
+		r.fail("api-server-proxy", "", "%v", err)
+		return
+	}
+	proxyCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	err = proxied.Healthz(proxyCtx)
+	var rerr *nodeapi.ResponseError
+	switch {
+	case err == nil:
+		r.pass("api-server-proxy", "the API server can reach the node, so kubectl logs works")
+	case errors.As(err, &rerr) && (rerr.StatusCode == 401 || rerr.StatusCode == 403):
+		r.warn("api-server-proxy", "Run with a kubeconfig allowed to get nodes/proxy to check it.", "can't check: %v", err)
+	default:
+		r.fail("api-server-proxy", fmt.Sprintf("kubectl logs fails until the API server can connect to %s. Make the node's address and port\n"+
+			"reachable from the control plane, or set --node-ip to one that is.", addr),
+			"the API server can't reach the node: %v", err)
+	}
+}
+
+// servingCertificate connects to the node API and returns the certificate it
+// serves. It isn't verified: the checks report on it instead.
+func servingCertificate(ctx context.Context, addr string, timeout time.Duration) (*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: timeout},
+		Config:    &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dialer.DialContext(dialCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate was served")
+	}
+	return certs[0], nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+var now = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// statusOf returns the worst status reported for a check, failing the test
+// if it wasn't reported
+func statusOf(t *testing.T, r report, check string) (status, string) {
+	t.Helper()
+	worst, found := statusPass, false
+	var fixes []string
+	for _, res := range r {
+		if res.check == check {
+			found = true
+			if res.status > worst {
+				worst = res.status
+			}
+			fixes = append(fixes, res.message+"\n"+res.fix)
+		}
+	}
+	if !found {
+		t.Fatalf("check %s wasn't reported", check)
+	}
+	return worst, strings.Join(fixes, "\n")
+}
+
+func krustletNode() *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "krustlet-wasi",
+			Labels: map[string]string{archLabel: "wasm32-wasi", typeLabel: krustletType},
+		},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: archLabel, Value: "wasm32-wasi", Effect: corev1.TaintEffectNoSchedule},
+			{Key: archLabel, Value: "wasm32-wasi", Effect: corev1.TaintEffectNoExecute},
+		}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			Addresses:  []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.4"}},
+		},
+	}
+}
+
+func TestCheckLabels(t *testing.T) {
+	node := krustletNode()
+	r := report{}
+	checkLabels(&r, node, "wasm32-wasi")
+	if s, _ := statusOf(t, r, "labels"); s != statusPass {
+		t.Errorf("expected krustlet's labels to pass, got %s", s)
+	}
+	s, fix := statusOf(t, r, "role")
+	if s != statusWarn || !strings.Contains(fix, "kubectl label node krustlet-wasi node-role.kubernetes.io/agent=") {
+		t.Errorf("expected a missing role to be explained, got %s: %s", s, fix)
+	}
+
+	node.Labels[roleLabel+"agent"] = ""
+	r = report{}
+	checkLabels(&r, node, "wasm32-wasi")
+	if s, _ := statusOf(t, r, "role"); s != statusPass {
+		t.Errorf("expected a role to pass, got %s", s)
+	}
+
+	r = report{}
+	checkLabels(&r, node, "wasm32-wagi")
+	if s, _ := statusOf(t, r, "labels"); s != statusFail {
+		t.Errorf("expected the wrong architecture to fail, got %s", s)
+	}
+}
+
+func TestCheckTaints(t *testing.T) {
+	node := krustletNode()
+	node.Spec.Taints = node.Spec.Taints[:1]
+	node.Spec.Unschedulable = true
+	r := report{}
+	checkTaints(&r, node, "wasm32-wasi")
+	s, fix := statusOf(t, r, "taints")
+	if s != statusWarn || !strings.Contains(fix, "kubectl taint node krustlet-wasi kubernetes.io/arch=wasm32-wasi:NoExecute") || !strings.Contains(fix, "uncordon") {
+		t.Errorf("expected the missing taint and cordon to be reported, got %s: %s", s, fix)
+	}
+}
+
+func TestCheckNodeIP(t *testing.T) {
+	for addr, want := range map[string]status{"10.0.0.4": statusPass, "127.0.1.1": statusFail, "": statusFail} {
+		node := krustletNode()
+		node.Status.Addresses = nil
+		if addr != "" {
+			node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: addr}}
+		}
+		r := report{}
+		checkNodeIP(&r, node)
+		if s, fix := statusOf(t, r, "node-ip"); s != want || (s != statusPass && !strings.Contains(fix, "--node-ip")) {
+			t.Errorf("%q: got %s: %s", addr, s, fix)
+		}
+	}
+}
+
+func TestCheckConditions(t *testing.T) {
+	node := krustletNode()
+	renewed := metav1.NewMicroTime(now.Add(-5 * time.Minute))
+	lease := &coordinationv1.Lease{Spec: coordinationv1.LeaseSpec{RenewTime: &renewed}}
+	r := report{}
+	checkConditions(&r, node, lease, now)
+	if s, _ := statusOf(t, r, "ready"); s != statusPass {
+		t.Errorf("expected ready to pass, got %s", s)
+	}
+	if s, _ := statusOf(t, r, "heartbeat"); s != statusFail {
+		t.Errorf("expected a stale lease to fail, got %s", s)
+	}
+}
+
+func testCertificate(t *testing.T, notAfter time.Time, ips ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "krustlet-wasi"},
+		DNSNames:     []string{"krustlet-wasi"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	for _, ip := range ips {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(ip))
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCheckServingCertificate(t *testing.T) {
+	node := krustletNode()
+	ip := net.ParseIP("10.0.0.4")
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want status
+		msg  string
+	}{
+		{"valid", testCertificate(t, now.AddDate(1, 0, 0), "10.0.0.4"), statusPass, ""},
+		{"expiring", testCertificate(t, now.AddDate(0, 0, 3), "10.0.0.4"), statusWarn, "expires"},
+		{"expired", testCertificate(t, now.AddDate(0, 0, -1), "10.0.0.4"), statusFail, "expired"},
+		{"wrong address", testCertificate(t, now.AddDate(1, 0, 0), "192.168.1.20"), statusFail, "valid for krustlet-wasi, 192.168.1.20"},
+	}
+	for _, tt := range tests {
+		r := report{}
+		checkServingCertificate(&r, tt.cert, node, ip, now)
+		if s, msg := statusOf(t, r, "serving-certificate"); s != tt.want || !strings.Contains(msg, tt.msg) {
+			t.Errorf("%s: got %s: %s", tt.name, s, msg)
+		}
+	}
+}
+
+func TestCheckCSRs(t *testing.T) {
+	csr := func(name, signer string, conditions ...certificatesv1.RequestConditionType) certificatesv1.CertificateSigningRequest {
+		c := certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       certificatesv1.CertificateSigningRequestSpec{SignerName: signer},
+		}
+		for _, cond := range conditions {
+			c.Status.Conditions = append(c.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{Type: cond})
+		}
+		return c
+	}
+	tests := []struct {
+		name string
+		csr  certificatesv1.CertificateSigningRequest
+		want status
+		msg  string
+	}{
+		{"pending", csr("krustlet-wasi-tls", servingSigner), statusFail, "kubectl certificate approve krustlet-wasi-tls"},
+		{"unsigned", csr("krustlet-wasi-tls", servingSigner, certificatesv1.CertificateApproved), statusFail, "no certificate was issued"},
+		{"denied", csr("krustlet-wasi", clientSigner, certificatesv1.CertificateDenied), statusFail, "client certificate request krustlet-wasi was denied"},
+		{"other node", csr("krustlet-wagi-tls", servingSigner), statusPass, "no certificate requests"},
+	}
+	for _, tt := range tests {
+		r := report{}
+		checkCSRs(&r, []certificatesv1.CertificateSigningRequest{tt.csr}, "krustlet-wasi")
+		if s, msg := statusOf(t, r, "csr"); s != tt.want || !strings.Contains(msg, tt.msg) {
+			t.Errorf("%s: got %s: %s", tt.name, s, msg)
+		}
+	}
+}
+
+func writeBootstrap(t *testing.T, path, token string) {
+	t.Helper()
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["kubernetes"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: []byte("ca")}
+	cfg.AuthInfos["tls-bootstrap-token-user"] = &clientcmdapi.AuthInfo{Token: token}
+	cfg.Contexts["bootstrap"] = &clientcmdapi.Context{Cluster: "kubernetes", AuthInfo: "tls-bootstrap-token-user"}
+	cfg.CurrentContext = "bootstrap"
+	if err := clientcmd.WriteToFile(*cfg, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckBootstrap(t *testing.T) {
+	dir := t.TempDir()
+	p := localPaths{
+		kubeconfig:    filepath.Join(dir, "kubeconfig"),
+		bootstrapFile: filepath.Join(dir, "bootstrap.conf"),
+	}
+
+	r := report{}
+	checkBootstrap(&r, p)
+	if s, msg := statusOf(t, r, "bootstrap"); s != statusFail || !strings.Contains(msg, "KUBECONFIG is not set") {
+		t.Errorf("expected an unset KUBECONFIG to fail, got %s: %s", s, msg)
+	}
+
+	p.kubeconfigSet = true
+	r = report{}
+	checkBootstrap(&r, p)
+	if s, msg := statusOf(t, r, "bootstrap"); s != statusFail || !strings.Contains(msg, "doesn't exist") {
+		t.Errorf("expected a missing bootstrap file to fail, got %s: %s", s, msg)
+	}
+
+	writeBootstrap(t, p.bootstrapFile, "not-a-token")
+	r = report{}
+	checkBootstrap(&r, p)
+	if s, _ := statusOf(t, r, "bootstrap"); s != statusFail {
+		t.Errorf("expected a bad token to fail, got %s", s)
+	}
+
+	writeBootstrap(t, p.bootstrapFile, "abcdef.0123456789abcdef")
+	r = report{}
+	if id := checkBootstrap(&r, p); id != "abcdef" {
+		t.Errorf("expected the token id, got %q", id)
+	}
+
+	if err := os.WriteFile(p.kubeconfig, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r = report{}
+	if id := checkBootstrap(&r, p); id != "" {
+		t.Error("expected no bootstrapping with an existing kubeconfig")
+	}
+}
+
+func TestCheckTokenSecret(t *testing.T) {
+	secret := map[string][]byte{
+		"expiration":                     []byte(now.Add(-time.Minute).Format(time.RFC3339)),
+		"usage-bootstrap-authentication": []byte("true"),
+	}
+	r := report{}
+	checkTokenSecret(&r, "abcdef", secret, now)
+	if s, msg := statusOf(t, r, "bootstrap-token"); s != statusFail || !strings.Contains(msg, "expired") {
+		t.Errorf("expected an expired token to fail, got %s: %s", s, msg)
+	}
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	p := localPaths{configFile: filepath.Join(dir, "config.json"), dataDir: "/default"}
+	if err := os.WriteFile(p.configFile, []byte(`{"nodeIP": "krustlet.local"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r := report{}
+	checkConfigFile(&r, &p, nil)
+	if s, _ := statusOf(t, r, "config-file"); s != statusFail {
+		t.Errorf("expected a hostname as nodeIP to fail, got %s", s)
+	}
+
+	t.Setenv("KRUSTLET_DATA_DIR", "")
+	if err := os.WriteFile(p.configFile, []byte(`{"nodeIP": "10.0.0.4", "dataDir": "/var/lib/krustlet", "nodeName": "krustlet-wasi"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r = report{}
+	checkConfigFile(&r, &p, map[string]bool{})
+	if s, _ := statusOf(t, r, "config-file"); s != statusPass {
+		t.Errorf("expected a valid file to pass, got %s", s)
+	}
+	if p.certFile != filepath.Join("/var/lib/krustlet", "config", "krustlet.crt") || p.nodeName != "krustlet-wasi" {
+		t.Errorf("expected the config file's settings to apply, got %+v", p)
+	}
+}
+
+func TestDiagnoseCluster(t *testing.T) {
+	// The node's API and the API server's proxy to it
+	nodeAPI := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("this is the Krustlet HTTP server"))
+	}))
+	defer nodeAPI.Close()
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/nodes/krustlet-wasi/proxy/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer apiServer.Close()
+
+	host, port, _ := net.SplitHostPort(nodeAPI.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	node := krustletNode()
+	node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: host}}
+	node.Status.DaemonEndpoints.KubeletEndpoint.Port = int32(p)
+	renewed := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: "krustlet-wasi", Namespace: leaseNamespace},
+		Spec:       coordinationv1.LeaseSpec{RenewTime: &renewed},
+	}
+	client := fake.NewSimpleClientset(node, lease)
+	restConfig := &rest.Config{Host: apiServer.URL, TLSClientConfig: rest.TLSClientConfig{Insecure: true}}
+
+	r := report{}
+	diagnoseCluster(context.Background(), &r, client, restConfig, "krustlet-wasi", nil, &diagnoseOptions{arch: "wasm32-wasi", timeout: 5 * time.Second})
+	var out bytes.Buffer
+	r.print(&out)
+
+	// The test server's certificate doesn't name the node
+	for check, want := range map[string]status{
+		"registered":          statusPass,
+		"heartbeat":           statusPass,
+		"node-api":            statusPass,
+		"serving-certificate": statusWarn,
+		"api-server-proxy":    statusFail,
+	} {
+		if s, msg := statusOf(t, r, check); s != want {
+			t.Errorf("%s: got %s, want %s: %s", check, s, want, msg)
+		}
+	}
+	if !strings.Contains(out.String(), "kubectl logs fails") {
+		t.Errorf("expected the proxy failure to be explained:\n%s", out.String())
+	}
+
+	r = report{}
+	diagnoseCluster(context.Background(), &r, fake.NewSimpleClientset(), restConfig, "krustlet-wasi", nil, &diagnoseOptions{arch: "wasm32-wasi"})
+	if s, _ := statusOf(t, r, "registered"); s != statusFail {
+		t.Errorf("expected a missing node to fail, got %s", s)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// defaultBootstrapFile is where krustlet looks for its bootstrap kubeconfig
+// unless told otherwise
+const defaultBootstrapFile = "/etc/kubernetes/bootstrap-kubelet.conf"
+
+// bootstrapTokenPattern is the format the API server accepts for bootstrap
+// tokens
+var bootstrapTokenPattern = regexp.MustCompile(`^([a-z0-9]{6})\.[a-z0-9]{16}$`)
+
+// localPaths are the files krustlet reads on its host, resolved the way
+// krustlet resolves them
+type localPaths struct {
+	configFile    string
+	dataDir       string
+	certFile      string
+	keyFile       string
+	bootstrapFile string
+	// kubeconfig is where krustlet keeps its credentials: $KUBECONFIG, or
+	// ~/.kube/config if that is unset
+	kubeconfig    string
+	kubeconfigSet bool
+	// nodeName is the node name krustlet is configured with, if any
+	nodeName string
+}
+
+// defaultLocalPaths applies krustlet's environment variables and defaults
+// to the paths that weren't given as flags
+func defaultLocalPaths(p localPaths) localPaths {
+	home, _ := os.UserHomeDir()
+	if p.configFile == "" {
+		p.configFile = filepath.Join(home, ".krustlet", "config", "config.json")
+	}
+	if p.dataDir == "" {
+		p.dataDir = envOr("KRUSTLET_DATA_DIR", filepath.Join(home, ".krustlet"))
+	}
+	if p.certFile == "" {
+		p.certFile = envOr("KRUSTLET_CERT_FILE", filepath.Join(p.dataDir, "config", "krustlet.crt"))
+	}
+	if p.keyFile == "" {
+		p.keyFile = envOr("KRUSTLET_PRIVATE_KEY_FILE", filepath.Join(p.dataDir, "config", "krustlet.key"))
+	}
+	if p.bootstrapFile == "" {
+		p.bootstrapFile = envOr("KRUSTLET_BOOTSTRAP_FILE", defaultBootstrapFile)
+	}
+	p.nodeName = os.Getenv("KRUSTLET_NODE_NAME")
+	if v, ok := os.LookupEnv("KUBECONFIG"); ok && v != "" {
+		p.kubeconfig, p.kubeconfigSet = v, true
+	} else {
+		p.kubeconfig = filepath.Join(home, ".kube", "config")
+	}
+	return p
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// localConfig is the part of krustlet's config file the checks read
+type localConfig struct {
+	NodeIP        *string `json:"nodeIP"`
+	NodeName      *string `json:"nodeName"`
+	ListenerPort  *int    `json:"listenerPort"`
+	BootstrapFile *string `json:"bootstrapFile"`
+	DataDir       *string `json:"dataDir"`
+}
+
+// checkConfigFile checks krustlet's config file parses, if there is one, and
+// applies the paths and node name it sets that weren't given otherwise
+func checkConfigFile(r *report, p *localPaths, explicit map[string]bool) {
+	data, err := os.ReadFile(p.configFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.pass("config-file", "%s doesn't exist, so flags and environment variables apply", p.configFile)
+		return
+	}
+	if err != nil {
+		r.fail("config-file", "Check the file's permissions.", "reading %s: %v", p.configFile, err)
+		return
+	}
+	var cfg localConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		r.fail("config-file", "Krustlet refuses to start with a malformed config file, even if flags set every value. Fix the\n"+
+			"JSON or remove the file.", "%s: %v", p.configFile, err)
+		return
+	}
+	if cfg.NodeIP != nil && net.ParseIP(*cfg.NodeIP) == nil {
+		r.fail("config-file", "nodeIP must be an IP address, not a hostname.", "%s: nodeIP %q is not an IP address", p.configFile, *cfg.NodeIP)
+		return
+	}
+	if cfg.ListenerPort != nil && (*cfg.ListenerPort <= 0 || *cfg.ListenerPort > 65535) {
+		r.fail("config-file", "listenerPort must be a port number.", "%s: listenerPort %d is out of range", p.configFile, *cfg.ListenerPort)
+		return
+	}
+	// Environment variables and flags override the file, but defaults don't
+	if cfg.DataDir != nil && !explicit["data-dir"] && os.Getenv("KRUSTLET_DATA_DIR") == "" {
+		p.dataDir = *cfg.DataDir
+		if !explicit["cert-file"] && os.Getenv("KRUSTLET_CERT_FILE") == "" {
+			p.certFile = filepath.Join(p.dataDir, "config", "krustlet.crt")
+		}
+		if !explicit["key-file"] && os.Getenv("KRUSTLET_PRIVATE_KEY_FILE") == "" {
+			p.keyFile = filepath.Join(p.dataDir, "config", "krustlet.key")
+		}
+	}
+	if cfg.NodeName != nil && p.nodeName == "" {
+		p.nodeName = *cfg.NodeName
+	}
+	if cfg.BootstrapFile != nil && !explicit["bootstrap-file"] && os.Getenv("KRUSTLET_BOOTSTRAP_FILE") == "" {
+		p.bootstrapFile = *cfg.BootstrapFile
+	}
+	r.pass("config-file", "%s is valid", p.configFile)
+}
+
+// checkLocalCertificate checks krustlet's serving certificate and key on
+// disk. It returns the certificate if there is one.
+func checkLocalCertificate(r *report, p localPaths, now time.Time) *x509.Certificate {
+	if _, err := os.Stat(p.certFile); errors.Is(err, fs.ErrNotExist) {
+		r.pass("local-certificate", "%s doesn't exist yet; krustlet requests one when it starts", p.certFile)
+		return nil
+	}
+	pair, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		r.fail("local-certificate", "Delete both files and restart krustlet to request a new certificate.",
+			"%s and %s aren't a valid certificate and key: %v", p.certFile, p.keyFile, err)
+		return nil
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		r.fail("local-certificate", "Delete both files and restart krustlet to request a new certificate.",
+			"parsing %s: %v", p.certFile, err)
+		return nil
+	}
+	if now.After(cert.NotAfter) {
+		r.fail("local-certificate", "Krustlet doesn't renew its serving certificate itself. Run krustlet-certrotate --force, or delete\n"+
+			"both files and restart krustlet, then approve the new CSR.", "%s expired %s", p.certFile, cert.NotAfter.UTC().Format(time.RFC3339))
+		return cert
+	}
+	r.pass("local-certificate", "%s is valid until %s", p.certFile, cert.NotAfter.UTC().Format(time.RFC3339))
+	return cert
+}
+
+// checkBootstrap checks that krustlet has credentials or a usable bootstrap
+// kubeconfig. It returns the id of the bootstrap token, empty if krustlet
+// won't bootstrap or the file has problems.
+func checkBootstrap(r *report, p localPaths) string {
+	if _, err := os.Stat(p.kubeconfig); err == nil {
+		r.pass("bootstrap", "krustlet uses the existing kubeconfig %s and skips bootstrapping", p.kubeconfig)
+		return ""
+	}
+	if !p.kubeconfigSet {
+		r.fail("bootstrap", "Krustlet writes the kubeconfig it gets from bootstrapping to $KUBECONFIG, and fails to start if it is\n"+
+			"unset. Set it, for example to ~/.krustlet/config/kubeconfig, in krustlet's environment.",
+			"there is no kubeconfig and KUBECONFIG is not set")
+		return ""
+	}
+
+	const regenerate = "Create a new one with krustlet-bootstrap on a machine with cluster admin access and copy it here."
+	cfg, err := clientcmd.LoadFromFile(p.bootstrapFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		r.fail("bootstrap", regenerate+"\nPoint krustlet at it with --bootstrap-file or KRUSTLET_BOOTSTRAP_FILE.",
+			"there is no kubeconfig at %s, so krustlet bootstraps, but the bootstrap file %s doesn't exist", p.kubeconfig, p.bootstrapFile)
+		return ""
+	}
+	if err != nil {
+		r.fail("bootstrap", regenerate, "reading bootstrap file %s: %v", p.bootstrapFile, err)
+		return ""
+	}
+	// Krustlet uses the first cluster and the current context's credentials
+	var cluster string
+	for _, c := range cfg.Clusters {
+		if c.Server == "" {
+			r.fail("bootstrap", regenerate, "a cluster in %s has no server", p.bootstrapFile)
+			return ""
+		}
+		if c.CertificateAuthority == "" && len(c.CertificateAuthorityData) == 0 {
+			r.fail("bootstrap", regenerate, "cluster %s in %s has no certificate authority, which krustlet needs", c.Server, p.bootstrapFile)
+			return ""
+		}
+		cluster = c.Server
+	}
+	if cluster == "" {
+		r.fail("bootstrap", regenerate, "%s has no clusters", p.bootstrapFile)
+		return ""
+	}
+	kubeContext := cfg.Contexts[cfg.CurrentContext]
+	if kubeContext == nil || cfg.AuthInfos[kubeContext.AuthInfo] == nil {
+		r.fail("bootstrap", regenerate, "%s has no current context with credentials", p.bootstrapFile)
+		return ""
+	}
+	m := bootstrapTokenPattern.FindStringSubmatch(cfg.AuthInfos[kubeContext.AuthInfo].Token)
+	if m == nil {
+		r.fail("bootstrap", regenerate, "%s doesn't hold a bootstrap token", p.bootstrapFile)
+		return ""
+	}
+	r.pass("bootstrap", "%s holds token %s for %s", p.bootstrapFile, m[1], cluster)
+	return m[1]
+}
+
+// checkTokenSecret checks that the bootstrap token is still accepted: its
+// secret exists in kube-system and hasn't expired. secret is nil if it
+// doesn't exist.
+func checkTokenSecret(r *report, id string, secret map[string][]byte, now time.Time) {
+	const fix = "Create a new token with krustlet-bootstrap and replace the bootstrap file with the kubeconfig it writes."
+	if secret == nil {
+		r.fail("bootstrap-token", fix, "token %s doesn't exist in the cluster (kube-system/bootstrap-token-%s)", id, id)
+		return
+	}
+	if exp, ok := secret["expiration"]; ok {
+		t, err := time.Parse(time.RFC3339, string(exp))
+		if err == nil && now.After(t) {
+			r.fail("bootstrap-token", fix, "token %s expired %s", id, t.UTC().Format(time.RFC3339))
+			return
+		}
+	}
+	if string(secret["usage-bootstrap-authentication"]) != "true" {
+		r.fail("bootstrap-token", fix, "token %s can't be used for authentication", id)
+		return
+	}
+	r.pass("bootstrap-token", "token %s is valid", id)
+}
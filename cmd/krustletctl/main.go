@@ -0,0 +1,42 @@
+// krustletctl is a command line tool for operating krustlet nodes.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+)
+
+// globalOptions are the flags shared by every subcommand
+type globalOptions struct {
+	kubeconfig string
+	context    string
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	global := &globalOptions{}
+	cmd := &cobra.Command{
+		Use:          "krustletctl",
+		Short:        "Operate krustlet nodes",
+		SilenceUsage: true,
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&global.kubeconfig, "kubeconfig", "", "path to the kubeconfig of the cluster (default $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&global.context, "context", "", "kubeconfig context to use (default the current context)")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+
+	cmd.AddCommand(newDiagnoseCommand(global))
+	return cmd
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+func newLogsCommand(global *globalOptions) *cobra.Command {
+	var follow bool
+	var container string
+	cmd := &cobra.Command{
+		Use:   "logs NAME",
+		Short: "Print the logs of a wasm pod",
+		Long: `Print the logs of a wasm pod, waiting for its module to start.
+
+The container defaults to the pod's only container, or the one named after
+the pod.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, namespace, err := global.client()
+			if err != nil {
+				return err
+			}
+			name := args[0]
+			if container == "" {
+				pod, err := client.CoreV1().Pods(namespace).Get(cmd.Context(), name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				if container, err = defaultContainer(pod); err != nil {
+					return err
+				}
+			}
+			if err := waitForContainer(cmd.Context(), client, namespace, name, container); err != nil {
+				return err
+			}
+			return streamLogs(cmd.Context(), client, namespace, name, container, follow, cmd.OutOrStdout())
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&follow, "follow", "f", false, "keep streaming the logs until the module exits")
+	flags.StringVarP(&container, "container", "c", "", "container to print the logs of")
+	return cmd
+}
+
+// defaultContainer returns the pod's only container, or the one named after
+// the pod as kubectl wasm run names it
+func defaultContainer(pod *corev1.Pod) (string, error) {
+	if len(pod.Spec.Containers) == 1 {
+		return pod.Spec.Containers[0].Name, nil
+	}
+	for _, c := range pod.Spec.Containers {
+		if c.Name == pod.Name {
+			return c.Name, nil
+		}
+	}
+	return "", fmt.Errorf("pod/%s has %d containers; choose one with --container", pod.Name, len(pod.Spec.Containers))
+}
+
+func containerStatus(pod *corev1.Pod, container string) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == container {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// waitForContainer waits until the container has started, so its logs can be
+// read. It fails if the pod fails before then.
+func waitForContainer(ctx context.Context, client kubernetes.Interface, namespace, name, container string) error {
+	var last string
+	return wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if status := containerStatus(pod, container); status != nil {
+			if status.State.Running != nil || status.State.Terminated != nil {
+				return true, nil
+			}
+			// Krustlet reports pull and compile errors as the waiting
+			// reason; show each once so a stuck pod doesn't look idle
+			if w := status.State.Waiting; w != nil && w.Message != "" && w.Message != last {
+				last = w.Message
+				fmt.Fprintf(os.Stderr, "pod/%s is waiting: %s: %s\n", name, w.Reason, w.Message)
+			}
+		}
+		if pod.Status.Phase == corev1.PodFailed {
+			return false, fmt.Errorf("pod/%s failed: %s", name, pod.Status.Message)
+		}
+		return pod.Status.Phase == corev1.PodSucceeded, nil
+	})
+}
+
+// streamLogs copies the container's logs to out
+func streamLogs(ctx context.Context, client kubernetes.Interface, namespace, name, container string, follow bool, out io.Writer) error {
+	stream, err := client.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{
+		Container: container,
+		Follow:    follow,
+	}).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("streaming logs of pod/%s: %w", name, err)
+	}
+	defer stream.Close()
+	_, err = io.Copy(out, stream)
+	return err
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// wasmArchPrefix starts the architecture of every krustlet node
+const wasmArchPrefix = "wasm32-"
+
+func newListCommand(global *globalOptions) *cobra.Command {
+	var allNamespaces bool
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List wasm pods",
+		Long: `List wasm pods: those that select or tolerate a wasm32 architecture,
+whether or not kubectl wasm created them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client, namespace, err := global.client()
+			if err != nil {
+				return err
+			}
+			if allNamespaces {
+				namespace = metav1.NamespaceAll
+			}
+			pods, err := client.CoreV1().Pods(namespace).List(cmd.Context(), metav1.ListOptions{})
+			if err != nil {
+				return err
+			}
+			var wasm []corev1.Pod
+			for _, pod := range pods.Items {
+				if isWasmPod(&pod) {
+					wasm = append(wasm, pod)
+				}
+			}
+			if len(wasm) == 0 {
+				fmt.Fprintln(cmd.ErrOrStderr(), "No wasm pods found")
+				return nil
+			}
+			printPods(cmd.OutOrStdout(), wasm, allNamespaces, time.Now())
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "list wasm pods in every namespace")
+	return cmd
+}
+
+// isWasmPod reports whether the pod selects or tolerates a wasm architecture
+func isWasmPod(pod *corev1.Pod) bool {
+	if strings.HasPrefix(pod.Spec.NodeSelector[corev1.LabelArchStable], wasmArchPrefix) {
+		return true
+	}
+	for _, t := range pod.Spec.Tolerations {
+		if t.Key == corev1.LabelArchStable && strings.HasPrefix(t.Value, wasmArchPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func printPods(out io.Writer, pods []corev1.Pod, namespaces bool, now time.Time) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if namespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tIMAGE\tNODE\tSTATUS\tAGE")
+	for i := range pods {
+		pod := &pods[i]
+		if namespaces {
+			fmt.Fprintf(w, "%s\t", pod.Namespace)
+		}
+		images := make([]string, 0, len(pod.Spec.Containers))
+		for _, c := range pod.Spec.Containers {
+			images = append(images, c.Image)
+		}
+		node := pod.Spec.NodeName
+		if node == "" {
+			node = "<none>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pod.Name, strings.Join(images, ","), node, podStatus(pod),
+			duration.HumanDuration(now.Sub(pod.CreationTimestamp.Time)))
+	}
+	w.Flush()
+}
+
+// podStatus summarizes the pod's status the way kubectl get pods does: the
+// reason a container is waiting or terminated, or else the pod's phase
+func podStatus(pod *corev1.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "Terminating"
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if w := status.State.Waiting; w != nil && w.Reason != "" {
+			return w.Reason
+		}
+		if t := status.State.Terminated; t != nil && t.Reason != "" && pod.Status.Phase != corev1.PodSucceeded {
+			return t.Reason
+		}
+	}
+	if pod.Status.Reason != "" {
+		return pod.Status.Reason
+	}
+	if pod.Status.Phase == "" {
+		return string(corev1.PodPending)
+	}
+	return string(pod.Status.Phase)
+}
@@ -0,0 +1,130 @@
+// kubectl-wasm is a kubectl plugin for running wasm modules on krustlet
+// nodes: it pushes a local module, runs it in a pod with the node selector
+// and tolerations krustlet needs, and streams its logs.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// globalOptions are the flags shared by every subcommand
+type globalOptions struct {
+	kubeconfig   string
+	context      string
+	namespace    string
+	dockerConfig string
+	plainHTTP    bool
+	insecure     bool
+}
+
+// exitError is returned when a module exits with an error, so the plugin
+// exits with the same code
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("module exited with code %d", e.code)
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			os.Exit(exit.code)
+		}
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	global := &globalOptions{}
+	cmd := &cobra.Command{
+		Use:   "kubectl-wasm",
+		Short: "Run wasm modules on krustlet nodes",
+		Long: `Run wasm modules on krustlet nodes.
+
+Installed on the PATH as kubectl-wasm, this is run as "kubectl wasm". Modules
+are pushed to registries with the credentials in the docker config, so a
+prior "docker login" is enough.`,
+		SilenceUsage: true,
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&global.kubeconfig, "kubeconfig", "", "path to the kubeconfig of the cluster (default $KUBECONFIG or ~/.kube/config)")
+	flags.StringVar(&global.context, "context", "", "kubeconfig context to use (default the current context)")
+	flags.StringVarP(&global.namespace, "namespace", "n", "", "namespace of the pods (default the context's namespace)")
+	flags.StringVar(&global.dockerConfig, "docker-config", "", "path to the docker config file holding registry credentials (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	flags.BoolVar(&global.plainHTTP, "plain-http", false, "use plain HTTP rather than HTTPS to push, for local development registries")
+	flags.BoolVar(&global.insecure, "insecure", false, "skip TLS certificate verification when pushing")
+
+	klogFlags := flag.NewFlagSet("klog", flag.ExitOnError)
+	klog.InitFlags(klogFlags)
+	flags.AddGoFlagSet(klogFlags)
+
+	cmd.AddCommand(
+		newRunCommand(global),
+		newPushCommand(global),
+		newLogsCommand(global),
+		newListCommand(global),
+	)
+	return cmd
+}
+
+// client returns a clientset and the namespace to use
+func (o *globalOptions) client() (kubernetes.Interface, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: o.context,
+		Context:        clientcmdapi.Context{Namespace: o.namespace},
+	})
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	namespace, _, err := loader.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := kubernetes.NewForConfig(rest.AddUserAgent(config, "kubectl-wasm"))
+	if err != nil {
+		return nil, "", err
+	}
+	return client, namespace, nil
+}
+
+// registry returns a registry client for pushing to ref
+func (o *globalOptions) registry(ref oci.Reference) (*oci.Client, error) {
+	path := o.dockerConfig
+	if path == "" {
+		var err error
+		if path, err = oci.DockerConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := oci.LoadDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	opts := []oci.Option{oci.WithCredentials(oci.DockerCredentials(cfg)), oci.WithUserAgent("kubectl-wasm")}
+	if o.plainHTTP {
+		opts = append(opts, oci.WithPlainHTTP(ref.Registry))
+	}
+	if o.insecure {
+		opts = append(opts, oci.WithInsecureSkipVerify())
+	}
+	return oci.NewClient(opts...), nil
+}
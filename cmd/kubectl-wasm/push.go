@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+func newPushCommand(global *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "push FILE REFERENCE",
+		Short: "Push a local wasm module to a registry",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := oci.ParseReference(args[1])
+			if err != nil {
+				return err
+			}
+			module, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			return push(cmd.Context(), global, cmd.OutOrStdout(), ref, module, filepath.Base(args[0]))
+		},
+	}
+	return cmd
+}
+
+// push uploads a module to ref, titled with the name of the file it came
+// from
+func push(ctx context.Context, global *globalOptions, out io.Writer, ref oci.Reference, module []byte, title string) error {
+	client, err := global.registry(ref)
+	if err != nil {
+		return err
+	}
+	desc, err := client.Push(ctx, ref, module, oci.PushOptions{Title: title})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Pushed %s\nDigest: %s\n", ref, desc.Digest)
+	return nil
+}
+
+// devTag is the tag a local module is pushed with by run. It is derived from
+// the module's content, since krustlet caches modules by tag and stores
+// modules pulled by digest alone under the latest tag, so a reused tag would
+// run a stale copy on nodes that already pulled it.
+func devTag(module []byte) string {
+	sum := sha256.Sum256(module)
+	return "dev-" + hex.EncodeToString(sum[:])[:12]
+}
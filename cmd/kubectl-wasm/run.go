@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/krustlet/krustlet/pkg/admission"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+const (
+	nameLabel      = "app.kubernetes.io/name"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "kubectl-wasm"
+)
+
+type runOptions struct {
+	registry string
+	arch     string
+	env      []string
+	restart  string
+	rm       bool
+	detach   bool
+	dryRun   bool
+}
+
+func newRunCommand(global *globalOptions) *cobra.Command {
+	opts := &runOptions{}
+	cmd := &cobra.Command{
+		Use:   "run NAME SOURCE [-- ARGS...]",
+		Short: "Run a wasm module in a pod on a krustlet node",
+		Long: `Run a wasm module in a pod on a krustlet node and stream its logs.
+
+SOURCE is a local .wasm file or a reference to a module in a registry. A local
+file is first pushed to <registry>/<name>:dev-<hash>, where <registry> is
+given by --registry; the tag changes with the module's content, so nodes that
+ran an earlier build pull the new one.
+
+The pod is named NAME and gets the node selector and tolerations krustlet
+nodes need. ARGS are passed to the module. A pod of the same name is replaced
+if kubectl wasm created it. The command exits with the module's exit code.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dash := cmd.ArgsLenAtDash()
+			if dash >= 0 && dash != 2 {
+				return errors.New("expected NAME and SOURCE before --")
+			}
+			if dash < 0 && len(args) > 2 {
+				return errors.New("module arguments must follow --")
+			}
+			return run(cmd.Context(), global, opts, cmd.OutOrStdout(), args[0], args[1], args[2:])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.registry, "registry", os.Getenv("KUBECTL_WASM_REGISTRY"), "registry and optional path to push local modules to, such as localhost:5000/dev ($KUBECTL_WASM_REGISTRY)")
+	flags.StringVar(&opts.arch, "arch", admission.DefaultArch, "architecture of the krustlet nodes to run on")
+	flags.StringArrayVarP(&opts.env, "env", "e", nil, "environment variable in KEY=VALUE form (may be repeated)")
+	flags.StringVar(&opts.restart, "restart", string(corev1.RestartPolicyNever), "restart policy of the pod: Never, OnFailure or Always")
+	flags.BoolVar(&opts.rm, "rm", false, "delete the pod once the module exits")
+	flags.BoolVarP(&opts.detach, "detach", "d", false, "create the pod and exit without streaming its logs")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "print the pod manifest instead of creating it; local modules aren't pushed")
+	return cmd
+}
+
+func run(ctx context.Context, global *globalOptions, opts *runOptions, out io.Writer, name, source string, args []string) error {
+	if opts.rm && opts.detach {
+		return errors.New("--rm and --detach are mutually exclusive")
+	}
+	image, module, err := resolveImage(opts.registry, name, source)
+	if err != nil {
+		return err
+	}
+	pod, err := podFor(name, image, args, opts)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		data, err := yaml.Marshal(pod)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+
+	client, namespace, err := global.client()
+	if err != nil {
+		return err
+	}
+	if module != nil {
+		if err := push(ctx, global, os.Stderr, image, module, filepath.Base(source)); err != nil {
+			return err
+		}
+	}
+	pods := client.CoreV1().Pods(namespace)
+	if err := deleteExisting(ctx, client, namespace, name); err != nil {
+		return err
+	}
+	if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "pod/%s created\n", name)
+	if opts.detach {
+		return nil
+	}
+	if opts.rm {
+		defer func() {
+			// Delete the pod even if the command was interrupted
+			ctx := context.WithoutCancel(ctx)
+			if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				fmt.Fprintf(os.Stderr, "deleting pod/%s: %v\n", name, err)
+			}
+		}()
+	}
+
+	if err := waitForContainer(ctx, client, namespace, name, name); err != nil {
+		return err
+	}
+	if err := streamLogs(ctx, client, namespace, name, name, true, out); err != nil {
+		return err
+	}
+	return exitCode(ctx, client, namespace, name, name)
+}
+
+// resolveImage returns the reference the pod runs. When source is a local
+// file, it also returns the module to push to that reference.
+func resolveImage(registry, name, source string) (oci.Reference, []byte, error) {
+	info, err := os.Stat(source)
+	if err != nil || info.IsDir() {
+		ref, err := oci.ParseReference(source)
+		if err != nil {
+			return oci.Reference{}, nil, fmt.Errorf("%s is neither a local file nor a module reference: %w", source, err)
+		}
+		return ref, nil, nil
+	}
+	if registry == "" {
+		return oci.Reference{}, nil, errors.New("--registry is required to run a local module")
+	}
+	module, err := os.ReadFile(source)
+	if err != nil {
+		return oci.Reference{}, nil, err
+	}
+	ref, err := oci.ParseReference(strings.TrimSuffix(registry, "/") + "/" + name + ":" + devTag(module))
+	if err != nil {
+		return oci.Reference{}, nil, fmt.Errorf("invalid registry %q: %w", registry, err)
+	}
+	return ref, module, nil
+}
+
+// podFor returns the pod that runs image on a krustlet node
+func podFor(name string, image oci.Reference, args []string, opts *runOptions) (*corev1.Pod, error) {
+	restart := corev1.RestartPolicy(opts.restart)
+	switch restart {
+	case corev1.RestartPolicyNever, corev1.RestartPolicyOnFailure, corev1.RestartPolicyAlways:
+	default:
+		return nil, fmt.Errorf("invalid restart policy %q", opts.restart)
+	}
+	env, err := parseEnv(opts.env)
+	if err != nil {
+		return nil, err
+	}
+
+	tolerations := admission.Tolerations(opts.arch)
+	// Krustlet nodes have no CNI, so the node controller may mark them as
+	// having no network
+	tolerations = append(tolerations, corev1.Toleration{
+		Key:      corev1.TaintNodeNetworkUnavailable,
+		Operator: corev1.TolerationOpExists,
+		Effect:   corev1.TaintEffectNoSchedule,
+	})
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{nameLabel: name, managedByLabel: managedBy},
+			Annotations: map[string]string{admission.WasmAnnotation: "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:            name,
+				Image:           image.String(),
+				Args:            args,
+				Env:             env,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+			}},
+			RestartPolicy: restart,
+			NodeSelector:  map[string]string{corev1.LabelArchStable: opts.arch},
+			Tolerations:   tolerations,
+		},
+	}, nil
+}
+
+func parseEnv(values []string) ([]corev1.EnvVar, error) {
+	env := make([]corev1.EnvVar, 0, len(values))
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", v)
+		}
+		env = append(env, corev1.EnvVar{Name: key, Value: value})
+	}
+	sort.SliceStable(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+	return env, nil
+}
+
+// deleteExisting deletes a pod of the same name created by kubectl wasm and
+// waits for it to go. It refuses to touch a pod created some other way.
+func deleteExisting(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	pods := client.CoreV1().Pods(namespace)
+	existing, err := pods.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Labels[managedByLabel] != managedBy {
+		return fmt.Errorf("pod/%s already exists and wasn't created by kubectl wasm", name)
+	}
+	uid := existing.UID
+	if err := pods.Delete(ctx, name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}}); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "pod/%s deleted\n", name)
+	return wait.PollUntilContextTimeout(ctx, time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+		pod, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return err == nil && pod.UID != uid, err
+	})
+}
+
+// exitCode returns an exitError if the container exited with an error
+func exitCode(ctx context.Context, client kubernetes.Interface, namespace, name, container string) error {
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	status := containerStatus(pod, container)
+	if status == nil || status.State.Terminated == nil {
+		return nil
+	}
+	if code := int(status.State.Terminated.ExitCode); code != 0 {
+		return &exitError{code: code}
+	}
+	return nil
+}
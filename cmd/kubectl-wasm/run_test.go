@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// wasmHeader is the smallest valid module: the magic number and version
+var wasmHeader = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+func TestPodFor(t *testing.T) {
+	image, _ := oci.ParseReference("localhost:5000/dev/hello:dev-0123456789ab")
+	pod, err := podFor("hello", image, []string{"--greeting", "hi"}, &runOptions{
+		arch:    "wasm32-wasi",
+		restart: "Never",
+		env:     []string{"B=2", "A=1=one"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod.Spec.NodeSelector["kubernetes.io/arch"] != "wasm32-wasi" {
+		t.Errorf("unexpected node selector %v", pod.Spec.NodeSelector)
+	}
+	if !isWasmPod(pod) {
+		t.Error("expected the pod to be listed as a wasm pod")
+	}
+	effects := map[corev1.TaintEffect]bool{}
+	for _, tol := range pod.Spec.Tolerations {
+		if tol.Key == "kubernetes.io/arch" && tol.Value == "wasm32-wasi" {
+			effects[tol.Effect] = true
+		}
+	}
+	if !effects[corev1.TaintEffectNoSchedule] || !effects[corev1.TaintEffectNoExecute] {
+		t.Errorf("expected arch tolerations for both effects, got %v", pod.Spec.Tolerations)
+	}
+	c := pod.Spec.Containers[0]
+	if c.Image != "localhost:5000/dev/hello:dev-0123456789ab" || strings.Join(c.Args, " ") != "--greeting hi" {
+		t.Errorf("unexpected container %+v", c)
+	}
+	if len(c.Env) != 2 || c.Env[0].Name != "A" || c.Env[0].Value != "1=one" {
+		t.Errorf("unexpected env %v", c.Env)
+	}
+	if pod.Labels[managedByLabel] != managedBy {
+		t.Errorf("unexpected labels %v", pod.Labels)
+	}
+
+	if _, err := podFor("hello", image, nil, &runOptions{arch: "wasm32-wasi", restart: "Sometimes"}); err == nil {
+		t.Error("expected an invalid restart policy to fail")
+	}
+	if _, err := podFor("hello", image, nil, &runOptions{arch: "wasm32-wasi", restart: "Never", env: []string{"NOVALUE"}}); err == nil {
+		t.Error("expected an invalid environment variable to fail")
+	}
+}
+
+func TestResolveImage(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hello.wasm")
+	if err := os.WriteFile(file, wasmHeader, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, module, err := resolveImage("localhost:5000/dev/", "hello", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "localhost:5000/dev/hello:" + devTag(wasmHeader); ref.String() != want || module == nil {
+		t.Errorf("got %s, want %s with a module to push", ref, want)
+	}
+	if _, _, err := resolveImage("", "hello", file); err == nil {
+		t.Error("expected a local module without a registry to fail")
+	}
+
+	ref, module, err = resolveImage("", "hello", "webassembly.azurecr.io/hello-wasm:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.String() != "webassembly.azurecr.io/hello-wasm:v1" || module != nil {
+		t.Errorf("unexpected reference %s", ref)
+	}
+}
+
+func TestDevTag(t *testing.T) {
+	other := append(append([]byte{}, wasmHeader...), 0x00)
+	if devTag(wasmHeader) == devTag(other) {
+		t.Error("expected different modules to get different tags")
+	}
+	if tag := devTag(wasmHeader); !strings.HasPrefix(tag, "dev-") || len(tag) != 16 {
+		t.Errorf("unexpected tag %s", tag)
+	}
+}
+
+func TestDeleteExistingRefusesUnmanagedPods(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+	})
+	if err := deleteExisting(context.Background(), client, "default", "web"); err == nil {
+		t.Fatal("expected a pod kubectl wasm didn't create to be left alone")
+	}
+	if _, err := client.CoreV1().Pods("default").Get(context.Background(), "web", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the pod to be kept: %v", err)
+	}
+}
+
+func TestDeleteExisting(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", Labels: map[string]string{managedByLabel: managedBy}},
+	})
+	if err := deleteExisting(context.Background(), client, "default", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteExisting(context.Background(), client, "default", "missing"); err != nil {
+		t.Errorf("expected a missing pod to be ignored: %v", err)
+	}
+}
+
+func TestPodStatus(t *testing.T) {
+	for want, pod := range map[string]*corev1.Pod{
+		"Pending": {},
+		"Running": {Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		"ErrImagePull": {Status: corev1.PodStatus{Phase: corev1.PodPending, ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull"}},
+		}}}},
+		"Error": {Status: corev1.PodStatus{Phase: corev1.PodFailed, ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}},
+		}}}},
+		"Terminating": {ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &metav1.Time{}}},
+	} {
+		if got := podStatus(pod); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/krustlet/krustlet/pkg/wasm"
+)
+
+// The supported compilers for Go packages
+const (
+	compilerGo     = "go"
+	compilerTinyGo = "tinygo"
+)
+
+// buildCommand returns the command that builds the package into output
+func buildCommand(ctx context.Context, compiler, pkg, output string, flags []string) (*exec.Cmd, error) {
+	var cmd *exec.Cmd
+	switch compiler {
+	case compilerGo:
+		args := append([]string{"build", "-o", output}, flags...)
+		cmd = exec.CommandContext(ctx, "go", append(args, pkg)...)
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	case compilerTinyGo:
+		args := append([]string{"build", "-target=wasi", "-o", output}, flags...)
+		cmd = exec.CommandContext(ctx, "tinygo", append(args, pkg)...)
+	default:
+		return nil, fmt.Errorf("unknown compiler %q: must be %s or %s", compiler, compilerGo, compilerTinyGo)
+	}
+	return cmd, nil
+}
+
+// compile builds the package and returns the module, sending the compiler's
+// output to w
+func compile(ctx context.Context, w io.Writer, compiler, pkg string, flags []string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "wasm-build-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "module.wasm")
+
+	cmd, err := buildCommand(ctx, compiler, pkg, output, flags)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = w, w
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("building %s with %s: %w", pkg, compiler, err)
+	}
+	return os.ReadFile(output)
+}
+
+// moduleName names the module after the package it was built from
+func moduleName(pkg string) string {
+	name := filepath.Base(filepath.Clean(pkg))
+	if name == "." || name == string(filepath.Separator) {
+		if abs, err := filepath.Abs(pkg); err == nil {
+			name = filepath.Base(abs)
+		}
+	}
+	return name
+}
+
+// validate checks that krustlet can run the module. Imports krustlet doesn't
+// provide are returned as warnings, since other providers may link them.
+func validate(module []byte) ([]string, error) {
+	m, err := wasm.Parse(module)
+	if errors.Is(err, wasm.ErrComponent) {
+		return nil, fmt.Errorf("%w; krustlet only runs core modules", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := m.Export("_start"); !ok || e.Kind != wasm.KindFunc {
+		return nil, errors.New("module has no _start function; krustlet runs modules as WASI commands, so build a program with a main function")
+	}
+	var warnings []string
+	for _, name := range m.ImportModules() {
+		if _, ok := wasm.WASI.Imports[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("module imports from %s, which krustlet's WASI provider doesn't provide", name))
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+func TestBuildCommand(t *testing.T) {
+	ctx := context.Background()
+	cmd, err := buildCommand(ctx, compilerGo, "./cmd/hello", "out.wasm", []string{"-trimpath"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cmd.Args, " "); got != "go build -o out.wasm -trimpath ./cmd/hello" {
+		t.Errorf("unexpected go command %q", got)
+	}
+	env := strings.Join(cmd.Env, "\n")
+	if !strings.Contains(env, "GOOS=wasip1") || !strings.Contains(env, "GOARCH=wasm") {
+		t.Error("go build must target wasip1/wasm")
+	}
+
+	cmd, err = buildCommand(ctx, compilerTinyGo, ".", "out.wasm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cmd.Args, " "); got != "tinygo build -target=wasi -o out.wasm ." {
+		t.Errorf("unexpected tinygo command %q", got)
+	}
+
+	if _, err := buildCommand(ctx, "rustc", ".", "out.wasm", nil); err == nil {
+		t.Error("expected an unknown compiler to be rejected")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	warnings, err := validate(ocitest.HelloWasm())
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("got %v, %v for a WASI command", warnings, err)
+	}
+	// A module with an import from env and no exports
+	library := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x02, 0x09, 0x01, 0x03, 'e', 'n', 'v', 0x01, 'f', 0x00, 0x00,
+	}
+	if _, err := validate(library); err == nil || !strings.Contains(err.Error(), "_start") {
+		t.Errorf("expected a module without _start to be rejected, got %v", err)
+	}
+	if _, err := validate([]byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}); err == nil || !strings.Contains(err.Error(), "core modules") {
+		t.Errorf("expected a component to be rejected, got %v", err)
+	}
+}
+
+func TestModuleName(t *testing.T) {
+	if got := moduleName("./cmd/hello/"); got != "hello" {
+		t.Errorf("got %q", got)
+	}
+	if got := moduleName("github.com/krustlet/krustlet/demos/greeter"); got != "greeter" {
+		t.Errorf("got %q", got)
+	}
+	wd, _ := os.Getwd()
+	if got := moduleName("."); got != filepath.Base(wd) {
+		t.Errorf("got %q for the working directory", got)
+	}
+}
+
+func TestRunPrebuilt(t *testing.T) {
+	reg := ocitest.NewServer(t)
+	path := filepath.Join(t.TempDir(), "hello.wasm")
+	if err := os.WriteFile(path, ocitest.HelloWasm(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := &options{plainHTTP: true, quiet: true, dockerConfig: filepath.Join(t.TempDir(), "config.json")}
+	var stdout, stderr bytes.Buffer
+	if err := run(context.Background(), &stdout, &stderr, opts, path, reg.Ref("wasm/hello:v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	pinned := strings.TrimSpace(stdout.String())
+	if !strings.HasPrefix(pinned, reg.Ref("wasm/hello@sha256:")) {
+		t.Fatalf("unexpected output %q", pinned)
+	}
+
+	ref, err := oci.ParseReference(pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	module, err := oci.NewClient(oci.WithPlainHTTP(reg.Host())).Pull(context.Background(), ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, _ := module.Manifest.ModuleLayer()
+	if !bytes.Equal(module.Data, ocitest.HelloWasm()) || layer.Annotations[oci.AnnotationTitle] != "hello.wasm" {
+		t.Errorf("unexpected module pushed: %+v", module.Manifest)
+	}
+}
@@ -0,0 +1,170 @@
+// wasm-build compiles a Go program to WebAssembly, checks that krustlet can
+// run the module and pushes it to a registry in one step.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+type options struct {
+	compiler     string
+	output       string
+	annotations  []string
+	dockerConfig string
+	plainHTTP    bool
+	insecure     bool
+	quiet        bool
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "wasm-build [flags] TARGET REFERENCE [-- BUILD FLAGS]",
+		Short: "Build a wasm module and push it to a registry",
+		Long: `Build a wasm module and push it to a registry.
+
+TARGET is a Go package, built with GOOS=wasip1 GOARCH=wasm or with TinyGo's
+wasi target, or a .wasm file that is already built. The module is checked to
+be a WASI command krustlet can run, then pushed to REFERENCE with the media
+types krustlet expects. The pushed module's reference, pinned to its digest,
+is printed for use as a container image in a pod spec.
+
+Flags after -- are passed to the compiler. Credentials come from the docker
+config, so a prior "docker login" is enough.`,
+		Example: `  wasm-build ./cmd/hello myregistry.example.com/hello:v1
+  wasm-build --compiler tinygo . myregistry.example.com/hello:v1 -- -opt=2
+  wasm-build target/wasm32-wasi/release/app.wasm myregistry.example.com/app:v1`,
+		SilenceUsage: true,
+		Args: func(cmd *cobra.Command, args []string) error {
+			n := len(args)
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				n = dash
+			}
+			if n != 2 {
+				return fmt.Errorf("accepts TARGET and REFERENCE, received %d arguments", n)
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr(), opts, args[0], args[1], args[2:])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&opts.compiler, "compiler", compilerGo, "compiler for Go packages: go or tinygo")
+	flags.StringVarP(&opts.output, "output", "o", "", "also write the built module to this file")
+	flags.StringArrayVarP(&opts.annotations, "annotation", "a", nil, "manifest annotation in key=value form (may be repeated)")
+	flags.StringVar(&opts.dockerConfig, "docker-config", "", "path to the docker config file holding credentials (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	flags.BoolVar(&opts.plainHTTP, "plain-http", false, "use plain HTTP rather than HTTPS, for local development registries")
+	flags.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "only print the pinned reference")
+	return cmd
+}
+
+func run(ctx context.Context, stdout, stderr io.Writer, opts *options, target, refArg string, buildFlags []string) error {
+	ref, err := oci.ParseReference(refArg)
+	if err != nil {
+		return err
+	}
+	annotations, err := parseAnnotations(opts.annotations)
+	if err != nil {
+		return err
+	}
+	client, err := newClient(opts, ref)
+	if err != nil {
+		return err
+	}
+
+	var module []byte
+	title := filepath.Base(target)
+	if strings.HasSuffix(target, ".wasm") {
+		if len(buildFlags) > 0 {
+			return errors.New("build flags can't be used with a .wasm file")
+		}
+		if module, err = os.ReadFile(target); err != nil {
+			return err
+		}
+	} else {
+		if module, err = compile(ctx, stderr, opts.compiler, target, buildFlags); err != nil {
+			return err
+		}
+		title = moduleName(target) + ".wasm"
+		if opts.output != "" {
+			if err := os.WriteFile(opts.output, module, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+
+	warnings, err := validate(module)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target, err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(stderr, "warning: %s\n", w)
+	}
+
+	desc, err := client.Push(ctx, ref, module, oci.PushOptions{Title: title, Annotations: annotations})
+	if err != nil {
+		return err
+	}
+	pinned := ref.Name() + "@" + desc.Digest
+	if opts.quiet {
+		fmt.Fprintln(stdout, pinned)
+		return nil
+	}
+	fmt.Fprintf(stdout, "Pushed %s\nDigest: %s\nImage: %s\n", ref, desc.Digest, pinned)
+	return nil
+}
+
+// newClient builds a registry client using the docker config's credentials
+func newClient(opts *options, ref oci.Reference) (*oci.Client, error) {
+	path := opts.dockerConfig
+	if path == "" {
+		var err error
+		if path, err = oci.DockerConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := oci.LoadDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	clientOpts := []oci.Option{oci.WithCredentials(oci.DockerCredentials(cfg)), oci.WithUserAgent("krustlet-wasm-build")}
+	if opts.plainHTTP {
+		clientOpts = append(clientOpts, oci.WithPlainHTTP(ref.Registry))
+	}
+	if opts.insecure {
+		clientOpts = append(clientOpts, oci.WithInsecureSkipVerify())
+	}
+	return oci.NewClient(clientOpts...), nil
+}
+
+// parseAnnotations turns key=value flags into a map
+func parseAnnotations(values []string) (map[string]string, error) {
+	out := make(map[string]string, len(values))
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid annotation %q: must be in key=value form", v)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
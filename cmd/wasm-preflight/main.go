@@ -0,0 +1,185 @@
+// wasm-preflight checks that one of krustlet's providers can run a
+// WebAssembly module, before it is pushed or deployed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/wasm"
+)
+
+type options struct {
+	provider     string
+	memoryLimit  string
+	output       string
+	dockerConfig string
+	plainHTTP    bool
+	insecure     bool
+}
+
+// result is the report for one module, as printed
+type result struct {
+	Module string `json:"module"`
+	*wasm.Report
+}
+
+func main() {
+	if err := newCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newCommand() *cobra.Command {
+	opts := &options{}
+	cmd := &cobra.Command{
+		Use:   "wasm-preflight MODULE...",
+		Short: "Check that krustlet can run WebAssembly modules",
+		Long: `Check that krustlet can run WebAssembly modules.
+
+Each MODULE is a .wasm file, or a reference to a module in a registry, which
+is pulled. Its binary is inspected without running it: whether it is a core
+module rather than a component, whether the provider links everything it
+imports, whether it exports a _start function the provider can call, whether
+it needs threads, 64-bit or multiple memories, or exceptions, and whether its
+memory fits --memory-limit. Problems that keep the provider from running a
+module are errors; ones that can make it fail once it runs are warnings.
+
+The command fails if any module has an error.`,
+		Example: `  wasm-preflight target/wasm32-wasi/release/app.wasm
+  wasm-preflight --memory-limit 64Mi -o json myregistry.example.com/app:v1`,
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(cmd.Context(), cmd.OutOrStdout(), opts, args)
+		},
+	}
+
+	providers := make([]string, 0, len(wasm.Providers))
+	for name := range wasm.Providers {
+		providers = append(providers, name)
+	}
+	sort.Strings(providers)
+	flags := cmd.Flags()
+	flags.StringVar(&opts.provider, "provider", wasm.WASI.Name, "krustlet provider to check against: "+strings.Join(providers, ", "))
+	flags.StringVar(&opts.memoryLimit, "memory-limit", "", "memory limit of the pods the modules run in, such as 128Mi")
+	flags.StringVarP(&opts.output, "output", "o", "text", "output format: text or json")
+	flags.StringVar(&opts.dockerConfig, "docker-config", "", "path to the docker config file holding credentials (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	flags.BoolVar(&opts.plainHTTP, "plain-http", false, "pull over plain HTTP rather than HTTPS, for local development registries")
+	flags.BoolVar(&opts.insecure, "insecure", false, "skip TLS certificate verification")
+	return cmd
+}
+
+func run(ctx context.Context, out io.Writer, opts *options, modules []string) error {
+	provider, ok := wasm.Providers[opts.provider]
+	if !ok {
+		return fmt.Errorf("unknown provider %q", opts.provider)
+	}
+	if opts.output != "text" && opts.output != "json" {
+		return fmt.Errorf("unknown output format %q: must be text or json", opts.output)
+	}
+	var checkOpts wasm.CheckOptions
+	if opts.memoryLimit != "" {
+		q, err := resource.ParseQuantity(opts.memoryLimit)
+		if err != nil {
+			return fmt.Errorf("invalid --memory-limit %q: %w", opts.memoryLimit, err)
+		}
+		checkOpts.MemoryLimit = q.Value()
+	}
+
+	var results []result
+	failed := 0
+	for _, module := range modules {
+		data, err := load(ctx, opts, module)
+		if err != nil {
+			return err
+		}
+		report, err := wasm.Check(data, provider, checkOpts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", module, err)
+		}
+		if !report.Compatible() {
+			failed++
+		}
+		results = append(results, result{Module: module, Report: report})
+	}
+
+	if opts.output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			printResult(out, r)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d modules can't run on the %s provider", failed, len(modules), provider.Name)
+	}
+	return nil
+}
+
+// load reads a module from a file if one is named module, and pulls it from
+// a registry otherwise
+func load(ctx context.Context, opts *options, module string) ([]byte, error) {
+	if info, err := os.Stat(module); err == nil && !info.IsDir() {
+		return os.ReadFile(module)
+	}
+	ref, err := oci.ParseReference(module)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a file nor a module reference: %w", module, err)
+	}
+	client, err := newClient(opts, ref)
+	if err != nil {
+		return nil, err
+	}
+	pulled, err := client.Pull(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return pulled.Data, nil
+}
+
+func newClient(opts *options, ref oci.Reference) (*oci.Client, error) {
+	path := opts.dockerConfig
+	if path == "" {
+		var err error
+		if path, err = oci.DockerConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := oci.LoadDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	clientOpts := []oci.Option{oci.WithCredentials(oci.DockerCredentials(cfg)), oci.WithUserAgent("krustlet-wasm-preflight")}
+	if opts.plainHTTP {
+		clientOpts = append(clientOpts, oci.WithPlainHTTP(ref.Registry))
+	}
+	if opts.insecure {
+		clientOpts = append(clientOpts, oci.WithInsecureSkipVerify())
+	}
+	return oci.NewClient(clientOpts...), nil
+}
+
+func printResult(out io.Writer, r result) {
+	verdict := "can run"
+	if !r.Compatible() {
+		verdict = "CAN'T run"
+	}
+	fmt.Fprintf(out, "%s: the %s provider %s it\n", r.Module, r.Provider, verdict)
+	for _, f := range r.Findings {
+		fmt.Fprintf(out, "  %s [%s] %s\n", f.Severity, f.Check, f.Message)
+	}
+}
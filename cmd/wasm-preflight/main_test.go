@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	hello := filepath.Join(dir, "hello.wasm")
+	component := filepath.Join(dir, "component.wasm")
+	_ = os.WriteFile(hello, ocitest.HelloWasm(), 0o644)
+	_ = os.WriteFile(component, []byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}, 0o644)
+	opts := &options{provider: "wasi", output: "text", memoryLimit: "64Mi"}
+
+	var out strings.Builder
+	if err := run(context.Background(), &out, opts, []string{hello}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "hello.wasm: the wasi provider can run it") || !strings.Contains(out.String(), "warning [memory]") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	err := run(context.Background(), &out, opts, []string{hello, component})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 modules") {
+		t.Errorf("expected the component to fail the check, got %v", err)
+	}
+	if !strings.Contains(out.String(), "component.wasm: the wasi provider CAN'T run it") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestRunPulled(t *testing.T) {
+	srv := ocitest.NewServer(t)
+	srv.Seed()
+	opts := &options{provider: "wasi", output: "json", plainHTTP: true, dockerConfig: filepath.Join(t.TempDir(), "config.json")}
+	var out strings.Builder
+	if err := run(context.Background(), &out, opts, []string{srv.Ref(ocitest.HelloRef)}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"imports": [`) || !strings.Contains(out.String(), "wasi_snapshot_preview1.fd_write") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+	if err := run(context.Background(), &out, &options{provider: "wascc", output: "text"}, []string{"x.wasm"}); err == nil {
+		t.Error("expected an unknown provider to be rejected")
+	}
+}
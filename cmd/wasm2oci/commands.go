@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+func newPushCommand(g *globalFlags) *cobra.Command {
+	var annotations []string
+	cmd := &cobra.Command{
+		Use:   "push FILE REFERENCE",
+		Short: "Push a wasm module to a registry",
+		Example: `  wasm2oci push hello.wasm webassembly.azurecr.io/hello-wasm:v1
+  wasm2oci push --plain-http app.wasm localhost:5000/app:dev`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := oci.ParseReference(args[1])
+			if err != nil {
+				return err
+			}
+			extra, err := parseAnnotations(annotations)
+			if err != nil {
+				return err
+			}
+			module, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := g.client(ref)
+			if err != nil {
+				return err
+			}
+			desc, err := client.Push(cmd.Context(), ref, module, oci.PushOptions{
+				Title:       filepath.Base(args[0]),
+				Annotations: extra,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Pushed %s\nDigest: %s\n", ref, desc.Digest)
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVarP(&annotations, "annotation", "a", nil, "manifest annotation in key=value form (may be repeated)")
+	return cmd
+}
+
+func newConvertCommand(g *globalFlags) *cobra.Command {
+	var modulePath, image string
+	var annotations []string
+	cmd := &cobra.Command{
+		Use:   "convert SOURCE REFERENCE",
+		Short: "Republish a wasm module packaged as a container image as a module",
+		Long: `Republish a wasm module packaged as a container image as a module.
+
+Krustlet can't pull a module that was pushed as a container image, such as a
+scratch image built with docker build, and fails with an unsupported media
+type. convert finds the module in the image's layers and pushes it to
+REFERENCE in the layout krustlet pulls.
+
+SOURCE is a tarball written by docker save, or a reference to the image in a
+registry. The module is the file given by --path, or else the image's
+entrypoint if it is a module, or else the only .wasm file in the image.`,
+		Example: `  wasm2oci convert myregistry.example.com/app-image:v1 myregistry.example.com/app:v1
+  docker save app-image:v1 -o app.tar && wasm2oci convert app.tar myregistry.example.com/app:v1
+  wasm2oci convert --path /bin/app.wasm app.tar myregistry.example.com/app:v1`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dst, err := oci.ParseReference(args[1])
+			if err != nil {
+				return err
+			}
+			extra, err := parseAnnotations(annotations)
+			if err != nil {
+				return err
+			}
+			src, archive, err := convertSource(args[0], image)
+			if err != nil {
+				return err
+			}
+			refs := []oci.Reference{dst}
+			if archive == "" {
+				refs = append(refs, src)
+			}
+			client, err := g.client(refs...)
+			if err != nil {
+				return err
+			}
+			var module *oci.ImageModule
+			if archive != "" {
+				module, err = readArchiveModule(archive, image, modulePath)
+			} else {
+				module, err = client.PullImageModule(cmd.Context(), src, modulePath)
+			}
+			if err != nil {
+				return err
+			}
+			if module.Digest != "" {
+				extra[oci.AnnotationBaseName] = src.String()
+				extra[oci.AnnotationBaseDigest] = module.Digest
+			}
+			desc, err := client.Push(cmd.Context(), dst, module.Data, oci.PushOptions{
+				Title:       path.Base(module.Path),
+				Annotations: extra,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Pushed %s from %s in %s\nDigest: %s\n", dst, module.Path, args[0], desc.Digest)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&modulePath, "path", "", "path of the module in the image (default the entrypoint, or the only .wasm file)")
+	flags.StringVar(&image, "image", "", "tag of the image to convert when a docker save tarball holds more than one")
+	flags.StringArrayVarP(&annotations, "annotation", "a", nil, "manifest annotation in key=value form (may be repeated)")
+	return cmd
+}
+
+func newCheckCommand(g *globalFlags) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "check REPOSITORY",
+		Short: "Check that a registry supports what krustlet needs",
+		Long: `Check that a registry supports what krustlet needs.
+
+check pushes a small module to REPOSITORY, tagged ` + oci.CompatibilityTag + `,
+and probes the registry with it: whether it accepts and keeps the wasm media
+types, serves the module by digest, stores OCI 1.1 artifacts, lists them
+through the referrers API, and accepts chunked uploads. The test manifests are
+deleted afterwards if the registry allows it.
+
+Krustlet needs the required checks to pass; the others are used by tools such
+as signers. The command fails if a required check does.`,
+		Example: `  wasm2oci check myregistry.example.com/wasm/check`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := oci.ParseRepository(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := g.client(repo)
+			if err != nil {
+				return err
+			}
+			report := client.CheckCompatibility(cmd.Context(), repo)
+			switch output {
+			case "json":
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			case "table":
+				printReport(cmd.OutOrStdout(), report)
+			default:
+				return fmt.Errorf("unknown output format %q: must be table or json", output)
+			}
+			if !report.Compatible() {
+				return fmt.Errorf("%s doesn't support everything krustlet needs", repo.Registry)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "output format: table or json")
+	return cmd
+}
+
+// printReport writes a compatibility report as a table
+func printReport(out io.Writer, report *oci.CompatibilityReport) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+	for _, c := range report.Checks {
+		result := "pass"
+		switch {
+		case !c.Passed && c.Required:
+			result = "FAIL"
+		case !c.Passed:
+			result = "unsupported"
+		}
+		name := c.Name
+		if c.Required {
+			name += " (required)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, result, c.Detail)
+	}
+	w.Flush()
+}
+
+// convertSource returns what convert should read: a docker save tarball if
+// a file named source exists, and an image reference otherwise
+func convertSource(source, image string) (oci.Reference, string, error) {
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		return oci.Reference{}, source, nil
+	}
+	if image != "" {
+		return oci.Reference{}, "", errors.New("--image only applies to docker save tarballs")
+	}
+	ref, err := oci.ParseReference(source)
+	if err != nil {
+		return oci.Reference{}, "", fmt.Errorf("%s is neither a file nor a valid image reference: %w", source, err)
+	}
+	return ref, "", nil
+}
+
+func readArchiveModule(name, image, modulePath string) (*oci.ImageModule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	module, err := oci.ReadArchiveModule(f, image, modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return module, nil
+}
+
+func newPullCommand(g *globalFlags) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "pull REFERENCE",
+		Short: "Pull a wasm module from a registry",
+		Example: `  wasm2oci pull webassembly.azurecr.io/hello-wasm:v1 -o hello.wasm
+  wasm2oci pull webassembly.azurecr.io/hello-wasm@sha256:...`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := oci.ParseReference(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := g.client(ref)
+			if err != nil {
+				return err
+			}
+			module, err := client.Pull(cmd.Context(), ref)
+			if err != nil {
+				return err
+			}
+			if output == "" {
+				output = path.Base(ref.Repository) + ".wasm"
+			}
+			if err := os.WriteFile(output, module.Data, 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Pulled %s to %s\nDigest: %s\n", ref, output, module.Digest)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the module to (default <repository name>.wasm)")
+	return cmd
+}
+
+func newInspectCommand(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "inspect REFERENCE",
+		Short: "Show the manifest and config of a module without pulling it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := oci.ParseReference(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := g.client(ref)
+			if err != nil {
+				return err
+			}
+			in, err := client.Inspect(cmd.Context(), ref)
+			if err != nil {
+				return err
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(in)
+		},
+	}
+}
+
+func newTagsCommand(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:     "tags REPOSITORY",
+		Short:   "List the tags in a repository",
+		Example: `  wasm2oci tags webassembly.azurecr.io/hello-wasm`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := oci.ParseRepository(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := g.client(repo)
+			if err != nil {
+				return err
+			}
+			tags, err := client.Tags(cmd.Context(), repo)
+			if err != nil {
+				return err
+			}
+			for _, t := range tags {
+				fmt.Fprintln(cmd.OutOrStdout(), t)
+			}
+			return nil
+		},
+	}
+}
+
+func newCopyCommand(g *globalFlags) *cobra.Command {
+	return &cobra.Command{
+		Use:   "copy SOURCE DESTINATION",
+		Short: "Copy a module between repositories or registries, preserving its digest",
+		Long: `Copy a module between repositories or registries, preserving its digest.
+
+If DESTINATION has no tag, the source's tag is used. Credentials for both
+registries come from the docker config unless --username is given, in which
+case it is used for both.`,
+		Example: `  wasm2oci copy webassembly.azurecr.io/hello-wasm:v1 myregistry.example.com/hello-wasm`,
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			src, err := oci.ParseReference(args[0])
+			if err != nil {
+				return err
+			}
+			dst, err := copyDestination(args[1], src)
+			if err != nil {
+				return err
+			}
+			client, err := g.client(src, dst)
+			if err != nil {
+				return err
+			}
+			desc, err := client.Copy(cmd.Context(), src, dst)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Copied %s to %s\nDigest: %s\n", src, dst, desc.Digest)
+			return nil
+		},
+	}
+}
+
+// copyDestination parses the copy destination, giving it the source's tag
+// when it names only a repository
+func copyDestination(s string, src oci.Reference) (oci.Reference, error) {
+	if repo, err := oci.ParseRepository(s); err == nil {
+		repo.Tag = src.Tag
+		if repo.Tag == "" {
+			repo.Digest = src.Digest
+		}
+		return repo, nil
+	}
+	return oci.ParseReference(s)
+}
+
+// parseAnnotations turns key=value flags into a map
+func parseAnnotations(values []string) (map[string]string, error) {
+	out := make(map[string]string, len(values))
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid annotation %q: must be in key=value form", v)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+func TestCopyDestination(t *testing.T) {
+	src, err := oci.ParseReference("example.com/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := copyDestination("mirror.example.com/app", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.String() != "mirror.example.com/app:v1" {
+		t.Errorf("expected the source tag to carry over, got %s", dst)
+	}
+	dst, err = copyDestination("mirror.example.com/app:stable", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Tag != "stable" {
+		t.Errorf("expected the destination tag to win, got %s", dst)
+	}
+
+	pinned, err := oci.ParseReference("example.com/app@sha256:abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err = copyDestination("mirror.example.com/app", pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Digest != "sha256:abcd" || dst.Tag != "" {
+		t.Errorf("expected a digest-only destination, got %s", dst)
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	got, err := parseAnnotations([]string{"org.opencontainers.image.source=https://github.com/krustlet/krustlet", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["org.opencontainers.image.source"] != "https://github.com/krustlet/krustlet" || got["empty"] != "" {
+		t.Errorf("unexpected annotations %v", got)
+	}
+	if _, err := parseAnnotations([]string{"novalue"}); err == nil {
+		t.Error("expected an annotation without = to be rejected")
+	}
+}
+
+func TestPrintReport(t *testing.T) {
+	var out strings.Builder
+	printReport(&out, &oci.CompatibilityReport{Checks: []oci.CompatibilityCheck{
+		{Name: oci.CheckPushModule, Required: true, Passed: true},
+		{Name: oci.CheckMediaTypes, Required: true, Detail: "config media type came back as application/octet-stream"},
+		{Name: oci.CheckReferrers, Detail: "not supported"},
+	}})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("unexpected table:\n%s", out.String())
+	}
+	if !strings.Contains(lines[2], "(required)") || !strings.Contains(lines[2], "FAIL") || !strings.Contains(lines[3], "unsupported") {
+		t.Errorf("unexpected table:\n%s", out.String())
+	}
+}
+
+func TestVerifyFlags(t *testing.T) {
+	for name, f := range map[string]*verifyFlags{
+		"no identity":               {issuer: "https://accounts.google.com"},
+		"no issuer":                 {identity: "dev@example.com"},
+		"identity and pattern":      {identity: "dev@example.com", identityRegexp: ".*", issuer: "https://accounts.google.com"},
+		"invalid pattern":           {identityRegexp: "(", issuer: "https://accounts.google.com"},
+		"ignore tlog without --key": {identity: "dev@example.com", issuer: "https://accounts.google.com", ignoreTlog: true},
+	} {
+		if _, err := f.verifier(context.Background()); err == nil {
+			t.Errorf("%s: expected the flags to be rejected", name)
+		}
+	}
+}
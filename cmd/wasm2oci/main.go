@@ -0,0 +1,124 @@
+// wasm2oci publishes WebAssembly modules to OCI registries in the layout
+// krustlet pulls, and pulls, inspects, lists, copies, and converts them. It
+// can also check that a registry supports them, and sign and verify them
+// with Sigstore.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// globalFlags are the flags shared by every subcommand
+type globalFlags struct {
+	username      string
+	password      string
+	passwordStdin bool
+	dockerConfig  string
+	plainHTTP     bool
+	insecure      bool
+}
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	g := &globalFlags{}
+	root := &cobra.Command{
+		Use:   "wasm2oci",
+		Short: "Push and pull WebAssembly modules to and from OCI registries",
+		Long: `wasm2oci stores WebAssembly modules in OCI registries using the artifact
+layout krustlet expects: an OCI image manifest with a single
+application/vnd.wasm.content.layer.v1+wasm layer.
+
+Credentials are taken from --username and --password when given, and from
+the docker config (including credential helpers) otherwise, so a prior
+"docker login" is enough.`,
+		SilenceUsage: true,
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVarP(&g.username, "username", "u", "", "registry username")
+	flags.StringVarP(&g.password, "password", "p", "", "registry password or token")
+	flags.BoolVar(&g.passwordStdin, "password-stdin", false, "read the password from stdin")
+	flags.StringVar(&g.dockerConfig, "docker-config", "", "path to the docker config file holding credentials (default $DOCKER_CONFIG/config.json or ~/.docker/config.json)")
+	flags.BoolVar(&g.plainHTTP, "plain-http", false, "use plain HTTP rather than HTTPS, for local development registries")
+	flags.BoolVar(&g.insecure, "insecure", false, "skip TLS certificate verification")
+
+	root.AddCommand(
+		newPushCommand(g),
+		newPullCommand(g),
+		newInspectCommand(g),
+		newTagsCommand(g),
+		newCopyCommand(g),
+		newConvertCommand(g),
+		newCheckCommand(g),
+		newSignCommand(g),
+		newVerifyCommand(g),
+	)
+	return root
+}
+
+// client builds a registry client for the registries in refs
+func (g *globalFlags) client(refs ...oci.Reference) (*oci.Client, error) {
+	creds, err := g.credentials()
+	if err != nil {
+		return nil, err
+	}
+	opts := []oci.Option{oci.WithCredentials(creds)}
+	if g.plainHTTP {
+		hosts := make([]string, 0, len(refs))
+		for _, r := range refs {
+			hosts = append(hosts, r.Registry)
+		}
+		opts = append(opts, oci.WithPlainHTTP(hosts...))
+	}
+	if g.insecure {
+		opts = append(opts, oci.WithInsecureSkipVerify())
+	}
+	return oci.NewClient(opts...), nil
+}
+
+// credentials returns the credentials from the flags, falling back to the
+// docker config for anything the flags don't cover
+func (g *globalFlags) credentials() (oci.CredentialFunc, error) {
+	if g.passwordStdin {
+		if g.password != "" {
+			return nil, errors.New("--password and --password-stdin are mutually exclusive")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("reading password from stdin: %w", err)
+		}
+		g.password = strings.TrimRight(line, "\r\n")
+	}
+	if g.password != "" && g.username == "" {
+		return nil, errors.New("--password requires --username")
+	}
+	if g.username != "" {
+		return oci.StaticCredential(oci.Credential{Username: g.username, Password: g.password}), nil
+	}
+
+	path := g.dockerConfig
+	if path == "" {
+		var err error
+		if path, err = oci.DockerConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := oci.LoadDockerConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return oci.DockerCredentials(cfg), nil
+}
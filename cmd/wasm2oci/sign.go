@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/spf13/cobra"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/sigstore"
+)
+
+func newSignCommand(g *globalFlags) *cobra.Command {
+	s := &sigstore.Signer{}
+	var token, issuer, clientID string
+	cmd := &cobra.Command{
+		Use:   "sign REFERENCE",
+		Short: "Sign a module with Sigstore's keyless flow",
+		Long: `Sign a module with Sigstore's keyless flow.
+
+The signature is made with a throwaway key certified by Fulcio for your OIDC
+identity, recorded in the Rekor transparency log, and pushed to the module's
+repository as an OCI 1.1 artifact whose subject is the module's manifest.
+Modules are signed by digest, so pushing something else under the tag later
+leaves it unsigned.
+
+The identity token is taken from --identity-token or $SIGSTORE_ID_TOKEN, or
+requested from GitHub Actions when running in a workflow with the id-token:
+write permission. Otherwise a browser is used to sign in.`,
+		Example: `  wasm2oci sign webassembly.azurecr.io/hello-wasm:v1
+  wasm2oci sign --identity-token "$(cat token.jwt)" myregistry.example.com/app@sha256:...`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := oci.ParseReference(args[0])
+			if err != nil {
+				return err
+			}
+			client, err := g.client(ref)
+			if err != nil {
+				return err
+			}
+			if token == "" {
+				token = os.Getenv("SIGSTORE_ID_TOKEN")
+			}
+			switch gh, ok := sigstore.GitHubActionsToken(clientID); {
+			case token != "":
+				s.Token = sigstore.StaticToken(token)
+			case ok:
+				s.Token = gh
+			default:
+				s.Token = sigstore.InteractiveToken(issuer, clientID, func(authURL string) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "Open this URL in a browser to sign in:\n\n  %s\n\n", authURL)
+				})
+			}
+			sig, err := s.Sign(cmd.Context(), client, ref)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Signed %s as %s\nDigest: %s\nSignature: %s\n", ref, sig.Identity, sig.Digest, sig.Descriptor.Digest)
+			if sig.LogIndex != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "Rekor log index: %d\n", *sig.LogIndex)
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&token, "identity-token", "", "OIDC identity token to sign with (default $SIGSTORE_ID_TOKEN)")
+	flags.StringVar(&s.FulcioURL, "fulcio-url", sigstore.DefaultFulcioURL, "Fulcio instance to get the signing certificate from")
+	flags.StringVar(&s.RekorURL, "rekor-url", sigstore.DefaultRekorURL, "Rekor instance to record the signature in")
+	flags.StringVar(&issuer, "oidc-issuer", sigstore.DefaultOIDCIssuer, "OIDC issuer to sign in with in a browser")
+	flags.StringVar(&clientID, "oidc-client-id", sigstore.DefaultClientID, "OAuth client ID to sign in with, and the token audience Fulcio expects")
+	return cmd
+}
+
+// verifyFlags are the flags that say which signatures verify accepts
+type verifyFlags struct {
+	identity       string
+	identityRegexp string
+	issuer         string
+	key            string
+	fulcioURL      string
+	rekorURL       string
+	fulcioChain    string
+	rekorKey       string
+	ignoreTlog     bool
+}
+
+func newVerifyCommand(g *globalFlags) *cobra.Command {
+	f := &verifyFlags{}
+	var output string
+	cmd := &cobra.Command{
+		Use:   "verify REFERENCE",
+		Short: "Verify a module's signatures",
+		Long: `Verify a module's signatures.
+
+A keyless signature is valid if its certificate was issued by Fulcio to the
+identity given by --certificate-identity (or matching
+--certificate-identity-regexp) and vouched for by --certificate-oidc-issuer,
+and Rekor recorded it while the certificate was valid. Signatures made with
+cosign sign --key are checked with --key instead.
+
+Fulcio's certificates and Rekor's key are downloaded from --fulcio-url and
+--rekor-url unless files holding them are given, which pins them and lets
+verify run without reaching the Sigstore instance.
+
+The command fails unless at least one signature is valid, and prints the ones
+that are.`,
+		Example: `  wasm2oci verify webassembly.azurecr.io/hello-wasm:v1 \
+    --certificate-identity dev@example.com \
+    --certificate-oidc-issuer https://github.com/login/oauth
+  wasm2oci verify myregistry.example.com/app:v1 \
+    --certificate-identity-regexp 'https://github.com/example/app/\.github/workflows/.*' \
+    --certificate-oidc-issuer https://token.actions.githubusercontent.com
+  wasm2oci verify --key cosign.pub myregistry.example.com/app:v1`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref, err := oci.ParseReference(args[0])
+			if err != nil {
+				return err
+			}
+			if output != "text" && output != "json" {
+				return fmt.Errorf("unknown output format %q: must be text or json", output)
+			}
+			v, err := f.verifier(cmd.Context())
+			if err != nil {
+				return err
+			}
+			client, err := g.client(ref)
+			if err != nil {
+				return err
+			}
+			sigs, err := v.Verify(cmd.Context(), client, ref)
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(sigs)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Verified %s\nDigest: %s\n", ref, sigs[0].Digest)
+			for _, s := range sigs {
+				signer := s.Identity
+				if signer == "" {
+					signer = "key"
+				} else {
+					signer += " (" + s.Issuer + ")"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Signed by %s in %s\n", signer, s.Descriptor.Digest)
+			}
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&f.identity, "certificate-identity", "", "identity signatures must be issued to, such as an email address or workflow URI")
+	flags.StringVar(&f.identityRegexp, "certificate-identity-regexp", "", "pattern the signing identity must fully match")
+	flags.StringVar(&f.issuer, "certificate-oidc-issuer", "", "OIDC issuer that must have vouched for the signing identity")
+	flags.StringVar(&f.key, "key", "", "PEM public key to verify signatures made with a key")
+	flags.StringVar(&f.fulcioURL, "fulcio-url", sigstore.DefaultFulcioURL, "Fulcio instance to download the certificates to trust from")
+	flags.StringVar(&f.rekorURL, "rekor-url", sigstore.DefaultRekorURL, "Rekor instance to download the public key to trust from")
+	flags.StringVar(&f.fulcioChain, "certificate-chain", "", "PEM file holding Fulcio's certificates, instead of downloading them")
+	flags.StringVar(&f.rekorKey, "rekor-public-key", "", "PEM file holding Rekor's public key, instead of downloading it")
+	flags.BoolVar(&f.ignoreTlog, "insecure-ignore-tlog", false, "don't require signatures made with --key to be recorded in Rekor")
+	flags.StringVarP(&output, "output", "o", "text", "output format: text or json")
+	return cmd
+}
+
+// verifier builds the verifier the flags describe
+func (f *verifyFlags) verifier(ctx context.Context) (*sigstore.Verifier, error) {
+	v := &sigstore.Verifier{Identity: f.identity, Issuer: f.issuer}
+	if f.identityRegexp != "" {
+		if f.identity != "" {
+			return nil, errors.New("--certificate-identity and --certificate-identity-regexp are mutually exclusive")
+		}
+		re, err := regexp.Compile(f.identityRegexp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --certificate-identity-regexp: %w", err)
+		}
+		v.IdentityRegexp = re
+	}
+
+	if f.key != "" {
+		data, err := os.ReadFile(f.key)
+		if err != nil {
+			return nil, err
+		}
+		if v.PublicKey, err = sigstore.ParsePublicKey(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.key, err)
+		}
+		if f.ignoreTlog {
+			return v, nil
+		}
+	} else {
+		if f.ignoreTlog {
+			return nil, errors.New("--insecure-ignore-tlog only applies to --key")
+		}
+		if (f.identity == "" && f.identityRegexp == "") || f.issuer == "" {
+			return nil, errors.New("--certificate-identity or --certificate-identity-regexp, and --certificate-oidc-issuer, are required without --key")
+		}
+		if f.fulcioChain != "" {
+			data, err := os.ReadFile(f.fulcioChain)
+			if err != nil {
+				return nil, err
+			}
+			certs, err := sigstore.ParseCertificates(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.fulcioChain, err)
+			}
+			v.Roots, v.Intermediates = sigstore.SplitChain(certs)
+		} else {
+			var err error
+			if v.Roots, v.Intermediates, err = sigstore.FetchFulcioRoots(ctx, f.fulcioURL); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var rekorKey crypto.PublicKey
+	if f.rekorKey != "" {
+		data, err := os.ReadFile(f.rekorKey)
+		if err != nil {
+			return nil, err
+		}
+		if rekorKey, err = sigstore.ParsePublicKey(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.rekorKey, err)
+		}
+	} else {
+		var err error
+		if rekorKey, err = sigstore.FetchRekorPublicKey(ctx, f.rekorURL); err != nil {
+			return nil, err
+		}
+	}
+	v.RekorKeys = []crypto.PublicKey{rekorKey}
+	return v, nil
+}
@@ -66,6 +66,11 @@ pub struct Config {
     /// device plugins lives. This is also where device plugins
     /// should host their services.
     pub device_plugins_dir: PathBuf,
+    /// The kubelet `CredentialProviderConfig` file configuring the image
+    /// credential provider plugins to run when pulling modules
+    pub image_credential_provider_config: Option<PathBuf>,
+    /// The directory holding the image credential provider plugins
+    pub image_credential_provider_bin_dir: Option<PathBuf>,
 }
 /// The configuration for the Kubelet server.
 #[derive(Clone, Debug)]
@@ -127,6 +132,10 @@ struct ConfigBuilder {
     pub plugins_dir: Option<PathBuf>,
     #[serde(default, rename = "devicePluginsDir")]
     pub device_plugins_dir: Option<PathBuf>,
+    #[serde(default, rename = "imageCredentialProviderConfig")]
+    pub image_credential_provider_config: Option<PathBuf>,
+    #[serde(default, rename = "imageCredentialProviderBinDir")]
+    pub image_credential_provider_bin_dir: Option<PathBuf>,
 }
 
 struct ConfigBuilderFallbacks {
@@ -165,6 +174,8 @@ impl Config {
             insecure_registries: None,
             plugins_dir,
             device_plugins_dir,
+            image_credential_provider_config: None,
+            image_credential_provider_bin_dir: None,
             server_config: ServerConfig {
                 addr: match preferred_ip_family {
                     IpAddr::V4(_) => IpAddr::V4(Ipv4Addr::UNSPECIFIED),
@@ -289,6 +300,8 @@ impl ConfigBuilder {
             insecure_registries: opts.insecure_registries.map(parse_comma_separated),
             plugins_dir: opts.plugins_dir,
             device_plugins_dir: opts.device_plugins_dir,
+            image_credential_provider_config: opts.image_credential_provider_config,
+            image_credential_provider_bin_dir: opts.image_credential_provider_bin_dir,
             server_addr: ok_result_of(opts.addr),
             server_port: ok_result_of(opts.port),
             server_tls_cert_file: opts.cert_file,
@@ -328,6 +341,12 @@ impl ConfigBuilder {
             insecure_registries: other.insecure_registries.or(self.insecure_registries),
             plugins_dir: other.plugins_dir.or(self.plugins_dir),
             device_plugins_dir: other.device_plugins_dir.or(self.device_plugins_dir),
+            image_credential_provider_config: other
+                .image_credential_provider_config
+                .or(self.image_credential_provider_config),
+            image_credential_provider_bin_dir: other
+                .image_credential_provider_bin_dir
+                .or(self.image_credential_provider_bin_dir),
             server_tls_private_key_file: other
                 .server_tls_private_key_file
                 .or(self.server_tls_private_key_file),
@@ -384,6 +403,8 @@ impl ConfigBuilder {
             insecure_registries: self.insecure_registries,
             plugins_dir,
             device_plugins_dir,
+            image_credential_provider_config: self.image_credential_provider_config,
+            image_credential_provider_bin_dir: self.image_credential_provider_bin_dir,
             server_config: ServerConfig {
                 cert_file: server_tls_cert_file,
                 private_key_file: server_tls_private_key_file,
@@ -541,6 +562,20 @@ pub struct Opts {
         help = "Registries that should be accessed over HTTP instead of HTTPS (comma separated)"
     )]
     insecure_registries: Option<String>,
+
+    #[structopt(
+        long = "image-credential-provider-config",
+        env = "KRUSTLET_IMAGE_CREDENTIAL_PROVIDER_CONFIG",
+        help = "The path to the credential provider plugin config file, as taken by the kubelet"
+    )]
+    image_credential_provider_config: Option<PathBuf>,
+
+    #[structopt(
+        long = "image-credential-provider-bin-dir",
+        env = "KRUSTLET_IMAGE_CREDENTIAL_PROVIDER_BIN_DIR",
+        help = "The path to the directory where credential provider plugin binaries are located"
+    )]
+    image_credential_provider_bin_dir: Option<PathBuf>,
 }
 
 fn default_hostname() -> anyhow::Result<String> {
@@ -687,7 +722,9 @@ mod test {
                 "local",
                 "dev"
             ],
-            "pluginsDir": "/some/plugins"
+            "pluginsDir": "/some/plugins",
+            "imageCredentialProviderConfig": "/etc/kubernetes/credential-providers.yaml",
+            "imageCredentialProviderBinDir": "/usr/local/bin/credential-providers"
         }"#,
         );
         let config = config_builder.unwrap().build(fallbacks()).unwrap();
@@ -717,6 +754,14 @@ mod test {
         assert_eq!(&config.insecure_registries.clone().unwrap()[0], "local");
         assert_eq!(&config.insecure_registries.unwrap()[1], "dev");
         assert_eq!(&config.plugins_dir.to_string_lossy(), "/some/plugins");
+        assert_eq!(
+            config.image_credential_provider_config,
+            Some(PathBuf::from("/etc/kubernetes/credential-providers.yaml"))
+        );
+        assert_eq!(
+            config.image_credential_provider_bin_dir,
+            Some(PathBuf::from("/usr/local/bin/credential-providers"))
+        );
     }
 
     #[test]
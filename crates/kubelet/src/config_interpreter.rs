@@ -34,6 +34,8 @@ mod test {
             insecure_registries: None,
             plugins_dir: std::path::PathBuf::from("/nope"),
             device_plugins_dir: std::path::PathBuf::from("/nope"),
+            image_credential_provider_config: None,
+            image_credential_provider_bin_dir: None,
             max_pods: 0,
             node_ip: IpAddr::V4(Ipv4Addr::LOCALHOST),
             node_labels: std::collections::HashMap::new(),
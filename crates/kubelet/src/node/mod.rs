@@ -768,6 +768,8 @@ mod test {
             data_dir: PathBuf::new(),
             plugins_dir: PathBuf::new(),
             device_plugins_dir: PathBuf::new(),
+            image_credential_provider_config: None,
+            image_credential_provider_bin_dir: None,
             node_labels,
             max_pods: 110,
         };
@@ -15,6 +15,7 @@ use crate::plugin_watcher::PluginRegistry;
 use crate::pod::Pod;
 use crate::pod::Status as PodStatus;
 use crate::resources::DeviceManager;
+use crate::store::CacheStats;
 use krator::{ObjectState, State};
 
 /// A back-end for a Kubelet.
@@ -156,6 +157,15 @@ pub trait Provider: Sized + Send + Sync + 'static {
         Err(NotImplementedError.into())
     }
 
+    /// How often the provider's module store found modules in its cache,
+    /// served on the node API's `/stats/modulecache` path.
+    ///
+    /// The default implementation reports nothing, which the node API answers
+    /// with not found.
+    fn module_cache_stats(&self) -> Option<CacheStats> {
+        None
+    }
+
     /// Resolve the environment variables for a container.
     ///
     /// This generally should not be overwritten unless you need to handle
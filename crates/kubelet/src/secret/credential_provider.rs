@@ -0,0 +1,587 @@
+//! Runs kubelet image credential provider plugins
+//!
+//! Upstream kubelets get short-lived registry credentials from executables
+//! such as `ecr-credential-provider` or `acr-credential-provider`, which turn a
+//! cloud workload identity into a registry username and password. These are
+//! configured with a `CredentialProviderConfig` file, and talk to the kubelet
+//! by reading a `CredentialProviderRequest` on stdin and writing a
+//! `CredentialProviderResponse` to stdout. See
+//! <https://kubernetes.io/docs/tasks/administer-cluster/kubelet-credential-provider/>.
+
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::process::Stdio;
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+use oci_distribution::secrets::RegistryAuth;
+use oci_distribution::Reference;
+use serde::{Deserialize, Serialize};
+use tokio::io::AsyncWriteExt;
+use tracing::{debug, warn};
+
+/// How long a plugin may run before it is killed
+const PLUGIN_TIMEOUT: Duration = Duration::from_secs(60);
+
+const CONFIG_KIND: &str = "CredentialProviderConfig";
+const REQUEST_KIND: &str = "CredentialProviderRequest";
+const RESPONSE_KIND: &str = "CredentialProviderResponse";
+
+// The versions only differ in name
+const CONFIG_API_VERSIONS: &[&str] = &[
+    "kubelet.config.k8s.io/v1",
+    "kubelet.config.k8s.io/v1beta1",
+    "kubelet.config.k8s.io/v1alpha1",
+];
+const PLUGIN_API_VERSIONS: &[&str] = &[
+    "credentialprovider.kubelet.k8s.io/v1",
+    "credentialprovider.kubelet.k8s.io/v1beta1",
+    "credentialprovider.kubelet.k8s.io/v1alpha1",
+];
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase", deny_unknown_fields)]
+struct CredentialProviderConfig {
+    api_version: String,
+    kind: String,
+    providers: Vec<ProviderConfig>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase", deny_unknown_fields)]
+struct ProviderConfig {
+    name: String,
+    match_images: Vec<String>,
+    default_cache_duration: String,
+    api_version: String,
+    #[serde(default)]
+    args: Vec<String>,
+    #[serde(default)]
+    env: Vec<ExecEnvVar>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct ExecEnvVar {
+    name: String,
+    value: String,
+}
+
+#[derive(Serialize)]
+#[serde(rename_all = "camelCase")]
+struct Request<'a> {
+    api_version: &'a str,
+    kind: &'a str,
+    image: &'a str,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(rename_all = "camelCase")]
+struct Response {
+    api_version: String,
+    kind: String,
+    cache_key_type: CacheKeyType,
+    cache_duration: Option<String>,
+    #[serde(default)]
+    auth: HashMap<String, AuthConfig>,
+}
+
+#[derive(Debug, Deserialize, Clone, Copy, PartialEq)]
+enum CacheKeyType {
+    Image,
+    Registry,
+    Global,
+}
+
+#[derive(Debug, Deserialize, Clone)]
+struct AuthConfig {
+    username: String,
+    password: String,
+}
+
+struct CacheEntry {
+    auth: HashMap<String, AuthConfig>,
+    expires: Instant,
+}
+
+/// A single plugin and the responses it has given
+struct Plugin {
+    config: ProviderConfig,
+    path: PathBuf,
+    default_cache_duration: Duration,
+    cache: Mutex<HashMap<String, CacheEntry>>,
+}
+
+/// The plugins in a `CredentialProviderConfig`, the file the kubelet takes
+/// with `--image-credential-provider-config`
+pub struct CredentialProviders {
+    plugins: Vec<Plugin>,
+}
+
+impl CredentialProviders {
+    /// Loads the plugins configured in the kubelet config, if it has a
+    /// credential provider config
+    pub fn from_config(config: &crate::config::Config) -> anyhow::Result<Option<Self>> {
+        let config_file = match &config.image_credential_provider_config {
+            Some(f) => f,
+            None => return Ok(None),
+        };
+        let bin_dir = config
+            .image_credential_provider_bin_dir
+            .as_ref()
+            .ok_or_else(|| {
+                anyhow::anyhow!(
+                    "an image credential provider bin dir is required with an image credential provider config"
+                )
+            })?;
+        Ok(Some(Self::load(config_file, bin_dir)?))
+    }
+
+    /// Loads a credential provider config, which may be YAML or JSON. The
+    /// plugins it names are executables in `bin_dir`.
+    pub fn load(config_file: &Path, bin_dir: &Path) -> anyhow::Result<Self> {
+        let data = std::fs::read(config_file)?;
+        let config: CredentialProviderConfig = serde_yaml::from_slice(&data)
+            .map_err(|e| anyhow::anyhow!("unable to parse {}: {}", config_file.display(), e))?;
+        Self::new(config, bin_dir).map_err(|e| {
+            anyhow::anyhow!(
+                "invalid credential provider config {}: {}",
+                config_file.display(),
+                e
+            )
+        })
+    }
+
+    fn new(config: CredentialProviderConfig, bin_dir: &Path) -> anyhow::Result<Self> {
+        if config.kind != CONFIG_KIND {
+            anyhow::bail!("kind must be {}, got {:?}", CONFIG_KIND, config.kind);
+        }
+        if !CONFIG_API_VERSIONS.contains(&config.api_version.as_str()) {
+            anyhow::bail!("unsupported apiVersion {:?}", config.api_version);
+        }
+        if config.providers.is_empty() {
+            anyhow::bail!("at least one provider is required");
+        }
+        let mut plugins: Vec<Plugin> = Vec::new();
+        for provider in config.providers {
+            let name = provider.name.as_str();
+            if name.is_empty() || name.contains(&['/', '\\'][..]) || name == "." || name == ".." {
+                anyhow::bail!("provider name {:?} must be a file name", name);
+            }
+            if plugins.iter().any(|p| p.config.name == name) {
+                anyhow::bail!("provider {:?} is configured more than once", name);
+            }
+            if provider.match_images.is_empty() {
+                anyhow::bail!("provider {:?}: matchImages is required", name);
+            }
+            if !PLUGIN_API_VERSIONS.contains(&provider.api_version.as_str()) {
+                anyhow::bail!(
+                    "provider {:?}: unsupported apiVersion {:?}",
+                    name,
+                    provider.api_version
+                );
+            }
+            let default_cache_duration = parse_duration(&provider.default_cache_duration)
+                .map_err(|e| anyhow::anyhow!("provider {:?}: defaultCacheDuration: {}", name, e))?;
+            plugins.push(Plugin {
+                path: bin_dir.join(name),
+                default_cache_duration,
+                cache: Mutex::new(HashMap::new()),
+                config: provider,
+            });
+        }
+        Ok(CredentialProviders { plugins })
+    }
+
+    /// Returns the credentials for an image from the first matching plugin
+    /// that has any. Plugins that fail are logged and skipped, as the kubelet
+    /// does, so the pull can still be tried without credentials.
+    pub async fn registry_auth(&self, reference: &Reference) -> Option<RegistryAuth> {
+        let image = reference.whole();
+        for plugin in self.plugins.iter().filter(|p| p.matches(&image)) {
+            match plugin.lookup(&image).await {
+                Ok(Some(auth)) => return Some(RegistryAuth::Basic(auth.username, auth.password)),
+                Ok(None) => {
+                    debug!(provider = %plugin.config.name, %image, "credential provider has no credentials for image")
+                }
+                Err(e) => {
+                    warn!(provider = %plugin.config.name, %image, error = %e, "credential provider failed")
+                }
+            }
+        }
+        None
+    }
+}
+
+impl Plugin {
+    fn matches(&self, image: &str) -> bool {
+        self.config
+            .match_images
+            .iter()
+            .any(|pattern| matches_image(pattern, image))
+    }
+
+    async fn lookup(&self, image: &str) -> anyhow::Result<Option<AuthConfig>> {
+        if let Some(auth) = self.cached(image) {
+            return Ok(best_match(&auth, image));
+        }
+        let response = self.exec(image).await?;
+
+        let duration = match &response.cache_duration {
+            Some(d) => parse_duration(d)?,
+            None => self.default_cache_duration,
+        };
+        let auth = best_match(&response.auth, image);
+        if duration > Duration::ZERO {
+            let key = cache_key(response.cache_key_type, image);
+            self.cache.lock().unwrap().insert(
+                key,
+                CacheEntry {
+                    auth: response.auth,
+                    expires: Instant::now() + duration,
+                },
+            );
+        }
+        Ok(auth)
+    }
+
+    fn cached(&self, image: &str) -> Option<HashMap<String, AuthConfig>> {
+        let mut cache = self.cache.lock().unwrap();
+        let now = Instant::now();
+        for key_type in &[
+            CacheKeyType::Image,
+            CacheKeyType::Registry,
+            CacheKeyType::Global,
+        ] {
+            let key = cache_key(*key_type, image);
+            match cache.get(&key) {
+                Some(entry) if now < entry.expires => return Some(entry.auth.clone()),
+                Some(_) => {
+                    cache.remove(&key);
+                }
+                None => (),
+            }
+        }
+        None
+    }
+
+    async fn exec(&self, image: &str) -> anyhow::Result<Response> {
+        let request = serde_json::to_vec(&Request {
+            api_version: &self.config.api_version,
+            kind: REQUEST_KIND,
+            image,
+        })?;
+        let mut child = tokio::process::Command::new(&self.path)
+            .args(&self.config.args)
+            .envs(self.config.env.iter().map(|e| (&e.name, &e.value)))
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .kill_on_drop(true)
+            .spawn()
+            .map_err(|e| anyhow::anyhow!("unable to run {}: {}", self.path.display(), e))?;
+        // The request is small enough to write before reading the output
+        let mut stdin = child.stdin.take().expect("plugin stdin is piped");
+        stdin.write_all(&request).await?;
+        drop(stdin);
+
+        let output = tokio::time::timeout(PLUGIN_TIMEOUT, child.wait_with_output())
+            .await
+            .map_err(|_| anyhow::anyhow!("{} timed out", self.path.display()))??;
+        if !output.status.success() {
+            anyhow::bail!(
+                "{} failed with {}: {}",
+                self.path.display(),
+                output.status,
+                String::from_utf8_lossy(&output.stderr).trim()
+            );
+        }
+        let response: Response = serde_json::from_slice(&output.stdout)
+            .map_err(|e| anyhow::anyhow!("unable to decode response: {}", e))?;
+        if response.kind != RESPONSE_KIND || response.api_version != self.config.api_version {
+            anyhow::bail!(
+                "expected a {} {} response, got {} {}",
+                self.config.api_version,
+                RESPONSE_KIND,
+                response.api_version,
+                response.kind
+            );
+        }
+        Ok(response)
+    }
+}
+
+fn cache_key(key_type: CacheKeyType, image: &str) -> String {
+    match key_type {
+        CacheKeyType::Image => format!("Image:{}", image),
+        CacheKeyType::Registry => format!("Registry:{}", registry_of(image)),
+        CacheKeyType::Global => "Global:".to_owned(),
+    }
+}
+
+/// Returns the credentials whose pattern matches the image most
+/// specifically, taken to be the longest one
+fn best_match(auth: &HashMap<String, AuthConfig>, image: &str) -> Option<AuthConfig> {
+    auth.iter()
+        .filter(|(pattern, _)| matches_image(pattern, image))
+        .max_by(|(a, _), (b, _)| a.len().cmp(&b.len()).then_with(|| b.cmp(a)))
+        .map(|(_, auth)| auth.clone())
+}
+
+/// Reports whether an image matches a `matchImages` pattern, using the
+/// kubelet's rules: the pattern and image must have the same number of host
+/// labels, each label of the pattern is a glob (so `*.example.com` matches
+/// `a.example.com` but not `example.com` or `a.b.example.com`), ports must be
+/// equal, and the pattern's path must be a prefix of the image's.
+fn matches_image(pattern: &str, image: &str) -> bool {
+    let (pattern, image) = match (parse_schemeless(pattern), parse_schemeless(image)) {
+        (Some(p), Some(i)) => (p, i),
+        _ => return false,
+    };
+    let pattern_labels: Vec<&str> = pattern.host.split('.').collect();
+    let image_labels: Vec<&str> = image.host.split('.').collect();
+    pattern.port == image.port
+        && pattern_labels.len() == image_labels.len()
+        && image.path.starts_with(pattern.path)
+        && pattern_labels
+            .iter()
+            .zip(image_labels.iter())
+            .all(|(p, i)| glob_match(p, i))
+}
+
+struct ParsedImage<'a> {
+    host: &'a str,
+    port: Option<&'a str>,
+    path: &'a str,
+}
+
+/// Splits a pattern or image into its host, port and path, as a URL without
+/// a scheme
+fn parse_schemeless(s: &str) -> Option<ParsedImage<'_>> {
+    let (host_port, path) = match s.find('/') {
+        Some(i) => s.split_at(i),
+        None => (s, ""),
+    };
+    let (host, port) = match host_port.rsplit_once(':') {
+        Some((host, port)) => (host, Some(port)),
+        None => (host_port, None),
+    };
+    if host.is_empty() {
+        return None;
+    }
+    Some(ParsedImage { host, port, path })
+}
+
+/// Matches a single host label against a glob of `*` and `?`
+fn glob_match(pattern: &str, label: &str) -> bool {
+    let pattern: Vec<char> = pattern.chars().collect();
+    let label: Vec<char> = label.chars().collect();
+    let (mut p, mut l) = (0, 0);
+    // Where the last `*` was, and how much of the label it has taken
+    let mut star: Option<(usize, usize)> = None;
+    while l < label.len() {
+        match pattern.get(p) {
+            Some('*') => {
+                star = Some((p, l));
+                p += 1;
+            }
+            Some(c) if *c == '?' || *c == label[l] => {
+                p += 1;
+                l += 1;
+            }
+            _ => match star {
+                Some((sp, sl)) => {
+                    p = sp + 1;
+                    l = sl + 1;
+                    star = Some((sp, sl + 1));
+                }
+                None => return false,
+            },
+        }
+    }
+    pattern[p..].iter().all(|c| *c == '*')
+}
+
+/// Returns the host, and port if any, of an image
+fn registry_of(image: &str) -> &str {
+    image.split('/').next().unwrap_or_default()
+}
+
+/// Parses a duration as Kubernetes writes them, such as `12h` or `1h30m0s`
+fn parse_duration(s: &str) -> anyhow::Result<Duration> {
+    if s == "0" {
+        return Ok(Duration::ZERO);
+    }
+    if s.is_empty() {
+        anyhow::bail!("empty duration");
+    }
+    let mut rest = s;
+    let mut seconds = 0f64;
+    while !rest.is_empty() {
+        let number_len = rest
+            .find(|c: char| !(c.is_ascii_digit() || c == '.'))
+            .unwrap_or_else(|| rest.len());
+        let number: f64 = rest[..number_len]
+            .parse()
+            .map_err(|_| anyhow::anyhow!("invalid duration {:?}", s))?;
+        rest = &rest[number_len..];
+        let unit_len = rest
+            .find(|c: char| c.is_ascii_digit() || c == '.')
+            .unwrap_or_else(|| rest.len());
+        let scale = match &rest[..unit_len] {
+            "ns" => 1e-9,
+            "us" | "µs" => 1e-6,
+            "ms" => 1e-3,
+            "s" => 1.0,
+            "m" => 60.0,
+            "h" => 3600.0,
+            _ => anyhow::bail!("invalid duration {:?}", s),
+        };
+        seconds += number * scale;
+        rest = &rest[unit_len..];
+    }
+    Ok(Duration::from_secs_f64(seconds))
+}
+
+#[cfg(test)]
+mod test {
+    use super::*;
+
+    #[test]
+    fn test_matches_image() {
+        let cases = [
+            (
+                "*.dkr.ecr.*.amazonaws.com",
+                "1234.dkr.ecr.us-west-2.amazonaws.com/hello:v1",
+                true,
+            ),
+            ("*.azurecr.io", "myregistry.azurecr.io/hello", true),
+            ("*.azurecr.io", "a.b.azurecr.io/hello", false),
+            ("*.azurecr.io", "azurecr.io/hello", false),
+            ("registry.io:5000", "registry.io:5000/hello", true),
+            ("registry.io:5000", "registry.io/hello", false),
+            ("registry.io/team", "registry.io/team/hello", true),
+            ("registry.io/team", "registry.io/other/hello", false),
+            ("reg?stry.io", "registry.io/hello", true),
+            ("*", "localhost/hello", true),
+        ];
+        for (pattern, image, want) in cases.iter() {
+            assert_eq!(
+                matches_image(pattern, image),
+                *want,
+                "{} against {}",
+                pattern,
+                image
+            );
+        }
+    }
+
+    #[test]
+    fn test_parse_duration() {
+        assert_eq!(parse_duration("0").unwrap(), Duration::ZERO);
+        assert_eq!(
+            parse_duration("12h").unwrap(),
+            Duration::from_secs(12 * 3600)
+        );
+        assert_eq!(
+            parse_duration("1h30m0s").unwrap(),
+            Duration::from_secs(5400)
+        );
+        assert_eq!(parse_duration("1.5s").unwrap(), Duration::from_millis(1500));
+        assert!(parse_duration("").is_err());
+        assert!(parse_duration("-1s").is_err());
+        assert!(parse_duration("10").is_err());
+    }
+
+    #[test]
+    fn test_invalid_config() {
+        let config = |providers: &str| {
+            serde_yaml::from_str::<CredentialProviderConfig>(&format!(
+                "apiVersion: kubelet.config.k8s.io/v1\nkind: CredentialProviderConfig\nproviders: {}",
+                providers
+            ))
+            .unwrap()
+        };
+        let bin_dir = Path::new("/plugins");
+        assert!(CredentialProviders::new(config("[]"), bin_dir).is_err());
+        assert!(CredentialProviders::new(
+            config(r#"[{name: ../ecr, matchImages: ["*.io"], defaultCacheDuration: 1h, apiVersion: credentialprovider.kubelet.k8s.io/v1}]"#),
+            bin_dir
+        )
+        .is_err());
+        assert!(CredentialProviders::new(
+            config(
+                r#"[{name: ecr, matchImages: ["*.io"], defaultCacheDuration: 1h, apiVersion: v1}]"#
+            ),
+            bin_dir
+        )
+        .is_err());
+        let providers = CredentialProviders::new(
+            config(r#"[{name: ecr, matchImages: ["*.io"], defaultCacheDuration: 1h, apiVersion: credentialprovider.kubelet.k8s.io/v1}]"#),
+            bin_dir,
+        )
+        .unwrap();
+        assert_eq!(providers.plugins[0].path, bin_dir.join("ecr"));
+    }
+
+    #[cfg(target_family = "unix")]
+    #[tokio::test]
+    async fn test_registry_auth() {
+        use std::os::unix::fs::PermissionsExt;
+
+        let dir = tempfile::tempdir().unwrap();
+        let calls = dir.path().join("calls");
+        // The plugin records each request it gets, so caching can be checked
+        let script = format!(
+            r#"#!/bin/sh
+cat >> {}
+echo >> {}
+echo '{{"apiVersion":"credentialprovider.kubelet.k8s.io/v1","kind":"CredentialProviderResponse","cacheKeyType":"Registry","auth":{{"*.example.com":{{"username":"user","password":"token"}}}}}}'
+"#,
+            calls.display(),
+            calls.display()
+        );
+        let plugin = dir.path().join("fake-provider");
+        std::fs::write(&plugin, script).unwrap();
+        std::fs::set_permissions(&plugin, std::fs::Permissions::from_mode(0o755)).unwrap();
+        let config = dir.path().join("config.yaml");
+        std::fs::write(
+            &config,
+            r#"apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+  - name: fake-provider
+    matchImages: ["*.example.com"]
+    defaultCacheDuration: 1h
+    apiVersion: credentialprovider.kubelet.k8s.io/v1
+"#,
+        )
+        .unwrap();
+        let providers = CredentialProviders::load(&config, dir.path()).unwrap();
+
+        for image in &[
+            "registry.example.com/hello:v1",
+            "registry.example.com/other:v1",
+        ] {
+            let reference = Reference::try_from(*image).unwrap();
+            match providers.registry_auth(&reference).await {
+                Some(RegistryAuth::Basic(username, password)) => {
+                    assert_eq!(username, "user");
+                    assert_eq!(password, "token");
+                }
+                _ => panic!("expected credentials for {}", image),
+            }
+        }
+        let requests = std::fs::read_to_string(&calls).unwrap();
+        assert_eq!(
+            requests.lines().count(),
+            1,
+            "expected the registry's credentials to be cached, got requests {}",
+            requests
+        );
+        assert!(requests.contains(r#""image":"registry.example.com/hello:v1""#));
+
+        let unmatched = Reference::try_from("ghcr.io/hello:v1").unwrap();
+        assert!(providers.registry_auth(&unmatched).await.is_none());
+    }
+}
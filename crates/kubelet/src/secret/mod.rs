@@ -1,14 +1,22 @@
 //! Resolves image pull secrets
 
+pub mod credential_provider;
+
+use std::sync::Arc;
+
 use k8s_openapi::api::core::v1::Secret;
 use kube::api::Api;
 use oci_distribution::secrets::RegistryAuth;
 
-/// Resolves registry authentication from image pull secrets
+use credential_provider::CredentialProviders;
+
+/// Resolves registry authentication from image pull secrets, falling back to
+/// image credential provider plugins
 pub struct RegistryAuthResolver {
     kube_client: kube::Client,
     pod_namespace: String,
     image_pull_secret_names: Vec<String>,
+    credential_providers: Option<Arc<CredentialProviders>>,
 }
 
 impl RegistryAuthResolver {
@@ -20,9 +28,20 @@ impl RegistryAuthResolver {
             kube_client: client,
             pod_namespace: pod.namespace().to_owned(),
             image_pull_secret_names: pod.image_pull_secrets(),
+            credential_providers: None,
         }
     }
 
+    /// Uses credential provider plugins for images the pod's pull secrets
+    /// have no credentials for
+    pub fn with_credential_providers(
+        mut self,
+        credential_providers: Option<Arc<CredentialProviders>>,
+    ) -> Self {
+        self.credential_providers = credential_providers;
+        self
+    }
+
     /// Get the registry authentication method appropriate to the given image reference
     pub async fn resolve_registry_auth(
         &self,
@@ -49,6 +68,12 @@ impl RegistryAuthResolver {
             }
         }
 
+        if let Some(providers) = &self.credential_providers {
+            if let Some(auth) = providers.registry_auth(reference).await {
+                return Ok(auth);
+            }
+        }
+
         Ok(RegistryAuth::Anonymous)
     }
 }
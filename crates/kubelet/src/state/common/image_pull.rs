@@ -43,12 +43,17 @@ impl<P: GenericProvider> State<P::PodState> for ImagePull<P> {
 
         tracing::Span::current().record("pod_name", &pod.name());
 
-        let (client, store) = {
+        let (client, store, credential_providers) = {
             // Minimise the amount of time we hold any locks
             let state_reader = provider_state.read().await;
-            (state_reader.client(), state_reader.store())
+            (
+                state_reader.client(),
+                state_reader.store(),
+                state_reader.credential_providers(),
+            )
         };
-        let auth_resolver = crate::secret::RegistryAuthResolver::new(client, &pod);
+        let auth_resolver = crate::secret::RegistryAuthResolver::new(client, &pod)
+            .with_credential_providers(credential_providers);
         let modules = match store.fetch_pod_modules(&pod, &auth_resolver).await {
             Ok(m) => m,
             Err(e) => {
@@ -43,6 +43,13 @@ pub trait GenericProviderState: 'static + Send + Sync {
     fn client(&self) -> kube::Client;
     /// Gets the `Store` used by the provider.
     fn store(&self) -> std::sync::Arc<dyn crate::store::Store + Sync + Send>;
+    /// Gets the image credential provider plugins used for images a pod's
+    /// pull secrets have no credentials for. Defaults to none.
+    fn credential_providers(
+        &self,
+    ) -> Option<std::sync::Arc<crate::secret::credential_provider::CredentialProviders>> {
+        None
+    }
     /// Stops the specified pod. This typically involves tearing down a
     /// runtime or other execution environment.
     async fn stop(&self, pod: &crate::pod::Pod) -> anyhow::Result<()>;
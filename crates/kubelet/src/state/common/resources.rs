@@ -85,7 +85,11 @@ impl<P: GenericProvider> State<P::PodState> for Resources<P> {
                 // Get host paths, env vars, and annotations from allocate responses.
                 container_allocate_responses.iter().for_each(|(c, rs)| {
                     rs.iter().for_each(|r| {
-                        env_vars.insert(c.clone(), r.envs.clone());
+                        // A container may be allocated several resources, so merge their env vars
+                        env_vars
+                            .entry(c.clone())
+                            .or_insert_with(HashMap::new)
+                            .extend(r.envs.clone());
                         r.mounts.iter().for_each(|m| {
                             vol_paths.push((m.host_path.clone(), m.container_path.clone()))
                         })
@@ -1,5 +1,6 @@
 //! `composite` implements building complex stores from simpler ones.
 
+use crate::store::CacheStats;
 use crate::store::PullPolicy;
 use crate::store::Store;
 use async_trait::async_trait;
@@ -73,6 +74,10 @@ impl Store for CompositeStore {
             self.base.get(image_ref, pull_policy, auth).await
         }
     }
+
+    fn cache_stats(&self) -> Option<CacheStats> {
+        self.base.cache_stats()
+    }
 }
 
 #[cfg(test)]
@@ -5,7 +5,9 @@ pub mod oci;
 
 use oci_distribution::client::ImageData;
 use oci_distribution::secrets::RegistryAuth;
+use serde::Serialize;
 use std::collections::HashMap;
+use std::sync::atomic::{AtomicU64, Ordering};
 use std::sync::Arc;
 use tokio::sync::Mutex;
 use tokio::sync::RwLock;
@@ -59,6 +61,12 @@ pub trait Store: Sync {
         auth: &RegistryAuth,
     ) -> anyhow::Result<Vec<u8>>;
 
+    /// How often the store found a module in its cache. Defaults to none, for
+    /// stores that don't cache modules.
+    fn cache_stats(&self) -> Option<CacheStats> {
+        None
+    }
+
     /// Fetch all container modules for a given `Pod` storing the name of the
     /// container and the module's data as key/value pairs in a hashmap.
     ///
@@ -102,11 +110,22 @@ pub trait Store: Sync {
     }
 }
 
+/// Counts of a store's module cache lookups.
+#[derive(Clone, Copy, Debug, Default, PartialEq, Eq, Serialize)]
+pub struct CacheStats {
+    /// Modules served from the cache without pulling.
+    pub hits: u64,
+    /// Modules that had to be pulled.
+    pub misses: u64,
+}
+
 /// A `Store` implementation which obtains module data from remote registries
 /// but caches it in local storage.
 pub struct LocalStore<S: Storer, C: Client> {
     storer: Arc<RwLock<S>>,
     client: Arc<Mutex<C>>,
+    hits: AtomicU64,
+    misses: AtomicU64,
 }
 
 impl<S: Storer, C: Client> LocalStore<S, C> {
@@ -131,11 +150,13 @@ impl<S: Storer + Sync + Send, C: Client + Sync + Send> Store for LocalStore<S, C
         pull_policy: PullPolicy,
         auth: &RegistryAuth,
     ) -> anyhow::Result<Vec<u8>> {
-        match pull_policy {
+        let pulled = match pull_policy {
             PullPolicy::IfNotPresent => {
-                if !self.storer.read().await.is_present(image_ref).await {
+                let present = self.storer.read().await.is_present(image_ref).await;
+                if !present {
                     self.pull(image_ref, auth).await?
                 }
+                !present
             }
             PullPolicy::Always => {
                 let digest = self
@@ -153,11 +174,27 @@ impl<S: Storer + Sync + Send, C: Client + Sync + Send> Store for LocalStore<S, C
                 if !already_got_with_digest {
                     self.pull(image_ref, auth).await?
                 }
+                !already_got_with_digest
             }
-            PullPolicy::Never => (),
+            PullPolicy::Never => false,
         };
 
-        self.storer.read().await.get_local(image_ref).await
+        let module = self.storer.read().await.get_local(image_ref).await?;
+        // Only lookups that produced a module count, so a Never policy for a
+        // missing module is neither
+        if pulled {
+            self.misses.fetch_add(1, Ordering::Relaxed);
+        } else {
+            self.hits.fetch_add(1, Ordering::Relaxed);
+        }
+        Ok(module)
+    }
+
+    fn cache_stats(&self) -> Option<CacheStats> {
+        Some(CacheStats {
+            hits: self.hits.load(Ordering::Relaxed),
+            misses: self.misses.load(Ordering::Relaxed),
+        })
     }
 }
 
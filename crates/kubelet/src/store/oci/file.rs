@@ -27,6 +27,8 @@ impl<C: Client + Send> FileStore<C> {
                 root_dir: root_dir.as_ref().into(),
             })),
             client: Arc::new(Mutex::new(client)),
+            hits: Default::default(),
+            misses: Default::default(),
         }
     }
 }
@@ -130,6 +132,7 @@ async fn file_content_is(path: PathBuf, text: String) -> bool {
 mod test {
     use super::*;
     use crate::container::PullPolicy;
+    use crate::store::CacheStats;
     use crate::store::Store;
     use oci_distribution::client::{ImageData, ImageLayer};
     use oci_distribution::secrets::RegistryAuth;
@@ -434,4 +437,36 @@ mod test {
         assert_eq!(6, module_bytes_after[1]);
         Ok(())
     }
+
+    #[tokio::test]
+    async fn file_module_store_counts_cache_hits_and_misses() -> anyhow::Result<()> {
+        let mut fake_client =
+            FakeImageClient::new(vec![("foo/bar:1.0", vec![1, 2, 3], "sha256:123")]);
+        let fake_ref = Reference::try_from("foo/bar:1.0")?;
+        let missing_ref = Reference::try_from("foo/baz:1.0")?;
+        let scratch_dir = create_temp_dir();
+        let store = FileStore::new(fake_client.clone(), &scratch_dir.path);
+        let auth = RegistryAuth::Anonymous;
+        store
+            .get(&fake_ref, PullPolicy::IfNotPresent, &auth)
+            .await?;
+        store
+            .get(&fake_ref, PullPolicy::IfNotPresent, &auth)
+            .await?;
+        store.get(&fake_ref, PullPolicy::Always, &auth).await?;
+        store.get(&fake_ref, PullPolicy::Never, &auth).await?;
+        fake_client.update("foo/bar:1.0", vec![4, 5, 6, 7], "sha256:4567");
+        store.get(&fake_ref, PullPolicy::Always, &auth).await?;
+        // Lookups that fail count as neither
+        assert!(store
+            .get(&missing_ref, PullPolicy::Never, &auth)
+            .await
+            .is_err());
+        assert!(store
+            .get(&missing_ref, PullPolicy::IfNotPresent, &auth)
+            .await
+            .is_err());
+        assert_eq!(Some(CacheStats { hits: 3, misses: 2 }), store.cache_stats());
+        Ok(())
+    }
 }
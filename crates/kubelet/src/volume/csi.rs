@@ -0,0 +1,77 @@
+use std::time::Duration;
+
+use k8s_openapi::api::core::v1::Volume as KubeVolume;
+
+use super::*;
+
+/// The environment variable that overrides where the CSI shim publishes inline volumes
+pub const CSI_SHIM_DIR_ENV: &str = "KRUSTLET_CSI_SHIM_DIR";
+
+#[cfg(target_family = "unix")]
+const DEFAULT_CSI_SHIM_DIR: &str = "/var/lib/krustlet/csi";
+#[cfg(target_family = "windows")]
+const DEFAULT_CSI_SHIM_DIR: &str = "c:\\ProgramData\\krustlet\\csi";
+
+const READY_TIMEOUT: Duration = Duration::from_secs(120);
+const READY_POLL_INTERVAL: Duration = Duration::from_millis(500);
+
+/// A type that manages an inline CSI volume published by krustlet-csi-shim. The shim watches the
+/// pods on this node and calls the volume's CSI driver to publish it into
+/// $CSI_SHIM_DIR/$POD_UID/$VOLUME_NAME/mount, writing a `ready` file alongside once it has done so.
+/// Krustlet only waits for that directory and preopens it; the shim unpublishes the volume once the
+/// pod is gone.
+pub struct CsiVolume {
+    vol_name: String,
+    dir: PathBuf,
+    mounted_path: Option<PathBuf>,
+}
+
+impl CsiVolume {
+    /// Creates a new CSI volume from a Kubernetes volume object. Passing a non-CSI volume type
+    /// will result in an error
+    pub fn new(vol: &KubeVolume, pod: &Pod) -> anyhow::Result<Self> {
+        if vol.csi.is_none() {
+            return Err(anyhow::anyhow!(
+                "Called a CSI volume constructor with a non-CSI volume"
+            ));
+        }
+        let root = std::env::var_os(CSI_SHIM_DIR_ENV)
+            .map(PathBuf::from)
+            .unwrap_or_else(|| PathBuf::from(DEFAULT_CSI_SHIM_DIR));
+        Ok(CsiVolume {
+            vol_name: vol.name.clone(),
+            dir: root.join(pod.pod_uid()).join(&vol.name),
+            mounted_path: None,
+        })
+    }
+
+    /// Returns the path where the volume is published on the host. Will return `None` if the
+    /// volume hasn't been mounted yet
+    pub fn get_path(&self) -> Option<&Path> {
+        self.mounted_path.as_deref()
+    }
+
+    /// Waits for the CSI shim to publish the volume
+    pub async fn mount(&mut self) -> anyhow::Result<()> {
+        let ready = self.dir.join("ready");
+        let deadline = tokio::time::Instant::now() + READY_TIMEOUT;
+        while tokio::fs::metadata(&ready).await.is_err() {
+            if tokio::time::Instant::now() >= deadline {
+                return Err(anyhow::anyhow!(
+                    "timed out waiting for krustlet-csi-shim to publish volume {} at {}",
+                    self.vol_name,
+                    self.dir.display()
+                ));
+            }
+            tokio::time::sleep(READY_POLL_INTERVAL).await;
+        }
+        self.mounted_path = Some(self.dir.join("mount"));
+        Ok(())
+    }
+
+    /// Forgets the published path. The CSI shim unpublishes the volume once the pod is deleted
+    pub async fn unmount(&mut self) -> anyhow::Result<()> {
+        self.mounted_path = None;
+        Ok(())
+    }
+}
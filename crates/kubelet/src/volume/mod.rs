@@ -14,6 +14,7 @@ use crate::plugin_watcher::PluginRegistry;
 use crate::pod::Pod;
 
 mod configmap;
+mod csi;
 mod downward;
 mod hostpath;
 mod persistentvolumeclaim;
@@ -21,6 +22,7 @@ mod projected;
 mod secret;
 
 pub use configmap::ConfigMapVolume;
+pub use csi::{CsiVolume, CSI_SHIM_DIR_ENV};
 pub use downward::DownwardApiVolume;
 pub use hostpath::HostPathVolume;
 pub use persistentvolumeclaim::PvcVolume;
@@ -39,6 +41,8 @@ pub enum VolumeRef {
     Secret(SecretVolume),
     /// PVC volume
     PersistentVolumeClaim(PvcVolume),
+    /// Inline CSI volume, published by krustlet-csi-shim
+    Csi(CsiVolume),
     /// Volume specified by a device plugin
     DeviceVolume(HostPathVolume, PathBuf),
     /// hostpath volume
@@ -76,6 +80,7 @@ impl VolumeRef {
             VolumeRef::ConfigMap(cm) => cm.get_path(),
             VolumeRef::Secret(sec) => sec.get_path(),
             VolumeRef::PersistentVolumeClaim(pv) => pv.get_path(),
+            VolumeRef::Csi(csi) => csi.get_path(),
             VolumeRef::DeviceVolume(host, _) => host.get_path(),
             VolumeRef::HostPath(host) => host.get_path(),
             VolumeRef::DownwardApi(d) => d.get_path(),
@@ -89,6 +94,7 @@ impl VolumeRef {
             VolumeRef::ConfigMap(cm) => cm.mount(path).await,
             VolumeRef::Secret(sec) => sec.mount(path).await,
             VolumeRef::PersistentVolumeClaim(pv) => pv.mount(path).await,
+            VolumeRef::Csi(csi) => csi.mount().await,
             VolumeRef::DeviceVolume(host, _) => host.mount().await,
             VolumeRef::HostPath(host) => host.mount().await,
             VolumeRef::DownwardApi(d) => d.mount(path).await,
@@ -104,6 +110,7 @@ impl VolumeRef {
             VolumeRef::ConfigMap(cm) => cm.unmount().await,
             VolumeRef::Secret(sec) => sec.unmount().await,
             VolumeRef::PersistentVolumeClaim(pv) => pv.unmount().await,
+            VolumeRef::Csi(csi) => csi.unmount().await,
             VolumeRef::DeviceVolume(_, _) => Ok(()),
             // Doesn't need any unmounting steps
             VolumeRef::HostPath(_) => Ok(()),
@@ -161,6 +168,8 @@ async fn to_volume_ref(
         Ok(VolumeRef::PersistentVolumeClaim(
             PvcVolume::new(vol, pod.namespace(), client.clone(), plugin_registry).await?,
         ))
+    } else if vol.csi.is_some() {
+        Ok(VolumeRef::Csi(CsiVolume::new(vol, pod)?))
     } else if vol.host_path.is_some() {
         Ok(VolumeRef::HostPath(HostPathVolume::new(vol)?))
     } else if vol.downward_api.is_some() {
@@ -176,7 +185,7 @@ async fn to_volume_ref(
         )?))
     } else {
         Err(anyhow::anyhow!(
-            "Unsupported volume type. Currently supported types: ConfigMap, Secret, PersistentVolumeClaim, HostPath, and DownwardAPI"
+            "Unsupported volume type. Currently supported types: ConfigMap, Secret, PersistentVolumeClaim, CSI, HostPath, and DownwardAPI"
         ))
     }
 }
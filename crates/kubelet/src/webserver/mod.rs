@@ -42,7 +42,12 @@ pub(crate) async fn start<T: Provider>(
             post_exec(provider, namespace, pod, container)
         });
 
-    let routes = ping.or(health).or(logs).or(exec);
+    let stats_provider = provider.clone();
+    let module_cache = warp::get()
+        .and(warp::path!("stats" / "modulecache"))
+        .map(move || get_module_cache_stats(stats_provider.as_ref()));
+
+    let routes = ping.or(health).or(logs).or(exec).or(module_cache);
 
     warp::serve(routes)
         .tls()
@@ -102,6 +107,25 @@ async fn post_exec<T: Provider>(
     ))
 }
 
+/// Get the provider's module cache hits and misses as JSON.
+///
+/// Implements the path /stats/modulecache
+fn get_module_cache_stats<T: Provider>(provider: &T) -> Response<Body> {
+    match provider.module_cache_stats() {
+        Some(stats) => match serde_json::to_string(&stats) {
+            Ok(body) => Response::new(body.into()),
+            Err(e) => return_with_code(
+                StatusCode::INTERNAL_SERVER_ERROR,
+                format!("Server error: {}", e),
+            ),
+        },
+        None => return_with_code(
+            StatusCode::NOT_FOUND,
+            "Module cache stats not reported by provider.".to_owned(),
+        ),
+    }
+}
+
 fn return_with_code(code: StatusCode, body: String) -> Response<Body> {
     let mut response = Response::new(body.into());
     *response.status_mut() = code;
@@ -51,10 +51,11 @@ use kubelet::provider::{
     DevicePluginSupport, PluginSupport, Provider, ProviderError, VolumeSupport,
 };
 use kubelet::resources::DeviceManager;
+use kubelet::secret::credential_provider::CredentialProviders;
 use kubelet::state::common::registered::Registered;
 use kubelet::state::common::terminated::Terminated;
 use kubelet::state::common::{GenericProvider, GenericProviderState};
-use kubelet::store::Store;
+use kubelet::store::{CacheStats, Store};
 use kubelet::volume::VolumeRef;
 use tokio::sync::RwLock;
 use wasi_runtime::Runtime;
@@ -86,6 +87,7 @@ pub struct ProviderState {
     volume_path: PathBuf,
     plugin_registry: Arc<PluginRegistry>,
     device_plugin_manager: Arc<DeviceManager>,
+    credential_providers: Option<Arc<CredentialProviders>>,
 }
 
 #[async_trait]
@@ -96,6 +98,9 @@ impl GenericProviderState for ProviderState {
     fn store(&self) -> std::sync::Arc<(dyn Store + Send + Sync + 'static)> {
         self.store.clone()
     }
+    fn credential_providers(&self) -> Option<Arc<CredentialProviders>> {
+        self.credential_providers.clone()
+    }
     async fn stop(&self, pod: &Pod) -> anyhow::Result<()> {
         let key = PodKey::from(pod);
         let mut handle_writer = self.handles.write().await;
@@ -139,6 +144,7 @@ impl WasiProvider {
         tokio::fs::create_dir_all(&log_path).await?;
         tokio::fs::create_dir_all(&volume_path).await?;
         let client = kube::Client::try_from(kubeconfig)?;
+        let credential_providers = CredentialProviders::from_config(config)?.map(Arc::new);
         Ok(Self {
             shared: ProviderState {
                 handles: Default::default(),
@@ -148,6 +154,7 @@ impl WasiProvider {
                 client,
                 plugin_registry,
                 device_plugin_manager,
+                credential_providers,
             },
         })
     }
@@ -176,6 +183,11 @@ impl Provider for WasiProvider {
         builder.set_architecture("wasm-wasi");
         builder.add_taint("NoSchedule", "kubernetes.io/arch", Self::ARCH);
         builder.add_taint("NoExecute", "kubernetes.io/arch", Self::ARCH);
+        // Advertise what the runtime links into modules so schedulers can
+        // match pods to nodes that can run them. No memory limit is set, so
+        // krustlet.dev/max-memory is left off.
+        builder.add_annotation("krustlet.dev/wasi-snapshots", "wasi_snapshot_preview1");
+        builder.add_annotation("krustlet.dev/host-functions", "wasi_experimental_http");
         Ok(())
     }
 
@@ -199,6 +211,10 @@ impl Provider for WasiProvider {
         handle.output(&container_name, sender).await
     }
 
+    fn module_cache_stats(&self) -> Option<CacheStats> {
+        self.shared.store.cache_stats()
+    }
+
     // Evict all pods upon shutdown
     async fn shutdown(&self, node_name: &str) -> anyhow::Result<()> {
         node::drain(&self.shared.client, node_name).await?;
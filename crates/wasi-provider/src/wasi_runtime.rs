@@ -69,6 +69,106 @@ struct Data {
     dirs: HashMap<PathBuf, Option<PathBuf>>,
 }
 
+/// Returns the prefix of a container's log file. Runtime names are
+/// `<namespace>:<pod>:<container>`, and log files are named
+/// `<pod>_<namespace>_<container>-<random>.log`, after the kubelet's
+/// /var/log/containers, so log shippers can tell whose output a file holds.
+fn log_file_prefix(name: &str) -> String {
+    let parts: Vec<&str> = name.splitn(3, ':').collect();
+    match parts.as_slice() {
+        [namespace, pod, container] => format!("{}_{}_{}-", pod, namespace, container),
+        _ => format!("{}-", name.replace(':', "_")),
+    }
+}
+
+/// A file next to a container's log file that records where its module runs.
+/// `<name>.log` gets `<name>.stats.json`.
+///
+/// It holds the thread running the module, which the module keeps for its
+/// whole run, and the address of its linear memory. It also holds the
+/// module's arguments, environment and preopened directories.
+///
+/// The stats shim reads it to attribute CPU time and memory to the
+/// container. The exec bridge reads it to run a debugging module with the
+/// same view of the pod.
+///
+/// Only krustlet's user can read the file. It is removed when dropped.
+/// Threads can only be found on Linux, so no file is written elsewhere.
+struct StatsFile(PathBuf);
+
+impl StatsFile {
+    fn create(
+        path: PathBuf,
+        name: &str,
+        memory_address: Option<usize>,
+        data: &Data,
+    ) -> Option<Self> {
+        let parts: Vec<&str> = name.splitn(3, ':').collect();
+        let (namespace, pod, container) = match parts.as_slice() {
+            [namespace, pod, container] => (*namespace, *pod, *container),
+            _ => return None,
+        };
+        // /proc/thread-self links to /proc/<pid>/task/<tid>
+        let thread = std::fs::read_link("/proc/thread-self").ok()?;
+        let tid: u32 = thread.file_name()?.to_str()?.parse().ok()?;
+        let dirs: Vec<serde_json::Value> = data
+            .dirs
+            .iter()
+            .map(|(host, guest)| {
+                serde_json::json!({
+                    "hostPath": host,
+                    "guestPath": guest.as_ref().unwrap_or(host),
+                })
+            })
+            .collect();
+        let stats = serde_json::json!({
+            "namespace": namespace,
+            "pod": pod,
+            "container": container,
+            "pid": std::process::id(),
+            "tid": tid,
+            "memoryAddress": memory_address,
+            "startTime": chrono::Utc::now(),
+            "args": data.args,
+            // The full environment, values from Secrets included, so the exec
+            // bridge can give a debugging module the same environment as the
+            // container. This is why the file is private to krustlet's user.
+            "env": data.env,
+            "dirs": dirs,
+        });
+        if let Err(e) = write_private(&path, stats.to_string().as_bytes()) {
+            warn!(error = %e, path = %path.display(), "unable to write stats file");
+            return None;
+        }
+        Some(StatsFile(path))
+    }
+}
+
+/// Writes a file only its owner can read
+#[cfg(unix)]
+fn write_private(path: &Path, contents: &[u8]) -> std::io::Result<()> {
+    use std::io::Write;
+    use std::os::unix::fs::OpenOptionsExt;
+    std::fs::OpenOptions::new()
+        .write(true)
+        .create(true)
+        .truncate(true)
+        .mode(0o600)
+        .open(path)?
+        .write_all(contents)
+}
+
+#[cfg(not(unix))]
+fn write_private(path: &Path, contents: &[u8]) -> std::io::Result<()> {
+    std::fs::write(path, contents)
+}
+
+impl Drop for StatsFile {
+    fn drop(&mut self) {
+        let _ = std::fs::remove_file(&self.0);
+    }
+}
+
 /// Holds our tempfile handle.
 pub struct HandleFactory {
     temp: Arc<NamedTempFile>,
@@ -104,8 +204,12 @@ impl WasiRuntime {
         status_sender: Sender<Status>,
         http_config: WasiHttpConfig,
     ) -> anyhow::Result<Self> {
+        let prefix = log_file_prefix(&name);
         let temp = tokio::task::spawn_blocking(move || -> anyhow::Result<NamedTempFile> {
-            Ok(NamedTempFile::new_in(log_dir)?)
+            Ok(tempfile::Builder::new()
+                .prefix(&prefix)
+                .suffix(".log")
+                .tempfile_in(log_dir)?)
         })
         .await??;
 
@@ -295,30 +399,61 @@ impl WasiRuntime {
             }
         };
 
+        // Where the module's linear memory starts, so its resident size can be
+        // read from outside krustlet
+        let memory_address = instance
+            .get_memory(&mut store, "memory")
+            .map(|m| m.data_ptr(&store) as usize);
+        let stats_path = self.output.path().with_extension("stats.json");
+        let stats_data = data.clone();
+
         let name = self.name.clone();
         let handle = tokio::task::spawn_blocking(move || -> anyhow::Result<_> {
             let span = tracing::info_span!("wasmtime_module_run", %name);
             let _enter = span.enter();
+            // Removed when the module finishes, however it finishes
+            let _stats = StatsFile::create(stats_path, &name, memory_address, &stats_data);
 
             match func.call(&mut store, &[]) {
                 // We can't map errors here or it moves the send channel, so we
                 // do it in a match
                 Ok(_) => {}
-                Err(e) => {
-                    let message = "unable to run module";
-                    error!(error = %e, "{}", message);
-                    send(
-                        &status_sender,
-                        &name,
-                        Status::Terminated {
-                            failed: true,
-                            message: message.into(),
-                            timestamp: chrono::Utc::now(),
-                        },
-                    );
-
-                    return Err(anyhow::anyhow!("{}: {}", message, e));
-                }
+                // Modules that finish by calling `proc_exit` (which Go does
+                // even when main returns normally) surface as a trap carrying
+                // the exit status, so only a non-zero status is a failure
+                Err(e) => match exit_status(&e) {
+                    Some(0) => {}
+                    Some(code) => {
+                        let message = format!("module exited with code {}", code);
+                        error!("{}", message);
+                        send(
+                            &status_sender,
+                            &name,
+                            Status::Terminated {
+                                failed: true,
+                                message: message.clone(),
+                                timestamp: chrono::Utc::now(),
+                            },
+                        );
+
+                        return Err(anyhow::anyhow!(message));
+                    }
+                    None => {
+                        let message = "unable to run module";
+                        error!(error = %e, "{}", message);
+                        send(
+                            &status_sender,
+                            &name,
+                            Status::Terminated {
+                                failed: true,
+                                message: message.into(),
+                                timestamp: chrono::Utc::now(),
+                            },
+                        );
+
+                        return Err(anyhow::anyhow!("{}: {}", message, e));
+                    }
+                },
             };
 
             info!("module run complete");
@@ -338,6 +473,13 @@ impl WasiRuntime {
     }
 }
 
+/// Returns the exit status if the error is the trap raised by a module calling
+/// `proc_exit`
+fn exit_status(e: &anyhow::Error) -> Option<i32> {
+    e.downcast_ref::<wasmtime::Trap>()
+        .and_then(|t| t.i32_exit_status())
+}
+
 #[instrument(level = "info", skip(sender, status))]
 fn send(sender: &Sender<Status>, name: &str, status: Status) {
     match sender.blocking_send(status) {
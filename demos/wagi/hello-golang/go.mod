@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/demos/wagi/hello-golang
+
+go 1.21
@@ -0,0 +1,155 @@
+// A WAGI request handler written in Go. WAGI runs a module once per request
+// using CGI conventions: the request line and headers arrive as environment
+// variables, the request body on stdin, and the module writes headers, a blank
+// line and then the response body to stdout.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxBodyBytes caps how much of the request body is read.
+const maxBodyBytes = 1 << 20
+
+// request holds the parts of the CGI environment this handler uses.
+type request struct {
+	Method  string
+	Path    string
+	Query   url.Values
+	Headers map[string]string
+	Body    []byte
+	Form    url.Values
+}
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Hello from Go on WAGI</title></head>
+<body>
+<h1>Hello from Go on WAGI!</h1>
+<p>You sent a <code>{{.Method}}</code> request for <code>{{.Path}}</code>.</p>
+{{if .Query}}<h2>Query parameters</h2>
+<ul>{{range $k, $v := .Query}}<li><code>{{$k}}</code> = {{range $v}}<code>{{.}}</code> {{end}}</li>{{end}}</ul>
+{{end}}{{if .Form}}<h2>Form values</h2>
+<ul>{{range $k, $v := .Form}}<li><code>{{$k}}</code> = {{range $v}}<code>{{.}}</code> {{end}}</li>{{end}}</ul>
+{{else if .Body}}<h2>Body</h2>
+<pre>{{printf "%s" .Body}}</pre>
+{{end}}<h2>Headers</h2>
+<ul>{{range $k, $v := .Headers}}<li><code>{{$k}}</code>: {{$v}}</li>{{end}}</ul>
+<form method="POST"><input name="name" placeholder="Your name"> <button>Send</button></form>
+</body>
+</html>
+`))
+
+func main() {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	req, err := parseRequest()
+	if err != nil {
+		writeError(out, 400, err.Error())
+		return
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "POST":
+	default:
+		fmt.Fprintln(out, "Allow: GET, HEAD, POST")
+		writeError(out, 405, "method "+req.Method+" is not allowed")
+		return
+	}
+
+	fmt.Fprintln(out, "Content-Type: text/html; charset=utf-8")
+	fmt.Fprintln(out, "Status: 200")
+	fmt.Fprintln(out)
+	if req.Method == "HEAD" {
+		return
+	}
+	if err := page.Execute(out, req); err != nil {
+		// The headers have already been sent, so all that can be done is
+		// logging. WAGI sends stderr to its log.
+		fmt.Fprintf(os.Stderr, "unable to render page: %s\n", err)
+	}
+}
+
+// parseRequest builds a request from the CGI environment and stdin.
+func parseRequest() (*request, error) {
+	req := &request{
+		Method:  os.Getenv("REQUEST_METHOD"),
+		Path:    os.Getenv("PATH_INFO"),
+		Headers: map[string]string{},
+	}
+	if req.Method == "" {
+		req.Method = "GET"
+	}
+	if req.Path == "" {
+		req.Path = "/"
+	}
+
+	query, err := url.ParseQuery(os.Getenv("QUERY_STRING"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid query string: %w", err)
+	}
+	req.Query = query
+
+	// Request headers are passed as HTTP_<NAME> with dashes turned into
+	// underscores. Content type and length get their own variables.
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if name, ok := strings.CutPrefix(key, "HTTP_"); ok {
+			req.Headers[headerName(name)] = value
+		}
+	}
+	contentType := os.Getenv("CONTENT_TYPE")
+	if contentType != "" {
+		req.Headers["Content-Type"] = contentType
+	}
+
+	if length := os.Getenv("CONTENT_LENGTH"); length != "" && length != "0" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid content length %q", length)
+		}
+		if n > maxBodyBytes {
+			return nil, fmt.Errorf("request body is larger than %d bytes", maxBodyBytes)
+		}
+		req.Body = make([]byte, n)
+		if _, err := io.ReadFull(os.Stdin, req.Body); err != nil {
+			return nil, fmt.Errorf("unable to read request body: %w", err)
+		}
+	}
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(req.Body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form body: %w", err)
+		}
+		req.Form = form
+	}
+	return req, nil
+}
+
+// headerName turns a CGI variable suffix like ACCEPT_ENCODING back into the
+// canonical header name Accept-Encoding.
+func headerName(cgi string) string {
+	parts := strings.Split(strings.ToLower(cgi), "_")
+	for i, p := range parts {
+		if p != "" {
+			parts[i] = strings.ToUpper(p[:1]) + p[1:]
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func writeError(out io.Writer, status int, message string) {
+	fmt.Fprintln(out, "Content-Type: text/plain; charset=utf-8")
+	fmt.Fprintf(out, "Status: %d\n", status)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, message)
+}
@@ -0,0 +1,7 @@
+module github.com/krustlet/krustlet/demos/wagi/hello-sdk-golang
+
+go 1.21
+
+require github.com/krustlet/krustlet/sdk/go/wagi v0.0.0
+
+replace github.com/krustlet/krustlet/sdk/go/wagi => ../../../sdk/go/wagi
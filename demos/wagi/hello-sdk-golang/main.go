@@ -0,0 +1,80 @@
+// The hello-golang WAGI request handler written with the wagi package in
+// sdk/go/wagi, which takes care of WAGI's CGI conventions: the request line
+// and headers arrive as environment variables, the request body on stdin, and
+// the module writes headers, a blank line and then the response body to
+// stdout.
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+
+	"github.com/krustlet/krustlet/sdk/go/wagi"
+)
+
+// maxBodyBytes caps how much of the request body is read.
+const maxBodyBytes = 1 << 20
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Hello from Go on WAGI</title></head>
+<body>
+<h1>Hello from Go on WAGI!</h1>
+<p>You sent a <code>{{.Method}}</code> request for <code>{{.Path}}</code>.</p>
+{{if .Query}}<h2>Query parameters</h2>
+<ul>{{range $k, $v := .Query}}<li><code>{{$k}}</code> = {{range $v}}<code>{{.}}</code> {{end}}</li>{{end}}</ul>
+{{end}}{{if .Form}}<h2>Form values</h2>
+<ul>{{range $k, $v := .Form}}<li><code>{{$k}}</code> = {{range $v}}<code>{{.}}</code> {{end}}</li>{{end}}</ul>
+{{else if .Body}}<h2>Body</h2>
+<pre>{{printf "%s" .Body}}</pre>
+{{end}}<h2>Headers</h2>
+<ul>{{range $k, $v := .Headers}}<li><code>{{$k}}</code>: {{$v}}</li>{{end}}</ul>
+<form method="POST"><input name="name" placeholder="Your name"> <button>Send</button></form>
+</body>
+</html>
+`))
+
+func main() {
+	r := wagi.NewRouter()
+	r.HandleFunc("GET", "/*path", hello)
+	r.HandleFunc("POST", "/*path", hello)
+	wagi.Serve(r)
+}
+
+// hello renders a page describing the request.
+func hello(w wagi.ResponseWriter, r *wagi.Request) {
+	form, err := r.Form(maxBodyBytes)
+	if err != nil {
+		wagi.Error(w, err.Error(), 400)
+		return
+	}
+	var body []byte
+	if len(form) == 0 {
+		if body, err = r.ReadBody(maxBodyBytes); err != nil {
+			wagi.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = page.Execute(w, map[string]any{
+		"Method":  r.Method,
+		"Path":    r.Path,
+		"Query":   r.Query,
+		"Form":    form,
+		"Body":    body,
+		"Headers": headers,
+	})
+	if err != nil {
+		// The headers have already been sent, so all that can be done is
+		// logging. WAGI sends stderr to its log.
+		fmt.Fprintf(os.Stderr, "unable to render page: %s\n", err)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/demos/wasi/capability-probe-golang
+
+go 1.21
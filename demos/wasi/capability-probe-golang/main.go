@@ -0,0 +1,262 @@
+// A demo that tries each WASI capability a module commonly relies on (args,
+// environment, clocks, randomness, stdio and filesystem operations on
+// preopened directories) and prints a pass/fail table. It is meant to quickly
+// show which capabilities a Krustlet build and provider grant to modules.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultProbeDir matches the mountPath used in k8s.yaml. More directories can
+// be probed by setting PROBE_DIRS to a comma separated list.
+const defaultProbeDir = "/mnt/probe"
+
+// errSkip is returned by probes that couldn't run, for example because no
+// directory was configured for them.
+var errSkip = errors.New("skipped")
+
+type probe struct {
+	name string
+	run  func() (string, error)
+}
+
+func main() {
+	dirs := []string{defaultProbeDir}
+	if v := os.Getenv("PROBE_DIRS"); v != "" {
+		dirs = strings.Split(v, ",")
+	}
+
+	probes := []probe{
+		{"args", probeArgs},
+		{"environ", probeEnviron},
+		{"clock: wall", probeWallClock},
+		{"clock: monotonic", probeMonotonicClock},
+		{"clock: sleep", probeSleep},
+		{"random", probeRandom},
+		{"fd: stdout", probeStdout},
+		{"fd: stderr", probeStderr},
+		{"fd: stdin", probeStdin},
+	}
+	for _, dir := range dirs {
+		dir := dir
+		probes = append(probes,
+			probe{"fs: stat " + dir, func() (string, error) { return probeStat(dir) }},
+			probe{"fs: readdir " + dir, func() (string, error) { return probeReadDir(dir) }},
+			probe{"fs: write/read/seek " + dir, func() (string, error) { return probeReadWrite(dir) }},
+			probe{"fs: mkdir/rename/remove " + dir, func() (string, error) { return probeDirOps(dir) }},
+		)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CAPABILITY\tRESULT\tDETAIL")
+	failed := 0
+	for _, p := range probes {
+		result := "PASS"
+		detail, err := p.run()
+		switch {
+		case errors.Is(err, errSkip):
+			result = "SKIP"
+		case err != nil:
+			result = "FAIL"
+			detail = err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.name, result, detail)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d of %d probes failed\n", failed, len(probes))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func probeArgs() (string, error) {
+	if len(os.Args) == 0 {
+		return "", errors.New("no args, not even the program name")
+	}
+	return fmt.Sprintf("%d args: %q", len(os.Args), os.Args), nil
+}
+
+func probeEnviron() (string, error) {
+	// An empty environment is valid, so this only checks that the call works
+	return fmt.Sprintf("%d variables", len(os.Environ())), nil
+}
+
+func probeWallClock() (string, error) {
+	now := time.Now()
+	// Anything before this demo was written means the clock isn't real
+	if now.Year() < 2021 {
+		return "", fmt.Errorf("wall clock reports %s", now.UTC().Format(time.RFC3339))
+	}
+	return now.UTC().Format(time.RFC3339Nano), nil
+}
+
+func probeMonotonicClock() (string, error) {
+	start := time.Now()
+	var elapsed time.Duration
+	// Spin briefly so a clock with coarse resolution still has a chance to
+	// advance
+	for i := 0; i < 1_000_000 && elapsed == 0; i++ {
+		elapsed = time.Since(start)
+	}
+	if elapsed <= 0 {
+		return "", errors.New("monotonic clock did not advance")
+	}
+	return fmt.Sprintf("advanced %s", elapsed), nil
+}
+
+func probeSleep() (string, error) {
+	const want = 50 * time.Millisecond
+	start := time.Now()
+	time.Sleep(want)
+	got := time.Since(start)
+	if got < want {
+		return "", fmt.Errorf("slept %s, wanted at least %s", got, want)
+	}
+	return fmt.Sprintf("slept %s", got.Round(time.Millisecond)), nil
+}
+
+func probeRandom() (string, error) {
+	a, b := make([]byte, 32), make([]byte, 32)
+	if _, err := rand.Read(a); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	if bytes.Equal(a, b) {
+		return "", errors.New("two reads returned the same bytes")
+	}
+	return fmt.Sprintf("read %d bytes", len(a)+len(b)), nil
+}
+
+func probeStdout() (string, error) {
+	return probeWritable(os.Stdout)
+}
+
+func probeStderr() (string, error) {
+	return probeWritable(os.Stderr)
+}
+
+func probeWritable(f *os.File) (string, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("unable to stat: %w", err)
+	}
+	if _, err := f.Write(nil); err != nil {
+		return "", fmt.Errorf("unable to write: %w", err)
+	}
+	return fmt.Sprintf("mode %s", info.Mode()), nil
+}
+
+func probeStdin() (string, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return "", fmt.Errorf("unable to stat: %w", err)
+	}
+	// Reading would block forever if stdin is attached to something, so only
+	// read when it can't be a terminal or pipe waiting for input
+	if info.Mode()&(os.ModeCharDevice|os.ModeNamedPipe) != 0 {
+		return fmt.Sprintf("mode %s, not read", info.Mode()), nil
+	}
+	n, err := os.Stdin.Read(make([]byte, 1))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("unable to read: %w", err)
+	}
+	return fmt.Sprintf("mode %s, read %d bytes", info.Mode(), n), nil
+}
+
+func probeStat(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if notMounted(err) {
+		return "not preopened or mounted", errSkip
+	} else if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return fmt.Sprintf("mode %s", info.Mode()), nil
+}
+
+func probeReadDir(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "requires stat", errSkip
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d entries", len(entries)), nil
+}
+
+func probeReadWrite(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "requires stat", errSkip
+	}
+	path := filepath.Join(dir, ".capability-probe")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", fmt.Errorf("unable to create file: %w", err)
+	}
+	defer os.Remove(path)
+	defer f.Close()
+
+	want := []byte("capability probe")
+	if _, err := f.Write(want); err != nil {
+		return "", fmt.Errorf("unable to write: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return "", fmt.Errorf("unable to sync: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("unable to seek: %w", err)
+	}
+	got, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("unable to read: %w", err)
+	}
+	if !bytes.Equal(got, want) {
+		return "", fmt.Errorf("read back %q, wanted %q", got, want)
+	}
+	return fmt.Sprintf("round tripped %d bytes", len(got)), nil
+}
+
+func probeDirOps(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "requires stat", errSkip
+	}
+	sub := filepath.Join(dir, ".capability-probe-dir")
+	renamed := sub + "-renamed"
+	if err := os.Mkdir(sub, 0755); err != nil {
+		return "", fmt.Errorf("unable to mkdir: %w", err)
+	}
+	if err := os.Rename(sub, renamed); err != nil {
+		os.Remove(sub)
+		return "", fmt.Errorf("unable to rename: %w", err)
+	}
+	if err := os.Remove(renamed); err != nil {
+		return "", fmt.Errorf("unable to remove: %w", err)
+	}
+	return "ok", nil
+}
+
+// notMounted reports whether err means a path isn't under any preopened
+// directory. Depending on the runtime that is either a missing file or a bad
+// file descriptor, since there is no preopen to resolve the path against.
+func notMounted(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EBADF)
+}
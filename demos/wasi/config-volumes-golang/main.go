@@ -0,0 +1,105 @@
+// A demo that reads configuration projected into the module by ConfigMap and
+// Secret volumes. Every key in the volume shows up as a file under the
+// volume's mountPath, so configuration is just a matter of reading files.
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// These match the mountPaths used in k8s.yaml and can be overridden with the
+// CONFIG_DIR and SECRET_DIR environment variables.
+const (
+	defaultConfigDir = "/etc/demo/config"
+	defaultSecretDir = "/etc/demo/secret"
+)
+
+func main() {
+	configDir := envOr("CONFIG_DIR", defaultConfigDir)
+	secretDir := envOr("SECRET_DIR", defaultSecretDir)
+
+	failed := false
+	fmt.Printf("ConfigMap values from %s:\n", configDir)
+	if err := dump(configDir, false); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		failed = true
+	}
+
+	fmt.Printf("\nSecret values from %s:\n", secretDir)
+	if err := dump(secretDir, true); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// dump prints every file found under dir as a key/value pair, where the key is
+// the path relative to dir. If mask is true only the size of each value is
+// printed.
+func dump(dir string, mask bool) error {
+	count := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// Upstream kubelet uses hidden "..data" style entries for atomic
+		// updates. Skip them so the demo prints the same thing on either kind
+		// of node.
+		if path != dir && strings.HasPrefix(d.Name(), "..") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", path, err)
+		}
+		key, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		count++
+		if mask {
+			fmt.Printf("  %s=%s\n", key, redact(data))
+			return nil
+		}
+		value := strings.TrimRight(string(data), "\n")
+		if strings.Contains(value, "\n") {
+			fmt.Printf("  %s=\n    %s\n", key, strings.ReplaceAll(value, "\n", "\n    "))
+		} else {
+			fmt.Printf("  %s=%s\n", key, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read values from %s: %w", dir, err)
+	}
+	if count == 0 {
+		fmt.Println("  (no values found)")
+	}
+	return nil
+}
+
+// redact returns a placeholder for a secret value that only reveals its size.
+func redact(data []byte) string {
+	return fmt.Sprintf("******** (%d bytes)", len(data))
+}
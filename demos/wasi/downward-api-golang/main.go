@@ -0,0 +1,130 @@
+// A demo that reports everything the Downward API exposed to the module, both
+// as environment variables and as files in a downwardAPI volume, as a single
+// JSON document. Because missing fields are reported explicitly, the output
+// doubles as a probe of which Downward API features a provider supports.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// envPrefix selects which environment variables are reported. The
+	// manifest names every Downward API variable with this prefix.
+	envPrefix = "PODINFO_"
+	// defaultPodInfoDir matches the mountPath used in k8s.yaml and can be
+	// overridden with the PODINFO_DIR environment variable.
+	defaultPodInfoDir = "/etc/podinfo"
+)
+
+// report is the document written to stdout.
+type report struct {
+	// Env holds every non-empty environment variable with envPrefix.
+	Env map[string]string `json:"env"`
+	// Files holds the contents of each file in the downwardAPI volume. Label
+	// and annotation files are decoded into objects, everything else is a
+	// string.
+	Files map[string]interface{} `json:"files"`
+	// EmptyEnv lists the environment variables that were set but empty,
+	// which is how unsupported fieldRef and resourceFieldRef values show up.
+	EmptyEnv []string `json:"emptyEnv"`
+	// Errors holds any problems reading the volume.
+	Errors []string `json:"errors,omitempty"`
+}
+
+func main() {
+	dir := os.Getenv("PODINFO_DIR")
+	if dir == "" {
+		dir = defaultPodInfoDir
+	}
+
+	r := report{
+		Env:      map[string]string{},
+		Files:    map[string]interface{}{},
+		EmptyEnv: []string{},
+	}
+
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, envPrefix) || key == "PODINFO_DIR" {
+			continue
+		}
+		if value == "" {
+			r.EmptyEnv = append(r.EmptyEnv, key)
+			continue
+		}
+		r.Env[key] = value
+	}
+	sort.Strings(r.EmptyEnv)
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), "..") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			r.Errors = append(r.Errors, err.Error())
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if m, ok := parseKeyValues(string(data)); ok {
+			r.Files[name] = m
+		} else {
+			r.Files[name] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		r.Errors = append(r.Errors, err.Error())
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r); err != nil {
+		fmt.Fprintf(os.Stderr, "error: unable to encode report: %s\n", err)
+		os.Exit(1)
+	}
+	if len(r.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// parseKeyValues decodes the `key="value"` per line format used for the labels
+// and annotations files. It returns false if data isn't in that format.
+func parseKeyValues(data string) (map[string]string, bool) {
+	if data == "" {
+		return nil, false
+	}
+	m := map[string]string{}
+	for _, line := range strings.Split(strings.TrimRight(data, "\n"), "\n") {
+		key, quoted, found := strings.Cut(line, "=")
+		if !found || !strings.HasPrefix(quoted, `"`) {
+			return nil, false
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, false
+		}
+		m[key] = value
+	}
+	return m, true
+}
@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/demos/wasi/exit-code-golang
+
+go 1.21
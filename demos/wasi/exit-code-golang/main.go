@@ -0,0 +1,93 @@
+// A demo whose exit code and run time are controlled by flags or environment
+// variables, for checking how a provider reports terminal pod phases, restart
+// policies and crash loops.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	exitCode := flag.Int("exit-code", envInt("EXIT_CODE", 0), "exit code to finish with (env EXIT_CODE)")
+	duration := flag.Duration("duration", envDuration("RUN_DURATION", 0), "how long to run before exiting (env RUN_DURATION)")
+	panics := flag.Bool("panic", envBool("PANIC", false), "panic instead of exiting cleanly (env PANIC)")
+	flag.CommandLine.Parse(flagArgs())
+
+	if *panics {
+		fmt.Printf("running for %s, then panicking\n", *duration)
+	} else {
+		fmt.Printf("running for %s, then exiting with code %d\n", *duration, *exitCode)
+	}
+
+	// Print a heartbeat every second so the pod has some log output while it
+	// runs
+	start := time.Now()
+	for elapsed := time.Duration(0); elapsed < *duration; elapsed = time.Since(start) {
+		step := time.Second
+		if remaining := *duration - elapsed; remaining < step {
+			step = remaining
+		}
+		time.Sleep(step)
+		fmt.Printf("still running (%s of %s)\n", time.Since(start).Round(100*time.Millisecond), *duration)
+	}
+
+	if *panics {
+		panic("panicking as requested")
+	}
+	fmt.Printf("exiting with code %d\n", *exitCode)
+	os.Exit(*exitCode)
+}
+
+func envInt(key string, fallback int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s %q: %s\n", key, v, err)
+		os.Exit(2)
+	}
+	return i
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s %q: %s\n", key, v, err)
+		os.Exit(2)
+	}
+	return d
+}
+
+func envBool(key string, fallback bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s %q: %s\n", key, v, err)
+		os.Exit(2)
+	}
+	return b
+}
+
+// flagArgs returns the arguments to parse as flags. Krustlet passes a
+// container's args to the module as-is, without a program name in front, so
+// the first argument is only skipped when it isn't a flag.
+func flagArgs() []string {
+	if len(os.Args) > 0 && !strings.HasPrefix(os.Args[0], "-") {
+		return os.Args[1:]
+	}
+	return os.Args
+}
@@ -0,0 +1,101 @@
+// A simple demo that exercises volumes mounted into a WASI module by the
+// wasi-provider. Every directory listed in the pod's volumeMounts is preopened
+// for the module, so ordinary file operations on the mount path work as they
+// would on the host.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultDataDir matches the mountPath used in k8s.yaml.
+const defaultDataDir = "/mnt/data"
+
+func main() {
+	dir := os.Getenv("DATA_DIR")
+	if dir == "" {
+		dir = defaultDataDir
+	}
+
+	if err := run(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string) error {
+	fmt.Printf("Contents of %s before writing:\n", dir)
+	if err := list(dir); err != nil {
+		return err
+	}
+
+	// Write a file, replacing it if it exists, and read it back
+	greeting := filepath.Join(dir, "greeting.txt")
+	if err := os.WriteFile(greeting, []byte("hello from file-io-golang!\n"), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %w", greeting, err)
+	}
+	data, err := os.ReadFile(greeting)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", greeting, err)
+	}
+	fmt.Printf("\nRead back %s: %s", greeting, data)
+
+	// Append to a log file so that restarts of the pod accumulate entries
+	logPath := filepath.Join(dir, "runs.log")
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", logPath, err)
+	}
+	entry := fmt.Sprintf("%s run by pod %q\n", time.Now().UTC().Format(time.RFC3339), os.Getenv("POD_NAME"))
+	if _, err := f.WriteString(entry); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to append to %s: %w", logPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to close %s: %w", logPath, err)
+	}
+
+	fmt.Printf("\nContents of %s:\n", logPath)
+	f, err = os.Open(logPath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", logPath, err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fmt.Printf("  %s\n", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read %s: %w", logPath, err)
+	}
+
+	fmt.Printf("\nContents of %s after writing:\n", dir)
+	return list(dir)
+}
+
+// list prints each entry in dir along with its size.
+func list(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("unable to list %s: %w", dir, err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("  (empty)")
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("unable to stat %s: %w", entry.Name(), err)
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		fmt.Printf("  %-24s %8d bytes\n", name, info.Size())
+	}
+	return nil
+}
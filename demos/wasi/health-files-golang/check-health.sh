@@ -0,0 +1,22 @@
+#!/usr/bin/env sh
+# Checks that a file written by the health-files-golang demo was updated
+# recently. This is the same check the probes in k8s.yaml run, and can be used
+# directly on the node against the hostPath directory.
+#
+# Usage: check-health.sh <file> [max age in seconds]
+set -eu
+
+file="$1"
+max_age="${2:-15}"
+
+if [ ! -f "$file" ]; then
+  echo "$file does not exist" >&2
+  exit 1
+fi
+
+age=$(( $(date +%s) - $(cat "$file") ))
+if [ "$age" -ge "$max_age" ]; then
+  echo "$file is ${age}s old, older than ${max_age}s" >&2
+  exit 1
+fi
+echo "$file is ${age}s old"
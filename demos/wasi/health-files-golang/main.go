@@ -0,0 +1,111 @@
+// A demo that periodically touches "healthz" and "ready" files in a mounted
+// directory, writing the current Unix time into each, so that liveness and
+// readiness can be checked by looking at how fresh the files are. The module
+// can be told through the environment to stop updating either file after a
+// while, to simulate a hung or unready workload.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+func main() {
+	dir := envOr("HEALTH_DIR", "/mnt/health")
+	interval := envDuration("TOUCH_INTERVAL", 5*time.Second)
+	readyDelay := envDuration("READY_DELAY", 10*time.Second)
+	// Zero means keep updating forever
+	healthzStop := envDuration("HEALTHZ_STOP_AFTER", 0)
+	readyStop := envDuration("READY_STOP_AFTER", 0)
+
+	healthz := filepath.Join(dir, "healthz")
+	ready := filepath.Join(dir, "ready")
+	// Start out unready and unhealthy until the first touch
+	for _, f := range []string{healthz, ready} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			fail("unable to remove stale %s: %s", f, err)
+		}
+	}
+
+	fmt.Printf("touching %s every %s, ready after %s\n", dir, interval, readyDelay)
+	start := time.Now()
+	var healthzStopped, readyStopped bool
+	for {
+		elapsed := time.Since(start)
+
+		if healthzStop > 0 && elapsed >= healthzStop {
+			if !healthzStopped {
+				fmt.Printf("%s stopped updating healthz as requested\n", timestamp())
+				healthzStopped = true
+			}
+		} else if err := touch(healthz); err != nil {
+			fail("unable to touch healthz: %s", err)
+		}
+
+		if readyStop > 0 && elapsed >= readyStop {
+			if !readyStopped {
+				fmt.Printf("%s stopped updating ready as requested\n", timestamp())
+				readyStopped = true
+			}
+		} else if elapsed >= readyDelay {
+			if err := touch(ready); err != nil {
+				fail("unable to touch ready: %s", err)
+			}
+		}
+
+		fmt.Printf("%s healthz=%s ready=%s\n", timestamp(), state(healthzStopped, true), state(readyStopped, elapsed >= readyDelay))
+		time.Sleep(interval)
+	}
+}
+
+func state(stopped, started bool) string {
+	switch {
+	case stopped:
+		return "stale"
+	case !started:
+		return "pending"
+	default:
+		return "fresh"
+	}
+}
+
+// touch writes the current Unix time to path through a temporary file, so a
+// probe never reads a partially written value.
+func touch(path string) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(time.Now().Unix(), 10)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func timestamp() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fail("invalid %s %q: %s", key, v, err)
+	}
+	return d
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/demos/wasi/http-golang
+
+go 1.21
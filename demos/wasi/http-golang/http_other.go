@@ -0,0 +1,12 @@
+//go:build !wasip1
+
+package main
+
+import "errors"
+
+// do is only implemented for wasip1, where the host provides the
+// wasi-experimental-http functions. This lets the rest of the demo build and
+// vet natively.
+func do(method, url string, headers map[string]string, body []byte) (*response, error) {
+	return nil, errors.New("outbound HTTP is only available when built with GOOS=wasip1 and run by a host providing wasi-experimental-http")
+}
@@ -0,0 +1,62 @@
+// A demo that makes an outbound HTTPS request through the experimental HTTP
+// host functions (wasi-experimental-http) linked in by the wasi-provider, and
+// reports the response status, some headers and how long it took.
+//
+// The host only allows requests to domains listed in the pod's
+// alpha.wasi.krustlet.dev/allowed-domains annotation.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const defaultURL = "https://postman-echo.com/get?source=krustlet"
+
+// response is the result of a request made through the host.
+type response struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       []byte
+}
+
+func main() {
+	url := os.Getenv("REQUEST_URL")
+	if url == "" {
+		url = defaultURL
+	}
+	method := os.Getenv("REQUEST_METHOD")
+	if method == "" {
+		method = "GET"
+	}
+
+	fmt.Printf("%s %s\n", method, url)
+	start := time.Now()
+	res, err := do(method, url, map[string]string{"User-Agent": "krustlet-http-golang"}, nil)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "request failed after %s: %s\n", elapsed.Round(time.Millisecond), err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("status: %d\n", res.StatusCode)
+	fmt.Printf("latency: %s\n", elapsed.Round(time.Millisecond))
+	for _, name := range []string{"content-type", "content-length", "server"} {
+		if v, ok := res.Headers[name]; ok {
+			fmt.Printf("%s: %s\n", name, v)
+		}
+	}
+	fmt.Printf("body (%d bytes):\n%s\n", len(res.Body), truncate(res.Body, 1024))
+
+	if res.StatusCode >= 400 {
+		os.Exit(1)
+	}
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) <= n {
+		return string(b)
+	}
+	return string(b[:n]) + "..."
+}
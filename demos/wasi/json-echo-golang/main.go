@@ -0,0 +1,86 @@
+// A demo that writes everything the module was started with (args,
+// environment, preopened directories and working directory) to stdout as a
+// single JSON document. Test harnesses can parse the output and assert on
+// fields instead of scraping free-form text.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxEntries caps how many directory entries are listed for each mount.
+const maxEntries = 100
+
+type document struct {
+	Args       []string          `json:"args"`
+	Env        map[string]string `json:"env"`
+	Mounts     []mount           `json:"mounts"`
+	WorkingDir string            `json:"workingDir"`
+}
+
+type mount struct {
+	Path string `json:"path"`
+	// Entries holds the names of the entries in the mount, with a trailing
+	// slash for directories, sorted and capped at maxEntries.
+	Entries   []string `json:"entries"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+func main() {
+	doc := document{
+		Args:   os.Args,
+		Env:    map[string]string{},
+		Mounts: []mount{},
+	}
+	if doc.Args == nil {
+		doc.Args = []string{}
+	}
+
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		doc.Env[key] = value
+	}
+
+	dirs := preopens()
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		doc.Mounts = append(doc.Mounts, describe(dir))
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		doc.WorkingDir = wd
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to encode document: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func describe(dir string) mount {
+	m := mount{Path: dir, Entries: []string{}}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		m.Error = err.Error()
+		return m
+	}
+	for i, e := range entries {
+		if i == maxEntries {
+			m.Truncated = true
+			break
+		}
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		m.Entries = append(m.Entries, name)
+	}
+	return m
+}
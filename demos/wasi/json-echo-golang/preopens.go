@@ -0,0 +1,48 @@
+//go:build wasip1
+
+package main
+
+import "unsafe"
+
+// WASI has no call to list preopened directories, but they are handed to the
+// module as consecutive file descriptors starting after stdio. Walking them
+// with fd_prestat_get until it fails is how WASI libc discovers them too.
+
+//go:wasmimport wasi_snapshot_preview1 fd_prestat_get
+func fdPrestatGet(fd int32, prestat unsafe.Pointer) uint32
+
+//go:wasmimport wasi_snapshot_preview1 fd_prestat_dir_name
+func fdPrestatDirName(fd int32, path unsafe.Pointer, pathLen uint32) uint32
+
+// prestat mirrors the __wasi_prestat_t struct. Directories are the only kind
+// of preopen defined so far, so the tag is always 0.
+type prestat struct {
+	tag     uint8
+	_       [3]byte
+	nameLen uint32
+}
+
+// preopens returns the guest paths of every preopened directory.
+func preopens() []string {
+	const firstPreopenFd = 3
+	var dirs []string
+	for fd := int32(firstPreopenFd); ; fd++ {
+		var p prestat
+		if errno := fdPrestatGet(fd, unsafe.Pointer(&p)); errno != 0 {
+			break
+		}
+		if p.tag != 0 {
+			continue
+		}
+		name := make([]byte, p.nameLen)
+		if errno := fdPrestatDirName(fd, unsafe.Pointer(unsafe.SliceData(name)), p.nameLen); errno != 0 {
+			continue
+		}
+		// Some runtimes include a trailing NUL in the name
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		dirs = append(dirs, string(name))
+	}
+	return dirs
+}
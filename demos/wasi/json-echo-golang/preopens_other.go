@@ -0,0 +1,9 @@
+//go:build !wasip1
+
+package main
+
+// preopens is only meaningful under WASI. Natively every directory is
+// reachable, so there is nothing to report.
+func preopens() []string {
+	return nil
+}
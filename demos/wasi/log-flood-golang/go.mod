@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/demos/wasi/log-flood-golang
+
+go 1.21
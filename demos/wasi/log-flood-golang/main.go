@@ -0,0 +1,121 @@
+// A demo that writes a configurable amount of log output at a configurable
+// rate, for stressing a provider's log capture path. Each line is numbered so
+// dropped or reordered output can be spotted, and a summary is written to
+// stderr once all of the output has been written.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minLineBytes is the size of the "<20 digit sequence> " prefix plus the
+// trailing newline. Anything shorter can't hold a sequence number.
+const minLineBytes = 22
+
+func main() {
+	// Megabytes of output to write, and how fast to write it. A rate of 0
+	// writes as fast as possible.
+	total := env("FLOOD_MEGABYTES", 10.0, parseFloat)
+	rate := env("FLOOD_RATE", 0.0, parseFloat)
+	// The size of each line in bytes, including the newline
+	lineBytes := env("FLOOD_LINE_BYTES", 128, strconv.Atoi)
+	// Keep running after writing so memory use can be inspected
+	hold := env("FLOOD_HOLD", false, strconv.ParseBool)
+
+	if lineBytes < minLineBytes {
+		fmt.Fprintf(os.Stderr, "FLOOD_LINE_BYTES must be at least %d\n", minLineBytes)
+		os.Exit(1)
+	}
+
+	totalBytes := int64(total * 1024 * 1024)
+	fmt.Fprintf(os.Stderr, "writing %d bytes in %d byte lines", totalBytes, lineBytes)
+	if rate > 0 {
+		fmt.Fprintf(os.Stderr, " at %.2f MB/s\n", rate)
+	} else {
+		fmt.Fprintln(os.Stderr, " as fast as possible")
+	}
+
+	start := time.Now()
+	written, lines, err := flood(totalBytes, lineBytes, rate)
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error after %d bytes: %s\n", written, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes in %d lines in %s (%.2f MB/s)\n",
+		written, lines, elapsed.Round(time.Millisecond), float64(written)/1024/1024/elapsed.Seconds())
+
+	if hold {
+		fmt.Fprintln(os.Stderr, "holding, delete the pod to stop")
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+}
+
+// flood writes numbered lines of lineBytes each to stdout until total bytes
+// have been written, sleeping as needed to stay under rate megabytes per
+// second.
+func flood(total int64, lineBytes int, rate float64) (int64, int64, error) {
+	out := bufio.NewWriterSize(os.Stdout, 64*1024)
+	padding := strings.Repeat("x", lineBytes-minLineBytes)
+	bytesPerSecond := rate * 1024 * 1024
+
+	start := time.Now()
+	var written, lines int64
+	for written < total {
+		size := lineBytes
+		if remaining := total - written; remaining < int64(size) {
+			// The last line is cut short, but it is still numbered as long
+			// as it has room
+			size = int(remaining)
+		}
+		line := fmt.Sprintf("%020d %s\n", lines+1, padding)
+		if size < len(line) {
+			line = line[:size-1] + "\n"
+		}
+		n, err := out.WriteString(line)
+		written += int64(n)
+		if err != nil {
+			return written, lines, err
+		}
+		lines++
+
+		// Flush and wait at every buffer's worth of output so the rate is
+		// smooth rather than bursty
+		if bytesPerSecond > 0 && out.Buffered() >= out.Size()-lineBytes {
+			if err := out.Flush(); err != nil {
+				return written, lines, err
+			}
+			expected := time.Duration(float64(written) / bytesPerSecond * float64(time.Second))
+			if ahead := expected - time.Since(start); ahead > 0 {
+				time.Sleep(ahead)
+			}
+		}
+	}
+	return written, lines, out.Flush()
+}
+
+// env returns the value of the environment variable key as parsed by parse,
+// or fallback if it isn't set
+func env[T any](key string, fallback T, parse func(string) (T, error)) T {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	parsed, err := parse(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s %q: %s\n", key, v, err)
+		os.Exit(1)
+	}
+	return parsed
+}
+
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,66 @@
+// A demo that writes a timestamped line with a sequence number on a fixed
+// interval, for checking `kubectl logs --follow`, `--tail` and `--since`
+// against a provider. Because every line carries its own sequence number and
+// timestamp, gaps, duplicates and out-of-range lines are easy to spot.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+func main() {
+	interval := time.Second
+	if v := os.Getenv("LOG_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			invalid("LOG_INTERVAL", v, err)
+		}
+		interval = d
+	}
+	// count is the number of lines to write before exiting, 0 to run forever
+	count := envInt("LOG_COUNT")
+	// stderrEvery also writes every Nth line to stderr, 0 to disable
+	stderrEvery := envInt("LOG_STDERR_EVERY")
+
+	if interval <= 0 {
+		fmt.Fprintln(os.Stderr, "LOG_INTERVAL must be greater than 0")
+		os.Exit(1)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for seq := 1; count == 0 || seq <= count; seq++ {
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		fmt.Printf("%s seq=%d stream=stdout\n", now, seq)
+		if stderrEvery > 0 && seq%stderrEvery == 0 {
+			fmt.Fprintf(os.Stderr, "%s seq=%d stream=stderr\n", now, seq)
+		}
+		if count != 0 && seq == count {
+			break
+		}
+		<-ticker.C
+	}
+}
+
+// envInt returns the integer in the environment variable key, or 0 if it isn't
+// set
+func envInt(key string) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		invalid(key, v, err)
+	}
+	return i
+}
+
+// invalid reports a malformed environment variable and exits
+func invalid(key, value string, err error) {
+	fmt.Fprintf(os.Stderr, "invalid %s %q: %s\n", key, value, err)
+	os.Exit(1)
+}
@@ -0,0 +1,128 @@
+// A demo of a long-running module that does a unit of work on an interval and
+// shuts down cleanly when asked to. Progress is checkpointed to a mounted
+// directory after every unit of work so that a restarted pod picks up where
+// the previous one left off, even if it was stopped without warning.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultStateDir matches the mountPath used in k8s.yaml.
+	defaultStateDir = "/mnt/state"
+	checkpointFile  = "checkpoint"
+	sentinelFile    = "shutdown"
+)
+
+func main() {
+	stateDir := os.Getenv("STATE_DIR")
+	if stateDir == "" {
+		stateDir = defaultStateDir
+	}
+	interval := 2 * time.Second
+	if v := os.Getenv("WORK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid WORK_INTERVAL %q: %s\n", v, err)
+			os.Exit(1)
+		}
+		interval = d
+	}
+
+	if err := run(stateDir, interval); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(stateDir string, interval time.Duration) error {
+	checkpoint := filepath.Join(stateDir, checkpointFile)
+	sentinel := filepath.Join(stateDir, sentinelFile)
+
+	count, err := readCheckpoint(checkpoint)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("starting at unit %d, working every %s\n", count, interval)
+	fmt.Printf("create %s to request a graceful shutdown\n", sentinel)
+
+	// WASI has no signals, so this only fires when the module is run natively.
+	// It is kept so the same code does the right thing in both places.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case sig := <-signals:
+			return shutdown(fmt.Sprintf("received signal %s", sig), checkpoint, sentinel, count)
+		case <-ticker.C:
+		}
+
+		if _, err := os.Stat(sentinel); err == nil {
+			return shutdown("found "+sentinel, checkpoint, sentinel, count)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unable to check for %s: %w", sentinel, err)
+		}
+
+		count++
+		if err := writeCheckpoint(checkpoint, count); err != nil {
+			return err
+		}
+		fmt.Printf("%s completed unit %d\n", time.Now().UTC().Format(time.RFC3339), count)
+	}
+}
+
+// shutdown logs each step of a graceful shutdown and removes the sentinel so
+// that the next run of the pod doesn't stop immediately.
+func shutdown(reason, checkpoint, sentinel string, count int) error {
+	fmt.Printf("shutdown requested: %s\n", reason)
+	fmt.Println("shutdown: no longer accepting new work")
+	fmt.Printf("shutdown: saving final checkpoint at unit %d\n", count)
+	if err := writeCheckpoint(checkpoint, count); err != nil {
+		return err
+	}
+	if err := os.Remove(sentinel); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to remove %s: %w", sentinel, err)
+	}
+	fmt.Println("shutdown: complete")
+	return nil
+}
+
+func readCheckpoint(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("unable to read checkpoint: %w", err)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("checkpoint %s is corrupt: %w", path, err)
+	}
+	return count, nil
+}
+
+// writeCheckpoint writes to a temporary file and renames it into place, so the
+// checkpoint is never left half written if the module is stopped mid-write.
+func writeCheckpoint(path string, count int) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(count)+"\n"), 0644); err != nil {
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to write checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// A demo that allocates memory in steps while reporting progress, for testing
+// how memory limits are enforced on a module and what the failure looks like.
+// Every allocated page is written to so the host can't leave it unbacked.
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const mib = 1024 * 1024
+
+func main() {
+	// MiB to allocate per step and in total. A total of 0 keeps going until
+	// allocation fails.
+	step := env("MEMORY_STEP_MB", 16, strconv.Atoi)
+	total := env("MEMORY_TOTAL_MB", 256, strconv.Atoi)
+	interval := env("MEMORY_INTERVAL", time.Second, time.ParseDuration)
+	// Keep running with the memory allocated once done
+	hold := env("MEMORY_HOLD", true, strconv.ParseBool)
+
+	if step <= 0 {
+		fmt.Fprintln(os.Stderr, "MEMORY_STEP_MB must be greater than 0")
+		os.Exit(1)
+	}
+
+	target := "until allocation fails"
+	if total > 0 {
+		target = fmt.Sprintf("up to %d MiB", total)
+	}
+	fmt.Printf("allocating %d MiB every %s, %s\n", step, interval, target)
+
+	// Keep every chunk reachable so the garbage collector can't free it
+	var chunks [][]byte
+	allocated := 0
+	pageSize := os.Getpagesize()
+	for total == 0 || allocated < total {
+		size := step
+		if total > 0 && allocated+size > total {
+			size = total - allocated
+		}
+		chunk := make([]byte, size*mib)
+		for i := 0; i < len(chunk); i += pageSize {
+			chunk[i] = 1
+		}
+		chunks = append(chunks, chunk)
+		allocated += size
+
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		fmt.Printf("%s allocated %d MiB (heap %d MiB, from host %d MiB)\n",
+			time.Now().UTC().Format(time.RFC3339), allocated, stats.HeapAlloc/mib, stats.Sys/mib)
+		time.Sleep(interval)
+	}
+
+	fmt.Printf("finished allocating %d MiB in %d chunks\n", allocated, len(chunks))
+	if hold {
+		fmt.Println("holding, delete the pod to stop")
+		for {
+			time.Sleep(time.Hour)
+			runtime.KeepAlive(chunks)
+		}
+	}
+	runtime.KeepAlive(chunks)
+}
+
+// env returns the value of the environment variable key as parsed by parse,
+// or fallback if it isn't set
+func env[T any](key string, fallback T, parse func(string) (T, error)) T {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	parsed, err := parse(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s %q: %s\n", key, v, err)
+		os.Exit(1)
+	}
+	return parsed
+}
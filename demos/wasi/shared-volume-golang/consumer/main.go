@@ -0,0 +1,125 @@
+// The consumer half of the shared volume demo. It waits for the producer to
+// start a run on the shared volume, then follows the run directory and prints
+// each message file as it appears, in order, until the run is marked as done.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// startupSkew allows for the producer starting a little before the consumer.
+// Runs started before this window are left over from previous pods.
+const startupSkew = 30 * time.Second
+
+func main() {
+	dir := envOr("SHARED_DIR", "/mnt/shared")
+	poll, err := time.ParseDuration(envOr("POLL_INTERVAL", "500ms"))
+	if err != nil {
+		fail("invalid POLL_INTERVAL: %s", err)
+	}
+	timeout, err := time.ParseDuration(envOr("WAIT_TIMEOUT", "2m"))
+	if err != nil {
+		fail("invalid WAIT_TIMEOUT: %s", err)
+	}
+
+	start := time.Now()
+	fmt.Printf("consumer: waiting for a run in %s\n", dir)
+	var run string
+	for run == "" {
+		if run, err = latestRun(dir, start.Add(-startupSkew)); err != nil {
+			fail("unable to look for runs: %s", err)
+		}
+		if run == "" {
+			if time.Since(start) > timeout {
+				fail("no run started within %s", timeout)
+			}
+			time.Sleep(poll)
+		}
+	}
+	fmt.Printf("consumer: following %s\n", run)
+
+	seen := map[string]bool{}
+	for {
+		// Check for the done marker before listing so that every message
+		// written before it is picked up by the listing below
+		done, err := exists(filepath.Join(run, "done"))
+		if err != nil {
+			fail("unable to check if the run is done: %s", err)
+		}
+
+		msgs, err := filepath.Glob(filepath.Join(run, "*.msg"))
+		if err != nil {
+			fail("unable to list messages: %s", err)
+		}
+		sort.Strings(msgs)
+		for _, m := range msgs {
+			if seen[m] {
+				continue
+			}
+			data, err := os.ReadFile(m)
+			if err != nil {
+				fail("unable to read %s: %s", m, err)
+			}
+			seen[m] = true
+			fmt.Printf("consumer: %s: %s", filepath.Base(m), data)
+		}
+
+		if done {
+			fmt.Printf("consumer: run is done, read %d messages\n", len(seen))
+			return
+		}
+		if time.Since(start) > timeout {
+			fail("run did not finish within %s", timeout)
+		}
+		time.Sleep(poll)
+	}
+}
+
+// latestRun returns the newest run directory in dir that was started after
+// notBefore, or an empty string if there isn't one yet.
+func latestRun(dir string, notBefore time.Time) (string, error) {
+	runs, err := filepath.Glob(filepath.Join(dir, "run-*"))
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	var latestNanos int64
+	for _, r := range runs {
+		nanos, err := strconv.ParseInt(strings.TrimPrefix(filepath.Base(r), "run-"), 10, 64)
+		if err != nil || nanos < notBefore.UnixNano() {
+			continue
+		}
+		if nanos > latestNanos {
+			latest, latestNanos = r, nanos
+		}
+	}
+	return latest, nil
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "consumer: "+format+"\n", args...)
+	os.Exit(1)
+}
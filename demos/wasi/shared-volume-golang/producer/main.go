@@ -0,0 +1,83 @@
+// The producer half of the shared volume demo. It writes a numbered message
+// file into a new run directory on the shared volume at a fixed interval and
+// marks the run as done when it has written them all.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	dir := envOr("SHARED_DIR", "/mnt/shared")
+	count, err := strconv.Atoi(envOr("MESSAGE_COUNT", "10"))
+	if err != nil {
+		fail("invalid MESSAGE_COUNT: %s", err)
+	}
+	interval, err := time.ParseDuration(envOr("MESSAGE_INTERVAL", "1s"))
+	if err != nil {
+		fail("invalid MESSAGE_INTERVAL: %s", err)
+	}
+
+	// Clean up runs left behind by previous pods so the volume doesn't grow
+	// forever
+	old, err := filepath.Glob(filepath.Join(dir, "run-*"))
+	if err != nil {
+		fail("unable to list old runs: %s", err)
+	}
+	for _, o := range old {
+		if err := os.RemoveAll(o); err != nil {
+			fail("unable to remove old run %s: %s", o, err)
+		}
+	}
+
+	run := filepath.Join(dir, fmt.Sprintf("run-%d", time.Now().UnixNano()))
+	if err := os.Mkdir(run, 0755); err != nil {
+		fail("unable to create run directory: %s", err)
+	}
+	fmt.Printf("producer: writing %d messages to %s\n", count, run)
+
+	host := envOr("POD_NAME", "producer")
+	for seq := 1; seq <= count; seq++ {
+		msg := fmt.Sprintf("message %d of %d from %s at %s", seq, count, host, time.Now().UTC().Format(time.RFC3339))
+		name := fmt.Sprintf("%06d.msg", seq)
+		if err := writeAtomic(filepath.Join(run, name), msg+"\n"); err != nil {
+			fail("unable to write %s: %s", name, err)
+		}
+		fmt.Printf("producer: wrote %s\n", name)
+		if seq < count {
+			time.Sleep(interval)
+		}
+	}
+
+	if err := writeAtomic(filepath.Join(run, "done"), strconv.Itoa(count)+"\n"); err != nil {
+		fail("unable to mark run as done: %s", err)
+	}
+	fmt.Println("producer: done")
+}
+
+// writeAtomic writes to a hidden temporary file and renames it into place, so
+// the consumer never sees a partially written message.
+func writeAtomic(path, data string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func envOr(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "producer: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,33 @@
+// A demo that blocks reading lines from stdin and echoes each one back on
+// stdout. It exits when stdin is closed or when it reads a line containing
+// only "quit", which makes it useful for checking whether a provider wires up
+// stdin for `kubectl attach`.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	fmt.Println("waiting for input on stdin, send \"quit\" to exit")
+
+	count := 0
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "quit" {
+			fmt.Printf("received quit after %d line(s), exiting\n", count)
+			return
+		}
+		count++
+		fmt.Printf("echo %d: %s\n", count, line)
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "error reading stdin: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("stdin closed after %d line(s), exiting\n", count)
+}
@@ -0,0 +1,216 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// WasmAnnotation marks a pod as a wasm workload. Only pods with this
+	// annotation set to "true" are mutated.
+	WasmAnnotation = "krustlet.dev/wasm"
+	// StrippedAnnotation lists the container fields the mutating webhook
+	// removed, so it is clear afterwards why they are missing
+	StrippedAnnotation = "krustlet.dev/stripped-fields"
+
+	// DefaultArch is the architecture krustlet's WASI provider registers
+	// its nodes with
+	DefaultArch = "wasm32-wasi"
+
+	archLabel = "kubernetes.io/arch"
+)
+
+// Container fields that can be stripped from wasm pods
+const (
+	FieldStdin          = "stdin"
+	FieldStdinOnce      = "stdinOnce"
+	FieldTTY            = "tty"
+	FieldLifecycle      = "lifecycle"
+	FieldLivenessProbe  = "livenessProbe"
+	FieldReadinessProbe = "readinessProbe"
+	FieldStartupProbe   = "startupProbe"
+)
+
+// DefaultStrippedFields are the fields stripped unless configured otherwise.
+// Krustlet doesn't wire up a terminal or stdin for modules and doesn't run
+// lifecycle hooks, so keeping them only suggests they work. Probes are kept by
+// default since they are harmless and some manifests are shared with other
+// runtimes.
+var DefaultStrippedFields = []string{FieldStdin, FieldStdinOnce, FieldTTY, FieldLifecycle}
+
+// PodMutator prepares annotated pods for krustlet nodes by adding the node
+// selector and tolerations krustlet's taints require and stripping container
+// fields krustlet doesn't support
+type PodMutator struct {
+	// Arch is the architecture of the target nodes
+	Arch string
+	// StripFields are the container fields to remove
+	StripFields []string
+}
+
+// NewPodMutator returns a mutator for nodes of the given architecture that
+// strips the default fields
+func NewPodMutator(arch string) *PodMutator {
+	return &PodMutator{Arch: arch, StripFields: DefaultStrippedFields}
+}
+
+// IsWasmPod reports whether the pod is annotated as a wasm workload
+func IsWasmPod(pod *corev1.Pod) bool {
+	return strings.EqualFold(pod.Annotations[WasmAnnotation], "true")
+}
+
+// Review implements Reviewer
+func (m *PodMutator) Review(_ context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return Allowed()
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return Errored(fmt.Errorf("decoding pod: %w", err))
+	}
+	if !IsWasmPod(&pod) {
+		return Allowed()
+	}
+	ops, warnings := m.Mutate(&pod)
+	return Patched(ops, warnings...)
+}
+
+// Mutate returns the patch that prepares the pod for krustlet, along with
+// warnings to show the user
+func (m *PodMutator) Mutate(pod *corev1.Pod) ([]PatchOperation, []string) {
+	var ops []PatchOperation
+	var warnings []string
+
+	switch current, ok := pod.Spec.NodeSelector[archLabel]; {
+	case pod.Spec.NodeSelector == nil:
+		ops = append(ops, PatchOperation{Op: "add", Path: "/spec/nodeSelector", Value: map[string]string{archLabel: m.Arch}})
+	case !ok:
+		ops = append(ops, PatchOperation{Op: "add", Path: "/spec/nodeSelector/" + escape(archLabel), Value: m.Arch})
+	case current != m.Arch:
+		ops = append(ops, PatchOperation{Op: "replace", Path: "/spec/nodeSelector/" + escape(archLabel), Value: m.Arch})
+		warnings = append(warnings, fmt.Sprintf("nodeSelector %s=%s replaced with %s so the pod can be scheduled on a krustlet node", archLabel, current, m.Arch))
+	}
+
+	missing := m.missingTolerations(pod.Spec.Tolerations)
+	if len(missing) > 0 {
+		if pod.Spec.Tolerations == nil {
+			ops = append(ops, PatchOperation{Op: "add", Path: "/spec/tolerations", Value: missing})
+		} else {
+			for _, t := range missing {
+				ops = append(ops, PatchOperation{Op: "add", Path: "/spec/tolerations/-", Value: t})
+			}
+		}
+	}
+
+	stripped := map[string]bool{}
+	for _, kind := range []struct {
+		path       string
+		containers []corev1.Container
+	}{
+		{"initContainers", pod.Spec.InitContainers},
+		{"containers", pod.Spec.Containers},
+	} {
+		for i := range kind.containers {
+			for _, field := range m.setFields(&kind.containers[i]) {
+				ops = append(ops, PatchOperation{Op: "remove", Path: fmt.Sprintf("/spec/%s/%d/%s", kind.path, i, field)})
+				stripped[field] = true
+			}
+			if len(kind.containers[i].Command) > 0 {
+				warnings = append(warnings, fmt.Sprintf("container %q sets command, which krustlet ignores; the module's _start function is always run with the container's args", kind.containers[i].Name))
+			}
+		}
+	}
+	if len(stripped) > 0 {
+		fields := make([]string, 0, len(stripped))
+		for f := range stripped {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		// The pod is known to have annotations since it was selected by one
+		ops = append(ops, PatchOperation{Op: "add", Path: "/metadata/annotations/" + escape(StrippedAnnotation), Value: strings.Join(fields, ",")})
+		warnings = append(warnings, fmt.Sprintf("removed fields krustlet does not support: %s", strings.Join(fields, ", ")))
+	}
+
+	return ops, warnings
+}
+
+// Tolerations returns the tolerations for the taints krustlet puts on nodes
+// of the architecture
+func Tolerations(arch string) []corev1.Toleration {
+	return []corev1.Toleration{
+		{Key: archLabel, Operator: corev1.TolerationOpEqual, Value: arch, Effect: corev1.TaintEffectNoSchedule},
+		{Key: archLabel, Operator: corev1.TolerationOpEqual, Value: arch, Effect: corev1.TaintEffectNoExecute},
+	}
+}
+
+func (m *PodMutator) missingTolerations(existing []corev1.Toleration) []corev1.Toleration {
+	var missing []corev1.Toleration
+	for _, want := range Tolerations(m.Arch) {
+		taint := corev1.Taint{Key: want.Key, Value: want.Value, Effect: want.Effect}
+		tolerated := false
+		for i := range existing {
+			if existing[i].ToleratesTaint(&taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			missing = append(missing, want)
+		}
+	}
+	return missing
+}
+
+// setFields returns the configured fields that are set on the container
+func (m *PodMutator) setFields(c *corev1.Container) []string {
+	var set []string
+	for _, field := range m.StripFields {
+		var present bool
+		switch field {
+		case FieldStdin:
+			present = c.Stdin
+		case FieldStdinOnce:
+			present = c.StdinOnce
+		case FieldTTY:
+			present = c.TTY
+		case FieldLifecycle:
+			present = c.Lifecycle != nil
+		case FieldLivenessProbe:
+			present = c.LivenessProbe != nil
+		case FieldReadinessProbe:
+			present = c.ReadinessProbe != nil
+		case FieldStartupProbe:
+			present = c.StartupProbe != nil
+		}
+		if present {
+			set = append(set, field)
+		}
+	}
+	return set
+}
+
+// ValidateStripFields checks that every field is one the mutator knows how
+// to strip
+func ValidateStripFields(fields []string) error {
+	known := map[string]bool{
+		FieldStdin: true, FieldStdinOnce: true, FieldTTY: true, FieldLifecycle: true,
+		FieldLivenessProbe: true, FieldReadinessProbe: true, FieldStartupProbe: true,
+	}
+	for _, f := range fields {
+		if !known[f] {
+			return fmt.Errorf("unknown container field %q", f)
+		}
+	}
+	return nil
+}
+
+// escape escapes a key for use in a JSON pointer
+func escape(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
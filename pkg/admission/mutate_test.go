@@ -0,0 +1,179 @@
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func wasmPod() *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "hello",
+			Annotations: map[string]string{WasmAnnotation: "true"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "hello", Image: "webassembly.azurecr.io/hello-wasm:v1"}},
+		},
+	}
+}
+
+// applyMutation runs the mutator through the webhook handler the way the API
+// server would and returns the patched pod along with the warnings
+func applyMutation(t *testing.T, m *PodMutator, pod *corev1.Pod) (*corev1.Pod, []string) {
+	t.Helper()
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "1234",
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+	body, _ := json.Marshal(review)
+	rec := httptest.NewRecorder()
+	Handler(m).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body)
+	}
+
+	var out admissionv1.AdmissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Response == nil || out.Response.UID != "1234" || !out.Response.Allowed {
+		t.Fatalf("unexpected response %+v", out.Response)
+	}
+	if out.Response.Patch == nil {
+		return pod, out.Response.Warnings
+	}
+	patch, err := jsonpatch.DecodePatch(out.Response.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := patch.Apply(raw)
+	if err != nil {
+		t.Fatalf("applying patch %s: %v", out.Response.Patch, err)
+	}
+	var result corev1.Pod
+	if err := json.Unmarshal(patched, &result); err != nil {
+		t.Fatal(err)
+	}
+	return &result, out.Response.Warnings
+}
+
+func TestMutateAddsSchedulingFields(t *testing.T) {
+	pod, _ := applyMutation(t, NewPodMutator(DefaultArch), wasmPod())
+
+	if pod.Spec.NodeSelector["kubernetes.io/arch"] != "wasm32-wasi" {
+		t.Errorf("expected the arch node selector, got %v", pod.Spec.NodeSelector)
+	}
+	for _, effect := range []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectNoExecute} {
+		taint := corev1.Taint{Key: "kubernetes.io/arch", Value: "wasm32-wasi", Effect: effect}
+		tolerated := false
+		for i := range pod.Spec.Tolerations {
+			tolerated = tolerated || pod.Spec.Tolerations[i].ToleratesTaint(&taint)
+		}
+		if !tolerated {
+			t.Errorf("expected the %s taint to be tolerated, got %v", effect, pod.Spec.Tolerations)
+		}
+	}
+}
+
+func TestMutateKeepsExistingFields(t *testing.T) {
+	in := wasmPod()
+	in.Spec.NodeSelector = map[string]string{"kubernetes.io/arch": "amd64", "zone": "edge"}
+	in.Spec.Tolerations = []corev1.Toleration{
+		{Key: "dedicated", Operator: corev1.TolerationOpExists},
+		{Key: "kubernetes.io/arch", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+	}
+
+	pod, warnings := applyMutation(t, NewPodMutator(DefaultArch), in)
+	if pod.Spec.NodeSelector["zone"] != "edge" || pod.Spec.NodeSelector["kubernetes.io/arch"] != "wasm32-wasi" {
+		t.Errorf("unexpected node selector %v", pod.Spec.NodeSelector)
+	}
+	// Only the NoExecute toleration is missing
+	if len(pod.Spec.Tolerations) != 3 || pod.Spec.Tolerations[2].Effect != corev1.TaintEffectNoExecute {
+		t.Errorf("unexpected tolerations %v", pod.Spec.Tolerations)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "amd64") {
+		t.Errorf("expected a warning about the replaced selector, got %v", warnings)
+	}
+}
+
+func TestMutateStripsFields(t *testing.T) {
+	in := wasmPod()
+	in.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "init", TTY: true}}
+	in.Spec.Containers[0].Stdin = true
+	in.Spec.Containers[0].Lifecycle = &corev1.Lifecycle{PreStop: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"true"}}}}
+	in.Spec.Containers[0].ReadinessProbe = &corev1.Probe{}
+	in.Spec.Containers[0].Command = []string{"/hello"}
+
+	pod, warnings := applyMutation(t, NewPodMutator(DefaultArch), in)
+	c := pod.Spec.Containers[0]
+	if c.Stdin || c.Lifecycle != nil || pod.Spec.InitContainers[0].TTY {
+		t.Errorf("expected unsupported fields to be removed, got %+v", pod.Spec)
+	}
+	if c.ReadinessProbe == nil {
+		t.Error("expected probes to be kept by default")
+	}
+	if got := pod.Annotations[StrippedAnnotation]; got != "lifecycle,stdin,tty" {
+		t.Errorf("unexpected %s annotation %q", StrippedAnnotation, got)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("expected warnings for the command and stripped fields, got %v", warnings)
+	}
+
+	m := NewPodMutator(DefaultArch)
+	m.StripFields = append(m.StripFields, FieldReadinessProbe)
+	if pod, _ = applyMutation(t, m, in); pod.Spec.Containers[0].ReadinessProbe != nil {
+		t.Error("expected the readiness probe to be removed when configured")
+	}
+}
+
+func TestMutateIgnoresOtherPods(t *testing.T) {
+	in := wasmPod()
+	delete(in.Annotations, WasmAnnotation)
+	resp := NewPodMutator(DefaultArch).Review(context.Background(), &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: mustJSON(t, in)},
+	})
+	if !resp.Allowed || resp.Patch != nil {
+		t.Errorf("expected an unannotated pod to be left alone, got %+v", resp)
+	}
+}
+
+func TestValidateStripFields(t *testing.T) {
+	if err := ValidateStripFields([]string{FieldTTY, FieldStartupProbe}); err != nil {
+		t.Error(err)
+	}
+	if err := ValidateStripFields([]string{"securityContext"}); err == nil {
+		t.Error("expected an unknown field to be rejected")
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
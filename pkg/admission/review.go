@@ -0,0 +1,127 @@
+// Package admission implements the admission webhooks that help pods land
+// on krustlet nodes: a mutating webhook that adds what wasm pods need to be
+// scheduled, and a validating webhook that rejects images krustlet can't run.
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// maxRequestSize caps the size of an AdmissionReview body. The API server
+// limits objects to a few megabytes, so this is generous.
+const maxRequestSize = 8 * 1024 * 1024
+
+// Reviewer decides the response to an admission request
+type Reviewer interface {
+	Review(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+}
+
+// ReviewerFunc adapts a function to a Reviewer
+type ReviewerFunc func(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse
+
+// Review calls f
+func (f ReviewerFunc) Review(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	return f(ctx, req)
+}
+
+// Handler serves AdmissionReview requests from the API server using r
+func Handler(r Reviewer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "admission reviews must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("reading request: %v", err), http.StatusBadRequest)
+			return
+		}
+		var review admissionv1.AdmissionReview
+		if err := json.Unmarshal(body, &review); err != nil {
+			http.Error(w, fmt.Sprintf("decoding AdmissionReview: %v", err), http.StatusBadRequest)
+			return
+		}
+		if review.Request == nil {
+			http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+			return
+		}
+
+		resp := r.Review(req.Context(), review.Request)
+		if resp == nil {
+			resp = Allowed()
+		}
+		resp.UID = review.Request.UID
+		review.Response = resp
+		review.Request = nil
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&review); err != nil {
+			klog.ErrorS(err, "Failed to write admission response")
+		}
+	})
+}
+
+// Allowed returns a response admitting the object unchanged
+func Allowed(warnings ...string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{Allowed: true, Warnings: warnings}
+}
+
+// Denied returns a response rejecting the object with the given message,
+// which kubectl shows to the user
+func Denied(code int32, message string) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    code,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: message,
+		},
+	}
+}
+
+// Errored returns a response for a request that couldn't be processed
+func Errored(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusBadRequest,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: err.Error(),
+		},
+	}
+}
+
+// PatchOperation is a single JSON patch operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Patched returns a response admitting the object with the given patch
+func Patched(ops []PatchOperation, warnings ...string) *admissionv1.AdmissionResponse {
+	if len(ops) == 0 {
+		return Allowed(warnings...)
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return Errored(fmt.Errorf("encoding patch: %w", err))
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+		Warnings:  warnings,
+	}
+}
@@ -0,0 +1,83 @@
+package admission
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// certLoader serves a TLS key pair from disk, picking up a new pair when the
+// certificate file changes so rotated certificates (for example from
+// cert-manager) are used without a restart
+type certLoader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cert    *tls.Certificate
+}
+
+func (l *certLoader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	info, err := os.Stat(l.certFile)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cert != nil && info.ModTime().Equal(l.modTime) {
+		return l.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.certFile, l.keyFile)
+	if err != nil {
+		if l.cert != nil {
+			// Keep serving the old pair if the new one is half written
+			klog.ErrorS(err, "Failed to reload TLS key pair, using the previous one")
+			return l.cert, nil
+		}
+		return nil, err
+	}
+	l.cert, l.modTime = &cert, info.ModTime()
+	return l.cert, nil
+}
+
+// ListenAndServeTLS serves handler over TLS until the context is cancelled,
+// then shuts down gracefully
+func ListenAndServeTLS(ctx context.Context, addr, certFile, keyFile string, handler http.Handler) error {
+	loader := &certLoader{certFile: certFile, keyFile: keyFile}
+	// Fail at startup rather than on the first request if the pair is bad
+	if _, err := loader.getCertificate(nil); err != nil {
+		return fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: loader.getCertificate,
+		},
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.ListenAndServeTLS("", "") }()
+	klog.InfoS("Serving admission webhooks", "addr", addr)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// ManifestFetcher fetches the manifest a module reference points at.
+// *oci.Client implements it.
+type ManifestFetcher interface {
+	FetchModuleManifest(ctx context.Context, ref oci.Reference) (*oci.Manifest, oci.Descriptor, error)
+}
+
+// ImageValidator rejects pods bound for krustlet nodes whose images are
+// container images rather than wasm modules. Without it, such pods are
+// admitted and then fail on the node with an unsupported media type error.
+//
+// Only an image the registry positively reports as something other than a
+// wasm module is rejected. If the registry can't be reached or refuses the
+// request, the pod is admitted with a warning, since krustlet itself may
+// still be able to pull it.
+type ImageValidator struct {
+	// Arch is the architecture of krustlet nodes, used to tell which pods
+	// target them
+	Arch string
+	// Secrets reads image pull secrets so private registries can be checked.
+	// Pull secrets are not used if it is nil
+	Secrets kubernetes.Interface
+	// NewFetcher returns a fetcher that uses the given credentials
+	NewFetcher func(creds oci.CredentialFunc) ManifestFetcher
+	// Timeout bounds how long checking all of a pod's images may take
+	Timeout time.Duration
+	// CacheTTL is how long the result of checking an image is remembered
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedResult
+}
+
+type cachedResult struct {
+	err     error
+	expires time.Time
+}
+
+// NewImageValidator returns a validator that checks images with an
+// oci.Client built from opts
+func NewImageValidator(arch string, secrets kubernetes.Interface, opts ...oci.Option) *ImageValidator {
+	return &ImageValidator{
+		Arch:    arch,
+		Secrets: secrets,
+		NewFetcher: func(creds oci.CredentialFunc) ManifestFetcher {
+			return oci.NewClient(append(append([]oci.Option{}, opts...), oci.WithCredentials(creds))...)
+		},
+		Timeout:  5 * time.Second,
+		CacheTTL: time.Minute,
+	}
+}
+
+// TargetsKrustlet reports whether the pod is meant for a krustlet node of the
+// given architecture, through the wasm annotation, a node selector, or a
+// required node affinity on the architecture label
+func TargetsKrustlet(pod *corev1.Pod, arch string) bool {
+	if IsWasmPod(pod) || pod.Spec.NodeSelector[archLabel] == arch {
+		return true
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key != archLabel || expr.Operator != corev1.NodeSelectorOpIn {
+				continue
+			}
+			for _, v := range expr.Values {
+				if v == arch {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Review implements Reviewer
+func (v *ImageValidator) Review(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	if req.Kind.Kind != "Pod" || req.Operation != admissionv1.Create {
+		return Allowed()
+	}
+	var pod corev1.Pod
+	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
+		return Errored(fmt.Errorf("decoding pod: %w", err))
+	}
+	if !TargetsKrustlet(&pod, v.Arch) {
+		return Allowed()
+	}
+	namespace := pod.Namespace
+	if namespace == "" {
+		namespace = req.Namespace
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+	fetcher := v.NewFetcher(v.pullSecretCredentials(ctx, namespace, pod.Spec.ImagePullSecrets))
+
+	var problems, warnings []string
+	for _, c := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		err := v.check(ctx, fetcher, c.Image)
+		switch {
+		case err == nil:
+		case errors.Is(err, oci.ErrNotModule):
+			problems = append(problems, fmt.Sprintf("container %q: image %q is not a wasm module. %v", c.Name, c.Image, err))
+		default:
+			warnings = append(warnings, fmt.Sprintf("could not check that image %q of container %q is a wasm module: %v", c.Image, c.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return Denied(http.StatusForbidden, fmt.Sprintf(
+			"pod targets krustlet nodes, which can only run WebAssembly modules (images with a %s layer, such as those pushed with wasm2oci): %s",
+			oci.WasmLayerMediaType, strings.Join(problems, "; ")))
+	}
+	return Allowed(warnings...)
+}
+
+// check returns nil if the image is a wasm module. Results for the same image
+// string are cached briefly, since a rollout admits many identical pods.
+func (v *ImageValidator) check(ctx context.Context, fetcher ManifestFetcher, image string) error {
+	now := time.Now()
+	v.mu.Lock()
+	if r, ok := v.cache[image]; ok && now.Before(r.expires) {
+		v.mu.Unlock()
+		return r.err
+	}
+	v.mu.Unlock()
+
+	err := checkImage(ctx, fetcher, image)
+	// Only definite answers are cached so a registry outage doesn't outlive
+	// itself
+	if err == nil || errors.Is(err, oci.ErrNotModule) {
+		v.mu.Lock()
+		if v.cache == nil {
+			v.cache = map[string]cachedResult{}
+		}
+		v.cache[image] = cachedResult{err: err, expires: now.Add(v.CacheTTL)}
+		v.mu.Unlock()
+	}
+	return err
+}
+
+func checkImage(ctx context.Context, fetcher ManifestFetcher, image string) error {
+	ref, err := oci.ParseReference(image)
+	if err != nil {
+		return err
+	}
+	manifest, _, err := fetcher.FetchModuleManifest(ctx, ref)
+	if err != nil {
+		return err
+	}
+	_, err = manifest.ModuleLayer()
+	return err
+}
+
+// pullSecretCredentials returns credentials from the pod's image pull
+// secrets, falling back to anonymous access. Secrets that can't be read are
+// skipped, since the check is best effort.
+func (v *ImageValidator) pullSecretCredentials(ctx context.Context, namespace string, refs []corev1.LocalObjectReference) oci.CredentialFunc {
+	cfg := &oci.DockerConfig{Auths: map[string]oci.DockerAuth{}}
+	if v.Secrets == nil {
+		return oci.DockerCredentials(cfg)
+	}
+	for _, ref := range refs {
+		secret, err := v.Secrets.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			klog.V(2).InfoS("Skipping image pull secret", "namespace", namespace, "secret", ref.Name, "err", err)
+			continue
+		}
+		var parsed oci.DockerConfig
+		switch secret.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			err = json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &parsed)
+		case corev1.SecretTypeDockercfg:
+			err = json.Unmarshal(secret.Data[corev1.DockerConfigKey], &parsed.Auths)
+		default:
+			continue
+		}
+		if err != nil {
+			klog.V(2).InfoS("Skipping malformed image pull secret", "namespace", namespace, "secret", ref.Name, "err", err)
+			continue
+		}
+		for k, a := range parsed.Auths {
+			// Earlier secrets win, the same as for the kubelet
+			if _, ok := cfg.Auths[k]; !ok {
+				cfg.Auths[k] = a
+			}
+		}
+	}
+	return oci.DockerCredentials(cfg)
+}
@@ -0,0 +1,204 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// fakeFetcher answers with canned manifests keyed by the reference string and
+// records the credentials it was built with
+type fakeFetcher struct {
+	manifests map[string]*oci.Manifest
+	errs      map[string]error
+	calls     int
+	creds     oci.CredentialFunc
+}
+
+func (f *fakeFetcher) FetchModuleManifest(_ context.Context, ref oci.Reference) (*oci.Manifest, oci.Descriptor, error) {
+	f.calls++
+	if err, ok := f.errs[ref.String()]; ok {
+		return nil, oci.Descriptor{}, err
+	}
+	m, ok := f.manifests[ref.String()]
+	if !ok {
+		return nil, oci.Descriptor{}, oci.ErrNotFound
+	}
+	return m, oci.Descriptor{}, nil
+}
+
+func newTestValidator(f *fakeFetcher) *ImageValidator {
+	v := NewImageValidator(DefaultArch, nil)
+	v.NewFetcher = func(creds oci.CredentialFunc) ManifestFetcher {
+		f.creds = creds
+		return f
+	}
+	return v
+}
+
+func moduleManifest() *oci.Manifest {
+	return &oci.Manifest{Layers: []oci.Descriptor{{MediaType: oci.WasmLayerMediaType}}}
+}
+
+func imageManifest() *oci.Manifest {
+	return &oci.Manifest{Layers: []oci.Descriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip"}}}
+}
+
+func validate(t *testing.T, v *ImageValidator, pod *corev1.Pod) *admissionv1.AdmissionResponse {
+	t.Helper()
+	return v.Review(context.Background(), &admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Operation: admissionv1.Create,
+		Namespace: "default",
+		Object:    runtime.RawExtension{Raw: mustJSON(t, pod)},
+	})
+}
+
+func TestValidateAllowsModules(t *testing.T) {
+	f := &fakeFetcher{manifests: map[string]*oci.Manifest{
+		"webassembly.azurecr.io/hello-wasm:v1": moduleManifest(),
+	}}
+	if resp := validate(t, newTestValidator(f), wasmPod()); !resp.Allowed || len(resp.Warnings) != 0 {
+		t.Errorf("expected a wasm module to be allowed, got %+v", resp)
+	}
+}
+
+func TestValidateRejectsContainerImages(t *testing.T) {
+	f := &fakeFetcher{
+		manifests: map[string]*oci.Manifest{
+			"webassembly.azurecr.io/hello-wasm:v1": moduleManifest(),
+			"docker.io/library/nginx:latest":       imageManifest(),
+		},
+		errs: map[string]error{
+			"docker.io/library/busybox:latest": fmt.Errorf("docker.io/library/busybox:latest: %w: index has no manifest for a wasm platform", oci.ErrNotModule),
+		},
+	}
+	pod := wasmPod()
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init", Image: "busybox"}}
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "web", Image: "nginx"})
+
+	resp := validate(t, newTestValidator(f), pod)
+	if resp.Allowed {
+		t.Fatal("expected container images to be rejected")
+	}
+	msg := resp.Result.Message
+	for _, want := range []string{`container "init"`, `container "web"`, oci.WasmLayerMediaType, "container image"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected the message to mention %q, got %q", want, msg)
+		}
+	}
+	if strings.Contains(msg, `container "hello"`) {
+		t.Errorf("expected the wasm container not to be reported, got %q", msg)
+	}
+}
+
+func TestValidateWarnsOnRegistryErrors(t *testing.T) {
+	f := &fakeFetcher{errs: map[string]error{
+		"webassembly.azurecr.io/hello-wasm:v1": errors.New("connection refused"),
+	}}
+	v := newTestValidator(f)
+	for i := 0; i < 2; i++ {
+		resp := validate(t, v, wasmPod())
+		if !resp.Allowed || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "connection refused") {
+			t.Errorf("expected the pod to be allowed with a warning, got %+v", resp)
+		}
+	}
+	if f.calls != 2 {
+		t.Errorf("expected failed checks not to be cached, got %d calls", f.calls)
+	}
+}
+
+func TestValidateCachesResults(t *testing.T) {
+	f := &fakeFetcher{manifests: map[string]*oci.Manifest{
+		"webassembly.azurecr.io/hello-wasm:v1": moduleManifest(),
+	}}
+	v := newTestValidator(f)
+	validate(t, v, wasmPod())
+	validate(t, v, wasmPod())
+	if f.calls != 1 {
+		t.Errorf("expected the second check to be cached, got %d calls", f.calls)
+	}
+}
+
+func TestValidateIgnoresOtherPods(t *testing.T) {
+	f := &fakeFetcher{}
+	pod := wasmPod()
+	delete(pod.Annotations, WasmAnnotation)
+	if resp := validate(t, newTestValidator(f), pod); !resp.Allowed || f.calls != 0 {
+		t.Errorf("expected a pod not targeting krustlet to be allowed unchecked, got %+v", resp)
+	}
+}
+
+func TestTargetsKrustlet(t *testing.T) {
+	selector := wasmPod()
+	delete(selector.Annotations, WasmAnnotation)
+	selector.Spec.NodeSelector = map[string]string{archLabel: DefaultArch}
+
+	affinity := wasmPod()
+	delete(affinity.Annotations, WasmAnnotation)
+	affinity.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key: archLabel, Operator: corev1.NodeSelectorOpIn, Values: []string{"amd64", DefaultArch},
+				}},
+			}},
+		},
+	}}
+
+	other := wasmPod()
+	delete(other.Annotations, WasmAnnotation)
+	other.Spec.NodeSelector = map[string]string{archLabel: "amd64"}
+
+	for name, tc := range map[string]struct {
+		pod  *corev1.Pod
+		want bool
+	}{
+		"annotation": {wasmPod(), true},
+		"selector":   {selector, true},
+		"affinity":   {affinity, true},
+		"other arch": {other, false},
+	} {
+		if got := TargetsKrustlet(tc.pod, DefaultArch); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", name, tc.want, got)
+		}
+	}
+}
+
+func TestValidateUsesPullSecrets(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"webassembly.azurecr.io":{"username":"user","password":"secret"}}}`),
+		},
+	})
+	f := &fakeFetcher{manifests: map[string]*oci.Manifest{
+		"webassembly.azurecr.io/hello-wasm:v1": moduleManifest(),
+	}}
+	v := newTestValidator(f)
+	v.Secrets = client
+
+	pod := wasmPod()
+	pod.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}, {Name: "missing"}}
+	if resp := validate(t, v, pod); !resp.Allowed {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	cred, err := f.creds("webassembly.azurecr.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Username != "user" || cred.Password != "secret" {
+		t.Errorf("expected credentials from the pull secret, got %+v", cred)
+	}
+}
@@ -0,0 +1,489 @@
+// Package bench measures how quickly a krustlet node pulls modules and
+// starts pods, so performance can be compared between releases.
+//
+// There are three benchmarks, each run a number of times:
+//
+//   - pull pushes modules of several sizes with random contents to a
+//     registry and runs a pod of each on the node, timing the pull with
+//     nothing cached, then a second pod of the same module with it cached.
+//     The same pull from the benchmark's own host is timed as a baseline
+//     for the registry.
+//   - start times pods of a module the node has cached, from being created
+//     to being scheduled, to their module running and to finishing.
+//   - scale starts many pods at once and times how long the node takes to
+//     start them all.
+//
+// The benchmarks only use the Kubernetes API, so they see the node as its
+// users do; see timeline for how precise that is.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/admission"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// The benchmarks
+const (
+	BenchmarkPull  = "pull"
+	BenchmarkStart = "start"
+	BenchmarkScale = "scale"
+)
+
+// Benchmarks are all the benchmarks, in the order they run
+var Benchmarks = []string{BenchmarkPull, BenchmarkStart, BenchmarkScale}
+
+const (
+	// DefaultArch is the architecture krustlet's wasi provider registers with
+	DefaultArch = "wasm32-wasi"
+	// DefaultImage is the module start and scale run when no registry is
+	// given to push one to. It prints "Hello, world!" and exits.
+	DefaultImage = "webassembly.azurecr.io/hello-wasm:v1"
+
+	archLabel = "kubernetes.io/arch"
+	// runLabel marks the namespaces and pods of a run
+	runLabel = "bench.krustlet.dev/run"
+)
+
+// DefaultSizes are the module sizes pull measures, in bytes
+var DefaultSizes = []int64{1 << 20, 10 << 20, 50 << 20}
+
+// DefaultConcurrency are the numbers of pods scale starts at once
+var DefaultConcurrency = []int{1, 5, 10, 25}
+
+// Config configures a benchmark run
+type Config struct {
+	// Client talks to the cluster the node is registered with
+	Client kubernetes.Interface
+	// NodeName is the krustlet node to benchmark
+	NodeName string
+	// Arch is the architecture label of the node
+	Arch string
+	// Registry and Repository are where pull pushes its modules, such as
+	// localhost:5000/krustlet-bench. The node must be able to pull from
+	// the repository under the same name. pull needs them, and without
+	// them start and scale run Image instead of a module pushed there.
+	Registry   *oci.Client
+	Repository *oci.Reference
+	// Image is the module start and scale run when there is no registry
+	Image string
+	// Sizes are the module sizes pull measures, in bytes
+	Sizes []int64
+	// Concurrency are the numbers of pods scale starts at once
+	Concurrency []int
+	// Iterations is how many times each scenario is measured
+	Iterations int
+	// PodTimeout bounds how long a pod may take to finish
+	PodTimeout time.Duration
+	// NamespacePrefix is prepended to the name of the run's namespace
+	NamespacePrefix string
+	// KeepNamespace leaves the run's namespace behind for debugging
+	KeepNamespace bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Arch == "" {
+		c.Arch = DefaultArch
+	}
+	if c.Image == "" {
+		c.Image = DefaultImage
+	}
+	if len(c.Sizes) == 0 {
+		c.Sizes = DefaultSizes
+	}
+	if len(c.Concurrency) == 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Iterations == 0 {
+		c.Iterations = 3
+	}
+	if c.PodTimeout == 0 {
+		c.PodTimeout = 5 * time.Minute
+	}
+	if c.NamespacePrefix == "" {
+		c.NamespacePrefix = "krustlet-bench"
+	}
+	return c
+}
+
+// SizeName formats a size in bytes as a Kubernetes quantity, such as 10Mi,
+// as used in scenario names
+func SizeName(size int64) string {
+	return resource.NewQuantity(size, resource.BinarySI).String()
+}
+
+// runner runs the benchmarks in a namespace of its own
+type runner struct {
+	cfg       Config
+	id        string
+	namespace string
+	pods      *podTimelines
+	report    *Report
+	seq       int
+	// image is the cached module start and scale run, once it is
+	image string
+}
+
+// Run runs the benchmarks on the node and reports the results. A scenario
+// that fails is recorded in the report's errors and the run carries on, so
+// only failing to set the run up is an error.
+func Run(ctx context.Context, cfg Config, benchmarks ...string) (*Report, error) {
+	cfg = cfg.withDefaults()
+	if len(benchmarks) == 0 {
+		benchmarks = Benchmarks
+	}
+	for _, b := range benchmarks {
+		if !contains(Benchmarks, b) {
+			return nil, fmt.Errorf("unknown benchmark %q, expected one of %s", b, strings.Join(Benchmarks, ", "))
+		}
+		if b == BenchmarkPull && (cfg.Registry == nil || cfg.Repository == nil) {
+			return nil, errors.New("the pull benchmark needs a registry to push modules to")
+		}
+	}
+
+	node, err := cfg.Client.CoreV1().Nodes().Get(ctx, cfg.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting node: %w", err)
+	}
+	r := &runner{
+		cfg:  cfg,
+		id:   utilrand.String(6),
+		pods: newPodTimelines(time.Now),
+		report: &Report{
+			Version:        reportVersion,
+			Started:        time.Now().UTC(),
+			Node:           node.Name,
+			KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+			Iterations:     cfg.Iterations,
+		},
+	}
+	r.namespace = cfg.NamespacePrefix + "-" + r.id
+	_, err = cfg.Client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: r.namespace, Labels: map[string]string{runLabel: r.id}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating namespace: %w", err)
+	}
+	defer func() {
+		if cfg.KeepNamespace {
+			klog.InfoS("Keeping namespace", "namespace", r.namespace)
+			return
+		}
+		// Cleanup runs even if the run was cancelled
+		if err := cfg.Client.CoreV1().Namespaces().Delete(context.Background(), r.namespace, metav1.DeleteOptions{}); err != nil {
+			klog.ErrorS(err, "Deleting namespace", "namespace", r.namespace)
+		}
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	factory := informers.NewSharedInformerFactoryWithOptions(cfg.Client, 0, informers.WithNamespace(r.namespace))
+	informer := factory.Core().V1().Pods().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.pods.observe,
+		UpdateFunc: func(_, obj interface{}) { r.pods.observe(obj) },
+	}); err != nil {
+		return nil, err
+	}
+	factory.Start(stop)
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return nil, errors.New("timed out waiting for the pod watch to start")
+	}
+
+	for _, b := range benchmarks {
+		if ctx.Err() != nil {
+			break
+		}
+		klog.InfoS("Running benchmark", "benchmark", b, "node", cfg.NodeName)
+		switch b {
+		case BenchmarkPull:
+			r.pull(ctx)
+		case BenchmarkStart:
+			r.start(ctx)
+		case BenchmarkScale:
+			r.scale(ctx)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		r.report.Errors = append(r.report.Errors, fmt.Sprintf("run interrupted: %s", err))
+	}
+	return r.report, nil
+}
+
+// fail records a failed scenario
+func (r *runner) fail(benchmark, scenario string, err error) {
+	klog.ErrorS(err, "Scenario failed", "benchmark", benchmark, "scenario", scenario)
+	r.report.Errors = append(r.report.Errors, fmt.Sprintf("%s %s: %s", benchmark, scenario, err))
+}
+
+// pull times pulls of modules of each size, with and without them cached
+func (r *runner) pull(ctx context.Context) {
+	for _, size := range r.cfg.Sizes {
+		name := SizeName(size)
+		for i := 0; i < r.cfg.Iterations && ctx.Err() == nil; i++ {
+			if err := r.pullOnce(ctx, size, name, i); err != nil {
+				r.fail(BenchmarkPull, name, err)
+			}
+		}
+	}
+}
+
+func (r *runner) pullOnce(ctx context.Context, size int64, name string, i int) error {
+	ref, err := r.push(ctx, size, fmt.Sprintf("%s-%d", strings.ToLower(name), i))
+	if err != nil {
+		return err
+	}
+	mib := float64(size) / (1 << 20)
+
+	cold, err := r.runPods(ctx, ref.String(), 1)
+	if err != nil {
+		return fmt.Errorf("cold pull: %w", err)
+	}
+	pull := cold[0].pullTime()
+	r.report.record(BenchmarkPull, "cold/"+name, "pull_seconds", UnitSeconds, pull.Seconds())
+	r.report.record(BenchmarkPull, "cold/"+name, "start_seconds", UnitSeconds, cold[0].startTime().Seconds())
+	if pull > 0 {
+		r.report.record(BenchmarkPull, "cold/"+name, "throughput", UnitMebibytesPerSec, mib/pull.Seconds())
+	}
+
+	warm, err := r.runPods(ctx, ref.String(), 1)
+	if err != nil {
+		return fmt.Errorf("warm pull: %w", err)
+	}
+	r.report.record(BenchmarkPull, "warm/"+name, "pull_seconds", UnitSeconds, warm[0].pullTime().Seconds())
+	r.report.record(BenchmarkPull, "warm/"+name, "start_seconds", UnitSeconds, warm[0].startTime().Seconds())
+
+	// The baseline pull comes after the node's, so a registry that caches
+	// what it serves can't make the node's pull look faster
+	start := time.Now()
+	if _, err := r.cfg.Registry.Pull(ctx, *ref); err != nil {
+		return fmt.Errorf("baseline pull: %w", err)
+	}
+	baseline := time.Since(start)
+	r.report.record(BenchmarkPull, "registry/"+name, "pull_seconds", UnitSeconds, baseline.Seconds())
+	r.report.record(BenchmarkPull, "registry/"+name, "throughput", UnitMebibytesPerSec, mib/baseline.Seconds())
+	klog.InfoS("Timed pull", "size", name, "iteration", i, "cold", pull, "warm", warm[0].pullTime(), "registry", baseline)
+	return nil
+}
+
+// push pushes a new module of the size to the repository
+func (r *runner) push(ctx context.Context, size int64, tag string) (*oci.Reference, error) {
+	module, err := Module(size)
+	if err != nil {
+		return nil, err
+	}
+	ref := *r.cfg.Repository
+	ref.Tag = r.id + "-" + tag
+	ref.Digest = ""
+	if _, err := r.cfg.Registry.Push(ctx, ref, module, oci.PushOptions{Title: "krustlet-bench.wasm"}); err != nil {
+		return nil, fmt.Errorf("pushing %s: %w", ref, err)
+	}
+	return &ref, nil
+}
+
+// cachedImage returns the module start and scale run, making sure the node
+// has it cached so they don't time its pull
+func (r *runner) cachedImage(ctx context.Context) (string, error) {
+	if r.image != "" {
+		return r.image, nil
+	}
+	image := r.cfg.Image
+	if r.cfg.Registry != nil && r.cfg.Repository != nil {
+		ref, err := r.push(ctx, 64<<10, "start")
+		if err != nil {
+			return "", err
+		}
+		image = ref.String()
+	}
+	if _, err := r.runPods(ctx, image, 1); err != nil {
+		return "", fmt.Errorf("caching %s on the node: %w", image, err)
+	}
+	r.image = image
+	return image, nil
+}
+
+// start times pods of a cached module, one at a time
+func (r *runner) start(ctx context.Context) {
+	const scenario = "cached"
+	image, err := r.cachedImage(ctx)
+	if err != nil {
+		r.fail(BenchmarkStart, scenario, err)
+		return
+	}
+	for i := 0; i < r.cfg.Iterations && ctx.Err() == nil; i++ {
+		tls, err := r.runPods(ctx, image, 1)
+		if err != nil {
+			r.fail(BenchmarkStart, scenario, err)
+			continue
+		}
+		t := tls[0]
+		r.report.record(BenchmarkStart, scenario, "schedule_seconds", UnitSeconds, t.scheduleTime().Seconds())
+		r.report.record(BenchmarkStart, scenario, "pull_seconds", UnitSeconds, t.pullTime().Seconds())
+		r.report.record(BenchmarkStart, scenario, "start_seconds", UnitSeconds, t.startTime().Seconds())
+		r.report.record(BenchmarkStart, scenario, "complete_seconds", UnitSeconds, t.completeTime().Seconds())
+		klog.InfoS("Timed start", "iteration", i, "start", t.startTime())
+	}
+}
+
+// scale times starting many pods at once
+func (r *runner) scale(ctx context.Context) {
+	image, err := r.cachedImage(ctx)
+	if err != nil {
+		r.fail(BenchmarkScale, "", err)
+		return
+	}
+	for _, n := range r.cfg.Concurrency {
+		scenario := fmt.Sprintf("pods=%d", n)
+		for i := 0; i < r.cfg.Iterations && ctx.Err() == nil; i++ {
+			tls, err := r.runPods(ctx, image, n)
+			if err != nil {
+				r.fail(BenchmarkScale, scenario, err)
+				continue
+			}
+			first, last := tls[0].created, tls[0].running
+			for _, t := range tls {
+				r.report.record(BenchmarkScale, scenario, "start_seconds", UnitSeconds, t.startTime().Seconds())
+				if t.created.Before(first) {
+					first = t.created
+				}
+				if t.running.After(last) {
+					last = t.running
+				}
+			}
+			all := last.Sub(first)
+			r.report.record(BenchmarkScale, scenario, "all_started_seconds", UnitSeconds, all.Seconds())
+			if all > 0 {
+				r.report.record(BenchmarkScale, scenario, "pods_per_second", UnitPodsPerSecond, float64(n)/all.Seconds())
+			}
+			klog.InfoS("Timed scale", "pods", n, "iteration", i, "allStarted", all)
+		}
+	}
+}
+
+// runPods creates n pods of the image at once, waits for them to finish and
+// deletes them, returning their timelines
+func (r *runner) runPods(ctx context.Context, image string, n int) ([]*timeline, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.PodTimeout)
+	defer cancel()
+
+	names := make([]string, n)
+	tls := make([]*timeline, n)
+	for i := range names {
+		r.seq++
+		names[i] = fmt.Sprintf("bench-%d", r.seq)
+	}
+	defer r.deletePods(names)
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		tls[i] = r.pods.track(name)
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			_, err := r.cfg.Client.CoreV1().Pods(r.namespace).Create(ctx, r.podFor(name, image), metav1.CreateOptions{})
+			if err != nil {
+				errs[i] = fmt.Errorf("creating pod %s: %w", name, err)
+				return
+			}
+			select {
+			case <-tls[i].done:
+				errs[i] = tls[i].err
+			case <-ctx.Done():
+				errs[i] = fmt.Errorf("pod %s didn't finish within %s", name, r.cfg.PodTimeout)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	// Stop recording before the timelines are read, as pods that timed out
+	// may still be updated
+	for _, name := range names {
+		r.pods.forget(name)
+	}
+	return tls, errors.Join(errs...)
+}
+
+// deletePods deletes the pods and waits for them to be gone, so their
+// teardown doesn't slow down what is measured next
+func (r *runner) deletePods(names []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.PodTimeout)
+	defer cancel()
+	pods := r.cfg.Client.CoreV1().Pods(r.namespace)
+	for _, name := range names {
+		if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "Deleting pod", "pod", name)
+		}
+	}
+	selector := labels.SelectorFromSet(labels.Set{runLabel: r.id}).String()
+	err := wait.PollUntilContextCancel(ctx, 250*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, nil
+		}
+		return len(list.Items) == 0, nil
+	})
+	if err != nil {
+		klog.ErrorS(err, "Waiting for pods to be deleted", "namespace", r.namespace)
+	}
+}
+
+// podFor returns a pod that runs the image on the node once
+func (r *runner) podFor(name, image string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: r.namespace,
+			Labels:    map[string]string{runLabel: r.id},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:            "bench",
+				Image:           image,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+			}},
+			RestartPolicy: corev1.RestartPolicyNever,
+			NodeSelector:  map[string]string{archLabel: r.cfg.Arch},
+			Tolerations:   admission.Tolerations(r.cfg.Arch),
+			// The pod goes through the scheduler, as users' pods do, but
+			// only to the node being benchmarked
+			Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchFields: []corev1.NodeSelectorRequirement{{
+							Key:      "metadata.name",
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{r.cfg.NodeName},
+						}},
+					}},
+				},
+			}},
+		},
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+// fakeNode schedules the pods created on the client to the node and takes
+// them through the states krustlet reports, pausing between each
+func fakeNode(ctx context.Context, t *testing.T, client *fake.Clientset, node string) {
+	w, err := client.CoreV1().Pods("").Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer w.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-w.ResultChan():
+				if ev.Type != watch.Added {
+					continue
+				}
+				go runFakePod(ctx, client, node, ev.Object.(*corev1.Pod).DeepCopy())
+			}
+		}
+	}()
+}
+
+func runFakePod(ctx context.Context, client *fake.Clientset, node string, pod *corev1.Pod) {
+	pods := client.CoreV1().Pods(pod.Namespace)
+	steps := []func(*corev1.Pod){
+		func(p *corev1.Pod) { p.Spec.NodeName = node },
+		func(p *corev1.Pod) { p.Status = corev1.PodStatus{Phase: corev1.PodPending, Reason: reasonImagePull} },
+		func(p *corev1.Pod) { p.Status = corev1.PodStatus{Phase: corev1.PodRunning, Reason: "Running"} },
+		func(p *corev1.Pod) { p.Status = corev1.PodStatus{Phase: corev1.PodSucceeded, Reason: "Completed"} },
+	}
+	for _, step := range steps {
+		time.Sleep(5 * time.Millisecond)
+		step(pod)
+		var err error
+		if pod, err = pods.Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			return
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "krustlet"},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: "1.0.0-alpha.1"}},
+	}
+	client := fake.NewSimpleClientset(node)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	fakeNode(ctx, t, client, "krustlet")
+
+	reg := ocitest.NewServer(t)
+	repo, err := oci.ParseRepository(reg.Host() + "/krustlet-bench")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Run(ctx, Config{
+		Client:      client,
+		NodeName:    "krustlet",
+		Registry:    oci.NewClient(oci.WithPlainHTTP(reg.Host())),
+		Repository:  &repo,
+		Sizes:       []int64{64 << 10},
+		Concurrency: []int{3},
+		Iterations:  2,
+		PodTimeout:  10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Errors) != 0 {
+		t.Fatalf("unexpected errors %v", report.Errors)
+	}
+	if report.Node != "krustlet" || report.KubeletVersion != "1.0.0-alpha.1" {
+		t.Errorf("unexpected node in report %+v", report)
+	}
+
+	results := map[string]*Result{}
+	for _, res := range report.Results {
+		results[res.Key()] = res
+	}
+	for key, count := range map[string]int{
+		"pull/cold/64Ki/pull_seconds":      2,
+		"pull/cold/64Ki/throughput":        2,
+		"pull/warm/64Ki/pull_seconds":      2,
+		"pull/registry/64Ki/throughput":    2,
+		"start/cached/start_seconds":       2,
+		"start/cached/complete_seconds":    2,
+		"scale/pods=3/start_seconds":       6,
+		"scale/pods=3/all_started_seconds": 2,
+		"scale/pods=3/pods_per_second":     2,
+	} {
+		res, ok := results[key]
+		if !ok {
+			t.Errorf("missing result %s", key)
+			continue
+		}
+		if res.Count != count || res.Min <= 0 {
+			t.Errorf("%s: expected %d positive samples, got %v", key, count, res.Samples)
+		}
+	}
+
+	// The pods and the namespace are cleaned up
+	pods, _ := client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	namespaces, _ := client.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if len(pods.Items) != 0 || len(namespaces.Items) != 0 {
+		t.Errorf("expected everything to be cleaned up, got %d pods and %d namespaces", len(pods.Items), len(namespaces.Items))
+	}
+}
+
+func TestRunPullNeedsRegistry(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if _, err := Run(context.Background(), Config{Client: client, NodeName: "krustlet"}, BenchmarkPull); err == nil {
+		t.Fatal("expected an error without a registry")
+	}
+	if _, err := Run(context.Background(), Config{Client: client, NodeName: "krustlet"}, "latency"); err == nil {
+		t.Fatal("expected an error for an unknown benchmark")
+	}
+}
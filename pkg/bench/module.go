@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// moduleCode is a module whose _start returns straight away, so it runs
+// and exits as soon as krustlet starts it. It exports a memory, as WASI
+// commands do.
+var moduleCode = []byte{
+	0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00,
+	// Type section: one function type, () -> ()
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+	// Function section: one function of that type
+	0x03, 0x02, 0x01, 0x00,
+	// Memory section: one memory of one page
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// Export section: _start and memory
+	0x07, 0x13, 0x02,
+	0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x00,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	// Code section: one body with no locals that just ends
+	0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b,
+}
+
+// paddingSection names the custom section a module is padded to size with
+const paddingSection = "krustlet-bench"
+
+// paddingOverhead is the size of the padding section apart from its
+// padding: the section ID, its length as a five byte LEB128, which the wasm
+// spec allows for any u32, and its name
+const paddingOverhead = 1 + 5 + 1 + len(paddingSection)
+
+// MinModuleSize is the size of the smallest module Module can make
+var MinModuleSize = int64(len(moduleCode) + paddingOverhead)
+
+// Module returns a module of exactly size bytes that exits as soon as it is
+// started. It is padded with random bytes in a custom section, so every
+// module is different, can't be compressed and is never already cached by
+// the node or the registry.
+func Module(size int64) ([]byte, error) {
+	if size < MinModuleSize || size > 1<<31 {
+		return nil, fmt.Errorf("modules must be between %d bytes and 2Gi", MinModuleSize)
+	}
+	padding := size - MinModuleSize
+	payload := uint32(1 + len(paddingSection) + int(padding))
+
+	module := make([]byte, size)
+	n := copy(module, moduleCode)
+	module[n] = 0x00
+	n++
+	// A fixed length keeps the size exact whatever the padding
+	for i := 0; i < 5; i++ {
+		c := byte(payload >> (7 * i) & 0x7f)
+		if i < 4 {
+			c |= 0x80
+		}
+		module[n] = c
+		n++
+	}
+	module[n] = byte(len(paddingSection))
+	n++
+	n += copy(module[n:], paddingSection)
+	if _, err := rand.Read(module[n:]); err != nil {
+		return nil, err
+	}
+	return module, nil
+}
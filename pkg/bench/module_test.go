@@ -0,0 +1,39 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/wasm"
+)
+
+func TestModule(t *testing.T) {
+	for _, size := range []int64{MinModuleSize, MinModuleSize + 1, 200, 16*1024 + 3, 1 << 20} {
+		module, err := Module(size)
+		if err != nil {
+			t.Fatalf("%d: %s", size, err)
+		}
+		if int64(len(module)) != size {
+			t.Errorf("%d: got a module of %d bytes", size, len(module))
+		}
+		m, err := wasm.Parse(module)
+		if err != nil {
+			t.Fatalf("%d: %s", size, err)
+		}
+		if _, ok := m.Export("_start"); !ok {
+			t.Errorf("%d: expected the module to export _start", size)
+		}
+		if len(m.ImportModules()) != 0 {
+			t.Errorf("%d: expected no imports, got %v", size, m.ImportModules())
+		}
+	}
+
+	a, _ := Module(4096)
+	b, _ := Module(4096)
+	if bytes.Equal(a, b) {
+		t.Error("expected every module to be different")
+	}
+	if _, err := Module(MinModuleSize - 1); err == nil {
+		t.Error("expected an error for a module smaller than the minimum")
+	}
+}
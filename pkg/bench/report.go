@@ -0,0 +1,191 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Units results are measured in
+const (
+	UnitSeconds         = "seconds"
+	UnitMebibytesPerSec = "MiB/s"
+	UnitPodsPerSecond   = "pods/s"
+)
+
+// reportVersion is bumped when reports change in a way Compare can't read
+const reportVersion = 1
+
+// Report is the outcome of a benchmark run
+type Report struct {
+	Version int       `json:"version"`
+	Started time.Time `json:"started"`
+	// Node is the krustlet node benchmarked, and KubeletVersion the version
+	// it reports, so reports from different releases can be told apart
+	Node           string    `json:"node"`
+	KubeletVersion string    `json:"kubeletVersion"`
+	Iterations     int       `json:"iterations"`
+	Results        []*Result `json:"results"`
+	// Errors are the scenarios that failed. Their results are missing or
+	// have fewer samples.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Result is a metric measured in a scenario of a benchmark, such as the
+// pull_seconds of pull's cold/10Mi scenario
+type Result struct {
+	Benchmark string `json:"benchmark"`
+	Scenario  string `json:"scenario"`
+	Metric    string `json:"metric"`
+	Unit      string `json:"unit"`
+	// HigherIsBetter is set for rates, for which a drop is a regression
+	HigherIsBetter bool      `json:"higherIsBetter,omitempty"`
+	Samples        []float64 `json:"samples"`
+	Summary
+}
+
+// Summary summarises a result's samples
+type Summary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	Max   float64 `json:"max"`
+}
+
+// Key identifies the result across reports
+func (r *Result) Key() string {
+	return r.Benchmark + "/" + r.Scenario + "/" + r.Metric
+}
+
+func (r *Result) summarise() {
+	s := append([]float64(nil), r.Samples...)
+	sort.Float64s(s)
+	r.Summary = Summary{Count: len(s)}
+	if len(s) == 0 {
+		return
+	}
+	var sum float64
+	for _, v := range s {
+		sum += v
+	}
+	r.Min, r.Max, r.Mean = s[0], s[len(s)-1], sum/float64(len(s))
+	r.P50, r.P95 = percentile(s, 0.5), percentile(s, 0.95)
+}
+
+// percentile interpolates between the closest ranks of the sorted samples
+func percentile(sorted []float64, p float64) float64 {
+	rank := p * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// record adds a sample to the result with the key, creating it if needed
+func (r *Report) record(benchmark, scenario, metric, unit string, value float64) {
+	for _, res := range r.Results {
+		if res.Benchmark == benchmark && res.Scenario == scenario && res.Metric == metric {
+			res.Samples = append(res.Samples, value)
+			res.summarise()
+			return
+		}
+	}
+	res := &Result{
+		Benchmark:      benchmark,
+		Scenario:       scenario,
+		Metric:         metric,
+		Unit:           unit,
+		HigherIsBetter: unit != UnitSeconds,
+		Samples:        []float64{value},
+	}
+	res.summarise()
+	r.Results = append(r.Results, res)
+}
+
+// WriteJSON writes the report, with every sample, as indented JSON
+func WriteJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// ReadJSON reads a report written by WriteJSON
+func ReadJSON(rd io.Reader) (*Report, error) {
+	var r Report
+	if err := json.NewDecoder(rd).Decode(&r); err != nil {
+		return nil, fmt.Errorf("reading report: %w", err)
+	}
+	if r.Version != reportVersion {
+		return nil, fmt.Errorf("unsupported report version %d", r.Version)
+	}
+	return &r, nil
+}
+
+// csvHeader is the header row WriteCSV writes
+var csvHeader = []string{"node", "kubelet_version", "benchmark", "scenario", "metric", "unit", "count", "min", "mean", "p50", "p95", "max"}
+
+// WriteCSV writes a row summarising each result, for spreadsheets and
+// plotting. Every row repeats the node and its version, so rows from several
+// reports can be concatenated.
+func WriteCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, res := range r.Results {
+		err := cw.Write([]string{
+			r.Node, r.KubeletVersion, res.Benchmark, res.Scenario, res.Metric, res.Unit,
+			strconv.Itoa(res.Count), f(res.Min), f(res.Mean), f(res.P50), f(res.P95), f(res.Max),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Change is how a result differs between two reports
+type Change struct {
+	Key  string
+	Unit string
+	// Old and New are the medians
+	Old, New float64
+	// Ratio is how much worse New is than Old, as a fraction: 0.2 is 20%
+	// slower, or a 20% lower rate. It is negative for improvements.
+	Ratio float64
+}
+
+// Regression reports whether the change is worse than the threshold
+func (c Change) Regression(threshold float64) bool {
+	return c.Ratio > threshold
+}
+
+// Compare returns the change in the median of each result in both reports,
+// in the order of the new report
+func Compare(old, new *Report) []Change {
+	prev := map[string]*Result{}
+	for _, res := range old.Results {
+		prev[res.Key()] = res
+	}
+	var changes []Change
+	for _, res := range new.Results {
+		o, ok := prev[res.Key()]
+		if !ok || o.Unit != res.Unit || o.Count == 0 || res.Count == 0 || o.P50 == 0 {
+			continue
+		}
+		ratio := (res.P50 - o.P50) / o.P50
+		if res.HigherIsBetter {
+			ratio = -ratio
+		}
+		changes = append(changes, Change{Key: res.Key(), Unit: res.Unit, Old: o.P50, New: res.P50, Ratio: ratio})
+	}
+	return changes
+}
@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	r := &Report{}
+	for _, v := range []float64{4, 1, 3, 2, 5} {
+		r.record(BenchmarkStart, "cached", "start_seconds", UnitSeconds, v)
+	}
+	r.record(BenchmarkPull, "cold/1Mi", "throughput", UnitMebibytesPerSec, 12.5)
+	if len(r.Results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(r.Results))
+	}
+	want := Summary{Count: 5, Min: 1, Mean: 3, P50: 3, P95: 4.8, Max: 5}
+	if got := r.Results[0].Summary; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if r.Results[0].HigherIsBetter || !r.Results[1].HigherIsBetter {
+		t.Error("expected only rates to be better higher")
+	}
+}
+
+func TestWriteAndReadJSON(t *testing.T) {
+	r := &Report{Version: reportVersion, Node: "krustlet", KubeletVersion: "1.0.0-alpha.1", Iterations: 2}
+	r.record(BenchmarkStart, "cached", "start_seconds", UnitSeconds, 1.5)
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("got %+v, want %+v", got, r)
+	}
+
+	if _, err := ReadJSON(strings.NewReader(`{"version": 99}`)); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	r := &Report{Node: "krustlet", KubeletVersion: "1.0.0-alpha.1"}
+	r.record(BenchmarkPull, "cold/10Mi", "throughput", UnitMebibytesPerSec, 20)
+	r.record(BenchmarkPull, "cold/10Mi", "throughput", UnitMebibytesPerSec, 30)
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	want := "node,kubelet_version,benchmark,scenario,metric,unit,count,min,mean,p50,p95,max\n" +
+		"krustlet,1.0.0-alpha.1,pull,cold/10Mi,throughput,MiB/s,2,20.0000,25.0000,25.0000,29.5000,30.0000\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old, new := &Report{}, &Report{}
+	old.record(BenchmarkStart, "cached", "start_seconds", UnitSeconds, 2)
+	new.record(BenchmarkStart, "cached", "start_seconds", UnitSeconds, 2.5)
+	old.record(BenchmarkPull, "cold/1Mi", "throughput", UnitMebibytesPerSec, 20)
+	new.record(BenchmarkPull, "cold/1Mi", "throughput", UnitMebibytesPerSec, 25)
+	// Only in the new report
+	new.record(BenchmarkScale, "pods=5", "start_seconds", UnitSeconds, 3)
+
+	changes := Compare(old, new)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	if c := changes[0]; c.Key != "start/cached/start_seconds" || c.Ratio != 0.25 || !c.Regression(0.1) || c.Regression(0.3) {
+		t.Errorf("unexpected change %+v", c)
+	}
+	// A higher rate is an improvement
+	if c := changes[1]; c.Ratio != -0.25 || c.Regression(0) {
+		t.Errorf("unexpected change %+v", c)
+	}
+}
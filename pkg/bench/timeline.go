@@ -0,0 +1,153 @@
+package bench
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons krustlet reports in the pod status while a pod moves through its
+// state machine. See crates/kubelet/src/state/common.
+const (
+	reasonRegistered       = "Registered"
+	reasonImagePull        = "ImagePull"
+	reasonImagePullBackoff = "ImagePullBackoff"
+)
+
+// timeline records when the benchmark saw a pod reach each step of its
+// start. Krustlet doesn't report when its steps begin or end, and the pod's
+// own timestamps only have a resolution of a second, so the times are when
+// the watch delivered each update and are only as precise as the watch.
+type timeline struct {
+	created   time.Time
+	scheduled time.Time
+	// pulling is when the pod was seen in ImagePull. It stays zero if the
+	// pull was too quick for the watch to see, as cached modules usually
+	// are.
+	pulling  time.Time
+	pulled   time.Time
+	running  time.Time
+	finished time.Time
+	err      error
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newTimeline(created time.Time) *timeline {
+	return &timeline{created: created, done: make(chan struct{})}
+}
+
+// observe records an update of the pod seen at now
+func (t *timeline) observe(pod *corev1.Pod, now time.Time) {
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+
+	if t.scheduled.IsZero() && pod.Spec.NodeName != "" {
+		t.scheduled = now
+	}
+	phase, reason := pod.Status.Phase, pod.Status.Reason
+	switch {
+	case reason == reasonImagePullBackoff:
+		t.finish(fmt.Errorf("pulling the module failed: %s", pod.Status.Message))
+		return
+	case reason == reasonImagePull && t.pulling.IsZero():
+		t.pulling = now
+	}
+	pastPull := (phase != "" && phase != corev1.PodPending) ||
+		(reason != "" && reason != reasonRegistered && reason != reasonImagePull)
+	if pastPull && t.pulled.IsZero() && !t.scheduled.IsZero() {
+		t.pulled = now
+	}
+	if (phase == corev1.PodRunning || phase == corev1.PodSucceeded) && t.running.IsZero() {
+		t.running = now
+	}
+	switch phase {
+	case corev1.PodSucceeded:
+		t.finished = now
+		t.finish(nil)
+	case corev1.PodFailed:
+		t.finish(fmt.Errorf("pod failed: %s %s", reason, pod.Status.Message))
+	}
+}
+
+func (t *timeline) finish(err error) {
+	t.once.Do(func() {
+		t.err = err
+		close(t.done)
+	})
+}
+
+// scheduleTime is how long the pod took to be bound to the node
+func (t *timeline) scheduleTime() time.Duration {
+	return t.scheduled.Sub(t.created)
+}
+
+// pullTime is how long krustlet took to get the pod's module, from when it
+// was seen pulling or, if the pull was too quick to see, from when it was
+// scheduled
+func (t *timeline) pullTime() time.Duration {
+	start := t.pulling
+	if start.IsZero() {
+		start = t.scheduled
+	}
+	return t.pulled.Sub(start)
+}
+
+// startTime is how long the pod took from being created to its module
+// running. Modules that exit straight away can finish before the watch sees
+// them running, in which case it is when they were seen finished.
+func (t *timeline) startTime() time.Duration {
+	return t.running.Sub(t.created)
+}
+
+// completeTime is how long the pod took from being created to finishing
+func (t *timeline) completeTime() time.Duration {
+	return t.finished.Sub(t.created)
+}
+
+// podTimelines hands pod updates to the timeline of each pod
+type podTimelines struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	pods map[string]*timeline
+}
+
+func newPodTimelines(now func() time.Time) *podTimelines {
+	return &podTimelines{now: now, pods: map[string]*timeline{}}
+}
+
+// track starts a timeline for a pod about to be created
+func (p *podTimelines) track(name string) *timeline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := newTimeline(p.now())
+	p.pods[name] = t
+	return t
+}
+
+// forget stops recording a pod's updates
+func (p *podTimelines) forget(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pods, name)
+}
+
+func (p *podTimelines) observe(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	now := p.now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.pods[pod.Name]; ok {
+		t.observe(pod, now)
+	}
+}
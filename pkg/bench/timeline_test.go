@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func podIn(node string, phase corev1.PodPhase, reason string) *corev1.Pod {
+	return &corev1.Pod{
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Phase: phase, Reason: reason},
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return t0.Add(time.Duration(ms) * time.Millisecond) }
+	tl := newTimeline(t0)
+	tl.observe(podIn("", corev1.PodPending, ""), at(10))
+	tl.observe(podIn("krustlet", corev1.PodPending, ""), at(100))
+	tl.observe(podIn("krustlet", corev1.PodPending, reasonRegistered), at(150))
+	tl.observe(podIn("krustlet", corev1.PodPending, reasonImagePull), at(200))
+	tl.observe(podIn("krustlet", corev1.PodPending, "VolumeMount"), at(1200))
+	tl.observe(podIn("krustlet", corev1.PodRunning, "Running"), at(1300))
+	tl.observe(podIn("krustlet", corev1.PodSucceeded, "Completed"), at(1500))
+
+	select {
+	case <-tl.done:
+	default:
+		t.Fatal("expected the timeline to be done")
+	}
+	if tl.err != nil {
+		t.Fatal(tl.err)
+	}
+	for name, tt := range map[string]struct{ got, want time.Duration }{
+		"schedule": {tl.scheduleTime(), 100 * time.Millisecond},
+		"pull":     {tl.pullTime(), time.Second},
+		"start":    {tl.startTime(), 1300 * time.Millisecond},
+		"complete": {tl.completeTime(), 1500 * time.Millisecond},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %s, want %s", name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestTimelineQuickPull(t *testing.T) {
+	// A cached module is pulled too quickly to see, so the pull is timed
+	// from the pod being scheduled
+	t0 := time.Unix(1000, 0)
+	tl := newTimeline(t0)
+	tl.observe(podIn("krustlet", corev1.PodPending, ""), t0.Add(100*time.Millisecond))
+	tl.observe(podIn("krustlet", corev1.PodSucceeded, "Completed"), t0.Add(400*time.Millisecond))
+	if got := tl.pullTime(); got != 300*time.Millisecond {
+		t.Errorf("got %s", got)
+	}
+	if got := tl.startTime(); got != 400*time.Millisecond {
+		t.Errorf("expected a module seen finished to count as started then, got %s", got)
+	}
+}
+
+func TestTimelineFailure(t *testing.T) {
+	for _, pod := range []*corev1.Pod{
+		podIn("krustlet", corev1.PodPending, reasonImagePullBackoff),
+		podIn("krustlet", corev1.PodFailed, "Error"),
+	} {
+		tl := newTimeline(time.Now())
+		tl.observe(pod, time.Now())
+		select {
+		case <-tl.done:
+		default:
+			t.Fatalf("%s: expected the timeline to be done", pod.Status.Reason)
+		}
+		if tl.err == nil {
+			t.Errorf("%s: expected an error", pod.Status.Reason)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package bootstrap creates the bootstrap tokens and bootstrap kubeconfigs
+// new krustlet nodes request their certificates with.
+package bootstrap
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// TokenNamespace is the only namespace the API server reads bootstrap
+	// tokens from
+	TokenNamespace = "kube-system"
+	tokenUser      = "tls-bootstrap-token-user"
+	tokenContext   = "tls-bootstrap-token-user@kubernetes"
+	// tokenGroup is the group krustlet joins as. kubeadm clusters already
+	// allow it to create and auto-approve node client CSRs
+	tokenGroup = "system:bootstrappers:kubeadm:default-node-token"
+
+	tokenChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// minServerVersion is the oldest API server with certificates.k8s.io/v1,
+// which krustlet uses for its CSRs
+var minServerVersion = version.MustParseGeneric("v1.19.0")
+
+// Token is a bootstrap token in its two halves
+type Token struct {
+	ID     string
+	Secret string
+}
+
+func (t Token) String() string {
+	return t.ID + "." + t.Secret
+}
+
+// NewToken generates a token in the format the API server requires:
+// a 6 character id and 16 character secret of lower case letters and digits
+func NewToken() (Token, error) {
+	id, err := randomString(6)
+	if err != nil {
+		return Token{}, err
+	}
+	secret, err := randomString(16)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{ID: id, Secret: secret}, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(tokenChars)))
+	for i := range b {
+		c, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("generating token: %w", err)
+		}
+		b[i] = tokenChars[c.Int64()]
+	}
+	return string(b), nil
+}
+
+// tokenSecret returns the secret that makes the API server accept the token
+func tokenSecret(token Token, expiration time.Time) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + token.ID,
+			Namespace: TokenNamespace,
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			"auth-extra-groups":              tokenGroup,
+			"expiration":                     expiration.UTC().Format(time.RFC3339),
+			"token-id":                       token.ID,
+			"token-secret":                   token.Secret,
+			"usage-bootstrap-authentication": "true",
+			"usage-bootstrap-signing":        "true",
+		},
+	}
+}
+
+// CreateTokenSecret creates the secret that makes the API server accept the
+// token until it expires
+func CreateTokenSecret(ctx context.Context, client kubernetes.Interface, token Token, expiration time.Time) error {
+	_, err := client.CoreV1().Secrets(TokenNamespace).Create(ctx, tokenSecret(token, expiration), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating bootstrap token secret: %w", err)
+	}
+	return nil
+}
+
+// CheckCluster makes sure the cluster is new enough for krustlet and that the
+// current user can create bootstrap tokens, so problems show up before any
+// files are written rather than when krustlet first starts
+func CheckCluster(ctx context.Context, client kubernetes.Interface) error {
+	info, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return fmt.Errorf("contacting the API server: %w", err)
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return fmt.Errorf("parsing server version %q: %w", info.GitVersion, err)
+	}
+	if v.LessThan(minServerVersion) {
+		return fmt.Errorf("cluster is running Kubernetes %s, but krustlet requires v%s or newer", info.GitVersion, minServerVersion)
+	}
+
+	review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: TokenNamespace,
+				Verb:      "create",
+				Resource:  "secrets",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("checking permissions: %w", err)
+	}
+	if !review.Status.Allowed {
+		return fmt.Errorf("the current user cannot create secrets in %s, which is needed for the bootstrap token; use a context with cluster admin rights", TokenNamespace)
+	}
+	return nil
+}
+
+// Kubeconfig builds the kubeconfig krustlet bootstraps with. It
+// points at the cluster of the given context, with any certificate authority
+// file inlined so the kubeconfig can be copied to another machine.
+func Kubeconfig(source *clientcmdapi.Config, contextName string, token Token) (*clientcmdapi.Config, error) {
+	kubeContext, ok := source.Contexts[contextName]
+	if !ok {
+		return nil, fmt.Errorf("context %q not found in kubeconfig", contextName)
+	}
+	sourceCluster, ok := source.Clusters[kubeContext.Cluster]
+	if !ok {
+		return nil, fmt.Errorf("cluster %q for context %q not found in kubeconfig", kubeContext.Cluster, contextName)
+	}
+
+	cluster := sourceCluster.DeepCopy()
+	cluster.LocationOfOrigin = ""
+	if cluster.CertificateAuthority != "" {
+		ca, err := os.ReadFile(cluster.CertificateAuthority)
+		if err != nil {
+			return nil, fmt.Errorf("reading certificate authority for cluster %q: %w", kubeContext.Cluster, err)
+		}
+		cluster.CertificateAuthorityData = ca
+		cluster.CertificateAuthority = ""
+	}
+
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters[kubeContext.Cluster] = cluster
+	cfg.AuthInfos[tokenUser] = &clientcmdapi.AuthInfo{Token: token.String()}
+	cfg.Contexts[tokenContext] = &clientcmdapi.Context{
+		Cluster:   kubeContext.Cluster,
+		AuthInfo:  tokenUser,
+		Namespace: TokenNamespace,
+	}
+	cfg.CurrentContext = tokenContext
+	return cfg, nil
+}
@@ -0,0 +1,124 @@
+package bootstrap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestNewBootstrapToken(t *testing.T) {
+	token, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// This is the format the API server accepts for bootstrap tokens
+	if !regexp.MustCompile(`^[a-z0-9]{6}\.[a-z0-9]{16}$`).MatchString(token.String()) {
+		t.Errorf("token %q is not a valid bootstrap token", token)
+	}
+}
+
+func TestCreateTokenSecret(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	token := Token{ID: "abcdef", Secret: "0123456789abcdef"}
+	expiration := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := CreateTokenSecret(context.Background(), client, token, expiration); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := client.CoreV1().Secrets("kube-system").Get(context.Background(), "bootstrap-token-abcdef", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeBootstrapToken {
+		t.Errorf("unexpected secret type %q", secret.Type)
+	}
+	if secret.StringData["expiration"] != "2021-06-01T12:00:00Z" || secret.StringData["token-secret"] != token.Secret {
+		t.Errorf("unexpected secret data %v", secret.StringData)
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		allowed bool
+		wantErr string
+	}{
+		{name: "ok", version: "v1.21.2", allowed: true},
+		{name: "old cluster", version: "v1.18.9", allowed: true, wantErr: "requires v1.19.0"},
+		{name: "not allowed", version: "v1.22.0+k3s1", allowed: false, wantErr: "cannot create secrets"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: tt.version}
+			client.PrependReactor("create", "selfsubjectaccessreviews", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, &authorizationv1.SelfSubjectAccessReview{
+					Status: authorizationv1.SubjectAccessReviewStatus{Allowed: tt.allowed},
+				}, nil
+			})
+
+			err := CheckCluster(context.Background(), client)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestKubeconfig(t *testing.T) {
+	ca := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(ca, []byte("CA DATA"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	source := clientcmdapi.NewConfig()
+	source.Clusters["kind-krustlet"] = &clientcmdapi.Cluster{Server: "https://127.0.0.1:6443", CertificateAuthority: ca}
+	source.Clusters["other"] = &clientcmdapi.Cluster{Server: "https://other:6443"}
+	source.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "admin-token"}
+	source.Contexts["kind-krustlet"] = &clientcmdapi.Context{Cluster: "kind-krustlet", AuthInfo: "admin"}
+	source.CurrentContext = "kind-krustlet"
+
+	token := Token{ID: "abcdef", Secret: "0123456789abcdef"}
+	cfg, err := Kubeconfig(source, "kind-krustlet", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Clusters) != 1 || len(cfg.AuthInfos) != 1 {
+		t.Errorf("expected only the bootstrap cluster and user, got %d clusters and %d users", len(cfg.Clusters), len(cfg.AuthInfos))
+	}
+	cluster := cfg.Clusters["kind-krustlet"]
+	if cluster.Server != "https://127.0.0.1:6443" || string(cluster.CertificateAuthorityData) != "CA DATA" || cluster.CertificateAuthority != "" {
+		t.Errorf("unexpected cluster %+v", cluster)
+	}
+	if cfg.AuthInfos[tokenUser].Token != "abcdef.0123456789abcdef" {
+		t.Errorf("unexpected user %+v", cfg.AuthInfos[tokenUser])
+	}
+	if c := cfg.Contexts[cfg.CurrentContext]; c == nil || c.AuthInfo != tokenUser || c.Namespace != "kube-system" {
+		t.Errorf("unexpected context %+v", c)
+	}
+
+	if _, err := Kubeconfig(source, "missing", token); err == nil {
+		t.Error("expected a missing context to be an error")
+	}
+}
@@ -0,0 +1,306 @@
+// Package certrotate renews a krustlet node's certificates before they
+// expire.
+//
+// Krustlet requests a client certificate and a serving certificate when it
+// bootstraps, and never again, so a year later both expire: the node can no
+// longer reach the API server and goes NotReady, and nothing warns anyone
+// beforehand. The manager checks both certificates and, once a certificate
+// is most of the way through its lifetime, requests a replacement for a new
+// key with a CSR the node signs in as itself, waits for it to be approved,
+// and swaps the files. Each certificate renews at a point between 70% and
+// 90% of its lifetime that is fixed by its serial number, as the kubelet's
+// certificate manager does, so nodes that bootstrapped together don't all
+// renew at once.
+//
+// Renewals and failures are recorded as events on the node, and a failure
+// is retried at every check, with a warning event once the certificate is
+// within ExpiryWarning of expiring.
+package certrotate
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// Defaults for Options left unset
+const (
+	DefaultExpiryWarning   = 14 * 24 * time.Hour
+	DefaultApprovalTimeout = 15 * time.Minute
+)
+
+const component = "krustlet-certrotate"
+
+// Kind is which of the node's certificates a status is for
+type Kind string
+
+const (
+	// Client is the certificate krustlet authenticates to the API server
+	// with, kept in its kubeconfig
+	Client Kind = "client"
+	// Serving is the certificate of krustlet's node API, krustlet.crt
+	Serving Kind = "serving"
+)
+
+// Options say where the node's certificates are and how to renew them
+type Options struct {
+	// NodeName is the node the certificates are for, which events are
+	// recorded on
+	NodeName string
+	// Kubeconfig is krustlet's kubeconfig, holding its client certificate.
+	// The client certificate isn't renewed if it is empty.
+	Kubeconfig string
+	// ServingCert and ServingKey are krustlet's serving certificate and key.
+	// The serving certificate isn't renewed if they are empty.
+	ServingCert string
+	ServingKey  string
+	// ServingDNSNames and ServingIPs replace the names the serving
+	// certificate is issued for, such as after the node's IP changed. The
+	// current certificate's names are kept if both are empty.
+	ServingDNSNames []string
+	ServingIPs      []net.IP
+	// ExpiryWarning is how close to expiry a certificate that fails to renew
+	// is reported with a warning event
+	ExpiryWarning time.Duration
+	// ApprovalTimeout is how long to wait for a CSR to be approved and
+	// issued before giving up until the next check
+	ApprovalTimeout time.Duration
+	// AfterRotate is called after certificates were replaced, with the kinds
+	// replaced, such as to restart krustlet so it loads them
+	AfterRotate func(ctx context.Context, kinds []Kind) error
+}
+
+// Status is the state of one certificate after a check
+type Status struct {
+	Kind      Kind
+	Path      string
+	NotBefore time.Time
+	NotAfter  time.Time
+	// RenewAt is when the certificate is due for renewal
+	RenewAt time.Time
+	// Renewed is set if the check replaced the certificate, in which case
+	// the times are the new certificate's
+	Renewed bool
+	// Err is why the certificate couldn't be read or renewed
+	Err error
+}
+
+// ClientFunc returns a client authenticated as the node. It is called for
+// every check, so a client certificate renewed by the previous check is
+// used.
+type ClientFunc func() (kubernetes.Interface, error)
+
+// Manager checks and renews a node's certificates
+type Manager struct {
+	newClient ClientFunc
+	opts      Options
+	now       func() time.Time
+	poll      time.Duration
+}
+
+// New returns a manager for the node's certificates
+func New(newClient ClientFunc, opts Options) *Manager {
+	if opts.ExpiryWarning == 0 {
+		opts.ExpiryWarning = DefaultExpiryWarning
+	}
+	if opts.ApprovalTimeout == 0 {
+		opts.ApprovalTimeout = DefaultApprovalTimeout
+	}
+	return &Manager{newClient: newClient, opts: opts, now: time.Now, poll: 2 * time.Second}
+}
+
+// cert is a certificate the manager looks after
+type cert struct {
+	kind  Kind
+	store store
+}
+
+func (m *Manager) certs() []cert {
+	var out []cert
+	if m.opts.Kubeconfig != "" {
+		out = append(out, cert{kind: Client, store: kubeconfigStore{path: m.opts.Kubeconfig}})
+	}
+	if m.opts.ServingCert != "" {
+		out = append(out, cert{kind: Serving, store: fileStore{cert: m.opts.ServingCert, key: m.opts.ServingKey}})
+	}
+	return out
+}
+
+// Run checks the certificates every interval until the context is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Failures are logged, recorded on the node and retried at the next
+		// check
+		_, _ = m.Check(ctx, false)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check renews the certificates that are due, or all of them if force is
+// set, and returns their status. The error joins the statuses' errors and
+// AfterRotate's.
+func (m *Manager) Check(ctx context.Context, force bool) ([]Status, error) {
+	var statuses []Status
+	var renewed []Kind
+	var client kubernetes.Interface
+	var errs []error
+	for _, c := range m.certs() {
+		st := Status{Kind: c.kind, Path: c.store.String()}
+		current, err := c.store.load()
+		if err != nil {
+			st.Err = fmt.Errorf("reading %s certificate: %w", c.kind, err)
+			klog.ErrorS(err, "Failed to read certificate", "kind", c.kind, "path", st.Path)
+			statuses = append(statuses, st)
+			errs = append(errs, st.Err)
+			continue
+		}
+		st.NotBefore, st.NotAfter, st.RenewAt = current.NotBefore, current.NotAfter, renewAt(current)
+		now := m.now()
+		if !force && now.Before(st.RenewAt) {
+			klog.V(2).InfoS("Certificate not due for renewal", "kind", c.kind, "expires", st.NotAfter, "renewAt", st.RenewAt)
+			statuses = append(statuses, st)
+			continue
+		}
+
+		if client == nil {
+			if client, err = m.newClient(); err != nil {
+				st.Err = fmt.Errorf("connecting to the API server: %w", err)
+			}
+		}
+		if st.Err == nil {
+			st.Err = m.renew(ctx, client, c, current)
+		}
+		if st.Err != nil {
+			errs = append(errs, st.Err)
+			m.reportFailure(ctx, client, c, current, st.Err)
+			statuses = append(statuses, st)
+			continue
+		}
+		// Read back what was written, so the status is what krustlet will load
+		if replaced, err := c.store.load(); err == nil {
+			st.NotBefore, st.NotAfter, st.RenewAt = replaced.NotBefore, replaced.NotAfter, renewAt(replaced)
+		}
+		st.Renewed = true
+		renewed = append(renewed, c.kind)
+		klog.InfoS("Renewed certificate", "kind", c.kind, "path", st.Path, "expires", st.NotAfter)
+		m.recordEvent(ctx, client, corev1.EventTypeNormal, "CertificateRotated",
+			fmt.Sprintf("Renewed the %s certificate; it now expires %s", c.kind, st.NotAfter.UTC().Format(time.RFC3339)))
+		statuses = append(statuses, st)
+	}
+
+	if len(renewed) > 0 && m.opts.AfterRotate != nil {
+		if err := m.opts.AfterRotate(ctx, renewed); err != nil {
+			klog.ErrorS(err, "Failed to run the post rotation hook", "renewed", renewed)
+			errs = append(errs, fmt.Errorf("after rotating certificates: %w", err))
+		}
+	}
+	return statuses, errors.Join(errs...)
+}
+
+// renew replaces the certificate with one for a new key and the same
+// identity
+func (m *Manager) renew(ctx context.Context, client kubernetes.Interface, c cert, current *x509.Certificate) error {
+	if m.now().After(current.NotAfter) {
+		return fmt.Errorf("the %s certificate expired %s and can't be renewed with itself; bootstrap the node again", c.kind, current.NotAfter.UTC().Format(time.RFC3339))
+	}
+	r := request{
+		name:     m.opts.NodeName + "-" + string(c.kind),
+		template: &x509.CertificateRequest{Subject: current.Subject},
+	}
+	// The subject's other attributes are dropped, so the request matches
+	// what krustlet asks for when it bootstraps
+	r.template.Subject.ExtraNames = nil
+	switch c.kind {
+	case Client:
+		r.signer = certificatesv1.KubeAPIServerClientKubeletSignerName
+		r.usages = []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageKeyEncipherment, certificatesv1.UsageClientAuth}
+	case Serving:
+		r.signer = certificatesv1.KubeletServingSignerName
+		r.usages = []certificatesv1.KeyUsage{certificatesv1.UsageDigitalSignature, certificatesv1.UsageKeyEncipherment, certificatesv1.UsageServerAuth}
+		r.template.DNSNames, r.template.IPAddresses = current.DNSNames, current.IPAddresses
+		if len(m.opts.ServingDNSNames) > 0 || len(m.opts.ServingIPs) > 0 {
+			r.template.DNSNames, r.template.IPAddresses = m.opts.ServingDNSNames, m.opts.ServingIPs
+		}
+	}
+
+	klog.InfoS("Requesting certificate renewal", "kind", c.kind, "expires", current.NotAfter)
+	certPEM, keyPEM, err := requestCertificate(ctx, client, r, m.poll, m.opts.ApprovalTimeout)
+	if err != nil {
+		return fmt.Errorf("renewing the %s certificate: %w", c.kind, err)
+	}
+	if err := c.store.save(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("saving the renewed %s certificate: %w", c.kind, err)
+	}
+	return nil
+}
+
+// reportFailure logs a failed renewal and records it on the node, as a
+// warning that the node is about to drop off the cluster if it is close to
+// expiry
+func (m *Manager) reportFailure(ctx context.Context, client kubernetes.Interface, c cert, current *x509.Certificate, err error) {
+	left := current.NotAfter.Sub(m.now())
+	klog.ErrorS(err, "Failed to renew certificate", "kind", c.kind, "expires", current.NotAfter, "remaining", left.Round(time.Minute))
+	if client == nil {
+		return
+	}
+	reason := "CertificateRenewalFailed"
+	message := fmt.Sprintf("Failed to renew the %s certificate, which expires %s: %v", c.kind, current.NotAfter.UTC().Format(time.RFC3339), err)
+	if left < m.opts.ExpiryWarning {
+		reason = "CertificateExpiringSoon"
+		message = fmt.Sprintf("The %s certificate expires in %s and couldn't be renewed; the node will go NotReady when it does: %v", c.kind, left.Round(time.Hour), err)
+	}
+	m.recordEvent(ctx, client, corev1.EventTypeWarning, reason, message)
+}
+
+// recordEvent records an event on the node. Events are best effort.
+func (m *Manager) recordEvent(ctx context.Context, client kubernetes.Interface, eventType, reason, message string) {
+	if m.opts.NodeName == "" {
+		return
+	}
+	now := metav1.NewTime(m.now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", m.opts.NodeName, now.UnixNano()),
+			Namespace: metav1.NamespaceDefault,
+		},
+		InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: m.opts.NodeName, UID: types.UID(m.opts.NodeName)},
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1.EventSource{Component: component, Host: m.opts.NodeName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	if _, err := client.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		klog.ErrorS(err, "Failed to record event", "reason", reason)
+	}
+}
+
+// renewAt returns when the certificate is due for renewal: between 70% and
+// 90% of the way through its lifetime, at a point chosen by its serial
+// number
+func renewAt(c *x509.Certificate) time.Time {
+	h := fnv.New32a()
+	_, _ = h.Write(c.SerialNumber.Bytes())
+	fraction := 0.7 + 0.2*float64(h.Sum32())/float64(^uint32(0))
+	lifetime := c.NotAfter.Sub(c.NotBefore)
+	return c.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
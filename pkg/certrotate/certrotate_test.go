@@ -0,0 +1,293 @@
+package certrotate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// testCA signs certificates the way the cluster's signers do
+type testCA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             time.Now().Add(-365 * 24 * time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{key: key, cert: cert}
+}
+
+// issue signs a certificate for the template's subject and names, valid for
+// the given period, and returns it and its key in PEM form
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate, pub interface{}, notBefore time.Time, lifetime time.Duration) []byte {
+	t.Helper()
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = notBefore, notBefore.Add(lifetime)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, pub, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func (ca *testCA) keyPair(t *testing.T, tmpl *x509.Certificate, notBefore time.Time, lifetime time.Duration) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	return ca.issue(t, tmpl, &key.PublicKey, notBefore, lifetime), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// signer makes the fake clientset approve and issue CSRs as they are
+// created, or deny them
+func (ca *testCA) signer(t *testing.T, client *fake.Clientset, deny bool) {
+	client.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*certificatesv1.CertificateSigningRequest).DeepCopy()
+		csr.Name = csr.GenerateName + "abcde"
+		block, _ := pem.Decode(csr.Spec.Request)
+		req, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return true, nil, err
+		}
+		if deny {
+			csr.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{{Type: certificatesv1.CertificateDenied, Status: corev1.ConditionTrue, Message: "not today"}}
+		} else {
+			csr.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{{Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue}}
+			csr.Status.Certificate = ca.issue(t, &x509.Certificate{Subject: req.Subject, DNSNames: req.DNSNames, IPAddresses: req.IPAddresses}, req.PublicKey, time.Now().Add(-time.Minute), 365*24*time.Hour)
+		}
+		if err := client.Tracker().Add(csr); err != nil {
+			return true, nil, err
+		}
+		return true, csr, nil
+	})
+}
+
+// nodeFiles writes a kubeconfig with an embedded client certificate and a
+// serving certificate and key, as krustlet's bootstrap does
+func nodeFiles(t *testing.T, ca *testCA, notBefore time.Time, lifetime time.Duration) Options {
+	t.Helper()
+	dir := t.TempDir()
+	clientCert, clientKey := ca.keyPair(t, &x509.Certificate{Subject: pkix.Name{CommonName: "system:node:edge-1", Organization: []string{"system:nodes"}}}, notBefore, lifetime)
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["krustlet"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})}
+	cfg.AuthInfos["krustlet"] = &clientcmdapi.AuthInfo{ClientCertificateData: clientCert, ClientKeyData: clientKey}
+	cfg.Contexts["krustlet"] = &clientcmdapi.Context{Cluster: "krustlet", AuthInfo: "krustlet"}
+	cfg.CurrentContext = "krustlet"
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := clientcmd.WriteToFile(*cfg, kubeconfig); err != nil {
+		t.Fatal(err)
+	}
+
+	servingCert, servingKey := ca.keyPair(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "system:node:edge-1", Organization: []string{"system:nodes"}},
+		DNSNames:    []string{"edge-1"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.5")},
+	}, notBefore, lifetime)
+	opts := Options{
+		NodeName:    "edge-1",
+		Kubeconfig:  kubeconfig,
+		ServingCert: filepath.Join(dir, "krustlet.crt"),
+		ServingKey:  filepath.Join(dir, "krustlet.key"),
+	}
+	if err := os.WriteFile(opts.ServingCert, servingCert, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(opts.ServingKey, servingKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return opts
+}
+
+func events(t *testing.T, client kubernetes.Interface) map[string]int {
+	t.Helper()
+	list, err := client.CoreV1().Events(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]int{}
+	for _, e := range list.Items {
+		out[e.Reason]++
+	}
+	return out
+}
+
+func TestRenewDue(t *testing.T) {
+	ca := newTestCA(t)
+	// 95 days into a 100 day certificate, past the latest renewal point
+	opts := nodeFiles(t, ca, time.Now().Add(-95*24*time.Hour), 100*24*time.Hour)
+	var rotated []Kind
+	opts.AfterRotate = func(_ context.Context, kinds []Kind) error {
+		rotated = kinds
+		return nil
+	}
+	client := fake.NewSimpleClientset()
+	ca.signer(t, client, false)
+	m := New(func() (kubernetes.Interface, error) { return client, nil }, opts)
+	m.poll = time.Millisecond
+
+	statuses, err := m.Check(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Renewed || !statuses[1].Renewed || len(rotated) != 2 {
+		t.Fatalf("expected both certificates to be renewed, got %+v", statuses)
+	}
+	for _, st := range statuses {
+		if time.Until(st.NotAfter) < 300*24*time.Hour {
+			t.Errorf("%s: expected the new certificate, got one expiring %s", st.Kind, st.NotAfter)
+		}
+	}
+
+	// The kubeconfig's embedded data and the serving files hold matching
+	// pairs, as krustlet loads them
+	cfg, err := clientcmd.LoadFromFile(opts.Kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := cfg.AuthInfos["krustlet"]
+	if _, err := tls.X509KeyPair(user.ClientCertificateData, user.ClientKeyData); err != nil {
+		t.Errorf("kubeconfig holds a mismatched pair: %v", err)
+	}
+	if _, err := tls.LoadX509KeyPair(opts.ServingCert, opts.ServingKey); err != nil {
+		t.Errorf("serving files hold a mismatched pair: %v", err)
+	}
+	served, _ := (fileStore{cert: opts.ServingCert}).load()
+	if len(served.DNSNames) != 1 || served.DNSNames[0] != "edge-1" || !served.IPAddresses[0].Equal(net.ParseIP("10.0.0.5")) {
+		t.Errorf("expected the serving certificate's names to carry over, got %v %v", served.DNSNames, served.IPAddresses)
+	}
+	if got := events(t, client)["CertificateRotated"]; got != 2 {
+		t.Errorf("expected 2 rotation events, got %d", got)
+	}
+
+	// The new certificates aren't due, so nothing more is requested
+	before := len(client.Actions())
+	statuses, err = m.Check(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].Renewed || statuses[1].Renewed || len(client.Actions()) != before {
+		t.Errorf("expected fresh certificates to be left alone, got %+v", statuses)
+	}
+}
+
+func TestRenewDenied(t *testing.T) {
+	ca := newTestCA(t)
+	opts := nodeFiles(t, ca, time.Now().Add(-95*24*time.Hour), 100*24*time.Hour)
+	opts.ServingCert = ""
+	client := fake.NewSimpleClientset()
+	ca.signer(t, client, true)
+	m := New(func() (kubernetes.Interface, error) { return client, nil }, opts)
+	m.poll = time.Millisecond
+
+	original, _ := os.ReadFile(opts.Kubeconfig)
+	statuses, err := m.Check(context.Background(), false)
+	if err == nil || statuses[0].Err == nil || statuses[0].Renewed {
+		t.Fatalf("expected the denial to be reported, got %+v", statuses)
+	}
+	if current, _ := os.ReadFile(opts.Kubeconfig); string(current) != string(original) {
+		t.Error("expected the kubeconfig to be left alone")
+	}
+	if got := events(t, client)["CertificateExpiringSoon"]; got != 1 {
+		t.Errorf("expected a warning that the certificate is about to expire, got %v", events(t, client))
+	}
+}
+
+func TestRenewAt(t *testing.T) {
+	ca := newTestCA(t)
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		data := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "system:node:edge-1"}}, &ca.key.PublicKey, start, 100*time.Hour)
+		cert, _ := parseCertificate(data)
+		at := renewAt(cert)
+		if at.Before(start.Add(70*time.Hour)) || at.After(start.Add(90*time.Hour)) {
+			t.Errorf("renewal at %s is outside 70%%-90%% of the lifetime", at.Sub(start))
+		}
+		if !renewAt(cert).Equal(at) {
+			t.Error("expected the renewal point to be stable")
+		}
+	}
+}
+
+func TestSaveFiles(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("certificates are replaced without links on Windows")
+	}
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "krustlet.crt"), filepath.Join(dir, "krustlet.key")
+	clientCert, clientKey := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	for p, data := range map[string]string{cert: "cert 1", key: "key 1"} {
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(p string) string {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	for i, pair := range [][2]string{{"cert 2", "key 2"}, {"cert 3", "key 3"}} {
+		if err := saveFiles(cert, key, []byte(pair[0]), []byte(pair[1])); err != nil {
+			t.Fatal(err)
+		}
+		if err := saveFiles(clientCert, clientKey, []byte(fmt.Sprintf("client cert %d", i)), []byte(fmt.Sprintf("client key %d", i))); err != nil {
+			t.Fatal(err)
+		}
+		if got, got2 := read(cert), read(key); got != pair[0] || got2 != pair[1] {
+			t.Errorf("got %q and %q, want %q and %q", got, got2, pair[0], pair[1])
+		}
+		if got := read(clientCert); got != fmt.Sprintf("client cert %d", i) {
+			t.Errorf("expected certificates in the same directory to be kept apart, got %q", got)
+		}
+	}
+	for _, p := range []string{cert, key} {
+		if target, err := os.Readlink(p); err != nil || target != filepath.Join("..krustlet.crt", filepath.Base(p)) {
+			t.Errorf("expected %s to link through the pair, got %q, %v", p, target, err)
+		}
+	}
+	if pairs, _ := filepath.Glob(filepath.Join(dir, "..krustlet.crt-*")); len(pairs) != 1 {
+		t.Errorf("expected replaced pairs to be removed, got %v", pairs)
+	}
+	if info, err := os.Stat(key); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the key to be private, got %v, %v", info, err)
+	}
+}
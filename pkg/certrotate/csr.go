@@ -0,0 +1,93 @@
+package certrotate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// request describes a certificate to ask the cluster for
+type request struct {
+	// name prefixes the CSR's generated name
+	name     string
+	signer   string
+	usages   []certificatesv1.KeyUsage
+	template *x509.CertificateRequest
+}
+
+// requestCertificate creates a CSR for a new key and waits until it is
+// approved and issued, denied, or the timeout passes. It returns the issued
+// certificate and the key, both PEM encoded.
+func requestCertificate(ctx context.Context, client kubernetes.Interface, r request, poll, timeout time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, r.template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := client.CertificatesV1().CertificateSigningRequests().Create(ctx, &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: r.name + "-"},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			SignerName: r.signer,
+			Usages:     r.usages,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating CSR: %w", err)
+	}
+
+	var issued []byte
+	err = wait.PollUntilContextTimeout(ctx, poll, timeout, true, func(ctx context.Context) (bool, error) {
+		current, err := client.CertificatesV1().CertificateSigningRequests().Get(ctx, csr.Name, metav1.GetOptions{})
+		if err != nil {
+			// Transient errors are retried until the timeout
+			return false, nil
+		}
+		for _, c := range current.Status.Conditions {
+			if c.Status == corev1.ConditionFalse {
+				continue
+			}
+			switch c.Type {
+			case certificatesv1.CertificateDenied:
+				return false, fmt.Errorf("CSR %s was denied: %s", csr.Name, c.Message)
+			case certificatesv1.CertificateFailed:
+				return false, fmt.Errorf("CSR %s failed: %s", csr.Name, c.Message)
+			}
+		}
+		issued = current.Status.Certificate
+		return len(issued) > 0, nil
+	})
+	if wait.Interrupted(err) {
+		return nil, nil, fmt.Errorf("CSR %s wasn't approved and issued within %s; approve it with kubectl certificate approve %s", csr.Name, timeout, csr.Name)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := parseCertificate(issued)
+	if err != nil {
+		return nil, nil, fmt.Errorf("CSR %s: decoding issued certificate: %w", csr.Name, err)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil, fmt.Errorf("CSR %s: issued certificate is for another key", csr.Name)
+	}
+	return issued, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
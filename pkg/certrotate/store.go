@@ -0,0 +1,260 @@
+package certrotate
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// store is where one of the node's certificates and its key are kept
+type store interface {
+	// load returns the current certificate
+	load() (*x509.Certificate, error)
+	// save replaces the certificate and key
+	save(certPEM, keyPEM []byte) error
+	// String names the store in logs and events
+	String() string
+}
+
+// kubeconfigStore is the client certificate of a kubeconfig's current
+// context. Krustlet embeds it in the kubeconfig it writes when it
+// bootstraps, but kubeconfigs that refer to files are handled too.
+type kubeconfigStore struct {
+	path string
+}
+
+func (s kubeconfigStore) String() string {
+	return s.path
+}
+
+// authInfo loads the kubeconfig and returns its current user
+func (s kubeconfigStore) authInfo() (*clientcmdapi.Config, *clientcmdapi.AuthInfo, error) {
+	cfg, err := clientcmd.LoadFromFile(s.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, ok := cfg.Contexts[cfg.CurrentContext]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: current context %q not found", s.path, cfg.CurrentContext)
+	}
+	user, ok := cfg.AuthInfos[ctx.AuthInfo]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: user %q not found", s.path, ctx.AuthInfo)
+	}
+	return cfg, user, nil
+}
+
+func (s kubeconfigStore) load() (*x509.Certificate, error) {
+	_, user, err := s.authInfo()
+	if err != nil {
+		return nil, err
+	}
+	data := user.ClientCertificateData
+	if len(data) == 0 {
+		if user.ClientCertificate == "" {
+			return nil, fmt.Errorf("%s: the current user has no client certificate", s.path)
+		}
+		if data, err = os.ReadFile(s.resolve(user.ClientCertificate)); err != nil {
+			return nil, err
+		}
+	}
+	return parseCertificate(data)
+}
+
+func (s kubeconfigStore) save(certPEM, keyPEM []byte) error {
+	cfg, user, err := s.authInfo()
+	if err != nil {
+		return err
+	}
+	if len(user.ClientCertificateData) == 0 && user.ClientCertificate != "" {
+		return saveFiles(s.resolve(user.ClientCertificate), s.resolve(user.ClientKey), certPEM, keyPEM)
+	}
+	user.ClientCertificateData = certPEM
+	user.ClientKeyData = keyPEM
+	user.ClientCertificate, user.ClientKey = "", ""
+	data, err := clientcmd.Write(*cfg)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0o600)
+}
+
+// resolve makes a path in the kubeconfig relative to the kubeconfig's
+// directory, as clients read it
+func (s kubeconfigStore) resolve(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(s.path), p)
+}
+
+// fileStore is a certificate and key in PEM files, as krustlet keeps its
+// serving certificate
+type fileStore struct {
+	cert, key string
+}
+
+func (s fileStore) String() string {
+	return s.cert
+}
+
+func (s fileStore) load() (*x509.Certificate, error) {
+	data, err := os.ReadFile(s.cert)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(data)
+}
+
+func (s fileStore) save(certPEM, keyPEM []byte) error {
+	return saveFiles(s.cert, s.key, certPEM, keyPEM)
+}
+
+// pairLink returns the symlink beside a certificate that points at the
+// directory holding the current certificate and key, ..<certificate>. The
+// certificate and key paths are symlinks through it, so one rename of the
+// link swaps both, as the kubelet's AtomicWriter swaps the files of a
+// secret volume.
+func pairLink(certPath string) string {
+	return filepath.Join(filepath.Dir(certPath), ".."+filepath.Base(certPath))
+}
+
+// saveFiles replaces a certificate and key file together, so a reader never
+// sees the new key with the old certificate or the other way round. The
+// first time, the files are moved into a pair directory and replaced with
+// symlinks to it.
+//
+// A certificate and key in different directories, or on Windows, where
+// making symlinks takes a privilege, are instead replaced one after the
+// other, the key first. Something that reads both in between gets the new
+// key with the old certificate, and has to read them again.
+func saveFiles(certPath, keyPath string, certPEM, keyPEM []byte) error {
+	if keyPath == "" {
+		return fmt.Errorf("%s has no key file configured", certPath)
+	}
+	dir := filepath.Dir(certPath)
+	if runtime.GOOS == "windows" || filepath.Dir(keyPath) != dir || filepath.Base(keyPath) == filepath.Base(certPath) {
+		if err := writeFileAtomic(keyPath, keyPEM, 0o600); err != nil {
+			return err
+		}
+		return writeFileAtomic(certPath, certPEM, 0o644)
+	}
+
+	link := pairLink(certPath)
+	if !linked(certPath, link) || !linked(keyPath, link) {
+		// Link the files as they are first, so they don't change while the
+		// paths become links
+		current := map[string][]byte{}
+		for _, p := range []string{certPath, keyPath} {
+			data, err := os.ReadFile(p)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			current[p] = data
+		}
+		if err := swapPair(link, certPath, keyPath, current[certPath], current[keyPath]); err != nil {
+			return err
+		}
+		for _, p := range []string{keyPath, certPath} {
+			if err := replaceWithLink(p, filepath.Join(filepath.Base(link), filepath.Base(p))); err != nil {
+				return err
+			}
+		}
+	}
+	return swapPair(link, certPath, keyPath, certPEM, keyPEM)
+}
+
+// linked reports whether the path is a symlink through the pair link
+func linked(path, link string) bool {
+	target, err := os.Readlink(path)
+	return err == nil && target == filepath.Join(filepath.Base(link), filepath.Base(path))
+}
+
+// swapPair writes the certificate and key into a new pair directory, points
+// the pair link at it and removes the pair directories it replaced
+func swapPair(link, certPath, keyPath string, certPEM, keyPEM []byte) error {
+	pair, err := os.MkdirTemp(filepath.Dir(link), filepath.Base(link)+"-")
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(pair, 0o755)
+	if err == nil && certPEM != nil {
+		err = writeFileAtomic(filepath.Join(pair, filepath.Base(certPath)), certPEM, 0o644)
+	}
+	if err == nil && keyPEM != nil {
+		err = writeFileAtomic(filepath.Join(pair, filepath.Base(keyPath)), keyPEM, 0o600)
+	}
+	if err == nil {
+		err = replaceWithLink(link, filepath.Base(pair))
+	}
+	if err != nil {
+		_ = os.RemoveAll(pair)
+		return err
+	}
+	// Also remove pairs left by a rotation that stopped halfway
+	old, _ := filepath.Glob(link + "-*")
+	for _, p := range old {
+		if p != pair {
+			_ = os.RemoveAll(p)
+		}
+	}
+	return nil
+}
+
+// replaceWithLink atomically replaces the path with a symlink to target
+func replaceWithLink(path, target string) error {
+	tmp := path + ".tmp-link"
+	_ = os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("linking %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file by renaming a complete copy over it, so
+// a reader sees either the old content or the new, never a partial file
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseCertificate decodes the first certificate in PEM data, which is the
+// leaf in the chains the API server issues
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
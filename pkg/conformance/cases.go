@@ -0,0 +1,425 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Case is a single conformance check
+type Case struct {
+	// Name identifies the case, and is what --focus and --skip match against
+	Name string
+	// Description says what the case checks
+	Description string
+	// Run performs the check, returning an error if the node doesn't conform
+	Run func(ctx context.Context, f *Framework) error
+}
+
+// SkipError is returned by a case that doesn't apply to the configuration
+type SkipError struct {
+	Reason string
+}
+
+func (e *SkipError) Error() string {
+	return "skipped: " + e.Reason
+}
+
+// Skip returns an error marking the case as skipped
+func Skip(reason string) error {
+	return &SkipError{Reason: reason}
+}
+
+// Cases returns every case in the suite, in the order they should run. The
+// cases mirror the Rust integration tests under tests/.
+func Cases() []Case {
+	return []Case{
+		{
+			Name:        "node/registration",
+			Description: "the node has the architecture label, taints and node info krustlet registers with",
+			Run:         checkNode,
+		},
+		{
+			Name:        "pod/lifecycle",
+			Description: "a pod goes from Pending to Running to Succeeded and its logs can be read",
+			Run:         checkLifecycle,
+		},
+		{
+			Name:        "pod/container-logs",
+			Description: "each container's logs are kept separately",
+			Run:         checkContainerLogs,
+		},
+		{
+			Name:        "pod/env",
+			Description: "literal, config map, secret and Downward API environment variables reach the module",
+			Run:         checkEnv,
+		},
+		{
+			Name:        "volumes/configmap-secret",
+			Description: "every key of a config map and secret volume is mounted",
+			Run:         checkConfigMapSecretVolumes,
+		},
+		{
+			Name:        "volumes/items",
+			Description: "only the selected items of a config map and secret volume are mounted, at their paths",
+			Run:         checkVolumeItems,
+		},
+		{
+			Name:        "pod/init-containers",
+			Description: "init containers run in order before the main container and share its volumes",
+			Run:         checkInitContainers,
+		},
+		{
+			Name:        "pod/exit-failure",
+			Description: "a module that fails leaves the pod Failed with a non-zero exit code",
+			Run:         checkExitFailure,
+		},
+		{
+			Name:        "pod/init-failure",
+			Description: "a failing init container fails the pod without running the main container",
+			Run:         checkInitFailure,
+		},
+		{
+			Name:        "pod/deletion",
+			Description: "a deleted pod is cleaned up by the node and removed from the API",
+			Run:         checkDeletion,
+		},
+	}
+}
+
+func checkNode(ctx context.Context, f *Framework) error {
+	if f.NodeName == "" {
+		return Skip("no node name was given")
+	}
+	node, err := f.Client.CoreV1().Nodes().Get(ctx, f.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("fetching node: %w", err)
+	}
+	if arch := node.Status.NodeInfo.Architecture; arch != f.NodeArchitecture {
+		return fmt.Errorf("expected node info architecture %q, got %q", f.NodeArchitecture, arch)
+	}
+	if label := node.Labels[archLabel]; label != f.Arch {
+		return fmt.Errorf("expected %s label %q, got %q", archLabel, f.Arch, label)
+	}
+	for _, effect := range []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectNoExecute} {
+		want := corev1.Taint{Key: archLabel, Value: f.Arch, Effect: effect}
+		found := false
+		for _, t := range node.Spec.Taints {
+			found = found || t.MatchTaint(&want) && t.Value == want.Value
+		}
+		if !found {
+			return fmt.Errorf("expected a %s=%s:%s taint, got %v", archLabel, f.Arch, effect, node.Spec.Taints)
+		}
+	}
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady && c.Status != corev1.ConditionTrue {
+			return fmt.Errorf("node is not ready: %s", c.Message)
+		}
+	}
+	return nil
+}
+
+func checkLifecycle(ctx context.Context, f *Framework) error {
+	pod := f.WasmPod("hello", corev1.Container{Name: "hello", Image: f.HelloImage})
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	done, err := f.WaitForCompletion(ctx, pod.Name)
+	if err != nil {
+		return err
+	}
+	if err := ExpectTerminated(done, "hello", 0); err != nil {
+		return err
+	}
+	logs, err := f.Logs(ctx, pod.Name, "hello")
+	if err != nil {
+		return err
+	}
+	if logs != "Hello, world!\n" {
+		return fmt.Errorf("expected logs %q, got %q", "Hello, world!\n", logs)
+	}
+	return nil
+}
+
+func checkContainerLogs(ctx context.Context, f *Framework) error {
+	pod := f.WasmPod("loggy",
+		f.Wasmerciser("floofycat", "write(lit:slats)to(stm:stdout)"),
+		f.Wasmerciser("neatcat", "write(lit:kiki)to(stm:stdout)"),
+	)
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	if _, err := f.WaitForCompletion(ctx, pod.Name); err != nil {
+		return err
+	}
+	if err := f.ExpectLogContains(ctx, pod.Name, "floofycat", "slats"); err != nil {
+		return err
+	}
+	if err := f.ExpectLogContains(ctx, pod.Name, "neatcat", "kiki"); err != nil {
+		return err
+	}
+	logs, err := f.Logs(ctx, pod.Name, "floofycat")
+	if err != nil {
+		return err
+	}
+	if strings.Contains(logs, "kiki") {
+		return fmt.Errorf("expected logs of floofycat not to include neatcat's output, got %q", logs)
+	}
+	return nil
+}
+
+func checkEnv(ctx context.Context, f *Framework) error {
+	if err := f.CreateConfigMap(ctx, "env-config", map[string]string{"color": "teal"}); err != nil {
+		return err
+	}
+	if err := f.CreateSecret(ctx, "env-secret", map[string]string{"token": "hunter2"}); err != nil {
+		return err
+	}
+	c := f.Wasmerciser("env",
+		"read(env:LITERAL)to(var:literal)",
+		"assert_value(var:literal)is(lit:plain)",
+		"read(env:FROM_CONFIGMAP)to(var:color)",
+		"assert_value(var:color)is(lit:teal)",
+		"read(env:FROM_SECRET)to(var:token)",
+		"assert_value(var:token)is(lit:hunter2)",
+		"read(env:POD_NAME)to(var:name)",
+		"assert_value(var:name)is(lit:env)",
+		"read(env:POD_NAMESPACE)to(var:namespace)",
+		"assert_value(var:namespace)is(lit:"+f.Namespace+")",
+		"write(lit:envok)to(stm:stdout)",
+	)
+	c.Env = []corev1.EnvVar{
+		{Name: "LITERAL", Value: "plain"},
+		{Name: "FROM_CONFIGMAP", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "env-config"}, Key: "color",
+		}}},
+		{Name: "FROM_SECRET", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "env-secret"}, Key: "token",
+		}}},
+		{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		{Name: "POD_NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+	}
+	return runToCompletion(ctx, f, f.WasmPod("env", c), "env", "envok")
+}
+
+// createMultiValueSources creates the config map and secret the volume cases
+// mount
+func createMultiValueSources(ctx context.Context, f *Framework) error {
+	if err := f.CreateConfigMap(ctx, "multi-configmap", map[string]string{
+		"mcm1": "value1",
+		"mcm2": "value two",
+		"mcm5": "VALUE NUMBER FIVE",
+	}); err != nil {
+		return err
+	}
+	return f.CreateSecret(ctx, "multi-secret", map[string]string{
+		"ms1": "tell nobody",
+		"ms2": "but the password is",
+		"ms3": "wait was that a foot-- aargh!!!",
+	})
+}
+
+func mountVolumes(pod *corev1.Pod, volumes map[string]corev1.VolumeSource) {
+	for mountPath, source := range volumes {
+		name := strings.Trim(strings.ReplaceAll(mountPath, "/", "-"), "-")
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: name, VolumeSource: source})
+		for i := range pod.Spec.InitContainers {
+			c := &pod.Spec.InitContainers[i]
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: name, MountPath: mountPath})
+		}
+		for i := range pod.Spec.Containers {
+			c := &pod.Spec.Containers[i]
+			c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{Name: name, MountPath: mountPath})
+		}
+	}
+}
+
+func checkConfigMapSecretVolumes(ctx context.Context, f *Framework) error {
+	if err := createMultiValueSources(ctx, f); err != nil {
+		return err
+	}
+	pod := f.WasmPod("multi-mount", f.Wasmerciser("multimount",
+		"assert_exists(file:/mcm/mcm1)",
+		"assert_exists(file:/mcm/mcm2)",
+		"assert_exists(file:/mcm/mcm5)",
+		"assert_exists(file:/ms/ms1)",
+		"assert_exists(file:/ms/ms2)",
+		"assert_exists(file:/ms/ms3)",
+		"read(file:/mcm/mcm1)to(var:mcm1)",
+		"read(file:/mcm/mcm5)to(var:mcm5)",
+		"read(file:/ms/ms1)to(var:ms1)",
+		"read(file:/ms/ms3)to(var:ms3)",
+		"write(var:mcm1)to(stm:stdout)",
+		"write(var:mcm5)to(stm:stdout)",
+		"write(var:ms1)to(stm:stdout)",
+		"write(var:ms3)to(stm:stdout)",
+	))
+	mountVolumes(pod, map[string]corev1.VolumeSource{
+		"/mcm": {ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "multi-configmap"}}},
+		"/ms":  {Secret: &corev1.SecretVolumeSource{SecretName: "multi-secret"}},
+	})
+	return runToCompletion(ctx, f, pod, "multimount", "value1", "VALUE NUMBER FIVE", "tell nobody", "was that a foot-- aargh!!!")
+}
+
+func checkVolumeItems(ctx context.Context, f *Framework) error {
+	if err := createMultiValueSources(ctx, f); err != nil {
+		return err
+	}
+	pod := f.WasmPod("multi-mount-items", f.Wasmerciser("multimount",
+		"assert_exists(file:/mcm/mcm1)",
+		"assert_not_exists(file:/mcm/mcm2)",
+		"assert_exists(file:/mcm/mcm-five)",
+		"assert_exists(file:/ms/ms1)",
+		"assert_not_exists(file:/ms/ms2)",
+		"assert_exists(file:/ms/ms-three)",
+		"read(file:/mcm/mcm1)to(var:mcm1)",
+		"read(file:/mcm/mcm-five)to(var:mcm5)",
+		"read(file:/ms/ms1)to(var:ms1)",
+		"read(file:/ms/ms-three)to(var:ms3)",
+		"write(var:mcm1)to(stm:stdout)",
+		"write(var:mcm5)to(stm:stdout)",
+		"write(var:ms1)to(stm:stdout)",
+		"write(var:ms3)to(stm:stdout)",
+	))
+	mountVolumes(pod, map[string]corev1.VolumeSource{
+		"/mcm": {ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "multi-configmap"},
+			Items:                []corev1.KeyToPath{{Key: "mcm1", Path: "mcm1"}, {Key: "mcm5", Path: "mcm-five"}},
+		}},
+		"/ms": {Secret: &corev1.SecretVolumeSource{
+			SecretName: "multi-secret",
+			Items:      []corev1.KeyToPath{{Key: "ms1", Path: "ms1"}, {Key: "ms3", Path: "ms-three"}},
+		}},
+	})
+	return runToCompletion(ctx, f, pod, "multimount", "value1", "VALUE NUMBER FIVE", "tell nobody", "was that a foot-- aargh!!!")
+}
+
+func checkInitContainers(ctx context.Context, f *Framework) error {
+	// The host path is shared by every run, so name the files after the
+	// namespace to keep runs apart
+	first := f.Namespace + "-floofycat.txt"
+	second := f.Namespace + "-neatcat.txt"
+	pod := f.WasmPod("inits", f.Wasmerciser("inits",
+		"assert_exists(file:/hp/"+first+")",
+		"assert_exists(file:/hp/"+second+")",
+		"read(file:/hp/"+second+")to(var:ncat)",
+		"assert_value(var:ncat)is(lit:slatskiki)",
+		"write(var:ncat)to(stm:stdout)",
+	))
+	pod.Spec.InitContainers = []corev1.Container{
+		f.Wasmerciser("init-1", "write(lit:slats)to(file:/hp/"+first+")"),
+		// The second init container can only write its file if the first has
+		// already run
+		f.Wasmerciser("init-2",
+			"read(file:/hp/"+first+")to(var:fcat)",
+			"assert_value(var:fcat)is(lit:slats)",
+			"write(lit:slatskiki)to(file:/hp/"+second+")",
+		),
+	}
+	mountVolumes(pod, map[string]corev1.VolumeSource{
+		"/hp": {HostPath: &corev1.HostPathVolumeSource{Path: path.Clean(f.HostPath)}},
+	})
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	done, err := f.WaitForCompletion(ctx, pod.Name)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"init-1", "init-2", "inits"} {
+		if err := ExpectTerminated(done, name, 0); err != nil {
+			return err
+		}
+	}
+	for _, s := range done.Status.ContainerStatuses {
+		if strings.HasPrefix(s.Name, "init-") {
+			return fmt.Errorf("init container %s is reported as an app container", s.Name)
+		}
+	}
+	return f.ExpectLogContains(ctx, pod.Name, "inits", "slatskiki")
+}
+
+func checkExitFailure(ctx context.Context, f *Framework) error {
+	pod := f.WasmPod("faily", f.Wasmerciser("faily", "assert_exists(file:/nope.nope.nope.txt)"))
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	failed, err := f.WaitForFailure(ctx, pod.Name)
+	if err != nil {
+		return err
+	}
+	status, ok := ContainerStatus(failed, "faily")
+	if !ok || status.State.Terminated == nil {
+		return fmt.Errorf("expected container faily to be terminated, got %+v", failed.Status.ContainerStatuses)
+	}
+	if status.State.Terminated.ExitCode == 0 {
+		return fmt.Errorf("expected container faily to exit with a non-zero code")
+	}
+	return f.ExpectLogContains(ctx, pod.Name, "faily", "ERR: Failed with File /nope.nope.nope.txt was expected to exist but did not")
+}
+
+func checkInitFailure(ctx context.Context, f *Framework) error {
+	pod := f.WasmPod("faily-inits", f.Wasmerciser("main", "write(lit:ran)to(stm:stdout)"))
+	pod.Spec.InitContainers = []corev1.Container{
+		f.Wasmerciser("init-that-fails", "assert_exists(file:/nope.nope.nope.txt)"),
+		f.Wasmerciser("init-that-would-succeed-if-it-ran", "write(lit:slats)to(stm:stdout)"),
+	}
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	failed, err := f.WaitForFailure(ctx, pod.Name)
+	if err != nil {
+		return err
+	}
+	if reason := failed.Status.Reason + " " + failed.Status.Message; !strings.Contains(reason, "init-that-fails") {
+		return fmt.Errorf("expected the pod status to name the failed init container, got %q", strings.TrimSpace(reason))
+	}
+	for _, name := range []string{"init-that-would-succeed-if-it-ran", "main"} {
+		if status, ok := ContainerStatus(failed, name); ok && (status.State.Running != nil || status.State.Terminated != nil) {
+			return fmt.Errorf("expected container %s not to run after an init container failed", name)
+		}
+	}
+	return f.ExpectLogContains(ctx, pod.Name, "init-that-fails", "ERR: Failed with File /nope.nope.nope.txt was expected to exist but did not")
+}
+
+func checkDeletion(ctx context.Context, f *Framework) error {
+	pod := f.WasmPod("deleted", f.Wasmerciser("deleted", "write(lit:bye)to(stm:stdout)"))
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	if _, err := f.WaitForCompletion(ctx, pod.Name); err != nil {
+		return err
+	}
+	if err := f.DeletePod(ctx, pod.Name); err != nil {
+		return err
+	}
+	// A pod with the same name must be runnable again once the old one is
+	// gone, which catches state the node failed to clean up
+	if err := f.CreatePod(ctx, f.WasmPod("deleted", f.Wasmerciser("deleted", "write(lit:again)to(stm:stdout)"))); err != nil {
+		return err
+	}
+	if _, err := f.WaitForCompletion(ctx, pod.Name); err != nil {
+		return fmt.Errorf("recreated pod: %w", err)
+	}
+	return f.ExpectLogContains(ctx, pod.Name, "deleted", "again")
+}
+
+// runToCompletion creates the pod, waits for it to succeed and checks the
+// container's logs
+func runToCompletion(ctx context.Context, f *Framework, pod *corev1.Pod, container string, logs ...string) error {
+	if err := f.CreatePod(ctx, pod); err != nil {
+		return err
+	}
+	done, err := f.WaitForCompletion(ctx, pod.Name)
+	if err != nil {
+		return err
+	}
+	if err := ExpectTerminated(done, container, 0); err != nil {
+		return err
+	}
+	return f.ExpectLogContains(ctx, pod.Name, container, logs...)
+}
@@ -0,0 +1,52 @@
+//go:build e2e
+
+package conformance
+
+import (
+	"context"
+	"flag"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/kubeclient"
+)
+
+// Run against a cluster with:
+//
+//	go test -tags e2e ./pkg/conformance -node-name krustlet-wasi
+var (
+	kubeconfig       = flag.String("kubeconfig", "", "kubeconfig of the cluster the node is registered with")
+	nodeName         = flag.String("node-name", "krustlet-wasi", "krustlet node to test")
+	wasmerciserImage = flag.String("wasmerciser-image", DefaultWasmerciserImage, "wasmerciser module the cases run")
+	helloImage       = flag.String("hello-image", DefaultHelloImage, "hello world module the cases run")
+	hostPath         = flag.String("host-path", "/tmp", "existing directory on the node for hostPath volumes")
+	keepNamespaces   = flag.Bool("keep-namespaces", false, "leave each case's namespace behind")
+)
+
+func TestConformance(t *testing.T) {
+	client, err := kubeclient.NewClientset(*kubeconfig, "", "krustlet-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		Client:           client,
+		NodeName:         *nodeName,
+		WasmerciserImage: *wasmerciserImage,
+		HelloImage:       *helloImage,
+		HostPath:         *hostPath,
+		KeepNamespaces:   *keepNamespaces,
+	}
+	// Case names contain a slash, so -run TestConformance/pod/ selects the
+	// pod cases
+	for _, c := range Cases() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			r := RunCase(context.Background(), cfg, c)
+			switch {
+			case r.Skipped():
+				t.Skip(r.Err)
+			case !r.Passed():
+				t.Fatal(r.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+// Package conformance is a suite of end-to-end checks that a krustlet node
+// behaves the way Kubernetes expects a node to. It deploys a set of wasm
+// workloads to the node and checks pod phase transitions, logs, environment
+// variables, volume mounts and deletion.
+//
+// The suite can be run with the krustlet-conformance binary or, with the e2e
+// build tag, with go test.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const (
+	// DefaultArch is the architecture krustlet's wasi provider registers with
+	DefaultArch = "wasm32-wasi"
+	// DefaultNodeArchitecture is the architecture reported in the node info of
+	// a wasi provider node
+	DefaultNodeArchitecture = "wasm-wasi"
+	// DefaultWasmerciserImage is the module most cases run. It follows a small
+	// script passed as arguments, see demos/wasi/wasmerciser.
+	DefaultWasmerciserImage = "webassembly.azurecr.io/wasmerciser:v0.3.0"
+	// DefaultHelloImage prints "Hello, world!" and exits
+	DefaultHelloImage = "webassembly.azurecr.io/hello-wasm:v1"
+
+	archLabel = "kubernetes.io/arch"
+	// caseLabel marks the namespaces the suite creates
+	caseLabel = "conformance.krustlet.dev/case"
+)
+
+// Config configures a conformance run
+type Config struct {
+	// Client talks to the cluster the node is registered with
+	Client kubernetes.Interface
+	// NodeName is the krustlet node to test. If it is empty, pods may be
+	// scheduled to any node with the architecture and the node check is
+	// skipped
+	NodeName string
+	// Arch is the architecture label of the node
+	Arch string
+	// NodeArchitecture is the architecture the node reports in its node info
+	NodeArchitecture string
+	// WasmerciserImage and HelloImage are the modules the cases run. Point
+	// them at a mirror to test without access to webassembly.azurecr.io
+	WasmerciserImage string
+	HelloImage       string
+	// HostPath is an existing directory on the node that cases may create
+	// files in through a hostPath volume
+	HostPath string
+	// PodTimeout bounds how long a pod may take to reach the phase a case
+	// waits for
+	PodTimeout time.Duration
+	// NamespacePrefix is prepended to the name of each case's namespace
+	NamespacePrefix string
+	// KeepNamespaces leaves each case's namespace behind for debugging
+	KeepNamespaces bool
+}
+
+func (c Config) withDefaults() Config {
+	if c.Arch == "" {
+		c.Arch = DefaultArch
+	}
+	if c.NodeArchitecture == "" {
+		c.NodeArchitecture = DefaultNodeArchitecture
+	}
+	if c.WasmerciserImage == "" {
+		c.WasmerciserImage = DefaultWasmerciserImage
+	}
+	if c.HelloImage == "" {
+		c.HelloImage = DefaultHelloImage
+	}
+	if c.HostPath == "" {
+		c.HostPath = "/tmp"
+	}
+	if c.PodTimeout == 0 {
+		c.PodTimeout = 2 * time.Minute
+	}
+	if c.NamespacePrefix == "" {
+		c.NamespacePrefix = "krustlet-conformance"
+	}
+	return c
+}
+
+// Framework is what a case uses to talk to the cluster. Every case runs in a
+// namespace of its own, which is deleted when the case finishes.
+type Framework struct {
+	Config
+	// Namespace is the case's namespace
+	Namespace string
+}
+
+// WasmPod returns a pod that runs the given containers on the node under
+// test, which never restarts them
+func (f *Framework) WasmPod(name string, containers ...corev1.Container) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: f.Namespace},
+		Spec: corev1.PodSpec{
+			Containers:    containers,
+			RestartPolicy: corev1.RestartPolicyNever,
+			NodeSelector:  map[string]string{archLabel: f.Arch},
+			Tolerations: []corev1.Toleration{
+				{Key: archLabel, Operator: corev1.TolerationOpEqual, Value: f.Arch, Effect: corev1.TaintEffectNoExecute},
+				{Key: archLabel, Operator: corev1.TolerationOpEqual, Value: f.Arch, Effect: corev1.TaintEffectNoSchedule},
+			},
+		},
+	}
+	if f.NodeName != "" {
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchFields: []corev1.NodeSelectorRequirement{{
+						Key:      "metadata.name",
+						Operator: corev1.NodeSelectorOpIn,
+						Values:   []string{f.NodeName},
+					}},
+				}},
+			},
+		}}
+	}
+	return pod
+}
+
+// Wasmerciser returns a container running the wasmerciser module with the
+// given script
+func (f *Framework) Wasmerciser(name string, script ...string) corev1.Container {
+	return corev1.Container{Name: name, Image: f.WasmerciserImage, Args: script}
+}
+
+// CreatePod creates the pod and checks that it starts out Pending
+func (f *Framework) CreatePod(ctx context.Context, pod *corev1.Pod) error {
+	created, err := f.Client.CoreV1().Pods(f.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating pod %s: %w", pod.Name, err)
+	}
+	if created.Status.Phase != "" && created.Status.Phase != corev1.PodPending {
+		return fmt.Errorf("expected pod %s to be created Pending, got %s", pod.Name, created.Status.Phase)
+	}
+	return nil
+}
+
+// CreateConfigMap creates a config map in the case's namespace
+func (f *Framework) CreateConfigMap(ctx context.Context, name string, data map[string]string) error {
+	_, err := f.Client.CoreV1().ConfigMaps(f.Namespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Data:       data,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating config map %s: %w", name, err)
+	}
+	return nil
+}
+
+// CreateSecret creates an opaque secret in the case's namespace
+func (f *Framework) CreateSecret(ctx context.Context, name string, data map[string]string) error {
+	_, err := f.Client.CoreV1().Secrets(f.Namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		StringData: data,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("creating secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// Phases is the sequence of distinct phases a pod was seen in
+type Phases []corev1.PodPhase
+
+// Saw reports whether the pod was seen in the phase
+func (p Phases) Saw(phase corev1.PodPhase) bool {
+	for _, seen := range p {
+		if seen == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Phases) String() string {
+	names := make([]string, 0, len(p))
+	for _, phase := range p {
+		names = append(names, string(phase))
+	}
+	return strings.Join(names, " -> ")
+}
+
+// WaitForPhase watches the pod until it reaches one of the given phases and
+// returns the pod along with every phase it passed through. A pod that
+// reaches Succeeded or Failed without that being one of the phases is an
+// error, as it will never change again.
+func (f *Framework) WaitForPhase(ctx context.Context, name string, phases ...corev1.PodPhase) (*corev1.Pod, Phases, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.PodTimeout)
+	defer cancel()
+
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	pods := f.Client.CoreV1().Pods(f.Namespace)
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = selector
+			return pods.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = selector
+			return pods.Watch(ctx, opts)
+		},
+	}
+
+	var seen Phases
+	var last *corev1.Pod
+	_, err := watchtools.UntilWithSync(ctx, lw, &corev1.Pod{}, nil, func(ev watch.Event) (bool, error) {
+		pod, ok := ev.Object.(*corev1.Pod)
+		if !ok || pod.Name != name {
+			return false, nil
+		}
+		if ev.Type == watch.Deleted {
+			return false, fmt.Errorf("pod %s was deleted", name)
+		}
+		last = pod
+		phase := pod.Status.Phase
+		if phase != "" && (len(seen) == 0 || seen[len(seen)-1] != phase) {
+			seen = append(seen, phase)
+		}
+		for _, want := range phases {
+			if phase == want {
+				return true, nil
+			}
+		}
+		if phase == corev1.PodSucceeded || phase == corev1.PodFailed {
+			return false, fmt.Errorf("pod %s finished %s (%s)", name, phase, describeStatus(pod))
+		}
+		return false, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			err = fmt.Errorf("timed out after %s waiting for pod %s to be %s, saw %s", f.PodTimeout, name, phaseList(phases), seen)
+		}
+		return last, seen, err
+	}
+	return last, seen, nil
+}
+
+// WaitForCompletion waits for the pod to succeed, checking that it was seen
+// Running first the way a kubelet reports a pod
+func (f *Framework) WaitForCompletion(ctx context.Context, name string) (*corev1.Pod, error) {
+	pod, seen, err := f.WaitForPhase(ctx, name, corev1.PodSucceeded)
+	if err != nil {
+		return pod, err
+	}
+	if !seen.Saw(corev1.PodRunning) {
+		return pod, fmt.Errorf("pod %s reached Succeeded without being Running, saw %s", name, seen)
+	}
+	return pod, nil
+}
+
+// WaitForFailure waits for the pod to fail
+func (f *Framework) WaitForFailure(ctx context.Context, name string) (*corev1.Pod, error) {
+	pod, _, err := f.WaitForPhase(ctx, name, corev1.PodFailed)
+	return pod, err
+}
+
+// DeletePod deletes the pod and waits for the node to finish with it and the
+// API object to be removed
+func (f *Framework) DeletePod(ctx context.Context, name string) error {
+	pods := f.Client.CoreV1().Pods(f.Namespace)
+	if err := pods.Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("deleting pod %s: %w", name, err)
+	}
+	err := wait.PollUntilContextTimeout(ctx, time.Second, f.PodTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if wait.Interrupted(err) {
+		return fmt.Errorf("pod %s was still present %s after being deleted", name, f.PodTimeout)
+	}
+	return err
+}
+
+// Logs returns the logs of a container of the pod
+func (f *Framework) Logs(ctx context.Context, pod, container string) (string, error) {
+	stream, err := f.Client.CoreV1().Pods(f.Namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container}).Stream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fetching logs of %s/%s: %w", pod, container, err)
+	}
+	defer stream.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, stream); err != nil {
+		return "", fmt.Errorf("reading logs of %s/%s: %w", pod, container, err)
+	}
+	return buf.String(), nil
+}
+
+// ExpectLogContains checks that a container's logs contain each of the
+// strings
+func (f *Framework) ExpectLogContains(ctx context.Context, pod, container string, want ...string) error {
+	logs, err := f.Logs(ctx, pod, container)
+	if err != nil {
+		return err
+	}
+	for _, w := range want {
+		if !strings.Contains(logs, w) {
+			return fmt.Errorf("expected logs of %s/%s to contain %q, got %q", pod, container, w, logs)
+		}
+	}
+	return nil
+}
+
+// ContainerStatus returns the status of the named container or init
+// container
+func ContainerStatus(pod *corev1.Pod, name string) (*corev1.ContainerStatus, bool) {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for i := range statuses {
+			if statuses[i].Name == name {
+				return &statuses[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ExpectTerminated checks that the container ran to completion with the
+// given exit code
+func ExpectTerminated(pod *corev1.Pod, container string, exitCode int32) error {
+	status, ok := ContainerStatus(pod, container)
+	if !ok {
+		return fmt.Errorf("pod %s has no status for container %s", pod.Name, container)
+	}
+	terminated := status.State.Terminated
+	if terminated == nil {
+		return fmt.Errorf("expected container %s to be terminated, got %+v", container, status.State)
+	}
+	if terminated.ExitCode != exitCode {
+		return fmt.Errorf("expected container %s to exit with %d, got %d (%s)", container, exitCode, terminated.ExitCode, terminated.Message)
+	}
+	return nil
+}
+
+func describeStatus(pod *corev1.Pod) string {
+	parts := []string{}
+	if pod.Status.Reason != "" || pod.Status.Message != "" {
+		parts = append(parts, strings.TrimSpace(pod.Status.Reason+" "+pod.Status.Message))
+	}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, s := range statuses {
+			if t := s.State.Terminated; t != nil {
+				parts = append(parts, fmt.Sprintf("%s exited %d: %s", s.Name, t.ExitCode, t.Message))
+			}
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func phaseList(phases []corev1.PodPhase) string {
+	names := make([]string, 0, len(phases))
+	for _, phase := range phases {
+		names = append(names, string(phase))
+	}
+	return strings.Join(names, " or ")
+}
@@ -0,0 +1,180 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testFramework(client *fake.Clientset) *Framework {
+	cfg := Config{Client: client, NodeName: "krustlet-wasi", PodTimeout: 5 * time.Second}.withDefaults()
+	return &Framework{Config: cfg, Namespace: "test"}
+}
+
+// setPhases plays the pod through the phases the way a node would
+func setPhases(t *testing.T, client *fake.Clientset, name string, phases ...corev1.PodPhase) {
+	t.Helper()
+	go func() {
+		pods := client.CoreV1().Pods("test")
+		for _, phase := range phases {
+			time.Sleep(20 * time.Millisecond)
+			pod, err := pods.Get(context.Background(), name, metav1.GetOptions{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			pod.Status.Phase = phase
+			if _, err := pods.UpdateStatus(context.Background(), pod, metav1.UpdateOptions{}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+}
+
+func TestWasmPod(t *testing.T) {
+	f := testFramework(fake.NewSimpleClientset())
+	pod := f.WasmPod("hello", f.Wasmerciser("hello", "write(lit:hi)to(stm:stdout)"))
+
+	if pod.Spec.NodeSelector[archLabel] != DefaultArch {
+		t.Errorf("expected the arch node selector, got %v", pod.Spec.NodeSelector)
+	}
+	if len(pod.Spec.Tolerations) != 2 {
+		t.Errorf("expected tolerations for both taints, got %v", pod.Spec.Tolerations)
+	}
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) != 1 || terms[0].MatchFields[0].Values[0] != "krustlet-wasi" {
+		t.Errorf("expected the pod to be pinned to the node, got %+v", terms)
+	}
+	if pod.Spec.Containers[0].Image != DefaultWasmerciserImage {
+		t.Errorf("unexpected image %s", pod.Spec.Containers[0].Image)
+	}
+}
+
+func TestWaitForCompletion(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	f := testFramework(client)
+	if err := f.CreatePod(context.Background(), f.WasmPod("hello")); err != nil {
+		t.Fatal(err)
+	}
+	setPhases(t, client, "hello", corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded)
+
+	if _, err := f.WaitForCompletion(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForCompletionRequiresRunning(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	f := testFramework(client)
+	if err := f.CreatePod(context.Background(), f.WasmPod("hello")); err != nil {
+		t.Fatal(err)
+	}
+	setPhases(t, client, "hello", corev1.PodPending, corev1.PodSucceeded)
+
+	_, err := f.WaitForCompletion(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "without being Running") {
+		t.Fatalf("expected skipping Running to be an error, got %v", err)
+	}
+}
+
+func TestWaitForPhaseFailsFast(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	f := testFramework(client)
+	if err := f.CreatePod(context.Background(), f.WasmPod("hello")); err != nil {
+		t.Fatal(err)
+	}
+	setPhases(t, client, "hello", corev1.PodRunning, corev1.PodFailed)
+
+	start := time.Now()
+	_, seen, err := f.WaitForPhase(context.Background(), "hello", corev1.PodSucceeded)
+	if err == nil || !strings.Contains(err.Error(), "finished Failed") {
+		t.Fatalf("expected a failed pod to end the wait, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("expected the wait to end as soon as the pod failed")
+	}
+	if seen.String() != "Running -> Failed" {
+		t.Errorf("unexpected phases %s", seen)
+	}
+}
+
+func TestRunCaseCleansUp(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	var namespace string
+	result := RunCase(context.Background(), Config{Client: client}, Case{
+		Name: "pod/example",
+		Run: func(ctx context.Context, f *Framework) error {
+			namespace = f.Namespace
+			if _, err := client.CoreV1().Namespaces().Get(ctx, f.Namespace, metav1.GetOptions{}); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		},
+	})
+	if result.Passed() || result.Skipped() || result.Err.Error() != "boom" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if !strings.HasPrefix(namespace, "krustlet-conformance-pod-example-") {
+		t.Errorf("unexpected namespace %q", namespace)
+	}
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{}); err == nil {
+		t.Error("expected the namespace to be deleted")
+	}
+}
+
+func TestSelectAndReport(t *testing.T) {
+	cases := Select(Cases(), regexp.MustCompile(`^(pod|node)/`), regexp.MustCompile(`deletion`))
+	for _, c := range cases {
+		if strings.HasPrefix(c.Name, "volumes/") || c.Name == "pod/deletion" {
+			t.Errorf("unexpected case %s", c.Name)
+		}
+	}
+	if len(cases) == 0 {
+		t.Fatal("expected some cases to be selected")
+	}
+
+	var out bytes.Buffer
+	ok := Report(&out, []Result{
+		{Case: "a"},
+		{Case: "b", Err: Skip("not configured")},
+		{Case: "c", Err: errors.New("boom")},
+	})
+	if ok {
+		t.Error("expected a failure to be reported")
+	}
+	if !strings.Contains(out.String(), "1 passed, 1 failed, 1 skipped") {
+		t.Errorf("unexpected report %q", out.String())
+	}
+}
+
+func TestCheckNode(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "krustlet-wasi", Labels: map[string]string{archLabel: DefaultArch}},
+		Spec: corev1.NodeSpec{Taints: []corev1.Taint{
+			{Key: archLabel, Value: DefaultArch, Effect: corev1.TaintEffectNoExecute},
+			{Key: archLabel, Value: DefaultArch, Effect: corev1.TaintEffectNoSchedule},
+		}},
+		Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{Architecture: DefaultNodeArchitecture}},
+	}
+	client := fake.NewSimpleClientset(node)
+	if err := checkNode(context.Background(), testFramework(client)); err != nil {
+		t.Fatal(err)
+	}
+
+	node.Spec.Taints = node.Spec.Taints[:1]
+	if _, err := client.CoreV1().Nodes().Update(context.Background(), node, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkNode(context.Background(), testFramework(client)); err == nil || !strings.Contains(err.Error(), "NoSchedule") {
+		t.Errorf("expected the missing taint to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+)
+
+// Result is the outcome of running a case
+type Result struct {
+	Case     string
+	Duration time.Duration
+	// Err is nil if the case passed
+	Err error
+}
+
+// Skipped reports whether the case didn't apply to the configuration
+func (r Result) Skipped() bool {
+	var skip *SkipError
+	return errors.As(r.Err, &skip)
+}
+
+// Passed reports whether the case passed
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Select returns the cases whose names match focus and don't match skip.
+// Either may be nil.
+func Select(cases []Case, focus, skip *regexp.Regexp) []Case {
+	var selected []Case
+	for _, c := range cases {
+		if focus != nil && !focus.MatchString(c.Name) {
+			continue
+		}
+		if skip != nil && skip.MatchString(c.Name) {
+			continue
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+// RunCase runs a case in a namespace of its own and cleans up afterwards
+func RunCase(ctx context.Context, cfg Config, c Case) Result {
+	cfg = cfg.withDefaults()
+	start := time.Now()
+	result := Result{Case: c.Name}
+
+	namespace := namespaceFor(cfg.NamespacePrefix, c.Name)
+	_, err := cfg.Client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   namespace,
+			Labels: map[string]string{caseLabel: labelValue(c.Name)},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		result.Err = fmt.Errorf("creating namespace: %w", err)
+		result.Duration = time.Since(start)
+		return result
+	}
+	defer func() {
+		if cfg.KeepNamespaces {
+			klog.InfoS("Keeping namespace", "case", c.Name, "namespace", namespace)
+			return
+		}
+		// Cleanup runs even if the run was cancelled
+		if err := cfg.Client.CoreV1().Namespaces().Delete(context.Background(), namespace, metav1.DeleteOptions{}); err != nil {
+			klog.ErrorS(err, "Deleting namespace", "namespace", namespace)
+		}
+	}()
+
+	result.Err = c.Run(ctx, &Framework{Config: cfg, Namespace: namespace})
+	result.Duration = time.Since(start)
+	return result
+}
+
+// Report writes a line for each result followed by a summary, and returns
+// whether every case passed or was skipped
+func Report(w io.Writer, results []Result) bool {
+	var passed, failed, skipped int
+	for _, r := range results {
+		switch {
+		case r.Passed():
+			passed++
+			fmt.Fprintf(w, "PASS  %s (%s)\n", r.Case, r.Duration.Round(time.Millisecond))
+		case r.Skipped():
+			skipped++
+			fmt.Fprintf(w, "SKIP  %s: %v\n", r.Case, r.Err)
+		default:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s (%s): %v\n", r.Case, r.Duration.Round(time.Millisecond), r.Err)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return failed == 0
+}
+
+// namespaceFor returns a fresh namespace name for the case. The suffix keeps
+// reruns from colliding with namespaces still being deleted.
+func namespaceFor(prefix, caseName string) string {
+	name := prefix + "-" + labelValue(caseName)
+	if len(name) > 56 {
+		name = strings.TrimRight(name[:56], "-")
+	}
+	return name + "-" + utilrand.String(5)
+}
+
+// labelValue turns a case name into something usable in names and labels
+func labelValue(caseName string) string {
+	return strings.NewReplacer("/", "-", "_", "-").Replace(strings.ToLower(caseName))
+}
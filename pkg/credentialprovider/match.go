@@ -0,0 +1,56 @@
+package credentialprovider
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Match reports whether an image matches a matchImages pattern, using the
+// kubelet's rules: the pattern and image must have the same number of host
+// labels, each label of the pattern is a glob (so *.example.com matches
+// a.example.com but not example.com or a.b.example.com), ports must be equal,
+// and the pattern's path must be a prefix of the image's.
+func Match(pattern, image string) bool {
+	p, err := parseSchemeless(pattern)
+	if err != nil {
+		return false
+	}
+	i, err := parseSchemeless(image)
+	if err != nil {
+		return false
+	}
+	pLabels, pPort := splitHost(p.Host)
+	iLabels, iPort := splitHost(i.Host)
+	if pPort != iPort || len(pLabels) != len(iLabels) || !strings.HasPrefix(i.Path, p.Path) {
+		return false
+	}
+	for k, label := range pLabels {
+		if ok, err := filepath.Match(label, iLabels[k]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSchemeless(s string) (*url.URL, error) {
+	return url.Parse("https://" + s)
+}
+
+func splitHost(hostport string) ([]string, string) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, ""
+	}
+	return strings.Split(host, "."), port
+}
+
+// registryOf returns the host part of an image
+func registryOf(image string) string {
+	u, err := parseSchemeless(image)
+	if err != nil {
+		return image
+	}
+	return u.Host
+}
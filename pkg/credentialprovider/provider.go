@@ -0,0 +1,263 @@
+package credentialprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// DefaultTimeout bounds how long a plugin may run
+const DefaultTimeout = time.Minute
+
+// Providers runs the plugins in a credential provider config
+type Providers struct {
+	plugins []*plugin
+}
+
+// LoadConfig reads and validates a credential provider config file, which
+// may be YAML or JSON
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid credential provider config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Kind != configKind {
+		return fmt.Errorf("kind must be %s, got %q", configKind, cfg.Kind)
+	}
+	if !supportedConfigAPIVersions[cfg.APIVersion] {
+		return fmt.Errorf("unsupported apiVersion %q", cfg.APIVersion)
+	}
+	if len(cfg.Providers) == 0 {
+		return errors.New("at least one provider is required")
+	}
+	names := map[string]bool{}
+	for _, p := range cfg.Providers {
+		switch {
+		case p.Name == "" || strings.ContainsAny(p.Name, `/\`) || p.Name == "." || p.Name == "..":
+			return fmt.Errorf("provider name %q must be a file name", p.Name)
+		case names[p.Name]:
+			return fmt.Errorf("provider %q is configured more than once", p.Name)
+		case len(p.MatchImages) == 0:
+			return fmt.Errorf("provider %q: matchImages is required", p.Name)
+		case p.DefaultCacheDuration == nil || p.DefaultCacheDuration.Duration < 0:
+			return fmt.Errorf("provider %q: defaultCacheDuration is required and must not be negative", p.Name)
+		case !supportedAPIVersions[p.APIVersion]:
+			return fmt.Errorf("provider %q: unsupported apiVersion %q", p.Name, p.APIVersion)
+		}
+		names[p.Name] = true
+	}
+	return nil
+}
+
+// New returns the providers in cfg, whose plugins are executables in binDir
+func New(cfg *Config, binDir string) *Providers {
+	ps := &Providers{}
+	for _, p := range cfg.Providers {
+		ps.plugins = append(ps.plugins, &plugin{
+			config:  p,
+			path:    filepath.Join(binDir, p.Name),
+			timeout: DefaultTimeout,
+			now:     time.Now,
+			cache:   map[string]cacheEntry{},
+		})
+	}
+	return ps
+}
+
+// Load is LoadConfig followed by New
+func Load(configPath, binDir string) (*Providers, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return New(cfg, binDir), nil
+}
+
+// Lookup returns the credential for an image from the first matching plugin
+// that has one, or an empty credential if none do. An error is only returned
+// if no plugin had a credential and at least one of them failed.
+func (ps *Providers) Lookup(ctx context.Context, image string) (oci.Credential, error) {
+	var errs []error
+	for _, p := range ps.plugins {
+		if !p.matches(image) {
+			continue
+		}
+		auth, err := p.lookup(ctx, image)
+		if err != nil {
+			klog.V(2).InfoS("Credential provider failed", "provider", p.config.Name, "image", image, "err", err)
+			errs = append(errs, fmt.Errorf("credential provider %s: %w", p.config.Name, err))
+			continue
+		}
+		if auth != nil {
+			return oci.Credential{Username: auth.Username, Password: auth.Password}, nil
+		}
+	}
+	return oci.Credential{}, errors.Join(errs...)
+}
+
+// CredentialFunc returns the providers as an oci.CredentialFunc. The
+// oci.Client only asks for credentials by registry, so plugins are asked for
+// the registry host rather than a full image, and matchImages patterns with
+// a path never match.
+func (ps *Providers) CredentialFunc() oci.CredentialFunc {
+	return func(registry string) (oci.Credential, error) {
+		return ps.Lookup(context.Background(), registry)
+	}
+}
+
+// plugin execs a single provider and caches its responses
+type plugin struct {
+	config  ProviderConfig
+	path    string
+	timeout time.Duration
+	now     func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	auth    map[string]AuthConfig
+	expires time.Time
+}
+
+func (p *plugin) matches(image string) bool {
+	for _, pattern := range p.config.MatchImages {
+		if Match(pattern, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup returns the plugin's credential for the image, nil if it has none
+func (p *plugin) lookup(ctx context.Context, image string) (*AuthConfig, error) {
+	if auth, ok := p.cached(image); ok {
+		return bestMatch(auth, image), nil
+	}
+	resp, err := p.exec(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := p.config.DefaultCacheDuration.Duration
+	if resp.CacheDuration != nil {
+		duration = resp.CacheDuration.Duration
+	}
+	if duration > 0 {
+		var key string
+		switch resp.CacheKeyType {
+		case ImagePluginCacheKeyType:
+			key = cacheKey(ImagePluginCacheKeyType, image)
+		case RegistryPluginCacheKeyType:
+			key = cacheKey(RegistryPluginCacheKeyType, registryOf(image))
+		case GlobalPluginCacheKeyType:
+			key = cacheKey(GlobalPluginCacheKeyType, "")
+		}
+		p.mu.Lock()
+		p.cache[key] = cacheEntry{auth: resp.Auth, expires: p.now().Add(duration)}
+		p.mu.Unlock()
+	}
+	return bestMatch(resp.Auth, image), nil
+}
+
+func cacheKey(t CacheKeyType, s string) string {
+	return string(t) + ":" + s
+}
+
+func (p *plugin) cached(image string) (map[string]AuthConfig, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for _, key := range []string{
+		cacheKey(ImagePluginCacheKeyType, image),
+		cacheKey(RegistryPluginCacheKeyType, registryOf(image)),
+		cacheKey(GlobalPluginCacheKeyType, ""),
+	} {
+		entry, ok := p.cache[key]
+		if !ok {
+			continue
+		}
+		if now.Before(entry.expires) {
+			return entry.auth, true
+		}
+		delete(p.cache, key)
+	}
+	return nil, false
+}
+
+// bestMatch returns the credential whose pattern matches the image most
+// specifically, taken to be the longest one
+func bestMatch(auth map[string]AuthConfig, image string) *AuthConfig {
+	var best string
+	var found *AuthConfig
+	for pattern, a := range auth {
+		if !Match(pattern, image) {
+			continue
+		}
+		if found == nil || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			a := a
+			best, found = pattern, &a
+		}
+	}
+	return found
+}
+
+func (p *plugin) exec(ctx context.Context, image string) (*Response, error) {
+	req, err := json.Marshal(Request{APIVersion: p.config.APIVersion, Kind: requestKind, Image: image})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, p.path, p.config.Args...)
+	cmd.Env = os.Environ()
+	for _, e := range p.config.Env {
+		cmd.Env = append(cmd.Env, e.Name+"="+e.Value)
+	}
+	cmd.Stdin = bytes.NewReader(req)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w: %s", p.path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	if resp.Kind != responseKind || resp.APIVersion != p.config.APIVersion {
+		return nil, fmt.Errorf("expected a %s %s response, got %s %s", p.config.APIVersion, responseKind, resp.APIVersion, resp.Kind)
+	}
+	switch resp.CacheKeyType {
+	case ImagePluginCacheKeyType, RegistryPluginCacheKeyType, GlobalPluginCacheKeyType:
+	default:
+		return nil, fmt.Errorf("invalid cacheKeyType %q", resp.CacheKeyType)
+	}
+	return resp, nil
+}
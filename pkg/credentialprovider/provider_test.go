@@ -0,0 +1,222 @@
+package credentialprovider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// When run as a plugin, the test binary answers with a credential for the
+// requested image's registry and records each call in a file
+func TestMain(m *testing.M) {
+	if os.Getenv("TEST_CREDENTIAL_PROVIDER") != "1" {
+		os.Exit(m.Run())
+	}
+	err := Serve(context.Background(), os.Stdin, os.Stdout, func(_ context.Context, req *Request) (*Response, error) {
+		f, err := os.OpenFile(os.Getenv("TEST_CALLS_FILE"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := f.WriteString(req.Image + "\n"); err != nil {
+			return nil, err
+		}
+		if strings.Contains(req.Image, "fail") {
+			return nil, os.ErrPermission
+		}
+		return &Response{
+			CacheKeyType: CacheKeyType(os.Getenv("TEST_CACHE_KEY_TYPE")),
+			Auth: map[string]AuthConfig{
+				"*.example.com":       {Username: "AWS", Password: "wildcard"},
+				registryOf(req.Image): {Username: "AWS", Password: "token-for-" + registryOf(req.Image)},
+			},
+		}, nil
+	})
+	if err != nil {
+		os.Stderr.WriteString(err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func testProviders(t *testing.T, cacheKeyType CacheKeyType, matchImages ...string) (*Providers, func() []string) {
+	t.Helper()
+	dir := t.TempDir()
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(self, filepath.Join(dir, "test-provider")); err != nil {
+		t.Fatal(err)
+	}
+	calls := filepath.Join(dir, "calls")
+	cfg := &Config{
+		Providers: []ProviderConfig{{
+			Name:                 "test-provider",
+			MatchImages:          matchImages,
+			DefaultCacheDuration: &metav1.Duration{Duration: time.Minute},
+			APIVersion:           "credentialprovider.kubelet.k8s.io/v1",
+			Env: []ExecEnvVar{
+				{Name: "TEST_CREDENTIAL_PROVIDER", Value: "1"},
+				{Name: "TEST_CALLS_FILE", Value: calls},
+				{Name: "TEST_CACHE_KEY_TYPE", Value: string(cacheKeyType)},
+			},
+		}},
+	}
+	return New(cfg, dir), func() []string {
+		data, err := os.ReadFile(calls)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Fields(string(data))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	ps, calls := testProviders(t, RegistryPluginCacheKeyType, "*.dkr.ecr.*.amazonaws.com", "*.example.com")
+	ctx := context.Background()
+
+	cred, err := ps.Lookup(ctx, "1234.dkr.ecr.us-west-2.amazonaws.com/wasm/hello:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oci.Credential{Username: "AWS", Password: "token-for-1234.dkr.ecr.us-west-2.amazonaws.com"}
+	if cred != want {
+		t.Errorf("got %+v, want %+v", cred, want)
+	}
+	// The more specific pattern wins over the wildcard
+	if cred, _ := ps.Lookup(ctx, "wasm.example.com/hello"); cred.Password != "token-for-wasm.example.com" {
+		t.Errorf("expected the registry's own credential, got %+v", cred)
+	}
+	// Images the plugin isn't configured for don't run it
+	if cred, err := ps.Lookup(ctx, "docker.io/library/hello"); err != nil || !cred.IsEmpty() {
+		t.Errorf("expected no credential, got %+v, %v", cred, err)
+	}
+	if got := calls(); len(got) != 2 {
+		t.Errorf("expected two plugin calls, got %v", got)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	tests := map[CacheKeyType]int{
+		ImagePluginCacheKeyType:    3,
+		RegistryPluginCacheKeyType: 2,
+		GlobalPluginCacheKeyType:   1,
+	}
+	for keyType, wantCalls := range tests {
+		t.Run(string(keyType), func(t *testing.T) {
+			ps, calls := testProviders(t, keyType, "*.example.com")
+			for _, image := range []string{"a.example.com/one", "a.example.com/one", "a.example.com/two", "b.example.com/one"} {
+				if _, err := ps.Lookup(context.Background(), image); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := calls(); len(got) != wantCalls {
+				t.Errorf("expected %d plugin calls, got %v", wantCalls, got)
+			}
+		})
+	}
+}
+
+func TestLookupExpiry(t *testing.T) {
+	ps, calls := testProviders(t, RegistryPluginCacheKeyType, "*.example.com")
+	now := time.Now()
+	ps.plugins[0].now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, _ = ps.Lookup(ctx, "a.example.com/one")
+	_, _ = ps.Lookup(ctx, "a.example.com/one")
+	now = now.Add(2 * time.Minute)
+	_, _ = ps.Lookup(ctx, "a.example.com/one")
+	if got := calls(); len(got) != 2 {
+		t.Errorf("expected the credential to be fetched again after expiring, got calls %v", got)
+	}
+}
+
+func TestLookupFailure(t *testing.T) {
+	ps, _ := testProviders(t, RegistryPluginCacheKeyType, "*.example.com")
+	_, err := ps.Lookup(context.Background(), "fail.example.com/one")
+	if err == nil || !strings.Contains(err.Error(), "test-provider") {
+		t.Errorf("expected the plugin's failure, got %v", err)
+	}
+
+	cred, err := ps.CredentialFunc()("ok.example.com")
+	if err != nil || cred.Password != "token-for-ok.example.com" {
+		t.Errorf("got %+v, %v", cred, err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	valid := `apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+  - name: ecr-credential-provider
+    matchImages:
+      - "*.dkr.ecr.*.amazonaws.com"
+    defaultCacheDuration: 12h
+    apiVersion: credentialprovider.kubelet.k8s.io/v1
+    args: [get-credentials]
+    env:
+      - name: AWS_PROFILE
+        value: wasm
+`
+	tests := map[string]string{
+		"":               valid,
+		"kind must be":   strings.Replace(valid, "CredentialProviderConfig", "KubeletConfiguration", 1),
+		"unsupported":    strings.Replace(valid, "credentialprovider.kubelet.k8s.io/v1", "credentialprovider.kubelet.k8s.io/v2", 1),
+		"matchImages":    strings.Replace(valid, `      - "*.dkr.ecr.*.amazonaws.com"`+"\n", "", 1),
+		"must be a file": strings.Replace(valid, "name: ecr-credential-provider", "name: ../ecr", 1),
+		"defaultCache":   strings.Replace(valid, "    defaultCacheDuration: 12h\n", "", 1),
+		"unknown field":  strings.Replace(valid, "args:", "arguments:", 1),
+	}
+	for wantErr, config := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := LoadConfig(path)
+		if wantErr == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if p := cfg.Providers[0]; p.DefaultCacheDuration.Duration != 12*time.Hour || p.Env[0].Value != "wasm" {
+				t.Errorf("unexpected config %+v", p)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("expected an error containing %q, got %v", wantErr, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, image string
+		want           bool
+	}{
+		{"*.dkr.ecr.*.amazonaws.com", "1234.dkr.ecr.us-west-2.amazonaws.com/wasm/hello:v1", true},
+		{"*.azurecr.io", "webassembly.azurecr.io", true},
+		{"*.azurecr.io", "azurecr.io", false},
+		{"*.azurecr.io", "a.b.azurecr.io", false},
+		{"registry.example.com:5000", "registry.example.com:5000/hello", true},
+		{"registry.example.com:5000", "registry.example.com/hello", false},
+		{"registry.example.com/wasm", "registry.example.com/wasm/hello", true},
+		{"registry.example.com/wasm", "registry.example.com/other/hello", false},
+		{"registry.example.com/wasm", "registry.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.image); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.image, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package credentialprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ProvideFunc returns the response for a plugin request. APIVersion and Kind
+// are filled in by Serve.
+type ProvideFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// Serve implements the plugin side of the protocol: it reads a request from
+// in, calls provide, and writes the response to out. It is meant to be the
+// whole of a plugin's main function, with os.Stdin and os.Stdout.
+func Serve(ctx context.Context, in io.Reader, out io.Writer, provide ProvideFunc) error {
+	req := &Request{}
+	if err := json.NewDecoder(in).Decode(req); err != nil {
+		return fmt.Errorf("decoding request: %w", err)
+	}
+	if req.Kind != requestKind || !supportedAPIVersions[req.APIVersion] {
+		return fmt.Errorf("unsupported request %s %s", req.APIVersion, req.Kind)
+	}
+	if req.Image == "" {
+		return fmt.Errorf("request has no image")
+	}
+	resp, err := provide(ctx, req)
+	if err != nil {
+		return err
+	}
+	resp.APIVersion, resp.Kind = req.APIVersion, responseKind
+	if resp.CacheKeyType == "" {
+		resp.CacheKeyType = RegistryPluginCacheKeyType
+	}
+	return json.NewEncoder(out).Encode(resp)
+}
@@ -0,0 +1,104 @@
+// Package credentialprovider implements the kubelet's image credential
+// provider exec plugin protocol, so that the plugins written for the kubelet
+// (such as ecr-credential-provider or acr-credential-provider, which turn
+// cloud workload identities into short-lived registry credentials) can supply
+// credentials for pulling wasm modules.
+//
+// The configuration file and the request and response messages are the ones
+// documented at
+// https://kubernetes.io/docs/tasks/administer-cluster/kubelet-credential-provider/
+package credentialprovider
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The API versions of the plugin protocol accepted in a provider's
+// apiVersion. They differ only in name.
+var supportedAPIVersions = map[string]bool{
+	"credentialprovider.kubelet.k8s.io/v1":       true,
+	"credentialprovider.kubelet.k8s.io/v1beta1":  true,
+	"credentialprovider.kubelet.k8s.io/v1alpha1": true,
+}
+
+// The API versions accepted for the configuration file
+var supportedConfigAPIVersions = map[string]bool{
+	"kubelet.config.k8s.io/v1":       true,
+	"kubelet.config.k8s.io/v1beta1":  true,
+	"kubelet.config.k8s.io/v1alpha1": true,
+}
+
+// Config is a CredentialProviderConfig, the file passed to the kubelet with
+// --image-credential-provider-config
+type Config struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Providers  []ProviderConfig `json:"providers"`
+}
+
+// ProviderConfig configures a single plugin
+type ProviderConfig struct {
+	// Name is the plugin's executable name in the plugin directory
+	Name string `json:"name"`
+	// MatchImages are the image patterns the plugin is run for. See Match.
+	MatchImages []string `json:"matchImages"`
+	// DefaultCacheDuration is how long credentials are cached for when the
+	// plugin's response doesn't say
+	DefaultCacheDuration *metav1.Duration `json:"defaultCacheDuration"`
+	// APIVersion is the version of the request sent to, and the response
+	// expected from, the plugin
+	APIVersion string       `json:"apiVersion"`
+	Args       []string     `json:"args,omitempty"`
+	Env        []ExecEnvVar `json:"env,omitempty"`
+}
+
+// ExecEnvVar is an environment variable set for a plugin
+type ExecEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Request is the CredentialProviderRequest written to a plugin's stdin
+type Request struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Image is the image credentials are needed for
+	Image string `json:"image"`
+}
+
+// CacheKeyType says what credentials in a response apply to, and so how they
+// are cached
+type CacheKeyType string
+
+const (
+	// ImagePluginCacheKeyType caches credentials for the requested image only
+	ImagePluginCacheKeyType CacheKeyType = "Image"
+	// RegistryPluginCacheKeyType caches credentials for the image's registry
+	RegistryPluginCacheKeyType CacheKeyType = "Registry"
+	// GlobalPluginCacheKeyType caches credentials for every image the plugin
+	// matches
+	GlobalPluginCacheKeyType CacheKeyType = "Global"
+)
+
+// Response is the CredentialProviderResponse a plugin writes to its stdout
+type Response struct {
+	APIVersion    string           `json:"apiVersion"`
+	Kind          string           `json:"kind"`
+	CacheKeyType  CacheKeyType     `json:"cacheKeyType"`
+	CacheDuration *metav1.Duration `json:"cacheDuration,omitempty"`
+	// Auth maps image patterns, matched the same way as matchImages, to
+	// credentials
+	Auth map[string]AuthConfig `json:"auth,omitempty"`
+}
+
+// AuthConfig is a username and password for a registry
+type AuthConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+const (
+	requestKind  = "CredentialProviderRequest"
+	responseKind = "CredentialProviderResponse"
+	configKind   = "CredentialProviderConfig"
+)
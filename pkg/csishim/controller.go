@@ -0,0 +1,419 @@
+package csishim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	uidIndex = "uid"
+	// callTimeout bounds each call to a driver
+	callTimeout = 2 * time.Minute
+	// republishInterval is how often volumes are published again for
+	// drivers whose CSIDriver object sets requiresRepublish, so the tokens
+	// and secrets in them don't go stale
+	republishInterval = 2 * time.Minute
+)
+
+// Config is where the shim finds drivers and publishes volumes
+type Config struct {
+	// NodeName is the krustlet node the shim runs on
+	NodeName string
+	// Root is the directory volumes are published under
+	Root string
+	// PluginDir is where CSI drivers put their sockets
+	PluginDir string
+	// Endpoints overrides the socket of the named drivers
+	Endpoints map[string]string
+	// SecretsStore, if set, publishes Secrets Store CSI volumes when the
+	// Secrets Store CSI driver isn't running on the node
+	SecretsStore *SecretsStore
+}
+
+// Controller watches the pods on its node and publishes their inline CSI
+// volumes. Pods are keyed by UID, since volumes are published by UID and must
+// be unpublished after the pod is gone.
+type Controller struct {
+	client  kubernetes.Interface
+	config  *Config
+	indexer cache.Indexer
+	synced  cache.InformerSynced
+	queue   workqueue.TypedRateLimitingInterface[types.UID]
+	drivers *drivers
+}
+
+// NewController returns a controller that gets pods from the informer
+// factory, which should only list pods on the configured node. The factory
+// must be started by the caller.
+func NewController(client kubernetes.Interface, factory informers.SharedInformerFactory, config *Config) (*Controller, error) {
+	informer := factory.Core().V1().Pods().Informer()
+	err := informer.AddIndexers(cache.Indexers{uidIndex: func(obj interface{}) ([]string, error) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return nil, nil
+		}
+		return []string{string(pod.UID)}, nil
+	}})
+	if err != nil {
+		return nil, err
+	}
+	builtin := map[string]csi.NodeClient{}
+	if config.SecretsStore != nil {
+		builtin[SecretsStoreDriver] = config.SecretsStore
+	}
+	c := &Controller{
+		client:  client,
+		config:  config,
+		indexer: informer.GetIndexer(),
+		synced:  informer.HasSynced,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[types.UID](),
+			workqueue.TypedRateLimitingQueueConfig[types.UID]{Name: "krustlet-csi-shim"},
+		),
+		drivers: newDrivers(config.PluginDir, config.Endpoints, builtin),
+	}
+	_, _ = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+		DeleteFunc: c.enqueue,
+	})
+	return c, nil
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || len(inlineVolumes(pod)) == 0 {
+		return
+	}
+	c.queue.Add(pod.UID)
+}
+
+// Run publishes and unpublishes volumes until the context is cancelled
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+	defer c.drivers.close()
+
+	klog.Info("Waiting for pod informer to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		return fmt.Errorf("timed out waiting for the pod informer to sync")
+	}
+	// Volumes of pods deleted while the shim wasn't running are still on
+	// disk, with no event to say they should go
+	entries, err := os.ReadDir(c.config.Root)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			c.queue.Add(types.UID(e.Name()))
+		}
+	}
+
+	klog.InfoS("Starting CSI shim", "node", c.config.NodeName, "root", c.config.Root, "workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNext(ctx) {
+	}
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	uid, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(uid)
+
+	if err := c.sync(ctx, uid); err != nil {
+		klog.ErrorS(err, "Failed to sync pod volumes, retrying", "uid", uid)
+		c.queue.AddRateLimited(uid)
+		return true
+	}
+	c.queue.Forget(uid)
+	return true
+}
+
+// sync publishes the inline volumes of the pod with the UID while it runs,
+// and unpublishes any others found on disk for it
+func (c *Controller) sync(ctx context.Context, uid types.UID) error {
+	objs, err := c.indexer.ByIndex(uidIndex, string(uid))
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	var errs []error
+	republish := false
+	if len(objs) > 0 {
+		pod := objs[0].(*corev1.Pod)
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			for _, vol := range inlineVolumes(pod) {
+				wanted[vol.Name] = true
+				again, err := c.publish(ctx, pod, &vol)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("publishing volume %s: %w", vol.Name, err))
+				}
+				republish = republish || again
+			}
+		}
+	}
+	if republish && len(errs) == 0 {
+		c.queue.AddAfter(uid, republishInterval)
+	}
+
+	podDir := filepath.Join(c.config.Root, string(uid))
+	entries, err := os.ReadDir(podDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.Join(errs...)
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || wanted[e.Name()] {
+			continue
+		}
+		if err := c.unpublish(ctx, c.config.volumeDir(uid, e.Name())); err != nil {
+			errs = append(errs, fmt.Errorf("unpublishing volume %s: %w", e.Name(), err))
+		}
+	}
+	if len(wanted) == 0 && len(errs) == 0 {
+		if err := os.Remove(podDir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publish stages and publishes the volume if it isn't already, or publishes
+// it again if its driver requires republishing. It reports whether the
+// volume should be published again later.
+func (c *Controller) publish(ctx context.Context, pod *corev1.Pod, vol *corev1.Volume) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+	driver, err := c.csiDriver(ctx, vol.CSI.Driver)
+	if err != nil {
+		return false, err
+	}
+	republish := driver != nil && driver.Spec.RequiresRepublish != nil && *driver.Spec.RequiresRepublish
+	dir := c.config.volumeDir(pod.UID, vol.Name)
+	ready := dir.ready()
+	if ready && !republish {
+		return false, nil
+	}
+	node, err := c.drivers.node(vol.CSI.Driver)
+	if err != nil {
+		return false, err
+	}
+
+	staged, err := supportsStaging(ctx, node)
+	if err != nil {
+		return false, err
+	}
+	secrets, err := c.secrets(ctx, pod.Namespace, vol.CSI.NodePublishSecretRef)
+	if err != nil {
+		return false, err
+	}
+	volumeCtx := volumeContext(pod, vol)
+	if driver != nil && len(driver.Spec.TokenRequests) > 0 {
+		tokens, err := c.serviceAccountTokens(ctx, pod, driver.Spec.TokenRequests)
+		if err != nil {
+			return false, err
+		}
+		volumeCtx[serviceAccountTokensKey] = tokens
+	}
+	state := &volumeState{Driver: vol.CSI.Driver, VolumeID: volumeID(pod.UID, vol.Name), Staged: staged}
+
+	var fsType string
+	if vol.CSI.FSType != nil {
+		fsType = *vol.CSI.FSType
+	}
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+	var stagingPath string
+	if staged {
+		stagingPath = dir.staging()
+	}
+	// A volume being republished is already staged, and its state recorded
+	if !ready {
+		// The state is recorded before calling the driver, so a half
+		// published volume is still cleaned up if the pod goes away
+		if err := os.MkdirAll(string(dir), 0o750); err != nil {
+			return false, err
+		}
+		if err := dir.writeState(state); err != nil {
+			return false, err
+		}
+		if staged {
+			if err := os.MkdirAll(stagingPath, 0o750); err != nil {
+				return false, err
+			}
+			_, err := node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+				VolumeId:          state.VolumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  capability,
+				Secrets:           secrets,
+				VolumeContext:     volumeCtx,
+			})
+			if err != nil {
+				return false, fmt.Errorf("staging: %w", err)
+			}
+		}
+	}
+	// As with the kubelet, the driver creates the target directory itself
+	_, err = node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          state.VolumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        dir.target(),
+		VolumeCapability:  capability,
+		Readonly:          vol.CSI.ReadOnly != nil && *vol.CSI.ReadOnly,
+		Secrets:           secrets,
+		VolumeContext:     volumeCtx,
+	})
+	if err != nil {
+		return false, fmt.Errorf("publishing: %w", err)
+	}
+	if ready {
+		klog.V(4).InfoS("Republished volume", "pod", klog.KObj(pod), "volume", vol.Name, "driver", vol.CSI.Driver)
+		return republish, nil
+	}
+	if err := dir.markReady(); err != nil {
+		return false, err
+	}
+	klog.InfoS("Published volume", "pod", klog.KObj(pod), "volume", vol.Name, "driver", vol.CSI.Driver)
+	return republish, nil
+}
+
+// csiDriver returns the named driver's CSIDriver object, nil if it has none
+func (c *Controller) csiDriver(ctx context.Context, name string) (*storagev1.CSIDriver, error) {
+	driver, err := c.client.StorageV1().CSIDrivers().Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting CSIDriver %s: %w", name, err)
+	}
+	return driver, nil
+}
+
+// serviceAccountTokens requests the tokens of the pod's service account the
+// driver asks for, bound to the pod, and returns them in the form the
+// kubelet passes them to drivers
+func (c *Controller) serviceAccountTokens(ctx context.Context, pod *corev1.Pod, requests []storagev1.TokenRequest) (string, error) {
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	tokens := map[string]authenticationv1.TokenRequestStatus{}
+	for _, r := range requests {
+		var audiences []string
+		if r.Audience != "" {
+			audiences = []string{r.Audience}
+		}
+		tr, err := c.client.CoreV1().ServiceAccounts(pod.Namespace).CreateToken(ctx, serviceAccount, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				Audiences:         audiences,
+				ExpirationSeconds: r.ExpirationSeconds,
+				BoundObjectRef: &authenticationv1.BoundObjectReference{
+					APIVersion: "v1",
+					Kind:       "Pod",
+					Name:       pod.Name,
+					UID:        pod.UID,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return "", fmt.Errorf("requesting a service account token for audience %q: %w", r.Audience, err)
+		}
+		tokens[r.Audience] = tr.Status
+	}
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// unpublish unpublishes and unstages the volume and removes its directory
+func (c *Controller) unpublish(ctx context.Context, dir volumeDir) error {
+	state, err := dir.readState()
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		node, err := c.drivers.node(state.Driver)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, callTimeout)
+		defer cancel()
+		_, err = node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   state.VolumeID,
+			TargetPath: dir.target(),
+		})
+		if err != nil {
+			return fmt.Errorf("unpublishing: %w", err)
+		}
+		if state.Staged {
+			_, err = node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+				VolumeId:          state.VolumeID,
+				StagingTargetPath: dir.staging(),
+			})
+			if err != nil {
+				return fmt.Errorf("unstaging: %w", err)
+			}
+		}
+		klog.InfoS("Unpublished volume", "dir", string(dir), "driver", state.Driver)
+	}
+	return dir.remove()
+}
+
+// secrets returns the data of the referenced secret, passed to the driver
+// with the volume
+func (c *Controller) secrets(ctx context.Context, namespace string, ref *corev1.LocalObjectReference) (map[string]string, error) {
+	if ref == nil || ref.Name == "" {
+		return nil, nil
+	}
+	secret, err := c.client.CoreV1().Secrets(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting node publish secret: %w", err)
+	}
+	data := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = string(v)
+	}
+	return data, nil
+}
@@ -0,0 +1,279 @@
+package csishim
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeDriver is a CSI node service that publishes volumes by writing a file
+// into the target directory
+type fakeDriver struct {
+	csi.UnimplementedNodeServer
+	stage bool
+
+	mu          sync.Mutex
+	staged      map[string]*csi.NodeStageVolumeRequest
+	published   map[string]*csi.NodePublishVolumeRequest
+	unpublished []string
+	unstaged    []string
+}
+
+func (d *fakeDriver) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	resp := &csi.NodeGetCapabilitiesResponse{}
+	if d.stage {
+		resp.Capabilities = append(resp.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{Rpc: &csi.NodeServiceCapability_RPC{Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME}},
+		})
+	}
+	return resp, nil
+}
+
+func (d *fakeDriver) NodeStageVolume(_ context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.staged[req.VolumeId] = req
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (d *fakeDriver) NodeUnstageVolume(_ context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unstaged = append(d.unstaged, req.VolumeId)
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+func (d *fakeDriver) NodePublishVolume(_ context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if err := os.MkdirAll(req.TargetPath, 0o750); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(req.TargetPath, "secret"), []byte(req.Secrets["password"]), 0o600); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.published[req.VolumeId] = req
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (d *fakeDriver) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if err := os.RemoveAll(req.TargetPath); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unpublished = append(d.unpublished, req.VolumeId)
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// startDriver serves a fake driver and returns its socket
+func startDriver(t *testing.T, d *fakeDriver) string {
+	t.Helper()
+	d.staged = map[string]*csi.NodeStageVolumeRequest{}
+	d.published = map[string]*csi.NodePublishVolumeRequest{}
+	// Socket paths are limited to around 100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "csi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "csi.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	csi.RegisterNodeServer(srv, d)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return socket
+}
+
+func testPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "1234"},
+		Spec: corev1.PodSpec{
+			NodeName:           "krustlet",
+			ServiceAccountName: "app",
+			Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "secrets", VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{
+					Driver:               "fake.csi.example.com",
+					VolumeAttributes:     map[string]string{"vault": "kv"},
+					NodePublishSecretRef: &corev1.LocalObjectReference{Name: "creds"},
+				}}},
+			},
+		},
+	}
+}
+
+func newTestController(t *testing.T, socket string) (*Controller, podCache) {
+	t.Helper()
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	})
+	factory := informers.NewSharedInformerFactory(client, 0)
+	config := &Config{
+		NodeName:  "krustlet",
+		Root:      t.TempDir(),
+		PluginDir: t.TempDir(),
+		Endpoints: map[string]string{"fake.csi.example.com": "unix://" + socket},
+	}
+	c, err := NewController(client, factory, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.drivers.close)
+	return c, podCache{t, c}
+}
+
+// podCache adds and removes pods from the controller's informer
+type podCache struct {
+	t *testing.T
+	c *Controller
+}
+
+func (c podCache) add(pod *corev1.Pod) {
+	if err := c.c.indexer.Add(pod); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c podCache) delete(pod *corev1.Pod) {
+	if err := c.c.indexer.Delete(pod); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func TestPublishAndUnpublish(t *testing.T) {
+	for _, stage := range []bool{false, true} {
+		driver := &fakeDriver{stage: stage}
+		c, pods := newTestController(t, startDriver(t, driver))
+		pod := testPod()
+		pods.add(pod)
+
+		if err := c.sync(context.Background(), pod.UID); err != nil {
+			t.Fatal(err)
+		}
+		dir := c.config.volumeDir(pod.UID, "secrets")
+		if !dir.ready() {
+			t.Fatal("expected the volume to be marked ready")
+		}
+		if _, err := os.Stat(string(c.config.volumeDir(pod.UID, "config"))); err == nil {
+			t.Error("expected only CSI volumes to be published")
+		}
+		data, err := os.ReadFile(filepath.Join(dir.target(), "secret"))
+		if err != nil || string(data) != "hunter2" {
+			t.Errorf("expected the driver to be given the publish secret, got %q, %v", data, err)
+		}
+
+		id := volumeID(pod.UID, "secrets")
+		req, ok := driver.published[id]
+		if !ok {
+			t.Fatalf("expected volume %s to be published, got %v", id, driver.published)
+		}
+		for k, v := range map[string]string{
+			"vault":               "kv",
+			ephemeralKey:          "true",
+			podNameKey:            "app",
+			podNamespaceKey:       "default",
+			podUIDKey:             "1234",
+			serviceAccountNameKey: "app",
+		} {
+			if req.VolumeContext[k] != v {
+				t.Errorf("volume context %s: got %q, want %q", k, req.VolumeContext[k], v)
+			}
+		}
+		if _, ok := driver.staged[id]; ok != stage {
+			t.Errorf("staging supported %v, but staged %v", stage, ok)
+		}
+		if stage && req.StagingTargetPath != dir.staging() {
+			t.Errorf("expected the volume to be published from %s, got %q", dir.staging(), req.StagingTargetPath)
+		}
+
+		// Syncing again doesn't publish again
+		delete(driver.published, id)
+		if err := c.sync(context.Background(), pod.UID); err != nil {
+			t.Fatal(err)
+		}
+		if len(driver.published) != 0 {
+			t.Error("expected a ready volume not to be published again")
+		}
+
+		pods.delete(pod)
+		if err := c.sync(context.Background(), pod.UID); err != nil {
+			t.Fatal(err)
+		}
+		if len(driver.unpublished) != 1 || driver.unpublished[0] != id {
+			t.Errorf("expected the volume to be unpublished, got %v", driver.unpublished)
+		}
+		if len(driver.unstaged) > 0 != stage {
+			t.Errorf("staging supported %v, but unstaged %v", stage, driver.unstaged)
+		}
+		if _, err := os.Stat(filepath.Join(c.config.Root, string(pod.UID))); !os.IsNotExist(err) {
+			t.Errorf("expected the pod's directory to be removed, got %v", err)
+		}
+	}
+}
+
+func TestUnpublishFinishedPod(t *testing.T) {
+	driver := &fakeDriver{}
+	c, pods := newTestController(t, startDriver(t, driver))
+	pod := testPod()
+	pods.add(pod)
+	if err := c.sync(context.Background(), pod.UID); err != nil {
+		t.Fatal(err)
+	}
+
+	finished := pod.DeepCopy()
+	finished.Status.Phase = corev1.PodSucceeded
+	pods.add(finished)
+	if err := c.sync(context.Background(), pod.UID); err != nil {
+		t.Fatal(err)
+	}
+	if len(driver.unpublished) != 1 {
+		t.Errorf("expected the volume of a finished pod to be unpublished, got %v", driver.unpublished)
+	}
+}
+
+func TestMissingDriver(t *testing.T) {
+	c, pods := newTestController(t, filepath.Join(t.TempDir(), "missing.sock"))
+	pod := testPod()
+	pods.add(pod)
+	if err := c.sync(context.Background(), pod.UID); err == nil {
+		t.Fatal("expected an error when the driver isn't running")
+	}
+	if c.config.volumeDir(pod.UID, "secrets").ready() {
+		t.Error("expected the volume not to be marked ready")
+	}
+}
+
+func TestUnpublishLeavesUnmountedData(t *testing.T) {
+	driver := &fakeDriver{}
+	c, _ := newTestController(t, startDriver(t, driver))
+	// A volume left behind by a driver that didn't clean up its target
+	dir := c.config.volumeDir("5678", "data")
+	if err := os.MkdirAll(dir.target(), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir.target(), "keep"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sync(context.Background(), "5678"); err == nil {
+		t.Fatal("expected an error removing a target that still has data")
+	}
+	if _, err := os.Stat(filepath.Join(dir.target(), "keep")); err != nil {
+		t.Errorf("expected the data to be left alone, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package csishim
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// drivers connects to CSI drivers by name, reusing connections
+type drivers struct {
+	pluginDir string
+	endpoints map[string]string
+	// builtin serves drivers in process when they aren't running on the node
+	builtin map[string]csi.NodeClient
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newDrivers(pluginDir string, endpoints map[string]string, builtin map[string]csi.NodeClient) *drivers {
+	return &drivers{pluginDir: pluginDir, endpoints: endpoints, builtin: builtin, conns: map[string]*grpc.ClientConn{}}
+}
+
+// socket returns the path of the named driver's socket
+func (d *drivers) socket(name string) string {
+	if endpoint, ok := d.endpoints[name]; ok {
+		return strings.TrimPrefix(endpoint, "unix://")
+	}
+	return filepath.Join(d.pluginDir, name, "csi.sock")
+}
+
+// node returns a client for the named driver's node service
+func (d *drivers) node(name string) (csi.NodeClient, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if conn, ok := d.conns[name]; ok {
+		return csi.NewNodeClient(conn), nil
+	}
+	socket := d.socket(name)
+	if _, err := os.Stat(socket); err != nil {
+		if node, ok := d.builtin[name]; ok {
+			return node, nil
+		}
+		return nil, fmt.Errorf("CSI driver %s is not running on this node: %w", name, err)
+	}
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to CSI driver %s: %w", name, err)
+	}
+	d.conns[name] = conn
+	return csi.NewNodeClient(conn), nil
+}
+
+// close closes every connection, including the builtin drivers'
+func (d *drivers) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name, conn := range d.conns {
+		_ = conn.Close()
+		delete(d.conns, name)
+	}
+	for _, node := range d.builtin {
+		if closer, ok := node.(io.Closer); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
+// supportsStaging reports whether the driver wants volumes staged before they
+// are published
+func supportsStaging(ctx context.Context, node csi.NodeClient) (bool, error) {
+	resp, err := node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		return false, fmt.Errorf("getting node capabilities: %w", err)
+	}
+	for _, c := range resp.GetCapabilities() {
+		if c.GetRpc().GetType() == csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,244 @@
+package csishim
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The Secrets Store CSI driver's provider API, CSIDriverProvider in
+// sigs.k8s.io/secrets-store-csi-driver/provider/v1alpha1/service.proto.
+// Providers, such as those for Vault and the cloud secret managers, serve it
+// on a unix socket and return the secrets a SecretProviderClass names as
+// files. Only Mount is used.
+//
+// The messages are small and stable, so they are encoded by hand rather than
+// pulling in the driver's module for its generated code.
+const providerMountMethod = "/v1alpha1.CSIDriverProvider/Mount"
+
+// mountRequest asks a provider for the files of a volume. Attributes,
+// Secrets and Permission are JSON: the SecretProviderClass's parameters with
+// the pod's info, the node publish secret, and the files' mode.
+type mountRequest struct {
+	Attributes           string
+	Secrets              string
+	TargetPath           string
+	Permission           string
+	CurrentObjectVersion []objectVersion
+}
+
+// mountResponse holds the files of a volume, or the provider's error
+type mountResponse struct {
+	ObjectVersion []objectVersion
+	ErrorCode     string
+	Files         []providerFile
+}
+
+// objectVersion is the version of a secret a provider fetched
+type objectVersion struct {
+	ID      string
+	Version string
+}
+
+// providerFile is a file of a volume, at a path relative to its target
+type providerFile struct {
+	Path     string
+	Mode     int32
+	Contents []byte
+}
+
+func (r *mountRequest) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, r.Attributes)
+	b = appendString(b, 2, r.Secrets)
+	b = appendString(b, 3, r.TargetPath)
+	b = appendString(b, 4, r.Permission)
+	for _, v := range r.CurrentObjectVersion {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, v.marshal())
+	}
+	return b
+}
+
+func (r *mountRequest) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			r.Attributes = string(v)
+		case 2:
+			r.Secrets = string(v)
+		case 3:
+			r.TargetPath = string(v)
+		case 4:
+			r.Permission = string(v)
+		case 5:
+			var ov objectVersion
+			if err := ov.unmarshal(v); err != nil {
+				return err
+			}
+			r.CurrentObjectVersion = append(r.CurrentObjectVersion, ov)
+		}
+		return nil
+	})
+}
+
+func (r *mountResponse) marshal() []byte {
+	var b []byte
+	for _, v := range r.ObjectVersion {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, v.marshal())
+	}
+	if r.ErrorCode != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendString(nil, 1, r.ErrorCode))
+	}
+	for _, f := range r.Files {
+		var fb []byte
+		fb = appendString(fb, 1, f.Path)
+		if f.Mode != 0 {
+			fb = protowire.AppendTag(fb, 2, protowire.VarintType)
+			fb = protowire.AppendVarint(fb, uint64(f.Mode))
+		}
+		if len(f.Contents) > 0 {
+			fb = protowire.AppendTag(fb, 3, protowire.BytesType)
+			fb = protowire.AppendBytes(fb, f.Contents)
+		}
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, fb)
+	}
+	return b
+}
+
+func (r *mountResponse) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, _ protowire.Type, v []byte) error {
+		switch num {
+		case 1:
+			var ov objectVersion
+			if err := ov.unmarshal(v); err != nil {
+				return err
+			}
+			r.ObjectVersion = append(r.ObjectVersion, ov)
+		case 2:
+			return consumeFields(v, func(num protowire.Number, _ protowire.Type, v []byte) error {
+				if num == 1 {
+					r.ErrorCode = string(v)
+				}
+				return nil
+			})
+		case 3:
+			var f providerFile
+			err := consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1:
+					f.Path = string(v)
+				case num == 2 && typ == protowire.VarintType:
+					n, _ := protowire.ConsumeVarint(v)
+					f.Mode = int32(n)
+				case num == 3:
+					f.Contents = append([]byte(nil), v...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			r.Files = append(r.Files, f)
+		}
+		return nil
+	})
+}
+
+func (v *objectVersion) marshal() []byte {
+	return appendString(appendString(nil, 1, v.ID), 2, v.Version)
+}
+
+func (v *objectVersion) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, _ protowire.Type, b []byte) error {
+		switch num {
+		case 1:
+			v.ID = string(b)
+		case 2:
+			v.Version = string(b)
+		}
+		return nil
+	})
+}
+
+// appendString appends a string field, leaving it out if it is empty as
+// proto3 does
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// consumeFields calls fn with each field in b. Length delimited fields are
+// passed their contents and varints their encoding; unknown fields can be
+// ignored.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		if typ == protowire.BytesType {
+			v, n = protowire.ConsumeBytes(b)
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n >= 0 {
+				v = b[:n]
+			}
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// providerMessage is a message of the provider API
+type providerMessage interface {
+	marshal() []byte
+	unmarshal([]byte) error
+}
+
+// providerCodec encodes provider messages. It is named proto, since that is
+// what the messages are on the wire.
+type providerCodec struct{}
+
+func (providerCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(providerMessage)
+	if !ok {
+		return nil, fmt.Errorf("unexpected provider message %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (providerCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(providerMessage)
+	if !ok {
+		return fmt.Errorf("unexpected provider message %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (providerCodec) Name() string { return "proto" }
+
+// mount calls a provider's Mount
+func mount(ctx context.Context, conn grpc.ClientConnInterface, req *mountRequest) (*mountResponse, error) {
+	resp := &mountResponse{}
+	if err := conn.Invoke(ctx, providerMountMethod, req, resp, grpc.ForceCodec(providerCodec{})); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,260 @@
+package csishim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// SecretsStoreDriver is the name of the Secrets Store CSI driver
+	SecretsStoreDriver = "secrets-store.csi.k8s.io"
+	// DefaultProviderDir is where Secrets Store CSI providers put their
+	// sockets, as <dir>/<provider>.sock
+	DefaultProviderDir = "/var/run/secrets-store-csi-providers"
+
+	// secretProviderClassKey is the volume attribute naming the volume's
+	// SecretProviderClass
+	secretProviderClassKey = "secretProviderClass"
+	// filePermission is the mode the driver asks providers for by default
+	filePermission fs.FileMode = 0o644
+)
+
+var secretProviderClasses = schema.GroupVersionResource{
+	Group:    "secrets-store.csi.x-k8s.io",
+	Version:  "v1",
+	Resource: "secretproviderclasses",
+}
+
+// SecretsStore publishes Secrets Store CSI volumes itself, in place of the
+// Secrets Store CSI driver, which runs as a container and so can't run on a
+// krustlet node. Like the driver, it reads the volume's SecretProviderClass,
+// calls the class's provider for the secrets, and writes them as files into
+// the volume. Providers run on the host, each serving its socket in the
+// provider directory.
+//
+// Where the driver mounts a tmpfs for each volume, the files are written to
+// the volume's directory on disk, since krustlet preopens a directory
+// rather than mounting one.
+type SecretsStore struct {
+	client      dynamic.Interface
+	providerDir string
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewSecretsStore returns a SecretsStore that gets SecretProviderClasses with
+// the client and finds providers in providerDir
+func NewSecretsStore(client dynamic.Interface, providerDir string) *SecretsStore {
+	return &SecretsStore{client: client, providerDir: providerDir, conns: map[string]*grpc.ClientConn{}}
+}
+
+// provider returns a connection to the named provider
+func (s *SecretsStore) provider(name string) (*grpc.ClientConn, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, fmt.Errorf("invalid provider name %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if conn, ok := s.conns[name]; ok {
+		return conn, nil
+	}
+	socket := filepath.Join(s.providerDir, name+".sock")
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("secrets store provider %s is not running on this node: %w", name, err)
+	}
+	conn, err := grpc.NewClient("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to secrets store provider %s: %w", name, err)
+	}
+	s.conns[name] = conn
+	return conn, nil
+}
+
+// Close closes the connections to providers
+func (s *SecretsStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, conn := range s.conns {
+		_ = conn.Close()
+		delete(s.conns, name)
+	}
+	return nil
+}
+
+// NodeGetCapabilities implements csi.NodeClient. Volumes aren't staged.
+func (s *SecretsStore) NodeGetCapabilities(context.Context, *csi.NodeGetCapabilitiesRequest, ...grpc.CallOption) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+// NodePublishVolume implements csi.NodeClient by writing the secrets of the
+// volume's SecretProviderClass into the target directory. Publishing a
+// volume again fetches its secrets again, replacing the files.
+func (s *SecretsStore) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, _ ...grpc.CallOption) (*csi.NodePublishVolumeResponse, error) {
+	attrs := req.GetVolumeContext()
+	name := attrs[secretProviderClassKey]
+	if name == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "the %s volume attribute is required", secretProviderClassKey)
+	}
+	namespace := attrs[podNamespaceKey]
+	spc, err := s.client.Resource(secretProviderClasses).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting SecretProviderClass %s/%s: %w", namespace, name, err)
+	}
+	providerName, _, _ := unstructured.NestedString(spc.Object, "spec", "provider")
+	params, _, err := unstructured.NestedStringMap(spc.Object, "spec", "parameters")
+	if err != nil {
+		return nil, fmt.Errorf("reading SecretProviderClass %s/%s: %w", namespace, name, err)
+	}
+	if params == nil {
+		params = map[string]string{}
+	}
+	// As the driver does, providers are given the pod's info with the
+	// class's parameters
+	for _, k := range []string{podNameKey, podNamespaceKey, podUIDKey, serviceAccountNameKey, serviceAccountTokensKey} {
+		if v, ok := attrs[k]; ok {
+			params[k] = v
+		}
+	}
+	conn, err := s.provider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := json.Marshal(req.GetSecrets())
+	if err != nil {
+		return nil, err
+	}
+	permission, err := json.Marshal(filePermission)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := mount(ctx, conn, &mountRequest{
+		Attributes: string(attributes),
+		Secrets:    string(secrets),
+		TargetPath: req.GetTargetPath(),
+		Permission: string(permission),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", providerName, err)
+	}
+	if resp.ErrorCode != "" {
+		return nil, fmt.Errorf("provider %s failed to fetch secrets: %s", providerName, resp.ErrorCode)
+	}
+	if err := writeFiles(req.GetTargetPath(), resp.Files); err != nil {
+		return nil, err
+	}
+	klog.V(2).InfoS("Fetched secrets", "secretProviderClass", klog.KRef(namespace, name), "provider", providerName, "files", len(resp.Files))
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume implements csi.NodeClient by removing the volume's
+// files
+func (s *SecretsStore) NodeUnpublishVolume(_ context.Context, req *csi.NodeUnpublishVolumeRequest, _ ...grpc.CallOption) (*csi.NodeUnpublishVolumeResponse, error) {
+	if err := os.RemoveAll(req.GetTargetPath()); err != nil {
+		return nil, err
+	}
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// NodeStageVolume implements csi.NodeClient; volumes aren't staged
+func (s *SecretsStore) NodeStageVolume(context.Context, *csi.NodeStageVolumeRequest, ...grpc.CallOption) (*csi.NodeStageVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "volumes aren't staged")
+}
+
+// NodeUnstageVolume implements csi.NodeClient; volumes aren't staged
+func (s *SecretsStore) NodeUnstageVolume(context.Context, *csi.NodeUnstageVolumeRequest, ...grpc.CallOption) (*csi.NodeUnstageVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "volumes aren't staged")
+}
+
+// NodeGetVolumeStats implements csi.NodeClient; it isn't supported
+func (s *SecretsStore) NodeGetVolumeStats(context.Context, *csi.NodeGetVolumeStatsRequest, ...grpc.CallOption) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "volume stats are not supported")
+}
+
+// NodeExpandVolume implements csi.NodeClient; it isn't supported
+func (s *SecretsStore) NodeExpandVolume(context.Context, *csi.NodeExpandVolumeRequest, ...grpc.CallOption) (*csi.NodeExpandVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "volumes can't be expanded")
+}
+
+// NodeGetInfo implements csi.NodeClient; it isn't supported
+func (s *SecretsStore) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest, ...grpc.CallOption) (*csi.NodeGetInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "node info is not supported")
+}
+
+// writeFiles writes a provider's files into the target directory and removes
+// any other files there, left from an earlier publish. Each file is written
+// to a temporary file and renamed, so modules never read one half written.
+func writeFiles(target string, files []providerFile) error {
+	if err := os.MkdirAll(target, 0o750); err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, f := range files {
+		rel := filepath.Clean(filepath.FromSlash(f.Path))
+		if filepath.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("provider returned a file outside the volume: %q", f.Path)
+		}
+		path := filepath.Join(target, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+			return err
+		}
+		mode := fs.FileMode(f.Mode).Perm()
+		if mode == 0 {
+			mode = filePermission
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+		if err != nil {
+			return err
+		}
+		_, err = tmp.Write(f.Contents)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), mode)
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), path)
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return fmt.Errorf("writing %s: %w", f.Path, err)
+		}
+		keep[path] = true
+	}
+	return filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || keep[path] {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
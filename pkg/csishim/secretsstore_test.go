@@ -0,0 +1,226 @@
+package csishim
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// fakeProvider is a Secrets Store CSI provider that returns the files it is
+// set up with
+type fakeProvider struct {
+	mu       sync.Mutex
+	files    []providerFile
+	requests []*mountRequest
+}
+
+func (p *fakeProvider) setFiles(files ...providerFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files = files
+}
+
+func (p *fakeProvider) lastRequest() *mountRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.requests) == 0 {
+		return nil
+	}
+	return p.requests[len(p.requests)-1]
+}
+
+// startProvider serves a fake provider named vault and returns the provider
+// directory
+func startProvider(t *testing.T, p *fakeProvider) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "providers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	lis, err := net.Listen("unix", filepath.Join(dir, "vault.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(providerCodec{}))
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "v1alpha1.CSIDriverProvider",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Mount",
+			Handler: func(_ interface{}, _ context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &mountRequest{}
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				p.requests = append(p.requests, req)
+				return &mountResponse{
+					ObjectVersion: []objectVersion{{ID: "db-password", Version: "1"}},
+					Files:         p.files,
+				}, nil
+			},
+		}},
+	}, p)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return dir
+}
+
+func secretsStorePod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "1234"},
+		Spec: corev1.PodSpec{
+			NodeName:           "krustlet",
+			ServiceAccountName: "app",
+			Volumes: []corev1.Volume{{Name: "secrets", VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{
+				Driver:           SecretsStoreDriver,
+				VolumeAttributes: map[string]string{secretProviderClassKey: "db"},
+			}}}},
+		},
+	}
+}
+
+func TestSecretsStore(t *testing.T) {
+	provider := &fakeProvider{}
+	provider.setFiles(
+		providerFile{Path: "password", Mode: 0o600, Contents: []byte("hunter2")},
+		providerFile{Path: "tls/cert.pem", Contents: []byte("cert")},
+	)
+	providerDir := startProvider(t, provider)
+
+	requiresRepublish := true
+	client := fake.NewSimpleClientset(&storagev1.CSIDriver{
+		ObjectMeta: metav1.ObjectMeta{Name: SecretsStoreDriver},
+		Spec: storagev1.CSIDriverSpec{
+			RequiresRepublish: &requiresRepublish,
+			TokenRequests:     []storagev1.TokenRequest{{Audience: "vault"}},
+		},
+	})
+	var tokenRequest *authenticationv1.TokenRequest
+	client.PrependReactor("create", "serviceaccounts", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		tokenRequest = action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "token"}}, nil
+	})
+	spc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "secrets-store.csi.x-k8s.io/v1",
+		"kind":       "SecretProviderClass",
+		"metadata":   map[string]interface{}{"name": "db", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"provider":   "vault",
+			"parameters": map[string]interface{}{"roleName": "app"},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{secretProviderClasses: "SecretProviderClassList"}, spc)
+
+	c, err := NewController(client, informers.NewSharedInformerFactory(client, 0), &Config{
+		NodeName:     "krustlet",
+		Root:         t.TempDir(),
+		PluginDir:    t.TempDir(),
+		SecretsStore: NewSecretsStore(dynamicClient, providerDir),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.drivers.close)
+	pods := podCache{t, c}
+	pod := secretsStorePod()
+	pods.add(pod)
+
+	if err := c.sync(context.Background(), pod.UID); err != nil {
+		t.Fatal(err)
+	}
+	dir := c.config.volumeDir(pod.UID, "secrets")
+	if !dir.ready() {
+		t.Fatal("expected the volume to be marked ready")
+	}
+	for name, want := range map[string]string{"password": "hunter2", "tls/cert.pem": "cert"} {
+		data, err := os.ReadFile(filepath.Join(dir.target(), name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, data, err, want)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir.target(), "password")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the password to have mode 0600, got %v, %v", info, err)
+	}
+
+	req := provider.lastRequest()
+	if req == nil {
+		t.Fatal("expected the provider to be called")
+	}
+	if req.TargetPath != dir.target() || req.Permission != "420" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	var attrs map[string]string
+	if err := json.Unmarshal([]byte(req.Attributes), &attrs); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"roleName":            "app",
+		podNameKey:            "app",
+		podNamespaceKey:       "default",
+		podUIDKey:             "1234",
+		serviceAccountNameKey: "app",
+	} {
+		if attrs[k] != v {
+			t.Errorf("attribute %s: got %q, want %q", k, attrs[k], v)
+		}
+	}
+	var tokens map[string]authenticationv1.TokenRequestStatus
+	if err := json.Unmarshal([]byte(attrs[serviceAccountTokensKey]), &tokens); err != nil || tokens["vault"].Token != "token" {
+		t.Errorf("expected the provider to be given the vault token, got %q, %v", attrs[serviceAccountTokensKey], err)
+	}
+	if tokenRequest == nil || tokenRequest.Spec.BoundObjectRef == nil || tokenRequest.Spec.BoundObjectRef.UID != pod.UID ||
+		len(tokenRequest.Spec.Audiences) != 1 || tokenRequest.Spec.Audiences[0] != "vault" {
+		t.Errorf("expected a vault token bound to the pod, got %+v", tokenRequest)
+	}
+
+	// The driver requires republishing, so a rotated secret replaces the
+	// files and a removed one goes away
+	provider.setFiles(providerFile{Path: "password", Contents: []byte("correct horse")})
+	if err := c.sync(context.Background(), pod.UID); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir.target(), "password")); err != nil || string(data) != "correct horse" {
+		t.Errorf("expected the rotated password, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir.target(), "tls", "cert.pem")); !os.IsNotExist(err) {
+		t.Errorf("expected the removed secret to be deleted, got %v", err)
+	}
+
+	pods.delete(pod)
+	if err := c.sync(context.Background(), pod.UID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(string(dir)); !os.IsNotExist(err) {
+		t.Errorf("expected the volume to be removed, got %v", err)
+	}
+}
+
+func TestWriteFilesRejectsEscapes(t *testing.T) {
+	target := t.TempDir()
+	for _, path := range []string{"../escape", "/etc/passwd", ".."} {
+		if err := writeFiles(target, []providerFile{{Path: path}}); err == nil {
+			t.Errorf("expected %q to be rejected", path)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package csishim publishes inline CSI volumes for pods on a krustlet node.
+//
+// Krustlet mounts persistent volume claims through their CSI drivers itself,
+// but it has no support for inline CSI volumes, the kind declared in the pod
+// spec with a csi source and used by drivers such as the Secrets Store CSI
+// driver. The shim runs on the krustlet host next to the drivers, calls each
+// volume's driver to stage and publish it into a directory under Root, and
+// writes a ready file once it has. Krustlet waits for that file and preopens
+// the directory for the pod's modules. When the pod is deleted the shim
+// unpublishes and unstages the volume again.
+//
+// Volumes are published at <Root>/<pod UID>/<volume name>/mount, so Root must
+// match krustlet's KRUSTLET_CSI_SHIM_DIR.
+package csishim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DefaultRoot is where volumes are published unless configured otherwise,
+	// and where krustlet looks for them by default
+	DefaultRoot = "/var/lib/krustlet/csi"
+	// DefaultPluginDir is where CSI drivers put their sockets, as
+	// <dir>/<driver>/csi.sock
+	DefaultPluginDir = "/var/lib/kubelet/plugins"
+
+	readyFile = "ready"
+	stateFile = "volume.json"
+	mountDir  = "mount"
+	stageDir  = "staging"
+)
+
+// Volume context keys the kubelet passes to drivers of inline volumes
+const (
+	ephemeralKey          = "csi.storage.k8s.io/ephemeral"
+	podNameKey            = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey       = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey             = "csi.storage.k8s.io/pod.uid"
+	serviceAccountNameKey = "csi.storage.k8s.io/serviceAccount.name"
+	// serviceAccountTokensKey holds the tokens the driver's CSIDriver object
+	// asks for, as JSON keyed by audience
+	serviceAccountTokensKey = "csi.storage.k8s.io/serviceAccount.tokens"
+)
+
+// volumeState is written next to a published volume, so it can be
+// unpublished after the pod it belonged to is gone
+type volumeState struct {
+	Driver   string `json:"driver"`
+	VolumeID string `json:"volumeID"`
+	Staged   bool   `json:"staged"`
+}
+
+// volumeDir is where the named volume of a pod is published
+type volumeDir string
+
+func (c *Config) volumeDir(uid types.UID, name string) volumeDir {
+	return volumeDir(filepath.Join(c.Root, string(uid), name))
+}
+
+func (d volumeDir) target() string  { return filepath.Join(string(d), mountDir) }
+func (d volumeDir) staging() string { return filepath.Join(string(d), stageDir) }
+
+func (d volumeDir) ready() bool {
+	_, err := os.Stat(filepath.Join(string(d), readyFile))
+	return err == nil
+}
+
+func (d volumeDir) markReady() error {
+	return os.WriteFile(filepath.Join(string(d), readyFile), nil, 0o644)
+}
+
+// readState returns the recorded state of the volume, nil if there is none
+func (d volumeDir) readState() (*volumeState, error) {
+	data, err := os.ReadFile(filepath.Join(string(d), stateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state volumeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", stateFile, err)
+	}
+	return &state, nil
+}
+
+func (d volumeDir) writeState(state *volumeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(string(d), stateFile), data, 0o600)
+}
+
+// remove deletes what the shim created for the volume. Directories are only
+// removed if they are empty, so nothing is deleted from a volume a driver
+// failed to unmount.
+func (d volumeDir) remove() error {
+	for _, name := range []string{readyFile, stateFile, mountDir, stageDir} {
+		if err := os.Remove(filepath.Join(string(d), name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Remove(string(d)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// inlineVolumes returns the pod's inline CSI volumes
+func inlineVolumes(pod *corev1.Pod) []corev1.Volume {
+	var vols []corev1.Volume
+	for _, v := range pod.Spec.Volumes {
+		if v.CSI != nil {
+			vols = append(vols, v)
+		}
+	}
+	return vols
+}
+
+// volumeID returns the ID drivers are given for an inline volume. It is
+// derived the same way as the kubelet's, so drivers see the same IDs on
+// krustlet nodes.
+func volumeID(uid types.UID, name string) string {
+	sum := sha256.Sum256([]byte(string(uid) + name))
+	return "csi-" + hex.EncodeToString(sum[:])
+}
+
+// volumeContext returns the attributes passed to the driver with the volume:
+// the volume's own attributes and the pod info the kubelet adds for inline
+// volumes
+func volumeContext(pod *corev1.Pod, vol *corev1.Volume) map[string]string {
+	ctx := map[string]string{}
+	for k, v := range vol.CSI.VolumeAttributes {
+		ctx[k] = v
+	}
+	ctx[ephemeralKey] = "true"
+	ctx[podNameKey] = pod.Name
+	ctx[podNamespaceKey] = pod.Namespace
+	ctx[podUIDKey] = string(pod.UID)
+	ctx[serviceAccountNameKey] = pod.Spec.ServiceAccountName
+	return ctx
+}
@@ -0,0 +1,151 @@
+package csrapprover
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	certificateslisters "k8s.io/client-go/listers/certificates/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// ApprovalReason is the reason set on the Approved condition of CSRs this
+// controller approves
+const ApprovalReason = "KrustletAutoApproved"
+
+// Controller watches CSRs and approves those the policy allows. CSRs the
+// policy doesn't allow are left pending rather than denied, so an
+// administrator or another approver can still act on them.
+type Controller struct {
+	client kubernetes.Interface
+	policy *Policy
+	lister certificateslisters.CertificateSigningRequestLister
+	synced cache.InformerSynced
+	queue  workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController returns a controller that gets CSRs from the informer
+// factory. The factory must be started by the caller.
+func NewController(client kubernetes.Interface, factory informers.SharedInformerFactory, policy *Policy) *Controller {
+	informer := factory.Certificates().V1().CertificateSigningRequests()
+	c := &Controller{
+		client: client,
+		policy: policy,
+		lister: informer.Lister(),
+		synced: informer.Informer().HasSynced,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "krustlet-csr-approver"},
+		),
+	}
+	_, _ = informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+	})
+	return c
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	csr, ok := obj.(*certificatesv1.CertificateSigningRequest)
+	if !ok || isFinished(csr) {
+		return
+	}
+	c.queue.Add(csr.Name)
+}
+
+// Run processes CSRs until the context is cancelled
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.Info("Waiting for CSR informer to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		return fmt.Errorf("timed out waiting for the CSR informer to sync")
+	}
+	klog.InfoS("Starting CSR approver", "workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNext(ctx) {
+	}
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	name, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	if err := c.sync(ctx, name); err != nil {
+		klog.ErrorS(err, "Failed to approve CSR, retrying", "csr", name)
+		c.queue.AddRateLimited(name)
+		return true
+	}
+	c.queue.Forget(name)
+	return true
+}
+
+// sync approves the named CSR if it is pending and the policy allows it
+func (c *Controller) sync(ctx context.Context, name string) error {
+	csr, err := c.lister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isFinished(csr) {
+		return nil
+	}
+
+	if err := c.policy.Evaluate(csr); err != nil {
+		klog.V(2).InfoS("Not approving CSR", "csr", name, "signer", csr.Spec.SignerName, "requester", csr.Spec.Username, "reason", err.Error())
+		return nil
+	}
+
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+		Type:           certificatesv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         ApprovalReason,
+		Message:        "Approved by the krustlet CSR approver",
+		LastUpdateTime: metav1.Now(),
+	})
+	_, err = c.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, name, csr, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("updating approval: %w", err)
+	}
+	klog.InfoS("Approved CSR", "csr", name, "signer", csr.Spec.SignerName, "requester", csr.Spec.Username)
+	return nil
+}
+
+// isFinished reports whether the CSR has already been approved, denied, or
+// failed
+func isFinished(csr *certificatesv1.CertificateSigningRequest) bool {
+	for _, c := range csr.Status.Conditions {
+		switch c.Type {
+		case certificatesv1.CertificateApproved, certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package csrapprover
+
+import (
+	"context"
+	"testing"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestController(t *testing.T, csrs ...*certificatesv1.CertificateSigningRequest) (*Controller, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	c := NewController(client, factory, &Policy{ApproveClient: true, ApproveServing: true})
+	indexer := factory.Certificates().V1().CertificateSigningRequests().Informer().GetIndexer()
+	for _, csr := range csrs {
+		if _, err := client.CertificatesV1().CertificateSigningRequests().Create(context.Background(), csr, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := indexer.Add(csr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c, client
+}
+
+func approvalUpdates(client *fake.Clientset) int {
+	n := 0
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "update" && a.GetSubresource() == "approval" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSyncApproves(t *testing.T) {
+	csr := servingCSR(t, "krustlet-wasi", "10.0.0.4")
+	c, client := newTestController(t, csr)
+
+	if err := c.sync(context.Background(), csr.Name); err != nil {
+		t.Fatal(err)
+	}
+	if approvalUpdates(client) != 1 {
+		t.Fatalf("expected one approval update, got actions %v", client.Actions())
+	}
+	got, err := client.CertificatesV1().CertificateSigningRequests().Get(context.Background(), csr.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isFinished(got) || got.Status.Conditions[0].Reason != ApprovalReason {
+		t.Errorf("expected the CSR to be approved, got %+v", got.Status)
+	}
+}
+
+func TestSyncLeavesOthers(t *testing.T) {
+	denied := clientCSR(t, "krustlet-wasi")
+	denied.Name = "denied"
+	denied.Status.Conditions = []certificatesv1.CertificateSigningRequestCondition{
+		{Type: certificatesv1.CertificateDenied, Status: corev1.ConditionTrue},
+	}
+	refused := servingCSR(t, "krustlet-wasi")
+	refused.Name = "refused"
+	refused.Spec.Username = "someone-else"
+
+	c, client := newTestController(t, denied, refused)
+	for _, name := range []string{"denied", "refused", "deleted"} {
+		if err := c.sync(context.Background(), name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if n := approvalUpdates(client); n != 0 {
+		t.Errorf("expected no approvals, got %d", n)
+	}
+}
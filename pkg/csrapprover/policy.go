@@ -0,0 +1,187 @@
+// Package csrapprover approves the certificate signing requests krustlet
+// makes while bootstrapping, so nodes can join without someone running
+// `kubectl certificate approve` for each one.
+package csrapprover
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+)
+
+const (
+	nodeUserPrefix = "system:node:"
+	nodesGroup     = "system:nodes"
+	// bootstrappersGroup is the group every bootstrap token user is in
+	bootstrappersGroup = "system:bootstrappers"
+)
+
+// allowedUsages are the key usages krustlet asks for, by signer. Anything
+// beyond these is refused.
+var allowedUsages = map[string][]certificatesv1.KeyUsage{
+	certificatesv1.KubeAPIServerClientKubeletSignerName: {
+		certificatesv1.UsageDigitalSignature,
+		certificatesv1.UsageKeyEncipherment,
+		certificatesv1.UsageClientAuth,
+	},
+	certificatesv1.KubeletServingSignerName: {
+		certificatesv1.UsageDigitalSignature,
+		certificatesv1.UsageKeyEncipherment,
+		certificatesv1.UsageServerAuth,
+	},
+}
+
+// Policy decides which CSRs are approved
+type Policy struct {
+	// ApproveClient approves node client certificates requested with a
+	// bootstrap token, or renewed by the node itself
+	ApproveClient bool
+	// ApproveServing approves krustlet's serving certificates
+	ApproveServing bool
+	// NodeNamePattern, if set, restricts approval to nodes whose name
+	// matches it
+	NodeNamePattern *regexp.Regexp
+	// AllowedCIDRs, if set, restricts the IP addresses a serving certificate
+	// may be issued for
+	AllowedCIDRs []*net.IPNet
+}
+
+// Evaluate returns nil if the policy approves the CSR, or an error saying why
+// it doesn't
+func (p *Policy) Evaluate(csr *certificatesv1.CertificateSigningRequest) error {
+	switch csr.Spec.SignerName {
+	case certificatesv1.KubeAPIServerClientKubeletSignerName:
+		if !p.ApproveClient {
+			return fmt.Errorf("client certificate approval is disabled")
+		}
+	case certificatesv1.KubeletServingSignerName:
+		if !p.ApproveServing {
+			return fmt.Errorf("serving certificate approval is disabled")
+		}
+	default:
+		return fmt.Errorf("signer %q is not a kubelet signer", csr.Spec.SignerName)
+	}
+
+	if err := checkUsages(csr.Spec.SignerName, csr.Spec.Usages); err != nil {
+		return err
+	}
+	req, err := parseRequest(csr.Spec.Request)
+	if err != nil {
+		return err
+	}
+
+	nodeName, err := subjectNodeName(req)
+	if err != nil {
+		return err
+	}
+	if p.NodeNamePattern != nil && !p.NodeNamePattern.MatchString(nodeName) {
+		return fmt.Errorf("node name %q does not match %s", nodeName, p.NodeNamePattern)
+	}
+
+	if csr.Spec.SignerName == certificatesv1.KubeAPIServerClientKubeletSignerName {
+		return p.checkClientRequester(csr, nodeName, req)
+	}
+	return p.checkServing(csr, nodeName, req)
+}
+
+// checkClientRequester makes sure a client CSR comes from a bootstrap token or
+// from the node renewing its own certificate
+func (p *Policy) checkClientRequester(csr *certificatesv1.CertificateSigningRequest, nodeName string, req *x509.CertificateRequest) error {
+	if len(req.DNSNames) > 0 || len(req.IPAddresses) > 0 || len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return fmt.Errorf("client certificate requests must not have subject alternative names")
+	}
+	if csr.Spec.Username == nodeUserPrefix+nodeName {
+		return nil
+	}
+	if slices.Contains(csr.Spec.Groups, bootstrappersGroup) {
+		return nil
+	}
+	return fmt.Errorf("requester %q is neither a bootstrap token nor node %q", csr.Spec.Username, nodeName)
+}
+
+// checkServing makes sure a serving CSR comes from the node it is for and only
+// names that node
+func (p *Policy) checkServing(csr *certificatesv1.CertificateSigningRequest, nodeName string, req *x509.CertificateRequest) error {
+	if csr.Spec.Username != nodeUserPrefix+nodeName {
+		return fmt.Errorf("requester %q may not request a serving certificate for node %q", csr.Spec.Username, nodeName)
+	}
+	if len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return fmt.Errorf("serving certificate requests may only have DNS and IP subject alternative names")
+	}
+	if len(req.DNSNames) == 0 && len(req.IPAddresses) == 0 {
+		return fmt.Errorf("serving certificate request has no subject alternative names")
+	}
+	for _, name := range req.DNSNames {
+		if name != nodeName {
+			return fmt.Errorf("DNS name %q is not the node name %q", name, nodeName)
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if !p.ipAllowed(ip) {
+			return fmt.Errorf("IP address %s is not in an allowed range", ip)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) ipAllowed(ip net.IP) bool {
+	if len(p.AllowedCIDRs) == 0 {
+		return true
+	}
+	for _, cidr := range p.AllowedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkUsages(signer string, usages []certificatesv1.KeyUsage) error {
+	allowed := allowedUsages[signer]
+	for _, u := range usages {
+		if !slices.Contains(allowed, u) {
+			return fmt.Errorf("usage %q is not allowed for signer %s", u, signer)
+		}
+	}
+	if len(usages) == 0 {
+		return fmt.Errorf("request has no usages")
+	}
+	return nil
+}
+
+// parseRequest decodes the PEM encoded x509 certificate request in a CSR
+func parseRequest(data []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("request is not a PEM encoded certificate request")
+	}
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing certificate request: %w", err)
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate request signature is invalid: %w", err)
+	}
+	return req, nil
+}
+
+// subjectNodeName returns the node a request is for, which kubelet
+// certificates carry as a common name of system:node:<name> in the
+// system:nodes organization
+func subjectNodeName(req *x509.CertificateRequest) (string, error) {
+	orgs := req.Subject.Organization
+	if len(orgs) != 1 || orgs[0] != nodesGroup {
+		return "", fmt.Errorf("organization must be %q, got %v", nodesGroup, req.Subject.Organization)
+	}
+	cn := req.Subject.CommonName
+	if !strings.HasPrefix(cn, nodeUserPrefix) || len(cn) == len(nodeUserPrefix) {
+		return "", fmt.Errorf("common name %q is not of the form %s<node name>", cn, nodeUserPrefix)
+	}
+	return strings.TrimPrefix(cn, nodeUserPrefix), nil
+}
@@ -0,0 +1,142 @@
+package csrapprover
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testCSR builds a CSR shaped like the ones krustlet sends
+func testCSR(t *testing.T, signer, username string, groups []string, tmpl x509.CertificateRequest) *certificatesv1.CertificateSigningRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &tmpl, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificatesv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "csr-" + strings.ReplaceAll(username, ":", "-")},
+		Spec: certificatesv1.CertificateSigningRequestSpec{
+			Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}),
+			SignerName: signer,
+			Usages:     allowedUsages[signer],
+			Username:   username,
+			Groups:     groups,
+		},
+	}
+}
+
+func nodeSubject(name string) pkix.Name {
+	return pkix.Name{CommonName: "system:node:" + name, Organization: []string{"system:nodes"}}
+}
+
+func clientCSR(t *testing.T, node string) *certificatesv1.CertificateSigningRequest {
+	return testCSR(t, certificatesv1.KubeAPIServerClientKubeletSignerName, "system:bootstrap:abcdef",
+		[]string{"system:bootstrappers", "system:bootstrappers:kubeadm:default-node-token", "system:authenticated"},
+		x509.CertificateRequest{Subject: nodeSubject(node)})
+}
+
+func servingCSR(t *testing.T, node string, ips ...string) *certificatesv1.CertificateSigningRequest {
+	tmpl := x509.CertificateRequest{Subject: nodeSubject(node), DNSNames: []string{node}}
+	for _, ip := range ips {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(ip))
+	}
+	return testCSR(t, certificatesv1.KubeletServingSignerName, "system:node:"+node,
+		[]string{"system:nodes", "system:authenticated"}, tmpl)
+}
+
+func TestPolicyApproves(t *testing.T) {
+	policy := &Policy{ApproveClient: true, ApproveServing: true}
+	for name, csr := range map[string]*certificatesv1.CertificateSigningRequest{
+		"bootstrap client": clientCSR(t, "krustlet-wasi"),
+		"serving":          servingCSR(t, "krustlet-wasi", "10.0.0.4"),
+		"client renewal": testCSR(t, certificatesv1.KubeAPIServerClientKubeletSignerName, "system:node:krustlet-wasi",
+			[]string{"system:nodes"}, x509.CertificateRequest{Subject: nodeSubject("krustlet-wasi")}),
+	} {
+		if err := policy.Evaluate(csr); err != nil {
+			t.Errorf("%s: expected approval, got %v", name, err)
+		}
+	}
+}
+
+func TestPolicyRefuses(t *testing.T) {
+	policy := &Policy{
+		ApproveClient:   true,
+		ApproveServing:  true,
+		NodeNamePattern: regexp.MustCompile(`^krustlet-`),
+		AllowedCIDRs:    []*net.IPNet{mustCIDR(t, "10.0.0.0/8")},
+	}
+
+	extraUsage := clientCSR(t, "krustlet-wasi")
+	extraUsage.Spec.Usages = append(extraUsage.Spec.Usages, certificatesv1.UsageServerAuth)
+
+	otherSigner := clientCSR(t, "krustlet-wasi")
+	otherSigner.Spec.SignerName = certificatesv1.KubeAPIServerClientSignerName
+
+	wrongNode := servingCSR(t, "krustlet-wasi")
+	wrongNode.Spec.Username = "system:node:krustlet-other"
+
+	notNode := testCSR(t, certificatesv1.KubeAPIServerClientKubeletSignerName, "system:bootstrap:abcdef",
+		[]string{"system:bootstrappers"}, x509.CertificateRequest{Subject: pkix.Name{CommonName: "admin", Organization: []string{"system:masters"}}})
+
+	notBootstrapper := clientCSR(t, "krustlet-wasi")
+	notBootstrapper.Spec.Username = "jane"
+	notBootstrapper.Spec.Groups = []string{"system:authenticated"}
+
+	otherDNS := testCSR(t, certificatesv1.KubeletServingSignerName, "system:node:krustlet-wasi", nil,
+		x509.CertificateRequest{Subject: nodeSubject("krustlet-wasi"), DNSNames: []string{"kubernetes.default"}})
+
+	tests := map[string]struct {
+		csr  *certificatesv1.CertificateSigningRequest
+		want string
+	}{
+		"extra usage":       {extraUsage, "not allowed"},
+		"other signer":      {otherSigner, "not a kubelet signer"},
+		"name pattern":      {clientCSR(t, "worker-1"), "does not match"},
+		"wrong node":        {wrongNode, "may not request"},
+		"not a node":        {notNode, "organization"},
+		"not bootstrapper":  {notBootstrapper, "neither a bootstrap token"},
+		"IP outside range":  {servingCSR(t, "krustlet-wasi", "192.168.1.1"), "not in an allowed range"},
+		"other DNS name":    {otherDNS, "is not the node name"},
+		"client with SANs":  {testCSR(t, certificatesv1.KubeAPIServerClientKubeletSignerName, "system:bootstrap:abcdef", []string{"system:bootstrappers"}, x509.CertificateRequest{Subject: nodeSubject("krustlet-wasi"), DNSNames: []string{"krustlet-wasi"}}), "subject alternative names"},
+		"serving no SANs":   {testCSR(t, certificatesv1.KubeletServingSignerName, "system:node:krustlet-wasi", nil, x509.CertificateRequest{Subject: nodeSubject("krustlet-wasi")}), "no subject alternative names"},
+		"corrupted request": {&certificatesv1.CertificateSigningRequest{Spec: certificatesv1.CertificateSigningRequestSpec{SignerName: certificatesv1.KubeletServingSignerName, Usages: allowedUsages[certificatesv1.KubeletServingSignerName], Request: []byte("junk")}}, "PEM"},
+	}
+	for name, tt := range tests {
+		err := policy.Evaluate(tt.csr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, tt.want, err)
+		}
+	}
+}
+
+func TestPolicyDisabled(t *testing.T) {
+	if err := (&Policy{ApproveClient: true}).Evaluate(servingCSR(t, "krustlet-wasi")); err == nil {
+		t.Error("expected serving CSRs to be refused when serving approval is disabled")
+	}
+	if err := (&Policy{ApproveServing: true}).Evaluate(clientCSR(t, "krustlet-wasi")); err == nil {
+		t.Error("expected client CSRs to be refused when client approval is disabled")
+	}
+}
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, cidr, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cidr
+}
@@ -0,0 +1,306 @@
+// Package execbridge serves the kubelet's exec and attach APIs for krustlet
+// nodes, so kubectl exec and kubectl attach work against wasm pods.
+//
+// A wasm module has no shell or other programs beside it to run, so exec
+// runs a debug module instead: a module the node is configured with, such as
+// a shell compiled to WASI, chosen by the command's name. It is run with the
+// container's environment and directories, so it sees the container's files
+// the way the container does. Attach follows the container's output;
+// krustlet doesn't give modules a stdin to write to.
+//
+// The streaming protocols, SPDY and WebSocket, are the kubelet's own.
+package execbridge
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientremotecommand "k8s.io/client-go/tools/remotecommand"
+	"k8s.io/klog/v2"
+	kubeletremotecommand "k8s.io/kubelet/pkg/cri/streaming/remotecommand"
+	utilexec "k8s.io/utils/exec"
+
+	"github.com/krustlet/krustlet/pkg/statsshim"
+)
+
+// The kubelet's default timeouts
+const (
+	// DefaultIdleTimeout is how long a session may go without traffic
+	DefaultIdleTimeout = 4 * time.Hour
+	// DefaultStreamCreationTimeout is how long the client may take to open
+	// the session's streams
+	DefaultStreamCreationTimeout = 30 * time.Second
+)
+
+// commandNotFound is the exit code shells use for a command that doesn't
+// exist
+const commandNotFound = 127
+
+// Options configure a Handler
+type Options struct {
+	// StatsDir is where the wasi provider writes the stats files of running
+	// modules
+	StatsDir string
+	// Modules are the debug modules exec may run, by command name
+	Modules map[string]string
+}
+
+// Handler serves /exec/{namespace}/{pod}/{container} and
+// /attach/{namespace}/{pod}/{container}, and the same with the pod's UID
+// before the container
+type Handler struct {
+	pods     corelisters.PodLister
+	statsDir string
+	modules  map[string]string
+	cache    wazero.CompilationCache
+	// IdleTimeout and StreamCreationTimeout default to the kubelet's
+	IdleTimeout           time.Duration
+	StreamCreationTimeout time.Duration
+	// PollInterval is how often attach checks for output
+	PollInterval time.Duration
+}
+
+// New returns a handler that finds pods with the informer factory, which
+// should only list pods on the node. The factory must be started by the
+// caller.
+func New(factory informers.SharedInformerFactory, opts Options) *Handler {
+	return &Handler{
+		pods:                  factory.Core().V1().Pods().Lister(),
+		statsDir:              opts.StatsDir,
+		modules:               opts.Modules,
+		cache:                 wazero.NewCompilationCache(),
+		IdleTimeout:           DefaultIdleTimeout,
+		StreamCreationTimeout: DefaultStreamCreationTimeout,
+		PollInterval:          250 * time.Millisecond,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if endpoint != "exec" && endpoint != "attach" {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) < 3 || len(parts) > 4 || slices.Contains(parts, "") {
+		http.NotFound(w, r)
+		return
+	}
+	namespace, name, container := parts[0], parts[1], parts[len(parts)-1]
+	pod, err := h.pods.Pods(namespace).Get(name)
+	if err != nil || (len(parts) == 4 && string(pod.UID) != parts[2]) {
+		http.Error(w, fmt.Sprintf("pod %s/%s not found", namespace, name), http.StatusNotFound)
+		return
+	}
+	m, ok := h.running(namespace, name, container)
+	if !ok {
+		http.Error(w, fmt.Sprintf("container %s is not running in pod %s/%s", container, namespace, name), http.StatusBadRequest)
+		return
+	}
+	opts, err := kubeletremotecommand.NewOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s := &session{handler: h, module: m}
+	if endpoint == "attach" {
+		klog.V(2).InfoS("Attaching to container", "pod", klog.KObj(pod), "container", container)
+		kubeletremotecommand.ServeAttach(w, r, s, pod.Name, pod.UID, container, opts,
+			h.IdleTimeout, h.StreamCreationTimeout, remotecommand.SupportedStreamingProtocols)
+		return
+	}
+	cmd := r.URL.Query()["command"]
+	klog.V(2).InfoS("Executing in container", "pod", klog.KObj(pod), "container", container, "command", cmd)
+	kubeletremotecommand.ServeExec(w, r, s, pod.Name, pod.UID, container, cmd, opts,
+		h.IdleTimeout, h.StreamCreationTimeout, remotecommand.SupportedStreamingProtocols)
+}
+
+// running returns the container's module if it is running
+func (h *Handler) running(namespace, pod, container string) (statsshim.Module, bool) {
+	modules, err := statsshim.ReadModules(h.statsDir)
+	if err != nil {
+		klog.ErrorS(err, "Reading stats files", "dir", h.statsDir)
+		return statsshim.Module{}, false
+	}
+	for _, m := range modules {
+		if m.Namespace == namespace && m.Pod == pod && m.Container == container {
+			return m, true
+		}
+	}
+	return statsshim.Module{}, false
+}
+
+// modulePath returns the debug module for the command: the one named by the
+// command, or by its base name, so sh and /bin/sh both find a module named sh
+func (h *Handler) modulePath(command string) (string, bool) {
+	if p, ok := h.modules[command]; ok {
+		return p, true
+	}
+	p, ok := h.modules[path.Base(command)]
+	return p, ok
+}
+
+// session is an exec or attach session with one container
+type session struct {
+	handler *Handler
+	module  statsshim.Module
+}
+
+// ExecInContainer implements the kubelet's Executor by running the debug
+// module the command names. The module's exit code is the command's.
+func (s *session) ExecInContainer(ctx context.Context, _ string, _ types.UID, _ string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, _ <-chan clientremotecommand.TerminalSize, _ time.Duration) error {
+	if len(cmd) == 0 {
+		return errors.New("no command given")
+	}
+	if tty && errOut == nil {
+		// With a TTY, errors are written to the terminal
+		errOut = out
+	}
+	modulePath, ok := s.handler.modulePath(cmd[0])
+	if !ok {
+		err := fmt.Errorf("%s: command not found; %s", cmd[0], s.handler.available())
+		if errOut != nil {
+			fmt.Fprintln(errOut, err)
+		}
+		return utilexec.CodeExitError{Err: err, Code: commandNotFound}
+	}
+	wasm, err := os.ReadFile(modulePath)
+	if err != nil {
+		return fmt.Errorf("reading debug module %s: %w", cmd[0], err)
+	}
+
+	// Closing the runtime when the context is done stops a module that
+	// doesn't exit on its own
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(s.handler.cache).
+		WithCloseOnContextDone(true))
+	defer rt.Close(context.Background())
+	wasi_snapshot_preview1.MustInstantiate(ctx, rt)
+	compiled, err := rt.CompileModule(ctx, wasm)
+	if err != nil {
+		return fmt.Errorf("compiling debug module %s: %w", cmd[0], err)
+	}
+
+	fsConfig := wazero.NewFSConfig()
+	for _, d := range s.module.Dirs {
+		fsConfig = fsConfig.WithDirMount(d.HostPath, d.GuestPath)
+	}
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(cmd...).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+	for k, v := range s.module.Env {
+		config = config.WithEnv(k, v)
+	}
+	if in != nil {
+		config = config.WithStdin(in)
+	}
+	if out != nil {
+		config = config.WithStdout(out)
+	}
+	if errOut != nil {
+		config = config.WithStderr(errOut)
+	}
+
+	mod, err := rt.InstantiateModule(ctx, compiled, config)
+	if mod != nil {
+		_ = mod.Close(context.Background())
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		if exitErr.ExitCode() == 0 {
+			return nil
+		}
+		return utilexec.CodeExitError{Err: err, Code: int(exitErr.ExitCode())}
+	}
+	return err
+}
+
+// available describes the debug modules exec can run
+func (h *Handler) available() string {
+	if len(h.modules) == 0 {
+		return "this node has no debug modules to exec"
+	}
+	names := make([]string, 0, len(h.modules))
+	for name := range h.modules {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return "the debug modules on this node are " + strings.Join(names, ", ")
+}
+
+// AttachContainer implements the kubelet's Attacher by following the
+// container's output until the container exits or the client goes away.
+// Output written before the client attached isn't sent; kubectl logs shows
+// it.
+func (s *session) AttachContainer(ctx context.Context, _ string, _ types.UID, _ string, in io.Reader, out, errOut io.WriteCloser, _ bool, _ <-chan clientremotecommand.TerminalSize) error {
+	if out == nil {
+		// The module's stdout and stderr are written to the same log
+		out = errOut
+	}
+	if out == nil {
+		return nil
+	}
+	f, err := os.Open(s.module.LogFile)
+	if err != nil {
+		return fmt.Errorf("opening container log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	// There's nothing to send input to, so it is discarded. A client that
+	// closes stdin, or goes away, ends the session: the session's context
+	// isn't done when an upgraded connection closes, so this is how a
+	// client leaving is noticed while the container is quiet.
+	stdinClosed := make(chan struct{})
+	if in != nil {
+		go func() {
+			_, _ = io.Copy(io.Discard, in)
+			close(stdinClosed)
+		}()
+	}
+
+	ticker := time.NewTicker(s.handler.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := io.Copy(out, f); err != nil {
+			// The client went away
+			return nil
+		}
+		if _, err := os.Stat(s.module.StatsFile); errors.Is(err, os.ErrNotExist) {
+			// The module exited; send whatever it wrote last
+			_, _ = io.Copy(out, f)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stdinClosed:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package execbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// section encodes a module section; the tests' sections are all shorter
+// than 128 bytes, so their sizes fit in a byte
+func section(id byte, content ...byte) []byte {
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+func name(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+// helloModule is a WASI command that writes "hello\n" to stdout and exits
+// with 3
+func helloModule() []byte {
+	wasi := name("wasi_snapshot_preview1")
+	var imports []byte
+	imports = append(imports, 2)
+	imports = append(imports, wasi...)
+	imports = append(append(imports, name("fd_write")...), 0x00, 0)
+	imports = append(imports, wasi...)
+	imports = append(append(imports, name("proc_exit")...), 0x00, 1)
+
+	var exports []byte
+	exports = append(exports, 2)
+	exports = append(append(exports, name("memory")...), 0x02, 0)
+	exports = append(append(exports, name("_start")...), 0x00, 2)
+
+	body := []byte{
+		0x00,                                // no locals
+		0x41, 1, 0x41, 0, 0x41, 1, 0x41, 20, // fd_write(stdout, iovs, 1, &written)
+		0x10, 0, 0x1a,
+		0x41, 3, 0x10, 1, // proc_exit(3)
+		0x0b,
+	}
+	data := []byte{1, 0x00, 0x41, 0, 0x0b, 14, 8, 0, 0, 0, 6, 0, 0, 0}
+	data = append(data, "hello\n"...)
+
+	var m []byte
+	m = append(m, 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00)
+	m = append(m, section(1, 3,
+		0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f,
+		0x60, 1, 0x7f, 0,
+		0x60, 0, 0)...)
+	m = append(m, section(2, imports...)...)
+	m = append(m, section(3, 1, 2)...)
+	m = append(m, section(5, 1, 0x00, 1)...)
+	m = append(m, section(7, exports...)...)
+	m = append(m, section(10, append([]byte{1, byte(len(body))}, body...)...)...)
+	m = append(m, section(11, data...)...)
+	return m
+}
+
+type testNode struct {
+	srv      *httptest.Server
+	logFile  string
+	statsDir string
+}
+
+func newTestNode(t *testing.T) *testNode {
+	t.Helper()
+	dir := t.TempDir()
+	statsDir := filepath.Join(dir, "wasi-logs")
+	if err := os.Mkdir(statsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	logFile := filepath.Join(statsDir, "hello_default_hello-abc.log")
+	if err := os.WriteFile(logFile, []byte("before attaching\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := json.Marshal(map[string]interface{}{
+		"namespace": "default",
+		"pod":       "hello",
+		"container": "hello",
+		"pid":       os.Getpid(),
+		"tid":       os.Getpid(),
+		"startTime": time.Now(),
+		"env":       map[string]string{"GREETING": "hi"},
+	})
+	if err := os.WriteFile(filepath.Join(statsDir, "hello_default_hello-abc.stats.json"), stats, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	module := filepath.Join(dir, "hello.wasm")
+	if err := os.WriteFile(module, helloModule(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "uid-1"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "hello"}}},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if err := factory.Core().V1().Pods().Informer().GetIndexer().Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	h := New(factory, Options{StatsDir: statsDir, Modules: map[string]string{"hello": module}})
+	h.PollInterval = 10 * time.Millisecond
+	mux := http.NewServeMux()
+	mux.Handle("/exec/", h)
+	mux.Handle("/attach/", h)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return &testNode{srv: srv, logFile: logFile, statsDir: statsDir}
+}
+
+func (n *testNode) stream(ctx context.Context, path string, query url.Values, opts remotecommand.StreamOptions) error {
+	u, _ := url.Parse(n.srv.URL + path)
+	u.RawQuery = query.Encode()
+	executor, err := remotecommand.NewSPDYExecutor(&rest.Config{Host: n.srv.URL}, http.MethodPost, u)
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, opts)
+}
+
+func TestExec(t *testing.T) {
+	n := newTestNode(t)
+	var stdout, stderr bytes.Buffer
+	err := n.stream(context.Background(), "/exec/default/hello/hello",
+		url.Values{corev1.ExecCommandParam: {"/bin/hello", "world"}, corev1.ExecStdoutParam: {"1"}, corev1.ExecStderrParam: {"1"}},
+		remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	var exitErr exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Fatalf("err = %v, want exit code 3", err)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "hello\n")
+	}
+}
+
+func TestExecCommandNotFound(t *testing.T) {
+	n := newTestNode(t)
+	var stdout, stderr bytes.Buffer
+	err := n.stream(context.Background(), "/exec/default/hello/hello",
+		url.Values{corev1.ExecCommandParam: {"sh"}, corev1.ExecStdoutParam: {"1"}, corev1.ExecStderrParam: {"1"}},
+		remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	var exitErr exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 127 {
+		t.Fatalf("err = %v, want exit code 127", err)
+	}
+	if !strings.Contains(stderr.String(), "sh: command not found") || !strings.Contains(stderr.String(), "hello") {
+		t.Errorf("stderr = %q, want the command and the available modules", stderr.String())
+	}
+}
+
+func TestExecContainerNotRunning(t *testing.T) {
+	n := newTestNode(t)
+	err := n.stream(context.Background(), "/exec/default/hello/other",
+		url.Values{corev1.ExecCommandParam: {"hello"}, corev1.ExecStdoutParam: {"1"}},
+		remotecommand.StreamOptions{Stdout: &bytes.Buffer{}})
+	if err == nil {
+		t.Fatal("exec into a container that isn't running succeeded")
+	}
+}
+
+// syncBuffer is written by the stream while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAttach(t *testing.T) {
+	n := newTestNode(t)
+	out := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() {
+		done <- n.stream(context.Background(), "/attach/default/hello/uid-1/hello",
+			url.Values{corev1.ExecStdoutParam: {"1"}, corev1.ExecStderrParam: {"1"}},
+			remotecommand.StreamOptions{Stdout: out, Stderr: out})
+	}()
+
+	f, err := os.OpenFile(n.logFile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The session only sees output written once it is attached
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(out.String(), "while attached") {
+		if time.Now().After(deadline) {
+			t.Fatalf("output = %q, want the lines written while attached", out.String())
+		}
+		_, _ = f.WriteString("while attached\n")
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The session ends when the module exits
+	if err := os.Remove(filepath.Join(n.statsDir, "hello_default_hello-abc.stats.json")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("attach didn't end when the module exited")
+	}
+	if strings.Contains(out.String(), "before attaching") {
+		t.Errorf("output = %q, want only what was written while attached", out.String())
+	}
+}
@@ -0,0 +1,193 @@
+// Package exporter exposes Prometheus metrics about krustlet nodes and the
+// pods running on them.
+//
+// Krustlet has no metrics endpoint of its own, so nearly everything is derived
+// from outside: node and pod objects from the API server, and the health
+// endpoint and serving certificate of each node's API. The one exception is
+// the module cache, whose hits and misses the node API serves.
+package exporter
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// DefaultArch is the architecture label of krustlet's wasi provider nodes
+const DefaultArch = "wasm32-wasi"
+
+const archLabel = "kubernetes.io/arch"
+
+var (
+	nodeInfoDesc = prometheus.NewDesc("krustlet_node_info",
+		"Information about a krustlet node. Always 1.",
+		[]string{"node", "kubelet_version", "architecture"}, nil)
+	nodeReadyDesc = prometheus.NewDesc("krustlet_node_ready",
+		"Whether the node's Ready condition is true.",
+		[]string{"node"}, nil)
+	nodeAPIUpDesc = prometheus.NewDesc("krustlet_node_api_up",
+		"Whether the node API's health endpoint answered at the last probe.",
+		[]string{"node"}, nil)
+	certExpiryDesc = prometheus.NewDesc("krustlet_node_serving_certificate_expiration_timestamp_seconds",
+		"When the serving certificate of the node API expires, as a Unix timestamp.",
+		[]string{"node"}, nil)
+	moduleCacheHitsDesc = prometheus.NewDesc("krustlet_module_cache_hits_total",
+		"Modules the node served from its cache without pulling, since krustlet started.",
+		[]string{"node"}, nil)
+	moduleCacheMissesDesc = prometheus.NewDesc("krustlet_module_cache_misses_total",
+		"Modules the node had to pull, since krustlet started.",
+		[]string{"node"}, nil)
+	podsDesc = prometheus.NewDesc("krustlet_pods",
+		"Number of pods on the node by phase.",
+		[]string{"node", "phase"}, nil)
+)
+
+// Exporter is a prometheus.Collector for krustlet nodes, which it tells apart
+// from other nodes by their architecture label
+type Exporter struct {
+	arch    string
+	nodes   corelisters.NodeLister
+	pods    corelisters.PodLister
+	prober  *prober
+	timings *podTimings
+}
+
+// New returns an exporter that reads nodes and pods from the factory's
+// informers. The factory must be started by the caller.
+func New(factory informers.SharedInformerFactory, arch string) *Exporter {
+	e := &Exporter{
+		arch:    arch,
+		nodes:   factory.Core().V1().Nodes().Lister(),
+		pods:    factory.Core().V1().Pods().Lister(),
+		prober:  newProber(),
+		timings: newPodTimings(time.Now),
+	}
+	_, _ = factory.Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, initial bool) {
+			e.observePod(obj, initial)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			e.observePod(obj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				e.timings.forget(pod.UID)
+			}
+		},
+	})
+	return e
+}
+
+func (e *Exporter) observePod(obj interface{}, initial bool) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || !e.isKrustletNode(pod.Spec.NodeName) {
+		return
+	}
+	e.timings.observe(pod, initial)
+}
+
+func (e *Exporter) isKrustletNode(name string) bool {
+	if name == "" {
+		return false
+	}
+	node, err := e.nodes.Get(name)
+	return err == nil && node.Labels[archLabel] == e.arch
+}
+
+func (e *Exporter) krustletNodes() []*corev1.Node {
+	nodes, err := e.nodes.List(labels.SelectorFromSet(labels.Set{archLabel: e.arch}))
+	if err != nil {
+		klog.ErrorS(err, "Listing nodes")
+	}
+	return nodes
+}
+
+// RunProbes probes every krustlet node's API each interval until the context
+// is done. A node that doesn't answer within timeout is reported as down.
+func (e *Exporter) RunProbes(ctx context.Context, interval, timeout time.Duration) {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		e.prober.probeAll(ctx, e.krustletNodes())
+	}, interval)
+}
+
+// Describe implements prometheus.Collector
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeInfoDesc
+	ch <- nodeReadyDesc
+	ch <- nodeAPIUpDesc
+	ch <- certExpiryDesc
+	ch <- moduleCacheHitsDesc
+	ch <- moduleCacheMissesDesc
+	ch <- podsDesc
+	e.timings.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	nodes := e.krustletNodes()
+	for _, node := range nodes {
+		info := node.Status.NodeInfo
+		ch <- prometheus.MustNewConstMetric(nodeInfoDesc, prometheus.GaugeValue, 1, node.Name, info.KubeletVersion, info.Architecture)
+		ch <- prometheus.MustNewConstMetric(nodeReadyDesc, prometheus.GaugeValue, boolValue(nodeReady(node)), node.Name)
+		if r, ok := e.prober.result(node.Name); ok {
+			ch <- prometheus.MustNewConstMetric(nodeAPIUpDesc, prometheus.GaugeValue, boolValue(r.up), node.Name)
+			if !r.certExpiry.IsZero() {
+				ch <- prometheus.MustNewConstMetric(certExpiryDesc, prometheus.GaugeValue, float64(r.certExpiry.Unix()), node.Name)
+			}
+			if c := r.moduleCache; c != nil {
+				ch <- prometheus.MustNewConstMetric(moduleCacheHitsDesc, prometheus.CounterValue, float64(c.Hits), node.Name)
+				ch <- prometheus.MustNewConstMetric(moduleCacheMissesDesc, prometheus.CounterValue, float64(c.Misses), node.Name)
+			}
+		}
+	}
+
+	counts := map[string]map[corev1.PodPhase]int{}
+	for _, node := range nodes {
+		counts[node.Name] = map[corev1.PodPhase]int{}
+	}
+	pods, err := e.pods.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Listing pods")
+	}
+	for _, pod := range pods {
+		if byPhase, ok := counts[pod.Spec.NodeName]; ok {
+			byPhase[pod.Status.Phase]++
+		}
+	}
+	for node, byPhase := range counts {
+		for _, phase := range []corev1.PodPhase{corev1.PodPending, corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed, corev1.PodUnknown} {
+			ch <- prometheus.MustNewConstMetric(podsDesc, prometheus.GaugeValue, float64(byPhase[phase]), node, string(phase))
+		}
+	}
+
+	e.timings.Collect(ch)
+}
+
+func nodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
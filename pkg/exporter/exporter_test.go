@@ -0,0 +1,275 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var epoch = time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func testPod(reason string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "hello",
+			Namespace:         "default",
+			UID:               types.UID("uid-1"),
+			CreationTimestamp: metav1.NewTime(epoch),
+		},
+		Spec:   corev1.PodSpec{NodeName: "krustlet-wasi"},
+		Status: corev1.PodStatus{Phase: corev1.PodPending, Reason: reason},
+	}
+}
+
+func running(pod *corev1.Pod, at time.Time) *corev1.Pod {
+	pod = pod.DeepCopy()
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.Reason = "Running"
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "hello",
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(at)}},
+	}}
+	return pod
+}
+
+func TestPullAndStartDuration(t *testing.T) {
+	clock := &fakeClock{t: epoch}
+	p := newPodTimings(clock.now)
+
+	clock.advance(time.Second)
+	p.observe(testPod("Registered"), false)
+	p.observe(testPod(reasonImagePull), false)
+	clock.advance(3 * time.Second)
+	p.observe(testPod("VolumeMount"), false)
+	p.observe(running(testPod("Running"), epoch.Add(5*time.Second)), false)
+	// Later updates don't count the start again
+	p.observe(running(testPod("Running"), epoch.Add(5*time.Second)), false)
+
+	expected := `
+# HELP krustlet_module_pull_duration_seconds Time a pod spent pulling its modules, as observed through pod status updates.
+# TYPE krustlet_module_pull_duration_seconds histogram
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="0.1"} 0
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="0.25"} 0
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="0.5"} 0
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="1"} 0
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="2"} 0
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="5"} 1
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="10"} 1
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="30"} 1
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="60"} 1
+krustlet_module_pull_duration_seconds_bucket{node="krustlet-wasi",result="success",le="+Inf"} 1
+krustlet_module_pull_duration_seconds_sum{node="krustlet-wasi",result="success"} 3
+krustlet_module_pull_duration_seconds_count{node="krustlet-wasi",result="success"} 1
+# HELP krustlet_pod_start_duration_seconds Time from a pod being created to its first container starting on a krustlet node.
+# TYPE krustlet_pod_start_duration_seconds histogram
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="0.5"} 0
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="1"} 0
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="2"} 0
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="5"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="10"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="20"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="30"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="60"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="120"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="300"} 1
+krustlet_pod_start_duration_seconds_bucket{node="krustlet-wasi",le="+Inf"} 1
+krustlet_pod_start_duration_seconds_sum{node="krustlet-wasi"} 5
+krustlet_pod_start_duration_seconds_count{node="krustlet-wasi"} 1
+`
+	if err := testutil.CollectAndCompare(p, strings.NewReader(expected),
+		"krustlet_module_pull_duration_seconds", "krustlet_pod_start_duration_seconds"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPullBackoff(t *testing.T) {
+	clock := &fakeClock{t: epoch}
+	p := newPodTimings(clock.now)
+
+	p.observe(testPod(reasonImagePull), false)
+	clock.advance(2 * time.Second)
+	p.observe(testPod(reasonImagePullBackoff), false)
+	p.observe(testPod(reasonImagePull), false)
+	clock.advance(time.Second)
+	p.observe(testPod(reasonImagePullBackoff), false)
+
+	if got := testutil.ToFloat64(p.pullBackoffs.WithLabelValues("krustlet-wasi")); got != 2 {
+		t.Errorf("expected 2 backoffs, got %v", got)
+	}
+	if got := testutil.CollectAndCount(p.pullDuration); got != 1 {
+		t.Errorf("expected one pull duration series, got %d", got)
+	}
+	if !p.pullDuration.DeleteLabelValues("krustlet-wasi", "failure") {
+		t.Error("expected the pulls to be recorded as failures")
+	}
+}
+
+func TestInitialPodsAreNotTimed(t *testing.T) {
+	clock := &fakeClock{t: epoch.Add(time.Minute)}
+	p := newPodTimings(clock.now)
+
+	// The pod was pulling and created before the exporter started, so neither
+	// its pull nor its start can be measured
+	p.observe(testPod(reasonImagePull), true)
+	clock.advance(time.Second)
+	p.observe(running(testPod("Running"), epoch.Add(70*time.Second)), false)
+
+	if got := testutil.CollectAndCount(p); got != 0 {
+		t.Errorf("expected no observations, got %d series", got)
+	}
+}
+
+func TestFirstContainerStart(t *testing.T) {
+	pod := testPod("Completed")
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{FinishedAt: metav1.NewTime(epoch.Add(4 * time.Second))}}},
+		{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(epoch.Add(6 * time.Second))}}},
+		{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{}}},
+	}
+	got, ok := firstContainerStart(pod)
+	if !ok || !got.Equal(epoch.Add(4*time.Second)) {
+		t.Errorf("got %v, %v", got, ok)
+	}
+	if _, ok := firstContainerStart(testPod("Registered")); ok {
+		t.Error("expected no start for a pod without container statuses")
+	}
+}
+
+func testNode(name, arch, addr string, port int32) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{archLabel: arch}},
+		Status: corev1.NodeStatus{
+			NodeInfo:        corev1.NodeSystemInfo{KubeletVersion: "0.8.0", Architecture: "wasm-wasi"},
+			Conditions:      []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			Addresses:       []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: addr}},
+			DaemonEndpoints: corev1.NodeDaemonEndpoints{KubeletEndpoint: corev1.DaemonEndpoint{Port: port}},
+		},
+	}
+}
+
+func TestCollect(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			_, _ = w.Write([]byte("ok"))
+		case "/stats/modulecache":
+			_, _ = w.Write([]byte(`{"hits":7,"misses":2}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	host, portStr, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	krustlet := testNode("krustlet-wasi", DefaultArch, host, int32(port))
+	other := testNode("linux", "amd64", "10.0.0.5", 10250)
+	pod := running(testPod("Running"), epoch)
+	otherPod := testPod("")
+	otherPod.Name, otherPod.UID, otherPod.Spec.NodeName = "nginx", "uid-2", "linux"
+
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	e := New(factory, DefaultArch)
+	for _, obj := range []interface{}{krustlet, other} {
+		if err := factory.Core().V1().Nodes().Informer().GetIndexer().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, obj := range []interface{}{pod, otherPod} {
+		if err := factory.Core().V1().Pods().Informer().GetIndexer().Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.prober.probeAll(context.Background(), e.krustletNodes())
+
+	expiry := srv.Certificate().NotAfter.Unix()
+	expected := `
+# HELP krustlet_module_cache_hits_total Modules the node served from its cache without pulling, since krustlet started.
+# TYPE krustlet_module_cache_hits_total counter
+krustlet_module_cache_hits_total{node="krustlet-wasi"} 7
+# HELP krustlet_module_cache_misses_total Modules the node had to pull, since krustlet started.
+# TYPE krustlet_module_cache_misses_total counter
+krustlet_module_cache_misses_total{node="krustlet-wasi"} 2
+# HELP krustlet_node_api_up Whether the node API's health endpoint answered at the last probe.
+# TYPE krustlet_node_api_up gauge
+krustlet_node_api_up{node="krustlet-wasi"} 1
+# HELP krustlet_node_info Information about a krustlet node. Always 1.
+# TYPE krustlet_node_info gauge
+krustlet_node_info{architecture="wasm-wasi",kubelet_version="0.8.0",node="krustlet-wasi"} 1
+# HELP krustlet_node_ready Whether the node's Ready condition is true.
+# TYPE krustlet_node_ready gauge
+krustlet_node_ready{node="krustlet-wasi"} 1
+# HELP krustlet_node_serving_certificate_expiration_timestamp_seconds When the serving certificate of the node API expires, as a Unix timestamp.
+# TYPE krustlet_node_serving_certificate_expiration_timestamp_seconds gauge
+krustlet_node_serving_certificate_expiration_timestamp_seconds{node="krustlet-wasi"} ` + strconv.FormatInt(expiry, 10) + `
+# HELP krustlet_pods Number of pods on the node by phase.
+# TYPE krustlet_pods gauge
+krustlet_pods{node="krustlet-wasi",phase="Failed"} 0
+krustlet_pods{node="krustlet-wasi",phase="Pending"} 0
+krustlet_pods{node="krustlet-wasi",phase="Running"} 1
+krustlet_pods{node="krustlet-wasi",phase="Succeeded"} 0
+krustlet_pods{node="krustlet-wasi",phase="Unknown"} 0
+`
+	if err := testutil.CollectAndCompare(e, strings.NewReader(expected),
+		"krustlet_module_cache_hits_total", "krustlet_module_cache_misses_total", "krustlet_node_api_up", "krustlet_node_info", "krustlet_node_ready",
+		"krustlet_node_serving_certificate_expiration_timestamp_seconds", "krustlet_pods"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProbeDown(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	p := newProber()
+	r := p.probe(context.Background(), srv.Listener.Addr().String())
+	if r.up || r.certExpiry.IsZero() || r.moduleCache != nil {
+		t.Errorf("expected an unhealthy node with a certificate, got %+v", r)
+	}
+
+	srv.Close()
+	r = p.probe(context.Background(), srv.Listener.Addr().String())
+	if r.up || !r.certExpiry.IsZero() {
+		t.Errorf("expected an unreachable node, got %+v", r)
+	}
+}
+
+func TestProbeWithoutModuleCache(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	r := newProber().probe(context.Background(), srv.Listener.Addr().String())
+	if !r.up || r.moduleCache != nil {
+		t.Errorf("expected a healthy node without module cache stats, got %+v", r)
+	}
+}
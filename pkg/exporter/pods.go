@@ -0,0 +1,155 @@
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Reasons krustlet reports in the pod status while a pod moves through its
+// state machine. See crates/kubelet/src/state/common.
+const (
+	reasonImagePull        = "ImagePull"
+	reasonImagePullBackoff = "ImagePullBackoff"
+)
+
+// podTimings derives pod start and module pull durations from the pod
+// updates krustlet makes. Krustlet doesn't report when a pull starts or ends,
+// so pull durations are measured from when the exporter sees the pod enter
+// and leave the ImagePull state, and are only as precise as the watch.
+type podTimings struct {
+	now   func() time.Time
+	since time.Time
+
+	startDuration *prometheus.HistogramVec
+	pullDuration  *prometheus.HistogramVec
+	pullBackoffs  *prometheus.CounterVec
+
+	mu   sync.Mutex
+	pods map[types.UID]*podTiming
+}
+
+type podTiming struct {
+	// pullStarted is when the pod was seen entering ImagePull, zero when it
+	// isn't pulling or the start was missed
+	pullStarted time.Time
+	reason      string
+	started     bool
+}
+
+func newPodTimings(now func() time.Time) *podTimings {
+	return &podTimings{
+		now:   now,
+		since: now(),
+		startDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "krustlet_pod_start_duration_seconds",
+			Help:    "Time from a pod being created to its first container starting on a krustlet node.",
+			Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+		}, []string{"node"}),
+		pullDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "krustlet_module_pull_duration_seconds",
+			Help:    "Time a pod spent pulling its modules, as observed through pod status updates.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 30, 60},
+		}, []string{"node", "result"}),
+		pullBackoffs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "krustlet_module_pull_backoffs_total",
+			Help: "Number of times a pod went into ImagePullBackoff after a failed pull.",
+		}, []string{"node"}),
+		pods: map[types.UID]*podTiming{},
+	}
+}
+
+// observe records a pod update. initial is true for pods that already
+// existed when the exporter started, whose pulls can't be timed.
+func (p *podTimings) observe(pod *corev1.Pod, initial bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	node := pod.Spec.NodeName
+	t, ok := p.pods[pod.UID]
+	if !ok {
+		t = &podTiming{}
+		p.pods[pod.UID] = t
+		// A pod that was already pulling when the exporter started began at
+		// some unknown point, so its state is taken as is rather than timed
+		if initial {
+			t.reason = pod.Status.Reason
+		}
+	}
+
+	reason := pod.Status.Reason
+	if reason != t.reason {
+		now := p.now()
+		if t.reason == reasonImagePull && !t.pullStarted.IsZero() {
+			result := "success"
+			if reason == reasonImagePullBackoff {
+				result = "failure"
+			}
+			p.pullDuration.WithLabelValues(node, result).Observe(now.Sub(t.pullStarted).Seconds())
+		}
+		t.pullStarted = time.Time{}
+		if reason == reasonImagePull {
+			t.pullStarted = now
+		}
+		if reason == reasonImagePullBackoff {
+			p.pullBackoffs.WithLabelValues(node).Inc()
+		}
+		t.reason = reason
+	}
+
+	if !t.started {
+		if started, ok := firstContainerStart(pod); ok {
+			t.started = true
+			// Pods that were created before the exporter was would otherwise
+			// be counted again on every restart
+			created := pod.CreationTimestamp.Time
+			if !created.Before(p.since.Truncate(time.Second)) {
+				p.startDuration.WithLabelValues(node).Observe(started.Sub(created).Seconds())
+			}
+		}
+	}
+}
+
+// forget drops the state kept for a deleted pod
+func (p *podTimings) forget(uid types.UID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pods, uid)
+}
+
+// firstContainerStart returns when the earliest app container started.
+// Krustlet doesn't set startedAt on terminated containers, so a module that
+// exits before a running status is seen counts as starting when it finished.
+func firstContainerStart(pod *corev1.Pod) (time.Time, bool) {
+	var first time.Time
+	for _, s := range pod.Status.ContainerStatuses {
+		var started time.Time
+		switch {
+		case s.State.Running != nil:
+			started = s.State.Running.StartedAt.Time
+		case s.State.Terminated != nil:
+			started = s.State.Terminated.StartedAt.Time
+			if started.IsZero() {
+				started = s.State.Terminated.FinishedAt.Time
+			}
+		}
+		if !started.IsZero() && (first.IsZero() || started.Before(first)) {
+			first = started
+		}
+	}
+	return first, !first.IsZero()
+}
+
+func (p *podTimings) Describe(ch chan<- *prometheus.Desc) {
+	p.startDuration.Describe(ch)
+	p.pullDuration.Describe(ch)
+	p.pullBackoffs.Describe(ch)
+}
+
+func (p *podTimings) Collect(ch chan<- prometheus.Metric) {
+	p.startDuration.Collect(ch)
+	p.pullDuration.Collect(ch)
+	p.pullBackoffs.Collect(ch)
+}
@@ -0,0 +1,133 @@
+package exporter
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/krustlet/krustlet/pkg/nodeapi"
+)
+
+// probeResult is what probing a node's API found
+type probeResult struct {
+	up bool
+	// certExpiry is when the node's serving certificate expires, zero if the
+	// TLS handshake failed
+	certExpiry time.Time
+	// moduleCache is the node's module cache lookups, nil if the node doesn't
+	// report them
+	moduleCache *moduleCacheStats
+}
+
+// moduleCacheStats is what the node API serves on /stats/modulecache. Both
+// counts start from zero when krustlet restarts.
+type moduleCacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// prober checks each krustlet node's API, the server krustlet runs for logs
+// and exec, and records its serving certificate
+type prober struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	results map[string]probeResult
+}
+
+func newProber() *prober {
+	return &prober{
+		client: &http.Client{
+			Transport: &http.Transport{
+				// Only the certificate's expiry and the health and module
+				// cache endpoints are read, and node certificates are often signed by a CA the
+				// exporter doesn't have, so the certificate isn't verified
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+				DisableKeepAlives: true,
+			},
+		},
+		results: map[string]probeResult{},
+	}
+}
+
+// probeAll probes the nodes concurrently and replaces the stored results
+func (p *prober) probeAll(ctx context.Context, nodes []*corev1.Node) {
+	results := make(map[string]probeResult, len(nodes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		addr, ok := nodeapi.Address(node)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name, addr string) {
+			defer wg.Done()
+			r := p.probe(ctx, addr)
+			mu.Lock()
+			results[name] = r
+			mu.Unlock()
+		}(node.Name, addr)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	p.results = results
+	p.mu.Unlock()
+}
+
+func (p *prober) probe(ctx context.Context, addr string) probeResult {
+	var r probeResult
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+addr+"/healthz", nil)
+	if err != nil {
+		return r
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return r
+	}
+	defer resp.Body.Close()
+	r.up = resp.StatusCode == http.StatusOK
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		r.certExpiry = resp.TLS.PeerCertificates[0].NotAfter
+	}
+	if r.up {
+		r.moduleCache = p.moduleCacheStats(ctx, addr)
+	}
+	return r
+}
+
+// moduleCacheStats reads the node's module cache lookups. Older krustlets and
+// providers without a cache answer not found, so any failure is nil.
+func (p *prober) moduleCacheStats(ctx context.Context, addr string) *moduleCacheStats {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+addr+"/stats/modulecache", nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var stats moduleCacheStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil
+	}
+	return &stats
+}
+
+// result returns the last probe of the node
+func (p *prober) result(node string) (probeResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, ok := p.results[node]
+	return r, ok
+}
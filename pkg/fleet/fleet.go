@@ -0,0 +1,286 @@
+// Package fleet provisions krustlet on many hosts at once. Each host gets the
+// krustlet binary, a systemd unit and its own bootstrap token, and is done
+// once its node has registered and is ready.
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/bootstrap"
+	"github.com/krustlet/krustlet/pkg/nodename"
+)
+
+// Where krustlet is installed on each host, as contrib/azure does
+const (
+	BinaryPath = "/usr/local/bin/krustlet-wasi"
+	UnitPath   = "/etc/systemd/system/krustlet.service"
+	DataDir    = "/etc/krustlet"
+
+	configDir      = DataDir + "/config"
+	bootstrapPath  = configDir + "/bootstrap.conf"
+	kubeconfigPath = configDir + "/kubeconfig"
+	// user is the account krustlet runs as
+	user = "krustlet"
+)
+
+// Steps of provisioning a host, as reported in a failed Result
+const (
+	StepConnect   = "connect"
+	StepInstall   = "install"
+	StepBootstrap = "bootstrap"
+	StepStart     = "start"
+	StepRegister  = "register"
+)
+
+// Status is how provisioning a host ended
+type Status string
+
+const (
+	// StatusReady means the node registered and is ready
+	StatusReady Status = "Ready"
+	// StatusNotReady means krustlet was started but the node wasn't ready
+	// in time
+	StatusNotReady Status = "NotReady"
+	// StatusFailed means a step failed
+	StatusFailed Status = "Failed"
+)
+
+// Config is how hosts are provisioned
+type Config struct {
+	Client kubernetes.Interface
+	// Kubeconfig and Context are the cluster the nodes join, which their
+	// bootstrap kubeconfigs point at
+	Kubeconfig *clientcmdapi.Config
+	Context    string
+	// APIServerIP, if set, is used to find the address each host reaches
+	// the API server from, which its node registers with unless the target
+	// names one
+	APIServerIP string
+	Runner      Runner
+	// Binary is the krustlet binary installed on every host
+	Binary []byte
+	// TokenTTL is how long each host's bootstrap token is valid for
+	TokenTTL time.Duration
+	// Labels are added to every node, as key=value
+	Labels []string
+	// Timeout is how long to wait for each node to become ready
+	Timeout time.Duration
+	// Parallel is how many hosts are provisioned at once
+	Parallel int
+}
+
+// Result is how provisioning a host went
+type Result struct {
+	Target   Target
+	NodeName string
+	Status   Status
+	// Bootstrapped is whether the host was given a bootstrap token. Hosts
+	// that already have their credentials keep them.
+	Bootstrapped bool
+	// Step and Err say what failed
+	Step     string
+	Err      error
+	Duration time.Duration
+}
+
+// Provision provisions the targets, Parallel at a time, and returns their
+// results in the same order
+func Provision(ctx context.Context, cfg *Config, targets []Target) []Result {
+	parallel := cfg.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]Result, len(targets))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			start := time.Now()
+			results[i] = provision(ctx, cfg, t)
+			results[i].Duration = time.Since(start)
+			if results[i].Err != nil {
+				klog.ErrorS(results[i].Err, "Provisioning failed", "host", t.Host, "step", results[i].Step)
+			} else {
+				klog.InfoS("Provisioned", "host", t.Host, "node", results[i].NodeName, "status", results[i].Status)
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// host is what provisioning found out about a host
+type host struct {
+	hostname   string
+	registered bool
+	sourceIP   string
+}
+
+func provision(ctx context.Context, cfg *Config, t Target) Result {
+	r := Result{Target: t, NodeName: t.NodeName, Status: StatusFailed}
+	fail := func(step string, err error) Result {
+		r.Step, r.Err = step, err
+		return r
+	}
+
+	h, err := connect(ctx, cfg, t)
+	if err != nil {
+		return fail(StepConnect, err)
+	}
+	if r.NodeName == "" {
+		r.NodeName = nodename.FromHostname(h.hostname)
+	}
+	nodeIP := t.NodeIP
+	if nodeIP == "" {
+		nodeIP = h.sourceIP
+	}
+	klog.V(2).InfoS("Connected", "host", t.Host, "node", r.NodeName, "nodeIP", nodeIP, "registered", h.registered)
+
+	if _, err := cfg.Runner.Run(ctx, t.Host, installScript(), bytes.NewReader(cfg.Binary)); err != nil {
+		return fail(StepInstall, err)
+	}
+
+	if !h.registered {
+		if err := writeBootstrap(ctx, cfg, t); err != nil {
+			return fail(StepBootstrap, err)
+		}
+		r.Bootstrapped = true
+	}
+
+	unitFile := unit(r.NodeName, nodeIP, cfg.Labels)
+	if _, err := cfg.Runner.Run(ctx, t.Host, writeFileScript(UnitPath, 0o644, "root")+startScript, strings.NewReader(unitFile)); err != nil {
+		return fail(StepStart, err)
+	}
+
+	ready, err := waitReady(ctx, cfg, r.NodeName)
+	if err != nil {
+		return fail(StepRegister, err)
+	}
+	r.Status = StatusNotReady
+	if ready {
+		r.Status = StatusReady
+	}
+	return r
+}
+
+// connect checks the host can run krustlet and finds its hostname, whether
+// it already has its credentials, and the address it reaches the API server
+// from
+func connect(ctx context.Context, cfg *Config, t Target) (*host, error) {
+	script := fmt.Sprintf(`set -e
+test -d /run/systemd/system || { echo "krustlet is run with systemd, which isn't running" >&2; exit 1; }
+hostname
+if [ -f %s ]; then echo registered; else echo new; fi
+`, shellQuote(kubeconfigPath))
+	if cfg.APIServerIP != "" {
+		script += fmt.Sprintf("{ ip route get %s 2>/dev/null || true; } | sed -n 's/.* src \\([^ ]*\\).*/\\1/p' | head -n 1\n", shellQuote(cfg.APIServerIP))
+	}
+	out, err := cfg.Runner.Run(ctx, t.Host, script, nil)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 2 || strings.TrimSpace(lines[0]) == "" {
+		return nil, fmt.Errorf("unexpected output %q", out)
+	}
+	h := &host{hostname: strings.TrimSpace(lines[0]), registered: strings.TrimSpace(lines[1]) == "registered"}
+	if len(lines) > 2 {
+		h.sourceIP = strings.TrimSpace(lines[2])
+	}
+	return h, nil
+}
+
+// installScript creates krustlet's account and directories and installs the
+// binary from stdin
+func installScript() string {
+	return fmt.Sprintf(`set -e
+id -u %[1]s >/dev/null 2>&1 || useradd --system --home-dir %[2]s --shell /usr/sbin/nologin %[1]s
+mkdir -p %[3]s
+chown -R %[1]s:%[1]s %[2]s
+chmod 0700 %[3]s
+`, user, shellQuote(DataDir), shellQuote(configDir)) + writeFileScript(BinaryPath, 0o755, "root")
+}
+
+// startScript enables and restarts krustlet, so an upgraded binary or
+// changed unit takes effect
+const startScript = "systemctl daemon-reload\nsystemctl enable krustlet\nsystemctl restart krustlet\n"
+
+// writeFileScript writes stdin to the path, replacing it in one step
+func writeFileScript(p string, mode os.FileMode, owner string) string {
+	tmp := shellQuote(p + ".tmp")
+	return fmt.Sprintf("set -e\nmkdir -p %s\ncat > %s\nchmod %o %s\nchown %s:%s %s\nmv %s %s\n",
+		shellQuote(path.Dir(p)), tmp, mode, tmp, owner, owner, tmp, tmp, shellQuote(p))
+}
+
+// writeBootstrap creates a bootstrap token for the host and writes its
+// bootstrap kubeconfig
+func writeBootstrap(ctx context.Context, cfg *Config, t Target) error {
+	token, err := bootstrap.NewToken()
+	if err != nil {
+		return err
+	}
+	kubeconfig, err := bootstrap.Kubeconfig(cfg.Kubeconfig, cfg.Context, token)
+	if err != nil {
+		return err
+	}
+	data, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return err
+	}
+	if err := bootstrap.CreateTokenSecret(ctx, cfg.Client, token, time.Now().Add(cfg.TokenTTL)); err != nil {
+		return err
+	}
+	_, err = cfg.Runner.Run(ctx, t.Host, writeFileScript(bootstrapPath, 0o600, user), bytes.NewReader(data))
+	return err
+}
+
+// waitReady waits for the node to register and become ready, returning
+// whether it did in time
+func waitReady(ctx context.Context, cfg *Config, name string) (bool, error) {
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, cfg.Timeout, true, func(ctx context.Context) (bool, error) {
+		node, err := cfg.Client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return isReady(node), nil
+	})
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() == nil && wait.Interrupted(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func isReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// fakeHost is a host as seen by fakeRunner
+type fakeHost struct {
+	hostname    string
+	registered  bool
+	unreachable bool
+	// files are what was written, by path
+	files map[string]string
+}
+
+// fakeRunner runs scripts against fake hosts, recording the files written
+type fakeRunner struct {
+	mu    sync.Mutex
+	hosts map[string]*fakeHost
+	// onStart is called when krustlet is started on a host
+	onStart func(h *fakeHost)
+}
+
+func (r *fakeRunner) Run(_ context.Context, name, script string, stdin io.Reader) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := r.hosts[name]
+	if h == nil || h.unreachable {
+		return nil, errors.New("connection refused")
+	}
+	if strings.Contains(script, "hostname\n") {
+		state := "new"
+		if h.registered {
+			state = "registered"
+		}
+		return []byte(h.hostname + "\n" + state + "\n10.0.0.7\n"), nil
+	}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range []string{BinaryPath, UnitPath, bootstrapPath} {
+			if strings.Contains(script, "mv '"+p+".tmp' '"+p+"'") {
+				h.files[p] = string(data)
+			}
+		}
+	}
+	if strings.Contains(script, "systemctl restart krustlet") && r.onStart != nil {
+		r.onStart(h)
+	}
+	return nil, nil
+}
+
+func readyNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+		}},
+	}
+}
+
+func testKubeconfig() *clientcmdapi.Config {
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["edge"] = &clientcmdapi.Cluster{Server: "https://10.0.0.1:6443", CertificateAuthorityData: []byte("CA")}
+	cfg.Contexts["edge"] = &clientcmdapi.Context{Cluster: "edge"}
+	return cfg
+}
+
+func TestProvision(t *testing.T) {
+	hosts := map[string]*fakeHost{
+		"root@edge-1":  {hostname: "Edge-1", files: map[string]string{}},
+		"root@edge-2":  {hostname: "edge-2", registered: true, files: map[string]string{}},
+		"root@offline": {unreachable: true},
+		"root@stuck":   {hostname: "stuck", files: map[string]string{}},
+	}
+	client := fake.NewSimpleClientset()
+	// Nodes become ready once krustlet starts, except on the stuck host
+	runner := &fakeRunner{hosts: hosts, onStart: func(h *fakeHost) {
+		if h.hostname == "stuck" {
+			return
+		}
+		name := strings.SplitN(strings.SplitN(h.files[UnitPath], "KRUSTLET_NODE_NAME=", 2)[1], "\n", 2)[0]
+		_, _ = client.CoreV1().Nodes().Create(context.Background(), readyNode(name), metav1.CreateOptions{})
+	}}
+	cfg := &Config{
+		Client:      client,
+		Kubeconfig:  testKubeconfig(),
+		Context:     "edge",
+		APIServerIP: "10.0.0.1",
+		Runner:      runner,
+		Binary:      []byte("krustlet"),
+		TokenTTL:    time.Hour,
+		Labels:      []string{"topology.kubernetes.io/zone=store"},
+		Timeout:     50 * time.Millisecond,
+		Parallel:    2,
+	}
+
+	results := Provision(context.Background(), cfg, []Target{
+		{Host: "root@edge-1", NodeName: "store-17"},
+		{Host: "root@edge-2"},
+		{Host: "root@offline"},
+		{Host: "root@stuck", NodeIP: "192.168.1.9"},
+	})
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+
+	if r := results[0]; r.Status != StatusReady || r.NodeName != "store-17" || !r.Bootstrapped {
+		t.Errorf("unexpected result %+v", r)
+	}
+	edge1 := hosts["root@edge-1"]
+	if edge1.files[BinaryPath] != "krustlet" {
+		t.Errorf("expected the binary to be installed, got %q", edge1.files[BinaryPath])
+	}
+	for _, want := range []string{"KRUSTLET_NODE_NAME=store-17", "KRUSTLET_HOSTNAME=store-17", "KRUSTLET_NODE_IP=10.0.0.7", "NODE_LABELS=topology.kubernetes.io/zone=store"} {
+		if !strings.Contains(edge1.files[UnitPath], "Environment="+want+"\n") {
+			t.Errorf("expected %s in the unit:\n%s", want, edge1.files[UnitPath])
+		}
+	}
+	if !strings.Contains(edge1.files[bootstrapPath], "server: https://10.0.0.1:6443") {
+		t.Errorf("unexpected bootstrap kubeconfig:\n%s", edge1.files[bootstrapPath])
+	}
+
+	// A host with credentials keeps them
+	if r := results[1]; r.Status != StatusReady || r.NodeName != "edge-2" || r.Bootstrapped {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if _, ok := hosts["root@edge-2"].files[bootstrapPath]; ok {
+		t.Error("expected no bootstrap kubeconfig for a registered host")
+	}
+
+	if r := results[2]; r.Status != StatusFailed || r.Step != StepConnect || r.Err == nil {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r := results[3]; r.Status != StatusNotReady || r.Err != nil {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if !strings.Contains(hosts["root@stuck"].files[UnitPath], "KRUSTLET_NODE_IP=192.168.1.9\n") {
+		t.Errorf("expected the target's node IP in the unit:\n%s", hosts["root@stuck"].files[UnitPath])
+	}
+
+	secrets, err := client.CoreV1().Secrets("kube-system").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One token for each host that was bootstrapped
+	if len(secrets.Items) != 2 {
+		t.Errorf("expected 2 bootstrap tokens, got %d", len(secrets.Items))
+	}
+}
+
+func TestParseInventory(t *testing.T) {
+	targets, err := ParseInventory(strings.NewReader(`# edge boxes
+ubuntu@10.0.0.5 name=store-17 ip=10.0.0.5
+
+ubuntu@i-0123456789abcdef0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{{Host: "ubuntu@10.0.0.5", NodeName: "store-17", NodeIP: "10.0.0.5"}, {Host: "ubuntu@i-0123456789abcdef0"}}
+	if len(targets) != len(want) || targets[0] != want[0] || targets[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", targets, want)
+	}
+
+	for _, bad := range []string{"host name", "host ip=nope", "host zone=a"} {
+		if _, err := ParseInventory(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	if got := shellQuote("it's"); got != `'it'\''s'` {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,54 @@
+package fleet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Target is a host to provision
+type Target struct {
+	// Host is what the runner connects to, such as user@host for ssh
+	Host string
+	// NodeName is the node's name, or empty for the host's lower cased
+	// hostname, as krustlet uses by default
+	NodeName string
+	// NodeIP is the address the node registers with, or empty for the
+	// address the host reaches the API server from
+	NodeIP string
+}
+
+// ParseInventory reads targets, one per line as a host followed by optional
+// name=<node name> and ip=<node IP> fields. Blank lines and lines starting
+// with # are skipped.
+func ParseInventory(r io.Reader) ([]Target, error) {
+	var targets []Target
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		t := Target{Host: fields[0]}
+		for _, f := range fields[1:] {
+			key, value, ok := strings.Cut(f, "=")
+			switch {
+			case !ok:
+				return nil, fmt.Errorf("line %d: expected key=value, got %q", line, f)
+			case key == "name":
+				t.NodeName = value
+			case key == "ip":
+				if net.ParseIP(value) == nil {
+					return nil, fmt.Errorf("line %d: invalid IP address %q", line, value)
+				}
+				t.NodeIP = value
+			default:
+				return nil, fmt.Errorf("line %d: unknown field %q", line, key)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs shell scripts on hosts
+type Runner interface {
+	// Run runs the script with sh on the host, as root, with stdin as its
+	// input, and returns what it writes to stdout
+	Run(ctx context.Context, host, script string, stdin io.Reader) ([]byte, error)
+}
+
+// SSH runs scripts with the ssh command, so hosts are reached however the
+// user's ssh config says: with their keys and agent, through jump hosts, or
+// through a cloud provider's ProxyCommand for hosts named by instance ID
+type SSH struct {
+	// Command is the ssh binary, ssh on the PATH by default
+	Command string
+	// Args are passed to ssh before the host
+	Args []string
+	// Sudo runs scripts with sudo, for hosts not logged into as root
+	Sudo bool
+}
+
+// Run implements Runner
+func (s *SSH) Run(ctx context.Context, host, script string, stdin io.Reader) ([]byte, error) {
+	command := s.Command
+	if command == "" {
+		command = "ssh"
+	}
+	remote := "sh -c " + shellQuote(script)
+	if s.Sudo {
+		remote = "sudo -n " + remote
+	}
+	args := append([]string{"-o", "BatchMode=yes"}, s.Args...)
+	args = append(args, "--", host, remote)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
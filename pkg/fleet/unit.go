@@ -0,0 +1,51 @@
+package fleet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unit returns the systemd unit that runs krustlet on a host. The hostname is
+// the node name, so the serving certificate names the node it is requested
+// by.
+func unit(nodeName, nodeIP string, labels []string) string {
+	env := []string{
+		"KUBECONFIG=" + kubeconfigPath,
+		"KRUSTLET_DATA_DIR=" + DataDir,
+		"KRUSTLET_CERT_FILE=" + configDir + "/krustlet.crt",
+		"KRUSTLET_PRIVATE_KEY_FILE=" + configDir + "/krustlet.key",
+		"KRUSTLET_BOOTSTRAP_FILE=" + bootstrapPath,
+		"KRUSTLET_NODE_NAME=" + nodeName,
+		"KRUSTLET_HOSTNAME=" + nodeName,
+	}
+	if nodeIP != "" {
+		env = append(env, "KRUSTLET_NODE_IP="+nodeIP)
+	}
+	if len(labels) > 0 {
+		env = append(env, "NODE_LABELS="+strings.Join(labels, ","))
+	}
+	env = append(env, "RUST_LOG=wasi_provider=info,main=info")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `[Unit]
+Description=Krustlet
+Documentation=https://github.com/krustlet/krustlet
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+`)
+	for _, e := range env {
+		fmt.Fprintf(&b, "Environment=%s\n", e)
+	}
+	fmt.Fprintf(&b, `ExecStart=%s
+User=%s
+Group=%s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, BinaryPath, user, user)
+	return b.String()
+}
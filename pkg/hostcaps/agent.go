@@ -0,0 +1,148 @@
+package hostcaps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// DefaultInterval is how often capability health and krustlet's registration
+// socket are checked
+const DefaultInterval = 10 * time.Second
+
+// kubeletSocket is the registration socket krustlet serves in the device
+// plugin directory
+const kubeletSocket = "kubelet.sock"
+
+var errKubeletRestarted = errors.New("krustlet's registration socket changed")
+
+// Agent serves a device plugin per capability and registers them with
+// krustlet, registering again whenever krustlet restarts
+type Agent struct {
+	config *Config
+	dir    string
+	// Interval is how often health and the registration socket are checked
+	Interval time.Duration
+}
+
+// New returns an agent that serves its plugins from dir, krustlet's device
+// plugin directory
+func New(config *Config, dir string) *Agent {
+	return &Agent{config: config, dir: dir, Interval: DefaultInterval}
+}
+
+// Run serves and registers the plugins until the context is cancelled
+func (a *Agent) Run(ctx context.Context) error {
+	for {
+		err := a.serve(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errKubeletRestarted) {
+			klog.Info("Krustlet restarted, registering capabilities again")
+			continue
+		}
+		klog.ErrorS(err, "Failed to serve capabilities, retrying", "interval", a.Interval)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(a.Interval):
+		}
+	}
+}
+
+// serve starts the plugins and registers them, then waits until krustlet
+// restarts, a plugin's socket disappears or the context is cancelled
+func (a *Agent) serve(ctx context.Context) error {
+	kubelet := filepath.Join(a.dir, kubeletSocket)
+	kubeletInfo, err := os.Stat(kubelet)
+	if err != nil {
+		return fmt.Errorf("waiting for krustlet's registration socket: %w", err)
+	}
+
+	var sockets []string
+	for i := range a.config.Capabilities {
+		c := &a.config.Capabilities[i]
+		p := &plugin{resource: a.config.resourceName(c), capability: c, interval: a.Interval}
+		socket := filepath.Join(a.dir, "krustlet-hostcaps-"+c.Name+".sock")
+		stop, err := servePlugin(p, socket)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		sockets = append(sockets, socket)
+	}
+
+	conn, err := grpc.NewClient("unix://"+kubelet, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	registration := pluginapi.NewRegistrationClient(conn)
+	for i, c := range a.config.Capabilities {
+		resource := a.config.resourceName(&c)
+		regCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		_, err := registration.Register(regCtx, &pluginapi.RegisterRequest{
+			Version:      pluginapi.Version,
+			Endpoint:     filepath.Base(sockets[i]),
+			ResourceName: resource,
+			Options:      &pluginapi.DevicePluginOptions{},
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("registering %s: %w", resource, err)
+		}
+		klog.InfoS("Registered capability", "resource", resource, "count", c.Count)
+	}
+
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if info, err := os.Stat(kubelet); err != nil || !os.SameFile(info, kubeletInfo) {
+			return errKubeletRestarted
+		}
+		for _, socket := range sockets {
+			// Kubelets remove plugin sockets when they start
+			if _, err := os.Stat(socket); err != nil {
+				return errKubeletRestarted
+			}
+		}
+	}
+}
+
+// servePlugin serves the plugin on a fresh socket, returning a function that
+// stops it
+func servePlugin(p *plugin, socket string) (func(), error) {
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	srv := grpc.NewServer()
+	pluginapi.RegisterDevicePluginServer(srv, p)
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			klog.ErrorS(err, "Device plugin stopped", "resource", p.resource)
+		}
+	}()
+	return func() {
+		srv.Stop()
+		_ = os.Remove(socket)
+	}, nil
+}
@@ -0,0 +1,188 @@
+package hostcaps
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// fakeKrustlet serves the registration API and passes on what registers
+type fakeKrustlet struct {
+	pluginapi.UnimplementedRegistrationServer
+	registered chan *pluginapi.RegisterRequest
+	srv        *grpc.Server
+}
+
+func (k *fakeKrustlet) Register(_ context.Context, req *pluginapi.RegisterRequest) (*pluginapi.Empty, error) {
+	k.registered <- req
+	return &pluginapi.Empty{}, nil
+}
+
+func startKrustlet(t *testing.T, dir string) *fakeKrustlet {
+	t.Helper()
+	socket := filepath.Join(dir, kubeletSocket)
+	_ = os.Remove(socket)
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &fakeKrustlet{registered: make(chan *pluginapi.RegisterRequest, 10), srv: grpc.NewServer()}
+	pluginapi.RegisterRegistrationServer(k.srv, k)
+	go func() { _ = k.srv.Serve(lis) }()
+	t.Cleanup(k.srv.Stop)
+	return k
+}
+
+func (k *fakeKrustlet) next(t *testing.T) *pluginapi.RegisterRequest {
+	t.Helper()
+	select {
+	case req := <-k.registered:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a plugin to register")
+		return nil
+	}
+}
+
+// tempDir returns a directory short enough for socket paths
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "hostcaps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func dialPlugin(t *testing.T, dir, endpoint string) pluginapi.DevicePluginClient {
+	t.Helper()
+	conn, err := grpc.NewClient("unix://"+filepath.Join(dir, endpoint), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pluginapi.NewDevicePluginClient(conn)
+}
+
+func TestAgent(t *testing.T) {
+	dir := tempDir(t)
+	bus := filepath.Join(tempDir(t), "i2c")
+	if err := os.Mkdir(bus, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Capabilities: []Capability{
+		{Name: "i2c", Count: 2, Mounts: []Mount{{HostPath: bus, ContainerPath: "/i2c"}}, Env: map[string]string{"I2C_DIR": "/i2c"}},
+		{Name: "token", Env: map[string]string{"PKCS11_SLOT": "0"}},
+	}}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	krustlet := startKrustlet(t, dir)
+	agent := New(config, dir)
+	agent.Interval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- agent.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	i2c := krustlet.next(t)
+	token := krustlet.next(t)
+	if i2c.ResourceName != "krustlet.dev/i2c" || token.ResourceName != "krustlet.dev/token" || i2c.Version != pluginapi.Version {
+		t.Fatalf("unexpected registrations %v, %v", i2c, token)
+	}
+	if strings.Contains(i2c.Endpoint, "/") {
+		t.Errorf("expected the endpoint to be relative to the plugin directory, got %q", i2c.Endpoint)
+	}
+
+	client := dialPlugin(t, dir, i2c.Endpoint)
+	stream, err := client.ListAndWatch(ctx, &pluginapi.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Devices) != 2 || resp.Devices[0].Health != pluginapi.Healthy {
+		t.Errorf("expected two healthy devices, got %v", resp.Devices)
+	}
+
+	alloc, err := client.Allocate(ctx, &pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"i2c-1"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := alloc.ContainerResponses[0]
+	if c.Envs["I2C_DIR"] != "/i2c" || len(c.Mounts) != 1 || c.Mounts[0].HostPath != bus || c.Mounts[0].ContainerPath != "/i2c" {
+		t.Errorf("unexpected allocation %v", c)
+	}
+	if _, err := client.Allocate(ctx, &pluginapi.AllocateRequest{ContainerRequests: []*pluginapi.ContainerAllocateRequest{{DevicesIDs: []string{"i2c-7"}}}}); err == nil {
+		t.Error("expected allocating an unknown device to fail")
+	}
+
+	// Removing the bus marks the devices unhealthy
+	if err := os.Remove(bus); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Devices[0].Health != pluginapi.Unhealthy {
+		t.Errorf("expected unhealthy devices, got %v", resp.Devices)
+	}
+
+	// The plugins register again when krustlet restarts
+	krustlet.srv.Stop()
+	krustlet = startKrustlet(t, dir)
+	if got := krustlet.next(t).ResourceName; got != "krustlet.dev/i2c" {
+		t.Errorf("expected i2c to register again, got %s", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []Config{
+		{},
+		{Capabilities: []Capability{{Name: "bad name!", Env: map[string]string{"A": "b"}}}},
+		{Capabilities: []Capability{{Name: "empty"}}},
+		{Capabilities: []Capability{{Name: "rel", Mounts: []Mount{{HostPath: "dev", ContainerPath: "/dev"}}}}},
+		{Capabilities: []Capability{{Name: "neg", Count: -1, Env: map[string]string{"A": "b"}}}},
+		{Capabilities: []Capability{{Name: "a", Env: map[string]string{"A": "b"}}, {Name: "a", Env: map[string]string{"A": "b"}}}},
+		{ResourceDomain: "kubernetes.io", Capabilities: []Capability{{Name: "a", Env: map[string]string{"A": "b"}}}},
+	} {
+		if err := c.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "capabilities.yaml")
+	data := `
+capabilities:
+  - name: gpio
+    mounts:
+      - hostPath: /sys/class/gpio
+        containerPath: /gpio
+`
+	if err := os.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ResourceDomain != DefaultResourceDomain || cfg.Capabilities[0].Count != 1 {
+		t.Errorf("expected defaults to be set, got %+v", cfg)
+	}
+}
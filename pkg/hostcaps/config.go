@@ -0,0 +1,114 @@
+// Package hostcaps advertises host capabilities on a krustlet node as
+// extended resources, through the device plugin API krustlet implements.
+//
+// A capability is host access that only some pods should get, such as a
+// sensor bus or a crypto token: a set of host directories to preopen and
+// environment variables to set. Each one is advertised as a resource with a
+// fixed number of devices, so pods ask for it in resources.limits and at most
+// that many pods get it at once. When krustlet allocates a device to a
+// container it preopens the directories at their container paths and sets the
+// variables.
+package hostcaps
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultResourceDomain prefixes the resource names of capabilities unless
+// the config sets another
+const DefaultResourceDomain = "krustlet.dev"
+
+// Config lists the capabilities the node offers
+type Config struct {
+	// ResourceDomain prefixes capability resource names, as
+	// <domain>/<name>
+	ResourceDomain string       `json:"resourceDomain,omitempty"`
+	Capabilities   []Capability `json:"capabilities"`
+}
+
+// Capability is host access granted to pods that request its resource
+type Capability struct {
+	// Name is the resource name within the domain
+	Name string `json:"name"`
+	// Count is how many containers can hold the capability at once. It
+	// defaults to 1.
+	Count int `json:"count,omitempty"`
+	// Mounts are the host directories krustlet preopens for the container
+	Mounts []Mount `json:"mounts,omitempty"`
+	// Env is set in the container's environment
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// Mount is a host directory made available to a container
+type Mount struct {
+	HostPath      string `json:"hostPath"`
+	ContainerPath string `json:"containerPath"`
+	ReadOnly      bool   `json:"readOnly,omitempty"`
+}
+
+// LoadConfig reads and validates a capability config file, which may be
+// YAML or JSON
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid capability config %s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.ResourceDomain == "" {
+		cfg.ResourceDomain = DefaultResourceDomain
+	}
+	if cfg.ResourceDomain == "kubernetes.io" || strings.HasSuffix(cfg.ResourceDomain, ".kubernetes.io") {
+		return fmt.Errorf("resourceDomain %s is reserved for Kubernetes resources", cfg.ResourceDomain)
+	}
+	if len(cfg.Capabilities) == 0 {
+		return errors.New("at least one capability is required")
+	}
+	names := map[string]bool{}
+	for i := range cfg.Capabilities {
+		c := &cfg.Capabilities[i]
+		if errs := validation.IsQualifiedName(cfg.resourceName(c)); len(errs) > 0 {
+			return fmt.Errorf("capability %q: invalid resource name: %s", c.Name, strings.Join(errs, "; "))
+		}
+		if names[c.Name] {
+			return fmt.Errorf("capability %q is configured more than once", c.Name)
+		}
+		names[c.Name] = true
+		if c.Count == 0 {
+			c.Count = 1
+		}
+		if c.Count < 0 {
+			return fmt.Errorf("capability %q: count must be positive", c.Name)
+		}
+		if len(c.Mounts) == 0 && len(c.Env) == 0 {
+			return fmt.Errorf("capability %q grants nothing; it needs mounts or env", c.Name)
+		}
+		for _, m := range c.Mounts {
+			if !path.IsAbs(m.HostPath) || !path.IsAbs(m.ContainerPath) {
+				return fmt.Errorf("capability %q: mount paths must be absolute, got %q and %q", c.Name, m.HostPath, m.ContainerPath)
+			}
+		}
+	}
+	return nil
+}
+
+// resourceName is the extended resource the capability is advertised as
+func (cfg *Config) resourceName(c *Capability) string {
+	return cfg.ResourceDomain + "/" + c.Name
+}
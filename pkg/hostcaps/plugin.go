@@ -0,0 +1,125 @@
+package hostcaps
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+	pluginapi "k8s.io/kubelet/pkg/apis/deviceplugin/v1beta1"
+)
+
+// plugin serves the device plugin API for one capability. Its devices are
+// interchangeable slots; they are all unhealthy while any mounted host
+// directory is missing.
+type plugin struct {
+	pluginapi.UnimplementedDevicePluginServer
+
+	resource   string
+	capability *Capability
+	interval   time.Duration
+}
+
+func (p *plugin) deviceIDs() []string {
+	ids := make([]string, p.capability.Count)
+	for i := range ids {
+		ids[i] = p.capability.Name + "-" + strconv.Itoa(i)
+	}
+	return ids
+}
+
+// health returns the state of every device
+func (p *plugin) health() string {
+	for _, m := range p.capability.Mounts {
+		// Krustlet preopens mounts, which only works for directories
+		info, err := os.Stat(m.HostPath)
+		if err != nil || !info.IsDir() {
+			return pluginapi.Unhealthy
+		}
+	}
+	return pluginapi.Healthy
+}
+
+func (p *plugin) devices(health string) []*pluginapi.Device {
+	var devs []*pluginapi.Device
+	for _, id := range p.deviceIDs() {
+		devs = append(devs, &pluginapi.Device{ID: id, Health: health})
+	}
+	return devs
+}
+
+// GetDevicePluginOptions implements pluginapi.DevicePluginServer
+func (p *plugin) GetDevicePluginOptions(context.Context, *pluginapi.Empty) (*pluginapi.DevicePluginOptions, error) {
+	return &pluginapi.DevicePluginOptions{}, nil
+}
+
+// ListAndWatch implements pluginapi.DevicePluginServer. It sends the devices
+// again whenever their health changes.
+func (p *plugin) ListAndWatch(_ *pluginapi.Empty, stream pluginapi.DevicePlugin_ListAndWatchServer) error {
+	health := p.health()
+	if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: p.devices(health)}); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+		current := p.health()
+		if current == health {
+			continue
+		}
+		klog.InfoS("Capability health changed", "resource", p.resource, "health", current)
+		health = current
+		if err := stream.Send(&pluginapi.ListAndWatchResponse{Devices: p.devices(health)}); err != nil {
+			return err
+		}
+	}
+}
+
+// Allocate implements pluginapi.DevicePluginServer. Every container gets the
+// capability's mounts and environment, whichever devices it was given.
+func (p *plugin) Allocate(_ context.Context, req *pluginapi.AllocateRequest) (*pluginapi.AllocateResponse, error) {
+	known := map[string]bool{}
+	for _, id := range p.deviceIDs() {
+		known[id] = true
+	}
+	resp := &pluginapi.AllocateResponse{}
+	for _, creq := range req.ContainerRequests {
+		for _, id := range creq.DevicesIDs {
+			if !known[id] {
+				return nil, fmt.Errorf("unknown %s device %q", p.resource, id)
+			}
+		}
+		cresp := &pluginapi.ContainerAllocateResponse{Envs: map[string]string{}}
+		for k, v := range p.capability.Env {
+			cresp.Envs[k] = v
+		}
+		for _, m := range p.capability.Mounts {
+			cresp.Mounts = append(cresp.Mounts, &pluginapi.Mount{
+				ContainerPath: m.ContainerPath,
+				HostPath:      m.HostPath,
+				ReadOnly:      m.ReadOnly,
+			})
+		}
+		resp.ContainerResponses = append(resp.ContainerResponses, cresp)
+		klog.V(2).InfoS("Allocated capability", "resource", p.resource, "devices", creq.DevicesIDs)
+	}
+	return resp, nil
+}
+
+// GetPreferredAllocation implements pluginapi.DevicePluginServer. The
+// devices are interchangeable, so there is no preference.
+func (p *plugin) GetPreferredAllocation(context.Context, *pluginapi.PreferredAllocationRequest) (*pluginapi.PreferredAllocationResponse, error) {
+	return &pluginapi.PreferredAllocationResponse{}, nil
+}
+
+// PreStartContainer implements pluginapi.DevicePluginServer
+func (p *plugin) PreStartContainer(context.Context, *pluginapi.PreStartContainerRequest) (*pluginapi.PreStartContainerResponse, error) {
+	return &pluginapi.PreStartContainerResponse{}, nil
+}
@@ -0,0 +1,249 @@
+// Package imagecache reports the modules cached on a krustlet node in an
+// annotation on the node, so schedulers, pre-pullers and operators can see
+// what each node already has without logging in to it.
+//
+// Krustlet keeps every module it pulls in its module store and tells the API
+// server nothing about them; a krustlet node's status lists no images. The
+// reporter runs on the node, reads the store and publishes an index of its
+// modules, by reference and manifest digest, in CachedModulesAnnotation.
+package imagecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/modulestore"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// CachedModulesAnnotation is the node annotation holding the node's Index,
+// as JSON
+const CachedModulesAnnotation = "krustlet.dev/cached-modules"
+
+// DefaultMaxSize is how large the annotation is allowed to grow. The API
+// server allows 256Ki for all of an object's annotations together.
+const DefaultMaxSize = 64 * 1024
+
+// Module is one module in the store
+type Module struct {
+	// Ref is the module's reference as krustlet stores it,
+	// <registry>/<repository>:<tag>. Modules pulled by digest alone are
+	// stored under the latest tag.
+	Ref string `json:"ref"`
+	// Digest is the digest of the module's manifest when it was pulled
+	Digest string `json:"digest"`
+	// Size is the size of the module's binary
+	Size int64 `json:"size"`
+	// Pulled is when the module was written to the store
+	Pulled metav1.Time `json:"pulled"`
+}
+
+// Index is what a node has cached
+type Index struct {
+	// Modules are sorted by Ref
+	Modules []Module `json:"modules"`
+	// Omitted is how many of the least recently pulled modules were left
+	// out to keep the annotation within its size limit
+	Omitted int `json:"omitted,omitempty"`
+	// Bytes is the size of every module in the store, omitted ones included
+	Bytes int64 `json:"bytes"`
+}
+
+// Lookup returns the cached module an image reference resolves to. A
+// reference with a digest matches any module with that manifest digest,
+// whatever its tag; one without matches the module stored under its tag.
+func (idx *Index) Lookup(image string) (Module, bool) {
+	ref, err := oci.ParseReference(image)
+	if err != nil {
+		return Module{}, false
+	}
+	for _, m := range idx.Modules {
+		if ref.Digest != "" && m.Digest == ref.Digest {
+			return m, true
+		}
+		if ref.Digest == "" && m.Ref == modulestore.Name(modulestore.Path(ref)) {
+			return m, true
+		}
+	}
+	return Module{}, false
+}
+
+// FromNode returns the index a node reports. It returns nil if the node
+// reports none.
+func FromNode(node *corev1.Node) (*Index, error) {
+	v, ok := node.Annotations[CachedModulesAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	idx := &Index{}
+	if err := json.Unmarshal([]byte(v), idx); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation on node %s: %w", CachedModulesAnnotation, node.Name, err)
+	}
+	return idx, nil
+}
+
+// Options say where the store is and how much of it to report
+type Options struct {
+	// ModuleDir is krustlet's module store, <data dir>/.oci/modules
+	ModuleDir string
+	// MaxSize is the largest the annotation may be, in bytes. 0 means
+	// DefaultMaxSize.
+	MaxSize int
+}
+
+// Reporter publishes one node's index
+type Reporter struct {
+	client   kubernetes.Interface
+	nodeName string
+	opts     Options
+}
+
+// New returns a reporter for the node's module store
+func New(client kubernetes.Interface, nodeName string, opts Options) *Reporter {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+	return &Reporter{client: client, nodeName: nodeName, opts: opts}
+}
+
+// Report reads the store and updates the node's annotation if the index has
+// changed. It returns the index it read.
+func (r *Reporter) Report(ctx context.Context) (*Index, error) {
+	modules, err := Scan(r.opts.ModuleDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading module store: %w", err)
+	}
+	idx, value, err := fit(modules, r.opts.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := r.client.CoreV1().Nodes().Get(ctx, r.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("getting node: %w", err)
+	}
+	if node.Annotations[CachedModulesAnnotation] == value {
+		return idx, nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{CachedModulesAnnotation: value},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.client.CoreV1().Nodes().Patch(ctx, r.nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("patching node: %w", err)
+	}
+	klog.InfoS("Reported cached modules", "node", r.nodeName, "modules", len(idx.Modules), "omitted", idx.Omitted)
+	return idx, nil
+}
+
+// Scan returns the complete modules in the store at root, sorted by
+// reference. A store that doesn't exist yet holds no modules.
+func Scan(root string) ([]Module, error) {
+	var modules []Module
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == root {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || d.Name() != modulestore.DigestFile {
+			return nil
+		}
+		dir := filepath.Dir(p)
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil
+		}
+		digest, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(filepath.Join(dir, modulestore.ModuleFile))
+		if errors.Is(err, fs.ErrNotExist) {
+			klog.V(2).InfoS("Skipping module without a binary", "dir", dir)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		pulled := info.ModTime()
+		if dinfo, err := d.Info(); err == nil && dinfo.ModTime().After(pulled) {
+			pulled = dinfo.ModTime()
+		}
+		modules = append(modules, Module{
+			Ref:    modulestore.Name(rel),
+			Digest: strings.TrimSpace(string(digest)),
+			Size:   info.Size(),
+			Pulled: metav1.NewTime(pulled.Truncate(time.Second)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Ref < modules[j].Ref })
+	return modules, nil
+}
+
+// fit returns the index of the modules and its encoding, leaving out the
+// least recently pulled modules until it is no larger than maxSize
+func fit(modules []Module, maxSize int) (*Index, string, error) {
+	idx := &Index{Modules: []Module{}}
+	for _, m := range modules {
+		idx.Bytes += m.Size
+	}
+	byAge := make([]Module, len(modules))
+	copy(byAge, modules)
+	sort.SliceStable(byAge, func(i, j int) bool { return byAge[i].Pulled.After(byAge[j].Pulled.Time) })
+
+	// The encoding grows with each module, so find the most that fit
+	keep := sort.Search(len(byAge)+1, func(n int) bool {
+		if n == 0 {
+			return false
+		}
+		value, err := encode(idx, byAge[:n], len(byAge)-n)
+		return err != nil || len(value) > maxSize
+	}) - 1
+	value, err := encode(idx, byAge[:keep], len(byAge)-keep)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(value) > maxSize {
+		return nil, "", fmt.Errorf("index of no modules is larger than %d bytes", maxSize)
+	}
+	return idx, value, nil
+}
+
+// encode sets the index to hold the modules, sorted by reference, and
+// returns it as JSON
+func encode(idx *Index, modules []Module, omitted int) (string, error) {
+	idx.Modules = append(idx.Modules[:0], modules...)
+	sort.Slice(idx.Modules, func(i, j int) bool { return idx.Modules[i].Ref < idx.Modules[j].Ref })
+	idx.Omitted = omitted
+	data, err := json.Marshal(idx)
+	return string(data), err
+}
@@ -0,0 +1,138 @@
+package imagecache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/krustlet/krustlet/pkg/modulestore"
+)
+
+var now = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// writeModule writes a module into the store at <registry>/<repository>/<tag>
+// that was pulled age ago. An empty digest leaves the module half pulled.
+func writeModule(t *testing.T, root, rel, digest string, age time.Duration) {
+	t.Helper()
+	dir := filepath.Join(root, rel)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{modulestore.ModuleFile: "\x00asm\x01\x00\x00\x00"}
+	if digest != "" {
+		files[modulestore.DigestFile] = digest + "\n"
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newReporter(t *testing.T, opts Options) (*Reporter, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "krustlet"}})
+	return New(client, "krustlet", opts), client
+}
+
+func TestReport(t *testing.T) {
+	root := filepath.Join(t.TempDir(), ".oci", "modules")
+	writeModule(t, root, "ghcr.io/example/app/v1", "sha256:aaa", time.Hour)
+	writeModule(t, root, "docker.io/library/hello/latest", "sha256:bbb", 2*time.Hour)
+	writeModule(t, root, "ghcr.io/example/app/v2", "", 0)
+	r, client := newReporter(t, Options{ModuleDir: root})
+	ctx := context.Background()
+
+	if _, err := r.Report(ctx); err != nil {
+		t.Fatal(err)
+	}
+	node, _ := client.CoreV1().Nodes().Get(ctx, "krustlet", metav1.GetOptions{})
+	idx, err := FromNode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Modules) != 2 || idx.Modules[0].Ref != "docker.io/library/hello:latest" || idx.Modules[1].Ref != "ghcr.io/example/app:v1" {
+		t.Fatalf("expected the complete modules sorted by reference, got %+v", idx.Modules)
+	}
+	if m := idx.Modules[1]; m.Digest != "sha256:aaa" || m.Size != 8 || !m.Pulled.Time.Equal(now.Add(-time.Hour)) || idx.Bytes != 16 {
+		t.Errorf("unexpected index %+v", idx)
+	}
+	if m, ok := idx.Lookup("hello"); !ok || m.Digest != "sha256:bbb" {
+		t.Errorf("expected hello to resolve to the Docker Hub module, got %+v", m)
+	}
+	if m, ok := idx.Lookup("ghcr.io/example/other@sha256:aaa"); !ok || m.Ref != "ghcr.io/example/app:v1" {
+		t.Errorf("expected a digest to match whatever the module is stored under, got %+v", m)
+	}
+	if _, ok := idx.Lookup("ghcr.io/example/app:v2"); ok {
+		t.Error("expected a module still being pulled not to be reported")
+	}
+
+	// Nothing is patched while the store doesn't change
+	client.ClearActions()
+	if _, err := r.Report(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "patch" {
+			t.Errorf("expected no patch for an unchanged store, got %v", a)
+		}
+	}
+}
+
+func TestReportLimit(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeModule(t, root, fmt.Sprintf("ghcr.io/example/app/v%d", i), "sha256:0123456789abcdef", time.Duration(i)*time.Hour)
+	}
+	r, client := newReporter(t, Options{ModuleDir: root, MaxSize: 1024})
+	idx, err := r.Report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := client.CoreV1().Nodes().Get(context.Background(), "krustlet", metav1.GetOptions{})
+	value := node.Annotations[CachedModulesAnnotation]
+	if len(value) > 1024 || idx.Omitted == 0 || len(idx.Modules)+idx.Omitted != 20 {
+		t.Fatalf("expected modules to be omitted to fit the limit, got %d kept and %d omitted in %d bytes", len(idx.Modules), idx.Omitted, len(value))
+	}
+	if _, ok := idx.Lookup("ghcr.io/example/app:v0"); !ok {
+		t.Error("expected the most recently pulled module to be kept")
+	}
+	if _, ok := idx.Lookup("ghcr.io/example/app:v19"); ok {
+		t.Error("expected the least recently pulled module to be omitted")
+	}
+	if idx.Bytes != 20*8 {
+		t.Errorf("expected omitted modules to count towards the store's size, got %d", idx.Bytes)
+	}
+}
+
+func TestReportEmptyStore(t *testing.T) {
+	r, _ := newReporter(t, Options{ModuleDir: filepath.Join(t.TempDir(), "missing")})
+	idx, err := r.Report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Modules == nil || len(idx.Modules) != 0 {
+		t.Errorf("expected an empty index for a store that doesn't exist yet, got %+v", idx)
+	}
+}
+
+func TestFromNode(t *testing.T) {
+	if idx, err := FromNode(&corev1.Node{}); idx != nil || err != nil {
+		t.Errorf("expected no index on a node without the annotation, got %v, %v", idx, err)
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n", Annotations: map[string]string{CachedModulesAnnotation: "{"}}}
+	if _, err := FromNode(node); err == nil {
+		t.Error("expected an invalid annotation to be an error")
+	}
+}
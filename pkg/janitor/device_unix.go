@@ -0,0 +1,22 @@
+//go:build !windows
+
+package janitor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// device returns the ID of the filesystem holding the path
+func device(path string) (uint64, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("no device for %s", path)
+	}
+	return uint64(st.Dev), nil
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package janitor
+
+import "errors"
+
+// device isn't supported on Windows, so volume directories, which might hold a
+// mount, are never removed there
+func device(string) (uint64, error) {
+	return 0, errors.New("checking for mount points is not supported on Windows")
+}
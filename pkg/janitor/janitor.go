@@ -0,0 +1,351 @@
+// Package janitor removes what krustlet leaves behind on a host for pods that
+// are gone: cached modules no pod on the node uses, the volume directories
+// of deleted pods, and log files left by a krustlet that stopped before it
+// could remove them.
+//
+// Krustlet caches every module it pulls and never removes one, so a node
+// that runs many workloads over time slowly fills its disk. Everything is
+// checked against the pods bound to the node, whatever their phase, and
+// nothing changed more recently than MinAge is removed, so a module being
+// pulled for a pod that was just created is left alone.
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/modulestore"
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// DefaultMinAge is how long a module or directory is kept after it last
+// changed, whether or not a pod uses it
+const DefaultMinAge = 24 * time.Hour
+
+// Options say where krustlet keeps its files and what to keep
+type Options struct {
+	// ModuleDir is krustlet's module store, <data dir>/.oci/modules
+	ModuleDir string
+	// VolumeDir holds the pods' volumes, <data dir>/volumes
+	VolumeDir string
+	// LogDir holds the containers' logs, <data dir>/wasi-logs
+	LogDir string
+	// MinAge is how long anything is kept after it last changed
+	MinAge time.Duration
+	// Keep are patterns of modules never to remove, matched with path.Match
+	// against <registry>/<repository>:<tag>. A pattern without a tag matches
+	// every tag.
+	Keep []string
+	// DryRun reports what would be removed without removing it
+	DryRun bool
+}
+
+// Report is what a collection removed, or would remove in a dry run
+type Report struct {
+	Modules []string
+	Volumes []string
+	Logs    []string
+	// Bytes is the size of the files removed
+	Bytes int64
+	// Skipped are orphans left in place, such as volume directories with
+	// something still mounted in them
+	Skipped []string
+}
+
+// Janitor removes orphaned files for one node
+type Janitor struct {
+	client   kubernetes.Interface
+	nodeName string
+	opts     Options
+	now      func() time.Time
+}
+
+// New returns a janitor for the node's files
+func New(client kubernetes.Interface, nodeName string, opts Options) *Janitor {
+	return &Janitor{client: client, nodeName: nodeName, opts: opts, now: time.Now}
+}
+
+// inUse is what the node's pods use
+type inUse struct {
+	// modules are store directories, relative to the store's root
+	modules map[string]bool
+	// podDirs are volume directory names, <pod>-<namespace>
+	podDirs map[string]bool
+	// pods are <namespace>/<pod>
+	pods map[string]bool
+}
+
+// Collect removes what no pod on the node uses. Nothing is removed if the
+// node's pods can't be listed.
+func (j *Janitor) Collect(ctx context.Context) (*Report, error) {
+	pods, err := j.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", j.nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	used := usedBy(pods.Items)
+	report := &Report{}
+	var errs []error
+	if j.opts.ModuleDir != "" {
+		errs = append(errs, j.collectModules(used, report))
+	}
+	if j.opts.VolumeDir != "" {
+		errs = append(errs, j.collectVolumes(used, report))
+	}
+	if j.opts.LogDir != "" {
+		errs = append(errs, j.collectLogs(used, report))
+	}
+	return report, errors.Join(errs...)
+}
+
+func usedBy(pods []corev1.Pod) *inUse {
+	used := &inUse{modules: map[string]bool{}, podDirs: map[string]bool{}, pods: map[string]bool{}}
+	for i := range pods {
+		pod := &pods[i]
+		used.podDirs[pod.Name+"-"+pod.Namespace] = true
+		used.pods[pod.Namespace+"/"+pod.Name] = true
+		var images []string
+		for _, c := range pod.Spec.InitContainers {
+			images = append(images, c.Image)
+		}
+		for _, c := range pod.Spec.Containers {
+			images = append(images, c.Image)
+		}
+		for _, image := range images {
+			ref, err := oci.ParseReference(image)
+			if err != nil {
+				continue
+			}
+			used.modules[modulestore.Path(ref)] = true
+		}
+	}
+	return used
+}
+
+// kept reports whether the module at a store path matches a keep pattern
+func (j *Janitor) kept(rel string) bool {
+	module := modulestore.Name(rel)
+	name := filepath.ToSlash(filepath.Dir(rel))
+	for _, pattern := range j.opts.Keep {
+		target := module
+		// A colon after the last slash is a tag; one before it is a port
+		if !strings.Contains(pattern[strings.LastIndex(pattern, "/")+1:], ":") {
+			target = name
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collectModules removes the modules in the store that no pod uses
+func (j *Janitor) collectModules(used *inUse, report *Report) error {
+	root := j.opts.ModuleDir
+	var dirs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == root {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.IsDir() && (d.Name() == modulestore.ModuleFile || d.Name() == modulestore.DigestFile) {
+			dir := filepath.Dir(p)
+			if len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+				dirs = append(dirs, dir)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil || used.modules[rel] || j.kept(rel) {
+			continue
+		}
+		var size int64
+		recent := false
+		for _, name := range []string{modulestore.ModuleFile, modulestore.DigestFile} {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			size += info.Size()
+			recent = recent || j.now().Sub(info.ModTime()) < j.opts.MinAge
+		}
+		if recent {
+			continue
+		}
+		module := modulestore.Name(rel)
+		if !j.opts.DryRun {
+			// The digest goes first, as krustlet writes it last. A digest
+			// without its module makes krustlet fail to load it rather than
+			// pull it again.
+			if err := removeFiles(dir, modulestore.DigestFile, modulestore.ModuleFile); err != nil {
+				errs = append(errs, fmt.Errorf("removing module %s: %w", module, err))
+				continue
+			}
+			removeEmptyParents(root, dir)
+		}
+		klog.V(2).InfoS("Removed module", "module", module, "bytes", size, "dryRun", j.opts.DryRun)
+		report.Modules = append(report.Modules, module)
+		report.Bytes += size
+	}
+	return errors.Join(errs...)
+}
+
+// collectVolumes removes the volume directories of pods that are gone
+func (j *Janitor) collectVolumes(used *inUse, report *Report) error {
+	entries, err := os.ReadDir(j.opts.VolumeDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range entries {
+		if !e.IsDir() || used.podDirs[e.Name()] {
+			continue
+		}
+		dir := filepath.Join(j.opts.VolumeDir, e.Name())
+		size, newest, err := scan(dir)
+		if err != nil {
+			// Most likely something mounted in the directory; removing it
+			// would delete what is on the mounted filesystem
+			klog.InfoS("Leaving orphaned volume directory", "dir", dir, "reason", err.Error())
+			report.Skipped = append(report.Skipped, dir)
+			continue
+		}
+		if j.now().Sub(newest) < j.opts.MinAge {
+			continue
+		}
+		if !j.opts.DryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				errs = append(errs, fmt.Errorf("removing volume directory %s: %w", dir, err))
+				continue
+			}
+		}
+		klog.V(2).InfoS("Removed volume directory", "dir", dir, "bytes", size, "dryRun", j.opts.DryRun)
+		report.Volumes = append(report.Volumes, dir)
+		report.Bytes += size
+	}
+	return errors.Join(errs...)
+}
+
+// collectLogs removes log and stats files of containers whose pods are gone
+func (j *Janitor) collectLogs(used *inUse, report *Report) error {
+	entries, err := os.ReadDir(j.opts.LogDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range entries {
+		// Files are named <pod>_<namespace>_<container>-<random>.log, with a
+		// <pod>_<namespace>_<container>-<random>.stats.json beside a running
+		// container's; neither names may hold an underscore
+		parts := strings.SplitN(e.Name(), "_", 3)
+		if e.IsDir() || len(parts) != 3 || used.pods[parts[1]+"/"+parts[0]] {
+			continue
+		}
+		if !strings.HasSuffix(e.Name(), ".log") && !strings.HasSuffix(e.Name(), ".stats.json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || j.now().Sub(info.ModTime()) < j.opts.MinAge {
+			continue
+		}
+		p := filepath.Join(j.opts.LogDir, e.Name())
+		if !j.opts.DryRun {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		klog.V(2).InfoS("Removed log file", "file", p, "bytes", info.Size(), "dryRun", j.opts.DryRun)
+		report.Logs = append(report.Logs, p)
+		report.Bytes += info.Size()
+	}
+	return errors.Join(errs...)
+}
+
+// removeFiles removes the named files from the directory
+func removeFiles(dir string, names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents up to root for as long as
+// they are empty
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// scan returns the size of the files under dir and when any of it last
+// changed. It fails if anything under dir is on another filesystem.
+func scan(dir string) (int64, time.Time, error) {
+	rootDev, err := device(dir)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var size int64
+	var newest time.Time
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if d.IsDir() {
+			dev, err := device(p)
+			if err != nil {
+				return err
+			}
+			if dev != rootDev {
+				return fmt.Errorf("%s is a mount point", p)
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, newest, err
+}
@@ -0,0 +1,231 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/krustlet/krustlet/pkg/modulestore"
+)
+
+var now = time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+
+// writeFile writes a file, creating its directory, that last changed age ago
+// along with the directory
+func writeFile(t *testing.T, path string, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, filepath.Dir(path)} {
+		if err := os.Chtimes(p, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeModule writes a module into the store at <registry>/<repository>/<tag>
+func writeModule(t *testing.T, root, rel string, age time.Duration) {
+	t.Helper()
+	writeFile(t, filepath.Join(root, rel, modulestore.ModuleFile), age)
+	writeFile(t, filepath.Join(root, rel, modulestore.DigestFile), age)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+type fixture struct {
+	janitor *Janitor
+	opts    Options
+}
+
+func newFixture(t *testing.T, pods ...*corev1.Pod) *fixture {
+	dir := t.TempDir()
+	opts := Options{
+		ModuleDir: filepath.Join(dir, ".oci", "modules"),
+		VolumeDir: filepath.Join(dir, "volumes"),
+		LogDir:    filepath.Join(dir, "wasi-logs"),
+		MinAge:    time.Hour,
+	}
+	client := fake.NewSimpleClientset()
+	for _, pod := range pods {
+		if _, err := client.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j := New(client, "krustlet", opts)
+	j.now = func() time.Time { return now }
+	return &fixture{janitor: j, opts: opts}
+}
+
+func (f *fixture) collect(t *testing.T) *Report {
+	t.Helper()
+	report, err := f.janitor.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func pod(name, image string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName:   "krustlet",
+			Containers: []corev1.Container{{Name: "app", Image: image}},
+		},
+	}
+}
+
+func TestCollectModules(t *testing.T) {
+	f := newFixture(t, pod("app", "webassembly.azurecr.io/hello-wasm:v1"), pod("web", "wagi-app"))
+	root := f.opts.ModuleDir
+	writeModule(t, root, "webassembly.azurecr.io/hello-wasm/v1", 48*time.Hour)
+	writeModule(t, root, "webassembly.azurecr.io/hello-wasm/v0", 48*time.Hour)
+	writeModule(t, root, "docker.io/library/wagi-app/latest", 48*time.Hour)
+	writeModule(t, root, "localhost:5000/old/module/v2", 48*time.Hour)
+	writeModule(t, root, "ghcr.io/krustlet/pinned/v1", 48*time.Hour)
+	writeModule(t, root, "ghcr.io/krustlet/new/v1", time.Minute)
+	f.janitor.opts.Keep = []string{"ghcr.io/krustlet/pinned"}
+
+	report := f.collect(t)
+	sort.Strings(report.Modules)
+	want := []string{"localhost:5000/old/module:v2", "webassembly.azurecr.io/hello-wasm:v0"}
+	if len(report.Modules) != len(want) || report.Modules[0] != want[0] || report.Modules[1] != want[1] {
+		t.Fatalf("got %v, want %v", report.Modules, want)
+	}
+	if report.Bytes != 16 {
+		t.Errorf("got %d bytes, want 16", report.Bytes)
+	}
+	for rel, want := range map[string]bool{
+		"webassembly.azurecr.io/hello-wasm/v1": true,
+		"webassembly.azurecr.io/hello-wasm/v0": false,
+		"docker.io/library/wagi-app/latest":    true,
+		"ghcr.io/krustlet/pinned/v1":           true,
+		"ghcr.io/krustlet/new/v1":              true,
+	} {
+		if got := exists(filepath.Join(root, rel, modulestore.ModuleFile)); got != want {
+			t.Errorf("%s: got exists %v, want %v", rel, got, want)
+		}
+	}
+	// Directories emptied by the removal go with it
+	if exists(filepath.Join(root, "localhost:5000")) {
+		t.Error("expected the empty registry directory to be removed")
+	}
+	if !exists(root) {
+		t.Error("expected the store itself to be kept")
+	}
+}
+
+func TestKeep(t *testing.T) {
+	j := &Janitor{opts: Options{Keep: []string{"ghcr.io/krustlet/*:v1", "localhost:5000/pinned"}}}
+	for rel, want := range map[string]bool{
+		"ghcr.io/krustlet/app/v1":     true,
+		"ghcr.io/krustlet/app/v2":     false,
+		"localhost:5000/pinned/v9":    true,
+		"localhost:5000/unpinned/v9":  false,
+		"docker.io/library/hello/1.0": false,
+	} {
+		if got := j.kept(filepath.FromSlash(rel)); got != want {
+			t.Errorf("%s: got %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestCollectDryRun(t *testing.T) {
+	f := newFixture(t)
+	f.janitor.opts.DryRun = true
+	writeModule(t, f.opts.ModuleDir, "ghcr.io/krustlet/old/v1", 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.VolumeDir, "gone-default", "data"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.LogDir, "gone_default_app-abc123.log"), 48*time.Hour)
+
+	report := f.collect(t)
+	if len(report.Modules) != 1 || len(report.Volumes) != 1 || len(report.Logs) != 1 {
+		t.Fatalf("expected one of each to be reported, got %+v", report)
+	}
+	for _, p := range []string{
+		filepath.Join(f.opts.ModuleDir, "ghcr.io/krustlet/old/v1", modulestore.ModuleFile),
+		filepath.Join(f.opts.VolumeDir, "gone-default", "data"),
+		filepath.Join(f.opts.LogDir, "gone_default_app-abc123.log"),
+	} {
+		if !exists(p) {
+			t.Errorf("expected %s to be kept in a dry run", p)
+		}
+	}
+}
+
+func TestCollectVolumesAndLogs(t *testing.T) {
+	f := newFixture(t, pod("app", "hello-wasm"))
+	writeFile(t, filepath.Join(f.opts.VolumeDir, "app-default", "data"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.VolumeDir, "gone-default", "data"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.VolumeDir, "new-default", "data"), time.Minute)
+	writeFile(t, filepath.Join(f.opts.LogDir, "app_default_app-abc123.log"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.LogDir, "gone_default_app-abc123.log"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.LogDir, "app_default_app-abc123.stats.json"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.LogDir, "gone_default_app-abc123.stats.json"), 48*time.Hour)
+	writeFile(t, filepath.Join(f.opts.LogDir, "new_default_app-abc123.log"), time.Minute)
+	writeFile(t, filepath.Join(f.opts.LogDir, "unrelated.txt"), 48*time.Hour)
+
+	report := f.collect(t)
+	if len(report.Volumes) != 1 || report.Volumes[0] != filepath.Join(f.opts.VolumeDir, "gone-default") {
+		t.Errorf("unexpected volumes %v", report.Volumes)
+	}
+	if len(report.Logs) != 2 {
+		t.Errorf("unexpected logs %v", report.Logs)
+	}
+	for name, want := range map[string]bool{
+		"volumes/app-default":                          true,
+		"volumes/gone-default":                         false,
+		"volumes/new-default":                          true,
+		"wasi-logs/app_default_app-abc123.log":         true,
+		"wasi-logs/app_default_app-abc123.stats.json":  true,
+		"wasi-logs/gone_default_app-abc123.log":        false,
+		"wasi-logs/gone_default_app-abc123.stats.json": false,
+		"wasi-logs/new_default_app-abc123.log":         true,
+		"wasi-logs/unrelated.txt":                      true,
+	} {
+		p := filepath.Join(filepath.Dir(f.opts.VolumeDir), filepath.FromSlash(name))
+		if got := exists(p); got != want {
+			t.Errorf("%s: got exists %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCollectNothingWithoutPods(t *testing.T) {
+	f := newFixture(t)
+	writeModule(t, f.opts.ModuleDir, "ghcr.io/krustlet/old/v1", 48*time.Hour)
+	client := f.janitor.client.(*fake.Clientset)
+	client.PrependReactor("list", "pods", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("unauthorized")
+	})
+
+	if _, err := f.janitor.Collect(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !exists(filepath.Join(f.opts.ModuleDir, "ghcr.io/krustlet/old/v1", modulestore.ModuleFile)) {
+		t.Error("expected nothing to be removed when pods can't be listed")
+	}
+}
+
+func TestCollectMissingDirs(t *testing.T) {
+	f := newFixture(t)
+	report := f.collect(t)
+	if len(report.Modules)+len(report.Volumes)+len(report.Logs) != 0 {
+		t.Errorf("expected nothing to be removed, got %+v", report)
+	}
+}
@@ -0,0 +1,38 @@
+// Package kubeclient loads Kubernetes client configuration the same way for
+// every tool in this repository.
+package kubeclient
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// RESTConfig loads client configuration from the kubeconfig at path, or from
+// $KUBECONFIG and ~/.kube/config when path is empty. When no kubeconfig is
+// found, the in-cluster service account is used, so the same binary works
+// both from a workstation and as a pod.
+func RESTConfig(path, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		rules,
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	return cfg, nil
+}
+
+// NewClientset returns a clientset configured by RESTConfig, identifying
+// itself to the API server as userAgent
+func NewClientset(path, context, userAgent string) (kubernetes.Interface, error) {
+	cfg, err := RESTConfig(path, context)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(rest.AddUserAgent(cfg, userAgent))
+}
@@ -0,0 +1,179 @@
+package logship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatch Logs' limits on a PutLogEvents batch
+const (
+	cloudWatchMaxEvents     = 10000
+	cloudWatchMaxBatchBytes = 1048576
+	cloudWatchEventOverhead = 26
+)
+
+// CloudWatch sends records to a CloudWatch Logs log group, one log stream per
+// container, named <namespace>/<pod>/<container>. Streams are created as
+// needed; the group must already exist.
+type CloudWatch struct {
+	Region   string
+	LogGroup string
+	// Endpoint overrides the regional endpoint
+	Endpoint    string
+	Credentials AWSCredentials
+
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	streams map[string]bool
+}
+
+// NewCloudWatch returns a sink that writes to the log group in the region
+func NewCloudWatch(region, logGroup string, creds AWSCredentials) *CloudWatch {
+	return &CloudWatch{
+		Region:      region,
+		LogGroup:    logGroup,
+		Endpoint:    fmt.Sprintf("https://logs.%s.amazonaws.com/", region),
+		Credentials: creds,
+		client:      &http.Client{Timeout: httpTimeout},
+		now:         time.Now,
+		streams:     map[string]bool{},
+	}
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+type cloudWatchMessage struct {
+	Log        string           `json:"log"`
+	Kubernetes kubernetesFields `json:"kubernetes"`
+}
+
+// cloudWatchError is the error body CloudWatch Logs returns
+type cloudWatchError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *cloudWatchError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// Send implements Sink. Each line is sent as a JSON message with the
+// container's metadata.
+func (c *CloudWatch) Send(ctx context.Context, records []Record) error {
+	byStream := map[string][]cloudWatchEvent{}
+	var order []string
+	for i := range records {
+		r := &records[i]
+		stream := r.Namespace + "/" + r.Pod + "/" + r.Container
+		msg, err := json.Marshal(cloudWatchMessage{Log: r.Line, Kubernetes: r.kubernetes()})
+		if err != nil {
+			return err
+		}
+		if _, ok := byStream[stream]; !ok {
+			order = append(order, stream)
+		}
+		byStream[stream] = append(byStream[stream], cloudWatchEvent{Timestamp: r.Time.UnixMilli(), Message: string(msg)})
+	}
+	for _, stream := range order {
+		for _, batch := range cloudWatchBatches(byStream[stream]) {
+			if err := c.put(ctx, stream, batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cloudWatchBatches splits events into batches within PutLogEvents' limits
+func cloudWatchBatches(events []cloudWatchEvent) [][]cloudWatchEvent {
+	var batches [][]cloudWatchEvent
+	var batch []cloudWatchEvent
+	size := 0
+	for _, e := range events {
+		n := len(e.Message) + cloudWatchEventOverhead
+		if len(batch) == cloudWatchMaxEvents || (len(batch) > 0 && size+n > cloudWatchMaxBatchBytes) {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, e)
+		size += n
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// put writes events to the stream, creating it first if needed
+func (c *CloudWatch) put(ctx context.Context, stream string, events []cloudWatchEvent) error {
+	c.mu.Lock()
+	known := c.streams[stream]
+	c.mu.Unlock()
+	if !known {
+		err := c.call(ctx, "CreateLogStream", map[string]string{"logGroupName": c.LogGroup, "logStreamName": stream})
+		var cwErr *cloudWatchError
+		if err != nil && !(errors.As(err, &cwErr) && cwErr.Type == "ResourceAlreadyExistsException") {
+			return fmt.Errorf("creating log stream %s: %w", stream, err)
+		}
+		c.mu.Lock()
+		c.streams[stream] = true
+		c.mu.Unlock()
+	}
+	err := c.call(ctx, "PutLogEvents", map[string]interface{}{
+		"logGroupName":  c.LogGroup,
+		"logStreamName": stream,
+		"logEvents":     events,
+	})
+	var cwErr *cloudWatchError
+	if errors.As(err, &cwErr) && cwErr.Type == "ResourceNotFoundException" {
+		// The stream was deleted; create it again on the retry
+		c.mu.Lock()
+		delete(c.streams, stream)
+		c.mu.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("putting log events to %s: %w", stream, err)
+	}
+	return nil
+}
+
+// call makes a CloudWatch Logs API call
+func (c *CloudWatch) call(ctx context.Context, action string, input interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	signV4(req, body, c.Credentials, c.Region, "logs", c.now())
+
+	resp, err := post(c.client, req)
+	if err != nil {
+		cwErr := &cloudWatchError{}
+		if json.Unmarshal(resp, cwErr) == nil && cwErr.Type != "" {
+			// Types may be qualified, as in com.amazonaws.logs#ResourceNotFoundException
+			if i := strings.LastIndex(cwErr.Type, "#"); i >= 0 {
+				cwErr.Type = cwErr.Type[i+1:]
+			}
+			return cwErr
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package logship
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseLogName(t *testing.T) {
+	tests := []struct {
+		name string
+		want source
+		ok   bool
+	}{
+		{"hello_default_hello-world-a1b2c3.log", source{Namespace: "default", Pod: "hello", Container: "hello-world"}, true},
+		{"my-pod_kube-system_app-x7.log", source{Namespace: "kube-system", Pod: "my-pod", Container: "app"}, true},
+		{"hello_default_app.log", source{}, false},
+		{"hello_default.log", source{}, false},
+		{"hello_default_app-x.txt", source{}, false},
+		{".tmpAbc123", source{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseLogName(tt.name)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseLogName(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+type fakeSink struct {
+	records []Record
+	err     error
+}
+
+func (s *fakeSink) Send(_ context.Context, records []Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *fakeSink) lines() []string {
+	var lines []string
+	for _, r := range s.records {
+		lines = append(lines, r.Line)
+	}
+	s.records = nil
+	return lines
+}
+
+func newTestShipper(t *testing.T, sink Sink, pods ...*corev1.Pod) (*Shipper, string) {
+	t.Helper()
+	dir := t.TempDir()
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	s, err := New(factory, sink, Options{Dir: dir, NodeName: "krustlet", PositionsFile: filepath.Join(t.TempDir(), "positions.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer := factory.Core().V1().Pods().Informer().GetIndexer()
+	for _, pod := range pods {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s, dir
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func equal(a, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n") && len(a) == len(b)
+}
+
+func TestShip(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "1234", Labels: map[string]string{"app": "hello"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "hello", Image: "webassembly.azurecr.io/hello:v1"}}},
+	}
+	sink := &fakeSink{}
+	s, dir := newTestShipper(t, sink, pod)
+	ctx := context.Background()
+	path := filepath.Join(dir, "hello_default_hello-abc.log")
+
+	appendFile(t, path, "one\ntwo\nthr")
+	if err := s.ship(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := sink.records[0]
+	if r.Node != "krustlet" || r.Namespace != "default" || r.Pod != "hello" || r.Container != "hello" ||
+		r.PodUID != "1234" || r.Image != "webassembly.azurecr.io/hello:v1" || r.Labels["app"] != "hello" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if got := sink.lines(); !equal(got, []string{"one", "two"}) {
+		t.Errorf("got lines %q", got)
+	}
+
+	// Failed sends are retried
+	appendFile(t, path, "ee\n")
+	sink.err = errors.New("unavailable")
+	if err := s.ship(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+	sink.err = nil
+	if err := s.ship(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.lines(); !equal(got, []string{"three"}) {
+		t.Errorf("got lines %q after retry", got)
+	}
+
+	// A new shipper carries on from the saved positions
+	appendFile(t, path, "four\nfive")
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	s2, err := New(factory, sink, s.opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.ship(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.lines(); !equal(got, []string{"four"}) {
+		t.Errorf("got lines %q after restart", got)
+	}
+
+	// The rest of a removed file is shipped, then the file is forgotten
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s2.ship(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.lines(); !equal(got, []string{"five"}) {
+		t.Errorf("got lines %q after removal", got)
+	}
+	if len(s2.files) != 0 {
+		t.Errorf("removed file is still tailed")
+	}
+}
+
+func testRecords() []Record {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	return []Record{
+		{Time: now, Line: "hello", Node: "krustlet", Namespace: "default", Pod: "hello", Container: "hello", PodUID: "1234", Labels: map[string]string{"app.kubernetes.io/name": "hello"}},
+		{Time: now, Line: "world", Node: "krustlet", Namespace: "default", Pod: "hello", Container: "hello", PodUID: "1234"},
+		{Time: now, Line: "other", Node: "krustlet", Namespace: "default", Pod: "other", Container: "main"},
+	}
+}
+
+func TestFluentd(t *testing.T) {
+	var path string
+	var got []fluentdRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewFluentd(server.URL+"/", "krustlet.logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(context.Background(), testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/krustlet.logs" {
+		t.Errorf("posted to %s", path)
+	}
+	if len(got) != 3 || got[0].Log != "hello" || got[0].Time != "2021-03-04T05:06:07Z" ||
+		got[0].Kubernetes.PodName != "hello" || got[0].Kubernetes.PodID != "1234" || got[0].Kubernetes.Host != "krustlet" {
+		t.Errorf("unexpected records %+v", got)
+	}
+}
+
+func TestLoki(t *testing.T) {
+	var tenant string
+	var got struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" {
+			http.NotFound(w, r)
+			return
+		}
+		tenant = r.Header.Get("X-Scope-OrgID")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewLoki(server.URL, "team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.StructuredMetadata = true
+	if err := sink.Send(context.Background(), testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	if tenant != "team-a" {
+		t.Errorf("got tenant %q", tenant)
+	}
+	if len(got.Streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(got.Streams))
+	}
+	first := got.Streams[0]
+	if first.Stream["pod"] != "hello" || first.Stream["namespace"] != "default" || first.Stream["job"] != "krustlet" || len(first.Values) != 2 {
+		t.Errorf("unexpected stream %+v", first)
+	}
+	var meta map[string]string
+	if len(first.Values[0]) != 3 || json.Unmarshal(first.Values[0][2], &meta) != nil ||
+		meta["pod_uid"] != "1234" || meta["label_app_kubernetes_io_name"] != "hello" {
+		t.Errorf("unexpected structured metadata in %s", first.Values[0])
+	}
+	if len(got.Streams[1].Values[0]) != 2 {
+		t.Errorf("record without metadata has %d values", len(got.Streams[1].Values[0]))
+	}
+}
+
+func TestCloudWatch(t *testing.T) {
+	var actions []string
+	created := map[string]bool{}
+	events := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/20210304/us-west-2/logs/aws4_request") {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+		actions = append(actions, action)
+		body, _ := io.ReadAll(r.Body)
+		var input struct {
+			LogGroupName  string            `json:"logGroupName"`
+			LogStreamName string            `json:"logStreamName"`
+			LogEvents     []cloudWatchEvent `json:"logEvents"`
+		}
+		if err := json.Unmarshal(body, &input); err != nil {
+			t.Error(err)
+		}
+		if input.LogGroupName != "krustlet" {
+			t.Errorf("got log group %q", input.LogGroupName)
+		}
+		switch action {
+		case "CreateLogStream":
+			if created[input.LogStreamName] {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"ResourceAlreadyExistsException","message":"exists"}`))
+				return
+			}
+			created[input.LogStreamName] = true
+		case "PutLogEvents":
+			if !created[input.LogStreamName] {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"com.amazonaws.logs#ResourceNotFoundException","message":"no stream"}`))
+				return
+			}
+			events[input.LogStreamName] += len(input.LogEvents)
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	sink := NewCloudWatch("us-west-2", "krustlet", AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"})
+	sink.Endpoint = server.URL
+	sink.now = func() time.Time { return time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC) }
+	ctx := context.Background()
+	if err := sink.Send(ctx, testRecords()); err != nil {
+		t.Fatal(err)
+	}
+	if events["default/hello/hello"] != 2 || events["default/other/main"] != 1 {
+		t.Errorf("unexpected events %v", events)
+	}
+	want := "CreateLogStream,PutLogEvents,CreateLogStream,PutLogEvents"
+	if got := strings.Join(actions, ","); got != want {
+		t.Errorf("got calls %s, want %s", got, want)
+	}
+
+	// A deleted stream is created again on the next send
+	delete(created, "default/other/main")
+	if err := sink.Send(ctx, testRecords()[2:]); err == nil {
+		t.Fatal("expected an error for a deleted stream")
+	}
+	if err := sink.Send(ctx, testRecords()[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if events["default/other/main"] != 2 {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestCloudWatchBatches(t *testing.T) {
+	events := make([]cloudWatchEvent, cloudWatchMaxEvents+1)
+	if got := len(cloudWatchBatches(events)); got != 2 {
+		t.Errorf("got %d batches for too many events, want 2", got)
+	}
+	big := strings.Repeat("x", cloudWatchMaxBatchBytes/2)
+	events = []cloudWatchEvent{{Message: big}, {Message: big}, {Message: "small"}}
+	if got := len(cloudWatchBatches(events)); got != 2 {
+		t.Errorf("got %d batches for large events, want 2", got)
+	}
+}
@@ -0,0 +1,73 @@
+package logship
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Record is a line of output with the metadata of the container that wrote it
+type Record struct {
+	// Time is when the shipper read the line; krustlet doesn't record when
+	// it was written
+	Time      time.Time
+	Line      string
+	Node      string
+	Namespace string
+	Pod       string
+	Container string
+	// PodUID, Labels and Image are empty if the pod was never seen
+	PodUID string
+	Labels map[string]string
+	Image  string
+}
+
+// Sink sends records to a log backend. Records are sent in the order they
+// were read, and sent again if Send fails.
+type Sink interface {
+	Send(ctx context.Context, records []Record) error
+}
+
+// podMeta is what records are enriched with from the pod
+type podMeta struct {
+	uid    string
+	labels map[string]string
+	images map[string]string
+}
+
+func newPodMeta(pod *corev1.Pod) *podMeta {
+	m := &podMeta{uid: string(pod.UID), labels: pod.Labels, images: map[string]string{}}
+	for _, c := range pod.Spec.InitContainers {
+		m.images[c.Name] = c.Image
+	}
+	for _, c := range pod.Spec.Containers {
+		m.images[c.Name] = c.Image
+	}
+	return m
+}
+
+// kubernetesFields is the metadata of a record in the shape Fluentd's and
+// Fluent Bit's Kubernetes filters use, so existing dashboards and queries
+// work for wasm pods too
+type kubernetesFields struct {
+	NamespaceName  string            `json:"namespace_name"`
+	PodName        string            `json:"pod_name"`
+	PodID          string            `json:"pod_id,omitempty"`
+	ContainerName  string            `json:"container_name"`
+	ContainerImage string            `json:"container_image,omitempty"`
+	Host           string            `json:"host"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+func (r *Record) kubernetes() kubernetesFields {
+	return kubernetesFields{
+		NamespaceName:  r.Namespace,
+		PodName:        r.Pod,
+		PodID:          r.PodUID,
+		ContainerName:  r.Container,
+		ContainerImage: r.Image,
+		Host:           r.Node,
+		Labels:         r.Labels,
+	}
+}
@@ -0,0 +1,165 @@
+package logship
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// DefaultInterval is how often log files are read
+const DefaultInterval = time.Second
+
+// Options configure a Shipper
+type Options struct {
+	// Dir is the WASI provider's log directory
+	Dir string
+	// NodeName is added to every record
+	NodeName string
+	// PositionsFile records how far each file was shipped. Output is shipped
+	// again from the start of each file after a restart if it is empty.
+	PositionsFile string
+	Interval      time.Duration
+}
+
+// Shipper tails the log files in a directory and sends their lines to a sink
+type Shipper struct {
+	opts   Options
+	sink   Sink
+	pods   corelisters.PodLister
+	synced cache.InformerSynced
+	files  map[string]*tailedFile
+	pos    *positions
+	now    func() time.Time
+}
+
+// New returns a shipper that gets pod metadata from the informer factory,
+// which should only list pods on the node. The factory must be started by the
+// caller.
+func New(factory informers.SharedInformerFactory, sink Sink, opts Options) (*Shipper, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	pos, err := loadPositions(opts.PositionsFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading positions: %w", err)
+	}
+	informer := factory.Core().V1().Pods()
+	return &Shipper{
+		opts:   opts,
+		sink:   sink,
+		pods:   informer.Lister(),
+		synced: informer.Informer().HasSynced,
+		files:  map[string]*tailedFile{},
+		pos:    pos,
+		now:    time.Now,
+	}, nil
+}
+
+// Run ships logs until the context is cancelled
+func (s *Shipper) Run(ctx context.Context) error {
+	klog.Info("Waiting for pod informer to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), s.synced) {
+		return fmt.Errorf("timed out waiting for the pod informer to sync")
+	}
+	klog.InfoS("Shipping logs", "dir", s.opts.Dir, "node", s.opts.NodeName)
+	defer func() {
+		for _, f := range s.files {
+			f.file.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.ship(ctx); err != nil {
+			klog.ErrorS(err, "Failed to ship logs, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// ship sends what was written since the last call. Offsets only move on once
+// the sink has accepted the lines, so nothing is lost if it fails.
+func (s *Shipper) ship(ctx context.Context) error {
+	if err := scan(s.opts.Dir, s.files, s.pos); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := s.now()
+	var chunks []*chunk
+	var records []Record
+	for _, name := range names {
+		f := s.files[name]
+		c, err := f.read()
+		if err != nil {
+			klog.ErrorS(err, "Failed to read log file", "file", name)
+			continue
+		}
+		if c.next == f.offset {
+			continue
+		}
+		chunks = append(chunks, c)
+		meta := s.meta(f)
+		for _, line := range c.lines {
+			r := Record{
+				Time:      now,
+				Line:      line,
+				Node:      s.opts.NodeName,
+				Namespace: f.src.Namespace,
+				Pod:       f.src.Pod,
+				Container: f.src.Container,
+			}
+			if meta != nil {
+				r.PodUID, r.Labels, r.Image = meta.uid, meta.labels, meta.images[f.src.Container]
+			}
+			records = append(records, r)
+		}
+	}
+
+	if len(records) > 0 {
+		if err := s.sink.Send(ctx, records); err != nil {
+			return err
+		}
+		klog.V(4).InfoS("Shipped log lines", "lines", len(records))
+	}
+	for _, c := range chunks {
+		c.file.offset = c.next
+	}
+	for name, f := range s.files {
+		if f.done() {
+			f.file.Close()
+			delete(s.files, name)
+		}
+	}
+	return s.pos.save(s.files)
+}
+
+// meta returns the metadata of the file's pod, remembering it for after the
+// pod is deleted
+func (s *Shipper) meta(f *tailedFile) *podMeta {
+	pod, err := s.pods.Pods(f.src.Namespace).Get(f.src.Pod)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to get pod", "pod", f.src.Namespace+"/"+f.src.Pod)
+		}
+		return f.meta
+	}
+	f.meta = newPodMeta(pod)
+	return f.meta
+}
@@ -0,0 +1,182 @@
+package logship
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpTimeout bounds each request to a backend
+const httpTimeout = 30 * time.Second
+
+// post sends a request and returns an error with the response body if it
+// doesn't succeed
+func post(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return body, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// Fluentd sends records to Fluentd's in_http input, or anything that accepts
+// the same requests, such as Fluent Bit's http input
+type Fluentd struct {
+	// URL is the input's address; the tag is appended as the path
+	URL string
+	Tag string
+
+	client *http.Client
+}
+
+// NewFluentd returns a sink that posts records to the Fluentd HTTP input at
+// rawURL with the tag
+func NewFluentd(rawURL, tag string) (*Fluentd, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	return &Fluentd{URL: strings.TrimSuffix(rawURL, "/"), Tag: tag, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+type fluentdRecord struct {
+	Time       string           `json:"time"`
+	Log        string           `json:"log"`
+	Kubernetes kubernetesFields `json:"kubernetes"`
+}
+
+// Send implements Sink. Records are posted as one JSON array; set time_key
+// time and time_type string in the input to use the read time.
+func (f *Fluentd) Send(ctx context.Context, records []Record) error {
+	batch := make([]fluentdRecord, 0, len(records))
+	for i := range records {
+		r := &records[i]
+		batch = append(batch, fluentdRecord{Time: r.Time.UTC().Format(time.RFC3339Nano), Log: r.Line, Kubernetes: r.kubernetes()})
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL+"/"+url.PathEscape(f.Tag), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = post(f.client, req)
+	return err
+}
+
+// Loki sends records to Loki's push API. Each container is its own stream,
+// labelled with its namespace, pod, container and node. Pod labels are never
+// made stream labels, to keep the number of streams down.
+type Loki struct {
+	// URL is Loki's address, without the API path
+	URL string
+	// TenantID is sent as X-Scope-OrgID if set
+	TenantID string
+	// StructuredMetadata sends the pod's UID, image and labels as structured
+	// metadata, which needs Loki 2.9 or later with it enabled
+	StructuredMetadata bool
+
+	client *http.Client
+}
+
+// NewLoki returns a sink that pushes records to the Loki at rawURL
+func NewLoki(rawURL, tenantID string) (*Loki, error) {
+	if _, err := url.Parse(rawURL); err != nil {
+		return nil, err
+	}
+	return &Loki{URL: strings.TrimSuffix(rawURL, "/"), TenantID: tenantID, client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// Send implements Sink
+func (l *Loki) Send(ctx context.Context, records []Record) error {
+	streams := map[source]*lokiStream{}
+	var order []source
+	for i := range records {
+		r := &records[i]
+		src := source{Namespace: r.Namespace, Pod: r.Pod, Container: r.Container}
+		s, ok := streams[src]
+		if !ok {
+			s = &lokiStream{Stream: map[string]string{
+				"job":       "krustlet",
+				"namespace": r.Namespace,
+				"pod":       r.Pod,
+				"container": r.Container,
+				"node":      r.Node,
+			}}
+			streams[src] = s
+			order = append(order, src)
+		}
+		value := []interface{}{strconv.FormatInt(r.Time.UnixNano(), 10), r.Line}
+		if meta := lokiMetadata(r); l.StructuredMetadata && len(meta) > 0 {
+			value = append(value, meta)
+		}
+		s.Values = append(s.Values, value)
+	}
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, src := range order {
+		push.Streams = append(push.Streams, streams[src])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.TenantID)
+	}
+	_, err = post(l.client, req)
+	return err
+}
+
+// lokiMetadata returns the record's structured metadata: the pod's UID, the
+// container's image and the pod's labels
+func lokiMetadata(r *Record) map[string]string {
+	meta := map[string]string{}
+	if r.PodUID != "" {
+		meta["pod_uid"] = r.PodUID
+	}
+	if r.Image != "" {
+		meta["image"] = r.Image
+	}
+	for k, v := range r.Labels {
+		meta["label_"+sanitizeLabel(k)] = v
+	}
+	return meta
+}
+
+// sanitizeLabel makes a Kubernetes label key a valid Loki label name
+func sanitizeLabel(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, k)
+}
@@ -0,0 +1,185 @@
+// Package logship ships the output of wasm pods on a krustlet node to log
+// backends.
+//
+// Log collectors such as Fluent Bit find container logs under
+// /var/log/containers, where container runtimes write them. Krustlet writes
+// each container's output to its own file in the WASI provider's log
+// directory instead, named <pod>_<namespace>_<container>-<random>.log. The
+// shipper tails those files, adds the pod's Kubernetes metadata to every
+// line and sends them to Fluentd, Loki or CloudWatch Logs.
+package logship
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxRead bounds how much of a file is read for one batch
+const maxRead = 1 << 20
+
+// source is the container a log file belongs to
+type source struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+// parseLogName returns the container a log file name belongs to
+func parseLogName(name string) (source, bool) {
+	base, ok := strings.CutSuffix(name, ".log")
+	if !ok {
+		return source{}, false
+	}
+	parts := strings.SplitN(base, "_", 3)
+	if len(parts) != 3 {
+		return source{}, false
+	}
+	i := strings.LastIndex(parts[2], "-")
+	if parts[0] == "" || parts[1] == "" || i <= 0 {
+		return source{}, false
+	}
+	return source{Namespace: parts[1], Pod: parts[0], Container: parts[2][:i]}, true
+}
+
+// tailedFile is a log file being read. The file is kept open so output
+// written just before krustlet deletes it can still be read.
+type tailedFile struct {
+	name   string
+	src    source
+	file   *os.File
+	offset int64
+	// removed is set once the file is no longer in the directory
+	removed bool
+	// meta is the pod's metadata when it was last seen, for lines read
+	// after the pod is deleted
+	meta *podMeta
+}
+
+// chunk is output read from a file, not yet shipped
+type chunk struct {
+	file  *tailedFile
+	lines []string
+	// next is the file offset after the chunk
+	next int64
+}
+
+// read returns the complete lines after the file's offset. Once the file is
+// removed, a final line without a newline is returned too.
+func (t *tailedFile) read() (*chunk, error) {
+	buf := make([]byte, maxRead)
+	n, err := t.file.ReadAt(buf, t.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	buf = buf[:n]
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	if end == 0 && (t.removed || n == maxRead) && n > 0 {
+		// Ship a line that will never be finished, or is too long to
+		// buffer, as it is
+		end = n
+	}
+	c := &chunk{file: t, next: t.offset + int64(end)}
+	for _, line := range strings.Split(string(buf[:end]), "\n") {
+		if line = strings.TrimSuffix(line, "\r"); line != "" {
+			c.lines = append(c.lines, line)
+		}
+	}
+	return c, nil
+}
+
+// done reports whether everything in a removed file has been shipped
+func (t *tailedFile) done() bool {
+	if !t.removed {
+		return false
+	}
+	info, err := t.file.Stat()
+	return err != nil || t.offset >= info.Size()
+}
+
+// positions records how far each file has been shipped, so a restarted
+// shipper carries on where it left off
+type positions struct {
+	path    string
+	offsets map[string]int64
+}
+
+func loadPositions(path string) (*positions, error) {
+	p := &positions{path: path, offsets: map[string]int64{}}
+	if path == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.offsets); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// save writes the offsets of the files still being tailed
+func (p *positions) save(files map[string]*tailedFile) error {
+	p.offsets = make(map[string]int64, len(files))
+	for name, f := range files {
+		p.offsets[name] = f.offset
+	}
+	if p.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(p.offsets)
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// scan opens log files that appeared in dir and marks those that went away
+func scan(dir string, files map[string]*tailedFile, pos *positions) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	present := map[string]bool{}
+	for _, e := range entries {
+		src, ok := parseLogName(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		present[e.Name()] = true
+		if _, ok := files[e.Name()]; ok {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		t := &tailedFile{name: e.Name(), src: src, file: f, offset: pos.offsets[e.Name()]}
+		if info, err := f.Stat(); err == nil && info.Size() < t.offset {
+			t.offset = 0
+		}
+		files[e.Name()] = t
+	}
+	for name, t := range files {
+		if !present[name] {
+			t.removed = true
+		}
+	}
+	return nil
+}
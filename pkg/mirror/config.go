@@ -0,0 +1,186 @@
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// DefaultInterval is how often repositories are checked for changes unless
+// the config sets another interval
+const DefaultInterval = 5 * time.Minute
+
+// Config says what to replicate and where
+type Config struct {
+	// Interval is how often the source repositories are checked
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// Repositories are the source repositories replicated to every site
+	Repositories []Repository `json:"repositories"`
+	// Sites are the edge registries replicated to
+	Sites []Site `json:"sites"`
+}
+
+// Repository is a source repository and the tags of it to replicate
+type Repository struct {
+	// Source is the repository on the central registry, such as
+	// registry.example.com/wasm/app
+	Source string `json:"source"`
+	// Tags are path.Match patterns of the tags to replicate. Every tag is
+	// replicated if there are none.
+	Tags []string `json:"tags,omitempty"`
+	// Destination is the repository path on the edge registries. It
+	// defaults to the source's path.
+	Destination string `json:"destination,omitempty"`
+
+	source oci.Reference
+}
+
+// Site is an edge registry
+type Site struct {
+	// Name identifies the site in logs and reports
+	Name string `json:"name"`
+	// Registry is the host of the site's registry, such as
+	// registry.store-42.example.com:5000
+	Registry string `json:"registry"`
+	// Bandwidth caps the rate modules are uploaded to the site at, such as
+	// 10Mi for 10 MiB/s. Uploads are not limited if it is unset.
+	Bandwidth *resource.Quantity `json:"bandwidth,omitempty"`
+	// Windows are the times of day the site may be synced in, in the
+	// daemon's local time. The site is synced at any time if there are none.
+	Windows []Window `json:"windows,omitempty"`
+}
+
+// Window is a daily period, from Start to End in HH:MM form. A window that
+// ends before it starts runs past midnight.
+type Window struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	start, end time.Duration
+}
+
+// LoadConfig reads and validates a mirror config file, which may be YAML or
+// JSON
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mirror config %s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the config and fills in defaults
+func (cfg *Config) Validate() error {
+	if cfg.Interval.Duration == 0 {
+		cfg.Interval.Duration = DefaultInterval
+	}
+	if cfg.Interval.Duration < 0 {
+		return errors.New("interval must be positive")
+	}
+	if len(cfg.Repositories) == 0 {
+		return errors.New("at least one repository is required")
+	}
+	if len(cfg.Sites) == 0 {
+		return errors.New("at least one site is required")
+	}
+	for i := range cfg.Repositories {
+		r := &cfg.Repositories[i]
+		src, err := oci.ParseRepository(r.Source)
+		if err != nil {
+			return fmt.Errorf("repository %q: %w", r.Source, err)
+		}
+		r.source = src
+		if r.Destination == "" {
+			r.Destination = src.Repository
+		}
+		for _, p := range r.Tags {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("repository %q: invalid tag pattern %q", r.Source, p)
+			}
+		}
+	}
+	names := map[string]bool{}
+	for i := range cfg.Sites {
+		s := &cfg.Sites[i]
+		if s.Name == "" || s.Registry == "" {
+			return errors.New("every site needs a name and a registry")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("site %q is configured more than once", s.Name)
+		}
+		names[s.Name] = true
+		if strings.Contains(s.Registry, "/") {
+			return fmt.Errorf("site %q: registry must be a host, got %q", s.Name, s.Registry)
+		}
+		if s.Bandwidth != nil && s.Bandwidth.Sign() <= 0 {
+			return fmt.Errorf("site %q: bandwidth must be positive", s.Name)
+		}
+		for j := range s.Windows {
+			w := &s.Windows[j]
+			var err error
+			if w.start, err = parseClock(w.Start); err != nil {
+				return fmt.Errorf("site %q: %w", s.Name, err)
+			}
+			if w.end, err = parseClock(w.End); err != nil {
+				return fmt.Errorf("site %q: %w", s.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock parses a time of day in HH:MM form into the time since midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: must be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// open reports whether the site may be synced at the time
+func (s *Site) open(now time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	for _, w := range s.Windows {
+		if w.start <= w.end {
+			if clock >= w.start && clock < w.end {
+				return true
+			}
+		} else if clock >= w.start || clock < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the repository replicates the tag
+func (r *Repository) matches(tag string) bool {
+	if len(r.Tags) == 0 {
+		return true
+	}
+	for _, p := range r.Tags {
+		if ok, _ := path.Match(p, tag); ok {
+			return true
+		}
+	}
+	return false
+}
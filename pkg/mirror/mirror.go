@@ -0,0 +1,214 @@
+// Package mirror replicates wasm module repositories from a central registry
+// to registries at edge sites, so krustlet nodes there can pull modules
+// without reaching the central registry at deploy time.
+//
+// Each sync lists the tags of every configured source repository, keeps the
+// ones that match its patterns, and resolves them to manifest digests. A tag
+// is copied to a site only when the site's registry doesn't already have it
+// at the same digest, so a sync that finds nothing new costs a few HEAD
+// requests. Copies preserve digests, so pods that pin modules by digest pull
+// the same module at every site. Sites are synced concurrently. Uploads to a
+// site can be limited to a bandwidth and to windows of the day, such as
+// overnight when the site's link is idle; copies pending when a window closes
+// wait for the next one.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// Options configure how a Mirror talks to registries
+type Options struct {
+	// ClientOptions configure the registry clients, such as their
+	// credentials. HTTP clients set here are replaced; use Transport.
+	ClientOptions []oci.Option
+	// Transport sends requests, and defaults to http.DefaultTransport
+	Transport http.RoundTripper
+	// DryRun reports what would be copied without copying it
+	DryRun bool
+}
+
+// Report is what a sync did
+type Report struct {
+	Sites []SiteReport
+	// Errors are failures to read the source repositories. Tags of
+	// repositories that couldn't be read are left alone at every site.
+	Errors []string
+}
+
+// SiteReport is what a sync did at one site
+type SiteReport struct {
+	Name string
+	// Copied are the references copied to the site, or that would be in a
+	// dry run, with their digests
+	Copied []string
+	// UpToDate is how many tags the site already had at the right digest
+	UpToDate int
+	// Deferred is how many tags weren't checked because the site is
+	// outside its sync windows
+	Deferred int
+	// Bytes is how much was uploaded to the site
+	Bytes  int64
+	Errors []string
+}
+
+// Mirror replicates repositories to sites
+type Mirror struct {
+	cfg    *Config
+	source *oci.Client
+	sites  []*site
+	dryRun bool
+	now    func() time.Time
+}
+
+// site is a site and the client that uploads to it
+type site struct {
+	*Site
+	client    *oci.Client
+	transport *throttledTransport
+}
+
+// New returns a mirror for a validated config
+func New(cfg *Config, opts Options) *Mirror {
+	base := opts.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	m := &Mirror{
+		cfg:    cfg,
+		source: oci.NewClient(append(opts.ClientOptions, oci.WithHTTPClient(&http.Client{Transport: base}))...),
+		dryRun: opts.DryRun,
+		now:    time.Now,
+	}
+	for i := range cfg.Sites {
+		s := &cfg.Sites[i]
+		t := newThrottledTransport(base, s.Registry, s.Bandwidth)
+		m.sites = append(m.sites, &site{
+			Site:      s,
+			client:    oci.NewClient(append(opts.ClientOptions, oci.WithHTTPClient(&http.Client{Transport: t}))...),
+			transport: t,
+		})
+	}
+	return m
+}
+
+// target is a source tag to replicate
+type target struct {
+	repo   *Repository
+	tag    string
+	digest string
+}
+
+// Sync replicates every matching tag to every site, and returns what it did.
+// The error joins every failure in the report.
+func (m *Mirror) Sync(ctx context.Context) (*Report, error) {
+	report := &Report{}
+	var targets []target
+	for i := range m.cfg.Repositories {
+		r := &m.cfg.Repositories[i]
+		found, err := m.resolve(ctx, r)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", r.Source, err))
+		}
+		targets = append(targets, found...)
+	}
+
+	report.Sites = make([]SiteReport, len(m.sites))
+	var wg sync.WaitGroup
+	for i, s := range m.sites {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Sites[i] = m.syncSite(ctx, s, targets)
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, e := range report.Errors {
+		errs = append(errs, errors.New(e))
+	}
+	for _, s := range report.Sites {
+		for _, e := range s.Errors {
+			errs = append(errs, fmt.Errorf("site %s: %s", s.Name, e))
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// resolve lists the repository's matching tags and their digests. Tags that
+// fail to resolve are left out and reported.
+func (m *Mirror) resolve(ctx context.Context, r *Repository) ([]target, error) {
+	tags, err := m.source.Tags(ctx, r.source)
+	if err != nil {
+		return nil, fmt.Errorf("listing tags: %w", err)
+	}
+	sort.Strings(tags)
+	var out []target
+	var errs []error
+	for _, tag := range tags {
+		if !r.matches(tag) {
+			continue
+		}
+		ref := r.source
+		ref.Tag = tag
+		desc, err := m.source.Resolve(ctx, ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("resolving %s: %w", tag, err))
+			continue
+		}
+		out = append(out, target{repo: r, tag: tag, digest: desc.Digest})
+	}
+	return out, errors.Join(errs...)
+}
+
+// syncSite copies the targets the site doesn't have yet
+func (m *Mirror) syncSite(ctx context.Context, s *site, targets []target) (report SiteReport) {
+	report.Name = s.Name
+	start := s.transport.uploaded()
+	defer func() { report.Bytes = s.transport.uploaded() - start }()
+
+	for i, t := range targets {
+		if ctx.Err() != nil {
+			report.Errors = append(report.Errors, ctx.Err().Error())
+			return report
+		}
+		// The window is checked before every tag, so a long sync stops
+		// when the window closes rather than running on into the day
+		if !s.open(m.now()) {
+			report.Deferred = len(targets) - i
+			return report
+		}
+		src := t.repo.source
+		src.Tag = t.tag
+		dst := oci.Reference{Registry: s.Registry, Repository: t.repo.Destination, Tag: t.tag}
+
+		current, err := s.client.Resolve(ctx, dst)
+		if err == nil && current.Digest == t.digest {
+			report.UpToDate++
+			continue
+		}
+		if err != nil && !errors.Is(err, oci.ErrNotFound) {
+			report.Errors = append(report.Errors, fmt.Sprintf("checking %s: %v", dst, err))
+			continue
+		}
+		// Copy by digest, so a tag moved at the source since it was resolved
+		// isn't copied under the old tag's name with the new content
+		if !m.dryRun {
+			if _, err := s.client.Copy(ctx, src.WithDigest(t.digest), dst); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("copying %s: %v", dst, err))
+				continue
+			}
+		}
+		report.Copied = append(report.Copied, dst.String()+"@"+t.digest)
+	}
+	return report
+}
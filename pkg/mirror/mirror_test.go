@@ -0,0 +1,192 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+func pushModule(t *testing.T, client *oci.Client, ref string, content string) string {
+	t.Helper()
+	r, err := oci.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := client.Push(context.Background(), r, []byte("\x00asm\x01\x00\x00\x00"+content), oci.PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc.Digest
+}
+
+func TestSync(t *testing.T) {
+	central := ocitest.NewServer(t)
+	edge := ocitest.NewServer(t)
+	client := oci.NewClient(oci.WithPlainHTTP(central.Host(), edge.Host()))
+	pushModule(t, client, central.Ref("wasm/app:v1"), "one")
+	pushModule(t, client, central.Ref("wasm/app:v2"), "two")
+	pushModule(t, client, central.Ref("wasm/app:dev"), "dev")
+
+	cfg := &Config{
+		Repositories: []Repository{{Source: central.Ref("wasm/app"), Tags: []string{"v*"}, Destination: "mirror/app"}},
+		Sites:        []Site{{Name: "store-42", Registry: edge.Host(), Bandwidth: resource.NewQuantity(1<<20, resource.BinarySI)}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := New(cfg, Options{ClientOptions: []oci.Option{oci.WithPlainHTTP(central.Host(), edge.Host())}})
+	ctx := context.Background()
+
+	report, err := m.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site := report.Sites[0]
+	if len(site.Copied) != 2 || site.UpToDate != 0 || site.Bytes == 0 {
+		t.Fatalf("unexpected first sync %+v", site)
+	}
+	if _, err := client.Pull(ctx, oci.Reference{Registry: edge.Host(), Repository: "mirror/app", Tag: "v2"}); err != nil {
+		t.Errorf("expected v2 at the site: %v", err)
+	}
+	if _, err := client.Resolve(ctx, oci.Reference{Registry: edge.Host(), Repository: "mirror/app", Tag: "dev"}); err == nil {
+		t.Error("expected dev not to match the tag patterns")
+	}
+
+	// Nothing changed, so nothing is copied
+	report, err = m.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site := report.Sites[0]; len(site.Copied) != 0 || site.UpToDate != 2 {
+		t.Errorf("unexpected second sync %+v", site)
+	}
+
+	// Moving a tag at the source copies just that tag
+	digest := pushModule(t, client, central.Ref("wasm/app:v2"), "two again")
+	report, err = m.Sync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site := report.Sites[0]; len(site.Copied) != 1 || !strings.HasSuffix(site.Copied[0], "mirror/app:v2@"+digest) || site.UpToDate != 1 {
+		t.Errorf("unexpected sync after a push %+v", site)
+	}
+}
+
+func TestSyncWindows(t *testing.T) {
+	central := ocitest.NewServer(t)
+	edge := ocitest.NewServer(t)
+	client := oci.NewClient(oci.WithPlainHTTP(central.Host(), edge.Host()))
+	pushModule(t, client, central.Ref("wasm/app:v1"), "one")
+
+	cfg := &Config{
+		Repositories: []Repository{{Source: central.Ref("wasm/app")}},
+		Sites:        []Site{{Name: "store-42", Registry: edge.Host(), Windows: []Window{{Start: "22:00", End: "06:00"}}}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := New(cfg, Options{ClientOptions: []oci.Option{oci.WithPlainHTTP(central.Host(), edge.Host())}})
+	m.now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local) }
+	report, err := m.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site := report.Sites[0]; len(site.Copied) != 0 || site.Deferred != 1 {
+		t.Errorf("expected the copy to wait for the window, got %+v", site)
+	}
+
+	m.now = func() time.Time { return time.Date(2024, 5, 1, 2, 30, 0, 0, time.Local) }
+	report, err = m.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site := report.Sites[0]; len(site.Copied) != 1 || site.Deferred != 0 {
+		t.Errorf("expected the copy within the window, got %+v", site)
+	}
+}
+
+func TestSyncSiteDown(t *testing.T) {
+	central := ocitest.NewServer(t)
+	edge := ocitest.NewServer(t)
+	client := oci.NewClient(oci.WithPlainHTTP(central.Host(), edge.Host()))
+	pushModule(t, client, central.Ref("wasm/app:v1"), "one")
+	down := edge.Host()
+	edge.Close()
+	up := ocitest.NewServer(t)
+
+	cfg := &Config{
+		Repositories: []Repository{{Source: central.Ref("wasm/app")}},
+		Sites:        []Site{{Name: "down", Registry: down}, {Name: "up", Registry: up.Host()}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m := New(cfg, Options{ClientOptions: []oci.Option{oci.WithPlainHTTP(central.Host(), down, up.Host())}})
+	report, err := m.Sync(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "site down") {
+		t.Errorf("expected the unreachable site to be reported, got %v", err)
+	}
+	if len(report.Sites[0].Errors) != 1 || len(report.Sites[1].Copied) != 1 {
+		t.Errorf("expected the other site to be synced anyway, got %+v", report.Sites)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "mirror.yaml")
+	if err := os.WriteFile(file, []byte(`
+interval: 10m
+repositories:
+- source: registry.example.com/wasm/app
+  tags: ["v*", "stable"]
+sites:
+- name: store-42
+  registry: registry.store-42.example.com:5000
+  bandwidth: 10Mi
+  windows:
+  - start: "22:00"
+    end: "06:00"
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval.Duration != 10*time.Minute || cfg.Repositories[0].Destination != "wasm/app" || cfg.Sites[0].Bandwidth.Value() != 10<<20 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	site := &cfg.Sites[0]
+	for clock, open := range map[string]bool{"23:00": true, "02:00": true, "06:00": false, "12:00": false, "22:00": true} {
+		at, _ := time.ParseInLocation("15:04", clock, time.Local)
+		if site.open(at) != open {
+			t.Errorf("at %s: expected open %v", clock, open)
+		}
+	}
+	if !cfg.Repositories[0].matches("v1.2") || !cfg.Repositories[0].matches("stable") || cfg.Repositories[0].matches("dev") {
+		t.Error("unexpected tag matches")
+	}
+
+	for name, bad := range map[string]string{
+		"no sites":     "repositories: [{source: registry.example.com/app}]\nsites: []",
+		"bad window":   "repositories: [{source: registry.example.com/app}]\nsites: [{name: a, registry: r.example.com, windows: [{start: '25:00', end: '01:00'}]}]",
+		"bad pattern":  "repositories: [{source: registry.example.com/app, tags: ['[']}]\nsites: [{name: a, registry: r.example.com}]",
+		"path as host": "repositories: [{source: registry.example.com/app}]\nsites: [{name: a, registry: r.example.com/edge}]",
+		"unknown key":  "repositories: [{source: registry.example.com/app, tag: v1}]\nsites: [{name: a, registry: r.example.com}]",
+	} {
+		if err := os.WriteFile(file, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(file); err == nil {
+			t.Errorf("%s: expected the config to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// throttleChunk is the most bytes sent to a site in one go, which is also
+// the limiter's burst
+const throttleChunk = 32 << 10
+
+// throttledTransport limits and counts the bytes uploaded to one registry.
+// Requests to other registries, such as downloads from the source, pass
+// through untouched.
+type throttledTransport struct {
+	base    http.RoundTripper
+	host    string
+	limiter *rate.Limiter
+	sent    atomic.Int64
+}
+
+func newThrottledTransport(base http.RoundTripper, host string, bandwidth *resource.Quantity) *throttledTransport {
+	t := &throttledTransport{base: base, host: host}
+	if bandwidth != nil {
+		t.limiter = rate.NewLimiter(rate.Limit(bandwidth.Value()), throttleChunk)
+	}
+	return t
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host || req.Body == nil || req.Body == http.NoBody {
+		return t.base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they are given
+	req = req.Clone(req.Context())
+	req.Body = &throttledBody{ReadCloser: req.Body, ctx: req.Context(), t: t}
+	if getBody := req.GetBody; getBody != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return &throttledBody{ReadCloser: body, ctx: req.Context(), t: t}, nil
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// uploaded returns how many bytes have been sent to the registry
+func (t *throttledTransport) uploaded() int64 {
+	return t.sent.Load()
+}
+
+// throttledBody reads a request body no faster than the transport's limit
+type throttledBody struct {
+	io.ReadCloser
+	ctx context.Context
+	t   *throttledTransport
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.t.sent.Add(int64(n))
+		if b.t.limiter != nil {
+			if werr := b.t.limiter.WaitN(b.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,46 @@
+// Package modulestore describes the layout of krustlet's module store, where
+// krustlet keeps every module it pulls, for the tools that read and clean it
+// on the node. The layout is krustlet's FileStore, in
+// crates/kubelet/src/store/oci/file.rs.
+package modulestore
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// Files of a module in the store, kept at <registry>/<repository>/<tag>/
+// under the store's root. Krustlet writes the digest after the module, so
+// a module without one is still being pulled.
+const (
+	ModuleFile = "module.wasm"
+	DigestFile = "digest.txt"
+)
+
+// Dir returns the module store in krustlet's data directory
+func Dir(dataDir string) string {
+	return filepath.Join(dataDir, ".oci", "modules")
+}
+
+// Path returns where the store keeps a module, relative to its root.
+// Modules pulled by digest alone are stored under the latest tag.
+func Path(ref oci.Reference) string {
+	tag := ref.Tag
+	if tag == "" {
+		tag = oci.DefaultTag
+	}
+	return filepath.Join(ref.Registry, filepath.FromSlash(ref.Repository), tag)
+}
+
+// Name returns the reference of the module at a path relative to the
+// store's root, as <registry>/<repository>:<tag>
+func Name(rel string) string {
+	rel = filepath.ToSlash(rel)
+	i := strings.LastIndex(rel, "/")
+	if i < 0 {
+		return rel
+	}
+	return rel[:i] + ":" + rel[i+1:]
+}
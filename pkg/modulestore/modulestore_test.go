@@ -0,0 +1,23 @@
+package modulestore
+
+import (
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+func TestPathAndName(t *testing.T) {
+	for image, want := range map[string]string{
+		"ghcr.io/example/app:v1":      "ghcr.io/example/app:v1",
+		"hello":                       "docker.io/library/hello:latest",
+		"localhost:5000/app@sha256:1": "localhost:5000/app:latest",
+	} {
+		ref, err := oci.ParseReference(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Name(Path(ref)); got != want {
+			t.Errorf("%s: got %s, want %s", image, got, want)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package nodeapi is a client for the HTTPS API a krustlet node serves for
+// logs, exec and stats, the same API the API server calls when it proxies
+// kubectl logs and kubectl exec to a kubelet.
+//
+// A node can be reached directly, at the address and port it registered, or
+// through the API server's node proxy, which only needs the caller's usual
+// kubeconfig and permission to the nodes/proxy subresource.
+package nodeapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	statsv1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// ErrNotImplemented is returned for calls the node doesn't support. Krustlet
+// answers exec with 501 Not Implemented and doesn't serve stats unless
+// krustlet-stats-shim runs in front of it.
+var ErrNotImplemented = errors.New("not implemented by the node")
+
+// ResponseError is returned when the node responds with an unexpected status
+// code
+type ResponseError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Message is the start of the response body, which krustlet fills with
+	// a description of the error
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected status %d", e.Method, e.URL, e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is makes a 501 response match ErrNotImplemented
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrNotImplemented && e.StatusCode == http.StatusNotImplemented
+}
+
+// Client calls one node's API
+type Client struct {
+	base *url.URL
+	http *http.Client
+}
+
+// TLSOptions configure direct connections to a node
+type TLSOptions struct {
+	// CAFile or CAData is the CA that signed the node's serving certificate,
+	// normally the cluster CA. The system roots are used if both are empty.
+	CAFile string
+	CAData []byte
+	// CertFile and KeyFile are an optional client certificate. Krustlet
+	// doesn't ask for one, but an API server kubelet client certificate is
+	// accepted.
+	CertFile string
+	KeyFile  string
+	// Insecure skips verifying the node's certificate
+	Insecure bool
+}
+
+// config builds the client TLS configuration for the options
+func (o TLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.Insecure, //nolint:gosec
+	}
+	ca := o.CAData
+	if o.CAFile != "" {
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA: %w", err)
+		}
+		ca = data
+	}
+	if len(ca) > 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("CA contains no PEM certificates")
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// New returns a client that connects directly to the node API at addr, a
+// host:port
+func New(addr string, opts TLSOptions) (*Client, error) {
+	tlsConfig, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{
+		base: &url.URL{Scheme: "https", Host: addr},
+		http: &http.Client{Transport: transport},
+	}, nil
+}
+
+// ForNode returns a client that connects directly to the node, at the address
+// Address finds for it
+func ForNode(node *corev1.Node, opts TLSOptions) (*Client, error) {
+	addr, ok := Address(node)
+	if !ok {
+		return nil, fmt.Errorf("node %s has no address and kubelet port", node.Name)
+	}
+	return New(addr, opts)
+}
+
+// NewProxied returns a client that reaches the named node through the API
+// server's node proxy, authenticating with the REST configuration
+func NewProxied(cfg *rest.Config, nodeName string) (*Client, error) {
+	server, _, err := rest.DefaultServerUrlFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := rest.HTTPClientFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	base := *server
+	base.Path = strings.TrimSuffix(base.Path, "/") + "/api/v1/nodes/" + url.PathEscape(nodeName) + "/proxy"
+	return &Client{base: &base, http: httpClient}, nil
+}
+
+// Address returns the host:port of the node's API, from its internal IP, or
+// its hostname if it has none, and the kubelet endpoint it registered
+func Address(node *corev1.Node) (string, bool) {
+	port := node.Status.DaemonEndpoints.KubeletEndpoint.Port
+	if port == 0 {
+		return "", false
+	}
+	for _, want := range []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeHostName} {
+		for _, a := range node.Status.Addresses {
+			if a.Type == want && a.Address != "" {
+				return net.JoinHostPort(a.Address, strconv.Itoa(int(port))), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Healthz checks that the node's API is serving
+func (c *Client) Healthz(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, []string{"healthz"}, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Logs streams a container's logs. The caller must close the stream.
+func (c *Client) Logs(ctx context.Context, namespace, pod, container string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, []string{"containerLogs", namespace, pod, container}, logQuery(opts))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// logQuery encodes log options the way the kubelet expects them
+func logQuery(opts *corev1.PodLogOptions) url.Values {
+	q := url.Values{}
+	if opts == nil {
+		return q
+	}
+	if opts.Follow {
+		q.Set("follow", "true")
+	}
+	if opts.Previous {
+		q.Set("previous", "true")
+	}
+	if opts.Timestamps {
+		q.Set("timestamps", "true")
+	}
+	if opts.TailLines != nil {
+		q.Set("tailLines", strconv.FormatInt(*opts.TailLines, 10))
+	}
+	if opts.SinceSeconds != nil {
+		q.Set("sinceSeconds", strconv.FormatInt(*opts.SinceSeconds, 10))
+	}
+	if opts.SinceTime != nil {
+		q.Set("sinceTime", opts.SinceTime.UTC().Format(time.RFC3339))
+	}
+	if opts.LimitBytes != nil {
+		q.Set("limitBytes", strconv.FormatInt(*opts.LimitBytes, 10))
+	}
+	return q
+}
+
+// Exec runs a command in a container and streams its output. The caller must
+// close the stream. Krustlet doesn't support exec, so against krustlet this
+// returns an error matching ErrNotImplemented. krustlet-stats-shim serves exec
+// over a streaming connection only, as kubectl exec uses it.
+func (c *Client) Exec(ctx context.Context, namespace, pod, container string, command []string) (io.ReadCloser, error) {
+	q := url.Values{"command": command, "output": {"1"}, "error": {"1"}}
+	resp, err := c.do(ctx, http.MethodPost, []string{"exec", namespace, pod, container}, q)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// StatsSummary fetches the node's resource usage summary. Krustlet doesn't
+// serve it itself, so against krustlet without krustlet-stats-shim this
+// returns an error matching ErrNotImplemented.
+func (c *Client) StatsSummary(ctx context.Context) (*statsv1alpha1.Summary, error) {
+	resp, err := c.do(ctx, http.MethodGet, []string{"stats", "summary"}, nil)
+	var rerr *ResponseError
+	if errors.As(err, &rerr) && rerr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %w", ErrNotImplemented, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var summary statsv1alpha1.Summary
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return nil, fmt.Errorf("decoding stats summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// do sends a request to the path, made of unescaped segments, and returns
+// the response if it was successful
+func (c *Client) do(ctx context.Context, method string, segments []string, query url.Values) (*http.Response, error) {
+	u := *c.base
+	var path, rawPath strings.Builder
+	path.WriteString(strings.TrimSuffix(u.Path, "/"))
+	rawPath.WriteString(strings.TrimSuffix(u.EscapedPath(), "/"))
+	for _, s := range segments {
+		path.WriteString("/" + s)
+		rawPath.WriteString("/" + url.PathEscape(s))
+	}
+	u.Path, u.RawPath = path.String(), rawPath.String()
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, &ResponseError{
+		Method:     method,
+		URL:        u.Redacted(),
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+}
@@ -0,0 +1,151 @@
+package nodeapi
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// fakeNode serves the routes krustlet's node API does, answering requests
+// under prefix
+func fakeNode(t *testing.T, prefix string) (*httptest.Server, *[]*http.Request) {
+	t.Helper()
+	var requests []*http.Request
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "this is the Krustlet HTTP server")
+	})
+	mux.HandleFunc("GET "+prefix+"/containerLogs/{namespace}/{pod}/{container}", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.PathValue("pod") == "missing" {
+			http.Error(w, "Server error: pod not found", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, r.PathValue("namespace")+"/"+r.PathValue("pod")+"/"+r.PathValue("container"))
+	})
+	mux.HandleFunc("POST "+prefix+"/exec/{namespace}/{pod}/{container}", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "Exec not implemented.", http.StatusNotImplemented)
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func serverCA(srv *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+}
+
+func TestDirect(t *testing.T) {
+	srv, requests := fakeNode(t, "")
+	c, err := New(srv.Listener.Addr().String(), TLSOptions{CAData: serverCA(srv)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := c.Healthz(ctx); err != nil {
+		t.Fatalf("healthz: %v", err)
+	}
+
+	tail := int64(10)
+	since := metav1.NewTime(time.Date(2021, 6, 1, 12, 0, 0, 0, time.FixedZone("", 3600)))
+	logs, err := c.Logs(ctx, "default", "hello world", "hello", &corev1.PodLogOptions{Follow: true, TailLines: &tail, SinceTime: &since})
+	if err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+	body, _ := io.ReadAll(logs)
+	logs.Close()
+	if string(body) != "default/hello world/hello" {
+		t.Errorf("got logs %q", body)
+	}
+	q := (*requests)[0].URL.Query()
+	if q.Get("follow") != "true" || q.Get("tailLines") != "10" || q.Get("sinceTime") != "2021-06-01T11:00:00Z" || q.Has("previous") {
+		t.Errorf("unexpected log query %v", q)
+	}
+
+	_, err = c.Logs(ctx, "default", "missing", "hello", nil)
+	var rerr *ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusInternalServerError || !strings.Contains(err.Error(), "pod not found") {
+		t.Errorf("expected the node's error, got %v", err)
+	}
+	if errors.Is(err, ErrNotImplemented) {
+		t.Error("a failed logs call isn't unimplemented")
+	}
+
+	if _, err := c.Exec(ctx, "default", "hello", "hello", []string{"ls", "/"}); !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("expected exec to be unimplemented, got %v", err)
+	}
+	if _, err := c.StatsSummary(ctx); !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("expected stats to be unimplemented, got %v", err)
+	}
+}
+
+func TestDirectVerifiesCertificate(t *testing.T) {
+	srv, _ := fakeNode(t, "")
+	c, err := New(srv.Listener.Addr().String(), TLSOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Healthz(context.Background()); err == nil {
+		t.Error("expected an untrusted certificate to be rejected")
+	}
+
+	c, err = New(srv.Listener.Addr().String(), TLSOptions{Insecure: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Healthz(context.Background()); err != nil {
+		t.Errorf("expected an insecure client to connect, got %v", err)
+	}
+}
+
+func TestProxied(t *testing.T) {
+	srv, requests := fakeNode(t, "/api/v1/nodes/krustlet-wasi/proxy")
+	cfg := &rest.Config{
+		Host:            srv.URL,
+		BearerToken:     "token",
+		TLSClientConfig: rest.TLSClientConfig{CAData: serverCA(srv)},
+	}
+	c, err := NewProxied(cfg, "krustlet-wasi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := c.Logs(context.Background(), "default", "hello", "hello", nil)
+	if err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+	logs.Close()
+	if got := (*requests)[0].Header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("expected the API server credentials to be sent, got %q", got)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	node := &corev1.Node{Status: corev1.NodeStatus{
+		Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "krustlet-wasi"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.4"},
+		},
+		DaemonEndpoints: corev1.NodeDaemonEndpoints{KubeletEndpoint: corev1.DaemonEndpoint{Port: 3000}},
+	}}
+	if addr, ok := Address(node); !ok || addr != "10.0.0.4:3000" {
+		t.Errorf("got %q, %v", addr, ok)
+	}
+	node.Status.Addresses = node.Status.Addresses[:1]
+	if addr, ok := Address(node); !ok || addr != "krustlet-wasi:3000" {
+		t.Errorf("expected the hostname without an internal IP, got %q, %v", addr, ok)
+	}
+	node.Status.DaemonEndpoints.KubeletEndpoint.Port = 0
+	if _, ok := Address(node); ok {
+		t.Error("expected no address without a kubelet port")
+	}
+}
@@ -0,0 +1,136 @@
+package nodelabeler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// Controller watches nodes and keeps the labels in its config on every
+// krustlet node. Labels are set when a node registers and set again if they
+// are removed or changed.
+type Controller struct {
+	client kubernetes.Interface
+	config *Config
+	lister corelisters.NodeLister
+	synced cache.InformerSynced
+	queue  workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController returns a controller that gets nodes from the informer
+// factory. The factory must be started by the caller.
+func NewController(client kubernetes.Interface, factory informers.SharedInformerFactory, config *Config) *Controller {
+	informer := factory.Core().V1().Nodes()
+	c := &Controller{
+		client: client,
+		config: config,
+		lister: informer.Lister(),
+		synced: informer.Informer().HasSynced,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "krustlet-node-labeler"},
+		),
+	}
+	_, _ = informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+	})
+	return c
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok || !IsKrustlet(node) {
+		return
+	}
+	c.queue.Add(node.Name)
+}
+
+// Run labels nodes until the context is cancelled
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.Info("Waiting for node informer to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced) {
+		return fmt.Errorf("timed out waiting for the node informer to sync")
+	}
+	klog.InfoS("Starting node labeler", "workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNext(ctx) {
+	}
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	name, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	if err := c.sync(ctx, name); err != nil {
+		klog.ErrorS(err, "Failed to label node, retrying", "node", name)
+		c.queue.AddRateLimited(name)
+		return true
+	}
+	c.queue.Forget(name)
+	return true
+}
+
+// sync patches the named node's labels if they differ from the desired ones
+func (c *Controller) sync(ctx context.Context, name string) error {
+	node, err := c.lister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !IsKrustlet(node) {
+		return nil
+	}
+
+	labels, annotation := labelPatch(node, c.config.desired(node))
+	if labels == nil {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": map[string]string{ManagedLabelsAnnotation: annotation},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.CoreV1().Nodes().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("patching labels: %w", err)
+	}
+	klog.InfoS("Labelled node", "node", name, "labels", labels)
+	return nil
+}
@@ -0,0 +1,158 @@
+package nodelabeler
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testNode(name, arch string, labels map[string]string) *corev1.Node {
+	l := map[string]string{archLabel: arch}
+	if arch != "amd64" {
+		l[typeLabel] = krustletType
+	}
+	for k, v := range labels {
+		l[k] = v
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: l}}
+}
+
+func newTestController(t *testing.T, config *Config, nodes ...*corev1.Node) (*Controller, *fake.Clientset) {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	c := NewController(client, factory, config)
+	indexer := factory.Core().V1().Nodes().Informer().GetIndexer()
+	for _, node := range nodes {
+		if _, err := client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := indexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.ClearActions()
+	return c, client
+}
+
+func patches(client *fake.Clientset) int {
+	n := 0
+	for _, a := range client.Actions() {
+		if a.GetVerb() == "patch" {
+			n++
+		}
+	}
+	return n
+}
+
+func getNode(t *testing.T, client *fake.Clientset, name string) *corev1.Node {
+	t.Helper()
+	node, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node
+}
+
+func TestSyncLabels(t *testing.T) {
+	config := &Config{Role: DefaultRole, Labels: map[string]string{"example.com/pool": "edge"}}
+	c, client := newTestController(t, config,
+		testNode("krustlet-wasi", "wasm32-wasi", nil),
+		testNode("krustlet-wagi", "wasm32-wagi", nil),
+	)
+	for _, name := range []string{"krustlet-wasi", "krustlet-wagi"} {
+		if err := c.sync(context.Background(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wasi := getNode(t, client, "krustlet-wasi")
+	want := map[string]string{
+		"node-role.kubernetes.io/wasm": "",
+		RuntimeLabel:                   "wasi",
+		ArchLabel:                      "wasm32",
+		"example.com/pool":             "edge",
+		// krustlet's own labels are kept
+		archLabel: "wasm32-wasi",
+		typeLabel: krustletType,
+	}
+	for k, v := range want {
+		if got, ok := wasi.Labels[k]; !ok || got != v {
+			t.Errorf("label %s: got %q, want %q", k, got, v)
+		}
+	}
+	if got := wasi.Annotations[ManagedLabelsAnnotation]; got != "example.com/pool,krustlet.dev/arch,krustlet.dev/runtime,node-role.kubernetes.io/wasm" {
+		t.Errorf("unexpected managed labels %q", got)
+	}
+	if got := getNode(t, client, "krustlet-wagi").Labels[RuntimeLabel]; got != "wagi" {
+		t.Errorf("expected the wagi runtime, got %q", got)
+	}
+}
+
+func TestSyncUpToDate(t *testing.T) {
+	node := testNode("krustlet-wasi", "wasm32-wasi", map[string]string{
+		"node-role.kubernetes.io/wasm": "",
+		RuntimeLabel:                   "wasi",
+		ArchLabel:                      "wasm32",
+	})
+	node.Annotations = map[string]string{ManagedLabelsAnnotation: "krustlet.dev/arch,krustlet.dev/runtime,node-role.kubernetes.io/wasm"}
+	c, client := newTestController(t, &Config{Role: DefaultRole}, node, testNode("linux", "amd64", nil))
+	for _, name := range []string{"krustlet-wasi", "linux", "deleted"} {
+		if err := c.sync(context.Background(), name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if n := patches(client); n != 0 {
+		t.Errorf("expected no patches, got %d", n)
+	}
+}
+
+func TestSyncReconciles(t *testing.T) {
+	// The runtime label was changed by hand, and the controller used to
+	// manage a role it no longer does
+	node := testNode("krustlet-wasi", "wasm32-wasi", map[string]string{
+		"node-role.kubernetes.io/wasm": "",
+		RuntimeLabel:                   "wagi",
+		ArchLabel:                      "wasm32",
+		"example.com/unmanaged":        "kept",
+	})
+	node.Annotations = map[string]string{ManagedLabelsAnnotation: "krustlet.dev/arch,krustlet.dev/runtime,node-role.kubernetes.io/wasm"}
+	c, client := newTestController(t, &Config{}, node)
+	if err := c.sync(context.Background(), "krustlet-wasi"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := getNode(t, client, "krustlet-wasi")
+	if got.Labels[RuntimeLabel] != "wasi" {
+		t.Errorf("expected the runtime label to be corrected, got %q", got.Labels[RuntimeLabel])
+	}
+	if _, ok := got.Labels["node-role.kubernetes.io/wasm"]; ok {
+		t.Error("expected the role the controller no longer manages to be removed")
+	}
+	if got.Labels["example.com/unmanaged"] != "kept" {
+		t.Error("expected labels the controller never managed to be kept")
+	}
+	if got.Annotations[ManagedLabelsAnnotation] != "krustlet.dev/arch,krustlet.dev/runtime" {
+		t.Errorf("unexpected managed labels %q", got.Annotations[ManagedLabelsAnnotation])
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []Config{
+		{Role: "not a role"},
+		{Labels: map[string]string{"bad key!": "x"}},
+		{Labels: map[string]string{"example.com/pool": "not a value"}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", c)
+		}
+	}
+	c := Config{Role: DefaultRole, Labels: map[string]string{"example.com/pool": "edge"}}
+	if err := c.Validate(); err != nil {
+		t.Error(err)
+	}
+}
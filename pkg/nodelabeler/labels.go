@@ -0,0 +1,129 @@
+// Package nodelabeler keeps krustlet nodes labelled with their role, runtime
+// and architecture.
+//
+// Krustlet can't set these itself: the NodeRestriction admission plugin
+// stops a node from giving itself a node-role.kubernetes.io label, and
+// krustlet only sets kubernetes.io/arch to its provider's combined
+// architecture, such as wasm32-wasi, which can't be selected on by runtime
+// alone.
+package nodelabeler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// RuntimeLabel is the WebAssembly runtime interface the node's provider
+	// offers, such as wasi or wagi
+	RuntimeLabel = "krustlet.dev/runtime"
+	// ArchLabel is the WebAssembly architecture the node runs, such as wasm32
+	ArchLabel = "krustlet.dev/arch"
+	// DefaultRole is the role krustlet nodes are given
+	DefaultRole = "wasm"
+	// ManagedLabelsAnnotation lists the labels the controller set on a node,
+	// so labels it stops managing are removed rather than left behind
+	ManagedLabelsAnnotation = "krustlet.dev/managed-labels"
+
+	roleLabelPrefix = "node-role.kubernetes.io/"
+	archLabel       = "kubernetes.io/arch"
+	typeLabel       = "type"
+	krustletType    = "krustlet"
+)
+
+// Config is what the controller labels krustlet nodes with
+type Config struct {
+	// Role is set as node-role.kubernetes.io/<Role>. No role is set if it
+	// is empty.
+	Role string
+	// Labels are set on every krustlet node as they are
+	Labels map[string]string
+}
+
+// Validate checks the role and labels are valid label keys and values
+func (c *Config) Validate() error {
+	if c.Role != "" {
+		if errs := validation.IsQualifiedName(roleLabelPrefix + c.Role); len(errs) > 0 {
+			return fmt.Errorf("invalid role %q: %s", c.Role, strings.Join(errs, "; "))
+		}
+	}
+	for k, v := range c.Labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("invalid value for label %s: %s", k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// IsKrustlet reports whether the node is run by krustlet. Krustlet sets the
+// type label on every node; nodes for WebAssembly architectures are also
+// treated as krustlet nodes, in case the label was removed.
+func IsKrustlet(node *corev1.Node) bool {
+	return node.Labels[typeLabel] == krustletType || strings.HasPrefix(node.Labels[archLabel], "wasm")
+}
+
+// desired returns the labels the controller manages on the node
+func (c *Config) desired(node *corev1.Node) map[string]string {
+	labels := map[string]string{}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+	if c.Role != "" {
+		labels[roleLabelPrefix+c.Role] = ""
+	}
+	// Providers name their architecture <arch>-<runtime>, as in wasm32-wasi
+	if arch, runtime, ok := strings.Cut(node.Labels[archLabel], "-"); ok && arch != "" && runtime != "" {
+		labels[ArchLabel] = arch
+		labels[RuntimeLabel] = runtime
+	}
+	return labels
+}
+
+// managed returns the label keys recorded on the node as set by the
+// controller
+func managed(node *corev1.Node) []string {
+	v := node.Annotations[ManagedLabelsAnnotation]
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// labelPatch returns the changes that give the node its desired labels and
+// drop those the controller set before but no longer manages, and the new
+// value of the managed labels annotation. It returns nil if the node is up
+// to date.
+func labelPatch(node *corev1.Node, desired map[string]string) (map[string]interface{}, string) {
+	keys := make([]string, 0, len(desired))
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	annotation := strings.Join(keys, ",")
+
+	patch := map[string]interface{}{}
+	for k, v := range desired {
+		if current, ok := node.Labels[k]; !ok || current != v {
+			patch[k] = v
+		}
+	}
+	for _, k := range managed(node) {
+		if _, ok := desired[k]; !ok {
+			if _, exists := node.Labels[k]; exists {
+				// null removes the label in a merge patch
+				patch[k] = nil
+			}
+		}
+	}
+	if len(patch) == 0 && node.Annotations[ManagedLabelsAnnotation] == annotation {
+		return nil, annotation
+	}
+	return patch, annotation
+}
@@ -0,0 +1,27 @@
+// Package nodename derives node names the way krustlet does, for tools that
+// run beside krustlet and must find the node it registered.
+package nodename
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FromHostname returns the node name krustlet registers a host with the
+// hostname as, when it isn't given one. Krustlet lower cases the hostname,
+// since node names can't hold upper case letters; see sanitize_hostname in
+// crates/kubelet/src/config.rs.
+func FromHostname(hostname string) string {
+	return strings.ToLower(hostname)
+}
+
+// Default returns the node name krustlet gives this host when it isn't
+// given one
+func Default() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("finding node name: %w", err)
+	}
+	return FromHostname(host), nil
+}
@@ -0,0 +1,9 @@
+package nodename
+
+import "testing"
+
+func TestFromHostname(t *testing.T) {
+	if got := FromHostname("Build-Agent.Example.COM"); got != "build-agent.example.com" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,151 @@
+// Package nodeproblem reports problems on a krustlet host as node conditions
+// and events, the way the kubelet and node-problem-detector do on other
+// nodes. Krustlet itself only reports whether it is ready, so without this
+// the scheduler, the cluster autoscaler and alerting never learn that a
+// krustlet node is short of disk or memory, has a skewed clock, or is about to
+// lose its certificates.
+package nodeproblem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// DefaultInterval is how often conditions are checked and reported
+const DefaultInterval = 30 * time.Second
+
+// component is the event source
+const component = "krustlet-problem-detector"
+
+// Observation is what a monitor found
+type Observation struct {
+	// Status is True when there is a problem, False when there isn't and
+	// Unknown when the monitor couldn't tell
+	Status  corev1.ConditionStatus
+	Reason  string
+	Message string
+}
+
+// Monitor checks one kind of problem, reported as a node condition
+type Monitor interface {
+	// Type is the condition the monitor reports
+	Type() corev1.NodeConditionType
+	Check(ctx context.Context, now time.Time) Observation
+}
+
+// Detector runs monitors and reports their observations on the node
+type Detector struct {
+	client   kubernetes.Interface
+	nodeName string
+	monitors []Monitor
+	interval time.Duration
+	now      func() time.Time
+}
+
+// New returns a detector that reports the monitors' conditions on the node
+// every interval
+func New(client kubernetes.Interface, nodeName string, interval time.Duration, monitors ...Monitor) *Detector {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Detector{client: client, nodeName: nodeName, monitors: monitors, interval: interval, now: time.Now}
+}
+
+// Run reports conditions until the context is cancelled
+func (d *Detector) Run(ctx context.Context) error {
+	klog.InfoS("Reporting node problems", "node", d.nodeName, "interval", d.interval)
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		if err := d.sync(ctx); err != nil {
+			klog.ErrorS(err, "Failed to report node conditions")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync checks every monitor and patches the node's conditions. Only the
+// monitors' conditions are patched; the strategic merge keeps the ones
+// krustlet reports.
+func (d *Detector) sync(ctx context.Context) error {
+	node, err := d.client.CoreV1().Nodes().Get(ctx, d.nodeName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("getting node %s: %w", d.nodeName, err)
+	}
+	existing := map[corev1.NodeConditionType]corev1.NodeCondition{}
+	for _, c := range node.Status.Conditions {
+		existing[c.Type] = c
+	}
+
+	now := metav1.NewTime(d.now())
+	conditions := make([]corev1.NodeCondition, 0, len(d.monitors))
+	for _, m := range d.monitors {
+		obs := m.Check(ctx, now.Time)
+		cond := corev1.NodeCondition{
+			Type:               m.Type(),
+			Status:             obs.Status,
+			Reason:             obs.Reason,
+			Message:            obs.Message,
+			LastHeartbeatTime:  now,
+			LastTransitionTime: now,
+		}
+		old, ok := existing[cond.Type]
+		if ok && old.Status == cond.Status {
+			cond.LastTransitionTime = old.LastTransitionTime
+		} else if ok || cond.Status == corev1.ConditionTrue {
+			d.recordTransition(ctx, node, cond)
+		}
+		conditions = append(conditions, cond)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"conditions": conditions},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = d.client.CoreV1().Nodes().PatchStatus(ctx, d.nodeName, patch)
+	return err
+}
+
+// recordTransition records an event for a condition changing status. Events
+// are best effort, as they are for the kubelet.
+func (d *Detector) recordTransition(ctx context.Context, node *corev1.Node, cond corev1.NodeCondition) {
+	eventType := corev1.EventTypeNormal
+	if cond.Status == corev1.ConditionTrue {
+		eventType = corev1.EventTypeWarning
+	}
+	now := cond.LastTransitionTime
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", node.Name, now.UnixNano()),
+			Namespace: metav1.NamespaceDefault,
+		},
+		// The kubelet refers to its node by name, as the node's UID isn't
+		// known when it starts
+		InvolvedObject: corev1.ObjectReference{Kind: "Node", Name: node.Name, UID: types.UID(node.Name)},
+		Reason:         cond.Reason,
+		Message:        fmt.Sprintf("%s is now %s: %s", cond.Type, cond.Status, cond.Message),
+		Source:         corev1.EventSource{Component: component, Host: node.Name},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           eventType,
+	}
+	if _, err := d.client.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+		klog.ErrorS(err, "Failed to record event", "condition", cond.Type, "status", cond.Status)
+	}
+	klog.InfoS("Node condition changed", "condition", cond.Type, "status", cond.Status, "reason", cond.Reason, "message", cond.Message)
+}
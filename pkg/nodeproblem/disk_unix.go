@@ -0,0 +1,15 @@
+//go:build !windows
+
+package nodeproblem
+
+import "syscall"
+
+// diskUsage returns the size of the filesystem holding path and the space
+// available to unprivileged users
+func diskUsage(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
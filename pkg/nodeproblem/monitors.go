@@ -0,0 +1,292 @@
+package nodeproblem
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/rest"
+)
+
+// The conditions reported by krustlet-problem-detector that the kubelet
+// doesn't define
+const (
+	ClockSkew           corev1.NodeConditionType = "ClockSkew"
+	CertificateExpiring corev1.NodeConditionType = "CertificateExpiring"
+)
+
+func unknown(reason string, err error) Observation {
+	return Observation{Status: corev1.ConditionUnknown, Reason: reason, Message: err.Error()}
+}
+
+// DiskMonitor reports DiskPressure when the filesystem holding Path, such as
+// krustlet's module cache, runs low on space. Krustlet doesn't garbage
+// collect modules, so the cache only grows.
+type DiskMonitor struct {
+	Path string
+	// MinFreePercent and MinFreeBytes are the free space below which there
+	// is pressure; either being crossed is enough
+	MinFreePercent float64
+	MinFreeBytes   uint64
+
+	usage func(path string) (total, free uint64, err error)
+}
+
+// NewDiskMonitor returns a monitor for the filesystem holding path
+func NewDiskMonitor(path string, minFreePercent float64, minFreeBytes uint64) *DiskMonitor {
+	return &DiskMonitor{Path: path, MinFreePercent: minFreePercent, MinFreeBytes: minFreeBytes, usage: diskUsage}
+}
+
+// Type implements Monitor
+func (m *DiskMonitor) Type() corev1.NodeConditionType {
+	return corev1.NodeDiskPressure
+}
+
+// Check implements Monitor
+func (m *DiskMonitor) Check(context.Context, time.Time) Observation {
+	total, free, err := m.usage(existingParent(m.Path))
+	if err != nil {
+		return unknown("DiskUsageUnavailable", err)
+	}
+	percent := 100.0
+	if total > 0 {
+		percent = float64(free) / float64(total) * 100
+	}
+	msg := fmt.Sprintf("%s has %s (%.1f%%) free", m.Path, formatBytes(free), percent)
+	if percent < m.MinFreePercent || free < m.MinFreeBytes {
+		return Observation{Status: corev1.ConditionTrue, Reason: "KubeletHasDiskPressure", Message: msg}
+	}
+	return Observation{Status: corev1.ConditionFalse, Reason: "KubeletHasNoDiskPressure", Message: msg}
+}
+
+// existingParent returns the path, or its closest parent that exists, since
+// krustlet only creates the module cache once it pulls a module
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// MemoryMonitor reports MemoryPressure when the host's available memory, as
+// reported by /proc/meminfo, falls below MinAvailable bytes
+type MemoryMonitor struct {
+	MinAvailable uint64
+	// Meminfo is the file to read, /proc/meminfo by default
+	Meminfo string
+}
+
+// Type implements Monitor
+func (m *MemoryMonitor) Type() corev1.NodeConditionType {
+	return corev1.NodeMemoryPressure
+}
+
+// Check implements Monitor
+func (m *MemoryMonitor) Check(context.Context, time.Time) Observation {
+	path := m.Meminfo
+	if path == "" {
+		path = "/proc/meminfo"
+	}
+	available, err := memAvailable(path)
+	if err != nil {
+		return unknown("MemoryUsageUnavailable", err)
+	}
+	msg := fmt.Sprintf("%s of memory available", formatBytes(available))
+	if available < m.MinAvailable {
+		return Observation{Status: corev1.ConditionTrue, Reason: "KubeletHasInsufficientMemory", Message: msg}
+	}
+	return Observation{Status: corev1.ConditionFalse, Reason: "KubeletHasSufficientMemory", Message: msg}
+}
+
+// memAvailable returns MemAvailable from a meminfo file
+func memAvailable(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("%s has no MemAvailable", path)
+}
+
+// ClockMonitor reports ClockSkew when the host's clock differs from the API
+// server's by more than MaxSkew. A skewed clock makes certificates and
+// tokens look expired or not yet valid, and leases expire early.
+type ClockMonitor struct {
+	MaxSkew time.Duration
+
+	serverTime func(ctx context.Context) (sent, received, server time.Time, err error)
+}
+
+// NewClockMonitor returns a monitor that compares the host's clock to the
+// Date header of the API server's responses
+func NewClockMonitor(config *rest.Config, maxSkew time.Duration) (*ClockMonitor, error) {
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(config.Host, "/") + "/version"
+	return &ClockMonitor{MaxSkew: maxSkew, serverTime: func(ctx context.Context) (time.Time, time.Time, time.Time, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return time.Time{}, time.Time{}, time.Time{}, err
+		}
+		sent := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return time.Time{}, time.Time{}, time.Time{}, err
+		}
+		received := time.Now()
+		resp.Body.Close()
+		server, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			return time.Time{}, time.Time{}, time.Time{}, fmt.Errorf("API server sent no usable Date header: %w", err)
+		}
+		return sent, received, server, nil
+	}}, nil
+}
+
+// Type implements Monitor
+func (m *ClockMonitor) Type() corev1.NodeConditionType {
+	return ClockSkew
+}
+
+// Check implements Monitor
+func (m *ClockMonitor) Check(ctx context.Context, _ time.Time) Observation {
+	sent, received, server, err := m.serverTime(ctx)
+	if err != nil {
+		return unknown("ClockUnchecked", err)
+	}
+	// The Date header has a resolution of a second, and the server wrote it
+	// somewhere during the request, so allow for both
+	local := sent.Add(received.Sub(sent) / 2)
+	skew := local.Sub(server)
+	slack := time.Second + received.Sub(sent)/2
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > m.MaxSkew+slack {
+		return Observation{
+			Status:  corev1.ConditionTrue,
+			Reason:  "ClockSkewed",
+			Message: fmt.Sprintf("host clock is %s off the API server's, more than the %s allowed", skew.Round(time.Second), m.MaxSkew),
+		}
+	}
+	return Observation{Status: corev1.ConditionFalse, Reason: "ClockSynchronized", Message: "host clock agrees with the API server's"}
+}
+
+// Certificate is a certificate the node needs
+type Certificate struct {
+	// Name describes the certificate in messages
+	Name string
+	// Load returns the PEM encoded certificate
+	Load func() ([]byte, error)
+}
+
+// CertificateFile returns a certificate read from a file
+func CertificateFile(name, path string) Certificate {
+	return Certificate{Name: fmt.Sprintf("%s (%s)", name, path), Load: func() ([]byte, error) { return os.ReadFile(path) }}
+}
+
+// CertificateMonitor reports CertificateExpiring when one of the node's
+// certificates expires within Warning. Krustlet can't serve logs or exec
+// once its serving certificate expires, or reach the API server once its
+// client certificate does.
+type CertificateMonitor struct {
+	Certificates []Certificate
+	Warning      time.Duration
+}
+
+// Type implements Monitor
+func (m *CertificateMonitor) Type() corev1.NodeConditionType {
+	return CertificateExpiring
+}
+
+// Check implements Monitor
+func (m *CertificateMonitor) Check(_ context.Context, now time.Time) Observation {
+	var earliest *x509.Certificate
+	var earliestName string
+	for _, c := range m.Certificates {
+		cert, err := parseCertificate(c.Load)
+		if err != nil {
+			return unknown("CertificateUnreadable", fmt.Errorf("%s: %w", c.Name, err))
+		}
+		if earliest == nil || cert.NotAfter.Before(earliest.NotAfter) {
+			earliest, earliestName = cert, c.Name
+		}
+	}
+	if earliest == nil {
+		return Observation{Status: corev1.ConditionFalse, Reason: "NoCertificates", Message: "no certificates to check"}
+	}
+	left := earliest.NotAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return Observation{
+			Status:  corev1.ConditionTrue,
+			Reason:  "CertificateExpired",
+			Message: fmt.Sprintf("%s expired at %s", earliestName, earliest.NotAfter.UTC().Format(time.RFC3339)),
+		}
+	case left < m.Warning:
+		return Observation{
+			Status:  corev1.ConditionTrue,
+			Reason:  "CertificateExpiringSoon",
+			Message: fmt.Sprintf("%s expires at %s", earliestName, earliest.NotAfter.UTC().Format(time.RFC3339)),
+		}
+	}
+	return Observation{
+		Status:  corev1.ConditionFalse,
+		Reason:  "CertificatesValid",
+		Message: fmt.Sprintf("the first certificate to expire, %s, is valid until %s", earliestName, earliest.NotAfter.UTC().Format(time.RFC3339)),
+	}
+}
+
+func parseCertificate(load func() ([]byte, error)) (*x509.Certificate, error) {
+	data, err := load()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM encoded certificate")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// formatBytes formats a byte count as a quantity, such as 512Mi
+func formatBytes(n uint64) string {
+	q := resource.NewQuantity(int64(n/(1<<20))*(1<<20), resource.BinarySI)
+	return q.String()
+}
@@ -0,0 +1,204 @@
+package nodeproblem
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeMonitor struct {
+	typ corev1.NodeConditionType
+	obs Observation
+}
+
+func (m *fakeMonitor) Type() corev1.NodeConditionType { return m.typ }
+
+func (m *fakeMonitor) Check(context.Context, time.Time) Observation { return m.obs }
+
+func TestSync(t *testing.T) {
+	start := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "krustlet"},
+		Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionTrue, Reason: "KubeletReady"},
+		}},
+	}
+	client := fake.NewSimpleClientset(node)
+	disk := &fakeMonitor{typ: corev1.NodeDiskPressure, obs: Observation{Status: corev1.ConditionFalse, Reason: "KubeletHasNoDiskPressure"}}
+	clock := &fakeMonitor{typ: ClockSkew, obs: Observation{Status: corev1.ConditionTrue, Reason: "ClockSkewed", Message: "off by 1m"}}
+	d := New(client, "krustlet", 0, disk, clock)
+	now := start
+	d.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	conditions := func() map[corev1.NodeConditionType]corev1.NodeCondition {
+		t.Helper()
+		if err := d.sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		n, err := client.CoreV1().Nodes().Get(ctx, "krustlet", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[corev1.NodeConditionType]corev1.NodeCondition{}
+		for _, c := range n.Status.Conditions {
+			out[c.Type] = c
+		}
+		return out
+	}
+	events := func() []corev1.Event {
+		t.Helper()
+		list, err := client.CoreV1().Events(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return list.Items
+	}
+
+	got := conditions()
+	if got[corev1.NodeReady].Status != corev1.ConditionTrue {
+		t.Error("Ready condition was lost")
+	}
+	if got[corev1.NodeDiskPressure].Status != corev1.ConditionFalse || got[ClockSkew].Status != corev1.ConditionTrue {
+		t.Errorf("unexpected conditions %+v", got)
+	}
+	// Only the new problem is worth an event
+	if ev := events(); len(ev) != 1 || ev[0].Reason != "ClockSkewed" || ev[0].Type != corev1.EventTypeWarning || ev[0].InvolvedObject.Name != "krustlet" {
+		t.Errorf("unexpected events %+v", ev)
+	}
+
+	now = start.Add(time.Minute)
+	clock.obs = Observation{Status: corev1.ConditionFalse, Reason: "ClockSynchronized"}
+	got = conditions()
+	if !got[corev1.NodeDiskPressure].LastTransitionTime.Time.Equal(start) || !got[corev1.NodeDiskPressure].LastHeartbeatTime.Time.Equal(now) {
+		t.Errorf("unchanged condition should keep its transition time: %+v", got[corev1.NodeDiskPressure])
+	}
+	if !got[ClockSkew].LastTransitionTime.Time.Equal(now) {
+		t.Errorf("changed condition should have a new transition time: %+v", got[ClockSkew])
+	}
+	if ev := events(); len(ev) != 2 {
+		t.Errorf("expected an event for the clock recovering, got %+v", ev)
+	}
+}
+
+func TestDiskMonitor(t *testing.T) {
+	m := NewDiskMonitor(filepath.Join(t.TempDir(), "missing", "modules"), 10, 1<<30)
+	m.usage = func(string) (uint64, uint64, error) { return 100 << 30, 20 << 30, nil }
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionFalse || !strings.Contains(obs.Message, "20Gi (20.0%)") {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	m.usage = func(string) (uint64, uint64, error) { return 100 << 30, 5 << 30, nil }
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionTrue || obs.Reason != "KubeletHasDiskPressure" {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	m.usage = func(string) (uint64, uint64, error) { return 0, 0, errors.New("statfs failed") }
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionUnknown {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+
+	// The real filesystem can be measured from a path that doesn't exist yet
+	m = NewDiskMonitor(filepath.Join(t.TempDir(), "missing"), 0, 0)
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionFalse {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+}
+
+func TestMemoryMonitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	if err := os.WriteFile(path, []byte("MemTotal:       16311424 kB\nMemFree:          204800 kB\nMemAvailable:      51200 kB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := &MemoryMonitor{MinAvailable: 100 << 20, Meminfo: path}
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionTrue || obs.Message != "50Mi of memory available" {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	m.MinAvailable = 10 << 20
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionFalse {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	m.Meminfo = filepath.Join(t.TempDir(), "missing")
+	if obs := m.Check(context.Background(), time.Now()); obs.Status != corev1.ConditionUnknown {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+}
+
+func TestClockMonitor(t *testing.T) {
+	local := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	server := local
+	m := &ClockMonitor{MaxSkew: 10 * time.Second, serverTime: func(context.Context) (time.Time, time.Time, time.Time, error) {
+		return local, local.Add(200 * time.Millisecond), server, nil
+	}}
+	if obs := m.Check(context.Background(), local); obs.Status != corev1.ConditionFalse {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	server = local.Add(-time.Minute)
+	if obs := m.Check(context.Background(), local); obs.Status != corev1.ConditionTrue || !strings.Contains(obs.Message, "1m0s") {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+}
+
+func testCertificate(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "system:node:krustlet"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCertificateMonitor(t *testing.T) {
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	serving := testCertificate(t, now.Add(90*24*time.Hour))
+	client := testCertificate(t, now.Add(3*24*time.Hour))
+	m := &CertificateMonitor{
+		Warning: 7 * 24 * time.Hour,
+		Certificates: []Certificate{
+			{Name: "serving certificate", Load: func() ([]byte, error) { return serving, nil }},
+			{Name: "client certificate", Load: func() ([]byte, error) { return client, nil }},
+		},
+	}
+	if obs := m.Check(context.Background(), now); obs.Status != corev1.ConditionTrue || obs.Reason != "CertificateExpiringSoon" || !strings.Contains(obs.Message, "client certificate") {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	if obs := m.Check(context.Background(), now.Add(4*24*time.Hour)); obs.Reason != "CertificateExpired" {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	m.Certificates = m.Certificates[:1]
+	if obs := m.Check(context.Background(), now); obs.Status != corev1.ConditionFalse {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+	m.Certificates = append(m.Certificates, CertificateFile("missing", filepath.Join(t.TempDir(), "missing.crt")))
+	if obs := m.Check(context.Background(), now); obs.Status != corev1.ConditionUnknown {
+		t.Errorf("unexpected observation %+v", obs)
+	}
+}
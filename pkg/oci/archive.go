@@ -0,0 +1,94 @@
+package oci
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// archiveManifest is an entry of the manifest.json in a docker save archive
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ReadArchiveModule extracts a wasm module from an image in a tarball written
+// by docker save. image picks the image by one of its tags when the archive
+// holds more than one. modulePath is chosen as it is by PullImageModule.
+func ReadArchiveModule(r io.ReadSeeker, image, modulePath string) (*ImageModule, error) {
+	var manifests []archiveManifest
+	if err := readArchiveFile(r, "manifest.json", func(f io.Reader) error {
+		return json.NewDecoder(f).Decode(&manifests)
+	}); err != nil {
+		return nil, err
+	}
+	m, err := selectArchiveImage(manifests, image)
+	if err != nil {
+		return nil, err
+	}
+
+	var config imageConfig
+	if err := readArchiveFile(r, m.Config, func(f io.Reader) error {
+		return json.NewDecoder(f).Decode(&config)
+	}); err != nil {
+		return nil, fmt.Errorf("reading image config: %w", err)
+	}
+	modulePath = cleanModulePath(modulePath)
+	files := newImageFiles(modulePath, config.entrypoint())
+	for _, l := range m.Layers {
+		if err := readArchiveFile(r, l, files.apply); err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", l, err)
+		}
+	}
+	return files.module(&config, modulePath)
+}
+
+// selectArchiveImage returns the archive's image with the tag, or its only
+// image if tag is empty
+func selectArchiveImage(manifests []archiveManifest, tag string) (*archiveManifest, error) {
+	if tag == "" {
+		switch len(manifests) {
+		case 0:
+			return nil, errors.New("archive has no images")
+		case 1:
+			return &manifests[0], nil
+		}
+		return nil, fmt.Errorf("archive has %d images; choose one by its tag", len(manifests))
+	}
+	for i := range manifests {
+		for _, t := range manifests[i].RepoTags {
+			if t == tag || strings.TrimPrefix(t, "docker.io/") == tag || strings.TrimPrefix(t, "docker.io/library/") == tag {
+				return &manifests[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("archive has no image tagged %s", tag)
+}
+
+// readArchiveFile finds the named file in the archive and passes it to fn.
+// The archive is read from the start each time, since docker save doesn't
+// write files in any particular order.
+func readArchiveFile(r io.ReadSeeker, name string, fn func(io.Reader) error) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	name = path.Clean(name)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("archive has no %s; is it from docker save?", name)
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+		if path.Clean(hdr.Name) == name && hdr.Typeflag == tar.TypeReg {
+			return fn(tr)
+		}
+	}
+}
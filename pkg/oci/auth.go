@@ -0,0 +1,168 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// challenge is a parsed WWW-Authenticate header
+type challenge struct {
+	scheme string
+	params map[string]string
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+func parseChallenge(header string) (challenge, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return challenge{}, fmt.Errorf("registry returned 401 without a WWW-Authenticate header")
+	}
+	scheme, rest, _ := strings.Cut(header, " ")
+	c := challenge{scheme: strings.ToLower(scheme), params: map[string]string{}}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return challenge{}, fmt.Errorf("malformed WWW-Authenticate header %q", header)
+			}
+			c.params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			v, r, _ := strings.Cut(value, ",")
+			c.params[key] = strings.TrimSpace(v)
+			rest = "," + r
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return c, nil
+}
+
+// authorize answers a challenge and returns the Authorization header value to
+// use for the scope
+func (c *Client) authorize(ctx context.Context, ref Reference, header, scope string) (string, error) {
+	ch, err := parseChallenge(header)
+	if err != nil {
+		return "", err
+	}
+	cred, err := c.credentials(ref.Registry)
+	if err != nil {
+		return "", fmt.Errorf("looking up credentials: %w", err)
+	}
+
+	switch ch.scheme {
+	case "basic":
+		if cred.Username == "" {
+			return "", fmt.Errorf("registry requires basic auth but no credentials were given")
+		}
+		return "Basic " + basicAuth(cred.Username, cred.Password), nil
+	case "bearer":
+		if cred.RegistryToken != "" {
+			return "Bearer " + cred.RegistryToken, nil
+		}
+		token, err := c.fetchToken(ctx, ch, cred, scope)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported auth scheme %q", ch.scheme)
+	}
+}
+
+// fetchToken gets a bearer token from the realm in the challenge. Identity
+// tokens are exchanged with the OAuth2 refresh flow; everything else uses a
+// GET request with optional basic auth, which every registry supports.
+func (c *Client) fetchToken(ctx context.Context, ch challenge, cred Credential, scope string) (string, error) {
+	realm := ch.params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge has no realm")
+	}
+	// Ask for the scope the operation needs as well as whatever the registry
+	// asked for, since the challenge for the first request of a push only
+	// mentions pull
+	scopes := []string{scope}
+	if s := ch.params["scope"]; s != "" && s != scope {
+		scopes = append(scopes, s)
+	}
+
+	var req *http.Request
+	var err error
+	if cred.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {cred.IdentityToken},
+			"service":       {ch.params["service"]},
+			"scope":         {strings.Join(scopes, " ")},
+			"client_id":     {c.userAgent},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		u, err := url.Parse(realm)
+		if err != nil {
+			return "", fmt.Errorf("invalid realm %q: %w", realm, err)
+		}
+		q := u.Query()
+		if s := ch.params["service"]; s != "" {
+			q.Set("service", s)
+		}
+		q["scope"] = scopes
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		if cred.Username != "" {
+			req.SetBasicAuth(cred.Username, cred.Password)
+		}
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching token: %w", err)
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return "", fmt.Errorf("fetching token: %w", err)
+	}
+	defer drain(resp)
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("token response did not contain a token")
+}
+
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+// repositoryScope returns the token scope for actions on the repository
+func repositoryScope(ref Reference, actions ...string) string {
+	return fmt.Sprintf("repository:%s:%s", ref.Repository, strings.Join(actions, ","))
+}
@@ -0,0 +1,248 @@
+// Package oci pushes and pulls WebAssembly modules to and from OCI registries
+// using the same artifact layout krustlet expects when it pulls a pod's
+// module: an OCI image manifest with a single wasm layer and a wasm config.
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Credential holds the credentials used for a registry
+type Credential struct {
+	Username string
+	Password string
+	// IdentityToken is an OAuth2 refresh token exchanged for access tokens
+	IdentityToken string
+	// RegistryToken is a bearer token sent to the registry as-is
+	RegistryToken string
+}
+
+// IsEmpty reports whether the credential has nothing set, meaning requests
+// are made anonymously
+func (c Credential) IsEmpty() bool {
+	return c == Credential{}
+}
+
+// CredentialFunc returns the credential to use for a registry host. An empty
+// credential means the registry is accessed anonymously.
+type CredentialFunc func(registry string) (Credential, error)
+
+// StaticCredential returns a CredentialFunc that uses cred for every registry
+func StaticCredential(cred Credential) CredentialFunc {
+	return func(string) (Credential, error) { return cred, nil }
+}
+
+// Client talks to OCI distribution registries. It is safe for concurrent use.
+type Client struct {
+	httpClient  *http.Client
+	credentials CredentialFunc
+	plainHTTP   map[string]bool
+	userAgent   string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) { cl.httpClient = c }
+}
+
+// WithCredentials sets how credentials are looked up for each registry
+func WithCredentials(f CredentialFunc) Option {
+	return func(cl *Client) { cl.credentials = f }
+}
+
+// WithPlainHTTP makes the client use plain HTTP rather than HTTPS for the
+// given registry hosts. This is meant for local development registries.
+func WithPlainHTTP(registries ...string) Option {
+	return func(cl *Client) {
+		for _, r := range registries {
+			cl.plainHTTP[r] = true
+		}
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification
+func WithInsecureSkipVerify() Option {
+	return func(cl *Client) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		cl.httpClient = &http.Client{Transport: transport}
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with requests
+func WithUserAgent(ua string) Option {
+	return func(cl *Client) { cl.userAgent = ua }
+}
+
+// NewClient returns a Client. Without options it uses anonymous access and
+// HTTPS for every registry.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		httpClient:  http.DefaultClient,
+		credentials: StaticCredential(Credential{}),
+		plainHTTP:   map[string]bool{},
+		userAgent:   "krustlet-wasm2oci",
+		tokens:      map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// ErrNotFound is returned when a manifest, blob, or repository does not exist
+var ErrNotFound = errors.New("not found")
+
+// ResponseError is returned when a registry responds with an unexpected
+// status code
+type ResponseError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	// Errors are the error codes and messages from the registry, if it sent
+	// any in the distribution error format
+	Errors []RegistryError
+}
+
+// RegistryError is a single error in a distribution API error response
+type RegistryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%s %s: unexpected status %d", e.Method, e.URL, e.StatusCode)
+	for _, re := range e.Errors {
+		msg += fmt.Sprintf("; %s: %s", re.Code, re.Message)
+	}
+	return msg
+}
+
+// Is makes a 404 response match ErrNotFound
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// url builds the API URL for a path on the reference's registry
+func (c *Client) url(ref Reference, path string) string {
+	scheme := "https"
+	if c.plainHTTP[ref.Registry] {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s%s", scheme, ref.apiHost(), ref.Repository, path)
+}
+
+// resolveURL resolves a Location header against the request it came from.
+// Registries are free to return relative upload locations.
+func resolveURL(base *url.URL, location string) (string, error) {
+	u, err := base.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid location %q: %w", location, err)
+	}
+	return u.String(), nil
+}
+
+// newRequest creates a request whose body can be replayed after an auth
+// challenge
+func newRequest(ctx context.Context, method, u string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return req, nil
+}
+
+// do sends the request, answering an auth challenge once if the registry
+// asks for one. scope is the token scope needed for the request, in the form
+// "repository:<name>:<actions>".
+func (c *Client) do(req *http.Request, ref Reference, scope string) (*http.Response, error) {
+	req.Header.Set("User-Agent", c.userAgent)
+	key := ref.apiHost() + " " + scope
+	c.mu.Lock()
+	auth := c.tokens[key]
+	c.mu.Unlock()
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drain(resp)
+
+	auth, err = c.authorize(req.Context(), ref, challenge, scope)
+	if err != nil {
+		return nil, fmt.Errorf("authenticating to %s: %w", ref.Registry, err)
+	}
+	c.mu.Lock()
+	c.tokens[key] = auth
+	c.mu.Unlock()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", auth)
+	return c.httpClient.Do(retry)
+}
+
+// checkResponse turns an unexpected status into a ResponseError, closing the
+// body
+func checkResponse(resp *http.Response, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	defer drain(resp)
+	rerr := &ResponseError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+	}
+	var errs struct {
+		Errors []RegistryError `json:"errors"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(body, &errs) == nil {
+		rerr.Errors = errs.Errors
+	}
+	return rerr
+}
+
+// drain reads and closes the body so the connection can be reused
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
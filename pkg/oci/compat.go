@@ -0,0 +1,337 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CompatibilityTag is the tag CheckCompatibility pushes its test module to
+const CompatibilityTag = "krustlet-compat-check"
+
+// compatArtifactType is the artifact type of the test artifact
+const compatArtifactType = "application/vnd.krustlet.compat-check.v1+json"
+
+// The checks CheckCompatibility makes, in order
+const (
+	CheckPushModule    = "push wasm module"
+	CheckMediaTypes    = "wasm media types kept"
+	CheckPullByDigest  = "pull by digest"
+	CheckArtifacts     = "OCI 1.1 artifacts"
+	CheckReferrers     = "referrers API"
+	CheckChunkedUpload = "chunked uploads"
+	CheckDelete        = "delete manifests"
+)
+
+// CompatibilityCheck is the outcome of probing a registry for one behavior
+type CompatibilityCheck struct {
+	Name string `json:"name"`
+	// Required is set for behaviors krustlet can't pull modules without.
+	// The others are used by tools such as signers.
+	Required bool   `json:"required"`
+	Passed   bool   `json:"passed"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// CompatibilityReport is the outcome of CheckCompatibility
+type CompatibilityReport struct {
+	Repository string               `json:"repository"`
+	Checks     []CompatibilityCheck `json:"checks"`
+}
+
+// Compatible reports whether every required check passed
+func (r *CompatibilityReport) Compatible() bool {
+	for _, c := range r.Checks {
+		if c.Required && !c.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *CompatibilityReport) add(name string, required bool, err error, detail string) bool {
+	c := CompatibilityCheck{Name: name, Required: required, Passed: err == nil, Detail: detail}
+	if err != nil {
+		c.Detail = err.Error()
+	}
+	r.Checks = append(r.Checks, c)
+	return err == nil
+}
+
+func (r *CompatibilityReport) skip(name string, required bool, reason string) {
+	r.Checks = append(r.Checks, CompatibilityCheck{Name: name, Required: required, Detail: "skipped: " + reason})
+}
+
+// CheckCompatibility probes the repository for the behaviors krustlet and
+// its tools rely on. It pushes a small module tagged CompatibilityTag and an
+// artifact referring to it, and deletes both at the end if the registry
+// allows it. Failed checks are reported rather than returned as errors.
+func (c *Client) CheckCompatibility(ctx context.Context, repo Reference) *CompatibilityReport {
+	ref := Reference{Registry: repo.Registry, Repository: repo.Repository, Tag: CompatibilityTag}
+	report := &CompatibilityReport{Repository: repo.Name()}
+
+	module, err := compatModule()
+	if err != nil {
+		report.add(CheckPushModule, true, err, "")
+		return report
+	}
+	desc, err := c.Push(ctx, ref, module, PushOptions{Title: "compat-check.wasm"})
+	if !report.add(CheckPushModule, true, err, "") {
+		report.skip(CheckMediaTypes, true, "the module couldn't be pushed")
+		report.skip(CheckPullByDigest, true, "the module couldn't be pushed")
+		report.skip(CheckArtifacts, false, "the module couldn't be pushed")
+		report.skip(CheckReferrers, false, "the module couldn't be pushed")
+		report.add(CheckChunkedUpload, false, c.checkChunkedUpload(ctx, ref), "")
+		return report
+	}
+
+	report.add(CheckMediaTypes, true, c.checkMediaTypes(ctx, ref), "")
+	report.add(CheckPullByDigest, true, c.checkPullByDigest(ctx, ref.WithDigest(desc.Digest), module), "")
+
+	artifact, err := c.pushCompatArtifact(ctx, ref, desc)
+	if report.add(CheckArtifacts, false, err, "") {
+		report.add(CheckReferrers, false, c.checkReferrers(ctx, ref, desc.Digest, artifact.Digest), "")
+	} else {
+		report.skip(CheckReferrers, false, "the artifact couldn't be pushed")
+	}
+	report.add(CheckChunkedUpload, false, c.checkChunkedUpload(ctx, ref), "")
+
+	var deleteErr error
+	if artifact.Digest != "" {
+		deleteErr = c.deleteManifest(ctx, ref.WithDigest(artifact.Digest))
+	}
+	if deleteErr == nil {
+		deleteErr = c.deleteManifest(ctx, Reference{Registry: ref.Registry, Repository: ref.Repository, Digest: desc.Digest})
+	}
+	detail := ""
+	if deleteErr != nil {
+		deleteErr = fmt.Errorf("%w; remove the %s tag by hand", deleteErr, CompatibilityTag)
+	} else {
+		detail = "test manifests removed"
+	}
+	report.add(CheckDelete, false, deleteErr, detail)
+	return report
+}
+
+// compatModule returns a module with a random custom section, so its blobs
+// are never already in the registry and really get uploaded
+func compatModule() ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	name := "krustlet-compat-check"
+	section := append(append([]byte{byte(len(name))}, name...), nonce...)
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	module = append(module, 0x00, byte(len(section)))
+	return append(module, section...), nil
+}
+
+// checkMediaTypes checks the registry hands the module's manifest back as an
+// OCI manifest with the wasm config and layer media types, rather than
+// rejecting or rewriting them
+func (c *Client) checkMediaTypes(ctx context.Context, ref Reference) error {
+	data, desc, err := c.FetchManifest(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if desc.MediaType != ManifestMediaType {
+		return fmt.Errorf("manifest came back as %s", desc.MediaType)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("decoding manifest: %w", err)
+	}
+	if m.Config.MediaType != WasmConfigMediaType {
+		return fmt.Errorf("config media type came back as %s", m.Config.MediaType)
+	}
+	_, err = m.ModuleLayer()
+	return err
+}
+
+func (c *Client) checkPullByDigest(ctx context.Context, ref Reference, module []byte) error {
+	pulled, err := c.Pull(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pulled.Data, module) {
+		return errors.New("pulled module differs from the one pushed")
+	}
+	return nil
+}
+
+// pushCompatArtifact pushes an OCI 1.1 artifact with the module as its
+// subject, and checks the registry keeps its artifact type and subject
+func (c *Client) pushCompatArtifact(ctx context.Context, ref Reference, subject Descriptor) (Descriptor, error) {
+	empty, err := c.PushBlob(ctx, ref, EmptyMediaType, []byte("{}"))
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("pushing empty config: %w", err)
+	}
+	subject.Annotations = nil
+	data, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		ArtifactType:  compatArtifactType,
+		Config:        empty,
+		Layers:        []Descriptor{empty},
+		Subject:       &subject,
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc, err := c.PushManifest(ctx, Reference{Registry: ref.Registry, Repository: ref.Repository}, ManifestMediaType, data)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	got, _, err := c.FetchManifest(ctx, ref.WithDigest(desc.Digest))
+	if err != nil {
+		return desc, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(got, &m); err != nil {
+		return desc, fmt.Errorf("decoding artifact: %w", err)
+	}
+	if m.ArtifactType != compatArtifactType || m.Subject == nil || m.Subject.Digest != subject.Digest {
+		return desc, errors.New("registry dropped the artifact type or subject")
+	}
+	return desc, nil
+}
+
+// checkReferrers checks the referrers API lists the artifact for its subject
+func (c *Client) checkReferrers(ctx context.Context, ref Reference, subject, artifact string) error {
+	req, err := newRequest(ctx, http.MethodGet, c.url(ref, "/referrers/"+subject), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", IndexMediaType)
+	resp, err := c.do(req, ref, repositoryScope(ref, "pull"))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		drain(resp)
+		return errors.New("not supported; tools fall back to tags in the form sha256-<hex>")
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return err
+	}
+	defer drain(resp)
+	var idx Index
+	if err := json.NewDecoder(resp.Body).Decode(&idx); err != nil {
+		return fmt.Errorf("decoding referrers: %w", err)
+	}
+	for _, d := range idx.Manifests {
+		if d.Digest == artifact {
+			if d.ArtifactType != compatArtifactType {
+				return fmt.Errorf("artifact listed with type %q", d.ArtifactType)
+			}
+			return nil
+		}
+	}
+	return errors.New("artifact not listed; the registry may index referrers asynchronously")
+}
+
+// checkChunkedUpload uploads a blob in two chunks
+func (c *Client) checkChunkedUpload(ctx context.Context, ref Reference) error {
+	data := make([]byte, 2048)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	scope := repositoryScope(ref, "pull", "push")
+	req, err := newRequest(ctx, http.MethodPost, c.url(ref, "/blobs/uploads/"), []byte{})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp, http.StatusAccepted); err != nil {
+		return err
+	}
+	drain(resp)
+	location, err := resolveURL(resp.Request.URL, resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	for offset := 0; offset < len(data); offset += len(data) / 2 {
+		chunk := data[offset : offset+len(data)/2]
+		req, err := newRequest(ctx, http.MethodPatch, location, chunk)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+len(chunk)-1))
+		req.ContentLength = int64(len(chunk))
+		resp, err := c.do(req, ref, scope)
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(resp, http.StatusAccepted, http.StatusNoContent); err != nil {
+			return err
+		}
+		drain(resp)
+		if next := resp.Header.Get("Location"); next != "" {
+			if location, err = resolveURL(resp.Request.URL, next); err != nil {
+				return err
+			}
+		}
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("digest", digestOf(data))
+	u.RawQuery = q.Encode()
+	req, err = newRequest(ctx, http.MethodPut, u.String(), []byte{})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(req, ref, scope)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp, http.StatusCreated); err != nil {
+		return err
+	}
+	drain(resp)
+	exists, err := c.blobExists(ctx, ref, digestOf(data))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("uploaded blob is missing")
+	}
+	return nil
+}
+
+// deleteManifest deletes the manifest the reference points at
+func (c *Client) deleteManifest(ctx context.Context, ref Reference) error {
+	req, err := newRequest(ctx, http.MethodDelete, c.url(ref, "/manifests/"+ref.manifestReference()), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req, ref, repositoryScope(ref, "delete"))
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp, http.StatusAccepted, http.StatusOK, http.StatusNoContent); err != nil {
+		if strings.Contains(err.Error(), "UNSUPPORTED") || resp.StatusCode == http.StatusMethodNotAllowed {
+			return errors.New("the registry doesn't allow deleting manifests")
+		}
+		return err
+	}
+	drain(resp)
+	return nil
+}
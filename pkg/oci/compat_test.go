@@ -0,0 +1,93 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+func checkResults(report *CompatibilityReport) map[string]bool {
+	out := map[string]bool{}
+	for _, c := range report.Checks {
+		out[c.Name] = c.Passed
+	}
+	return out
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	reg := newTestRegistry(t)
+	repo, err := ParseRepository(reg.Ref("wasm/check"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := reg.client().CheckCompatibility(context.Background(), repo)
+	for _, c := range report.Checks {
+		if !c.Passed {
+			t.Errorf("check %q failed: %s", c.Name, c.Detail)
+		}
+	}
+	if len(report.Checks) != 7 || !report.Compatible() {
+		t.Errorf("unexpected report %+v", report)
+	}
+	tags, err := reg.client().Tags(context.Background(), repo)
+	if err != nil || len(tags) != 0 {
+		t.Errorf("expected the test tag to be removed, got %v, %v", tags, err)
+	}
+}
+
+// rejectingRegistry serves reg but rejects manifests containing reject, and
+// 404s the paths containing missing
+func rejectingRegistry(t *testing.T, reg *ocitest.Registry, reject, missing string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if missing != "" && strings.Contains(r.URL.Path, missing) {
+			http.NotFound(w, r)
+			return
+		}
+		if reject != "" && r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") {
+			body, _ := io.ReadAll(r.Body)
+			if bytes.Contains(body, []byte(reject)) {
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"errors":[{"code":"MANIFEST_INVALID","message":"unsupported media type"}]}`)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckCompatibilityFailures(t *testing.T) {
+	ctx := context.Background()
+
+	server := rejectingRegistry(t, ocitest.New(), WasmLayerMediaType, "")
+	u, _ := url.Parse(server.URL)
+	repo := Reference{Registry: u.Host, Repository: "wasm/check"}
+	client := NewClient(WithPlainHTTP(u.Host))
+	report := client.CheckCompatibility(ctx, repo)
+	results := checkResults(report)
+	if report.Compatible() || results[CheckPushModule] || !results[CheckChunkedUpload] {
+		t.Errorf("unexpected report for a registry rejecting wasm %+v", report)
+	}
+	if !strings.Contains(report.Checks[0].Detail, "MANIFEST_INVALID") {
+		t.Errorf("expected the registry's error in the detail, got %q", report.Checks[0].Detail)
+	}
+
+	server = rejectingRegistry(t, ocitest.New(), "", "/referrers/")
+	u, _ = url.Parse(server.URL)
+	repo = Reference{Registry: u.Host, Repository: "wasm/check"}
+	client = NewClient(WithPlainHTTP(u.Host))
+	report = client.CheckCompatibility(ctx, repo)
+	results = checkResults(report)
+	if !report.Compatible() || results[CheckReferrers] || !results[CheckArtifacts] {
+		t.Errorf("unexpected report for a registry without referrers %+v", report)
+	}
+}
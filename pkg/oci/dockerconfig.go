@@ -0,0 +1,173 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DockerConfig is the part of a docker config.json that holds registry
+// credentials
+type DockerConfig struct {
+	Auths       map[string]DockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore,omitempty"`
+	CredHelpers map[string]string     `json:"credHelpers,omitempty"`
+}
+
+// DockerAuth is a single entry in a docker config's auths
+type DockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+	RegistryToken string `json:"registrytoken,omitempty"`
+}
+
+// DockerConfigPath returns the path of the docker config file, honouring
+// DOCKER_CONFIG the same way the docker CLI does
+func DockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// LoadDockerConfig reads a docker config file. A missing file is not an
+// error and yields an empty config.
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	cfg := &DockerConfig{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// dockerHubServer is the server address docker login stores Docker Hub's
+// credentials under, in auths and in credential helpers alike
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// DockerCredentials returns a CredentialFunc that looks credentials up in the
+// docker config, including credential helpers, so that `docker login` is
+// enough to use a registry
+func DockerCredentials(cfg *DockerConfig) CredentialFunc {
+	return func(registry string) (Credential, error) {
+		server := registry
+		if registry == DefaultRegistry {
+			server = dockerHubServer
+		}
+		for _, key := range []string{registry, server} {
+			if helper := cfg.CredHelpers[key]; helper != "" {
+				return helperCredential(helper, server)
+			}
+		}
+		// With a credential store, docker login leaves an empty entry in
+		// auths to record the login; the credential is in the store
+		if auth, ok := cfg.lookup(registry); ok && !auth.empty() {
+			return auth.credential()
+		}
+		if cfg.CredsStore != "" {
+			return helperCredential(cfg.CredsStore, server)
+		}
+		return Credential{}, nil
+	}
+}
+
+// lookup finds the auths entry for a registry. Entries may be keyed by a
+// bare host or by a URL, and Docker Hub has historically been keyed by its
+// index URL
+func (cfg *DockerConfig) lookup(registry string) (DockerAuth, bool) {
+	candidates := []string{registry, "https://" + registry, "http://" + registry}
+	if registry == DefaultRegistry {
+		candidates = append(candidates, dockerHubServer, "index.docker.io", dockerHubHost)
+	}
+	for _, key := range candidates {
+		if auth, ok := cfg.Auths[key]; ok {
+			return auth, true
+		}
+	}
+	for key, auth := range cfg.Auths {
+		if hostOf(key) == registry {
+			return auth, true
+		}
+	}
+	return DockerAuth{}, false
+}
+
+func hostOf(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ := strings.Cut(key, "/")
+	return host
+}
+
+// empty reports whether the entry holds no credential
+func (a DockerAuth) empty() bool {
+	return a.Auth == "" && a.Username == "" && a.IdentityToken == "" && a.RegistryToken == ""
+}
+
+func (a DockerAuth) credential() (Credential, error) {
+	cred := Credential{
+		Username:      a.Username,
+		Password:      a.Password,
+		IdentityToken: a.IdentityToken,
+		RegistryToken: a.RegistryToken,
+	}
+	if a.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return Credential{}, fmt.Errorf("decoding auth: %w", err)
+		}
+		user, pass, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return Credential{}, fmt.Errorf("auth is not in user:password form")
+		}
+		cred.Username, cred.Password = user, pass
+	}
+	return cred, nil
+}
+
+// helperCredential runs docker-credential-<helper> to get the credential for
+// a registry
+func helperCredential(helper, registry string) (Credential, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers report a missing entry on stdout rather than through the
+		// exit code alone
+		if strings.Contains(string(out), "credentials not found") {
+			return Credential{}, nil
+		}
+		return Credential{}, fmt.Errorf("running docker-credential-%s: %w: %s", helper, err, strings.TrimSpace(stderr.String()))
+	}
+	var resp struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return Credential{}, fmt.Errorf("decoding docker-credential-%s output: %w", helper, err)
+	}
+	// Helpers use this username to mark the secret as an identity token
+	if resp.Username == "<token>" {
+		return Credential{IdentityToken: resp.Secret}, nil
+	}
+	return Credential{Username: resp.Username, Password: resp.Secret}, nil
+}
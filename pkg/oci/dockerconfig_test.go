@@ -0,0 +1,120 @@
+package oci
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDockerCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pa:ss"))
+	config := `{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "` + auth + `"},
+			"https://myregistry.example.com/v2/": {"username": "other", "password": "secret"},
+			"tokens.example.com": {"identitytoken": "refresh"}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadDockerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup := DockerCredentials(cfg)
+
+	tests := map[string]Credential{
+		"docker.io":              {Username: "user", Password: "pa:ss"},
+		"myregistry.example.com": {Username: "other", Password: "secret"},
+		"tokens.example.com":     {IdentityToken: "refresh"},
+		"anonymous.example.com":  {},
+	}
+	for registry, want := range tests {
+		got, err := lookup(registry)
+		if err != nil {
+			t.Errorf("%s: %v", registry, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", registry, got, want)
+		}
+	}
+}
+
+// fakeHelper installs docker-credential-<name> on PATH, which answers with
+// the username <name> and the server it was asked for as the secret, and
+// reports no credentials for servers outside known
+func fakeHelper(t *testing.T, name string, known ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake credential helpers are shell scripts")
+	}
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read server
+case "$server" in
+` + strings.Join(known, "|") + `) printf '{"Username":"` + name + `","Secret":"%s"}' "$server" ;;
+*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-"+name), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestDockerCredentialHelpers(t *testing.T) {
+	fakeHelper(t, "store", "https://index.docker.io/v1/", "myregistry.example.com")
+	fakeHelper(t, "ecr", "123456789012.dkr.ecr.us-east-1.amazonaws.com")
+	auth := base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	cfg := &DockerConfig{
+		// docker login leaves empty entries when it stores the credential
+		Auths: map[string]DockerAuth{
+			"https://index.docker.io/v1/": {},
+			"myregistry.example.com":      {},
+			"inline.example.com":          {Auth: auth},
+		},
+		CredsStore:  "store",
+		CredHelpers: map[string]string{"123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr"},
+	}
+	lookup := DockerCredentials(cfg)
+
+	tests := map[string]Credential{
+		"docker.io":              {Username: "store", Password: "https://index.docker.io/v1/"},
+		"myregistry.example.com": {Username: "store", Password: "myregistry.example.com"},
+		"inline.example.com":     {Username: "user", Password: "pass"},
+		"anonymous.example.com":  {},
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com": {Username: "ecr", Password: "123456789012.dkr.ecr.us-east-1.amazonaws.com"},
+	}
+	for registry, want := range tests {
+		got, err := lookup(registry)
+		if err != nil {
+			t.Errorf("%s: %v", registry, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", registry, got, want)
+		}
+	}
+
+	// Docker Hub's helper is configured under its index URL
+	cfg = &DockerConfig{CredHelpers: map[string]string{"https://index.docker.io/v1/": "store"}}
+	if got, err := DockerCredentials(cfg)("docker.io"); err != nil || got.Username != "store" {
+		t.Errorf("expected Docker Hub's helper to be used, got %+v, %v", got, err)
+	}
+}
+
+func TestLoadDockerConfigMissing(t *testing.T) {
+	cfg, err := LoadDockerConfig(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Auths) != 0 {
+		t.Errorf("expected an empty config, got %+v", cfg)
+	}
+}
@@ -0,0 +1,265 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Annotation keys set on modules extracted from container images
+const (
+	// AnnotationBaseName is the reference of the image a module came from
+	AnnotationBaseName = "org.opencontainers.image.base.name"
+	// AnnotationBaseDigest is the manifest digest of that image
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// maxImageModuleSize caps the size of a module read out of an image layer
+const maxImageModuleSize = 256 * 1024 * 1024
+
+// whiteoutPrefix marks files deleted by a layer; whiteoutOpaque marks a
+// directory whose earlier contents are all deleted
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ImageModule is a wasm module found in a container image, usually a
+// scratch image built by a pipeline that only produces container images
+type ImageModule struct {
+	// Data is the module binary
+	Data []byte
+	// Path is where the module is in the image's filesystem
+	Path string
+	// Digest is the digest of the image's manifest, when it came from a
+	// registry
+	Digest string
+}
+
+// imageConfig is the part of an image config that says what the image runs
+type imageConfig struct {
+	Config struct {
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+		WorkingDir string   `json:"WorkingDir"`
+	} `json:"config"`
+}
+
+// entrypoint returns the absolute path of the program the image runs, if
+// it has one
+func (c *imageConfig) entrypoint() string {
+	args := c.Config.Entrypoint
+	if len(args) == 0 {
+		args = c.Config.Cmd
+	}
+	if len(args) == 0 || args[0] == "" {
+		return ""
+	}
+	if path.IsAbs(args[0]) {
+		return path.Clean(args[0])
+	}
+	return path.Join("/", c.Config.WorkingDir, args[0])
+}
+
+// imageFiles collects candidate module files while layers are applied in
+// order, so files deleted or replaced by later layers are dropped
+type imageFiles struct {
+	// want are paths kept even without a .wasm extension
+	want  map[string]bool
+	files map[string][]byte
+}
+
+func newImageFiles(want ...string) *imageFiles {
+	f := &imageFiles{want: map[string]bool{}, files: map[string][]byte{}}
+	for _, p := range want {
+		if p != "" {
+			f.want[p] = true
+		}
+	}
+	return f
+}
+
+// apply reads a layer, which may be gzip compressed
+func (f *imageFiles) apply(layer io.Reader) error {
+	br := bufio.NewReader(layer)
+	r := io.Reader(br)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else if magic, err := br.Peek(4); err == nil && bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		return errors.New("zstd compressed layers are not supported; rebuild the image with gzip compression")
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Join("/", hdr.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == whiteoutOpaque:
+			f.remove(path.Clean(dir), false)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			f.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), true)
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		// A file replaces whatever an earlier layer had at its path
+		delete(f.files, name)
+		if hdr.Typeflag != tar.TypeReg || !(strings.HasSuffix(name, ".wasm") || f.want[name]) {
+			continue
+		}
+		if hdr.Size > maxImageModuleSize {
+			return fmt.Errorf("%s is larger than %d bytes", name, maxImageModuleSize)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		f.files[name] = data
+	}
+}
+
+// remove drops the files under dir, and dir itself if self is set
+func (f *imageFiles) remove(dir string, self bool) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for name := range f.files {
+		if (self && name == dir) || strings.HasPrefix(name, prefix) {
+			delete(f.files, name)
+		}
+	}
+}
+
+// module picks the image's module: the file at modulePath if given, else the
+// image's entrypoint if it is a module, else the only .wasm file in the image
+func (f *imageFiles) module(config *imageConfig, modulePath string) (*ImageModule, error) {
+	if modulePath != "" {
+		data, ok := f.files[modulePath]
+		if !ok {
+			return nil, fmt.Errorf("image has no file %s", modulePath)
+		}
+		if !isWasm(data) {
+			return nil, fmt.Errorf("%s is not a WebAssembly binary", modulePath)
+		}
+		return &ImageModule{Data: data, Path: modulePath}, nil
+	}
+	if entry := config.entrypoint(); entry != "" && isWasm(f.files[entry]) {
+		return &ImageModule{Data: f.files[entry], Path: entry}, nil
+	}
+	var found []string
+	for name, data := range f.files {
+		if strings.HasSuffix(name, ".wasm") && isWasm(data) {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.New("image has no .wasm file")
+	case 1:
+		return &ImageModule{Data: f.files[found[0]], Path: found[0]}, nil
+	}
+	sort.Strings(found)
+	return nil, fmt.Errorf("image has more than one .wasm file (%s); choose one by its path", strings.Join(found, ", "))
+}
+
+// cleanModulePath makes a path given by the user absolute within the image
+func cleanModulePath(p string) string {
+	if p == "" {
+		return ""
+	}
+	return path.Join("/", p)
+}
+
+// PullImageModule extracts a wasm module from the container image the
+// reference points at. modulePath is the module's path in the image; if it
+// is empty, the image's entrypoint is used if it is a module, or else the
+// only .wasm file in the image. For an index, the entry for a wasm platform is
+// used, or else the first entry for a real platform.
+func (c *Client) PullImageModule(ctx context.Context, ref Reference, modulePath string) (*ImageModule, error) {
+	data, desc, err := c.FetchManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if isIndex(desc.MediaType) {
+		var idx Index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, fmt.Errorf("decoding index: %w", err)
+		}
+		entry, err := selectImageManifest(&idx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+		if data, desc, err = c.FetchManifest(ctx, ref.WithDigest(entry.Digest)); err != nil {
+			return nil, err
+		}
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+	if _, err := m.ModuleLayer(); err == nil {
+		return nil, fmt.Errorf("%s is already a wasm module; use copy to move it to another repository", ref)
+	}
+
+	configData, err := c.FetchBlob(ctx, ref, m.Config)
+	if err != nil {
+		return nil, fmt.Errorf("fetching image config: %w", err)
+	}
+	var config imageConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return nil, fmt.Errorf("decoding image config: %w", err)
+	}
+	modulePath = cleanModulePath(modulePath)
+	files := newImageFiles(modulePath, config.entrypoint())
+	for _, l := range m.Layers {
+		layer, err := c.FetchBlob(ctx, ref, l)
+		if err != nil {
+			return nil, fmt.Errorf("fetching layer: %w", err)
+		}
+		if err := files.apply(bytes.NewReader(layer)); err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", l.Digest, err)
+		}
+	}
+	module, err := files.module(&config, modulePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	module.Digest = desc.Digest
+	return module, nil
+}
+
+// selectImageManifest picks the index entry to extract a module from.
+// Images built for a wasm platform are preferred; otherwise the module is
+// taken from the first image for a real platform, skipping attestations.
+func selectImageManifest(idx *Index) (Descriptor, error) {
+	if d, err := selectWasmManifest(idx); err == nil {
+		return d, nil
+	}
+	for _, d := range idx.Manifests {
+		if d.Platform != nil && d.Platform.OS != "unknown" {
+			return d, nil
+		}
+	}
+	return Descriptor{}, errors.New("index has no image manifests")
+}
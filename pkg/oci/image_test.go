@@ -0,0 +1,191 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// layerFile is a file in a test layer; a nil body makes a directory
+type layerFile struct {
+	name string
+	body []byte
+}
+
+func testLayer(t *testing.T, compress bool, files ...layerFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var gz *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gz)
+	}
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if f.body == nil {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(f.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func testImageConfig(t *testing.T, entrypoint ...string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"config":       map[string]interface{}{"Entrypoint": entrypoint},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// pushImage pushes a Docker image with the config and layers
+func pushImage(t *testing.T, client *Client, ref Reference, config []byte, layers ...[]byte) Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	m := Manifest{SchemaVersion: 2, MediaType: DockerManifestMediaType}
+	var err error
+	if m.Config, err = client.PushBlob(ctx, ref, "application/vnd.docker.container.image.v1+json", config); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range layers {
+		desc, err := client.PushBlob(ctx, ref, "application/vnd.docker.image.rootfs.diff.tar.gzip", l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Layers = append(m.Layers, desc)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := client.PushManifest(ctx, ref, DockerManifestMediaType, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestPullImageModule(t *testing.T) {
+	reg := newTestRegistry(t)
+	client := reg.client()
+	ctx := context.Background()
+	other := append(append([]byte(nil), testModule...), 1)
+
+	ref := reg.ref(t, "images/app:v1")
+	desc := pushImage(t, client, ref, testImageConfig(t, "/app.wasm"),
+		testLayer(t, true, layerFile{"app.wasm", testModule}, layerFile{"lib/", nil}, layerFile{"lib/helper.wasm", other}),
+	)
+	module, err := client.PullImageModule(ctx, ref, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module.Path != "/app.wasm" || !bytes.Equal(module.Data, testModule) || module.Digest != desc.Digest {
+		t.Errorf("unexpected module %s %q %s", module.Path, module.Data, module.Digest)
+	}
+	module, err = client.PullImageModule(ctx, ref, "lib/helper.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(module.Data, other) {
+		t.Errorf("got %q from --path", module.Data)
+	}
+
+	// Later layers replace and delete files from earlier ones
+	ref = reg.ref(t, "images/layered:v1")
+	pushImage(t, client, ref, testImageConfig(t),
+		testLayer(t, false, layerFile{"a.wasm", testModule}, layerFile{"lib/b.wasm", testModule}),
+		testLayer(t, true, layerFile{".wh.a.wasm", []byte{}}, layerFile{"lib/", nil}, layerFile{"lib/b.wasm", other}),
+	)
+	module, err = client.PullImageModule(ctx, ref, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module.Path != "/lib/b.wasm" || !bytes.Equal(module.Data, other) {
+		t.Errorf("unexpected module %s %q", module.Path, module.Data)
+	}
+
+	ref = reg.ref(t, "images/ambiguous:v1")
+	pushImage(t, client, ref, testImageConfig(t, "/bin/sh"),
+		testLayer(t, true, layerFile{"a.wasm", testModule}, layerFile{"b.wasm", testModule}),
+	)
+	if _, err := client.PullImageModule(ctx, ref, ""); err == nil || !strings.Contains(err.Error(), "/a.wasm, /b.wasm") {
+		t.Errorf("expected an error listing both modules, got %v", err)
+	}
+
+	ref = reg.ref(t, "images/module:v1")
+	if _, err := client.Push(ctx, ref, testModule, PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.PullImageModule(ctx, ref, ""); err == nil || !strings.Contains(err.Error(), "already a wasm module") {
+		t.Errorf("expected a module to be rejected, got %v", err)
+	}
+}
+
+func TestSelectImageManifest(t *testing.T) {
+	idx := &Index{Manifests: []Descriptor{
+		{Digest: "sha256:attestation", Platform: &Platform{Architecture: "unknown", OS: "unknown"}},
+		{Digest: "sha256:amd64", Platform: &Platform{Architecture: "amd64", OS: "linux"}},
+	}}
+	if d, err := selectImageManifest(idx); err != nil || d.Digest != "sha256:amd64" {
+		t.Errorf("got %s, %v, want the linux/amd64 image", d.Digest, err)
+	}
+	idx.Manifests = append(idx.Manifests, Descriptor{Digest: "sha256:wasm", Platform: &Platform{Architecture: "wasm", OS: "wasip1"}})
+	if d, err := selectImageManifest(idx); err != nil || d.Digest != "sha256:wasm" {
+		t.Errorf("got %s, %v, want the wasm image", d.Digest, err)
+	}
+}
+
+func TestReadArchiveModule(t *testing.T) {
+	layer := testLayer(t, false, layerFile{"app.wasm", testModule})
+	config := testImageConfig(t, "app.wasm")
+	manifest, err := json.Marshal([]archiveManifest{
+		{Config: "blobs/sha256/config", RepoTags: []string{"app:v1"}, Layers: []string{"blobs/sha256/layer"}},
+		{Config: "blobs/sha256/config", RepoTags: []string{"docker.io/library/other:v1"}, Layers: []string{"blobs/sha256/layer"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files are written out of order to check the archive is searched
+	archive := testLayer(t, false,
+		layerFile{"blobs/sha256/layer", layer},
+		layerFile{"manifest.json", manifest},
+		layerFile{"blobs/sha256/config", config},
+	)
+
+	module, err := ReadArchiveModule(bytes.NewReader(archive), "other:v1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module.Path != "/app.wasm" || !bytes.Equal(module.Data, testModule) || module.Digest != "" {
+		t.Errorf("unexpected module %s %q %s", module.Path, module.Data, module.Digest)
+	}
+	if _, err := ReadArchiveModule(bytes.NewReader(archive), "", ""); err == nil {
+		t.Error("expected an error choosing between two images")
+	}
+	if _, err := ReadArchiveModule(bytes.NewReader(layer), "", ""); err == nil || !strings.Contains(err.Error(), "docker save") {
+		t.Errorf("expected an error for a plain tarball, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package oci
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Media types used for wasm modules. These match the types krustlet's module
+// store accepts when pulling, so anything pushed with this package can be run
+// by krustlet as-is.
+const (
+	// WasmLayerMediaType is the media type of the layer holding the module
+	WasmLayerMediaType = "application/vnd.wasm.content.layer.v1+wasm"
+	// WasmConfigMediaType is the media type of the module config blob
+	WasmConfigMediaType = "application/vnd.wasm.config.v1+json"
+
+	// ManifestMediaType is the OCI image manifest media type
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// IndexMediaType is the OCI image index media type
+	IndexMediaType = "application/vnd.oci.image.index.v1+json"
+	// DockerManifestMediaType is the Docker v2 schema 2 manifest media type
+	DockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	// DockerManifestListMediaType is the Docker v2 manifest list media type
+	DockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	// EmptyMediaType is the media type of the empty JSON blob OCI 1.1
+	// artifacts use as their config when they have no config
+	EmptyMediaType = "application/vnd.oci.empty.v1+json"
+)
+
+// Annotation keys set when pushing a module
+const (
+	// AnnotationTitle is the file name of the module
+	AnnotationTitle = "org.opencontainers.image.title"
+	// AnnotationCreated is the time the module was pushed
+	AnnotationCreated = "org.opencontainers.image.created"
+)
+
+// manifestAcceptTypes is the Accept header sent when fetching manifests
+var manifestAcceptTypes = strings.Join([]string{
+	ManifestMediaType,
+	DockerManifestMediaType,
+	IndexMediaType,
+	DockerManifestListMediaType,
+}, ", ")
+
+// Descriptor describes a piece of content in a registry
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	// ArtifactType is set on referrers API entries
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// Platform describes the platform an entry of an index targets
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// Manifest is an OCI image manifest. Docker v2 manifests decode into the same
+// structure.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	// ArtifactType and Subject are set on OCI 1.1 artifacts, such as
+	// signatures, that refer to another manifest
+	ArtifactType string      `json:"artifactType,omitempty"`
+	Subject      *Descriptor `json:"subject,omitempty"`
+}
+
+// Index is an OCI image index or Docker manifest list
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// ErrNotModule is returned when a reference points at something other than a
+// wasm module, most often a container image
+var ErrNotModule = errors.New("not a wasm module")
+
+// ModuleLayer returns the layer holding the wasm module
+func (m *Manifest) ModuleLayer() (Descriptor, error) {
+	for _, l := range m.Layers {
+		if l.MediaType == WasmLayerMediaType {
+			return l, nil
+		}
+	}
+	types := make([]string, 0, len(m.Layers))
+	for _, l := range m.Layers {
+		types = append(types, l.MediaType)
+	}
+	return Descriptor{}, fmt.Errorf("%w: manifest has no %s layer (found %s); this looks like a container image", ErrNotModule, WasmLayerMediaType, strings.Join(types, ", "))
+}
+
+// isIndex reports whether the media type is an index or manifest list
+func isIndex(mediaType string) bool {
+	return mediaType == IndexMediaType || mediaType == DockerManifestListMediaType
+}
+
+// selectWasmManifest picks the index entry that targets wasm. Entries
+// without a platform are skipped since they are usually attestations.
+func selectWasmManifest(idx *Index) (Descriptor, error) {
+	for _, d := range idx.Manifests {
+		if d.Platform == nil {
+			continue
+		}
+		if d.Platform.Architecture == "wasm" || d.Platform.Architecture == "wasm32" || d.Platform.OS == "wasi" || d.Platform.OS == "wasip1" {
+			return d, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("%w: index has no manifest for a wasm platform; this looks like a multi-platform container image", ErrNotModule)
+}
+
+// digestOf returns the sha256 digest of data in descriptor form
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// descriptorFor returns a descriptor for data with the given media type
+func descriptorFor(mediaType string, data []byte) Descriptor {
+	return Descriptor{MediaType: mediaType, Digest: digestOf(data), Size: int64(len(data))}
+}
+
+// mediaTypeOf returns the media type declared inside a manifest or index,
+// falling back to the Content-Type the registry sent
+func mediaTypeOf(data []byte, contentType string) string {
+	var probe struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(data, &probe) == nil && probe.MediaType != "" {
+		return probe.MediaType
+	}
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.TrimSpace(contentType)
+}
@@ -0,0 +1,223 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Module is a wasm module pulled from a registry
+type Module struct {
+	// Data is the module binary
+	Data []byte
+	// Manifest is the manifest the module was found through
+	Manifest Manifest
+	// Digest is the digest of that manifest
+	Digest string
+}
+
+// PushOptions are optional settings for Push
+type PushOptions struct {
+	// Title is recorded as the layer's title annotation, typically the name
+	// of the file the module came from
+	Title string
+	// Annotations are added to the manifest
+	Annotations map[string]string
+	// Created is recorded as the manifest's created annotation. It defaults
+	// to the current time
+	Created time.Time
+}
+
+// Push uploads a wasm module and tags it with the reference's tag. It returns
+// the descriptor of the pushed manifest.
+func (c *Client) Push(ctx context.Context, ref Reference, module []byte, opts PushOptions) (Descriptor, error) {
+	if !isWasm(module) {
+		return Descriptor{}, fmt.Errorf("module is not a WebAssembly binary")
+	}
+
+	config, err := c.PushBlob(ctx, ref, WasmConfigMediaType, []byte("{}"))
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("pushing config: %w", err)
+	}
+	layer, err := c.PushBlob(ctx, ref, WasmLayerMediaType, module)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("pushing module: %w", err)
+	}
+	if opts.Title != "" {
+		layer.Annotations = map[string]string{AnnotationTitle: opts.Title}
+	}
+
+	created := opts.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	annotations := map[string]string{AnnotationCreated: created.UTC().Format(time.RFC3339)}
+	for k, v := range opts.Annotations {
+		annotations[k] = v
+	}
+
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		Config:        config,
+		Layers:        []Descriptor{layer},
+		Annotations:   annotations,
+	})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc, err := c.PushManifest(ctx, ref, ManifestMediaType, manifest)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("pushing manifest: %w", err)
+	}
+	return desc, nil
+}
+
+// Pull downloads the wasm module the reference points at. Indexes are
+// followed to the entry for a wasm platform.
+func (c *Client) Pull(ctx context.Context, ref Reference) (*Module, error) {
+	manifest, desc, err := c.FetchModuleManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := manifest.ModuleLayer()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	data, err := c.FetchBlob(ctx, ref, layer)
+	if err != nil {
+		return nil, fmt.Errorf("pulling module: %w", err)
+	}
+	return &Module{Data: data, Manifest: *manifest, Digest: desc.Digest}, nil
+}
+
+// FetchModuleManifest fetches the manifest for the reference, following an
+// index to its wasm entry. An index without a wasm entry is an ErrNotModule
+// error; the manifest's layers are not checked.
+func (c *Client) FetchModuleManifest(ctx context.Context, ref Reference) (*Manifest, Descriptor, error) {
+	data, desc, err := c.FetchManifest(ctx, ref)
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	if isIndex(desc.MediaType) {
+		var idx Index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, Descriptor{}, fmt.Errorf("decoding index: %w", err)
+		}
+		entry, err := selectWasmManifest(&idx)
+		if err != nil {
+			return nil, Descriptor{}, fmt.Errorf("%s: %w", ref, err)
+		}
+		if data, desc, err = c.FetchManifest(ctx, ref.WithDigest(entry.Digest)); err != nil {
+			return nil, Descriptor{}, err
+		}
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, Descriptor{}, fmt.Errorf("decoding manifest: %w", err)
+	}
+	return &m, desc, nil
+}
+
+// Inspection describes what a reference points at
+type Inspection struct {
+	Reference string          `json:"reference"`
+	Digest    string          `json:"digest"`
+	MediaType string          `json:"mediaType"`
+	Manifest  *Manifest       `json:"manifest,omitempty"`
+	Index     *Index          `json:"index,omitempty"`
+	Config    json.RawMessage `json:"config,omitempty"`
+}
+
+// Inspect fetches the manifest the reference points at and, for a manifest,
+// its config. Modules are not downloaded.
+func (c *Client) Inspect(ctx context.Context, ref Reference) (*Inspection, error) {
+	data, desc, err := c.FetchManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	in := &Inspection{Reference: ref.String(), Digest: desc.Digest, MediaType: desc.MediaType}
+	if isIndex(desc.MediaType) {
+		in.Index = &Index{}
+		if err := json.Unmarshal(data, in.Index); err != nil {
+			return nil, fmt.Errorf("decoding index: %w", err)
+		}
+		return in, nil
+	}
+
+	in.Manifest = &Manifest{}
+	if err := json.Unmarshal(data, in.Manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+	config, err := c.FetchBlob(ctx, ref, in.Manifest.Config)
+	if err != nil {
+		return nil, fmt.Errorf("fetching config: %w", err)
+	}
+	if json.Valid(config) {
+		in.Config = config
+	}
+	return in, nil
+}
+
+// Copy copies everything the source reference points at to the destination,
+// which may be on another registry. Digests are preserved, so the copy can be
+// pulled by the same digest as the original. It returns the descriptor of the
+// copied manifest.
+func (c *Client) Copy(ctx context.Context, src, dst Reference) (Descriptor, error) {
+	data, desc, err := c.FetchManifest(ctx, src)
+	if err != nil {
+		return Descriptor{}, err
+	}
+
+	if isIndex(desc.MediaType) {
+		var idx Index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return Descriptor{}, fmt.Errorf("decoding index: %w", err)
+		}
+		for _, entry := range idx.Manifests {
+			if _, err := c.Copy(ctx, src.WithDigest(entry.Digest), Reference{Registry: dst.Registry, Repository: dst.Repository, Digest: entry.Digest}); err != nil {
+				return Descriptor{}, err
+			}
+		}
+	} else {
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return Descriptor{}, fmt.Errorf("decoding manifest: %w", err)
+		}
+		for _, blob := range append([]Descriptor{m.Config}, m.Layers...) {
+			if err := c.copyBlob(ctx, src, dst, blob); err != nil {
+				return Descriptor{}, err
+			}
+		}
+	}
+
+	pushed, err := c.PushManifest(ctx, dst, desc.MediaType, data)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("pushing manifest: %w", err)
+	}
+	return pushed, nil
+}
+
+func (c *Client) copyBlob(ctx context.Context, src, dst Reference, blob Descriptor) error {
+	exists, err := c.blobExists(ctx, dst, blob.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	data, err := c.FetchBlob(ctx, src, blob)
+	if err != nil {
+		return fmt.Errorf("copying blob: %w", err)
+	}
+	if _, err := c.PushBlob(ctx, dst, blob.MediaType, data); err != nil {
+		return fmt.Errorf("copying blob: %w", err)
+	}
+	return nil
+}
+
+// isWasm reports whether data starts with the WebAssembly magic number
+func isWasm(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == "\x00asm"
+}
@@ -0,0 +1,378 @@
+package ocitest
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultKind is a kind of fault Chaos injects
+type FaultKind string
+
+const (
+	// FaultTimeout holds the request without answering until Delay passes,
+	// or until the client gives up if Delay is 0, then drops the connection
+	FaultTimeout FaultKind = "timeout"
+	// FaultStatus answers with Status instead of forwarding the request
+	FaultStatus FaultKind = "status"
+	// FaultTruncate forwards the request but drops the connection after
+	// Bytes bytes of the response body
+	FaultTruncate FaultKind = "truncate"
+	// FaultSlow forwards the request and sends the response body at Rate
+	// bytes a second
+	FaultSlow FaultKind = "slow"
+	// FaultReset resets the connection without answering, after the TLS
+	// handshake if the proxy serves TLS
+	FaultReset FaultKind = "reset"
+)
+
+// Fault is a fault to inject into the requests it matches
+type Fault struct {
+	Kind FaultKind
+	// Path is a regular expression matched against the request path. Empty
+	// matches every path.
+	Path string
+	// Method, if set, is the request method to match
+	Method string
+	// Probability is the chance of injecting the fault into a matching
+	// request. 0 injects it into every one.
+	Probability float64
+	// After skips the first After matching requests
+	After int
+	// Times injects the fault at most this many times. 0 is unlimited.
+	Times int
+
+	// Status is the status FaultStatus answers with, 429 if not set
+	Status int
+	// RetryAfter is sent in the Retry-After header of a FaultStatus answer
+	RetryAfter time.Duration
+	// Delay is how long FaultTimeout holds a request for
+	Delay time.Duration
+	// Bytes is how much of the body FaultTruncate sends
+	Bytes int64
+	// Rate is the bytes a second FaultSlow sends, 1024 if not set
+	Rate int64
+}
+
+// ParseFault parses a fault in the form kind[:key=value,...], such as
+// "truncate:path=/blobs/,bytes=100" or "429:times=2". A status code on its
+// own is short for a status fault with that code. The keys are path,
+// method, probability, after, times, status, retry-after, delay, bytes and
+// rate.
+func ParseFault(s string) (Fault, error) {
+	kind, opts, _ := strings.Cut(s, ":")
+	var f Fault
+	if code, err := strconv.Atoi(kind); err == nil {
+		f.Kind, f.Status = FaultStatus, code
+	} else {
+		f.Kind = FaultKind(kind)
+	}
+	if opts != "" {
+		for _, opt := range strings.Split(opts, ",") {
+			key, value, ok := strings.Cut(opt, "=")
+			if !ok {
+				return Fault{}, fmt.Errorf("fault %q: expected key=value, got %q", s, opt)
+			}
+			if err := f.set(key, value); err != nil {
+				return Fault{}, fmt.Errorf("fault %q: %s: %w", s, key, err)
+			}
+		}
+	}
+	if err := f.validate(); err != nil {
+		return Fault{}, fmt.Errorf("fault %q: %w", s, err)
+	}
+	return f, nil
+}
+
+func (f *Fault) set(key, value string) error {
+	var err error
+	switch key {
+	case "path":
+		f.Path = value
+	case "method":
+		f.Method = strings.ToUpper(value)
+	case "probability":
+		f.Probability, err = strconv.ParseFloat(value, 64)
+	case "after":
+		f.After, err = strconv.Atoi(value)
+	case "times":
+		f.Times, err = strconv.Atoi(value)
+	case "status":
+		f.Status, err = strconv.Atoi(value)
+	case "retry-after":
+		f.RetryAfter, err = time.ParseDuration(value)
+	case "delay":
+		f.Delay, err = time.ParseDuration(value)
+	case "bytes":
+		f.Bytes, err = strconv.ParseInt(value, 10, 64)
+	case "rate":
+		f.Rate, err = strconv.ParseInt(value, 10, 64)
+	default:
+		return errors.New("unknown key")
+	}
+	return err
+}
+
+func (f *Fault) validate() error {
+	switch f.Kind {
+	case FaultTimeout, FaultStatus, FaultTruncate, FaultSlow, FaultReset:
+	default:
+		return fmt.Errorf("unknown kind %q", f.Kind)
+	}
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("probability %v is not between 0 and 1", f.Probability)
+	}
+	if f.Status != 0 && (f.Status < 100 || f.Status > 599) {
+		return fmt.Errorf("invalid status %d", f.Status)
+	}
+	if f.Bytes < 0 || f.Rate < 0 || f.After < 0 || f.Times < 0 {
+		return errors.New("counts can't be negative")
+	}
+	if _, err := regexp.Compile(f.Path); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (f Fault) String() string {
+	s := string(f.Kind)
+	if f.Kind == FaultStatus {
+		s = strconv.Itoa(f.status())
+	}
+	if f.Path != "" {
+		s += " " + f.Path
+	}
+	return s
+}
+
+func (f Fault) status() int {
+	if f.Status == 0 {
+		return http.StatusTooManyRequests
+	}
+	return f.Status
+}
+
+// Chaos is a proxy in front of a registry that injects faults, to check how
+// clients cope with an unreliable network or registry. Each request gets the
+// first fault that matches it and is picked by its probability, or none.
+type Chaos struct {
+	// OnFault, if set, is called for every fault injected
+	OnFault func(Fault, *http.Request)
+
+	proxy  *httputil.ReverseProxy
+	faults []chaosFault
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	injected map[FaultKind]int
+}
+
+type chaosFault struct {
+	Fault
+	path *regexp.Regexp
+	// matched and injected count the requests the fault matched and was
+	// injected into
+	matched, injected int
+}
+
+// NewChaos returns a proxy that forwards requests to upstream, injecting
+// faults. Probabilities are drawn from a source seeded with seed, so a run
+// with the same seed and requests injects the same faults.
+func NewChaos(upstream *url.URL, seed int64, faults ...Fault) (*Chaos, error) {
+	c := &Chaos{rand: rand.New(rand.NewSource(seed)), injected: map[FaultKind]int{}}
+	for _, f := range faults {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+		c.faults = append(c.faults, chaosFault{Fault: f, path: regexp.MustCompile(f.Path)})
+	}
+	c.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			r.Out.Host = upstream.Host
+			r.SetXForwarded()
+		},
+		// Upload locations and redirects to the registry itself have to
+		// come back through the proxy; redirects elsewhere, such as to blob
+		// storage, are left alone
+		ModifyResponse: func(resp *http.Response) error {
+			loc, err := resp.Location()
+			host := resp.Request.Header.Get("X-Forwarded-Host")
+			if err != nil || loc.Host != upstream.Host || host == "" {
+				return nil
+			}
+			loc.Scheme, loc.Host = resp.Request.Header.Get("X-Forwarded-Proto"), host
+			resp.Header.Set("Location", loc.String())
+			return nil
+		},
+	}
+	return c, nil
+}
+
+// Injected returns how many times each kind of fault has been injected
+func (c *Chaos) Injected() map[FaultKind]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	injected := make(map[FaultKind]int, len(c.injected))
+	for k, v := range c.injected {
+		injected[k] = v
+	}
+	return injected
+}
+
+// pick returns the fault to inject into the request, if any
+func (c *Chaos) pick(req *http.Request) (Fault, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.faults {
+		f := &c.faults[i]
+		if (f.Method != "" && f.Method != req.Method) || !f.path.MatchString(req.URL.Path) {
+			continue
+		}
+		f.matched++
+		if f.matched <= f.After || (f.Times > 0 && f.injected >= f.Times) {
+			continue
+		}
+		if f.Probability > 0 && c.rand.Float64() >= f.Probability {
+			continue
+		}
+		f.injected++
+		c.injected[f.Kind]++
+		return f.Fault, true
+	}
+	return Fault{}, false
+}
+
+// ServeHTTP implements http.Handler
+func (c *Chaos) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f, ok := c.pick(req)
+	if !ok {
+		c.proxy.ServeHTTP(w, req)
+		return
+	}
+	if c.OnFault != nil {
+		c.OnFault(f, req)
+	}
+
+	switch f.Kind {
+	case FaultStatus:
+		if f.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int((f.RetryAfter+time.Second-1)/time.Second)))
+		}
+		code, message := "UNKNOWN", "injected fault"
+		switch f.status() {
+		case http.StatusTooManyRequests:
+			code, message = "TOOMANYREQUESTS", "too many requests"
+		case http.StatusServiceUnavailable:
+			code, message = "UNAVAILABLE", "service unavailable"
+		}
+		writeError(w, f.status(), code, message)
+	case FaultTimeout:
+		var timeout <-chan time.Time
+		if f.Delay > 0 {
+			timer := time.NewTimer(f.Delay)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-req.Context().Done():
+		case <-timeout:
+		}
+		panic(http.ErrAbortHandler)
+	case FaultReset:
+		reset(w)
+	case FaultTruncate:
+		c.proxy.ServeHTTP(&truncateWriter{ResponseWriter: w, remaining: f.Bytes}, req)
+	case FaultSlow:
+		rate := f.Rate
+		if rate <= 0 {
+			rate = 1024
+		}
+		c.proxy.ServeHTTP(&slowWriter{ResponseWriter: w, req: req, rate: rate}, req)
+	}
+}
+
+// reset closes the client's connection with a TCP reset rather than a clean
+// close, as a middlebox dropping the connection would
+func reset(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 connections can't be hijacked; aborting the stream is the
+		// closest there is
+		panic(http.ErrAbortHandler)
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// errTruncated stops the proxy copying a response FaultTruncate cut short.
+// The proxy then aborts the connection, so the client sees the response end
+// early.
+var errTruncated = errors.New("response truncated")
+
+type truncateWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+func (w *truncateWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.remaining {
+		w.remaining -= int64(len(p))
+		return w.ResponseWriter.Write(p)
+	}
+	n, _ := w.ResponseWriter.Write(p[:w.remaining])
+	w.remaining = 0
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+	return n, errTruncated
+}
+
+func (w *truncateWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// slowWriter sends what is written to it a tenth of its rate at a time,
+// ten times a second
+type slowWriter struct {
+	http.ResponseWriter
+	req  *http.Request
+	rate int64
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	chunk := int(max(w.rate/10, 1))
+	written := 0
+	for written < len(p) {
+		n := min(chunk, len(p)-written)
+		m, err := w.ResponseWriter.Write(p[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		_ = http.NewResponseController(w.ResponseWriter).Flush()
+		select {
+		case <-w.req.Context().Done():
+			return written, w.req.Context().Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return written, nil
+}
+
+func (w *slowWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,211 @@
+package ocitest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+// chaosServer starts a seeded registry behind a chaos proxy injecting the
+// faults, and returns the proxy and a client for it
+func chaosServer(t *testing.T, tls bool, faults ...string) (*ocitest.Chaos, *httptest.Server) {
+	t.Helper()
+	srv := ocitest.NewServer(t)
+	srv.Seed()
+	upstream, _ := url.Parse(srv.URL)
+	var parsed []ocitest.Fault
+	for _, s := range faults {
+		f, err := ocitest.ParseFault(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, f)
+	}
+	chaos, err := ocitest.NewChaos(upstream, 1, parsed...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := httptest.NewServer
+	if tls {
+		start = httptest.NewTLSServer
+	}
+	proxy := start(chaos)
+	t.Cleanup(proxy.Close)
+	return chaos, proxy
+}
+
+func proxyRef(t *testing.T, proxy *httptest.Server, name string) oci.Reference {
+	u, _ := url.Parse(proxy.URL)
+	return parse(t, u.Host+"/"+name)
+}
+
+func TestChaosStatus(t *testing.T) {
+	chaos, proxy := chaosServer(t, false, "429:path=/manifests/,times=2,retry-after=2s")
+	ref := proxyRef(t, proxy, ocitest.HelloRef)
+	client := oci.NewClient(oci.WithPlainHTTP(ref.Registry))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.Pull(context.Background(), ref)
+		var rerr *oci.ResponseError
+		if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("pull %d: expected a 429, got %v", i, err)
+		}
+		if len(rerr.Errors) != 1 || rerr.Errors[0].Code != "TOOMANYREQUESTS" {
+			t.Errorf("unexpected registry errors %v", rerr.Errors)
+		}
+	}
+	if _, err := client.Pull(context.Background(), ref); err != nil {
+		t.Fatalf("expected the pull to succeed once the faults ran out: %v", err)
+	}
+	if n := chaos.Injected()[ocitest.FaultStatus]; n != 2 {
+		t.Errorf("injected %d faults, want 2", n)
+	}
+}
+
+func TestChaosTruncateAndResume(t *testing.T) {
+	_, proxy := chaosServer(t, false, "truncate:path=/blobs/,bytes=5,times=1")
+	blob := proxy.URL + "/v2/wasm/hello/blobs/" + ocitest.Digest(ocitest.HelloWasm())
+
+	resp, err := http.Get(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, io.ErrUnexpectedEOF) || len(head) != 5 {
+		t.Fatalf("expected the body to end after 5 bytes, got %d bytes and %v", len(head), err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, blob, nil)
+	req.Header.Set("Range", "bytes=5-")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	tail, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("expected the rest of the blob, got status %d", resp.StatusCode)
+	}
+	if !bytes.Equal(append(head, tail...), ocitest.HelloWasm()) {
+		t.Error("expected the resumed download to complete the blob")
+	}
+}
+
+func TestChaosTruncatedPull(t *testing.T) {
+	_, proxy := chaosServer(t, false, "truncate:path=/blobs/,bytes=5")
+	ref := proxyRef(t, proxy, ocitest.HelloRef)
+	_, err := oci.NewClient(oci.WithPlainHTTP(ref.Registry)).Pull(context.Background(), ref)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an unexpected EOF, got %v", err)
+	}
+}
+
+func TestChaosSlow(t *testing.T) {
+	_, proxy := chaosServer(t, false, "slow:path=/manifests/,rate=2000")
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/v2/wasm/hello/manifests/v1", nil)
+	req.Header.Set("Accept", oci.ManifestMediaType)
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 200 bytes every 100ms
+	if want := time.Duration(len(body)/200) * 100 * time.Millisecond; time.Since(start) < want {
+		t.Errorf("got %d bytes in %s, expected it to take at least %s", len(body), time.Since(start), want)
+	}
+}
+
+func TestChaosReset(t *testing.T) {
+	for _, tls := range []bool{false, true} {
+		_, proxy := chaosServer(t, tls, "reset:method=GET")
+		_, err := proxy.Client().Get(proxy.URL + "/v2/")
+		if !errors.Is(err, syscall.ECONNRESET) {
+			t.Errorf("tls %v: expected the connection to be reset, got %v", tls, err)
+		}
+	}
+}
+
+func TestChaosTimeout(t *testing.T) {
+	_, proxy := chaosServer(t, false, "timeout")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, proxy.URL+"/v2/", nil)
+	_, err := http.DefaultClient.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the request to time out, got %v", err)
+	}
+}
+
+func TestChaosProbability(t *testing.T) {
+	chaos, proxy := chaosServer(t, false, "503:probability=0.5,after=10")
+	failed := 0
+	for i := 0; i < 110; i++ {
+		resp, err := http.Get(proxy.URL + "/v2/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			if i < 10 {
+				t.Fatalf("request %d failed before the fault started", i)
+			}
+			failed++
+		}
+	}
+	if failed < 25 || failed > 75 || chaos.Injected()[ocitest.FaultStatus] != failed {
+		t.Errorf("expected about half of 100 requests to fail, got %d", failed)
+	}
+}
+
+func TestChaosPush(t *testing.T) {
+	// Upload locations point at the upstream and must be rewritten to come
+	// back through the proxy
+	_, proxy := chaosServer(t, false)
+	ref := proxyRef(t, proxy, "demos/pushed:v1")
+	client := oci.NewClient(oci.WithPlainHTTP(ref.Registry))
+	if _, err := client.Push(context.Background(), ref, ocitest.HelloWasm(), oci.PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Pull(context.Background(), ref); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseFault(t *testing.T) {
+	f, err := ocitest.ParseFault("truncate:path=/blobs/,method=get,bytes=100,probability=0.25,after=1,times=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ocitest.Fault{Kind: ocitest.FaultTruncate, Path: "/blobs/", Method: "GET", Bytes: 100, Probability: 0.25, After: 1, Times: 3}
+	if f != want {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+	if f, err := ocitest.ParseFault("503"); err != nil || f.Kind != ocitest.FaultStatus || f.Status != 503 {
+		t.Errorf("got %+v, %v", f, err)
+	}
+	for _, s := range []string{"explode", "slow:rate", "slow:speed=1", "timeout:delay=soon", "status:status=42", "reset:probability=2", "reset:path=("} {
+		if _, err := ocitest.ParseFault(s); err == nil || !strings.Contains(err.Error(), s) {
+			t.Errorf("%s: expected an error naming the fault, got %v", s, err)
+		}
+	}
+}
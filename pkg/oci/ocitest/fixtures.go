@@ -0,0 +1,268 @@
+package ocitest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+)
+
+// The fixtures Seed adds, as repository:tag
+const (
+	// HelloRef is a wasm module that prints "Hello, world!" and exits
+	HelloRef = "wasm/hello:v1"
+	// MultiArchRef is an index with entries for the hello module and a
+	// linux/amd64 container image
+	MultiArchRef = "wasm/hello-multiarch:v1"
+	// ContainerRef is a Docker container image, which krustlet can't run
+	ContainerRef = "containers/nginx:latest"
+	// UnsignedRef is the hello module with no signature
+	UnsignedRef = "wasm/unsigned:v1"
+	// SignedRef is the hello module with a signature from the key returned by
+	// PublicKeyPEM
+	SignedRef = "wasm/signed:v1"
+)
+
+// Signatures are stored the way cosign stores them
+const (
+	// SimpleSigningMediaType is the media type of a signature payload
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// SignatureAnnotation holds the base64 encoded signature of the payload
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+const (
+	wasmLayerMediaType      = "application/vnd.wasm.content.layer.v1+wasm"
+	wasmConfigMediaType     = "application/vnd.wasm.config.v1+json"
+	manifestMediaType       = "application/vnd.oci.image.manifest.v1+json"
+	indexMediaType          = "application/vnd.oci.image.index.v1+json"
+	imageConfigMediaType    = "application/vnd.oci.image.config.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerConfigMediaType   = "application/vnd.docker.container.image.v1+json"
+	dockerLayerMediaType    = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	annotationTitle   = "org.opencontainers.image.title"
+	annotationCreated = "org.opencontainers.image.created"
+	// fixtureCreated is fixed so the fixtures have the same digests on every
+	// run
+	fixtureCreated = "2021-10-01T00:00:00Z"
+)
+
+// helloWasm is a minimal WASI command module equivalent to
+//
+//	(module
+//	  (import "wasi_snapshot_preview1" "fd_write"
+//	    (func $fd_write (param i32 i32 i32 i32) (result i32)))
+//	  (memory (export "memory") 1)
+//	  (data (i32.const 0) "\08\00\00\00\0e\00\00\00") ;; iovec for the string
+//	  (data (i32.const 8) "Hello, world!\n")
+//	  (func (export "_start")
+//	    (drop (call $fd_write (i32.const 1) (i32.const 0) (i32.const 1) (i32.const 24)))))
+var helloWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// type section: (i32 i32 i32 i32) -> i32, () -> ()
+	0x01, 0x0c, 0x02, 0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x00, 0x00,
+	// import section: wasi_snapshot_preview1.fd_write
+	0x02, 0x23, 0x01, 0x16,
+	'w', 'a', 's', 'i', '_', 's', 'n', 'a', 'p', 's', 'h', 'o', 't', '_', 'p', 'r', 'e', 'v', 'i', 'e', 'w', '1',
+	0x08, 'f', 'd', '_', 'w', 'r', 'i', 't', 'e', 0x00, 0x00,
+	// function and memory sections
+	0x03, 0x02, 0x01, 0x01,
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	// export section: memory, _start
+	0x07, 0x13, 0x02,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x06, '_', 's', 't', 'a', 'r', 't', 0x00, 0x01,
+	// code section
+	0x0a, 0x0f, 0x01, 0x0d, 0x00,
+	0x41, 0x01, 0x41, 0x00, 0x41, 0x01, 0x41, 0x18, 0x10, 0x00, 0x1a, 0x0b,
+	// data section
+	0x0b, 0x21, 0x02,
+	0x00, 0x41, 0x00, 0x0b, 0x08, 0x08, 0x00, 0x00, 0x00, 0x0e, 0x00, 0x00, 0x00,
+	0x00, 0x41, 0x08, 0x0b, 0x0e,
+	'H', 'e', 'l', 'l', 'o', ',', ' ', 'w', 'o', 'r', 'l', 'd', '!', '\n',
+}
+
+// HelloWasm returns the module the wasm fixtures hold
+func HelloWasm() []byte {
+	return append([]byte(nil), helloWasm...)
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int               `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+	// ArtifactType is set on referrers API entries
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type imageManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type imageIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Manifests     []descriptor `json:"manifests"`
+}
+
+type signer struct {
+	key *ecdsa.PrivateKey
+}
+
+// Seed adds the fixtures to the registry. It can be called more than once;
+// the signing key is only generated the first time.
+func (r *Registry) Seed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signer == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			panic("ocitest: generating signing key: " + err.Error())
+		}
+		r.signer = &signer{key: key}
+	}
+
+	hello := r.pushModule(HelloRef, "hello.wasm")
+	r.pushModule(UnsignedRef, "unsigned.wasm")
+	signed := r.pushModule(SignedRef, "signed.wasm")
+	r.sign(SignedRef, signed)
+	container := r.pushContainer(ContainerRef)
+
+	hello.Platform = &platform{Architecture: "wasm", OS: "wasi"}
+	hello.Annotations = nil
+	container.Platform = &platform{Architecture: "amd64", OS: "linux"}
+	repo, tag := splitRef(MultiArchRef)
+	r.putManifest(repo, tag, indexMediaType, mustJSON(imageIndex{
+		SchemaVersion: 2,
+		MediaType:     indexMediaType,
+		Manifests:     []descriptor{container, hello},
+	}))
+	// Index entries are fetched from the index's own repository
+	r.copyManifest(HelloRef, repo, hello.Digest)
+	r.copyManifest(ContainerRef, repo, container.Digest)
+}
+
+// PublicKeyPEM returns the PEM encoded public key SignedRef is signed with.
+// It is nil until Seed has been called.
+func (r *Registry) PublicKeyPEM() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.signer == nil {
+		return nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(&r.signer.key.PublicKey)
+	if err != nil {
+		panic("ocitest: encoding public key: " + err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func (r *Registry) blob(mediaType string, data []byte) descriptor {
+	digest := Digest(data)
+	r.blobs[digest] = data
+	return descriptor{MediaType: mediaType, Digest: digest, Size: len(data)}
+}
+
+func (r *Registry) pushModule(ref, title string) descriptor {
+	layer := r.blob(wasmLayerMediaType, helloWasm)
+	layer.Annotations = map[string]string{annotationTitle: title}
+	data := mustJSON(imageManifest{
+		SchemaVersion: 2,
+		MediaType:     manifestMediaType,
+		Config:        r.blob(wasmConfigMediaType, []byte("{}")),
+		Layers:        []descriptor{layer},
+		Annotations:   map[string]string{annotationCreated: fixtureCreated},
+	})
+	repo, tag := splitRef(ref)
+	return descriptor{MediaType: manifestMediaType, Digest: r.putManifest(repo, tag, manifestMediaType, data), Size: len(data)}
+}
+
+func (r *Registry) pushContainer(ref string) descriptor {
+	config := mustJSON(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"created":      fixtureCreated,
+		"config":       map[string]interface{}{"Entrypoint": []string{"/docker-entrypoint.sh"}},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []string{Digest([]byte("layer"))}},
+	})
+	data := mustJSON(imageManifest{
+		SchemaVersion: 2,
+		MediaType:     dockerManifestMediaType,
+		Config:        r.blob(dockerConfigMediaType, config),
+		Layers:        []descriptor{r.blob(dockerLayerMediaType, []byte("layer"))},
+	})
+	repo, tag := splitRef(ref)
+	return descriptor{MediaType: dockerManifestMediaType, Digest: r.putManifest(repo, tag, dockerManifestMediaType, data), Size: len(data)}
+}
+
+// sign attaches a signature to the manifest the way cosign does: a simple
+// signing payload naming the manifest digest, stored as the layer of a
+// manifest tagged sha256-<hex>.sig, with the ECDSA signature of the payload
+// in the layer's annotations
+func (r *Registry) sign(ref string, target descriptor) {
+	repo, _ := splitRef(ref)
+	payload := mustJSON(map[string]interface{}{
+		"critical": map[string]interface{}{
+			"identity": map[string]string{"docker-reference": repo},
+			"image":    map[string]string{"docker-manifest-digest": target.Digest},
+			"type":     "cosign container image signature",
+		},
+		"optional": nil,
+	})
+	sum := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, r.signer.key, sum[:])
+	if err != nil {
+		panic("ocitest: signing: " + err.Error())
+	}
+
+	layer := r.blob(SimpleSigningMediaType, payload)
+	layer.Annotations = map[string]string{SignatureAnnotation: base64.StdEncoding.EncodeToString(sig)}
+	r.putManifest(repo, SignatureTag(target.Digest), manifestMediaType, mustJSON(imageManifest{
+		SchemaVersion: 2,
+		MediaType:     manifestMediaType,
+		Config:        r.blob(imageConfigMediaType, []byte("{}")),
+		Layers:        []descriptor{layer},
+	}))
+}
+
+func (r *Registry) copyManifest(from, toRepo, digest string) {
+	repo, _ := splitRef(from)
+	m := r.manifests[repo][digest]
+	r.putManifest(toRepo, "", m.mediaType, m.data)
+}
+
+// SignatureTag returns the tag a signature for the manifest digest is stored
+// under, sha256-<hex>.sig
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+func splitRef(ref string) (repo, tag string) {
+	i := strings.LastIndex(ref, ":")
+	return ref[:i], ref[i+1:]
+}
+
+func mustJSON(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic("ocitest: " + err.Error())
+	}
+	return data
+}
@@ -0,0 +1,132 @@
+package ocitest_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+func seededServer(t *testing.T) (*ocitest.Server, *oci.Client) {
+	srv := ocitest.NewServer(t)
+	srv.Seed()
+	return srv, oci.NewClient(oci.WithPlainHTTP(srv.Host()))
+}
+
+func parse(t *testing.T, s string) oci.Reference {
+	t.Helper()
+	ref, err := oci.ParseReference(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestModuleFixtures(t *testing.T) {
+	srv, client := seededServer(t)
+	for _, name := range []string{ocitest.HelloRef, ocitest.MultiArchRef, ocitest.UnsignedRef, ocitest.SignedRef} {
+		module, err := client.Pull(context.Background(), parse(t, srv.Ref(name)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(module.Data, ocitest.HelloWasm()) {
+			t.Errorf("%s: unexpected module", name)
+		}
+	}
+}
+
+func TestContainerFixture(t *testing.T) {
+	srv, client := seededServer(t)
+	_, err := client.Pull(context.Background(), parse(t, srv.Ref(ocitest.ContainerRef)))
+	if !errors.Is(err, oci.ErrNotModule) {
+		t.Errorf("expected the container image to be rejected, got %v", err)
+	}
+}
+
+func TestSignedFixture(t *testing.T) {
+	srv, client := seededServer(t)
+	ctx := context.Background()
+
+	desc, err := client.Resolve(ctx, parse(t, srv.Ref(ocitest.SignedRef)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigRef := parse(t, srv.Ref("wasm/signed:"+ocitest.SignatureTag(desc.Digest)))
+	data, _, err := client.FetchManifest(ctx, sigRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m oci.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	layer := m.Layers[0]
+	if layer.MediaType != ocitest.SimpleSigningMediaType {
+		t.Fatalf("unexpected signature layer %+v", layer)
+	}
+	payload, err := client.FetchBlob(ctx, sigRef, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var simple struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &simple); err != nil {
+		t.Fatal(err)
+	}
+	if simple.Critical.Image.Digest != desc.Digest {
+		t.Errorf("signature is for %s, want %s", simple.Critical.Image.Digest, desc.Digest)
+	}
+
+	block, _ := pem.Decode(srv.PublicKeyPEM())
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[ocitest.SignatureAnnotation])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(payload)
+	if !ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), sum[:], sig) {
+		t.Error("signature does not verify with the public key")
+	}
+
+	// The unsigned fixture has nothing at its signature tag
+	unsigned, err := client.Resolve(ctx, parse(t, srv.Ref(ocitest.UnsignedRef)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.FetchManifest(ctx, parse(t, srv.Ref("wasm/unsigned:"+ocitest.SignatureTag(unsigned.Digest))))
+	if !errors.Is(err, oci.ErrNotFound) {
+		t.Errorf("expected no signature for the unsigned fixture, got %v", err)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv, _ := seededServer(t)
+	srv.Username, srv.Password = "user", "pass"
+	ref := parse(t, srv.Ref(ocitest.HelloRef))
+
+	if _, err := oci.NewClient(oci.WithPlainHTTP(srv.Host())).Pull(context.Background(), ref); err == nil {
+		t.Error("expected an anonymous pull to fail")
+	}
+	client := oci.NewClient(oci.WithPlainHTTP(srv.Host()), oci.WithCredentials(oci.StaticCredential(oci.Credential{Username: "user", Password: "pass"})))
+	if _, err := client.Pull(context.Background(), ref); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,363 @@
+// Package ocitest provides an in-process OCI registry for tests and local
+// demos, so they don't depend on a real registry being reachable.
+//
+// The registry implements the parts of the distribution API that krustlet
+// and the oci package use: pulling, pushing and deleting manifests and blobs,
+// chunked uploads, ranged blob downloads, listing tags and referrers, and
+// basic or bearer auth. Seed fills it with wasm fixtures. Chaos fronts it, or
+// any other registry, to inject network faults.
+//
+// The package only uses the standard library, so the oci package's own tests
+// can use it.
+package ocitest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Registry is an in-memory registry. The zero value is not usable; create
+// one with New.
+type Registry struct {
+	// Username and Password, when set, are required as basic auth. If Token
+	// is also set, the registry requires bearer auth instead and hands out
+	// Token to anyone presenting Username and Password at /token
+	Username, Password, Token string
+	// TagPageSize is how many tags are returned at once when the client
+	// doesn't ask for a page size. 0 returns every tag.
+	TagPageSize int
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string]map[string]manifest // repo -> tag or digest -> manifest
+	uploads   int
+	// chunks are the data of chunked uploads in progress, by upload ID
+	chunks map[string][]byte
+	signer *signer
+}
+
+type manifest struct {
+	mediaType string
+	data      []byte
+}
+
+// New returns an empty registry
+func New() *Registry {
+	return &Registry{
+		blobs:     map[string][]byte{},
+		manifests: map[string]map[string]manifest{},
+		chunks:    map[string][]byte{},
+	}
+}
+
+// Uploads returns how many blob uploads have been started, which lets tests
+// check that existing blobs are reused
+func (r *Registry) Uploads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.uploads
+}
+
+// PutBlob stores a blob and returns its digest
+func (r *Registry) PutBlob(data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest := Digest(data)
+	r.blobs[digest] = data
+	return digest
+}
+
+// PutManifest stores a manifest under the tag, which may be empty to store it
+// by digest only, and returns its digest
+func (r *Registry) PutManifest(repo, tag, mediaType string, data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.putManifest(repo, tag, mediaType, data)
+}
+
+func (r *Registry) putManifest(repo, tag, mediaType string, data []byte) string {
+	m := manifest{mediaType: mediaType, data: data}
+	if r.manifests[repo] == nil {
+		r.manifests[repo] = map[string]manifest{}
+	}
+	digest := Digest(data)
+	r.manifests[repo][digest] = m
+	if tag != "" {
+		r.manifests[repo][tag] = m
+	}
+	return digest
+}
+
+// ServeHTTP implements http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.authorized(w, req) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case req.URL.Path == "/v2/" || req.URL.Path == "/v2":
+		w.WriteHeader(http.StatusOK)
+	case strings.HasSuffix(p, "/tags/list"):
+		r.serveTags(w, req, strings.TrimSuffix(p, "/tags/list"))
+	case strings.HasSuffix(p, "/blobs/uploads/") && req.Method == http.MethodPost:
+		r.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/upload/%d?state=x", r.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(req.URL.Path, "/upload/") && req.Method == http.MethodPatch:
+		r.serveChunk(w, req)
+	case strings.HasPrefix(req.URL.Path, "/upload/") && req.Method == http.MethodPut:
+		id := strings.TrimPrefix(req.URL.Path, "/upload/")
+		body, _ := io.ReadAll(req.Body)
+		data := append(r.chunks[id], body...)
+		delete(r.chunks, id)
+		digest := req.URL.Query().Get("digest")
+		if Digest(data) != digest || req.URL.Query().Get("state") != "x" {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+			return
+		}
+		r.blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/referrers/") && req.Method == http.MethodGet:
+		i := strings.Index(p, "/referrers/")
+		r.serveReferrers(w, p[:i], p[i+len("/referrers/"):])
+	case strings.Contains(p, "/blobs/"):
+		data, ok := r.blobs[p[strings.LastIndex(p, "/")+1:]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+			return
+		}
+		// ServeContent answers Range requests, so clients can resume
+		// interrupted downloads as they can from real registries
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(data))
+	case strings.Contains(p, "/manifests/"):
+		i := strings.Index(p, "/manifests/")
+		r.serveManifest(w, req, p[:i], p[i+len("/manifests/"):])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// authorized checks the request's credentials, challenging the client if
+// they are missing
+func (r *Registry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	if r.Username == "" && r.Token == "" {
+		return true
+	}
+	if r.Token == "" {
+		if user, pass, ok := req.BasicAuth(); ok && user == r.Username && pass == r.Password {
+			return true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="ocitest"`)
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+		return false
+	}
+
+	if req.URL.Path == "/token" {
+		user, pass, _ := req.BasicAuth()
+		if user != r.Username || pass != r.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		fmt.Fprintf(w, `{"token":%q}`, r.Token)
+		return false
+	}
+	if req.Header.Get("Authorization") == "Bearer "+r.Token {
+		return true
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s://%s/token",service="ocitest"`, scheme, req.Host))
+	writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	return false
+}
+
+// serveChunk appends a chunk to an upload, which must start where the
+// previous chunk ended
+func (r *Registry) serveChunk(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/upload/")
+	data, _ := io.ReadAll(req.Body)
+	if rng := req.Header.Get("Content-Range"); rng != "" {
+		start, _, _ := strings.Cut(rng, "-")
+		if start != strconv.Itoa(len(r.chunks[id])) {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID", "chunk out of order")
+			return
+		}
+	}
+	r.chunks[id] = append(r.chunks[id], data...)
+	w.Header().Set("Location", fmt.Sprintf("/upload/%s?state=x", id))
+	w.Header().Set("Range", fmt.Sprintf("0-%d", len(r.chunks[id])-1))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveReferrers lists the manifests in the repository whose subject is the
+// digest
+func (r *Registry) serveReferrers(w http.ResponseWriter, repo, digest string) {
+	idx := imageIndex{SchemaVersion: 2, MediaType: indexMediaType, Manifests: []descriptor{}}
+	for key, m := range r.manifests[repo] {
+		var probe struct {
+			ArtifactType string            `json:"artifactType"`
+			Config       descriptor        `json:"config"`
+			Subject      *descriptor       `json:"subject"`
+			Annotations  map[string]string `json:"annotations"`
+		}
+		if !strings.HasPrefix(key, "sha256:") || json.Unmarshal(m.data, &probe) != nil || probe.Subject == nil || probe.Subject.Digest != digest {
+			continue
+		}
+		artifactType := probe.ArtifactType
+		if artifactType == "" {
+			artifactType = probe.Config.MediaType
+		}
+		idx.Manifests = append(idx.Manifests, descriptor{
+			MediaType:    m.mediaType,
+			Digest:       key,
+			Size:         len(m.data),
+			Annotations:  probe.Annotations,
+			ArtifactType: artifactType,
+		})
+	}
+	sort.Slice(idx.Manifests, func(i, j int) bool { return idx.Manifests[i].Digest < idx.Manifests[j].Digest })
+	w.Header().Set("Content-Type", indexMediaType)
+	_ = json.NewEncoder(w).Encode(idx)
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repo, target string) {
+	if req.Method == http.MethodDelete {
+		m, ok := r.manifests[repo][target]
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+			return
+		}
+		digest := Digest(m.data)
+		for key, other := range r.manifests[repo] {
+			if key == target || (target == digest && Digest(other.data) == digest) {
+				delete(r.manifests[repo], key)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if req.Method == http.MethodPut {
+		data, _ := io.ReadAll(req.Body)
+		tag := target
+		if strings.HasPrefix(target, "sha256:") {
+			if Digest(data) != target {
+				writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+				return
+			}
+			tag = ""
+		}
+		digest := r.putManifest(repo, tag, req.Header.Get("Content-Type"), data)
+		var probe struct {
+			Subject *descriptor `json:"subject"`
+		}
+		if json.Unmarshal(data, &probe) == nil && probe.Subject != nil {
+			w.Header().Set("OCI-Subject", probe.Subject.Digest)
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	m, ok := r.manifests[repo][target]
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", Digest(m.data))
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.data)))
+	if req.Method == http.MethodGet {
+		_, _ = w.Write(m.data)
+	}
+}
+
+// serveTags lists tags in order, a page at a time when the client passes n or
+// TagPageSize is set
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, repo string) {
+	if r.manifests[repo] == nil {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	tags := []string{}
+	for k := range r.manifests[repo] {
+		if !strings.HasPrefix(k, "sha256:") {
+			tags = append(tags, k)
+		}
+	}
+	sort.Strings(tags)
+
+	query := req.URL.Query()
+	last := query.Get("last")
+	start := sort.SearchStrings(tags, last)
+	if last != "" && start < len(tags) && tags[start] == last {
+		start++
+	}
+	size := r.TagPageSize
+	if n, err := strconv.Atoi(query.Get("n")); err == nil && n > 0 {
+		size = n
+	}
+	end := len(tags)
+	if size > 0 && start+size < len(tags) {
+		end = start + size
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?n=%d&last=%s>; rel="next"`, repo, size, url.QueryEscape(tags[end-1])))
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": repo, "tags": tags[start:end]})
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+// Digest returns the sha256 digest of data in descriptor form
+func Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Server is a Registry served over plain HTTP on a local port
+type Server struct {
+	*Registry
+	*httptest.Server
+}
+
+// NewServer starts an empty registry that is shut down when the test ends
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	reg := New()
+	s := &Server{Registry: reg, Server: httptest.NewServer(reg)}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Host returns the registry host, for use in references
+func (s *Server) Host() string {
+	u, _ := url.Parse(s.URL)
+	return u.Host
+}
+
+// Ref returns a reference to name, such as "wasm/hello:v1", on the registry
+func (s *Server) Ref(name string) string {
+	return s.Host() + "/" + name
+}
@@ -0,0 +1,164 @@
+package oci
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultRegistry is the registry used for references that don't name one
+	DefaultRegistry = "docker.io"
+	// DefaultTag is the tag used for references that have neither a tag nor a
+	// digest
+	DefaultTag = "latest"
+
+	dockerHubHost = "registry-1.docker.io"
+)
+
+var (
+	repositoryPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern        = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestPattern     = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+)
+
+// Reference identifies a module in a registry, for example
+// webassembly.azurecr.io/hello-wasm:v1 or localhost:5000/app@sha256:...
+type Reference struct {
+	// Registry is the registry host, including a port if one was given
+	Registry string
+	// Repository is the repository path within the registry
+	Repository string
+	// Tag is the tag of the module. It is empty when only a digest was given
+	Tag string
+	// Digest is the content digest of the manifest, if one was given
+	Digest string
+}
+
+// ParseReference parses a reference in the same format as container image
+// references. A missing registry defaults to Docker Hub and a reference with
+// neither a tag nor a digest gets the "latest" tag.
+func ParseReference(s string) (Reference, error) {
+	ref, err := parseReference(s)
+	if err != nil {
+		return Reference{}, err
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// ParseRepository parses a reference that names a repository only, such as
+// the argument to a tag listing. Any tag or digest is rejected.
+func ParseRepository(s string) (Reference, error) {
+	ref, err := parseReference(s)
+	if err != nil {
+		return Reference{}, err
+	}
+	if ref.Tag != "" || ref.Digest != "" {
+		return Reference{}, fmt.Errorf("invalid repository %q: must not include a tag or digest", s)
+	}
+	return ref, nil
+}
+
+// ValidRepository reports whether s is a repository path within a registry,
+// such as library/hello, in the form references must use
+func ValidRepository(s string) bool {
+	return repositoryPattern.MatchString(s)
+}
+
+func parseReference(s string) (Reference, error) {
+	var ref Reference
+	rest := s
+	if i := strings.Index(rest, "@"); i >= 0 {
+		ref.Digest = rest[i+1:]
+		rest = rest[:i]
+		if !digestPattern.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("invalid reference %q: invalid digest %q", s, ref.Digest)
+		}
+	}
+	// A tag is separated by the last colon, as long as it comes after the
+	// last slash. Anything earlier is a registry port
+	if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		ref.Tag = rest[i+1:]
+		rest = rest[:i]
+		if !tagPattern.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid reference %q: invalid tag %q", s, ref.Tag)
+		}
+	}
+
+	ref.Registry, ref.Repository = splitRegistry(rest)
+	if ref.Repository == "" {
+		return Reference{}, fmt.Errorf("invalid reference %q: missing repository", s)
+	}
+	if !repositoryPattern.MatchString(ref.Repository) {
+		return Reference{}, fmt.Errorf("invalid reference %q: invalid repository %q", s, ref.Repository)
+	}
+	return ref, nil
+}
+
+// splitRegistry splits the registry host off a name. The first component is
+// only treated as a host if it looks like one, the same way docker does.
+func splitRegistry(name string) (string, string) {
+	i := strings.Index(name, "/")
+	if i < 0 {
+		return DefaultRegistry, "library/" + name
+	}
+	host := name[:i]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return DefaultRegistry, name
+	}
+	if host == "index.docker.io" {
+		host = DefaultRegistry
+	}
+	repo := name[i+1:]
+	if host == DefaultRegistry && !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return host, repo
+}
+
+// String returns the fully qualified form of the reference
+func (r Reference) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// Name returns the registry and repository without a tag or digest
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// WithDigest returns a copy of the reference pinned to the given digest
+func (r Reference) WithDigest(digest string) Reference {
+	r.Digest = digest
+	return r
+}
+
+// manifestReference is the tag or digest used in manifest URLs. The digest
+// wins when both are set since it is the more specific of the two.
+func (r Reference) manifestReference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	if r.Tag != "" {
+		return r.Tag
+	}
+	return DefaultTag
+}
+
+// apiHost returns the host that serves the registry API. Docker Hub is known
+// by a different name than the one its API lives on.
+func (r Reference) apiHost() string {
+	if r.Registry == DefaultRegistry {
+		return dockerHubHost
+	}
+	return r.Registry
+}
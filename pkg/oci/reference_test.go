@@ -0,0 +1,73 @@
+package oci
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Reference
+	}{
+		{"hello", Reference{Registry: "docker.io", Repository: "library/hello", Tag: "latest"}},
+		{"deislabs/hello:v1", Reference{Registry: "docker.io", Repository: "deislabs/hello", Tag: "v1"}},
+		{"index.docker.io/hello", Reference{Registry: "docker.io", Repository: "library/hello", Tag: "latest"}},
+		{"webassembly.azurecr.io/hello-wasm:v1", Reference{Registry: "webassembly.azurecr.io", Repository: "hello-wasm", Tag: "v1"}},
+		{"localhost:5000/a/b", Reference{Registry: "localhost:5000", Repository: "a/b", Tag: "latest"}},
+		{"localhost/app:dev", Reference{Registry: "localhost", Repository: "app", Tag: "dev"}},
+		{
+			"example.com:443/app@sha256:0123abcd",
+			Reference{Registry: "example.com:443", Repository: "app", Digest: "sha256:0123abcd"},
+		},
+		{
+			"example.com/app:v2@sha256:0123abcd",
+			Reference{Registry: "example.com", Repository: "app", Tag: "v2", Digest: "sha256:0123abcd"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.in)
+		if err != nil {
+			t.Errorf("ParseReference(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseReferenceInvalid(t *testing.T) {
+	for _, in := range []string{"", "Upper/case", "example.com/", "app@sha256", "app:-tag", "app:"} {
+		if ref, err := ParseReference(in); err == nil {
+			t.Errorf("ParseReference(%q) = %+v, expected an error", in, ref)
+		}
+	}
+}
+
+func TestParseRepository(t *testing.T) {
+	ref, err := ParseRepository("localhost:5000/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Tag != "" || ref.Repository != "app" {
+		t.Errorf("unexpected reference %+v", ref)
+	}
+	if _, err := ParseRepository("localhost:5000/app:v1"); err == nil {
+		t.Error("expected a repository with a tag to be rejected")
+	}
+}
+
+func TestReferenceString(t *testing.T) {
+	ref := Reference{Registry: "example.com", Repository: "app", Tag: "v1"}
+	if got := ref.String(); got != "example.com/app:v1" {
+		t.Errorf("got %q", got)
+	}
+	pinned := ref.WithDigest("sha256:abc")
+	if got := pinned.String(); got != "example.com/app:v1@sha256:abc" {
+		t.Errorf("got %q", got)
+	}
+	if pinned.manifestReference() != "sha256:abc" {
+		t.Errorf("expected the digest to be used for manifest requests, got %q", pinned.manifestReference())
+	}
+	if (Reference{Registry: "docker.io"}).apiHost() != "registry-1.docker.io" {
+		t.Error("expected Docker Hub to use its API host")
+	}
+}
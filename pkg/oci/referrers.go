@@ -0,0 +1,130 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ReferrersTag returns the tag registries without the referrers API keep the
+// index of a manifest's referrers under, sha256-<hex>, as the OCI
+// distribution spec describes
+func ReferrersTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// PushReferrer pushes an artifact manifest whose subject is another manifest
+// in the repository, such as a signature of a module, by digest. If the
+// registry doesn't index the subject itself, the artifact is added to the
+// subject's referrers tag, so Referrers finds it either way.
+func (c *Client) PushReferrer(ctx context.Context, repo Reference, m Manifest) (Descriptor, error) {
+	if m.Subject == nil {
+		return Descriptor{}, errors.New("referrer has no subject")
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	repo = Reference{Registry: repo.Registry, Repository: repo.Repository}
+	desc, header, err := c.pushManifest(ctx, repo, ManifestMediaType, data)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc.ArtifactType = m.ArtifactType
+	desc.Annotations = m.Annotations
+	if header.Get("OCI-Subject") == m.Subject.Digest {
+		return desc, nil
+	}
+
+	tag := repo
+	tag.Tag = ReferrersTag(m.Subject.Digest)
+	idx, err := c.referrersIndex(ctx, tag)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	for _, d := range idx.Manifests {
+		if d.Digest == desc.Digest {
+			return desc, nil
+		}
+	}
+	idx.Manifests = append(idx.Manifests, desc)
+	if data, err = json.Marshal(idx); err != nil {
+		return Descriptor{}, err
+	}
+	if _, err := c.PushManifest(ctx, tag, IndexMediaType, data); err != nil {
+		return Descriptor{}, fmt.Errorf("updating referrers tag: %w", err)
+	}
+	return desc, nil
+}
+
+// Referrers lists the manifests whose subject is the manifest the reference's
+// digest names, keeping only those of the artifact type if it isn't empty. It
+// uses the referrers API, falling back to the referrers tag for registries
+// that don't have it.
+func (c *Client) Referrers(ctx context.Context, subject Reference, artifactType string) ([]Descriptor, error) {
+	if subject.Digest == "" {
+		return nil, fmt.Errorf("%s: referrers can only be listed for a digest", subject)
+	}
+	u := c.url(subject, "/referrers/"+subject.Digest)
+	if artifactType != "" {
+		u += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+	req, err := newRequest(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", IndexMediaType)
+	resp, err := c.do(req, subject, repositoryScope(subject, "pull"))
+	if err != nil {
+		return nil, err
+	}
+
+	var idx *Index
+	if resp.StatusCode == http.StatusNotFound {
+		drain(resp)
+		tag := Reference{Registry: subject.Registry, Repository: subject.Repository, Tag: ReferrersTag(subject.Digest)}
+		if idx, err = c.referrersIndex(ctx, tag); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := checkResponse(resp, http.StatusOK); err != nil {
+			return nil, err
+		}
+		idx = &Index{}
+		err = json.NewDecoder(resp.Body).Decode(idx)
+		drain(resp)
+		if err != nil {
+			return nil, fmt.Errorf("decoding referrers: %w", err)
+		}
+	}
+
+	// Registries may ignore the filter, so it is applied here as well
+	var out []Descriptor
+	for _, d := range idx.Manifests {
+		if artifactType == "" || d.ArtifactType == artifactType {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+// referrersIndex fetches the index under a referrers tag, which is empty if
+// the tag doesn't exist yet
+func (c *Client) referrersIndex(ctx context.Context, tag Reference) (*Index, error) {
+	data, _, err := c.FetchManifest(ctx, tag)
+	if errors.Is(err, ErrNotFound) {
+		return &Index{SchemaVersion: 2, MediaType: IndexMediaType, Manifests: []Descriptor{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("decoding referrers tag %s: %w", tag.Tag, err)
+	}
+	return &idx, nil
+}
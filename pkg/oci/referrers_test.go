@@ -0,0 +1,109 @@
+package oci
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+// noReferrersAPI serves a registry the way registries from before OCI 1.1
+// do: no referrers API and no OCI-Subject header
+type noReferrersAPI struct {
+	http.Handler
+}
+
+func (h noReferrersAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.Contains(req.URL.Path, "/referrers/") {
+		http.NotFound(w, req)
+		return
+	}
+	h.Handler.ServeHTTP(&dropSubject{ResponseWriter: w}, req)
+}
+
+type dropSubject struct {
+	http.ResponseWriter
+}
+
+func (w *dropSubject) WriteHeader(code int) {
+	w.Header().Del("OCI-Subject")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func pushTestReferrer(t *testing.T, client *Client, ref Reference, subject Descriptor, artifactType string) Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	empty, err := client.PushBlob(ctx, ref, EmptyMediaType, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject.Annotations = nil
+	desc, err := client.PushReferrer(ctx, ref, Manifest{
+		SchemaVersion: 2,
+		MediaType:     ManifestMediaType,
+		ArtifactType:  artifactType,
+		Config:        empty,
+		Layers:        []Descriptor{empty},
+		Subject:       &subject,
+		Annotations:   map[string]string{"a": artifactType},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestReferrers(t *testing.T) {
+	for name, handler := range map[string]func(http.Handler) http.Handler{
+		"referrers API": func(h http.Handler) http.Handler { return h },
+		"referrers tag": func(h http.Handler) http.Handler { return noReferrersAPI{h} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(handler(ocitest.New()))
+			defer srv.Close()
+			u, _ := url.Parse(srv.URL)
+			client := NewClient(WithPlainHTTP(u.Host))
+			ctx := context.Background()
+			ref, err := ParseReference(u.Host + "/demos/hello:v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			subject, err := client.Push(ctx, ref, testModule, PushOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sig := pushTestReferrer(t, client, ref, subject, "application/vnd.example.signature")
+			pushTestReferrer(t, client, ref, subject, "application/vnd.example.sbom")
+			// Pushing the same artifact again doesn't list it twice
+			pushTestReferrer(t, client, ref, subject, "application/vnd.example.signature")
+
+			all, err := client.Referrers(ctx, ref.WithDigest(subject.Digest), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(all) != 2 {
+				t.Fatalf("expected 2 referrers, got %+v", all)
+			}
+			sigs, err := client.Referrers(ctx, ref.WithDigest(subject.Digest), "application/vnd.example.signature")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(sigs) != 1 || sigs[0].Digest != sig.Digest || sigs[0].Annotations["a"] != "application/vnd.example.signature" {
+				t.Errorf("unexpected referrers %+v", sigs)
+			}
+
+			none, err := client.Referrers(ctx, ref.WithDigest(ocitest.Digest([]byte("other"))), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(none) != 0 {
+				t.Errorf("expected no referrers, got %+v", none)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxManifestSize caps how much of a manifest response is read. Manifests
+// are small; anything larger is almost certainly not a manifest.
+const maxManifestSize = 4 * 1024 * 1024
+
+// Resolve returns the descriptor of the manifest the reference points at
+// without downloading it
+func (c *Client) Resolve(ctx context.Context, ref Reference) (Descriptor, error) {
+	req, err := newRequest(ctx, http.MethodHead, c.url(ref, "/manifests/"+ref.manifestReference()), nil)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	req.Header.Set("Accept", manifestAcceptTypes)
+	resp, err := c.do(req, ref, repositoryScope(ref, "pull"))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return Descriptor{}, err
+	}
+	drain(resp)
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		// Not every registry returns the digest on HEAD, so fall back to
+		// fetching the manifest and hashing it
+		_, desc, err := c.FetchManifest(ctx, ref)
+		return desc, err
+	}
+	return Descriptor{
+		MediaType: mediaTypeOf(nil, resp.Header.Get("Content-Type")),
+		Digest:    digest,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// FetchManifest returns the raw manifest the reference points at along with
+// its descriptor. The content is checked against the reference's digest if it
+// has one.
+func (c *Client) FetchManifest(ctx context.Context, ref Reference) ([]byte, Descriptor, error) {
+	req, err := newRequest(ctx, http.MethodGet, c.url(ref, "/manifests/"+ref.manifestReference()), nil)
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	req.Header.Set("Accept", manifestAcceptTypes)
+	resp, err := c.do(req, ref, repositoryScope(ref, "pull"))
+	if err != nil {
+		return nil, Descriptor{}, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, Descriptor{}, err
+	}
+	defer drain(resp)
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, Descriptor{}, fmt.Errorf("reading manifest: %w", err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, Descriptor{}, fmt.Errorf("manifest for %s is larger than %d bytes", ref, maxManifestSize)
+	}
+	desc := descriptorFor(mediaTypeOf(data, resp.Header.Get("Content-Type")), data)
+	if ref.Digest != "" && ref.Digest != desc.Digest {
+		return nil, Descriptor{}, fmt.Errorf("manifest for %s has digest %s", ref, desc.Digest)
+	}
+	return data, desc, nil
+}
+
+// FetchBlob downloads the blob and checks it against the descriptor's digest
+func (c *Client) FetchBlob(ctx context.Context, ref Reference, desc Descriptor) ([]byte, error) {
+	req, err := newRequest(ctx, http.MethodGet, c.url(ref, "/blobs/"+desc.Digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req, ref, repositoryScope(ref, "pull"))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+	defer drain(resp)
+
+	r := io.Reader(resp.Body)
+	if desc.Size > 0 {
+		r = io.LimitReader(resp.Body, desc.Size+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading blob %s: %w", desc.Digest, err)
+	}
+	if desc.Size > 0 && int64(len(data)) != desc.Size {
+		return nil, fmt.Errorf("blob %s is %d bytes, expected %d", desc.Digest, len(data), desc.Size)
+	}
+	if got := digestOf(data); got != desc.Digest {
+		return nil, fmt.Errorf("blob %s has digest %s", desc.Digest, got)
+	}
+	return data, nil
+}
+
+// blobExists reports whether the repository already has the blob
+func (c *Client) blobExists(ctx context.Context, ref Reference, digest string) (bool, error) {
+	req, err := newRequest(ctx, http.MethodHead, c.url(ref, "/blobs/"+digest), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req, ref, repositoryScope(ref, "pull", "push"))
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		drain(resp)
+		return false, nil
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return false, err
+	}
+	drain(resp)
+	return true, nil
+}
+
+// PushBlob uploads data to the repository unless it is already there, and
+// returns its descriptor
+func (c *Client) PushBlob(ctx context.Context, ref Reference, mediaType string, data []byte) (Descriptor, error) {
+	desc := descriptorFor(mediaType, data)
+	exists, err := c.blobExists(ctx, ref, desc.Digest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if exists {
+		return desc, nil
+	}
+
+	scope := repositoryScope(ref, "pull", "push")
+	req, err := newRequest(ctx, http.MethodPost, c.url(ref, "/blobs/uploads/"), []byte{})
+	if err != nil {
+		return Descriptor{}, err
+	}
+	resp, err := c.do(req, ref, scope)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := checkResponse(resp, http.StatusAccepted); err != nil {
+		return Descriptor{}, err
+	}
+	drain(resp)
+
+	location, err := resolveURL(resp.Request.URL, resp.Header.Get("Location"))
+	if err != nil {
+		return Descriptor{}, err
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	q := u.Query()
+	q.Set("digest", desc.Digest)
+	u.RawQuery = q.Encode()
+
+	req, err = newRequest(ctx, http.MethodPut, u.String(), data)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.ContentLength = int64(len(data))
+	resp, err = c.do(req, ref, scope)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := checkResponse(resp, http.StatusCreated); err != nil {
+		return Descriptor{}, err
+	}
+	drain(resp)
+	return desc, nil
+}
+
+// PushManifest uploads a manifest under the reference's tag, or its digest if
+// it has no tag, and returns the manifest's descriptor
+func (c *Client) PushManifest(ctx context.Context, ref Reference, mediaType string, data []byte) (Descriptor, error) {
+	desc, _, err := c.pushManifest(ctx, ref, mediaType, data)
+	return desc, err
+}
+
+// pushManifest pushes a manifest and also returns the registry's response
+// headers
+func (c *Client) pushManifest(ctx context.Context, ref Reference, mediaType string, data []byte) (Descriptor, http.Header, error) {
+	desc := descriptorFor(mediaType, data)
+	target := ref.Tag
+	if target == "" {
+		target = desc.Digest
+	}
+	req, err := newRequest(ctx, http.MethodPut, c.url(ref, "/manifests/"+target), data)
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req, ref, repositoryScope(ref, "pull", "push"))
+	if err != nil {
+		return Descriptor{}, nil, err
+	}
+	if err := checkResponse(resp, http.StatusCreated); err != nil {
+		return Descriptor{}, nil, err
+	}
+	drain(resp)
+	if d := resp.Header.Get("Docker-Content-Digest"); d != "" && d != desc.Digest {
+		return Descriptor{}, nil, fmt.Errorf("registry stored manifest as %s, expected %s", d, desc.Digest)
+	}
+	return desc, resp.Header, nil
+}
+
+// Tags lists the tags in the repository, following pagination
+func (c *Client) Tags(ctx context.Context, repo Reference) ([]string, error) {
+	tags := []string{}
+	next := c.url(repo, "/tags/list")
+	for next != "" {
+		req, err := newRequest(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req, repo, repositoryScope(repo, "pull"))
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(resp, http.StatusOK); err != nil {
+			return nil, err
+		}
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		drain(resp)
+		if err != nil {
+			return nil, fmt.Errorf("decoding tag list: %w", err)
+		}
+		tags = append(tags, page.Tags...)
+
+		next = ""
+		if link := nextLink(resp.Header.Get("Link")); link != "" {
+			if next, err = resolveURL(resp.Request.URL, link); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tags, nil
+}
+
+// nextLink returns the target of a `rel="next"` Link header, which is how
+// registries paginate tag lists
+func nextLink(header string) string {
+	for _, part := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(part, ";")
+		if !ok || !strings.Contains(strings.ReplaceAll(params, " ", ""), `rel="next"`) {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+	}
+	return ""
+}
+
+// Forward sends a pull request made by another client to the repository's
+// registry, authenticating with the client's credentials for it the same way
+// the client's own requests do. path is the part of the API path after the
+// repository, such as "/manifests/v1", and header holds the request headers
+// to pass on, such as Accept and Range. Only GET and HEAD are allowed. The
+// response is returned whatever its status; the caller closes its body.
+func (c *Client) Forward(ctx context.Context, method string, repo Reference, path, rawQuery string, header http.Header) (*http.Response, error) {
+	if method != http.MethodGet && method != http.MethodHead {
+		return nil, fmt.Errorf("forwarding %s requests is not supported", method)
+	}
+	u := c.url(repo, path)
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+	req, err := newRequest(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return c.do(req, repo, repositoryScope(repo, "pull"))
+}
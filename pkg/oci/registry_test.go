@@ -0,0 +1,294 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+var testModule = []byte("\x00asm\x01\x00\x00\x00")
+
+// testRegistry wraps an ocitest server with helpers for building clients and
+// references that point at it
+type testRegistry struct {
+	*ocitest.Server
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	return &testRegistry{ocitest.NewServer(t)}
+}
+
+func (r *testRegistry) client(opts ...Option) *Client {
+	return NewClient(append([]Option{WithPlainHTTP(r.Host())}, opts...)...)
+}
+
+func (r *testRegistry) ref(t *testing.T, s string) Reference {
+	t.Helper()
+	ref, err := ParseReference(r.Ref(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestPushPull(t *testing.T) {
+	reg := newTestRegistry(t)
+	client := reg.client()
+	ctx := context.Background()
+	ref := reg.ref(t, "demos/hello:v1")
+
+	desc, err := client.Push(ctx, ref, testModule, PushOptions{Title: "hello.wasm", Annotations: map[string]string{"a": "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.MediaType != ManifestMediaType {
+		t.Errorf("unexpected media type %q", desc.MediaType)
+	}
+
+	module, err := client.Pull(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(module.Data, testModule) {
+		t.Errorf("pulled %q, want %q", module.Data, testModule)
+	}
+	if module.Digest != desc.Digest {
+		t.Errorf("pulled digest %s, pushed %s", module.Digest, desc.Digest)
+	}
+	layer, err := module.Manifest.ModuleLayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer.Annotations[AnnotationTitle] != "hello.wasm" {
+		t.Errorf("missing title annotation: %v", layer.Annotations)
+	}
+	if module.Manifest.Config.MediaType != WasmConfigMediaType || module.Manifest.Annotations["a"] != "b" {
+		t.Errorf("unexpected manifest %+v", module.Manifest)
+	}
+
+	// Pulling by digest must give the same module
+	if _, err := client.Pull(ctx, ref.WithDigest(desc.Digest)); err != nil {
+		t.Errorf("pulling by digest: %v", err)
+	}
+
+	// Pushing again must reuse the blobs already uploaded
+	uploads := reg.Uploads()
+	if _, err := client.Push(ctx, reg.ref(t, "demos/hello:v2"), testModule, PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if reg.Uploads() != uploads {
+		t.Errorf("expected existing blobs to be reused, got %d new uploads", reg.Uploads()-uploads)
+	}
+}
+
+func TestPushRejectsNonWasm(t *testing.T) {
+	reg := newTestRegistry(t)
+	_, err := reg.client().Push(context.Background(), reg.ref(t, "app:v1"), []byte("#!/bin/sh"), PushOptions{})
+	if err == nil {
+		t.Fatal("expected pushing a non-wasm file to fail")
+	}
+}
+
+func TestPullContainerImage(t *testing.T) {
+	reg := newTestRegistry(t)
+	client := reg.client()
+	ctx := context.Background()
+	ref := reg.ref(t, "nginx:latest")
+
+	layer, err := client.PushBlob(ctx, ref, "application/vnd.oci.image.layer.v1.tar+gzip", []byte("layer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := client.PushBlob(ctx, ref, "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(Manifest{SchemaVersion: 2, MediaType: ManifestMediaType, Config: config, Layers: []Descriptor{layer}})
+	if _, err := client.PushManifest(ctx, ref, ManifestMediaType, data); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Pull(ctx, ref)
+	if !errors.Is(err, ErrNotModule) || !strings.Contains(err.Error(), "container image") {
+		t.Errorf("expected a container image error, got %v", err)
+	}
+}
+
+func TestPullNotFound(t *testing.T) {
+	reg := newTestRegistry(t)
+	_, err := reg.client().Pull(context.Background(), reg.ref(t, "missing:v1"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "MANIFEST_UNKNOWN") {
+		t.Errorf("expected the registry error code in %q", err)
+	}
+}
+
+func TestPullIndex(t *testing.T) {
+	reg := newTestRegistry(t)
+	client := reg.client()
+	ctx := context.Background()
+	ref := reg.ref(t, "multi:v1")
+
+	desc, err := client.Push(ctx, ref, testModule, PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc.Platform = &Platform{Architecture: "wasm", OS: "wasi"}
+	idx, _ := json.Marshal(Index{SchemaVersion: 2, MediaType: IndexMediaType, Manifests: []Descriptor{desc}})
+	if _, err := client.PushManifest(ctx, ref, IndexMediaType, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	module, err := client.Pull(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if module.Digest != desc.Digest {
+		t.Errorf("expected the wasm entry %s, got %s", desc.Digest, module.Digest)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	reg := newTestRegistry(t)
+	reg.Username, reg.Password, reg.Token = "user", "pass", "secret-token"
+	ctx := context.Background()
+	ref := reg.ref(t, "private:v1")
+
+	if _, err := reg.client().Push(ctx, ref, testModule, PushOptions{}); err == nil {
+		t.Fatal("expected an anonymous push to fail")
+	}
+
+	client := reg.client(WithCredentials(StaticCredential(Credential{Username: "user", Password: "pass"})))
+	if _, err := client.Push(ctx, ref, testModule, PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Pull(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenClient := reg.client(WithCredentials(StaticCredential(Credential{RegistryToken: "secret-token"})))
+	if _, err := tokenClient.Resolve(ctx, ref); err != nil {
+		t.Errorf("resolving with a registry token: %v", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	reg := newTestRegistry(t)
+	// Force the client to follow Link headers
+	reg.TagPageSize = 2
+	client := reg.client()
+	ctx := context.Background()
+	for _, tag := range []string{"v1", "v2", "v3", "latest", "dev"} {
+		if _, err := client.Push(ctx, reg.ref(t, "app:"+tag), testModule, PushOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := ParseRepository(reg.Host() + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := client.Tags(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tags, ","); got != "dev,latest,v1,v2,v3" {
+		t.Errorf("got tags %s", got)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	reg := newTestRegistry(t)
+	client := reg.client()
+	ctx := context.Background()
+	ref := reg.ref(t, "app:v1")
+	desc, err := client.Push(ctx, ref, testModule, PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := client.Inspect(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Digest != desc.Digest || in.Manifest == nil || string(in.Config) != "{}" {
+		t.Errorf("unexpected inspection %+v", in)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := newTestRegistry(t)
+	dst := newTestRegistry(t)
+	dst.Username, dst.Password, dst.Token = "user", "pass", "dst-token"
+	ctx := context.Background()
+
+	creds := func(registry string) (Credential, error) {
+		if registry == dst.Host() {
+			return Credential{Username: "user", Password: "pass"}, nil
+		}
+		return Credential{}, nil
+	}
+	client := NewClient(WithPlainHTTP(src.Host(), dst.Host()), WithCredentials(creds))
+
+	srcRef := src.ref(t, "app:v1")
+	pushed, err := client.Push(ctx, srcRef, testModule, PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstRef := dst.ref(t, "mirror/app:v1")
+	copied, err := client.Copy(ctx, srcRef, dstRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.Digest != pushed.Digest {
+		t.Errorf("copy changed the digest from %s to %s", pushed.Digest, copied.Digest)
+	}
+	module, err := client.Pull(ctx, dstRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(module.Data, testModule) {
+		t.Errorf("copied module differs")
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	c, err := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.scheme != "bearer" {
+		t.Errorf("got scheme %q", c.scheme)
+	}
+	want := map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry.example.com",
+		"scope":   "repository:a/b:pull,push",
+	}
+	for k, v := range want {
+		if c.params[k] != v {
+			t.Errorf("%s = %q, want %q", k, c.params[k], v)
+		}
+	}
+
+	c, err = parseChallenge(`Basic realm=registry`)
+	if err != nil || c.scheme != "basic" || c.params["realm"] != "registry" {
+		t.Errorf("unexpected challenge %+v (%v)", c, err)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	if got := nextLink(`</v2/app/tags/list?n=2&last=b>; rel="next"`); got != "/v2/app/tags/list?n=2&last=b" {
+		t.Errorf("got %q", got)
+	}
+	if got := nextLink(""); got != "" {
+		t.Errorf("got %q", got)
+	}
+}
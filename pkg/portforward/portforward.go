@@ -0,0 +1,166 @@
+// Package portforward serves the kubelet's port forwarding API for krustlet
+// nodes, so kubectl port-forward reaches wasm pods that listen on a port,
+// such as WAGI modules.
+//
+// The streaming protocols, SPDY and WebSocket, are the kubelet's own. Where
+// the kubelet dials the port inside the pod's network namespace, krustlet
+// pods share the host's network, so the port is dialled on the host instead.
+package portforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+	kubeletportforward "k8s.io/kubelet/pkg/cri/streaming/portforward"
+)
+
+// The kubelet's default timeouts
+const (
+	// DefaultIdleTimeout is how long a connection may go without traffic
+	DefaultIdleTimeout = 4 * time.Hour
+	// DefaultStreamCreationTimeout is how long the client may take to open
+	// the streams of a forwarded connection
+	DefaultStreamCreationTimeout = 30 * time.Second
+)
+
+// Handler serves /portForward/{namespace}/{pod} and
+// /portForward/{namespace}/{pod}/{uid}
+type Handler struct {
+	pods corelisters.PodLister
+	// IdleTimeout and StreamCreationTimeout default to the kubelet's
+	IdleTimeout           time.Duration
+	StreamCreationTimeout time.Duration
+
+	dialer net.Dialer
+}
+
+// New returns a handler that finds pods with the informer factory, which
+// should only list pods on the node. The factory must be started by the
+// caller.
+func New(factory informers.SharedInformerFactory) *Handler {
+	return &Handler{
+		pods:                  factory.Core().V1().Pods().Lister(),
+		IdleTimeout:           DefaultIdleTimeout,
+		StreamCreationTimeout: DefaultStreamCreationTimeout,
+		dialer:                net.Dialer{Timeout: 10 * time.Second},
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/portForward/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	pod, err := h.pods.Pods(parts[0]).Get(parts[1])
+	if err != nil || (len(parts) == 3 && string(pod.UID) != parts[2]) {
+		http.Error(w, fmt.Sprintf("pod %s/%s not found", parts[0], parts[1]), http.StatusNotFound)
+		return
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		http.Error(w, fmt.Sprintf("pod %s/%s is not running", pod.Namespace, pod.Name), http.StatusBadRequest)
+		return
+	}
+	opts, err := kubeletportforward.NewV4Options(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	klog.V(2).InfoS("Forwarding ports", "pod", klog.KObj(pod))
+	kubeletportforward.ServePortForward(w, r, &forwarder{handler: h, pod: pod}, pod.Name, pod.UID, opts,
+		h.IdleTimeout, h.StreamCreationTimeout, kubeletportforward.SupportedProtocols)
+}
+
+// forwarder forwards connections to one pod
+type forwarder struct {
+	handler *Handler
+	pod     *corev1.Pod
+}
+
+// PortForward implements the kubelet's PortForwarder. Failures are reported
+// to the client on the connection's error stream.
+func (f *forwarder) PortForward(ctx context.Context, _ string, _ types.UID, port int32, stream io.ReadWriteCloser) error {
+	if !f.declares(port) {
+		return fmt.Errorf("port %d is not a TCP port of any of the pod's containers", port)
+	}
+	conn, err := f.dial(ctx, port)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	errs := make(chan error, 2)
+	go func() {
+		// The client closes its side of the stream when it is done sending
+		_, err := io.Copy(conn, stream)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+		errs <- err
+	}()
+	go func() {
+		_, err := io.Copy(stream, conn)
+		errs <- err
+	}()
+	// Once either side is done, the other gets a moment to finish, as
+	// containerd gives it; the client waits for the error stream to close
+	// before it closes its side
+	err = <-errs
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// declares reports whether the pod declares the port. Pods on a krustlet
+// node share the host's network, so any other port may belong to another pod
+// or to the host itself.
+func (f *forwarder) declares(port int32) bool {
+	for _, c := range f.pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.ContainerPort == port && (p.Protocol == "" || p.Protocol == corev1.ProtocolTCP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dial connects to the port the way a module on the host would be reached:
+// on the loopback address, then the pod's and host's IPs, since a module may
+// listen on any of them
+func (f *forwarder) dial(ctx context.Context, port int32) (net.Conn, error) {
+	hosts := []string{"127.0.0.1"}
+	for _, ip := range []string{f.pod.Status.PodIP, f.pod.Status.HostIP} {
+		if ip != "" && !slices.Contains(hosts, ip) {
+			hosts = append(hosts, ip)
+		}
+	}
+	var err error
+	for _, host := range hosts {
+		var conn net.Conn
+		conn, err = f.handler.dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,172 @@
+package portforward
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// echoServer stands in for a module: it answers each line with the line in
+// upper case
+func echoServer(t *testing.T) int32 {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					_, _ = io.WriteString(conn, strings.ToUpper(scanner.Text())+"\n")
+				}
+			}()
+		}
+	}()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+func newTestServer(t *testing.T, pod *corev1.Pod) *httptest.Server {
+	t.Helper()
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	if err := factory.Core().V1().Pods().Informer().GetIndexer().Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/portForward/", New(factory))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// forward runs kubectl port-forward's client against the server and returns
+// the local port
+func forward(t *testing.T, srv *httptest.Server, path string, port int32) (uint16, <-chan error) {
+	t.Helper()
+	transport, upgrader, err := spdy.RoundTripperFor(&rest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(srv.URL + path)
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, u)
+	stop, ready := make(chan struct{}), make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	fw, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{"0:" + strconv.Itoa(int(port))}, stop, ready, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- fw.ForwardPorts() }()
+	select {
+	case <-ready:
+	case err := <-errs:
+		t.Fatalf("port forwarding failed: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for port forwarding")
+	}
+	ports, err := fw.GetPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ports[0].Local, errs
+}
+
+func testPod(port int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "uid-1"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "app",
+			Ports: []corev1.ContainerPort{{ContainerPort: port}},
+		}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	port := echoServer(t)
+	srv := newTestServer(t, testPod(port))
+	local, _ := forward(t, srv, "/portForward/default/hello", port)
+
+	// Two connections over the same session
+	for _, msg := range []string{"hello", "again"} {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(local))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if _, err := io.WriteString(conn, msg+"\n"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.ToUpper(msg) + "\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPortForwardUndeclaredPort(t *testing.T) {
+	port := echoServer(t)
+	// The pod declares another port, so the module's port is off limits
+	srv := newTestServer(t, testPod(port+1))
+	local, errs := forward(t, srv, "/portForward/default/hello", port)
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(local))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, _ = io.WriteString(conn, "hello\n")
+	if data, _ := io.ReadAll(conn); len(data) > 0 {
+		t.Errorf("read %q from an undeclared port", data)
+	}
+	select {
+	case err := <-errs:
+		// The client logs the error it was sent and gives up on the session
+		if err == nil {
+			t.Error("port forwarding succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("port forwarding didn't fail")
+	}
+}
+
+func TestPortForwardPodNotFound(t *testing.T) {
+	srv := newTestServer(t, testPod(8080))
+	for _, path := range []string{"/portForward/default/missing", "/portForward/default/hello/other-uid"} {
+		resp, err := http.Post(srv.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: got %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package prefetch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// ErrorAnnotation is set on a prefetch ConfigMap that can't be acted on,
+// saying why
+const ErrorAnnotation = "krustlet.dev/prefetch-error"
+
+// Controller stages the modules listed in prefetch ConfigMaps on krustlet
+// nodes, and records per-node progress in each ConfigMap's status
+// annotation. Nodes that join later get the modules too.
+type Controller struct {
+	client kubernetes.Interface
+	arch   string
+	gate   Gate
+
+	configMaps corelisters.ConfigMapLister
+	nodes      corelisters.NodeLister
+	pods       corelisters.PodLister
+	synced     []cache.InformerSynced
+	queue      workqueue.TypedRateLimitingInterface[string]
+}
+
+// NewController returns a controller for krustlet nodes with the given
+// architecture label. The factory must be started by the caller.
+func NewController(client kubernetes.Interface, factory informers.SharedInformerFactory, arch string, gate Gate) *Controller {
+	configMaps := factory.Core().V1().ConfigMaps()
+	nodes := factory.Core().V1().Nodes()
+	pods := factory.Core().V1().Pods()
+	c := &Controller{
+		client:     client,
+		arch:       arch,
+		gate:       gate,
+		configMaps: configMaps.Lister(),
+		nodes:      nodes.Lister(),
+		pods:       pods.Lister(),
+		synced:     []cache.InformerSynced{configMaps.Informer().HasSynced, nodes.Informer().HasSynced, pods.Informer().HasSynced},
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "krustlet-prefetch"},
+		),
+	}
+	_, _ = configMaps.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueConfigMap,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueConfigMap(obj) },
+	})
+	_, _ = pods.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueOwner,
+		UpdateFunc: func(_, obj interface{}) { c.enqueueOwner(obj) },
+		DeleteFunc: c.enqueueOwner,
+	})
+	_, _ = nodes.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueueAll,
+		DeleteFunc: c.enqueueAll,
+		UpdateFunc: func(old, obj interface{}) {
+			// Only a label change can change which ConfigMaps target a node
+			if !labels.Equals(old.(*corev1.Node).Labels, obj.(*corev1.Node).Labels) {
+				c.enqueueAll(obj)
+			}
+		},
+	})
+	return c
+}
+
+func (c *Controller) enqueueConfigMap(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || (cm.Labels[PrefetchLabel] != "true" && cm.Annotations[StatusAnnotation] == "") {
+		return
+	}
+	c.queue.Add(cm.Namespace + "/" + cm.Name)
+}
+
+func (c *Controller) enqueueOwner(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Labels[ownerLabel] == "" {
+		return
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "ConfigMap" {
+		c.queue.Add(pod.Namespace + "/" + owner.Name)
+	}
+}
+
+func (c *Controller) enqueueAll(interface{}) {
+	cms, err := c.configMaps.List(labels.SelectorFromSet(labels.Set{PrefetchLabel: "true"}))
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, cm := range cms {
+		c.queue.Add(cm.Namespace + "/" + cm.Name)
+	}
+}
+
+// Run processes prefetch ConfigMaps until the context is cancelled
+func (c *Controller) Run(ctx context.Context, workers int) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	klog.Info("Waiting for informers to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("timed out waiting for informers to sync")
+	}
+	klog.InfoS("Starting module prefetcher", "workers", workers)
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNext(ctx) {
+	}
+}
+
+func (c *Controller) processNext(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(ctx, key); err != nil {
+		klog.ErrorS(err, "Failed to sync prefetch ConfigMap, retrying", "configMap", key)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+// sync makes sure every targeted node has the ConfigMap's modules, or a pod
+// pulling them, and records how each node is doing
+func (c *Controller) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	cm, err := c.configMaps.ConfigMaps(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		// The pods are garbage collected with their owner
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pods, err := c.pods.Pods(namespace).List(labels.SelectorFromSet(labels.Set{ownerLabel: string(cm.UID)}))
+	if err != nil {
+		return err
+	}
+
+	if cm.Labels[PrefetchLabel] != "true" {
+		// No longer a prefetch ConfigMap: stop pulling and drop the status
+		for _, pod := range pods {
+			if err := c.deletePod(ctx, pod); err != nil {
+				return err
+			}
+		}
+		return c.updateAnnotations(ctx, cm, "", "")
+	}
+	spec, err := ParseSpec(cm)
+	if err != nil {
+		klog.InfoS("Invalid prefetch ConfigMap", "configMap", key, "err", err)
+		return c.updateAnnotations(ctx, cm, cm.Annotations[StatusAnnotation], err.Error())
+	}
+	hash := spec.Hash()
+
+	nodes, err := c.nodes.List(labels.SelectorFromSet(labels.Set{archLabel: c.arch}))
+	if err != nil {
+		return err
+	}
+	podsByNode := map[string]*corev1.Pod{}
+	for _, pod := range pods {
+		if pod.Annotations[hashAnnotation] != hash {
+			// Staging an older module list
+			if err := c.deletePod(ctx, pod); err != nil {
+				return err
+			}
+			continue
+		}
+		podsByNode[pod.Annotations[nodeAnnotation]] = pod
+	}
+
+	previous := ParseStatus(cm)
+	status := Status{}
+	for _, node := range nodes {
+		if !spec.NodeSelector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		pod := podsByNode[node.Name]
+		delete(podsByNode, node.Name)
+		if pod == nil {
+			if st, ok := previous[node.Name]; ok && st.Hash == hash && st.State == StateComplete {
+				status[node.Name] = st
+				continue
+			}
+			if err := c.createPod(ctx, podFor(cm, spec, node.Name, c.arch, c.gate)); err != nil {
+				return err
+			}
+			status[node.Name] = NodeStatus{State: StatePending, Hash: hash}
+			continue
+		}
+
+		state, message := podState(pod)
+		status[node.Name] = NodeStatus{State: state, Hash: hash, Message: message}
+		if state == StateComplete && pod.DeletionTimestamp == nil {
+			klog.InfoS("Staged modules", "configMap", key, "node", node.Name)
+			if err := c.deletePod(ctx, pod); err != nil {
+				return err
+			}
+		}
+	}
+	// Pods for nodes that are gone or no longer selected
+	for _, pod := range podsByNode {
+		if err := c.deletePod(ctx, pod); err != nil {
+			return err
+		}
+	}
+	return c.updateAnnotations(ctx, cm, status.encode(), "")
+}
+
+func (c *Controller) createPod(ctx context.Context, pod *corev1.Pod) error {
+	_, err := c.client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("creating prefetch pod for node %s: %w", pod.Spec.NodeName, err)
+	}
+	return nil
+}
+
+func (c *Controller) deletePod(ctx context.Context, pod *corev1.Pod) error {
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+	err := c.client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("deleting prefetch pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// updateAnnotations sets the status and error annotations, removing those
+// that are empty, if they have changed
+func (c *Controller) updateAnnotations(ctx context.Context, cm *corev1.ConfigMap, status, errMessage string) error {
+	if cm.Annotations[StatusAnnotation] == status && cm.Annotations[ErrorAnnotation] == errMessage {
+		return nil
+	}
+	cm = cm.DeepCopy()
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	for key, value := range map[string]string{StatusAnnotation: status, ErrorAnnotation: errMessage} {
+		if value == "" {
+			delete(cm.Annotations, key)
+		} else {
+			cm.Annotations[key] = value
+		}
+	}
+	_, err := c.client.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,317 @@
+package prefetch
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testArch = "wasm32-wasi"
+
+type testEnv struct {
+	c       *Controller
+	client  *fake.Clientset
+	factory informers.SharedInformerFactory
+}
+
+func newTestEnv(t *testing.T, objs ...runtime.Object) *testEnv {
+	t.Helper()
+	client := fake.NewSimpleClientset(objs...)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	c := NewController(client, factory, testArch, Gate{Image: DefaultGateImage, Args: DefaultGateArgs})
+	env := &testEnv{c: c, client: client, factory: factory}
+	for _, obj := range objs {
+		env.index(t, obj)
+	}
+	return env
+}
+
+func (env *testEnv) index(t *testing.T, obj runtime.Object) {
+	t.Helper()
+	var err error
+	switch o := obj.(type) {
+	case *corev1.ConfigMap:
+		err = env.factory.Core().V1().ConfigMaps().Informer().GetIndexer().Add(o)
+	case *corev1.Node:
+		err = env.factory.Core().V1().Nodes().Informer().GetIndexer().Add(o)
+	case *corev1.Pod:
+		err = env.factory.Core().V1().Pods().Informer().GetIndexer().Add(o)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// refresh copies the objects the controller changed into the informer caches
+func (env *testEnv) refresh(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	pods, err := env.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	podIndexer := env.factory.Core().V1().Pods().Informer().GetIndexer()
+	for _, obj := range podIndexer.List() {
+		_ = podIndexer.Delete(obj)
+	}
+	for i := range pods.Items {
+		env.index(t, &pods.Items[i])
+	}
+	cms, err := env.client.CoreV1().ConfigMaps("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cms.Items {
+		if err := env.factory.Core().V1().ConfigMaps().Informer().GetIndexer().Update(&cms.Items[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (env *testEnv) sync(t *testing.T) {
+	t.Helper()
+	if err := env.c.sync(context.Background(), "edge/rollout"); err != nil {
+		t.Fatal(err)
+	}
+	env.refresh(t)
+}
+
+func (env *testEnv) pods(t *testing.T) []corev1.Pod {
+	t.Helper()
+	pods, err := env.client.CoreV1().Pods("edge").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pods.Items
+}
+
+func (env *testEnv) status(t *testing.T) Status {
+	t.Helper()
+	cm, err := env.client.CoreV1().ConfigMaps("edge").Get(context.Background(), "rollout", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ParseStatus(cm)
+}
+
+// setPodStatus updates the node's prefetch pod as krustlet would
+func (env *testEnv) setPodStatus(t *testing.T, node string, phase corev1.PodPhase, reason string) {
+	t.Helper()
+	for _, pod := range env.pods(t) {
+		if pod.Spec.NodeName != node {
+			continue
+		}
+		pod.Status.Phase, pod.Status.Reason = phase, reason
+		if _, err := env.client.CoreV1().Pods("edge").UpdateStatus(context.Background(), &pod, metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.refresh(t)
+}
+
+func prefetchConfigMap(modules string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "rollout",
+			Namespace: "edge",
+			UID:       "cm-uid",
+			Labels:    map[string]string{PrefetchLabel: "true"},
+		},
+		Data: map[string]string{ModulesKey: modules},
+	}
+}
+
+func node(name string, extraLabels ...string) *corev1.Node {
+	n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{archLabel: testArch}}}
+	for i := 0; i+1 < len(extraLabels); i += 2 {
+		n.Labels[extraLabels[i]] = extraLabels[i+1]
+	}
+	return n
+}
+
+func TestSyncCreatesPodPerNode(t *testing.T) {
+	cm := prefetchConfigMap("webassembly.azurecr.io/hello-wasm:v1\n# comment\n\nwebassembly.azurecr.io/fileserver:v2\n")
+	linux := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "linux", Labels: map[string]string{archLabel: "amd64"}}}
+	env := newTestEnv(t, cm, node("edge-1"), node("edge-2"), linux)
+	env.sync(t)
+
+	pods := env.pods(t)
+	if len(pods) != 2 {
+		t.Fatalf("expected a pod for each krustlet node, got %d", len(pods))
+	}
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "edge-1" && pod.Spec.NodeName != "edge-2" {
+			t.Errorf("unexpected node %q", pod.Spec.NodeName)
+		}
+		if len(pod.Spec.InitContainers) != 1 || pod.Spec.InitContainers[0].Image != DefaultGateImage {
+			t.Errorf("expected the gate init container, got %+v", pod.Spec.InitContainers)
+		}
+		if len(pod.Spec.Containers) != 2 || pod.Spec.Containers[1].Image != "webassembly.azurecr.io/fileserver:v2" {
+			t.Errorf("unexpected containers %+v", pod.Spec.Containers)
+		}
+		if owner := metav1.GetControllerOf(&pod); owner == nil || owner.UID != cm.UID {
+			t.Errorf("expected the ConfigMap to own the pod, got %+v", pod.OwnerReferences)
+		}
+	}
+	status := env.status(t)
+	if len(status) != 2 || status["edge-1"].State != StatePending {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// Syncing again doesn't create more pods
+	env.sync(t)
+	if got := len(env.pods(t)); got != 2 {
+		t.Errorf("expected 2 pods, got %d", got)
+	}
+}
+
+func TestSyncTracksProgress(t *testing.T) {
+	env := newTestEnv(t, prefetchConfigMap("webassembly.azurecr.io/hello-wasm:v1"), node("edge-1"), node("edge-2"))
+	env.sync(t)
+
+	env.setPodStatus(t, "edge-1", corev1.PodPending, reasonImagePull)
+	env.setPodStatus(t, "edge-2", corev1.PodPending, reasonImagePullBackoff)
+	env.sync(t)
+	status := env.status(t)
+	if status["edge-1"].State != StatePulling || status["edge-2"].State != StateBackoff {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// The gate failing means the pull finished
+	env.setPodStatus(t, "edge-1", corev1.PodFailed, "Error")
+	env.sync(t)
+	status = env.status(t)
+	if status["edge-1"].State != StateComplete {
+		t.Fatalf("expected edge-1 to be complete, got %+v", status)
+	}
+	pods := env.pods(t)
+	if len(pods) != 1 || pods[0].Spec.NodeName != "edge-2" {
+		t.Fatalf("expected only edge-2's pod to remain, got %d pods", len(pods))
+	}
+
+	// A completed node isn't staged again
+	env.sync(t)
+	if len(env.pods(t)) != 1 || env.status(t)["edge-1"].State != StateComplete {
+		t.Error("expected edge-1 to stay complete without a new pod")
+	}
+}
+
+func TestSyncRestagesChangedModules(t *testing.T) {
+	cm := prefetchConfigMap("webassembly.azurecr.io/hello-wasm:v1")
+	env := newTestEnv(t, cm, node("edge-1"))
+	env.sync(t)
+	env.setPodStatus(t, "edge-1", corev1.PodPending, "VolumeMount")
+	env.sync(t)
+	if len(env.pods(t)) != 0 {
+		t.Fatal("expected the pod to be deleted once complete")
+	}
+
+	updated, err := env.client.CoreV1().ConfigMaps("edge").Get(context.Background(), "rollout", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated.Data[ModulesKey] = "webassembly.azurecr.io/hello-wasm:v2"
+	if _, err := env.client.CoreV1().ConfigMaps("edge").Update(context.Background(), updated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.refresh(t)
+	env.sync(t)
+	pods := env.pods(t)
+	if len(pods) != 1 || pods[0].Spec.Containers[0].Image != "webassembly.azurecr.io/hello-wasm:v2" {
+		t.Fatalf("expected a pod for the new module, got %+v", pods)
+	}
+	if st := env.status(t)["edge-1"]; st.State != StatePending {
+		t.Errorf("expected edge-1 to be pending again, got %+v", st)
+	}
+}
+
+func TestSyncNodeSelector(t *testing.T) {
+	cm := prefetchConfigMap("webassembly.azurecr.io/hello-wasm:v1")
+	cm.Annotations = map[string]string{NodeSelectorAnnotation: "site=factory"}
+	env := newTestEnv(t, cm, node("edge-1", "site", "factory"), node("edge-2", "site", "office"))
+	env.sync(t)
+
+	pods := env.pods(t)
+	if len(pods) != 1 || pods[0].Spec.NodeName != "edge-1" {
+		t.Errorf("expected only edge-1 to be targeted, got %d pods", len(pods))
+	}
+}
+
+func TestSyncInvalidSpec(t *testing.T) {
+	env := newTestEnv(t, prefetchConfigMap("# nothing yet"), node("edge-1"))
+	env.sync(t)
+
+	cm, err := env.client.CoreV1().ConfigMaps("edge").Get(context.Background(), "rollout", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cm.Annotations[ErrorAnnotation], "no modules") {
+		t.Errorf("expected an error annotation, got %v", cm.Annotations)
+	}
+	if len(env.pods(t)) != 0 {
+		t.Error("expected no pods")
+	}
+}
+
+func TestSyncUnlabelled(t *testing.T) {
+	env := newTestEnv(t, prefetchConfigMap("webassembly.azurecr.io/hello-wasm:v1"), node("edge-1"))
+	env.sync(t)
+
+	cm, err := env.client.CoreV1().ConfigMaps("edge").Get(context.Background(), "rollout", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(cm.Labels, PrefetchLabel)
+	if _, err := env.client.CoreV1().ConfigMaps("edge").Update(context.Background(), cm, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	env.refresh(t)
+	env.sync(t)
+
+	if len(env.pods(t)) != 0 {
+		t.Error("expected the prefetch pod to be deleted")
+	}
+	if len(env.status(t)) != 0 {
+		t.Error("expected the status to be removed")
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	cm := prefetchConfigMap("webassembly.azurecr.io/hello-wasm:v1")
+	cm.Data[PullPolicyKey] = "Always"
+	cm.Data[PullSecretsKey] = "acr, ghcr"
+	spec, err := ParseSpec(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.PullPolicy != corev1.PullAlways || len(spec.PullSecrets) != 2 || spec.PullSecrets[1] != "ghcr" {
+		t.Errorf("unexpected spec %+v", spec)
+	}
+
+	cm.Data[PullPolicyKey] = "Never"
+	if _, err := ParseSpec(cm); err == nil {
+		t.Error("expected Never to be rejected, since it can't stage anything")
+	}
+	cm.Data[PullPolicyKey] = ""
+	cm.Data[ModulesKey] = "Not A Reference"
+	if _, err := ParseSpec(cm); err == nil {
+		t.Error("expected an invalid reference to be rejected")
+	}
+}
+
+func TestPodName(t *testing.T) {
+	long := strings.Repeat("a", 100)
+	name := podName(long, "edge-1", "0123456789abcdef")
+	if len(name) > 63 {
+		t.Errorf("name %q is too long", name)
+	}
+	if podName("rollout", "edge-1", "h") == podName("rollout", "edge-2", "h") {
+		t.Error("expected different names for different nodes")
+	}
+}
@@ -0,0 +1,111 @@
+package prefetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ownerLabel is set on prefetch pods to the UID of their ConfigMap
+	ownerLabel = "krustlet.dev/prefetch-for"
+	// nodeAnnotation and hashAnnotation record which node and which spec a
+	// prefetch pod stages
+	nodeAnnotation = "krustlet.dev/prefetch-node"
+	hashAnnotation = "krustlet.dev/prefetch-hash"
+
+	archLabel = "kubernetes.io/arch"
+
+	// Reasons krustlet reports in the pod status while pulling. See
+	// crates/kubelet/src/state/common.
+	reasonRegistered       = "Registered"
+	reasonImagePull        = "ImagePull"
+	reasonImagePullBackoff = "ImagePullBackoff"
+)
+
+// DefaultGateImage is the module used for the gate init container
+const DefaultGateImage = "webassembly.azurecr.io/wasmerciser:v0.3.0"
+
+// DefaultGateArgs make wasmerciser exit with an error straight away
+var DefaultGateArgs = []string{"assert_exists(file:/krustlet-prefetch-gate)"}
+
+// Gate is the init container that stops a prefetch pod from running the
+// modules it pulls. It must exit unsuccessfully without needing any volumes.
+type Gate struct {
+	Image string
+	Args  []string
+}
+
+// podFor returns the pod that stages the spec's modules on a node
+func podFor(cm *corev1.ConfigMap, spec *Spec, node, arch string, gate Gate) *corev1.Pod {
+	hash := spec.Hash()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName(cm.Name, node, hash),
+			Namespace: cm.Namespace,
+			Labels:    map[string]string{ownerLabel: string(cm.UID)},
+			Annotations: map[string]string{
+				nodeAnnotation: node,
+				hashAnnotation: hash,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(cm, corev1.SchemeGroupVersion.WithKind("ConfigMap"))},
+		},
+		Spec: corev1.PodSpec{
+			// Binding the pod directly skips the scheduler, so the pod runs
+			// on the node even when it is cordoned for a rollout
+			NodeName:      node,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations: []corev1.Toleration{
+				{Key: archLabel, Operator: corev1.TolerationOpEqual, Value: arch, Effect: corev1.TaintEffectNoSchedule},
+				{Key: archLabel, Operator: corev1.TolerationOpEqual, Value: arch, Effect: corev1.TaintEffectNoExecute},
+			},
+			InitContainers: []corev1.Container{{
+				Name:            "gate",
+				Image:           gate.Image,
+				Args:            gate.Args,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+			}},
+		},
+	}
+	for i, module := range spec.Modules {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+			Name:            fmt.Sprintf("module-%d", i),
+			Image:           module,
+			ImagePullPolicy: spec.PullPolicy,
+		})
+	}
+	for _, s := range spec.PullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: s})
+	}
+	return pod
+}
+
+// podName is deterministic, so a pod created before the informer has caught
+// up isn't created twice
+func podName(configMap, node, hash string) string {
+	sum := sha256.Sum256([]byte(node + "/" + hash))
+	if len(configMap) > 40 {
+		configMap = configMap[:40]
+	}
+	return fmt.Sprintf("%s-prefetch-%s", configMap, hex.EncodeToString(sum[:])[:10])
+}
+
+// podState reports how far the node has got with a prefetch pod. Krustlet
+// pulls all of a pod's modules before it moves past ImagePull, so any later
+// state means they are cached.
+func podState(pod *corev1.Pod) (State, string) {
+	switch reason := pod.Status.Reason; {
+	case reason == reasonImagePullBackoff:
+		return StateBackoff, pod.Status.Message
+	case reason == reasonImagePull:
+		return StatePulling, ""
+	case pod.Status.Phase == corev1.PodPending && (reason == "" || reason == reasonRegistered):
+		return StatePending, ""
+	case pod.Status.Phase == "":
+		return StatePending, ""
+	}
+	return StateComplete, ""
+}
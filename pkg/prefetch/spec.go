@@ -0,0 +1,153 @@
+// Package prefetch stages wasm modules in the module caches of krustlet
+// nodes ahead of a rollout, so that pods start without waiting on a slow
+// registry link.
+//
+// The modules to stage are listed in ConfigMaps labelled
+// krustlet.dev/module-prefetch=true. Krustlet has no API for pulling a
+// module on its own, so for each node the controller creates a pod bound to
+// that node whose containers use the modules. Krustlet pulls every module of
+// a pod before running any of it; the pod's first init container is a gate
+// that fails straight away, so none of the prefetched modules run, and the
+// pod is deleted once the pull is done.
+package prefetch
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+const (
+	// PrefetchLabel marks the ConfigMaps the controller acts on
+	PrefetchLabel = "krustlet.dev/module-prefetch"
+	// NodeSelectorAnnotation holds a label selector that narrows the krustlet
+	// nodes a ConfigMap's modules are staged on
+	NodeSelectorAnnotation = "krustlet.dev/prefetch-node-selector"
+	// StatusAnnotation is where the controller records, as JSON, how staging
+	// is going on each node
+	StatusAnnotation = "krustlet.dev/prefetch-status"
+
+	// ModulesKey lists the module references to stage, one per line. Blank
+	// lines and lines starting with # are ignored.
+	ModulesKey = "modules"
+	// PullPolicyKey optionally sets the pull policy, IfNotPresent by default.
+	// Always refreshes tags that have already been pulled.
+	PullPolicyKey = "imagePullPolicy"
+	// PullSecretsKey optionally names image pull secrets in the ConfigMap's
+	// namespace, separated by commas
+	PullSecretsKey = "imagePullSecrets"
+)
+
+// Spec is what a prefetch ConfigMap asks for
+type Spec struct {
+	Modules      []string
+	PullPolicy   corev1.PullPolicy
+	PullSecrets  []string
+	NodeSelector labels.Selector
+}
+
+// ParseSpec reads the spec from a prefetch ConfigMap
+func ParseSpec(cm *corev1.ConfigMap) (*Spec, error) {
+	spec := &Spec{PullPolicy: corev1.PullIfNotPresent, NodeSelector: labels.Everything()}
+	for _, line := range strings.Split(cm.Data[ModulesKey], "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := oci.ParseReference(line); err != nil {
+			return nil, err
+		}
+		spec.Modules = append(spec.Modules, line)
+	}
+	if len(spec.Modules) == 0 {
+		return nil, fmt.Errorf("%s lists no modules", ModulesKey)
+	}
+
+	switch policy := corev1.PullPolicy(strings.TrimSpace(cm.Data[PullPolicyKey])); policy {
+	case "":
+	case corev1.PullAlways, corev1.PullIfNotPresent:
+		spec.PullPolicy = policy
+	default:
+		return nil, fmt.Errorf("%s must be Always or IfNotPresent, got %q", PullPolicyKey, policy)
+	}
+	for _, s := range strings.Split(cm.Data[PullSecretsKey], ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			spec.PullSecrets = append(spec.PullSecrets, s)
+		}
+	}
+	if s := cm.Annotations[NodeSelectorAnnotation]; s != "" {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", NodeSelectorAnnotation, err)
+		}
+		spec.NodeSelector = selector
+	}
+	return spec, nil
+}
+
+// Hash identifies what the spec stages, so that a change to the module list
+// stages the modules again. The node selector isn't included, since it only
+// decides where.
+func (s *Spec) Hash() string {
+	h := sha256.New()
+	for _, m := range s.Modules {
+		fmt.Fprintf(h, "module %s\n", m)
+	}
+	fmt.Fprintf(h, "policy %s\n", s.PullPolicy)
+	secrets := append([]string(nil), s.PullSecrets...)
+	sort.Strings(secrets)
+	for _, secret := range secrets {
+		fmt.Fprintf(h, "secret %s\n", secret)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// State is how staging the modules on a node is going
+type State string
+
+const (
+	// StatePending means the prefetch pod is waiting for the node to pick it
+	// up or to start pulling
+	StatePending State = "Pending"
+	// StatePulling means the node is pulling the modules
+	StatePulling State = "Pulling"
+	// StateBackoff means a pull failed and the node will retry it
+	StateBackoff State = "Backoff"
+	// StateComplete means the modules are in the node's cache
+	StateComplete State = "Complete"
+)
+
+// NodeStatus is the staging status for one node
+type NodeStatus struct {
+	State   State  `json:"state"`
+	Hash    string `json:"hash"`
+	Message string `json:"message,omitempty"`
+}
+
+// Status maps node names to their staging status
+type Status map[string]NodeStatus
+
+// ParseStatus reads the status annotation. A missing or malformed annotation
+// yields an empty status, so the modules are staged again.
+func ParseStatus(cm *corev1.ConfigMap) Status {
+	status := Status{}
+	if s := cm.Annotations[StatusAnnotation]; s != "" {
+		if err := json.Unmarshal([]byte(s), &status); err != nil {
+			return Status{}
+		}
+	}
+	return status
+}
+
+func (s Status) encode() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
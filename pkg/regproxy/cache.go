@@ -0,0 +1,178 @@
+package regproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// cacheablePattern matches the digests blobs are cached by. Only sha256 is
+// checked, since it is all registries use.
+var cacheablePattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+func cacheable(digest string) bool {
+	return cacheablePattern.MatchString(digest)
+}
+
+// blobCache keeps blobs on disk by digest. A blob is only added once all of
+// it was read and it matched its digest, so a blob in the cache is always
+// whole and can be served to any repository that asks for that digest.
+type blobCache struct {
+	dir     string
+	maxSize int64
+
+	// mu serializes evictions
+	mu sync.Mutex
+}
+
+func newBlobCache(dir string, maxSize int64) (*blobCache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "sha256"), 0o755); err != nil {
+		return nil, fmt.Errorf("creating blob cache: %w", err)
+	}
+	// Blobs being cached when the proxy last stopped were never finished
+	partial, _ := filepath.Glob(filepath.Join(dir, ".blob-*"))
+	for _, p := range partial {
+		_ = os.Remove(p)
+	}
+	return &blobCache{dir: dir, maxSize: maxSize}, nil
+}
+
+func (c *blobCache) path(digest string) string {
+	return filepath.Join(c.dir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// serve answers the request from the cache, including HEAD and range
+// requests, and reports whether the blob was cached
+func (c *blobCache) serve(w http.ResponseWriter, req *http.Request, digest string) bool {
+	f, err := os.Open(c.path(digest))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	// Eviction goes by modification time, so a hit marks the blob as used
+	now := time.Now()
+	_ = os.Chtimes(f.Name(), now, now)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("ETag", `"`+digest+`"`)
+	http.ServeContent(w, req, "", info.ModTime(), f)
+	return true
+}
+
+// create starts adding a blob to the cache
+func (c *blobCache) create(digest string) (*cacheEntry, error) {
+	f, err := os.CreateTemp(c.dir, ".blob-")
+	if err != nil {
+		return nil, err
+	}
+	return &cacheEntry{cache: c, digest: digest, file: f, hash: sha256.New()}, nil
+}
+
+// evict removes the least recently used blobs until the cache fits in its
+// size, keeping the one just added
+func (c *blobCache) evict(keep string) {
+	if c.maxSize <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var blobs []blob
+	var total int64
+	_ = filepath.WalkDir(filepath.Join(c.dir, "sha256"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		blobs = append(blobs, blob{path: p, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].modTime.Before(blobs[j].modTime) })
+	for _, b := range blobs {
+		if total <= c.maxSize {
+			break
+		}
+		if b.path == keep {
+			continue
+		}
+		if err := os.Remove(b.path); err != nil {
+			klog.ErrorS(err, "Evicting cached blob", "path", b.path)
+			continue
+		}
+		klog.V(2).InfoS("Evicted cached blob", "path", b.path, "size", b.size)
+		total -= b.size
+	}
+}
+
+// cacheEntry is a blob being written to the cache as it is sent to the
+// client
+type cacheEntry struct {
+	cache     *blobCache
+	digest    string
+	file      *os.File
+	hash      hash.Hash
+	failed    bool
+	committed bool
+}
+
+func (e *cacheEntry) Write(p []byte) (int, error) {
+	e.hash.Write(p)
+	// A failure to cache mustn't fail the response, so it is only noted, and
+	// the entry is discarded when it is committed
+	if !e.failed {
+		if _, err := e.file.Write(p); err != nil {
+			e.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+// commit adds the blob to the cache if it matches its digest
+func (e *cacheEntry) commit() error {
+	if err := e.file.Close(); err != nil || e.failed {
+		return fmt.Errorf("writing blob %s to the cache failed", e.digest)
+	}
+	if got := "sha256:" + hex.EncodeToString(e.hash.Sum(nil)); got != e.digest {
+		return fmt.Errorf("blob %s has digest %s", e.digest, got)
+	}
+	dst := e.cache.path(e.digest)
+	if err := os.Rename(e.file.Name(), dst); err != nil {
+		return err
+	}
+	e.committed = true
+	e.cache.evict(dst)
+	return nil
+}
+
+// abort discards the entry unless it was committed
+func (e *cacheEntry) abort() {
+	if e.committed {
+		return
+	}
+	_ = e.file.Close()
+	_ = os.Remove(e.file.Name())
+}
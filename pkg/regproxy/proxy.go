@@ -0,0 +1,279 @@
+// Package regproxy is a pull-through registry proxy that adds registry
+// credentials to the requests it forwards. It runs next to something that
+// pulls modules without credentials of its own, such as krustlet on a node
+// where distributing docker configs isn't allowed, and listens on localhost.
+//
+// Repositories are named through the proxy by the registry they are on, so
+// localhost:5000/ghcr.io/example/app:v1 is example/app:v1 on ghcr.io. Names
+// whose first component isn't a host, such as library/hello, are on Docker
+// Hub. Registries whose host has a port are given an alias to name them by.
+// Only pulls are forwarded: manifests, blobs, tag lists and referrers. The
+// proxy authenticates to each registry with the credential for its host,
+// then sends the response back as it came, following redirects itself so
+// the client never needs to reach a registry's blob storage. Blobs can be
+// cached on disk by digest, so pulls of the same module by many pods, or
+// after a restart, don't go upstream.
+package regproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// Options configure a Proxy
+type Options struct {
+	// Credentials returns the credential for each upstream registry host
+	Credentials oci.CredentialFunc
+	// Aliases are names for registries whose host can't be the first
+	// component of a repository name, usually because it has a port, keyed
+	// by the name
+	Aliases map[string]string
+	// PlainHTTP are the upstream registry hosts reached over plain HTTP
+	PlainHTTP []string
+	// Allow are path.Match patterns of the <registry>/<repository> names
+	// that may be pulled through the proxy, such as ghcr.io/example/*. Every
+	// repository is allowed if there are none.
+	Allow []string
+	// CacheDir is where blobs are cached. Blobs aren't cached if it is empty.
+	CacheDir string
+	// CacheSize caps the size of the cache in bytes, evicting the least
+	// recently used blobs. 0 means no cap.
+	CacheSize int64
+	// ClientOptions are further options for the upstream client, such as
+	// its HTTP client
+	ClientOptions []oci.Option
+}
+
+// Proxy is an http.Handler serving the pull side of the distribution API
+type Proxy struct {
+	client  *oci.Client
+	aliases map[string]string
+	allow   []string
+	cache   *blobCache
+}
+
+// New returns a proxy
+func New(opts Options) (*Proxy, error) {
+	for _, pattern := range opts.Allow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid allow pattern %q: %w", pattern, err)
+		}
+	}
+	for name, host := range opts.Aliases {
+		if name == "" || strings.ContainsAny(name, "/:") || host == "" || strings.Contains(host, "/") {
+			return nil, fmt.Errorf("invalid alias %s=%s", name, host)
+		}
+	}
+	clientOpts := []oci.Option{oci.WithUserAgent("krustlet-registry-proxy"), oci.WithPlainHTTP(opts.PlainHTTP...)}
+	if opts.Credentials != nil {
+		clientOpts = append(clientOpts, oci.WithCredentials(opts.Credentials))
+	}
+	p := &Proxy{
+		client:  oci.NewClient(append(clientOpts, opts.ClientOptions...)...),
+		aliases: opts.Aliases,
+		allow:   opts.Allow,
+	}
+	if opts.CacheDir != "" {
+		cache, err := newBlobCache(opts.CacheDir, opts.CacheSize)
+		if err != nil {
+			return nil, err
+		}
+		p.cache = cache
+	}
+	return p, nil
+}
+
+// forwardedHeaders are the request headers passed on to the registry
+var forwardedHeaders = []string{"Accept", "Range", "If-None-Match", "If-Range"}
+
+// returnedHeaders are the response headers passed back to the client. Link
+// is rewritten to point through the proxy.
+var returnedHeaders = []string{
+	"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Docker-Content-Digest",
+	"ETag", "Last-Modified", "OCI-Filters-Applied",
+}
+
+// ServeHTTP implements http.Handler
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "the proxy only serves pulls")
+		return
+	}
+	if req.URL.Path == "/v2/" || req.URL.Path == "/v2" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	name, rest, ok := splitPath(req.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "UNSUPPORTED", "not a distribution API path")
+		return
+	}
+	repo, err := p.resolve(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "NAME_INVALID", err.Error())
+		return
+	}
+	if !p.allowed(repo) {
+		klog.V(2).InfoS("Refused repository", "repository", repo.Name())
+		writeError(w, http.StatusForbidden, "DENIED", fmt.Sprintf("%s may not be pulled through this proxy", repo.Name()))
+		return
+	}
+
+	digest, isBlob := strings.CutPrefix(rest, "/blobs/")
+	if isBlob && p.cache != nil && cacheable(digest) {
+		if p.cache.serve(w, req, digest) {
+			klog.V(2).InfoS("Served cached blob", "repository", repo.Name(), "digest", digest)
+			return
+		}
+	}
+
+	header := http.Header{}
+	for _, h := range forwardedHeaders {
+		if v := req.Header.Values(h); len(v) > 0 {
+			header[h] = v
+		}
+	}
+	resp, err := p.client.Forward(req.Context(), req.Method, repo, rest, req.URL.RawQuery, header)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		klog.ErrorS(err, "Forwarding request", "repository", repo.Name(), "path", rest)
+		writeError(w, http.StatusBadGateway, "UNKNOWN", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	klog.V(2).InfoS("Forwarded request", "method", req.Method, "repository", repo.Name(), "path", rest, "status", resp.StatusCode)
+
+	for _, h := range returnedHeaders {
+		if v := resp.Header.Values(h); len(v) > 0 {
+			w.Header()[h] = v
+		}
+	}
+	if link := resp.Header.Get("Link"); link != "" {
+		w.Header().Set("Link", rewriteLink(link, repo, name))
+	}
+	status := resp.StatusCode
+	if status == http.StatusUnauthorized {
+		// The proxy has already answered the registry's challenge, so there
+		// is nothing the client could do with one of its own
+		status = http.StatusForbidden
+	}
+
+	body := io.Reader(resp.Body)
+	var entry *cacheEntry
+	if isBlob && p.cache != nil && cacheable(digest) && req.Method == http.MethodGet && status == http.StatusOK && req.Header.Get("Range") == "" {
+		if entry, err = p.cache.create(digest); err != nil {
+			klog.ErrorS(err, "Caching blob", "digest", digest)
+		} else {
+			defer entry.abort()
+			body = io.TeeReader(body, entry)
+		}
+	}
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, body); err != nil {
+		klog.V(2).InfoS("Copying response", "repository", repo.Name(), "path", rest, "err", err)
+		return
+	}
+	if entry != nil {
+		if err := entry.commit(); err != nil {
+			klog.ErrorS(err, "Caching blob", "digest", digest)
+		}
+	}
+}
+
+// splitPath splits an API path such as /v2/ghcr.io/example/app/manifests/v1
+// into the repository name and the rest of the path
+func splitPath(p string) (name, rest string, ok bool) {
+	p, ok = strings.CutPrefix(p, "/v2/")
+	if !ok {
+		return "", "", false
+	}
+	if name, ok := strings.CutSuffix(p, "/tags/list"); ok {
+		return name, "/tags/list", name != ""
+	}
+	for _, kind := range []string{"/manifests/", "/blobs/", "/referrers/"} {
+		if i := strings.LastIndex(p, kind); i > 0 && !strings.Contains(p[i+len(kind):], "/") {
+			return p[:i], p[i:], true
+		}
+	}
+	return "", "", false
+}
+
+// resolve returns the upstream repository a name through the proxy refers to
+func (p *Proxy) resolve(name string) (oci.Reference, error) {
+	first, repository, _ := strings.Cut(name, "/")
+	if host, ok := p.aliases[first]; ok {
+		// Names through an alias are forwarded as they are, so they get
+		// the checks ParseRepository makes of the others
+		if !oci.ValidRepository(repository) {
+			return oci.Reference{}, fmt.Errorf("invalid repository %q", name)
+		}
+		return oci.Reference{Registry: host, Repository: repository}, nil
+	}
+	repo, err := oci.ParseRepository(name)
+	if err != nil {
+		return oci.Reference{}, err
+	}
+	if repo.Registry == "localhost" {
+		return oci.Reference{}, fmt.Errorf("invalid repository %q: give local registries an alias", name)
+	}
+	return repo, nil
+}
+
+func (p *Proxy) allowed(repo oci.Reference) bool {
+	if len(p.allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.allow {
+		if ok, _ := path.Match(pattern, repo.Name()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteLink points the pagination links of tag lists, which name the
+// upstream repository, back through the proxy
+func rewriteLink(header string, repo oci.Reference, name string) string {
+	parts := strings.Split(header, ",")
+	for i, part := range parts {
+		target, params, _ := strings.Cut(part, ";")
+		target = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(target), "<"), ">")
+		u, err := url.Parse(target)
+		if err != nil {
+			continue
+		}
+		rest, ok := strings.CutPrefix(u.Path, "/v2/"+repo.Repository+"/")
+		if !ok {
+			continue
+		}
+		u.Scheme, u.Host, u.Path = "", "", "/v2/"+name+"/"+rest
+		parts[i] = "<" + u.String() + ">"
+		if params != "" {
+			parts[i] += ";" + params
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeError answers in the distribution API's error format
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string][]oci.RegistryError{
+		"errors": {{Code: code, Message: message}},
+	})
+}
@@ -0,0 +1,261 @@
+package regproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+// upstream is a seeded registry that requires bearer auth and counts the
+// blob requests it gets
+type upstream struct {
+	*ocitest.Registry
+	host  string
+	blobs atomic.Int32
+}
+
+func newUpstream(t *testing.T) *upstream {
+	t.Helper()
+	up := &upstream{Registry: ocitest.New()}
+	up.Seed()
+	up.Username, up.Password, up.Token = "user", "pass", "secret-token"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.Contains(req.URL.Path, "/blobs/") {
+			up.blobs.Add(1)
+		}
+		up.ServeHTTP(w, req)
+	}))
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	up.host = u.Host
+	return up
+}
+
+// startProxy serves a proxy for the upstream, aliased as "up", and returns
+// an anonymous client for it and the proxy's host
+func startProxy(t *testing.T, up *upstream, opts Options) (*oci.Client, string) {
+	t.Helper()
+	opts.Aliases = map[string]string{"up": up.host}
+	opts.PlainHTTP = []string{up.host}
+	if opts.Credentials == nil {
+		opts.Credentials = oci.StaticCredential(oci.Credential{Username: "user", Password: "pass"})
+	}
+	p, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	u, _ := url.Parse(srv.URL)
+	return oci.NewClient(oci.WithPlainHTTP(u.Host)), u.Host
+}
+
+func parse(t *testing.T, s string) oci.Reference {
+	t.Helper()
+	ref, err := oci.ParseReference(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestProxyPull(t *testing.T) {
+	up := newUpstream(t)
+	client, host := startProxy(t, up, Options{})
+	ctx := context.Background()
+
+	if _, err := oci.NewClient(oci.WithPlainHTTP(up.host)).Pull(ctx, parse(t, up.host+"/"+ocitest.HelloRef)); err == nil {
+		t.Fatal("expected an anonymous pull from the upstream to fail")
+	}
+	module, err := client.Pull(ctx, parse(t, host+"/up/"+ocitest.HelloRef))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(module.Data) != string(ocitest.HelloWasm()) {
+		t.Error("pulled the wrong module")
+	}
+
+	// Tag lists are paginated with links that point back through the proxy
+	up.TagPageSize = 1
+	up.PutManifest("wasm/hello", "v2", "application/vnd.oci.image.manifest.v1+json", []byte("{}"))
+	repo, _ := oci.ParseRepository(host + "/up/wasm/hello")
+	tags, err := client.Tags(ctx, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tags, ","); got != "v1,v2" {
+		t.Errorf("got tags %s", got)
+	}
+}
+
+func TestProxyUpstreamDenied(t *testing.T) {
+	up := newUpstream(t)
+	client, host := startProxy(t, up, Options{
+		Credentials: oci.StaticCredential(oci.Credential{Username: "user", Password: "wrong"}),
+	})
+	_, err := client.Pull(context.Background(), parse(t, host+"/up/"+ocitest.HelloRef))
+	var rerr *oci.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected the failure to authenticate upstream to be reported, got %v", err)
+	}
+}
+
+func TestProxyAllow(t *testing.T) {
+	up := newUpstream(t)
+	client, host := startProxy(t, up, Options{Allow: []string{up.host + "/wasm/*"}})
+	ctx := context.Background()
+
+	if _, err := client.Pull(ctx, parse(t, host+"/up/"+ocitest.HelloRef)); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.Pull(ctx, parse(t, host+"/up/"+ocitest.ContainerRef))
+	var rerr *oci.ResponseError
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusForbidden || len(rerr.Errors) == 0 || rerr.Errors[0].Code != "DENIED" {
+		t.Errorf("expected a repository outside the allow list to be denied, got %v", err)
+	}
+	if _, err := client.Push(ctx, parse(t, host+"/up/wasm/new:v1"), ocitest.HelloWasm(), oci.PushOptions{}); err == nil {
+		t.Error("expected pushes through the proxy to be refused")
+	}
+	_, err = client.Pull(ctx, parse(t, host+"/localhost/wasm/hello:v1"))
+	if !errors.As(err, &rerr) || rerr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected localhost to need an alias, got %v", err)
+	}
+}
+
+// Aliased names are checked like any other, whatever the mux in front of the
+// proxy does with the path
+func TestProxyInvalidAliasedName(t *testing.T) {
+	up := newUpstream(t)
+	p, err := New(Options{Aliases: map[string]string{"up": up.host}, PlainHTTP: []string{up.host}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := ocitest.Digest(ocitest.HelloWasm())
+	for _, name := range []string{"up/../wasm/hello", "up/WASM/hello", "up/wasm//hello", "up/"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = "/v2/" + name + "/blobs/" + digest
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "NAME_INVALID") {
+			t.Errorf("%s: expected the name to be refused, got %d %s", name, rec.Code, rec.Body)
+		}
+	}
+	if n := up.blobs.Load(); n != 0 {
+		t.Errorf("expected nothing to be forwarded upstream, got %d requests", n)
+	}
+}
+
+func TestProxyCache(t *testing.T) {
+	up := newUpstream(t)
+	dir := t.TempDir()
+	client, host := startProxy(t, up, Options{CacheDir: dir})
+	ctx := context.Background()
+	ref := parse(t, host+"/up/"+ocitest.HelloRef)
+
+	module, err := client.Pull(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched := up.blobs.Load()
+	if fetched == 0 {
+		t.Fatal("expected the first pull to fetch blobs upstream")
+	}
+	if _, err := client.Pull(ctx, ref); err != nil {
+		t.Fatal(err)
+	}
+	if got := up.blobs.Load(); got != fetched {
+		t.Errorf("expected the second pull to be served from the cache, got %d more blob requests", got-fetched)
+	}
+
+	layer, err := module.Manifest.ModuleLayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sha256", strings.TrimPrefix(layer.Digest, "sha256:"))); err != nil {
+		t.Errorf("expected the module to be cached: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://"+host+"/v2/up/wasm/hello/blobs/"+layer.Digest, nil)
+	req.Header.Set("Range", "bytes=0-3")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "\x00asm" {
+		t.Errorf("expected a range of the cached blob, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newBlobCache(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"first!", "second"} {
+		entry, err := cache.create(ocitest.Digest([]byte(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = entry.Write([]byte(data))
+		if err := entry.commit(); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-time.Hour)
+		_ = os.Chtimes(entry.cache.path(entry.digest), old, old)
+	}
+	if _, err := os.Stat(cache.path(ocitest.Digest([]byte("first!")))); !os.IsNotExist(err) {
+		t.Error("expected the least recently used blob to be evicted")
+	}
+	if _, err := os.Stat(cache.path(ocitest.Digest([]byte("second")))); err != nil {
+		t.Errorf("expected the new blob to be kept: %v", err)
+	}
+
+	entry, _ := cache.create(ocitest.Digest([]byte("other")))
+	_, _ = entry.Write([]byte("tampered"))
+	if err := entry.commit(); err == nil {
+		t.Error("expected a blob that doesn't match its digest to be refused")
+	}
+	entry.abort()
+	if partial, _ := filepath.Glob(filepath.Join(dir, ".blob-*")); len(partial) != 0 {
+		t.Errorf("expected refused blobs to be removed, got %v", partial)
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	for p, want := range map[string][2]string{
+		"/v2/ghcr.io/example/app/manifests/v1":       {"ghcr.io/example/app", "/manifests/v1"},
+		"/v2/library/hello/blobs/sha256:abc":         {"library/hello", "/blobs/sha256:abc"},
+		"/v2/ghcr.io/example/app/tags/list":          {"ghcr.io/example/app", "/tags/list"},
+		"/v2/ghcr.io/example/app/referrers/sha256:1": {"ghcr.io/example/app", "/referrers/sha256:1"},
+		"/v2/example/manifests/app/manifests/v1":     {"example/manifests/app", "/manifests/v1"},
+		"/v2/ghcr.io/example/app/blobs/uploads/":     {"", ""},
+		"/v3/example/manifests/v1":                   {"", ""},
+	} {
+		name, rest, ok := splitPath(p)
+		if ok != (want[0] != "") || name != want[0] || rest != want[1] {
+			t.Errorf("%s: got %q %q %t", p, name, rest, ok)
+		}
+	}
+}
+
+func TestRewriteLink(t *testing.T) {
+	repo := oci.Reference{Registry: "registry.local:5000", Repository: "wasm/hello"}
+	got := rewriteLink(`<https://registry.local:5000/v2/wasm/hello/tags/list?last=v1&n=1>; rel="next"`, repo, "local/wasm/hello")
+	if want := `</v2/local/wasm/hello/tags/list?last=v1&n=1>; rel="next"`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
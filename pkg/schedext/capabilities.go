@@ -0,0 +1,155 @@
+// Package schedext is a kube-scheduler extender that places WebAssembly pods
+// on the krustlet nodes whose runtime can run them.
+//
+// Krustlet nodes advertise what their runtime offers in node annotations, and
+// pods state what their modules need in pod annotations. The extender filters
+// out nodes that lack something a pod needs, and scores the rest by how much
+// memory they allow a module, so mixed clusters don't depend on getting every
+// taint and toleration right.
+package schedext
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Node annotations advertising the runtime's capabilities
+const (
+	// WASISnapshotsAnnotation lists the WASI snapshots the runtime
+	// implements, such as wasi_snapshot_preview1
+	WASISnapshotsAnnotation = "krustlet.dev/wasi-snapshots"
+	// HostFunctionsAnnotation lists the host function modules the runtime
+	// links into every module, such as wasi_experimental_http
+	HostFunctionsAnnotation = "krustlet.dev/host-functions"
+	// MaxMemoryAnnotation is the most linear memory the runtime allows a
+	// module, as a quantity. Nodes without it are taken to have no limit.
+	MaxMemoryAnnotation = "krustlet.dev/max-memory"
+)
+
+// Pod annotations stating what the pod's modules need
+const (
+	// RequiresWASISnapshotAnnotation is the WASI snapshot the modules are
+	// built against
+	RequiresWASISnapshotAnnotation = "krustlet.dev/requires-wasi-snapshot"
+	// RequiresHostFunctionsAnnotation lists the host function modules the
+	// modules import
+	RequiresHostFunctionsAnnotation = "krustlet.dev/requires-host-functions"
+	// RequiresMemoryAnnotation is the linear memory the largest module
+	// needs, as a quantity
+	RequiresMemoryAnnotation = "krustlet.dev/requires-memory"
+)
+
+const (
+	archLabel    = "kubernetes.io/arch"
+	typeLabel    = "type"
+	krustletType = "krustlet"
+)
+
+// Capabilities are what a node's runtime offers
+type Capabilities struct {
+	WASISnapshots []string
+	HostFunctions []string
+	// MaxMemory is nil if the runtime doesn't limit memory
+	MaxMemory *resource.Quantity
+}
+
+// Requirements are what a pod's modules need. The zero value needs nothing.
+type Requirements struct {
+	WASISnapshot  string
+	HostFunctions []string
+	Memory        *resource.Quantity
+}
+
+// Empty reports whether the pod states no requirements, in which case the
+// extender leaves its scheduling alone
+func (r *Requirements) Empty() bool {
+	return r.WASISnapshot == "" && len(r.HostFunctions) == 0 && r.Memory == nil
+}
+
+// IsKrustlet reports whether the node is run by krustlet, from the type label
+// krustlet sets or a WebAssembly architecture
+func IsKrustlet(node *corev1.Node) bool {
+	return node.Labels[typeLabel] == krustletType || strings.HasPrefix(node.Labels[archLabel], "wasm")
+}
+
+// NodeCapabilities reads a node's capabilities from its annotations
+func NodeCapabilities(node *corev1.Node) (*Capabilities, error) {
+	c := &Capabilities{
+		WASISnapshots: splitList(node.Annotations[WASISnapshotsAnnotation]),
+		HostFunctions: splitList(node.Annotations[HostFunctionsAnnotation]),
+	}
+	if v, ok := node.Annotations[MaxMemoryAnnotation]; ok {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", MaxMemoryAnnotation, err)
+		}
+		c.MaxMemory = &q
+	}
+	return c, nil
+}
+
+// PodRequirements reads a pod's requirements from its annotations
+func PodRequirements(pod *corev1.Pod) (*Requirements, error) {
+	r := &Requirements{
+		WASISnapshot:  strings.TrimSpace(pod.Annotations[RequiresWASISnapshotAnnotation]),
+		HostFunctions: splitList(pod.Annotations[RequiresHostFunctionsAnnotation]),
+	}
+	if v, ok := pod.Annotations[RequiresMemoryAnnotation]; ok {
+		q, err := resource.ParseQuantity(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", RequiresMemoryAnnotation, err)
+		}
+		r.Memory = &q
+	}
+	return r, nil
+}
+
+// Check returns why the capabilities don't meet the requirements, nil if
+// they do
+func (c *Capabilities) Check(r *Requirements) error {
+	var missing []string
+	if r.WASISnapshot != "" && !contains(c.WASISnapshots, r.WASISnapshot) {
+		missing = append(missing, fmt.Sprintf("WASI snapshot %s", r.WASISnapshot))
+	}
+	var functions []string
+	for _, f := range r.HostFunctions {
+		if !contains(c.HostFunctions, f) {
+			functions = append(functions, f)
+		}
+	}
+	if len(functions) > 0 {
+		missing = append(missing, "host functions "+strings.Join(functions, ", "))
+	}
+	if r.Memory != nil && c.MaxMemory != nil && r.Memory.Cmp(*c.MaxMemory) > 0 {
+		missing = append(missing, fmt.Sprintf("%s of memory (allows %s)", r.Memory, c.MaxMemory))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("runtime lacks %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// splitList parses a comma separated annotation, dropping empty entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package schedext
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+// Extender serves the scheduler's filter and prioritize calls
+type Extender struct {
+	nodes corelisters.NodeLister
+}
+
+// New returns an extender that looks up nodes with the lister when the
+// scheduler sends only node names, as it does with nodeCacheCapable set
+func New(nodes corelisters.NodeLister) *Extender {
+	return &Extender{nodes: nodes}
+}
+
+// candidates returns the nodes the scheduler asked about, and the names of
+// those that could not be found
+func (e *Extender) candidates(args *extenderv1.ExtenderArgs) ([]*corev1.Node, extenderv1.FailedNodesMap, error) {
+	failed := extenderv1.FailedNodesMap{}
+	if args.Nodes != nil {
+		nodes := make([]*corev1.Node, 0, len(args.Nodes.Items))
+		for i := range args.Nodes.Items {
+			nodes = append(nodes, &args.Nodes.Items[i])
+		}
+		return nodes, failed, nil
+	}
+	if args.NodeNames == nil {
+		return nil, nil, errors.New("no nodes given")
+	}
+	nodes := make([]*corev1.Node, 0, len(*args.NodeNames))
+	for _, name := range *args.NodeNames {
+		node, err := e.nodes.Get(name)
+		if apierrors.IsNotFound(err) {
+			failed[name] = "node not found"
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, failed, nil
+}
+
+// Filter removes the nodes whose runtime can't run the pod. Pods that state
+// no requirements are left alone. Nodes are reported as unresolvable, since
+// preempting other pods won't give a runtime capabilities it lacks.
+func (e *Extender) Filter(args *extenderv1.ExtenderArgs) *extenderv1.ExtenderFilterResult {
+	if args.Pod == nil {
+		return &extenderv1.ExtenderFilterResult{Error: "no pod given"}
+	}
+	reqs, err := PodRequirements(args.Pod)
+	if err != nil {
+		return &extenderv1.ExtenderFilterResult{Error: err.Error()}
+	}
+	nodes, failed, err := e.candidates(args)
+	if err != nil {
+		return &extenderv1.ExtenderFilterResult{Error: err.Error()}
+	}
+
+	unresolvable := extenderv1.FailedNodesMap{}
+	var fit []*corev1.Node
+	for _, node := range nodes {
+		if reason := e.reject(node, reqs); reason != "" {
+			unresolvable[node.Name] = reason
+			continue
+		}
+		fit = append(fit, node)
+	}
+	klog.V(4).InfoS("Filtered nodes", "pod", klog.KObj(args.Pod), "fit", len(fit), "unresolvable", len(unresolvable))
+
+	result := &extenderv1.ExtenderFilterResult{FailedNodes: failed, FailedAndUnresolvableNodes: unresolvable}
+	if args.Nodes != nil {
+		result.Nodes = &corev1.NodeList{}
+		for _, node := range fit {
+			result.Nodes.Items = append(result.Nodes.Items, *node)
+		}
+	} else {
+		names := make([]string, 0, len(fit))
+		for _, node := range fit {
+			names = append(names, node.Name)
+		}
+		result.NodeNames = &names
+	}
+	return result
+}
+
+// reject returns why the node can't run a pod with the requirements, or ""
+// if it can
+func (e *Extender) reject(node *corev1.Node, reqs *Requirements) string {
+	if reqs.Empty() {
+		return ""
+	}
+	if !IsKrustlet(node) {
+		return "not a krustlet node"
+	}
+	caps, err := NodeCapabilities(node)
+	if err != nil {
+		return err.Error()
+	}
+	if err := caps.Check(reqs); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// Prioritize scores krustlet nodes by how much memory their runtime allows a
+// module, so pods land where they have the most headroom. Nodes without a
+// limit get the top score, the rest a share of it in proportion to the
+// largest limit among them. Pods that state no requirements score every node
+// zero.
+func (e *Extender) Prioritize(args *extenderv1.ExtenderArgs) (extenderv1.HostPriorityList, error) {
+	if args.Pod == nil {
+		return nil, errors.New("no pod given")
+	}
+	reqs, err := PodRequirements(args.Pod)
+	if err != nil {
+		return nil, err
+	}
+	nodes, _, err := e.candidates(args)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make([]*Capabilities, len(nodes))
+	var largest int64
+	for i, node := range nodes {
+		if reqs.Empty() || !IsKrustlet(node) {
+			continue
+		}
+		caps, err := NodeCapabilities(node)
+		if err != nil {
+			continue
+		}
+		limits[i] = caps
+		if caps.MaxMemory != nil && caps.MaxMemory.Value() > largest {
+			largest = caps.MaxMemory.Value()
+		}
+	}
+
+	scores := make(extenderv1.HostPriorityList, 0, len(nodes))
+	for i, node := range nodes {
+		var score int64
+		switch caps := limits[i]; {
+		case caps == nil:
+		case caps.MaxMemory == nil:
+			score = extenderv1.MaxExtenderPriority
+		case largest > 0:
+			score = extenderv1.MaxExtenderPriority * caps.MaxMemory.Value() / largest
+		}
+		scores = append(scores, extenderv1.HostPriority{Host: node.Name, Score: score})
+	}
+	return scores, nil
+}
+
+// ServeHTTP answers the scheduler's calls on /filter and /prioritize, the
+// verbs the scheduler configuration should name
+func (e *Extender) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var handle func(*extenderv1.ExtenderArgs) (interface{}, error)
+	switch r.URL.Path {
+	case "/filter":
+		handle = func(args *extenderv1.ExtenderArgs) (interface{}, error) { return e.Filter(args), nil }
+	case "/prioritize":
+		handle = func(args *extenderv1.ExtenderArgs) (interface{}, error) { return e.Prioritize(args) }
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var args extenderv1.ExtenderArgs
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		http.Error(w, fmt.Sprintf("decoding extender args: %v", err), http.StatusBadRequest)
+		return
+	}
+	result, err := handle(&args)
+	if err != nil {
+		klog.ErrorS(err, "Failed to prioritize nodes", "pod", klog.KObj(args.Pod))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.ErrorS(err, "Failed to write extender response")
+	}
+}
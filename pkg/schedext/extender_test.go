@@ -0,0 +1,257 @@
+package schedext
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+)
+
+func testNode(name string, krustlet bool, annotations map[string]string) *corev1.Node {
+	labels := map[string]string{archLabel: "amd64"}
+	if krustlet {
+		labels = map[string]string{archLabel: "wasm32-wasi", typeLabel: krustletType}
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+}
+
+func testPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations}}
+}
+
+func testNodes() []*corev1.Node {
+	return []*corev1.Node{
+		testNode("linux", false, nil),
+		testNode("krustlet-http", true, map[string]string{
+			WASISnapshotsAnnotation: "wasi_snapshot_preview1",
+			HostFunctionsAnnotation: "wasi_experimental_http",
+		}),
+		testNode("krustlet-small", true, map[string]string{
+			WASISnapshotsAnnotation: "wasi_snapshot_preview1",
+			MaxMemoryAnnotation:     "64Mi",
+		}),
+		testNode("krustlet-large", true, map[string]string{
+			WASISnapshotsAnnotation: "wasi_snapshot_preview1",
+			MaxMemoryAnnotation:     "256Mi",
+		}),
+	}
+}
+
+func newTestExtender(t *testing.T, nodes []*corev1.Node) *Extender {
+	t.Helper()
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	informer := factory.Core().V1().Nodes()
+	for _, node := range nodes {
+		if err := informer.Informer().GetIndexer().Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(informer.Lister())
+}
+
+func nodeNames(nodes []*corev1.Node) *[]string {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	return &names
+}
+
+func keys(m extenderv1.FailedNodesMap) []string {
+	var k []string
+	for name := range m {
+		k = append(k, name)
+	}
+	sort.Strings(k)
+	return k
+}
+
+func TestFilter(t *testing.T) {
+	nodes := testNodes()
+	e := newTestExtender(t, nodes)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name: "no requirements",
+			want: []string{"linux", "krustlet-http", "krustlet-small", "krustlet-large"},
+		},
+		{
+			name:        "snapshot",
+			annotations: map[string]string{RequiresWASISnapshotAnnotation: "wasi_snapshot_preview1"},
+			want:        []string{"krustlet-http", "krustlet-small", "krustlet-large"},
+		},
+		{
+			name:        "unknown snapshot",
+			annotations: map[string]string{RequiresWASISnapshotAnnotation: "wasi_snapshot_preview2"},
+		},
+		{
+			name:        "host functions",
+			annotations: map[string]string{RequiresHostFunctionsAnnotation: "wasi_experimental_http"},
+			want:        []string{"krustlet-http"},
+		},
+		{
+			name:        "memory",
+			annotations: map[string]string{RequiresMemoryAnnotation: "128Mi"},
+			want:        []string{"krustlet-http", "krustlet-large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := e.Filter(&extenderv1.ExtenderArgs{Pod: testPod(tt.annotations), NodeNames: nodeNames(nodes)})
+			if result.Error != "" {
+				t.Fatal(result.Error)
+			}
+			got := *result.NodeNames
+			if len(got) == 0 {
+				got = nil
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got nodes %v, want %v", got, tt.want)
+			}
+			if n := len(result.FailedAndUnresolvableNodes) + len(got); n != len(nodes) {
+				t.Errorf("expected every node to fit or fail, got %d of %d", n, len(nodes))
+			}
+		})
+	}
+}
+
+func TestFilterNodeList(t *testing.T) {
+	list := &corev1.NodeList{}
+	for _, node := range testNodes() {
+		list.Items = append(list.Items, *node)
+	}
+	// The lister isn't used when the scheduler sends whole nodes
+	e := newTestExtender(t, nil)
+	pod := testPod(map[string]string{RequiresHostFunctionsAnnotation: "wasi_experimental_http"})
+	result := e.Filter(&extenderv1.ExtenderArgs{Pod: pod, Nodes: list})
+	if result.Error != "" {
+		t.Fatal(result.Error)
+	}
+	if result.NodeNames != nil || len(result.Nodes.Items) != 1 || result.Nodes.Items[0].Name != "krustlet-http" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if got := result.FailedAndUnresolvableNodes["linux"]; got != "not a krustlet node" {
+		t.Errorf("unexpected reason for the linux node %q", got)
+	}
+	if got := result.FailedAndUnresolvableNodes["krustlet-small"]; got != "runtime lacks host functions wasi_experimental_http" {
+		t.Errorf("unexpected reason for krustlet-small %q", got)
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	e := newTestExtender(t, testNodes())
+	names := []string{"krustlet-http", "deleted"}
+	result := e.Filter(&extenderv1.ExtenderArgs{Pod: testPod(nil), NodeNames: &names})
+	if !reflect.DeepEqual(*result.NodeNames, []string{"krustlet-http"}) || !reflect.DeepEqual(keys(result.FailedNodes), []string{"deleted"}) {
+		t.Errorf("expected the deleted node to fail, got %+v", result)
+	}
+
+	pod := testPod(map[string]string{RequiresMemoryAnnotation: "lots"})
+	if result := e.Filter(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: &names}); result.Error == "" {
+		t.Error("expected an invalid memory requirement to fail")
+	}
+
+	bad := testNode("krustlet-bad", true, map[string]string{MaxMemoryAnnotation: "lots"})
+	e = newTestExtender(t, []*corev1.Node{bad})
+	pod = testPod(map[string]string{RequiresMemoryAnnotation: "1Mi"})
+	result = e.Filter(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: &[]string{"krustlet-bad"}})
+	if _, ok := result.FailedAndUnresolvableNodes["krustlet-bad"]; !ok {
+		t.Errorf("expected a node with an invalid annotation to be filtered, got %+v", result)
+	}
+}
+
+func TestPrioritize(t *testing.T) {
+	nodes := testNodes()
+	e := newTestExtender(t, nodes)
+	pod := testPod(map[string]string{RequiresWASISnapshotAnnotation: "wasi_snapshot_preview1"})
+	scores, err := e.Prioritize(&extenderv1.ExtenderArgs{Pod: pod, NodeNames: nodeNames(nodes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := extenderv1.HostPriorityList{
+		{Host: "linux", Score: 0},
+		{Host: "krustlet-http", Score: extenderv1.MaxExtenderPriority},
+		{Host: "krustlet-small", Score: 2},
+		{Host: "krustlet-large", Score: extenderv1.MaxExtenderPriority},
+	}
+	if !reflect.DeepEqual(scores, want) {
+		t.Errorf("got scores %v, want %v", scores, want)
+	}
+
+	scores, err = e.Prioritize(&extenderv1.ExtenderArgs{Pod: testPod(nil), NodeNames: nodeNames(nodes)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range scores {
+		if s.Score != 0 {
+			t.Errorf("expected pods without requirements to score every node zero, got %v", scores)
+		}
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	nodes := testNodes()
+	srv := httptest.NewServer(newTestExtender(t, nodes))
+	defer srv.Close()
+
+	args := extenderv1.ExtenderArgs{
+		Pod:       testPod(map[string]string{RequiresMemoryAnnotation: "128Mi"}),
+		NodeNames: nodeNames(nodes),
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/filter", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result extenderv1.ExtenderFilterResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*result.NodeNames, []string{"krustlet-http", "krustlet-large"}) {
+		t.Errorf("unexpected filter result %+v", result)
+	}
+
+	resp, err = http.Post(srv.URL+"/prioritize", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scores extenderv1.HostPriorityList
+	err = json.NewDecoder(resp.Body).Decode(&scores)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scores) != len(nodes) {
+		t.Errorf("expected a score for every node, got %v", scores)
+	}
+
+	for path, status := range map[string]int{"/filter": http.StatusBadRequest, "/bind": http.StatusNotFound} {
+		resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader([]byte("{")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: got status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
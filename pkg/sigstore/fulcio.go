@@ -0,0 +1,200 @@
+package sigstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Fulcio certificate extensions naming the OIDC issuer of the identity. The
+// first is deprecated but still read, for certificates issued before the
+// second existed.
+var (
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// signingCertificate asks Fulcio to certify the key for the token's
+// identity. It returns the chain Fulcio sent, signing certificate first.
+func signingCertificate(ctx context.Context, client *http.Client, fulcioURL, token string, key *ecdsa.PrivateKey) ([]*x509.Certificate, error) {
+	c, err := parseClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	// Fulcio checks the key is ours from a signature of the identity
+	sum := sha256.Sum256([]byte(c.identity()))
+	proof, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var body struct {
+		Credentials struct {
+			OIDCIdentityToken string `json:"oidcIdentityToken"`
+		} `json:"credentials"`
+		PublicKeyRequest struct {
+			PublicKey struct {
+				Algorithm string `json:"algorithm"`
+				Content   string `json:"content"`
+			} `json:"publicKey"`
+			ProofOfPossession []byte `json:"proofOfPossession"`
+		} `json:"publicKeyRequest"`
+	}
+	body.Credentials.OIDCIdentityToken = token
+	body.PublicKeyRequest.PublicKey.Algorithm = "ECDSA"
+	body.PublicKeyRequest.PublicKey.Content = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	body.PublicKeyRequest.ProofOfPossession = proof
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(fulcioURL, "/")+"/api/v2/signingCert", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	type chain struct {
+		Chain struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chain"`
+	}
+	var resp struct {
+		Embedded *chain `json:"signedCertificateEmbeddedSct"`
+		Detached *chain `json:"signedCertificateDetachedSct"`
+	}
+	if err := doJSON(client, req, &resp, http.StatusOK, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("requesting a signing certificate: %w", err)
+	}
+	got := resp.Embedded
+	if got == nil {
+		got = resp.Detached
+	}
+	if got == nil || len(got.Chain.Certificates) == 0 {
+		return nil, errors.New("requesting a signing certificate: Fulcio returned no certificates")
+	}
+	var certs []*x509.Certificate
+	for _, p := range got.Chain.Certificates {
+		parsed, err := ParseCertificates([]byte(p))
+		if err != nil {
+			return nil, fmt.Errorf("decoding Fulcio's certificates: %w", err)
+		}
+		certs = append(certs, parsed...)
+	}
+	return certs, nil
+}
+
+// ParseCertificates decodes every certificate in PEM data
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+// encodeCertificates returns the certificates in PEM form
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, c := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes()
+}
+
+// certificateIdentity returns the identity a Fulcio certificate was issued
+// to, an email address or a URI such as a CI workflow's, and the issuer of
+// the token it was proved with
+func certificateIdentity(cert *x509.Certificate) (identity, issuer string) {
+	switch {
+	case len(cert.EmailAddresses) > 0:
+		identity = cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		identity = cert.URIs[0].String()
+	}
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidIssuerV2):
+			var s string
+			if _, err := asn1.UnmarshalWithParams(ext.Value, &s, "utf8"); err == nil {
+				return identity, s
+			}
+		case ext.Id.Equal(oidIssuerV1) && issuer == "":
+			issuer = string(ext.Value)
+		}
+	}
+	return identity, issuer
+}
+
+// FetchFulcioRoots downloads the certificate chains the Fulcio instance
+// issues from. Self-signed certificates are returned as roots and the rest
+// as intermediates.
+func FetchFulcioRoots(ctx context.Context, fulcioURL string) (roots, intermediates *x509.CertPool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(fulcioURL, "/")+"/api/v2/trustBundle", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bundle struct {
+		Chains []struct {
+			Certificates []string `json:"certificates"`
+		} `json:"chains"`
+	}
+	if err := doJSON(http.DefaultClient, req, &bundle, http.StatusOK); err != nil {
+		return nil, nil, fmt.Errorf("fetching Fulcio's trust bundle: %w", err)
+	}
+	var certs []*x509.Certificate
+	for _, chain := range bundle.Chains {
+		for _, p := range chain.Certificates {
+			parsed, err := ParseCertificates([]byte(p))
+			if err != nil {
+				return nil, nil, fmt.Errorf("decoding Fulcio's trust bundle: %w", err)
+			}
+			certs = append(certs, parsed...)
+		}
+	}
+	roots, intermediates = SplitChain(certs)
+	return roots, intermediates, nil
+}
+
+// SplitChain sorts certificates into the self-signed roots and the
+// intermediates between them and the certificates they issue
+func SplitChain(certs []*x509.Certificate) (roots, intermediates *x509.CertPool) {
+	roots, intermediates = x509.NewCertPool(), x509.NewCertPool()
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil {
+			roots.AddCert(c)
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	return roots, intermediates
+}
@@ -0,0 +1,274 @@
+package sigstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bundle is the proof a signature was recorded in Rekor, in the form cosign
+// stores in the dev.sigstore.cosign/bundle annotation
+type Bundle struct {
+	// SignedEntryTimestamp is Rekor's signature of the payload
+	SignedEntryTimestamp []byte        `json:"SignedEntryTimestamp"`
+	Payload              BundlePayload `json:"Payload"`
+}
+
+// BundlePayload is the log entry Rekor signed. Rekor signs its canonical
+// JSON, with the keys sorted, which canonical reproduces.
+type BundlePayload struct {
+	// Body is the base64 encoded entry
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogIndex       int64  `json:"logIndex"`
+	// LogID is the hex SHA-256 of the log's public key
+	LogID string `json:"logID"`
+}
+
+// hashedRekord is the Rekor entry kind recording a signature of a digest
+type hashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+	} `json:"spec"`
+}
+
+// newHashedRekord returns the entry for a signature of the payload made with
+// the key in the PEM encoded certificate or public key
+func newHashedRekord(payload, signature, publicKeyPEM []byte) hashedRekord {
+	sum := sha256.Sum256(payload)
+	e := hashedRekord{APIVersion: "0.0.1", Kind: "hashedrekord"}
+	e.Spec.Signature.Content = base64.StdEncoding.EncodeToString(signature)
+	e.Spec.Signature.PublicKey.Content = base64.StdEncoding.EncodeToString(publicKeyPEM)
+	e.Spec.Data.Hash.Algorithm = "sha256"
+	e.Spec.Data.Hash.Value = hex.EncodeToString(sum[:])
+	return e
+}
+
+// uploadEntry records the signature in Rekor and returns the bundle proving
+// it was
+func uploadEntry(ctx context.Context, client *http.Client, rekorURL string, payload, signature, publicKeyPEM []byte) (*Bundle, error) {
+	data, err := json.Marshal(newHashedRekord(payload, signature, publicKeyPEM))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(rekorURL, "/")+"/api/v1/log/entries", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The response maps the entry's UUID to the entry
+	var entries map[string]struct {
+		BundlePayload
+		Verification struct {
+			SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+		} `json:"verification"`
+	}
+	if err := doJSON(client, req, &entries, http.StatusCreated); err != nil {
+		return nil, fmt.Errorf("recording the signature in Rekor: %w", err)
+	}
+	for _, e := range entries {
+		if len(e.Verification.SignedEntryTimestamp) == 0 {
+			return nil, errors.New("recording the signature in Rekor: entry has no signed timestamp")
+		}
+		return &Bundle{SignedEntryTimestamp: e.Verification.SignedEntryTimestamp, Payload: e.BundlePayload}, nil
+	}
+	return nil, errors.New("recording the signature in Rekor: no entry returned")
+}
+
+// canonical returns the bytes Rekor signed for the entry: its RFC 8785
+// canonical JSON, which for these fields is compact JSON with the keys sorted
+// and nothing escaped that doesn't need to be
+func (p BundlePayload) canonical() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// Maps are encoded with their keys sorted
+	err := enc.Encode(map[string]interface{}{
+		"body":           p.Body,
+		"integratedTime": p.IntegratedTime,
+		"logID":          p.LogID,
+		"logIndex":       p.LogIndex,
+	})
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), err
+}
+
+// verifyBundle checks Rekor signed the bundle with one of the keys, and that
+// the entry records the signature of the payload whose SHA-256 is digest,
+// made by the certificate, or by the key if there is no certificate
+func verifyBundle(b *Bundle, keys []crypto.PublicKey, digest, signature []byte, cert *x509.Certificate, key crypto.PublicKey) error {
+	signed, err := b.Payload.canonical()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(signed)
+	verified := false
+	for _, k := range keys {
+		if logID(k) != b.Payload.LogID {
+			continue
+		}
+		if err := verifySignature(k, sum[:], signed, b.SignedEntryTimestamp); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return fmt.Errorf("Rekor entry %d isn't signed by a trusted log", b.Payload.LogIndex)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return fmt.Errorf("decoding Rekor entry: %w", err)
+	}
+	var e hashedRekord
+	if err := json.Unmarshal(body, &e); err != nil {
+		return fmt.Errorf("decoding Rekor entry: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(e.Spec.Signature.Content)
+	if err != nil {
+		return fmt.Errorf("decoding Rekor entry: %w", err)
+	}
+	if e.Kind != "hashedrekord" || e.Spec.Data.Hash.Value != hex.EncodeToString(digest) || !bytes.Equal(sig, signature) {
+		return fmt.Errorf("Rekor entry %d records a different signature", b.Payload.LogIndex)
+	}
+	// The entry's time only vouches for the certificate if the entry
+	// records the signature as made with it
+	signer, err := base64.StdEncoding.DecodeString(e.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return fmt.Errorf("decoding Rekor entry: %w", err)
+	}
+	if !sameSigner(signer, cert, key) {
+		return fmt.Errorf("Rekor entry %d records a signature made with a different key", b.Payload.LogIndex)
+	}
+	return nil
+}
+
+// sameSigner reports whether the PEM encoded certificate or public key of a
+// Rekor entry is the certificate, or the key if there is no certificate
+func sameSigner(data []byte, cert *x509.Certificate, key crypto.PublicKey) bool {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	if cert != nil {
+		return block.Type == "CERTIFICATE" && bytes.Equal(block.Bytes, cert.Raw)
+	}
+	var recorded crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		k, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return false
+		}
+		recorded = k
+	case "CERTIFICATE":
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return false
+		}
+		recorded = c.PublicKey
+	default:
+		return false
+	}
+	k, ok := recorded.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(key)
+}
+
+// integratedTime returns when Rekor recorded the entry
+func (b *Bundle) integratedTime() time.Time {
+	return time.Unix(b.Payload.IntegratedTime, 0)
+}
+
+// FetchRekorPublicKey downloads the key the Rekor instance signs entries
+// with
+func FetchRekorPublicKey(ctx context.Context, rekorURL string) (crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(rekorURL, "/")+"/api/v1/log/publicKey", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching Rekor's public key: unexpected status %d", resp.StatusCode)
+	}
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding Rekor's public key: %w", err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey decodes a PEM encoded public key
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// logID returns the ID of the log that signs with the key
+func logID(key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySignature checks sig is the key's signature of the message whose
+// SHA-256 is digest
+func verifySignature(key crypto.PublicKey, digest, message, sig []byte) error {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, message, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+}
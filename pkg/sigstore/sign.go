@@ -0,0 +1,133 @@
+package sigstore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// Signer signs modules with certificates from Fulcio
+type Signer struct {
+	// Token returns the identity token to sign with
+	Token TokenSource
+	// FulcioURL and RekorURL default to the public instance
+	FulcioURL string
+	RekorURL  string
+	// HTTPClient is used to talk to Fulcio and Rekor, and defaults to
+	// http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Signature describes a signature that was made or verified
+type Signature struct {
+	// Descriptor is the signature manifest in the registry
+	Descriptor oci.Descriptor `json:"descriptor"`
+	// Digest is the manifest digest of the module signed
+	Digest string `json:"digest"`
+	// Identity and Issuer are who signed the module and the OIDC issuer
+	// that vouched for them. They are empty for signatures made with a key.
+	Identity string `json:"identity,omitempty"`
+	Issuer   string `json:"issuer,omitempty"`
+	// LogIndex is the Rekor entry the signature was recorded in, if it was
+	LogIndex *int64 `json:"logIndex,omitempty"`
+}
+
+// Sign signs the module the reference names and pushes the signature to its
+// repository as a referrer of the module's manifest
+func (s *Signer) Sign(ctx context.Context, client *oci.Client, ref oci.Reference) (*Signature, error) {
+	if s.Token == nil {
+		return nil, errors.New("no identity token source configured")
+	}
+	httpClient := s.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	fulcioURL, rekorURL := s.FulcioURL, s.RekorURL
+	if fulcioURL == "" {
+		fulcioURL = DefaultFulcioURL
+	}
+	if rekorURL == "" {
+		rekorURL = DefaultRekorURL
+	}
+
+	// Only modules are signed, so a typo can't sign some other artifact
+	_, subject, err := client.FetchModuleManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getting an identity token: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := signingCertificate(ctx, httpClient, fulcioURL, token, key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := newPayload(ref.Name(), subject.Digest)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	certPEM := encodeCertificates(certs[:1])
+	bundle, err := uploadEntry(ctx, httpClient, rekorURL, data, sig, certPEM)
+	if err != nil {
+		return nil, err
+	}
+	bundleJSON, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadDesc, err := client.PushBlob(ctx, ref, PayloadMediaType, data)
+	if err != nil {
+		return nil, err
+	}
+	payloadDesc.Annotations = map[string]string{
+		AnnotationSignature:   base64.StdEncoding.EncodeToString(sig),
+		AnnotationCertificate: string(certPEM),
+		AnnotationChain:       string(encodeCertificates(certs[1:])),
+		AnnotationBundle:      string(bundleJSON),
+	}
+	empty, err := client.PushBlob(ctx, ref, oci.EmptyMediaType, []byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	desc, err := client.PushReferrer(ctx, ref, oci.Manifest{
+		SchemaVersion: 2,
+		MediaType:     oci.ManifestMediaType,
+		ArtifactType:  ArtifactType,
+		Config:        empty,
+		Layers:        []oci.Descriptor{payloadDesc},
+		Subject:       &oci.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pushing the signature: %w", err)
+	}
+
+	identity, issuer := certificateIdentity(certs[0])
+	return &Signature{
+		Descriptor: desc,
+		Digest:     subject.Digest,
+		Identity:   identity,
+		Issuer:     issuer,
+		LogIndex:   &bundle.Payload.LogIndex,
+	}, nil
+}
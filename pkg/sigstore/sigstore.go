@@ -0,0 +1,91 @@
+// Package sigstore signs wasm modules in OCI registries with Sigstore's
+// keyless flow, and verifies those signatures.
+//
+// Signing proves who published a module without anyone managing keys. The
+// signer gets an OpenID Connect identity token, from a browser login or a CI
+// system. A throwaway key pair is generated, and Fulcio issues a short-lived
+// certificate for it naming the identity. The key signs a payload that names
+// the module's manifest digest, and the signature is recorded in the Rekor
+// transparency log. Rekor's signed timestamp proves the signature was made
+// while the certificate was valid, so it can be checked long after the
+// certificate has expired.
+//
+// Signatures are stored as OCI 1.1 artifacts whose subject is the module's
+// manifest, found through the referrers API, with the payload and
+// annotations cosign uses. Verify also accepts signatures cosign stored under
+// sha256-<hex>.sig tags, and ones made with a key rather than a certificate.
+package sigstore
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The public Sigstore instance, used unless another is configured
+const (
+	DefaultFulcioURL  = "https://fulcio.sigstore.dev"
+	DefaultRekorURL   = "https://rekor.sigstore.dev"
+	DefaultOIDCIssuer = "https://oauth2.sigstore.dev/auth"
+	// DefaultClientID is the OAuth client ID the public instance's issuer
+	// accepts, and the audience Fulcio expects identity tokens to have
+	DefaultClientID = "sigstore"
+)
+
+// How signatures are stored in the registry
+const (
+	// ArtifactType is the artifact type of signature manifests
+	ArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// PayloadMediaType is the media type of the signed payload layer
+	PayloadMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// AnnotationSignature holds the base64 encoded signature of the payload
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+	// AnnotationCertificate holds the PEM encoded signing certificate
+	AnnotationCertificate = "dev.sigstore.cosign/certificate"
+	// AnnotationChain holds the PEM encoded certificates between the signing
+	// certificate and Fulcio's root
+	AnnotationChain = "dev.sigstore.cosign/chain"
+	// AnnotationBundle holds the Rekor entry the signature was recorded in
+	AnnotationBundle = "dev.sigstore.cosign/bundle"
+)
+
+const payloadType = "cosign container image signature"
+
+// payload is the simple signing payload, which names the manifest signed
+type payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]string `json:"optional"`
+}
+
+// newPayload returns the payload signed for the manifest digest in the
+// repository name
+func newPayload(name, digest string) ([]byte, error) {
+	var p payload
+	p.Critical.Identity.DockerReference = name
+	p.Critical.Image.DockerManifestDigest = digest
+	p.Critical.Type = payloadType
+	return json.Marshal(p)
+}
+
+// checkPayload checks the payload names the manifest digest
+func checkPayload(data []byte, digest string) error {
+	var p payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("decoding payload: %w", err)
+	}
+	if p.Critical.Type != payloadType {
+		return fmt.Errorf("payload has type %q, expected %q", p.Critical.Type, payloadType)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("payload signs %s, not %s", p.Critical.Image.DockerManifestDigest, digest)
+	}
+	return nil
+}
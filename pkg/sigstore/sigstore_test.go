@@ -0,0 +1,368 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+const testIssuer = "https://token.actions.githubusercontent.com"
+
+// testRoot is a Fulcio and Rekor standing in for the public instance
+type testRoot struct {
+	caKey   *ecdsa.PrivateKey
+	ca      *x509.Certificate
+	logKey  *ecdsa.PrivateKey
+	entries int64
+}
+
+func newTestRoot(t *testing.T) *testRoot {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	return &testRoot{caKey: caKey, ca: ca, logKey: logKey}
+}
+
+func (r *testRoot) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/api/v2/signingCert":
+		var body struct {
+			Credentials struct {
+				OIDCIdentityToken string `json:"oidcIdentityToken"`
+			} `json:"credentials"`
+			PublicKeyRequest struct {
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+			} `json:"publicKeyRequest"`
+		}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		c, err := parseClaims(body.Credentials.OIDCIdentityToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		key, err := ParsePublicKey([]byte(body.PublicKeyRequest.PublicKey.Content))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		issuer, _ := asn1.MarshalWithParams(c.Issuer, "utf8")
+		tmpl := &x509.Certificate{
+			SerialNumber:    big.NewInt(time.Now().UnixNano()),
+			NotBefore:       time.Now().Add(-time.Minute),
+			NotAfter:        time.Now().Add(10 * time.Minute),
+			KeyUsage:        x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			EmailAddresses:  []string{c.Email},
+			ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuer}},
+		}
+		der, _ := x509.CreateCertificate(rand.Reader, tmpl, r.ca, key, r.caKey)
+		leaf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		root := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.ca.Raw})
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"signedCertificateEmbeddedSct": map[string]interface{}{
+				"chain": map[string]interface{}{"certificates": []string{string(leaf), string(root)}},
+			},
+		})
+	case "/api/v1/log/entries":
+		var e json.RawMessage
+		_ = json.NewDecoder(req.Body).Decode(&e)
+		r.entries++
+		p := BundlePayload{
+			Body:           base64.StdEncoding.EncodeToString(e),
+			IntegratedTime: time.Now().Unix(),
+			LogIndex:       r.entries,
+			LogID:          logID(&r.logKey.PublicKey),
+		}
+		// Rekor signs the entry's canonical JSON, with its keys sorted
+		signed := []byte(fmt.Sprintf(`{"body":%q,"integratedTime":%d,"logID":%q,"logIndex":%d}`, p.Body, p.IntegratedTime, p.LogID, p.LogIndex))
+		sum := sha256.Sum256(signed)
+		set, _ := ecdsa.SignASN1(rand.Reader, r.logKey, sum[:])
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"uuid": map[string]interface{}{
+				"body":           p.Body,
+				"integratedTime": p.IntegratedTime,
+				"logIndex":       p.LogIndex,
+				"logID":          p.LogID,
+				"verification":   map[string]interface{}{"signedEntryTimestamp": set},
+			},
+		})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// testToken returns an unsigned identity token, which the test Fulcio
+// accepts
+func testToken(email string) string {
+	enc := base64.RawURLEncoding
+	c, _ := json.Marshal(claims{Issuer: testIssuer, Subject: email, Email: email})
+	return enc.EncodeToString([]byte(`{"alg":"none"}`)) + "." + enc.EncodeToString(c) + ".sig"
+}
+
+func TestSignAndVerify(t *testing.T) {
+	root := newTestRoot(t)
+	sigstoreSrv := httptest.NewServer(root)
+	defer sigstoreSrv.Close()
+	reg := ocitest.NewServer(t)
+	client := oci.NewClient(oci.WithPlainHTTP(reg.Host()))
+	ctx := context.Background()
+
+	ref, err := oci.ParseReference(reg.Ref("demos/hello:v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	module := append([]byte("\x00asm\x01\x00\x00\x00"), []byte("hello")...)
+	pushed, err := client.Push(ctx, ref, module, oci.PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &Signer{Token: StaticToken(testToken("dev@example.com")), FulcioURL: sigstoreSrv.URL, RekorURL: sigstoreSrv.URL}
+	sig, err := signer.Sign(ctx, client, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.Digest != pushed.Digest || sig.Identity != "dev@example.com" || sig.Issuer != testIssuer || sig.LogIndex == nil {
+		t.Errorf("unexpected signature %+v", sig)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.ca)
+	verifier := &Verifier{
+		Roots:     roots,
+		RekorKeys: []crypto.PublicKey{&root.logKey.PublicKey},
+		Identity:  "dev@example.com",
+		Issuer:    testIssuer,
+	}
+	sigs, err := verifier.Verify(ctx, client, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 || sigs[0].Descriptor.Digest != sig.Descriptor.Digest {
+		t.Errorf("unexpected signatures %+v", sigs)
+	}
+
+	verifier.Identity = ""
+	verifier.IdentityRegexp = regexp.MustCompile(`.*@example\.com`)
+	if _, err := verifier.Verify(ctx, client, ref); err != nil {
+		t.Errorf("expected the identity to match the pattern: %v", err)
+	}
+
+	for name, v := range map[string]*Verifier{
+		"wrong identity": {Roots: roots, RekorKeys: verifier.RekorKeys, Identity: "other@example.com", Issuer: testIssuer},
+		"wrong issuer":   {Roots: roots, RekorKeys: verifier.RekorKeys, Identity: "dev@example.com", Issuer: "https://accounts.google.com"},
+		"untrusted root": {Roots: x509.NewCertPool(), RekorKeys: verifier.RekorKeys, Identity: "dev@example.com", Issuer: testIssuer},
+		"untrusted log":  {Roots: roots, RekorKeys: []crypto.PublicKey{&root.caKey.PublicKey}, Identity: "dev@example.com", Issuer: testIssuer},
+	} {
+		if _, err := v.Verify(ctx, client, ref); !errors.Is(err, ErrNoValidSignature) {
+			t.Errorf("%s: expected ErrNoValidSignature, got %v", name, err)
+		}
+	}
+
+	// A module that was pushed again under the same tag isn't signed
+	if _, err := client.Push(ctx, ref, append(module, '!'), oci.PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(ctx, client, ref); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("expected an unsigned module to fail verification, got %v", err)
+	}
+}
+
+func TestVerifyKeySignature(t *testing.T) {
+	reg := ocitest.NewServer(t)
+	client := oci.NewClient(oci.WithPlainHTTP(reg.Host()))
+	ctx := context.Background()
+	ref, err := oci.ParseReference(reg.Ref("demos/hello:v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := client.Push(ctx, ref, []byte("\x00asm\x01\x00\x00\x00"), oci.PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Store a signature the way cosign sign --key does, under the .sig tag
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	data, _ := newPayload(ref.Name(), pushed.Digest)
+	sum := sha256.Sum256(data)
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	layer, err := client.PushBlob(ctx, ref, PayloadMediaType, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer.Annotations = map[string]string{AnnotationSignature: base64.StdEncoding.EncodeToString(sig)}
+	config, err := client.PushBlob(ctx, ref, "application/vnd.oci.image.config.v1+json", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := json.Marshal(oci.Manifest{SchemaVersion: 2, MediaType: oci.ManifestMediaType, Config: config, Layers: []oci.Descriptor{layer}})
+	tag := ref
+	tag.Tag = oci.ReferrersTag(pushed.Digest) + ".sig"
+	if _, err := client.PushManifest(ctx, tag, oci.ManifestMediaType, m); err != nil {
+		t.Fatal(err)
+	}
+
+	sigs, err := (&Verifier{PublicKey: &key.PublicKey}).Verify(ctx, client, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 1 || sigs[0].Identity != "" {
+		t.Errorf("unexpected signatures %+v", sigs)
+	}
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := (&Verifier{PublicKey: &other.PublicKey}).Verify(ctx, client, ref); !errors.Is(err, ErrNoValidSignature) {
+		t.Errorf("expected another key to fail verification, got %v", err)
+	}
+}
+
+// recordedBundle is a hashedrekord entry recorded by the Rekor of sigstore's
+// scaffolding, from sigstore-go's testdata, with the key the log signs with
+var recordedBundle = Bundle{
+	SignedEntryTimestamp: mustDecodeBase64("MEUCIQDlRe4vCqGTap9Bko4TN9scDU7E7ideUfC51cEwxJJVJwIgBhimuSEUEUTuJ8rISl9UyMZvZp2hi1m7SSDIZM/ZkAA="),
+	Payload: BundlePayload{
+		Body: "eyJhcGlWZXJzaW9uIjoiMC4wLjEiLCJraW5kIjoiaGFzaGVkcmVrb3JkIiwic3BlYyI6eyJkYXRhIjp7Imhhc2giOnsiYWxn" +
+			"b3JpdGhtIjoic2hhMjU2IiwidmFsdWUiOiJiYzEwM2I0YTg0OTcxZWY2NDU5YjI5NGEyYjk4NTY4YTJiZmI3MmNkZWQwOWQ0" +
+			"YWNkMWUxNjM2NmE0MDFmOTViIn19LCJzaWduYXR1cmUiOnsiY29udGVudCI6Ik1FVUNJQ2pKYmY1ZXZRRzBjZUN1SHEvZ1VW" +
+			"eWI4dFU5OHBaaVFudTcxYkRuT2drbUFpRUF0bzZLeTJYQjhPeitab1NQRzRQSjg3cnNUejFkR1h0V3V5LzU4OXZXZlB3PSIs" +
+			"InB1YmxpY0tleSI6eyJjb250ZW50IjoiTFMwdExTMUNSVWRKVGlCRFJWSlVTVVpKUTBGVVJTMHRMUzB0Q2sxSlNVVjBWRU5E" +
+			"UVhBeVowRjNTVUpCWjBsVlVXOHdNRGQ2Y3pCUGFFZFBTemd2UVdOcGF5dGhlR0UzZG1Vd2QwUlJXVXBMYjFwSmFIWmpUa0ZS" +
+			"UlV3S1FsRkJkMlpxUlUxTlFXOUhRVEZWUlVKb1RVUldWazVDVFZKTmQwVlJXVVJXVVZGSlJYZHdSRmxYZUhCYWJUbDVZbTFz" +
+			"YUUxU1dYZEdRVmxFVmxGUlNBcEZkekZVV1ZjMFoxSnVTbWhpYlU1d1l6Sk9kazFTV1hkR1FWbEVWbEZSU2tWM01ERk9SR2Ru" +
+			"VkZkR2VXRXlWakJKUms0d1RWRTBkMFJCV1VSV1VWRlNDa1YzVlRGT2Vra3pUa1JGV2sxQ1kwZEJNVlZGUTJoTlVWUkhiSFZr" +
+			"V0dkblVtMDVNV0p0VW1oa1IyeDJZbXBCWlVaM01IbE9SRUV6VFZSSmVFOVVRVElLVFdwb1lVWjNNSGxPUkVFelRWUkplRTlV" +
+			"UlRKTmFtaGhUVUZCZDFkVVFWUkNaMk54YUd0cVQxQlJTVUpDWjJkeGFHdHFUMUJSVFVKQ2QwNURRVUZSTWdwbVlYTmhUSHBC" +
+			"VVRaT1Z6RkVaVTQwTjJGb1RGRXJORUl2ZVhsclZFNXliRkJPTVV3MEwwWmtNbTQzSzB0b2F6Sk9jREJ6UTA5NmJqRnhNVW96" +
+			"UVRsakNuUlVZVXgzYUcxaFYzZzVPRlpZVm1GNE9YVk9ielJKUW1OcVEwTkJWelIzUkdkWlJGWlNNRkJCVVVndlFrRlJSRUZu" +
+			"WlVGTlFrMUhRVEZWWkVwUlVVMEtUVUZ2UjBORGMwZEJVVlZHUW5kTlJFMUNNRWRCTVZWa1JHZFJWMEpDVVdGMk4zcHBiV28y" +
+			"U1doU1NTOWlSWEoxTjFWT2IxVmtNazFOUkVGbVFtZE9WZ3BJVTAxRlIwUkJWMmRDVTFCRU5YWnNTR0ZZVmsxU1JEUlZiREJZ" +
+			"SzNrdlQwRktSV3czVkVGelFtZE9Wa2hTUlVKQlpqaEZTV3BCWjI5Q05FZERhWE5IQ2tGUlVVSm5OemgzUVZGbFowVkJkMDlh" +
+			"YlRsMlNWYzVjRnBIVFhWaVJ6bHFXVmQzZDBwQldVdExkMWxDUWtGSFJIWjZRVUpCVVZGWFlVaFNNR05FYjNZS1RESTVjRnBI" +
+			"VFhWaVJ6bHFXVmQzTms5RVFUUk5SRUZ0UW1kdmNrSm5SVVZCV1U4dlRVRkZTVUpDWjAxR2JXZ3daRWhCTmt4NU9YWmhWMUpx" +
+			"VEcxNGRncFpNa1p6VDJwbmQwOUVRWGRuV1c5SFEybHpSMEZSVVVJeGJtdERRa0ZKUldaQlVqWkJTR2RCWkdkRVpYTklSRmxJ" +
+			"ZW10NVVGTkhUVFI2WlVkd2MxQnFDbWt3SzBacmRXODFTell3TVVSM1VrcFZWMUZFV0VGQlFVRmFRMjlXZGtkNFFVRkJSVUYz" +
+			"UWtoTlJWVkRTVVk0UzBGVWJrZFNMMEV3VFRBd2QyVkhXVWtLVTI1TGJFMUlkU3N2VUZGUVRGaDFOM2xQTUVjeWFYUm1RV2xG" +
+			"UVRKck1rSkhPVWg2WkhBeVFXTm5kbVZ5YUc1elpXZHVXSGhxUzA1UE5VWk9kRzUzVndvdmFtNVBTVzgwZDBSUldVcExiMXBK" +
+			"YUhaalRrRlJSVXhDVVVGRVoyZEpRa0ZIVDBSbEwzWlFVSHBFZUdGeWIwaHNTVzB2TW5WSGIwRnNOMkV2WVZkS0NscDJhbTlp" +
+			"WnpkaE9WRnhVMDAwTTI1R2FIQnlVa1l6UXpVeE9HcEJWRkI0YlhweU1IaDZiVVJOVDJOSk5pdGhWREZsZWtzMmNFSlNTelZW" +
+			"TDNaWksyMEtUSHBaU0hoQ1p6bERZMEpFWkRaQk9HMVBiRGc1VVc0eGVEWmhkMU5ZYjNFck0wUTVOVEJGZDNjemRraG1SVXBW" +
+			"VXpWblFVWm1SREJUUlRreFdUbE1OZ3BtVGpGMU9WWjZabU5DTWpkelZFaG1ibVpEYXpjNGFWRm1LM05CTUV0WFlWUkdaMlZy" +
+			"UTFSclYyVjBVRGs0TXpsbFptTlJielY0V1RWS2EzaElla05YQ25oTFJITmFjbHB4U0RObmIwZElRM0ZrU1V3NU0yY3dObEZN" +
+			"U2tsSWNVOUlNM3AwVFhabWExbGlURzFXZFZSV01sSnBlWE5rV1Zab1JEWnpTbEpzUlVzS2VXbFlkR0ZZZDNSb2NXUmljMmRp" +
+			"YVV0RU9HZFNiVkZTU21seU9UWXhVRzk0VkV0clUzWklhR1JoWmxadFZsVlpkR3RYVHpaM1VUazRVSGR0VDFrd1VBcHZhaXN6" +
+			"ZWxkdlQwRnpibnB4Y2pCcWQwWnVPRkZXVG1SbFYwdHNSRzE2V0hGa1dHNDFZVUp2V0VKd2FHeFJlUzlxTW5VeFZGZHpiRGhJ" +
+			"WXpkS1RDdElDbWh0VmpOSGFIRlNZbWhFTXpGWGVGWkJVWEZwTUhCdlN6ZHBaek5hUWl0eE16WlVXSFpsYzIxTVJWZGxia2xE" +
+			"Y0d4WWMyTlZlVEpNY2pNNVF6VnpRbVVLYVV4M1RITmxNMkZoV0hObE9UVlpTSEZLYTFsblVEUTBZMU16TXk5dGJWUnRlVEpE" +
+			"TVVaak5GQjFNREZoYTFWb1RIZzJPUzl6WjB4SVV5OHpSeklyVlFweFowYzRibk5zZWpKT04ydzNVMVZZWVhRMFJHcHhaV014" +
+			"V0ZGMmIxZEhMMlkzYTFWaWJqTXJaSFF3VGpoMmRqUlpTRlp4Vm5saFZ6ZFJhMWhqVURab0NubHFibFE0WTJodGFuTnhRMU5E" +
+			"ZVRoTFYzTm5lSEl3Y0hGd1RFTnljblZ0YkZOclpURkNTa2RNTkVWYWJUQm9VMFIyY21nd1pHaHhWR2R5YjNNNFIxb0tjMWx4" +
+			"T0VGS1FrRkJiWEZxQ2kwdExTMHRSVTVFSUVORlVsUkpSa2xEUVZSRkxTMHRMUzBLIn19fX0=",
+		IntegratedTime: 1720811189,
+		LogIndex:       3,
+		LogID:          "f6fb357e481d95b94fc8cb9688b44041b120d219831c4e94c02f76571c8b4bc8",
+	},
+}
+
+const recordedLogKey = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEnPyeVMLRWPJQpCHcUdG41k+oJiQE
+jX4uGSX7ujPH7Iv5zQD3VYiHhyQ/oMJvc1vx+2Zk2DBcBhN9IT0eZjB2RQ==
+-----END PUBLIC KEY-----
+`
+
+func mustDecodeBase64(s string) []byte {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestVerifyRecordedBundle(t *testing.T) {
+	logKey, err := ParsePublicKey([]byte(recordedLogKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(mustDecodeBase64(recordedBundle.Payload.Body), &entry); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseCertificates(mustDecodeBase64(entry.Spec.Signature.PublicKey.Content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, _ := hex.DecodeString(entry.Spec.Data.Hash.Value)
+	sig := mustDecodeBase64(entry.Spec.Signature.Content)
+
+	b := recordedBundle
+	if err := verifyBundle(&b, []crypto.PublicKey{logKey}, digest, sig, certs[0], nil); err != nil {
+		t.Fatalf("expected the recorded bundle to verify: %v", err)
+	}
+
+	// A bundle decoded from cosign's annotation verifies the same way
+	data, _ := json.Marshal(recordedBundle)
+	var decoded Bundle
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBundle(&decoded, []crypto.PublicKey{logKey}, digest, sig, certs[0], nil); err != nil {
+		t.Errorf("expected the decoded bundle to verify: %v", err)
+	}
+
+	b.Payload.IntegratedTime++
+	if err := verifyBundle(&b, []crypto.PublicKey{logKey}, digest, sig, certs[0], nil); err == nil {
+		t.Error("expected a bundle with a changed time to be rejected")
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &other.PublicKey, other)
+	otherCert, _ := x509.ParseCertificate(der)
+	b = recordedBundle
+	if err := verifyBundle(&b, []crypto.PublicKey{logKey}, digest, sig, otherCert, nil); err == nil {
+		t.Error("expected an entry recording another certificate to be rejected")
+	}
+	if err := verifyBundle(&b, []crypto.PublicKey{logKey}, digest, sig, nil, certs[0].PublicKey); err != nil {
+		t.Errorf("expected an entry to match the key of the certificate it records: %v", err)
+	}
+	if err := verifyBundle(&b, []crypto.PublicKey{logKey}, digest, sig, nil, &other.PublicKey); err == nil {
+		t.Error("expected an entry recording another key to be rejected")
+	}
+}
@@ -0,0 +1,247 @@
+package sigstore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// TokenSource returns the OIDC identity token to sign with. Fulcio checks the
+// token and names its identity in the certificate.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken returns a TokenSource for a token obtained some other way
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) { return token, nil }
+}
+
+// GitHubActionsToken returns a TokenSource that requests a token for the
+// workflow from GitHub Actions, and whether the environment has what that
+// needs. The workflow needs the id-token: write permission.
+func GitHubActionsToken(audience string) (TokenSource, bool) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return nil, false
+	}
+	return func(ctx context.Context) (string, error) {
+		u, err := url.Parse(requestURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid ACTIONS_ID_TOKEN_REQUEST_URL: %w", err)
+		}
+		q := u.Query()
+		q.Set("audience", audience)
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+requestToken)
+		var body struct {
+			Value string `json:"value"`
+		}
+		if err := doJSON(http.DefaultClient, req, &body, http.StatusOK); err != nil {
+			return "", fmt.Errorf("requesting GitHub Actions identity token: %w", err)
+		}
+		return body.Value, nil
+	}, true
+}
+
+// InteractiveToken returns a TokenSource that signs in through a browser. It
+// calls prompt with the URL to open, and waits for the issuer to redirect
+// back to a listener on localhost with an authorization code, which it
+// exchanges for a token. The code is bound to this process with PKCE.
+func InteractiveToken(issuer, clientID string, prompt func(authURL string)) TokenSource {
+	return func(ctx context.Context) (string, error) {
+		endpoints, err := discover(ctx, issuer)
+		if err != nil {
+			return "", err
+		}
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			return "", fmt.Errorf("listening for the sign-in redirect: %w", err)
+		}
+		defer l.Close()
+		redirectURI := fmt.Sprintf("http://localhost:%d/auth/callback", l.Addr().(*net.TCPAddr).Port)
+		state, nonce, verifier := randomString(), randomString(), randomString()
+		challenge := sha256.Sum256([]byte(verifier))
+
+		authURL, err := url.Parse(endpoints.Authorization)
+		if err != nil {
+			return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+		}
+		q := authURL.Query()
+		q.Set("response_type", "code")
+		q.Set("client_id", clientID)
+		q.Set("redirect_uri", redirectURI)
+		q.Set("scope", "openid email")
+		q.Set("state", state)
+		q.Set("nonce", nonce)
+		q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+		q.Set("code_challenge_method", "S256")
+		authURL.RawQuery = q.Encode()
+
+		codes := make(chan string, 1)
+		errs := make(chan error, 1)
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path != "/auth/callback" {
+				http.NotFound(w, req)
+				return
+			}
+			q := req.URL.Query()
+			switch {
+			case q.Get("state") != state:
+				http.Error(w, "invalid state", http.StatusBadRequest)
+				return
+			case q.Get("error") != "":
+				select {
+				case errs <- fmt.Errorf("sign-in failed: %s: %s", q.Get("error"), q.Get("error_description")):
+				default:
+				}
+				http.Error(w, "Sign-in failed, return to the terminal for details.", http.StatusBadRequest)
+				return
+			}
+			select {
+			case codes <- q.Get("code"):
+			default:
+			}
+			fmt.Fprintln(w, "Signed in. You can close this window.")
+		})}
+		go func() { _ = srv.Serve(l) }()
+		defer srv.Close()
+
+		prompt(authURL.String())
+		var code string
+		select {
+		case code = <-codes:
+		case err := <-errs:
+			return "", err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"client_id":     {clientID},
+			"code_verifier": {verifier},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.Token, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		var body struct {
+			IDToken string `json:"id_token"`
+		}
+		if err := doJSON(http.DefaultClient, req, &body, http.StatusOK); err != nil {
+			return "", fmt.Errorf("exchanging the authorization code: %w", err)
+		}
+		claims, err := parseClaims(body.IDToken)
+		if err != nil {
+			return "", err
+		}
+		if claims.Nonce != nonce {
+			return "", errors.New("identity token has the wrong nonce")
+		}
+		return body.IDToken, nil
+	}
+}
+
+type oidcEndpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+}
+
+// discover looks up the issuer's endpoints from its discovery document
+func discover(ctx context.Context, issuer string) (*oidcEndpoints, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var e oidcEndpoints
+	if err := doJSON(http.DefaultClient, req, &e, http.StatusOK); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", issuer, err)
+	}
+	if e.Authorization == "" || e.Token == "" {
+		return nil, fmt.Errorf("discovering %s: no authorization or token endpoint", issuer)
+	}
+	return &e, nil
+}
+
+// claims are the identity token claims the signer needs
+type claims struct {
+	Issuer  string `json:"iss"`
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+	Nonce   string `json:"nonce"`
+}
+
+// parseClaims decodes the claims of a JWT. The token is not verified; that
+// is Fulcio's job.
+func parseClaims(token string) (*claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("identity token is not a JWT")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding identity token: %w", err)
+	}
+	var c claims
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("decoding identity token: %w", err)
+	}
+	return &c, nil
+}
+
+// identity returns what Fulcio names in the certificate: the email address
+// for tokens that have one, and the subject otherwise
+func (c *claims) identity() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Subject
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("sigstore: reading random bytes: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// doJSON sends the request and decodes a JSON response with one of the
+// expected statuses into v
+func doJSON(client *http.Client, req *http.Request, v interface{}, statuses ...int) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(statuses, resp.StatusCode) {
+		return fmt.Errorf("%s %s: unexpected status %d: %s", req.Method, req.URL.Redacted(), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s %s: decoding response: %w", req.Method, req.URL.Redacted(), err)
+	}
+	return nil
+}
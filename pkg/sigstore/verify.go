@@ -0,0 +1,231 @@
+package sigstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/krustlet/krustlet/pkg/oci"
+)
+
+// ErrNoValidSignature is returned by Verify when a module has no signature
+// that passes verification, including when it has no signatures at all
+var ErrNoValidSignature = errors.New("no valid signature")
+
+// Verifier checks signatures of modules. Keyless signatures must chain to
+// Roots, carry a Rekor bundle signed by one of RekorKeys, and name an identity
+// and issuer the verifier accepts. Signatures made with a key are checked
+// against PublicKey instead, and only need a bundle if RekorKeys is set.
+type Verifier struct {
+	// Roots and Intermediates are Fulcio's certificates
+	Roots         *x509.CertPool
+	Intermediates *x509.CertPool
+	// RekorKeys are the keys of the transparency logs trusted
+	RekorKeys []crypto.PublicKey
+
+	// Identity is the identity signatures must have been issued to, or
+	// IdentityRegexp a pattern it must fully match
+	Identity       string
+	IdentityRegexp *regexp.Regexp
+	// Issuer is the OIDC issuer that must have vouched for the identity
+	Issuer string
+
+	// PublicKey verifies signatures made with a key rather than a certificate
+	PublicKey crypto.PublicKey
+}
+
+// signatureLayer is a signature found in the registry, not yet verified
+type signatureLayer struct {
+	manifest oci.Descriptor
+	layer    oci.Descriptor
+}
+
+// Verify checks the module the reference names has at least one valid
+// signature and returns the ones that are. Signatures are looked up as
+// referrers of the module's manifest and under cosign's sha256-<hex>.sig tag.
+func (v *Verifier) Verify(ctx context.Context, client *oci.Client, ref oci.Reference) ([]Signature, error) {
+	if v.PublicKey == nil {
+		if v.Roots == nil || len(v.RekorKeys) == 0 {
+			return nil, errors.New("keyless verification needs Fulcio's roots and Rekor's public key")
+		}
+		if (v.Identity == "" && v.IdentityRegexp == nil) || v.Issuer == "" {
+			return nil, errors.New("keyless verification needs the identity and issuer to expect")
+		}
+	}
+	_, subject, err := client.FetchModuleManifest(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	found, err := findSignatures(ctx, client, ref.WithDigest(subject.Digest))
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%s: %w: the module isn't signed", ref, ErrNoValidSignature)
+	}
+
+	var valid []Signature
+	var reasons []string
+	for _, f := range found {
+		data, err := client.FetchBlob(ctx, ref, f.layer)
+		if err != nil {
+			return nil, fmt.Errorf("fetching signature %s: %w", f.manifest.Digest, err)
+		}
+		sig, err := v.verifyLayer(f.layer, data, subject.Digest)
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: %v", f.manifest.Digest, err))
+			continue
+		}
+		sig.Descriptor = f.manifest
+		valid = append(valid, *sig)
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("%s: %w: %s", ref, ErrNoValidSignature, strings.Join(reasons, "; "))
+	}
+	return valid, nil
+}
+
+// findSignatures lists the signature layers for the manifest the reference's
+// digest names
+func findSignatures(ctx context.Context, client *oci.Client, subject oci.Reference) ([]signatureLayer, error) {
+	manifests, err := client.Referrers(ctx, subject, ArtifactType)
+	if err != nil {
+		return nil, fmt.Errorf("listing signatures: %w", err)
+	}
+	legacy := oci.Reference{Registry: subject.Registry, Repository: subject.Repository, Tag: oci.ReferrersTag(subject.Digest) + ".sig"}
+	if _, desc, err := client.FetchManifest(ctx, legacy); err == nil {
+		manifests = append(manifests, desc)
+	} else if !errors.Is(err, oci.ErrNotFound) {
+		return nil, fmt.Errorf("fetching %s: %w", legacy.Tag, err)
+	}
+
+	var out []signatureLayer
+	for _, d := range manifests {
+		data, _, err := client.FetchManifest(ctx, subject.WithDigest(d.Digest))
+		if err != nil {
+			return nil, fmt.Errorf("fetching signature %s: %w", d.Digest, err)
+		}
+		var m oci.Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("decoding signature %s: %w", d.Digest, err)
+		}
+		for _, l := range m.Layers {
+			if l.MediaType == PayloadMediaType {
+				out = append(out, signatureLayer{manifest: d, layer: l})
+			}
+		}
+	}
+	return out, nil
+}
+
+// verifyLayer checks one signature of the payload in data
+func (v *Verifier) verifyLayer(layer oci.Descriptor, data []byte, digest string) (*Signature, error) {
+	if err := checkPayload(data, digest); err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(layer.Annotations[AnnotationSignature])
+	if err != nil || len(sig) == 0 {
+		return nil, errors.New("no signature annotation")
+	}
+	var bundle *Bundle
+	if b := layer.Annotations[AnnotationBundle]; b != "" {
+		bundle = &Bundle{}
+		if err := json.Unmarshal([]byte(b), bundle); err != nil {
+			return nil, fmt.Errorf("decoding bundle: %w", err)
+		}
+	}
+	sum := sha256.Sum256(data)
+	out := &Signature{Digest: digest}
+	if bundle != nil {
+		out.LogIndex = &bundle.Payload.LogIndex
+	}
+
+	certPEM := layer.Annotations[AnnotationCertificate]
+	if certPEM == "" || v.PublicKey != nil {
+		if v.PublicKey == nil {
+			return nil, errors.New("signed with a key, and no public key was given")
+		}
+		if err := verifySignature(v.PublicKey, sum[:], data, sig); err != nil {
+			return nil, err
+		}
+		if len(v.RekorKeys) > 0 {
+			if bundle == nil {
+				return nil, errors.New("not recorded in Rekor")
+			}
+			if err := verifyBundle(bundle, v.RekorKeys, sum[:], sig, nil, v.PublicKey); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	certs, err := ParseCertificates([]byte(certPEM))
+	if err != nil {
+		return nil, fmt.Errorf("decoding certificate: %w", err)
+	}
+	cert := certs[0]
+	// The certificate has expired by now, so the chain is checked at the time
+	// Rekor proves the signature was made
+	if bundle == nil {
+		return nil, errors.New("not recorded in Rekor")
+	}
+	if err := verifyBundle(bundle, v.RekorKeys, sum[:], sig, cert, nil); err != nil {
+		return nil, err
+	}
+	signed := bundle.integratedTime()
+	if signed.Before(cert.NotBefore) || signed.After(cert.NotAfter) {
+		return nil, fmt.Errorf("recorded at %s, outside the certificate's validity", signed.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	intermediates := x509.NewCertPool()
+	if v.Intermediates != nil {
+		intermediates = v.Intermediates.Clone()
+	}
+	if chain := layer.Annotations[AnnotationChain]; chain != "" {
+		if extra, err := ParseCertificates([]byte(chain)); err == nil {
+			for _, c := range extra {
+				intermediates.AddCert(c)
+			}
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.Roots,
+		Intermediates: intermediates,
+		CurrentTime:   signed,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("certificate isn't issued by a trusted Fulcio: %w", err)
+	}
+	if err := verifySignature(cert.PublicKey, sum[:], data, sig); err != nil {
+		return nil, err
+	}
+
+	out.Identity, out.Issuer = certificateIdentity(cert)
+	if err := v.checkIdentity(out.Identity, out.Issuer); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// checkIdentity checks the certificate was issued to an identity the
+// verifier accepts
+func (v *Verifier) checkIdentity(identity, issuer string) error {
+	if issuer != v.Issuer {
+		return fmt.Errorf("identity issued by %q, expected %q", issuer, v.Issuer)
+	}
+	if v.Identity != "" && identity != v.Identity {
+		return fmt.Errorf("signed by %q, expected %q", identity, v.Identity)
+	}
+	if v.IdentityRegexp != nil {
+		if loc := v.IdentityRegexp.FindStringIndex(identity); loc == nil || loc[0] != 0 || loc[1] != len(identity) {
+			return fmt.Errorf("signed by %q, which doesn't match %q", identity, v.IdentityRegexp)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package statsshim
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// authCacheTTL is how long a caller that was allowed is remembered, so a
+// metrics-server scraping every few seconds doesn't cost two API calls a
+// scrape
+const authCacheTTL = time.Minute
+
+// Auth checks callers of the stats endpoints the way the kubelet does with
+// webhook authentication and authorization: a bearer token is checked with a
+// TokenReview and a client certificate against the client CA, and the caller
+// must be allowed to get the node's stats or metrics subresource. The node's
+// own credentials may create both reviews.
+type Auth struct {
+	client   kubernetes.Interface
+	nodeName string
+	clientCA *x509.CertPool
+	now      func() time.Time
+
+	mu      sync.Mutex
+	allowed map[string]time.Time
+}
+
+// NewAuth returns an Auth for the node. Client certificates are only
+// accepted if clientCA is set.
+func NewAuth(client kubernetes.Interface, nodeName string, clientCA *x509.CertPool) *Auth {
+	return &Auth{
+		client:   client,
+		nodeName: nodeName,
+		clientCA: clientCA,
+		now:      time.Now,
+		allowed:  map[string]time.Time{},
+	}
+}
+
+// wrap only passes requests the caller may make to the handler. subresource
+// is the node subresource the handler serves.
+func (a *Auth) wrap(subresource string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := credentials(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		verb := verbFor(r.Method)
+		key += "|" + subresource + "|" + verb
+		if !a.cached(key) {
+			ctx := r.Context()
+			user, ok := a.authenticate(ctx, r)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !a.authorize(ctx, user, subresource, verb) {
+				http.Error(w, "Forbidden (user="+user.Username+", verb="+verb+", resource=nodes, subresource="+subresource+")", http.StatusForbidden)
+				return
+			}
+			a.remember(key)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// credentials returns a key identifying the credentials the caller
+// presented, a bearer token or a client certificate
+func credentials(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:]), true
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		return "cert:" + hex.EncodeToString(sum[:]), true
+	}
+	return "", false
+}
+
+// authenticate returns who the caller is
+func (a *Auth) authenticate(ctx context.Context, r *http.Request) (authenticationv1.UserInfo, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		review, err := a.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			klog.ErrorS(err, "Reviewing token")
+			return authenticationv1.UserInfo{}, false
+		}
+		return review.Status.User, review.Status.Authenticated
+	}
+	if a.clientCA == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return authenticationv1.UserInfo{}, false
+	}
+	certs := r.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         a.clientCA,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		klog.V(2).InfoS("Rejecting client certificate", "subject", certs[0].Subject.String(), "err", err)
+		return authenticationv1.UserInfo{}, false
+	}
+	return authenticationv1.UserInfo{Username: certs[0].Subject.CommonName, Groups: certs[0].Subject.Organization}, true
+}
+
+func (a *Auth) authorize(ctx context.Context, user authenticationv1.UserInfo, subresource, verb string) bool {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb:        verb,
+				Resource:    "nodes",
+				Subresource: subresource,
+				Name:        a.nodeName,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		klog.ErrorS(err, "Reviewing access", "user", user.Username)
+		return false
+	}
+	return review.Status.Allowed
+}
+
+func (a *Auth) cached(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	until, ok := a.allowed[key]
+	return ok && a.now().Before(until)
+}
+
+func (a *Auth) remember(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for k, until := range a.allowed {
+		if !now.Before(until) {
+			delete(a.allowed, k)
+		}
+	}
+	a.allowed[key] = now.Add(authCacheTTL)
+}
+
+// verbFor maps a request method to an API verb as the kubelet does
+func verbFor(method string) string {
+	switch method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	default:
+		return "get"
+	}
+}
@@ -0,0 +1,24 @@
+package statsshim
+
+import "syscall"
+
+// fsUsage is the space and inodes of the filesystem holding a path
+type fsUsage struct {
+	capacity, available, used uint64
+	inodes, inodesFree        uint64
+}
+
+func statfs(path string) (fsUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return fsUsage{}, err
+	}
+	bsize := uint64(st.Bsize)
+	return fsUsage{
+		capacity:   st.Blocks * bsize,
+		available:  st.Bavail * bsize,
+		used:       (st.Blocks - st.Bfree) * bsize,
+		inodes:     st.Files,
+		inodesFree: st.Ffree,
+	}, nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package statsshim
+
+import "errors"
+
+// fsUsage is the space and inodes of the filesystem holding a path
+type fsUsage struct {
+	capacity, available, used uint64
+	inodes, inodesFree        uint64
+}
+
+// statfs is only supported on Linux, the only platform module stats are
+// recorded on
+func statfs(string) (fsUsage, error) {
+	return fsUsage{}, errors.New("filesystem stats are only supported on Linux")
+}
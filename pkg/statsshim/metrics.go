@@ -0,0 +1,90 @@
+package statsshim
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+// The kubelet's resource metrics, which metrics-server scrapes from
+// /metrics/resource
+var (
+	nodeCPUDesc = prometheus.NewDesc("node_cpu_usage_seconds_total",
+		"Cumulative cpu time consumed by the node in core-seconds",
+		nil, nil)
+	nodeMemoryDesc = prometheus.NewDesc("node_memory_working_set_bytes",
+		"Current working set of the node in bytes",
+		nil, nil)
+	containerCPUDesc = prometheus.NewDesc("container_cpu_usage_seconds_total",
+		"Cumulative cpu time consumed by the container in core-seconds",
+		[]string{"container", "pod", "namespace"}, nil)
+	containerMemoryDesc = prometheus.NewDesc("container_memory_working_set_bytes",
+		"Current working set of the container in bytes",
+		[]string{"container", "pod", "namespace"}, nil)
+	containerStartDesc = prometheus.NewDesc("container_start_time_seconds",
+		"Start time of the container since unix epoch in seconds",
+		[]string{"container", "pod", "namespace"}, nil)
+	podCPUDesc = prometheus.NewDesc("pod_cpu_usage_seconds_total",
+		"Cumulative cpu time consumed by the pod in core-seconds",
+		[]string{"pod", "namespace"}, nil)
+	podMemoryDesc = prometheus.NewDesc("pod_memory_working_set_bytes",
+		"Current working set of the pod in bytes",
+		[]string{"pod", "namespace"}, nil)
+	scrapeErrorDesc = prometheus.NewDesc("scrape_error",
+		"1 if there was an error while getting container metrics, 0 otherwise",
+		nil, nil)
+)
+
+// resourceMetrics is a prometheus.Collector for the provider's stats in the
+// shape of the kubelet's resource metrics
+type resourceMetrics struct {
+	provider *Provider
+}
+
+// Describe implements prometheus.Collector
+func (r resourceMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{nodeCPUDesc, nodeMemoryDesc, containerCPUDesc, containerMemoryDesc, containerStartDesc, podCPUDesc, podMemoryDesc, scrapeErrorDesc} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (r resourceMetrics) Collect(ch chan<- prometheus.Metric) {
+	summary, err := r.provider.Summary()
+	if err != nil {
+		klog.ErrorS(err, "Gathering stats")
+		ch <- prometheus.MustNewConstMetric(scrapeErrorDesc, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeErrorDesc, prometheus.GaugeValue, 0)
+
+	node := summary.Node
+	if node.CPU != nil {
+		ch <- timestamped(node.CPU.Time.Time, prometheus.MustNewConstMetric(nodeCPUDesc, prometheus.CounterValue, seconds(*node.CPU.UsageCoreNanoSeconds)))
+	}
+	if node.Memory != nil {
+		ch <- timestamped(node.Memory.Time.Time, prometheus.MustNewConstMetric(nodeMemoryDesc, prometheus.GaugeValue, float64(*node.Memory.WorkingSetBytes)))
+	}
+	for _, pod := range summary.Pods {
+		ref := pod.PodRef
+		for _, c := range pod.Containers {
+			ch <- prometheus.MustNewConstMetric(containerStartDesc, prometheus.GaugeValue, float64(c.StartTime.Unix()), c.Name, ref.Name, ref.Namespace)
+			ch <- timestamped(c.CPU.Time.Time, prometheus.MustNewConstMetric(containerCPUDesc, prometheus.CounterValue, seconds(*c.CPU.UsageCoreNanoSeconds), c.Name, ref.Name, ref.Namespace))
+			ch <- timestamped(c.Memory.Time.Time, prometheus.MustNewConstMetric(containerMemoryDesc, prometheus.GaugeValue, float64(*c.Memory.WorkingSetBytes), c.Name, ref.Name, ref.Namespace))
+		}
+		ch <- timestamped(pod.CPU.Time.Time, prometheus.MustNewConstMetric(podCPUDesc, prometheus.CounterValue, seconds(*pod.CPU.UsageCoreNanoSeconds), ref.Name, ref.Namespace))
+		ch <- timestamped(pod.Memory.Time.Time, prometheus.MustNewConstMetric(podMemoryDesc, prometheus.GaugeValue, float64(*pod.Memory.WorkingSetBytes), ref.Name, ref.Namespace))
+	}
+}
+
+// timestamped sets when a sample was taken, as the kubelet does, so
+// metrics-server computes rates from when usage was read rather than
+// scraped
+func timestamped(t time.Time, m prometheus.Metric) prometheus.Metric {
+	return prometheus.NewMetricWithTimestamp(t, m)
+}
+
+func seconds(nanos uint64) float64 {
+	return float64(nanos) / float64(time.Second)
+}
@@ -0,0 +1,72 @@
+package statsshim
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// statsFileSuffix ends the name of the stats file krustlet's wasi provider
+// writes next to a container's <name>.log while its module runs
+const statsFileSuffix = ".stats.json"
+
+// Module is a running module, as recorded in its stats file
+type Module struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	// PID is krustlet's process and TID the thread running the module
+	PID int `json:"pid"`
+	TID int `json:"tid"`
+	// MemoryAddress is where the module's linear memory starts, nil if it
+	// has none
+	MemoryAddress *uint64   `json:"memoryAddress"`
+	StartTime     time.Time `json:"startTime"`
+	// Args, Env and Dirs are what the module was run with
+	Args []string          `json:"args"`
+	Env  map[string]string `json:"env"`
+	Dirs []Dir             `json:"dirs"`
+
+	// LogFile is the container's log file, which holds the module's output,
+	// and StatsFile the stats file, which is removed when the module exits
+	LogFile   string `json:"-"`
+	StatsFile string `json:"-"`
+}
+
+// Dir is a host directory preopened for a module at a path
+type Dir struct {
+	HostPath  string `json:"hostPath"`
+	GuestPath string `json:"guestPath"`
+}
+
+// ReadModules returns the modules running on the node, from the stats files
+// in the wasi provider's log directory. Stats files that can't be read, such
+// as one removed or still being written while the directory is read, are
+// skipped.
+func ReadModules(dir string) ([]Module, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var modules []Module
+	for _, e := range entries {
+		base, ok := strings.CutSuffix(e.Name(), statsFileSuffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var m Module
+		if err := json.Unmarshal(data, &m); err != nil || m.Namespace == "" || m.Pod == "" || m.Container == "" {
+			continue
+		}
+		m.LogFile = filepath.Join(dir, base+".log")
+		m.StatsFile = filepath.Join(dir, e.Name())
+		modules = append(modules, m)
+	}
+	return modules, nil
+}
@@ -0,0 +1,164 @@
+package statsshim
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nanosPerTick converts the CPU times in /proc, counted in USER_HZ ticks, to
+// nanoseconds. USER_HZ is 100 on every architecture Linux supports.
+const nanosPerTick = uint64(time.Second / 100)
+
+// procFS reads a proc filesystem mounted at its path
+type procFS string
+
+func (p procFS) read(elem ...string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(append([]string{string(p)}, elem...)...))
+}
+
+// cpuTime returns the host's total busy CPU time in nanoseconds and when it
+// booted, from /proc/stat
+func (p procFS) cpuTime() (busy uint64, boot time.Time, err error) {
+	data, err := p.read("stat")
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var foundCPU, foundBoot bool
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 9 && fields[0] == "cpu":
+			// user nice system idle iowait irq softirq steal; idle and iowait
+			// aren't busy time
+			for _, i := range []int{1, 2, 3, 6, 7, 8} {
+				n, err := strconv.ParseUint(fields[i], 10, 64)
+				if err != nil {
+					return 0, time.Time{}, fmt.Errorf("parsing /proc/stat: %w", err)
+				}
+				busy += n * nanosPerTick
+			}
+			foundCPU = true
+		case len(fields) == 2 && fields[0] == "btime":
+			secs, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, time.Time{}, fmt.Errorf("parsing /proc/stat: %w", err)
+			}
+			boot = time.Unix(secs, 0)
+			foundBoot = true
+		}
+	}
+	if !foundCPU || !foundBoot {
+		return 0, time.Time{}, errors.New("/proc/stat has no cpu or btime line")
+	}
+	return busy, boot, nil
+}
+
+// meminfo is the part of /proc/meminfo the node's memory stats come from
+type meminfo struct {
+	total, free, available uint64
+}
+
+func (p procFS) meminfo() (meminfo, error) {
+	data, err := p.read("meminfo")
+	if err != nil {
+		return meminfo{}, err
+	}
+	var m meminfo
+	fields := map[string]*uint64{"MemTotal:": &m.total, "MemFree:": &m.free, "MemAvailable:": &m.available}
+	found := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+		if v, ok := fields[parts[0]]; ok {
+			kb, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return meminfo{}, fmt.Errorf("parsing /proc/meminfo: %w", err)
+			}
+			*v = kb * 1024
+			found++
+		}
+	}
+	if found != len(fields) {
+		return meminfo{}, errors.New("/proc/meminfo has no MemTotal, MemFree or MemAvailable")
+	}
+	return m, nil
+}
+
+// threadCPUTime returns the CPU time in nanoseconds a thread has used, from
+// /proc/<pid>/task/<tid>/stat
+func (p procFS) threadCPUTime(pid, tid int) (uint64, error) {
+	data, err := p.read(strconv.Itoa(pid), "task", strconv.Itoa(tid), "stat")
+	if err != nil {
+		return 0, err
+	}
+	// The command name, in parentheses, may itself hold spaces and
+	// parentheses, so fields are counted from the last closing one
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return 0, errors.New("malformed thread stat")
+	}
+	// The fields after the name start at the third, state; utime and stime
+	// are the 14th and 15th
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 13 {
+		return 0, errors.New("malformed thread stat")
+	}
+	var total uint64
+	for _, f := range fields[11:13] {
+		n, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing thread stat: %w", err)
+		}
+		total += n * nanosPerTick
+	}
+	return total, nil
+}
+
+// mappingRSS returns the resident size of the process's memory mapping that
+// holds the address, from /proc/<pid>/smaps
+func (p procFS) mappingRSS(pid int, addr uint64) (uint64, error) {
+	f, err := os.Open(filepath.Join(string(p), strconv.Itoa(pid), "smaps"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	inside := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// Each mapping starts with a line like
+		// 7f2c4a000000-7f2c4a100000 rw-p 00000000 00:00 0
+		if start, end, ok := strings.Cut(fields[0], "-"); ok && !strings.HasSuffix(fields[0], ":") {
+			lo, err1 := strconv.ParseUint(start, 16, 64)
+			hi, err2 := strconv.ParseUint(end, 16, 64)
+			if err1 == nil && err2 == nil {
+				inside = lo <= addr && addr < hi
+				continue
+			}
+		}
+		if inside && fields[0] == "Rss:" && len(fields) >= 2 {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parsing smaps: %w", err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no mapping holds address %#x", addr)
+}
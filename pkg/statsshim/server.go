@@ -0,0 +1,78 @@
+package statsshim
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/klog/v2"
+)
+
+// Streaming serves the node's streaming endpoints. Endpoints without a
+// handler are passed to krustlet.
+type Streaming struct {
+	PortForward http.Handler
+	Exec        http.Handler
+	Attach      http.Handler
+}
+
+// NewHandler returns a handler for the node's API that serves the stats
+// endpoints from the provider and the streaming endpoints from streaming,
+// and passes every other request to krustlet at upstream, so the handler can
+// take the place of krustlet's own server. If auth is nil, anyone may use the
+// endpoints the handler serves itself.
+func NewHandler(provider *Provider, streaming Streaming, auth *Auth, upstream *url.URL) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(resourceMetrics{provider: provider})
+	metrics := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+	summary := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, err := provider.Summary()
+		if err != nil {
+			klog.ErrorS(err, "Gathering stats")
+			http.Error(w, "failed to get stats: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s)
+	})
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Krustlet's certificate is for the node's address, not the loopback
+	// address it is reached on behind the shim
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	proxy.Transport = transport
+	// Followed logs are streamed as they are written
+	proxy.FlushInterval = -1
+
+	protect := func(subresource string, h http.Handler) http.Handler {
+		if auth == nil {
+			return h
+		}
+		return auth.wrap(subresource, h)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/stats/summary", protect("stats", summary))
+	mux.Handle("/metrics/resource", protect("metrics", metrics))
+	// The kubelet authorizes streaming as the proxy subresource
+	for prefix, h := range map[string]http.Handler{
+		"/portForward/": streaming.PortForward,
+		"/exec/":        streaming.Exec,
+		"/attach/":      streaming.Attach,
+	} {
+		if h != nil {
+			mux.Handle(prefix, protect("proxy", h))
+		}
+	}
+	mux.Handle("/", proxy)
+	return mux
+}
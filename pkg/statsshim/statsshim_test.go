@@ -0,0 +1,273 @@
+package statsshim
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	testPID = 4242
+	testTID = 4250
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeProc writes a proc filesystem with krustlet running one module on
+// thread testTID, which has used cpuTicks of CPU time
+func fakeProc(t *testing.T, dir string, cpuTicks int) {
+	t.Helper()
+	writeFile(t, filepath.Join(dir, "stat"), "cpu  100 0 50 900 10 5 5 0 0 0\ncpu0 100 0 50 900 10 5 5 0 0 0\nbtime 1700000000\n")
+	writeFile(t, filepath.Join(dir, "meminfo"), "MemTotal:        4000000 kB\nMemFree:         1000000 kB\nMemAvailable:    3000000 kB\n")
+	task := filepath.Join(dir, "4242", "task", "4250", "stat")
+	writeFile(t, task, "4250 (tokio (blocking)) S 1 4242 4242 0 -1 4194368 10 0 0 0 "+
+		strconv.Itoa(cpuTicks-10)+" 10 0 0 20 0 40 0 12345 0 0\n")
+	writeFile(t, filepath.Join(dir, "4242", "smaps"), `55d0c0000000-55d0c0100000 r-xp 00000000 08:01 1234 /usr/local/bin/krustlet
+Size:               1024 kB
+Rss:                 800 kB
+7f0000000000-7f0000200000 rw-p 00000000 00:00 0
+Size:               2048 kB
+Rss:                1536 kB
+Pss:                1536 kB
+VmFlags: rd wr mr mw me ac sd
+7f0000200000-7f0100000000 ---p 00000000 00:00 0
+Size:            4192256 kB
+Rss:                   0 kB
+`)
+}
+
+func newTestProvider(t *testing.T, pods ...*corev1.Pod) (*Provider, string, string) {
+	t.Helper()
+	proc, dataDir := t.TempDir(), t.TempDir()
+	statsDir := filepath.Join(dataDir, "wasi-logs")
+	fakeProc(t, proc, 100)
+	writeFile(t, filepath.Join(statsDir, "hello_default_app-x1.stats.json"),
+		`{"namespace":"default","pod":"hello","container":"app","pid":4242,"tid":4250,"memoryAddress":139637976727552,"startTime":"2024-01-02T03:04:05Z"}`)
+	writeFile(t, filepath.Join(statsDir, "hello_default_app-x1.log"), "hello\nworld\n")
+	// A module whose thread has gone is left out
+	writeFile(t, filepath.Join(statsDir, "gone_default_app-x2.stats.json"),
+		`{"namespace":"default","pod":"gone","container":"app","pid":4242,"tid":9999,"memoryAddress":null,"startTime":"2024-01-02T03:04:05Z"}`)
+	writeFile(t, filepath.Join(dataDir, ".oci", "modules", "hello", "module.wasm"), "0123456789")
+
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	p := New(factory, Options{
+		NodeName:  "krustlet",
+		StatsDir:  statsDir,
+		DataDir:   dataDir,
+		ModuleDir: filepath.Join(dataDir, ".oci", "modules"),
+		ProcDir:   proc,
+	})
+	indexer := factory.Core().V1().Pods().Informer().GetIndexer()
+	for _, pod := range pods {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p, proc, statsDir
+}
+
+func TestSummary(t *testing.T) {
+	started := metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC))
+	p, proc, _ := newTestProvider(t, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "uid-1"},
+		Status:     corev1.PodStatus{StartTime: &started},
+	})
+	now := time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	s, err := p.Summary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := s.Node
+	if node.NodeName != "krustlet" || !node.StartTime.Equal(&metav1.Time{Time: time.Unix(1700000000, 0)}) {
+		t.Errorf("node = %s started %v", node.NodeName, node.StartTime)
+	}
+	// user + nice + system + irq + softirq + steal = 160 ticks
+	if got := *node.CPU.UsageCoreNanoSeconds; got != 160*nanosPerTick {
+		t.Errorf("node CPU = %d, want %d", got, 160*nanosPerTick)
+	}
+	if node.CPU.UsageNanoCores != nil {
+		t.Error("node CPU rate reported without an earlier sample")
+	}
+	if got := *node.Memory.WorkingSetBytes; got != 1000000*1024 {
+		t.Errorf("node working set = %d", got)
+	}
+	if got := *node.Memory.AvailableBytes; got != 3000000*1024 {
+		t.Errorf("node available memory = %d", got)
+	}
+	if node.Runtime != nil && *node.Runtime.ImageFs.UsedBytes != 10 {
+		t.Errorf("image filesystem used = %d, want 10", *node.Runtime.ImageFs.UsedBytes)
+	}
+
+	if len(s.Pods) != 1 {
+		t.Fatalf("got %d pods, want 1", len(s.Pods))
+	}
+	pod := s.Pods[0]
+	if pod.PodRef.Name != "hello" || pod.PodRef.Namespace != "default" || pod.PodRef.UID != "uid-1" || !pod.StartTime.Equal(&started) {
+		t.Errorf("pod = %+v started %v", pod.PodRef, pod.StartTime)
+	}
+	if len(pod.Containers) != 1 {
+		t.Fatalf("got %d containers, want 1", len(pod.Containers))
+	}
+	c := pod.Containers[0]
+	if c.Name != "app" || c.StartTime.Time.Format(time.RFC3339) != "2024-01-02T03:04:05Z" {
+		t.Errorf("container = %s started %v", c.Name, c.StartTime)
+	}
+	if got := *c.CPU.UsageCoreNanoSeconds; got != 100*nanosPerTick {
+		t.Errorf("container CPU = %d, want %d", got, 100*nanosPerTick)
+	}
+	if got := *c.Memory.WorkingSetBytes; got != 1536*1024 {
+		t.Errorf("container working set = %d, want %d", got, 1536*1024)
+	}
+	if c.Logs != nil && *c.Logs.UsedBytes != 12 {
+		t.Errorf("container logs = %d bytes, want 12", *c.Logs.UsedBytes)
+	}
+	if *pod.CPU.UsageCoreNanoSeconds != *c.CPU.UsageCoreNanoSeconds || *pod.Memory.WorkingSetBytes != *c.Memory.WorkingSetBytes {
+		t.Error("pod usage isn't the sum of its containers'")
+	}
+
+	// Half a core over ten seconds
+	fakeProc(t, proc, 600)
+	now = now.Add(10 * time.Second)
+	s, err = p.Summary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate := s.Pods[0].Containers[0].CPU.UsageNanoCores
+	if rate == nil || *rate != 500000000 {
+		t.Errorf("container CPU rate = %v, want 500000000", rate)
+	}
+	if s.Pods[0].CPU.UsageNanoCores == nil {
+		t.Error("pod CPU rate missing")
+	}
+}
+
+func TestResourceMetrics(t *testing.T) {
+	p, _, _ := newTestProvider(t)
+	srv := httptest.NewServer(NewHandler(p, Streaming{}, nil, &url.URL{Scheme: "http", Host: "127.0.0.1:1"}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics/resource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`container_cpu_usage_seconds_total{container="app",namespace="default",pod="hello"} 1 `,
+		`container_memory_working_set_bytes{container="app",namespace="default",pod="hello"} 1.572864e+06 `,
+		`container_start_time_seconds{container="app",namespace="default",pod="hello"} 1.704164645e+09`,
+		`pod_cpu_usage_seconds_total{namespace="default",pod="hello"} 1 `,
+		`node_cpu_usage_seconds_total 1.6 `,
+		"scrape_error 0",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHandlerProxiesKrustlet(t *testing.T) {
+	krustlet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "krustlet "+r.URL.Path)
+	}))
+	defer krustlet.Close()
+	upstream, _ := url.Parse(krustlet.URL)
+	p, _, _ := newTestProvider(t)
+	srv := httptest.NewServer(NewHandler(p, Streaming{}, nil, upstream))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/containerLogs/default/hello/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "krustlet /containerLogs/default/hello/app" {
+		t.Errorf("got %q", body)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "metrics-server" || review.Spec.Token == "nobody" {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: review.Spec.Token}}
+		}
+		return true, review, nil
+	})
+	var sar *authorizationv1.SubjectAccessReview
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar = action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = sar.Spec.User == "metrics-server"
+		return true, sar, nil
+	})
+
+	p, _, _ := newTestProvider(t)
+	srv := httptest.NewServer(NewHandler(p, Streaming{}, NewAuth(client, "krustlet", nil), &url.URL{Scheme: "http", Host: "127.0.0.1:1"}))
+	defer srv.Close()
+	get := func(token string) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stats/summary", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Errorf("no token: got %d, want 401", code)
+	}
+	if code := get("bogus"); code != http.StatusUnauthorized {
+		t.Errorf("bad token: got %d, want 401", code)
+	}
+	if code := get("nobody"); code != http.StatusForbidden {
+		t.Errorf("unauthorized user: got %d, want 403", code)
+	}
+	if code := get("metrics-server"); code != http.StatusOK {
+		t.Errorf("authorized user: got %d, want 200", code)
+	}
+	attrs := sar.Spec.ResourceAttributes
+	if attrs.Verb != "get" || attrs.Resource != "nodes" || attrs.Subresource != "stats" || attrs.Name != "krustlet" {
+		t.Errorf("access review attributes = %+v", attrs)
+	}
+	before := reviews
+	if code := get("metrics-server"); code != http.StatusOK {
+		t.Errorf("cached user: got %d, want 200", code)
+	}
+	if reviews != before {
+		t.Error("allowed caller reviewed again")
+	}
+}
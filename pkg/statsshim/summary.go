@@ -0,0 +1,298 @@
+// Package statsshim serves the kubelet's stats summary and resource metrics
+// APIs for a krustlet node, so metrics-server, the horizontal pod autoscaler
+// and kubectl top work for wasm pods.
+//
+// Krustlet runs every module on a thread of its own in the krustlet process,
+// so the usual per-container cgroups don't exist. Instead the wasi
+// provider writes a stats file for each running module recording its thread
+// and where its linear memory is mapped. A container's CPU usage is its
+// thread's CPU time and its memory usage the resident size of its linear
+// memory, both read from /proc. Node stats come from /proc and the
+// filesystems krustlet keeps its data on.
+package statsshim
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+	statsv1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+)
+
+// Options configure a Provider
+type Options struct {
+	NodeName string
+	// StatsDir is where the wasi provider writes stats files, its log
+	// directory
+	StatsDir string
+	// DataDir is krustlet's data directory, reported as the node's
+	// filesystem
+	DataDir string
+	// ModuleDir is krustlet's module store, reported as the image filesystem
+	ModuleDir string
+	// ProcDir is where the proc filesystem is mounted, /proc if empty
+	ProcDir string
+}
+
+// Provider gathers the node's stats
+type Provider struct {
+	opts Options
+	proc procFS
+	pods corelisters.PodLister
+	now  func() time.Time
+
+	// mu guards samples, the last CPU time read for the node and each
+	// container, from which usage rates are computed
+	mu      sync.Mutex
+	samples map[string]cpuSample
+}
+
+type cpuSample struct {
+	time  time.Time
+	usage uint64
+}
+
+// New returns a provider that gets pod UIDs from the informer factory, which
+// should only list pods on the node. The factory must be started by the
+// caller.
+func New(factory informers.SharedInformerFactory, opts Options) *Provider {
+	if opts.ProcDir == "" {
+		opts.ProcDir = "/proc"
+	}
+	return &Provider{
+		opts:    opts,
+		proc:    procFS(opts.ProcDir),
+		pods:    factory.Core().V1().Pods().Lister(),
+		now:     time.Now,
+		samples: map[string]cpuSample{},
+	}
+}
+
+// Summary returns the node's stats summary. Stats that can't be read, such as
+// those of a module that just finished, are left out, as the kubelet does.
+func (p *Provider) Summary() (*statsv1alpha1.Summary, error) {
+	modules, err := ReadModules(p.opts.StatsDir)
+	if err != nil {
+		return nil, err
+	}
+	now := p.now()
+	seen := map[string]bool{}
+	summary := &statsv1alpha1.Summary{
+		Node: p.nodeStats(now, seen),
+		Pods: []statsv1alpha1.PodStats{},
+	}
+
+	var logsFs *fsUsage
+	if fs, err := statfs(p.opts.StatsDir); err == nil {
+		logsFs = &fs
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		a, b := modules[i], modules[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+		return a.Container < b.Container
+	})
+	for i := range modules {
+		m := &modules[i]
+		c, ok := p.containerStats(m, now, logsFs, seen)
+		if !ok {
+			continue
+		}
+		n := len(summary.Pods)
+		if n == 0 || summary.Pods[n-1].PodRef.Namespace != m.Namespace || summary.Pods[n-1].PodRef.Name != m.Pod {
+			summary.Pods = append(summary.Pods, p.podStats(m))
+			n++
+		}
+		summary.Pods[n-1].Containers = append(summary.Pods[n-1].Containers, c)
+	}
+	for i := range summary.Pods {
+		totalPod(&summary.Pods[i], now)
+	}
+
+	p.mu.Lock()
+	for key := range p.samples {
+		if !seen[key] {
+			delete(p.samples, key)
+		}
+	}
+	p.mu.Unlock()
+	return summary, nil
+}
+
+func (p *Provider) nodeStats(now time.Time, seen map[string]bool) statsv1alpha1.NodeStats {
+	node := statsv1alpha1.NodeStats{NodeName: p.opts.NodeName}
+	t := metav1.NewTime(now)
+	if busy, boot, err := p.proc.cpuTime(); err != nil {
+		klog.V(2).InfoS("Reading node CPU usage", "err", err)
+	} else {
+		node.StartTime = metav1.NewTime(boot)
+		node.CPU = &statsv1alpha1.CPUStats{
+			Time:                 t,
+			UsageCoreNanoSeconds: &busy,
+			UsageNanoCores:       p.rate("node", now, busy),
+		}
+		seen["node"] = true
+	}
+	if m, err := p.proc.meminfo(); err != nil {
+		klog.V(2).InfoS("Reading node memory usage", "err", err)
+	} else {
+		usage := m.total - m.free
+		workingSet := m.total - m.available
+		node.Memory = &statsv1alpha1.MemoryStats{
+			Time:            t,
+			AvailableBytes:  &m.available,
+			UsageBytes:      &usage,
+			WorkingSetBytes: &workingSet,
+		}
+	}
+	if fs, err := statfs(p.opts.DataDir); err != nil {
+		klog.V(2).InfoS("Reading node filesystem usage", "path", p.opts.DataDir, "err", err)
+	} else {
+		node.Fs = fsStats(fs, fs.used, t)
+	}
+	if fs, err := statfs(p.opts.ModuleDir); err != nil {
+		klog.V(2).InfoS("Reading module store usage", "path", p.opts.ModuleDir, "err", err)
+	} else {
+		node.Runtime = &statsv1alpha1.RuntimeStats{ImageFs: fsStats(fs, dirSize(p.opts.ModuleDir), t)}
+	}
+	return node
+}
+
+// containerStats returns the stats of a module's container, or false if its
+// CPU time or memory can't be read because it has finished
+func (p *Provider) containerStats(m *Module, now time.Time, logsFs *fsUsage, seen map[string]bool) (statsv1alpha1.ContainerStats, bool) {
+	cpu, err := p.proc.threadCPUTime(m.PID, m.TID)
+	if err != nil {
+		klog.V(2).InfoS("Reading module CPU usage", "namespace", m.Namespace, "pod", m.Pod, "container", m.Container, "err", err)
+		return statsv1alpha1.ContainerStats{}, false
+	}
+	var memory uint64
+	if m.MemoryAddress != nil {
+		memory, err = p.proc.mappingRSS(m.PID, *m.MemoryAddress)
+		if err != nil {
+			klog.V(2).InfoS("Reading module memory usage", "namespace", m.Namespace, "pod", m.Pod, "container", m.Container, "err", err)
+			return statsv1alpha1.ContainerStats{}, false
+		}
+	}
+
+	t := metav1.NewTime(now)
+	// The thread ID tells a restarted container's samples from the last run's
+	key := m.Namespace + "/" + m.Pod + "/" + m.Container + "/" + strconv.Itoa(m.TID)
+	seen[key] = true
+	c := statsv1alpha1.ContainerStats{
+		Name:      m.Container,
+		StartTime: metav1.NewTime(m.StartTime),
+		CPU: &statsv1alpha1.CPUStats{
+			Time:                 t,
+			UsageCoreNanoSeconds: &cpu,
+			UsageNanoCores:       p.rate(key, now, cpu),
+		},
+		Memory: &statsv1alpha1.MemoryStats{
+			Time:            t,
+			UsageBytes:      &memory,
+			WorkingSetBytes: &memory,
+			RSSBytes:        &memory,
+		},
+	}
+	if info, err := os.Stat(m.LogFile); err == nil && logsFs != nil {
+		c.Logs = fsStats(*logsFs, uint64(info.Size()), t)
+	}
+	return c, true
+}
+
+// podStats returns the pod a module belongs to, without containers
+func (p *Provider) podStats(m *Module) statsv1alpha1.PodStats {
+	pod := statsv1alpha1.PodStats{
+		PodRef:    statsv1alpha1.PodReference{Name: m.Pod, Namespace: m.Namespace},
+		StartTime: metav1.NewTime(m.StartTime),
+	}
+	if obj, err := p.pods.Pods(m.Namespace).Get(m.Pod); err == nil {
+		pod.PodRef.UID = string(obj.UID)
+		if obj.Status.StartTime != nil {
+			pod.StartTime = *obj.Status.StartTime
+		}
+	}
+	return pod
+}
+
+// totalPod sums the usage of a pod's containers
+func totalPod(pod *statsv1alpha1.PodStats, now time.Time) {
+	t := metav1.NewTime(now)
+	var cpu, rate, memory, logs uint64
+	haveRate, haveLogs := true, false
+	for _, c := range pod.Containers {
+		cpu += *c.CPU.UsageCoreNanoSeconds
+		if c.CPU.UsageNanoCores != nil {
+			rate += *c.CPU.UsageNanoCores
+		} else {
+			haveRate = false
+		}
+		memory += *c.Memory.WorkingSetBytes
+		if c.Logs != nil {
+			logs += *c.Logs.UsedBytes
+			haveLogs = true
+		}
+	}
+	pod.CPU = &statsv1alpha1.CPUStats{Time: t, UsageCoreNanoSeconds: &cpu}
+	if haveRate {
+		pod.CPU.UsageNanoCores = &rate
+	}
+	pod.Memory = &statsv1alpha1.MemoryStats{Time: t, UsageBytes: &memory, WorkingSetBytes: &memory, RSSBytes: &memory}
+	if haveLogs {
+		pod.EphemeralStorage = &statsv1alpha1.FsStats{Time: t, UsedBytes: &logs}
+	}
+}
+
+// rate returns the CPU usage in nanocores since the last sample for the key,
+// or nil if there is none
+func (p *Provider) rate(key string, now time.Time, usage uint64) *uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last, ok := p.samples[key]
+	p.samples[key] = cpuSample{time: now, usage: usage}
+	elapsed := now.Sub(last.time)
+	if !ok || elapsed <= 0 || usage < last.usage {
+		return nil
+	}
+	r := uint64(float64(usage-last.usage) / elapsed.Seconds())
+	return &r
+}
+
+func fsStats(fs fsUsage, used uint64, t metav1.Time) *statsv1alpha1.FsStats {
+	inodesUsed := fs.inodes - fs.inodesFree
+	return &statsv1alpha1.FsStats{
+		Time:           t,
+		AvailableBytes: &fs.available,
+		CapacityBytes:  &fs.capacity,
+		UsedBytes:      &used,
+		InodesFree:     &fs.inodesFree,
+		Inodes:         &fs.inodes,
+		InodesUsed:     &inodesUsed,
+	}
+}
+
+// dirSize returns the total size of the files under dir
+func dirSize(dir string) uint64 {
+	var size uint64
+	_ = filepath.WalkDir(dir, func(_ string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
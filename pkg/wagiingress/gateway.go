@@ -0,0 +1,202 @@
+package wagiingress
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	networkinglisters "k8s.io/client-go/listers/networking/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// Gateway is an http.Handler that proxies requests to WAGI pods by the
+// routes in Ingresses of its class
+type Gateway struct {
+	builder   *builder
+	ingresses networkinglisters.IngressLister
+	synced    []cache.InformerSynced
+	// queue has a single key; every change rebuilds the whole table
+	queue workqueue.TypedRateLimitingInterface[string]
+
+	table  atomic.Pointer[table]
+	health *health
+	next   atomic.Uint64
+	proxy  *httputil.ReverseProxy
+}
+
+// Options configure a Gateway
+type Options struct {
+	// IngressClass is the class of the Ingresses the gateway serves
+	IngressClass string
+	// Arch is the architecture label of the krustlet nodes whose pods are
+	// routed to
+	Arch string
+	// HealthCheckTimeout bounds each health check
+	HealthCheckTimeout time.Duration
+}
+
+// New returns a gateway that reads Ingresses, Services, pods and nodes from
+// the factory's informers. The factory must be started by the caller.
+func New(factory informers.SharedInformerFactory, opts Options) *Gateway {
+	ingresses := factory.Networking().V1().Ingresses()
+	services := factory.Core().V1().Services()
+	pods := factory.Core().V1().Pods()
+	nodes := factory.Core().V1().Nodes()
+	g := &Gateway{
+		builder: &builder{
+			class:    opts.IngressClass,
+			arch:     opts.Arch,
+			services: services.Lister(),
+			pods:     pods.Lister(),
+			nodes:    nodes.Lister(),
+		},
+		ingresses: ingresses.Lister(),
+		synced: []cache.InformerSynced{
+			ingresses.Informer().HasSynced, services.Informer().HasSynced,
+			pods.Informer().HasSynced, nodes.Informer().HasSynced,
+		},
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "krustlet-wagi-ingress"},
+		),
+		health: newHealth(opts.HealthCheckTimeout),
+	}
+	g.table.Store(&table{})
+	g.proxy = &httputil.ReverseProxy{
+		Rewrite:      g.rewrite,
+		ErrorHandler: g.proxyError,
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { g.queue.Add("") },
+		UpdateFunc: func(_, _ interface{}) { g.queue.Add("") },
+		DeleteFunc: func(interface{}) { g.queue.Add("") },
+	}
+	for _, informer := range []cache.SharedIndexInformer{ingresses.Informer(), services.Informer(), pods.Informer(), nodes.Informer()} {
+		_, _ = informer.AddEventHandler(handler)
+	}
+	return g
+}
+
+// Run keeps the routing table up to date and checks endpoint health each
+// interval until the context is cancelled
+func (g *Gateway) Run(ctx context.Context, healthCheckInterval time.Duration) error {
+	defer utilruntime.HandleCrash()
+	defer g.queue.ShutDown()
+
+	klog.Info("Waiting for informers to sync")
+	if !cache.WaitForCacheSync(ctx.Done(), g.synced...) {
+		return fmt.Errorf("timed out waiting for informers to sync")
+	}
+	g.rebuild()
+	go wait.UntilWithContext(ctx, g.health.checkAll, healthCheckInterval)
+	go wait.UntilWithContext(ctx, func(context.Context) {
+		for g.processNext() {
+		}
+	}, time.Second)
+	<-ctx.Done()
+	return nil
+}
+
+func (g *Gateway) processNext() bool {
+	key, shutdown := g.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer g.queue.Done(key)
+	g.rebuild()
+	return true
+}
+
+// rebuild replaces the routing table from the current informer contents
+func (g *Gateway) rebuild() {
+	ingresses, err := g.ingresses.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	t, errs := g.builder.build(ingresses)
+	for _, err := range errs {
+		klog.V(2).InfoS("Skipping route", "err", err)
+	}
+
+	targets := map[target]bool{}
+	for _, r := range t.routes {
+		for _, addr := range t.endpoints[r.backend] {
+			targets[target{addr: addr, path: r.healthPath}] = true
+		}
+	}
+	g.health.setTargets(targets)
+	g.table.Store(t)
+	klog.V(4).InfoS("Rebuilt routes", "routes", len(t.routes), "endpoints", len(targets))
+}
+
+type proxyContextKey struct{}
+
+// proxyTarget is what ServeHTTP decided, for the proxy's callbacks
+type proxyTarget struct {
+	route  *route
+	target target
+}
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := g.table.Load()
+	route := t.lookup(r.Host, r.URL.Path)
+	if route == nil {
+		http.Error(w, "no route for "+r.Host+r.URL.Path, http.StatusNotFound)
+		return
+	}
+	var healthy []string
+	for _, addr := range t.endpoints[route.backend] {
+		if g.health.ok(target{addr: addr, path: route.healthPath}) {
+			healthy = append(healthy, addr)
+		}
+	}
+	if len(healthy) == 0 {
+		http.Error(w, "no healthy WAGI pods for "+route.backend, http.StatusServiceUnavailable)
+		return
+	}
+	addr := healthy[int(g.next.Add(1)%uint64(len(healthy)))]
+
+	ctx, cancel := context.WithTimeout(r.Context(), route.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, proxyContextKey{}, &proxyTarget{route: route, target: target{addr: addr, path: route.healthPath}})
+	g.proxy.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (g *Gateway) rewrite(pr *httputil.ProxyRequest) {
+	pt := pr.In.Context().Value(proxyContextKey{}).(*proxyTarget)
+	pr.SetURL(&url.URL{Scheme: "http", Host: pt.target.addr})
+	pr.SetXForwarded()
+	// WAGI modules may route on the host, so keep the one the client used
+	pr.Out.Host = pr.In.Host
+	if pt.route.stripPrefix && pt.route.pathType != networkingv1.PathTypeExact {
+		prefix := strings.TrimSuffix(pt.route.path, "/")
+		pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.In.URL.Path, prefix), "/")
+		pr.Out.URL.RawPath = ""
+	}
+}
+
+func (g *Gateway) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	pt := r.Context().Value(proxyContextKey{}).(*proxyTarget)
+	if r.Context().Err() == context.DeadlineExceeded {
+		klog.V(2).InfoS("WAGI pod timed out", "endpoint", pt.target.addr, "route", pt.route.ingress)
+		http.Error(w, "WAGI pod timed out", http.StatusGatewayTimeout)
+		return
+	}
+	klog.V(2).InfoS("Proxying to WAGI pod failed", "endpoint", pt.target.addr, "route", pt.route.ingress, "err", err)
+	g.health.eject(pt.target)
+	http.Error(w, "could not reach WAGI pod", http.StatusBadGateway)
+}
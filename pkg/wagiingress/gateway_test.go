@@ -0,0 +1,286 @@
+package wagiingress
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testClass = "krustlet-wagi"
+
+// backend is a stand-in for a WAGI pod that echoes the path it was sent
+func backend(t *testing.T, name string) (*httptest.Server, int32) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			return
+		}
+		_, _ = io.WriteString(w, name+" "+r.Host+" "+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return srv, int32(p)
+}
+
+func newTestGateway(t *testing.T, objs ...runtime.Object) *Gateway {
+	t.Helper()
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	g := New(factory, Options{IngressClass: testClass, Arch: "wasm32-wasi", HealthCheckTimeout: time.Second})
+	for _, obj := range objs {
+		var err error
+		switch o := obj.(type) {
+		case *networkingv1.Ingress:
+			err = factory.Networking().V1().Ingresses().Informer().GetIndexer().Add(o)
+		case *corev1.Service:
+			err = factory.Core().V1().Services().Informer().GetIndexer().Add(o)
+		case *corev1.Pod:
+			err = factory.Core().V1().Pods().Informer().GetIndexer().Add(o)
+		case *corev1.Node:
+			err = factory.Core().V1().Nodes().Informer().GetIndexer().Add(o)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.rebuild()
+	return g
+}
+
+func krustletNode(name string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{archLabel: "wasm32-wasi"}},
+		Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "127.0.0.1"}}},
+	}
+}
+
+func wagiPod(name, app, node string, port int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name:  app,
+				Image: "webassembly.azurecr.io/" + app + ":v1",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: port}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func service(name, app string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": app},
+			Ports:    []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromString("http")}},
+		},
+	}
+}
+
+func ingress(name string, annotations map[string]string, rules ...networkingv1.IngressRule) *networkingv1.Ingress {
+	class := testClass
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+		Spec:       networkingv1.IngressSpec{IngressClassName: &class, Rules: rules},
+	}
+}
+
+func rule(host, path string, pathType networkingv1.PathType, svc string) networkingv1.IngressRule {
+	return networkingv1.IngressRule{
+		Host: host,
+		IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+			Paths: []networkingv1.HTTPIngressPath{{
+				Path:     path,
+				PathType: &pathType,
+				Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+					Name: svc,
+					Port: networkingv1.ServiceBackendPort{Name: "http"},
+				}},
+			}},
+		}},
+	}
+}
+
+func get(t *testing.T, g *Gateway, host, path string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestRouting(t *testing.T) {
+	_, helloPort := backend(t, "hello")
+	_, apiPort := backend(t, "api")
+	g := newTestGateway(t,
+		krustletNode("krustlet-wagi"),
+		wagiPod("hello", "hello", "krustlet-wagi", helloPort),
+		wagiPod("api", "api", "krustlet-wagi", apiPort),
+		service("hello", "hello"),
+		service("api", "api"),
+		ingress("hello", nil, rule("", "/", networkingv1.PathTypePrefix, "hello")),
+		ingress("api", map[string]string{StripPrefixAnnotation: "true"},
+			rule("api.example.com", "/v1", networkingv1.PathTypePrefix, "api")),
+	)
+
+	tests := []struct {
+		host, path string
+		want       string
+	}{
+		{"api.example.com", "/v1/users", "api api.example.com /users"},
+		{"api.example.com", "/v1", "api api.example.com /"},
+		{"api.example.com", "/v1beta", "hello api.example.com /v1beta"},
+		{"hello.example.com", "/v1/users", "hello hello.example.com /v1/users"},
+	}
+	for _, tt := range tests {
+		code, body := get(t, g, tt.host, tt.path)
+		if code != http.StatusOK || body != tt.want {
+			t.Errorf("%s%s: got %d %q, want %q", tt.host, tt.path, code, body, tt.want)
+		}
+	}
+}
+
+func TestRoutingIgnoresOtherPods(t *testing.T) {
+	_, port := backend(t, "hello")
+	linux := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "linux", Labels: map[string]string{archLabel: "amd64"}}}
+	pending := wagiPod("pending", "hello", "krustlet-wagi", port)
+	pending.Status.Phase = corev1.PodPending
+	otherClass := ingress("other", nil, rule("other.example.com", "/", networkingv1.PathTypePrefix, "hello"))
+	other := "nginx"
+	otherClass.Spec.IngressClassName = &other
+
+	g := newTestGateway(t,
+		krustletNode("krustlet-wagi"), linux,
+		wagiPod("on-linux", "hello", "linux", port),
+		pending,
+		service("hello", "hello"),
+		ingress("hello", nil, rule("hello.example.com", "/", networkingv1.PathTypePrefix, "hello")),
+		otherClass,
+	)
+	if code, _ := get(t, g, "hello.example.com", "/"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected no endpoints, got %d", code)
+	}
+	if code, _ := get(t, g, "other.example.com", "/"); code != http.StatusNotFound {
+		t.Errorf("expected Ingresses of other classes to be ignored, got %d", code)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	g := newTestGateway(t,
+		krustletNode("krustlet-wagi"),
+		wagiPod("hello", "hello", "krustlet-wagi", int32(p)),
+		service("hello", "hello"),
+		ingress("hello", map[string]string{HealthCheckPathAnnotation: "/healthz"},
+			rule("", "/", networkingv1.PathTypePrefix, "hello")),
+	)
+	if code, _ := get(t, g, "hello.example.com", "/"); code != http.StatusOK {
+		t.Fatalf("expected a new pod to get traffic, got %d", code)
+	}
+	healthy = false
+	g.health.checkAll(context.Background())
+	if code, _ := get(t, g, "hello.example.com", "/"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected an unhealthy pod to get no traffic, got %d", code)
+	}
+	healthy = true
+	g.health.checkAll(context.Background())
+	if code, _ := get(t, g, "hello.example.com", "/"); code != http.StatusOK {
+		t.Errorf("expected a recovered pod to get traffic, got %d", code)
+	}
+}
+
+func TestUnreachablePodIsEjected(t *testing.T) {
+	srv, port := backend(t, "hello")
+	g := newTestGateway(t,
+		krustletNode("krustlet-wagi"),
+		wagiPod("hello", "hello", "krustlet-wagi", port),
+		service("hello", "hello"),
+		ingress("hello", nil, rule("", "/", networkingv1.PathTypePrefix, "hello")),
+	)
+	srv.Close()
+	if code, _ := get(t, g, "hello.example.com", "/"); code != http.StatusBadGateway {
+		t.Fatalf("expected a bad gateway, got %d", code)
+	}
+	if code, _ := get(t, g, "hello.example.com", "/"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected the pod to be ejected, got %d", code)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	g := newTestGateway(t,
+		krustletNode("krustlet-wagi"),
+		wagiPod("slow", "slow", "krustlet-wagi", int32(p)),
+		service("slow", "slow"),
+		ingress("slow", map[string]string{TimeoutAnnotation: "50ms"}, rule("", "/", networkingv1.PathTypePrefix, "slow")),
+	)
+	if code, _ := get(t, g, "slow.example.com", "/"); code != http.StatusGatewayTimeout {
+		t.Errorf("expected a gateway timeout, got %d", code)
+	}
+}
+
+func TestLookupPrecedence(t *testing.T) {
+	tbl := &table{routes: []route{
+		{ingress: "any", path: "/", pathType: networkingv1.PathTypePrefix},
+		{ingress: "wildcard", host: "*.example.com", path: "/", pathType: networkingv1.PathTypePrefix},
+		{ingress: "exact-host", host: "www.example.com", path: "/", pathType: networkingv1.PathTypePrefix},
+		{ingress: "long-prefix", host: "www.example.com", path: "/static/img", pathType: networkingv1.PathTypePrefix},
+		{ingress: "exact-path", host: "www.example.com", path: "/static/img", pathType: networkingv1.PathTypeExact},
+	}}
+	sort.SliceStable(tbl.routes, func(i, j int) bool { return precedence(tbl.routes[i], tbl.routes[j]) })
+
+	tests := []struct {
+		host, path string
+		want       string
+	}{
+		{"www.example.com", "/static/img", "exact-path"},
+		{"www.example.com", "/static/img/a.png", "long-prefix"},
+		{"WWW.example.com:8080", "/static/im", "exact-host"},
+		{"api.example.com", "/", "wildcard"},
+		{"a.b.example.com", "/", "any"},
+		{"example.com", "/", "any"},
+	}
+	for _, tt := range tests {
+		r := tbl.lookup(tt.host, tt.path)
+		if r == nil || r.ingress != tt.want {
+			t.Errorf("%s%s: got %+v, want %s", tt.host, tt.path, r, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package wagiingress
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ejectFor is how long an endpoint that failed a proxied request gets no
+// traffic, unless a health check passes first
+const ejectFor = 10 * time.Second
+
+// target is an endpoint and the path it is checked on, empty if its routes
+// have no health check
+type target struct {
+	addr string
+	path string
+}
+
+type targetHealth struct {
+	// probeFailed is set by active health checks
+	probeFailed bool
+	// ejectedUntil is set when a proxied request can't reach the endpoint
+	ejectedUntil time.Time
+}
+
+// health tracks which endpoints can take traffic, from active checks of the
+// routes' health check paths and from failed proxied requests
+type health struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	targets map[target]*targetHealth
+}
+
+func newHealth(timeout time.Duration) *health {
+	return &health{
+		client:  &http.Client{Timeout: timeout},
+		now:     time.Now,
+		targets: map[target]*targetHealth{},
+	}
+}
+
+// ok reports whether the endpoint may be sent traffic. Endpoints start out
+// healthy, so a new pod gets traffic before its first check.
+func (h *health) ok(t target) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	th := h.targets[t]
+	return th == nil || (!th.probeFailed && !h.now().Before(th.ejectedUntil))
+}
+
+// eject stops traffic to an endpoint for a while after a failed request
+func (h *health) eject(t target) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if th := h.targets[t]; th != nil {
+		th.ejectedUntil = h.now().Add(ejectFor)
+	}
+}
+
+// setTargets replaces the set of endpoints being tracked, keeping what is
+// known about those that remain
+func (h *health) setTargets(targets map[target]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for t := range h.targets {
+		if !targets[t] {
+			delete(h.targets, t)
+		}
+	}
+	for t := range targets {
+		if h.targets[t] == nil {
+			h.targets[t] = &targetHealth{}
+		}
+	}
+}
+
+// checkAll probes every endpoint that has a health check path
+func (h *health) checkAll(ctx context.Context) {
+	h.mu.Lock()
+	var targets []target
+	for t := range h.targets {
+		if t.path != "" {
+			targets = append(targets, t)
+		}
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			ok := h.check(ctx, t)
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if th := h.targets[t]; th != nil {
+				th.probeFailed = !ok
+				if ok {
+					th.ejectedUntil = time.Time{}
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (h *health) check(ctx context.Context, t target) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+t.addr+t.path, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
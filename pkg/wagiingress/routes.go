@@ -0,0 +1,318 @@
+// Package wagiingress routes HTTP traffic to WAGI workloads on krustlet
+// nodes according to Ingress resources.
+//
+// WAGI pods on krustlet nodes don't get pod IPs, so Services in front of
+// them have no endpoints and ordinary ingress controllers have nothing to
+// send traffic to. This package reads Ingresses of its class, resolves each
+// backend Service's selector to running pods on krustlet nodes itself, and
+// proxies to those pods at their host's address.
+package wagiingress
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+)
+
+// Ingress annotations for per-route configuration. They apply to every rule
+// of the Ingress they are on.
+const (
+	// StripPrefixAnnotation, when "true", removes a Prefix rule's path from
+	// the request before it is proxied, so a module mounted at /hello sees /
+	StripPrefixAnnotation = "wagi.krustlet.dev/strip-prefix"
+	// TimeoutAnnotation bounds how long the backend may take to respond, as
+	// a duration such as 30s
+	TimeoutAnnotation = "wagi.krustlet.dev/timeout"
+	// HealthCheckPathAnnotation is a path on the backend that is probed;
+	// pods that don't answer it with a 2xx or 3xx get no traffic
+	HealthCheckPathAnnotation = "wagi.krustlet.dev/health-check-path"
+
+	// legacyClassAnnotation is how Ingresses chose a class before
+	// spec.ingressClassName
+	legacyClassAnnotation = "kubernetes.io/ingress.class"
+	archLabel             = "kubernetes.io/arch"
+)
+
+// DefaultTimeout is the backend timeout for routes without TimeoutAnnotation
+const DefaultTimeout = 60 * time.Second
+
+// route is one path rule of an Ingress
+type route struct {
+	ingress     string
+	host        string
+	path        string
+	pathType    networkingv1.PathType
+	backend     string
+	stripPrefix bool
+	timeout     time.Duration
+	healthPath  string
+}
+
+// table is an immutable snapshot of the routes and the endpoints of their
+// backends
+type table struct {
+	routes []route
+	// endpoints maps a backend key to host:port addresses
+	endpoints map[string][]string
+}
+
+// lookup returns the route for a request host and path, nil if none
+// matches. Routes are kept in priority order, so the first match wins.
+func (t *table) lookup(host, path string) *route {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for i := range t.routes {
+		r := &t.routes[i]
+		if hostMatches(r.host, host) && pathMatches(r, path) {
+			return r
+		}
+	}
+	return nil
+}
+
+// hostMatches implements Ingress host matching: exact, or a single leading
+// wildcard label
+func hostMatches(pattern, host string) bool {
+	switch {
+	case pattern == "":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		suffix := pattern[1:]
+		return strings.HasSuffix(host, suffix) && !strings.Contains(strings.TrimSuffix(host, suffix), ".") && len(host) > len(suffix)
+	default:
+		return pattern == host
+	}
+}
+
+// pathMatches implements Ingress path matching. Prefix matches whole path
+// elements, so /foo matches /foo and /foo/bar but not /foobar.
+// ImplementationSpecific is treated as Prefix.
+func pathMatches(r *route, path string) bool {
+	if r.pathType == networkingv1.PathTypeExact {
+		return path == r.path
+	}
+	prefix := strings.TrimSuffix(r.path, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// precedence orders routes the way the Ingress spec asks: exact hosts before
+// wildcards before rules for any host, then exact paths before prefixes, then
+// longer paths first
+func precedence(a, b route) bool {
+	if ha, hb := hostRank(a.host), hostRank(b.host); ha != hb {
+		return ha < hb
+	}
+	if len(a.host) != len(b.host) {
+		return len(a.host) > len(b.host)
+	}
+	if ea, eb := a.pathType == networkingv1.PathTypeExact, b.pathType == networkingv1.PathTypeExact; ea != eb {
+		return ea
+	}
+	if len(a.path) != len(b.path) {
+		return len(a.path) > len(b.path)
+	}
+	return a.ingress < b.ingress
+}
+
+func hostRank(host string) int {
+	switch {
+	case host == "":
+		return 2
+	case strings.HasPrefix(host, "*."):
+		return 1
+	}
+	return 0
+}
+
+// builder resolves Ingresses to a routing table
+type builder struct {
+	class    string
+	arch     string
+	services corelisters.ServiceLister
+	pods     corelisters.PodLister
+	nodes    corelisters.NodeLister
+}
+
+// ownsIngress reports whether the Ingress is for this controller's class
+func (b *builder) ownsIngress(ing *networkingv1.Ingress) bool {
+	if ing.Spec.IngressClassName != nil {
+		return *ing.Spec.IngressClassName == b.class
+	}
+	return ing.Annotations[legacyClassAnnotation] == b.class
+}
+
+// build returns the table for the Ingresses. Problems with single rules are
+// returned alongside the table rather than failing the whole build.
+func (b *builder) build(ingresses []*networkingv1.Ingress) (*table, []error) {
+	t := &table{endpoints: map[string][]string{}}
+	var errs []error
+	for _, ing := range ingresses {
+		if !b.ownsIngress(ing) {
+			continue
+		}
+		name := ing.Namespace + "/" + ing.Name
+		opts, err := routeOptions(ing)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ingress %s: %w", name, err))
+			continue
+		}
+		add := func(host, path string, pathType networkingv1.PathType, backend *networkingv1.IngressBackend) {
+			if backend.Service == nil {
+				errs = append(errs, fmt.Errorf("ingress %s: only service backends are supported", name))
+				return
+			}
+			key, addrs, err := b.resolve(ing.Namespace, backend.Service)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("ingress %s: %w", name, err))
+			}
+			t.endpoints[key] = addrs
+			r := opts
+			r.ingress, r.host, r.path, r.pathType, r.backend = name, strings.ToLower(host), path, pathType, key
+			t.routes = append(t.routes, r)
+		}
+		if ing.Spec.DefaultBackend != nil {
+			add("", "/", networkingv1.PathTypePrefix, ing.Spec.DefaultBackend)
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, p := range rule.HTTP.Paths {
+				pathType := networkingv1.PathTypePrefix
+				if p.PathType != nil && *p.PathType == networkingv1.PathTypeExact {
+					pathType = networkingv1.PathTypeExact
+				}
+				path := p.Path
+				if path == "" {
+					path = "/"
+				}
+				add(rule.Host, path, pathType, &p.Backend)
+			}
+		}
+	}
+	sort.SliceStable(t.routes, func(i, j int) bool { return precedence(t.routes[i], t.routes[j]) })
+	return t, errs
+}
+
+func routeOptions(ing *networkingv1.Ingress) (route, error) {
+	r := route{timeout: DefaultTimeout, healthPath: ing.Annotations[HealthCheckPathAnnotation]}
+	if s, ok := ing.Annotations[StripPrefixAnnotation]; ok {
+		strip, err := strconv.ParseBool(s)
+		if err != nil {
+			return route{}, fmt.Errorf("invalid %s annotation: %w", StripPrefixAnnotation, err)
+		}
+		r.stripPrefix = strip
+	}
+	if s, ok := ing.Annotations[TimeoutAnnotation]; ok {
+		timeout, err := time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			return route{}, fmt.Errorf("invalid %s annotation %q", TimeoutAnnotation, s)
+		}
+		r.timeout = timeout
+	}
+	if r.healthPath != "" && !strings.HasPrefix(r.healthPath, "/") {
+		return route{}, fmt.Errorf("%s must start with /", HealthCheckPathAnnotation)
+	}
+	return r, nil
+}
+
+// resolve returns the key and endpoint addresses for a backend: the running
+// pods on krustlet nodes that the Service selects, at the port the backend
+// names
+func (b *builder) resolve(namespace string, backend *networkingv1.IngressServiceBackend) (string, []string, error) {
+	port := backend.Port.Name
+	if port == "" {
+		port = strconv.Itoa(int(backend.Port.Number))
+	}
+	key := namespace + "/" + backend.Name + ":" + port
+
+	svc, err := b.services.Services(namespace).Get(backend.Name)
+	if err != nil {
+		return key, nil, fmt.Errorf("service %s/%s: %w", namespace, backend.Name, err)
+	}
+	var svcPort *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		p := &svc.Spec.Ports[i]
+		if (backend.Port.Name != "" && p.Name == backend.Port.Name) || (backend.Port.Name == "" && p.Port == backend.Port.Number) {
+			svcPort = p
+			break
+		}
+	}
+	if svcPort == nil {
+		return key, nil, fmt.Errorf("service %s/%s has no port %s", namespace, backend.Name, port)
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return key, nil, fmt.Errorf("service %s/%s has no selector", namespace, backend.Name)
+	}
+
+	pods, err := b.pods.Pods(namespace).List(labels.SelectorFromSet(svc.Spec.Selector))
+	if err != nil {
+		return key, nil, err
+	}
+	var addrs []string
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		node, err := b.nodes.Get(pod.Spec.NodeName)
+		if err != nil || node.Labels[archLabel] != b.arch {
+			continue
+		}
+		host := podHost(pod, node)
+		p, ok := targetPort(pod, svcPort)
+		if host == "" || !ok {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(p))))
+	}
+	sort.Strings(addrs)
+	return key, addrs, nil
+}
+
+// podHost returns the address a WAGI pod listens on. Krustlet doesn't assign
+// pod IPs, so this is normally the node's.
+func podHost(pod *corev1.Pod, node *corev1.Node) string {
+	if pod.Status.PodIP != "" {
+		return pod.Status.PodIP
+	}
+	if pod.Status.HostIP != "" {
+		return pod.Status.HostIP
+	}
+	for _, a := range node.Status.Addresses {
+		if a.Type == corev1.NodeInternalIP {
+			return a.Address
+		}
+	}
+	return ""
+}
+
+// targetPort resolves the Service port's targetPort against the pod's
+// container ports
+func targetPort(pod *corev1.Pod, svcPort *corev1.ServicePort) (int32, bool) {
+	target := svcPort.TargetPort
+	if target.Type == intstr.Int {
+		if target.IntVal == 0 {
+			return svcPort.Port, true
+		}
+		return target.IntVal, true
+	}
+	for _, c := range pod.Spec.Containers {
+		for _, p := range c.Ports {
+			if p.Name == target.StrVal {
+				return p.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
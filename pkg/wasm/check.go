@@ -0,0 +1,278 @@
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Provider is what one of krustlet's providers can run
+type Provider struct {
+	// Name is how the provider is chosen, such as "wasi"
+	Name string
+	// Runtime is the runtime the provider embeds, for messages
+	Runtime string
+	// Entrypoint is the export the provider calls to run a module. It must
+	// be a function that takes and returns nothing.
+	Entrypoint string
+	// Imports are the functions the provider links, by import module
+	Imports map[string][]string
+	// Unsupported are functions the provider links that always fail, by
+	// import module
+	Unsupported map[string][]string
+	// Threads, Memory64, MultiMemory and Exceptions are the proposals the
+	// runtime enables that a module's imports, memories and tags can need
+	Threads, Memory64, MultiMemory, Exceptions bool
+}
+
+// wasiFunctions are the functions of WASI preview 1 as wasmtime 0.30
+// implements them. The older wasi_unstable snapshot has the same names.
+var wasiFunctions = []string{
+	"args_get", "args_sizes_get", "environ_get", "environ_sizes_get", "clock_res_get", "clock_time_get",
+	"fd_advise", "fd_allocate", "fd_close", "fd_datasync", "fd_fdstat_get", "fd_fdstat_set_flags",
+	"fd_fdstat_set_rights", "fd_filestat_get", "fd_filestat_set_size", "fd_filestat_set_times", "fd_pread",
+	"fd_prestat_get", "fd_prestat_dir_name", "fd_pwrite", "fd_read", "fd_readdir", "fd_renumber", "fd_seek",
+	"fd_sync", "fd_tell", "fd_write", "path_create_directory", "path_filestat_get", "path_filestat_set_times",
+	"path_link", "path_open", "path_readlink", "path_remove_directory", "path_rename", "path_symlink",
+	"path_unlink_file", "poll_oneoff", "proc_exit", "proc_raise", "sched_yield", "random_get", "sock_recv",
+	"sock_send", "sock_shutdown",
+}
+
+// wasiUnsupported are the WASI functions wasi-common 0.30 links but answers
+// with ENOTSUP
+var wasiUnsupported = []string{"proc_raise", "sock_recv", "sock_send", "sock_shutdown"}
+
+// WASI is krustlet-wasi's provider, which runs WASI commands with wasmtime
+// 0.30 and links wasi-experimental-http 0.6 for outbound HTTP
+var WASI = Provider{
+	Name:       "wasi",
+	Runtime:    "wasmtime 0.30",
+	Entrypoint: "_start",
+	Imports: map[string][]string{
+		"wasi_snapshot_preview1": wasiFunctions,
+		"wasi_unstable":          wasiFunctions,
+		"wasi_experimental_http": {"req", "close", "header_get", "headers_get_all", "body_read"},
+	},
+	Unsupported: map[string][]string{
+		"wasi_snapshot_preview1": wasiUnsupported,
+		"wasi_unstable":          wasiUnsupported,
+	},
+}
+
+// Providers are the providers modules can be checked against, by name
+var Providers = map[string]*Provider{WASI.Name: &WASI}
+
+// Severity is how much a finding matters
+type Severity string
+
+// The severities of findings
+const (
+	// SeverityError is a finding that keeps the provider from running the
+	// module at all
+	SeverityError Severity = "error"
+	// SeverityWarning is a finding that can make the module fail once it
+	// runs
+	SeverityWarning Severity = "warning"
+	// SeverityInfo is worth knowing but doesn't stop the module
+	SeverityInfo Severity = "info"
+)
+
+// The checks Check makes
+const (
+	CheckFormat     = "format"
+	CheckImports    = "imports"
+	CheckEntrypoint = "entrypoint"
+	CheckStart      = "start"
+	CheckMemory     = "memory"
+	CheckFeatures   = "features"
+)
+
+// Finding is one thing Check found
+type Finding struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// Report is the outcome of Check
+type Report struct {
+	Provider string `json:"provider"`
+	// Component is set if the binary is a component rather than a core
+	// module, in which case nothing else is inspected
+	Component bool `json:"component"`
+	// Imports are the module's imports, as module.name
+	Imports []string `json:"imports,omitempty"`
+	// Memory is the module's first memory, defined or imported
+	Memory   *Memory   `json:"memory,omitempty"`
+	Findings []Finding `json:"findings"`
+}
+
+// Compatible reports whether nothing keeps the provider from running the
+// module
+func (r *Report) Compatible() bool {
+	for _, f := range r.Findings {
+		if f.Severity == SeverityError {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(check string, severity Severity, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// CheckOptions tune Check
+type CheckOptions struct {
+	// MemoryLimit is the memory limit of the pods the module runs in, in
+	// bytes. Memory the module needs beyond it is reported. 0 means none.
+	MemoryLimit int64
+}
+
+// pageSize is the size of a page of linear memory
+const pageSize = 64 * 1024
+
+// maxPages32 is how many pages a 32-bit memory without a maximum can grow to
+const maxPages32 = 65536
+
+// Check inspects a binary and reports whether the provider can run it. An
+// error is only returned for data that isn't a WebAssembly binary or can't
+// be parsed; everything else is a finding.
+func Check(data []byte, p *Provider, opts CheckOptions) (*Report, error) {
+	report := &Report{Provider: p.Name, Findings: []Finding{}}
+	m, err := Parse(data)
+	if errors.Is(err, ErrComponent) {
+		report.Component = true
+		report.add(CheckFormat, SeverityError, "binary is a WebAssembly component; the %s provider only runs core modules, so build for wasm32-wasi rather than a component target", p.Name)
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	checkImports(report, m, p)
+	checkEntrypoint(report, m, p)
+	if m.HasStart {
+		report.add(CheckStart, SeverityInfo, "module has a start function, which runs when it is instantiated, before %s; a trap in it is reported as a failure to instantiate the module", p.Entrypoint)
+	}
+	checkMemory(report, m, p, opts)
+	if m.Tags > 0 && !p.Exceptions {
+		report.add(CheckFeatures, SeverityError, "module defines exception tags, which %s doesn't support; build without exception handling", p.Runtime)
+	}
+	return report, nil
+}
+
+func checkImports(report *Report, m *Module, p *Provider) {
+	missing := map[string][]string{}
+	for _, imp := range m.Imports {
+		report.Imports = append(report.Imports, imp.Module+"."+imp.Name)
+		funcs, ok := p.Imports[imp.Module]
+		switch {
+		case imp.Kind != KindFunc:
+			report.add(CheckImports, SeverityError, "module imports %s %s.%s; the %s provider only provides functions", imp.Kind, imp.Module, imp.Name, p.Name)
+		case !ok || !contains(funcs, imp.Name):
+			missing[imp.Module] = append(missing[imp.Module], imp.Name)
+		case contains(p.Unsupported[imp.Module], imp.Name):
+			report.add(CheckImports, SeverityWarning, "%s.%s is linked but always fails with ENOTSUP in %s", imp.Module, imp.Name, p.Runtime)
+		}
+	}
+	modules := make([]string, 0, len(missing))
+	for module := range missing {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		names := strings.Join(missing[module], ", ")
+		if _, ok := p.Imports[module]; ok {
+			report.add(CheckImports, SeverityError, "module imports %s from %s, which %s doesn't provide; the module won't instantiate, so build it with an older toolchain or without the features that need them", names, module, p.Runtime)
+			continue
+		}
+		report.add(CheckImports, SeverityError, "module imports %s from %s, which the %s provider doesn't link; the module won't instantiate", names, module, p.Name)
+	}
+}
+
+func checkEntrypoint(report *Report, m *Module, p *Provider) {
+	e, ok := m.Export(p.Entrypoint)
+	if !ok {
+		if _, reactor := m.Export("_initialize"); reactor {
+			report.add(CheckEntrypoint, SeverityError, "module is a WASI reactor with _initialize rather than %s; the %s provider runs commands, so build a program with a main function", p.Entrypoint, p.Name)
+			return
+		}
+		report.add(CheckEntrypoint, SeverityError, "module has no %s function; the %s provider runs modules as WASI commands, so build a program with a main function", p.Entrypoint, p.Name)
+		return
+	}
+	if e.Kind != KindFunc {
+		report.add(CheckEntrypoint, SeverityError, "%s is a %s, not a function", p.Entrypoint, e.Kind)
+		return
+	}
+	if ft, ok := m.FuncType(e.Index); ok && (len(ft.Params) > 0 || len(ft.Results) > 0) {
+		report.add(CheckEntrypoint, SeverityError, "%s has type %s; the %s provider calls it with no arguments and expects no results", p.Entrypoint, ft, p.Name)
+	}
+}
+
+func checkMemory(report *Report, m *Module, p *Provider, opts CheckOptions) {
+	var memories []Memory
+	for _, imp := range m.Imports {
+		if imp.Memory != nil {
+			memories = append(memories, *imp.Memory)
+		}
+	}
+	memories = append(memories, m.Memories...)
+	if len(memories) == 0 {
+		return
+	}
+	report.Memory = &memories[0]
+	if len(memories) > 1 && !p.MultiMemory {
+		report.add(CheckFeatures, SeverityError, "module has %d memories, and %s doesn't support multiple memories", len(memories), p.Runtime)
+	}
+	for _, mem := range memories {
+		if mem.Shared && !p.Threads {
+			report.add(CheckFeatures, SeverityError, "module has a shared memory, which needs threads that %s doesn't support; build without threads or atomics", p.Runtime)
+		}
+		if mem.Memory64 && !p.Memory64 {
+			report.add(CheckFeatures, SeverityError, "module has a 64-bit memory, which %s doesn't support; build for wasm32", p.Runtime)
+		}
+	}
+
+	mem := memories[0]
+	if opts.MemoryLimit <= 0 || mem.Memory64 {
+		return
+	}
+	initial := int64(mem.Min) * pageSize
+	if initial > opts.MemoryLimit {
+		report.add(CheckMemory, SeverityError, "module starts with %s of memory, more than the memory limit of %s", formatBytes(initial), formatBytes(opts.MemoryLimit))
+		return
+	}
+	max, bound := int64(maxPages32)*pageSize, "can grow to"
+	if mem.HasMax {
+		max = int64(mem.Max) * pageSize
+	} else {
+		bound = "has no maximum, so can grow to"
+	}
+	if max > opts.MemoryLimit {
+		report.add(CheckMemory, SeverityWarning, "module starts with %s of memory and %s %s, more than the memory limit of %s", formatBytes(initial), bound, formatBytes(max), formatBytes(opts.MemoryLimit))
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// formatBytes formats a size in the binary units memory limits are given in
+func formatBytes(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"Gi", 1 << 30}, {"Mi", 1 << 20}, {"Ki", 1 << 10}} {
+		if n >= unit.size && n%unit.size == 0 {
+			return fmt.Sprintf("%d%s", n/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d", n)
+}
@@ -0,0 +1,146 @@
+package wasm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+// section encodes a section holding a vector of the entries
+func section(id byte, entries ...[]byte) []byte {
+	body := []byte{byte(len(entries))}
+	for _, e := range entries {
+		body = append(body, e...)
+	}
+	return append([]byte{id, byte(len(body))}, body...)
+}
+
+func encName(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func funcImport(module, name string, typ byte) []byte {
+	return append(append(encName(module), encName(name)...), byte(KindFunc), typ)
+}
+
+func export(name string, kind Kind, index byte) []byte {
+	return append(encName(name), byte(kind), index)
+}
+
+// command builds a module exporting _start of the type, with the imports
+// and memory given
+func command(startType []byte, memory []byte, imports ...[]byte) []byte {
+	data := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	data = append(data, section(sectionType, []byte{0x60, 0x00, 0x00}, startType)...)
+	if len(imports) > 0 {
+		data = append(data, section(sectionImport, imports...)...)
+	}
+	data = append(data, section(sectionFunction, []byte{0x01})...)
+	if memory != nil {
+		data = append(data, section(sectionMemory, memory)...)
+	}
+	return append(data, section(sectionExport, export("_start", KindFunc, byte(len(imports))))...)
+}
+
+func findings(r *Report, severity Severity) []string {
+	var out []string
+	for _, f := range r.Findings {
+		if f.Severity == severity {
+			out = append(out, f.Check+": "+f.Message)
+		}
+	}
+	return out
+}
+
+func TestCheckCompatible(t *testing.T) {
+	r, err := Check(ocitest.HelloWasm(), &WASI, CheckOptions{MemoryLimit: 64 * 1024 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Compatible() || len(r.Findings) != 1 || r.Findings[0].Check != CheckMemory {
+		t.Errorf("expected a WASI command to be compatible with a warning about its unbounded memory, got %+v", r.Findings)
+	}
+	if len(r.Imports) != 1 || r.Imports[0] != "wasi_snapshot_preview1.fd_write" || r.Memory == nil || r.Memory.Min != 1 {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
+
+func TestCheckImports(t *testing.T) {
+	data := command([]byte{0x60, 0x00, 0x00}, nil,
+		funcImport("wasi_snapshot_preview1", "fd_write", 0),
+		funcImport("wasi_snapshot_preview1", "sock_accept", 0),
+		funcImport("wasi_snapshot_preview1", "sock_recv", 0),
+		funcImport("env", "host_log", 0),
+	)
+	r, err := Check(data, &WASI, CheckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := findings(r, SeverityError)
+	if len(errs) != 2 || !strings.Contains(errs[0], "host_log from env") || !strings.Contains(errs[1], "sock_accept from wasi_snapshot_preview1, which wasmtime 0.30") {
+		t.Errorf("expected the missing imports to be errors, got %v", errs)
+	}
+	if warnings := findings(r, SeverityWarning); len(warnings) != 1 || !strings.Contains(warnings[0], "sock_recv") {
+		t.Errorf("expected unsupported functions to be warned about, got %v", warnings)
+	}
+	if r.Compatible() {
+		t.Error("expected the module to be incompatible")
+	}
+}
+
+func TestCheckEntrypoint(t *testing.T) {
+	r, _ := Check(command([]byte{0x60, 0x01, byte(I32), 0x00}, nil), &WASI, CheckOptions{})
+	if errs := findings(r, SeverityError); len(errs) != 1 || !strings.Contains(errs[0], "(i32) -> ()") {
+		t.Errorf("expected a _start that takes arguments to be rejected, got %v", errs)
+	}
+
+	reactor := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	reactor = append(reactor, section(sectionType, []byte{0x60, 0x00, 0x00})...)
+	reactor = append(reactor, section(sectionFunction, []byte{0x00})...)
+	reactor = append(reactor, section(sectionExport, export("_initialize", KindFunc, 0))...)
+	r, _ = Check(reactor, &WASI, CheckOptions{})
+	if errs := findings(r, SeverityError); len(errs) != 1 || !strings.Contains(errs[0], "reactor") {
+		t.Errorf("expected a reactor to be rejected, got %v", errs)
+	}
+
+	r, _ = Check([]byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}, &WASI, CheckOptions{})
+	if !r.Component || r.Compatible() {
+		t.Errorf("expected a component to be rejected, got %+v", r)
+	}
+}
+
+func TestCheckMemory(t *testing.T) {
+	// 256 pages is 16Mi
+	big := []byte{0x00, 0x80, 0x02}
+	r, _ := Check(command([]byte{0x60, 0x00, 0x00}, big), &WASI, CheckOptions{MemoryLimit: 8 << 20})
+	if errs := findings(r, SeverityError); len(errs) != 1 || !strings.Contains(errs[0], "starts with 16Mi of memory, more than the memory limit of 8Mi") {
+		t.Errorf("expected memory over the limit to be rejected, got %v", errs)
+	}
+	bounded := []byte{0x01, 0x01, 0x10}
+	r, _ = Check(command([]byte{0x60, 0x00, 0x00}, bounded), &WASI, CheckOptions{MemoryLimit: 8 << 20})
+	if len(r.Findings) != 0 {
+		t.Errorf("expected a memory that fits the limit to pass, got %v", r.Findings)
+	}
+	shared := []byte{0x03, 0x01, 0x10}
+	r, _ = Check(command([]byte{0x60, 0x00, 0x00}, shared), &WASI, CheckOptions{})
+	if errs := findings(r, SeverityError); len(errs) != 1 || !strings.Contains(errs[0], "threads") {
+		t.Errorf("expected a shared memory to be rejected, got %v", errs)
+	}
+}
+
+func TestFuncType(t *testing.T) {
+	m, err := Parse(command([]byte{0x60, 0x02, byte(I32), byte(I64), 0x01, byte(F64)}, nil, funcImport("wasi_snapshot_preview1", "proc_exit", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ft, ok := m.FuncType(0); !ok || ft.String() != "() -> ()" {
+		t.Errorf("got %v, %t for the imported function", ft, ok)
+	}
+	if ft, ok := m.FuncType(1); !ok || ft.String() != "(i32, i64) -> (f64)" {
+		t.Errorf("got %v, %t for the defined function", ft, ok)
+	}
+	if _, ok := m.FuncType(2); ok {
+		t.Error("expected no function at index 2")
+	}
+}
@@ -0,0 +1,412 @@
+// Package wasm reads the structure of WebAssembly binaries: what a module
+// imports and exports and the memory it declares. It doesn't validate code,
+// only enough of the binary format to tell whether krustlet can run a
+// module before it is pushed. Check reports whether one of krustlet's
+// providers can.
+package wasm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kind is the kind of an import or export
+type Kind byte
+
+// The kinds of imports and exports
+const (
+	KindFunc   Kind = 0
+	KindTable  Kind = 1
+	KindMemory Kind = 2
+	KindGlobal Kind = 3
+	KindTag    Kind = 4
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindFunc:
+		return "func"
+	case KindTable:
+		return "table"
+	case KindMemory:
+		return "memory"
+	case KindGlobal:
+		return "global"
+	case KindTag:
+		return "tag"
+	}
+	return fmt.Sprintf("kind(%d)", byte(k))
+}
+
+// Import is something a module needs from its host
+type Import struct {
+	Module string
+	Name   string
+	Kind   Kind
+	// Type is the index of a function import's type
+	Type uint32
+	// Memory is set for memory imports
+	Memory *Memory
+}
+
+// Export is something a module provides to its host
+type Export struct {
+	Name  string
+	Kind  Kind
+	Index uint32
+}
+
+// Memory is a memory's limits, in 64KiB pages
+type Memory struct {
+	Min uint64
+	// Max is only meaningful if HasMax is set
+	Max      uint64
+	HasMax   bool
+	Shared   bool
+	Memory64 bool
+}
+
+// ValType is the type of a value, such as a parameter
+type ValType byte
+
+// The value types numbers and references are written with
+const (
+	I32       ValType = 0x7f
+	I64       ValType = 0x7e
+	F32       ValType = 0x7d
+	F64       ValType = 0x7c
+	V128      ValType = 0x7b
+	FuncRef   ValType = 0x70
+	ExternRef ValType = 0x6f
+)
+
+func (t ValType) String() string {
+	switch t {
+	case I32:
+		return "i32"
+	case I64:
+		return "i64"
+	case F32:
+		return "f32"
+	case F64:
+		return "f64"
+	case V128:
+		return "v128"
+	case FuncRef:
+		return "funcref"
+	case ExternRef:
+		return "externref"
+	}
+	return fmt.Sprintf("valtype(0x%02x)", byte(t))
+}
+
+// FuncType is a function's signature
+type FuncType struct {
+	Params  []ValType
+	Results []ValType
+}
+
+func (f FuncType) String() string {
+	return "(" + joinTypes(f.Params) + ") -> (" + joinTypes(f.Results) + ")"
+}
+
+func joinTypes(types []ValType) string {
+	s := make([]string, len(types))
+	for i, t := range types {
+		s[i] = t.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Module is the structure of a core WebAssembly module
+type Module struct {
+	// Types are the function types of the type section. Types in forms
+	// Parse doesn't know, such as those of the GC proposal, end the list
+	// early, so a function's type may not be found.
+	Types []FuncType
+	// Functions are the type indices of the functions the module defines
+	Functions []uint32
+	Imports   []Import
+	Exports   []Export
+	// Memories are the memories the module defines, not those it imports
+	Memories []Memory
+	// Tags is how many exception tags the module defines
+	Tags int
+	// Start is the index of the start function, if HasStart is set. This is
+	// the module's start section, not a WASI _start export.
+	Start    uint32
+	HasStart bool
+	// CustomSections are the names of the custom sections, in order
+	CustomSections []string
+}
+
+// ErrComponent is returned when a binary is a component rather than a core
+// module
+var ErrComponent = errors.New("binary is a WebAssembly component, not a core module")
+
+// ErrNotWasm is returned when data isn't a WebAssembly binary at all
+var ErrNotWasm = errors.New("not a WebAssembly binary")
+
+var (
+	magic       = []byte("\x00asm")
+	coreVersion = []byte{0x01, 0x00, 0x00, 0x00}
+	// componentVersion is version 0xd and layer 1, which is how the
+	// component model's binary format tells itself apart
+	componentVersion = []byte{0x0d, 0x00, 0x01, 0x00}
+)
+
+// Section IDs read by Parse
+const (
+	sectionCustom   = 0
+	sectionType     = 1
+	sectionImport   = 2
+	sectionFunction = 3
+	sectionMemory   = 5
+	sectionExport   = 7
+	sectionStart    = 8
+	sectionTag      = 13
+)
+
+// funcTypeForm starts a function type in the type section
+const funcTypeForm = 0x60
+
+// Parse reads the structure of a core module
+func Parse(data []byte) (*Module, error) {
+	if len(data) < 8 || string(data[:4]) != string(magic) {
+		return nil, ErrNotWasm
+	}
+	switch version := data[4:8]; {
+	case string(version) == string(componentVersion):
+		return nil, ErrComponent
+	case string(version) != string(coreVersion):
+		return nil, fmt.Errorf("unsupported WebAssembly version % x", version)
+	}
+
+	m := &Module{}
+	r := &reader{data: data, pos: 8}
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+		s := &reader{data: body}
+		switch id {
+		case sectionCustom:
+			err = m.readCustom(s)
+		case sectionType:
+			err = m.readTypes(s)
+		case sectionImport:
+			err = m.readImports(s)
+		case sectionFunction:
+			err = m.readFunctions(s)
+		case sectionMemory:
+			err = m.readMemories(s)
+		case sectionExport:
+			err = m.readExports(s)
+		case sectionStart:
+			m.Start, err = s.u32()
+			m.HasStart = true
+		case sectionTag:
+			var n uint32
+			n, err = s.u32()
+			m.Tags = int(n)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("section %d: %w", id, err)
+		}
+	}
+	return m, nil
+}
+
+// Export returns the export with the name
+func (m *Module) Export(name string) (Export, bool) {
+	for _, e := range m.Exports {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Export{}, false
+}
+
+// FuncType returns the type of the function with the index, which counts
+// imported functions before the ones the module defines
+func (m *Module) FuncType(index uint32) (FuncType, bool) {
+	typeIndex, found := uint32(0), false
+	for _, imp := range m.Imports {
+		if imp.Kind != KindFunc {
+			continue
+		}
+		if index == 0 {
+			typeIndex, found = imp.Type, true
+			break
+		}
+		index--
+	}
+	if !found {
+		if int(index) >= len(m.Functions) {
+			return FuncType{}, false
+		}
+		typeIndex = m.Functions[index]
+	}
+	if int(typeIndex) >= len(m.Types) {
+		return FuncType{}, false
+	}
+	return m.Types[typeIndex], true
+}
+
+// ImportModules returns the modules the module imports from, such as
+// wasi_snapshot_preview1, in the order they first appear
+func (m *Module) ImportModules() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, i := range m.Imports {
+		if !seen[i.Module] {
+			seen[i.Module] = true
+			out = append(out, i.Module)
+		}
+	}
+	return out
+}
+
+func (m *Module) readCustom(r *reader) error {
+	name, err := r.name()
+	if err != nil {
+		return err
+	}
+	m.CustomSections = append(m.CustomSections, name)
+	return nil
+}
+
+func (m *Module) readImports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var imp Import
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		imp.Kind = Kind(kind)
+		switch imp.Kind {
+		case KindFunc:
+			imp.Type, err = r.u32()
+		case KindTable:
+			if _, err = r.byte(); err == nil {
+				_, err = r.limits()
+			}
+		case KindMemory:
+			var mem Memory
+			mem, err = r.limits()
+			imp.Memory = &mem
+		case KindGlobal:
+			_, err = r.bytes(2)
+		case KindTag:
+			if _, err = r.byte(); err == nil {
+				_, err = r.u32()
+			}
+		default:
+			err = fmt.Errorf("unknown import kind %d", kind)
+		}
+		if err != nil {
+			return err
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	return nil
+}
+
+func (m *Module) readTypes(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != funcTypeForm {
+			return nil
+		}
+		var ft FuncType
+		var ok bool
+		if ft.Params, ok, err = r.valTypes(); err != nil || !ok {
+			return err
+		}
+		if ft.Results, ok, err = r.valTypes(); err != nil || !ok {
+			return err
+		}
+		m.Types = append(m.Types, ft)
+	}
+	return nil
+}
+
+func (m *Module) readFunctions(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		t, err := r.u32()
+		if err != nil {
+			return err
+		}
+		m.Functions = append(m.Functions, t)
+	}
+	return nil
+}
+
+func (m *Module) readMemories(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		mem, err := r.limits()
+		if err != nil {
+			return err
+		}
+		m.Memories = append(m.Memories, mem)
+	}
+	return nil
+}
+
+func (m *Module) readExports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var e Export
+		if e.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		e.Kind = Kind(kind)
+		if e.Index, err = r.u32(); err != nil {
+			return err
+		}
+		m.Exports = append(m.Exports, e)
+	}
+	return nil
+}
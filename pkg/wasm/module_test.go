@@ -0,0 +1,76 @@
+package wasm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/krustlet/krustlet/pkg/oci/ocitest"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse(ocitest.HelloWasm())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Imports) != 1 || m.Imports[0].Module != "wasi_snapshot_preview1" || m.Imports[0].Name != "fd_write" || m.Imports[0].Kind != KindFunc {
+		t.Errorf("unexpected imports %+v", m.Imports)
+	}
+	if got := m.ImportModules(); len(got) != 1 || got[0] != "wasi_snapshot_preview1" {
+		t.Errorf("unexpected import modules %v", got)
+	}
+	if len(m.Memories) != 1 || m.Memories[0].Min != 1 || m.Memories[0].HasMax {
+		t.Errorf("unexpected memories %+v", m.Memories)
+	}
+	if e, ok := m.Export("_start"); !ok || e.Kind != KindFunc || e.Index != 1 {
+		t.Errorf("unexpected _start export %+v, %v", e, ok)
+	}
+	if e, ok := m.Export("memory"); !ok || e.Kind != KindMemory {
+		t.Errorf("unexpected memory export %+v, %v", e, ok)
+	}
+	if m.HasStart {
+		t.Error("module has no start section")
+	}
+}
+
+func TestParseImportedMemory(t *testing.T) {
+	data := []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		// import section: env.memory, shared, min 2 max 16
+		0x02, 0x10, 0x01,
+		0x03, 'e', 'n', 'v', 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x03, 0x02, 0x10,
+		// custom section "name"
+		0x00, 0x05, 0x04, 'n', 'a', 'm', 'e',
+	}
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := m.Imports[0].Memory
+	if mem == nil || mem.Min != 2 || !mem.HasMax || mem.Max != 16 || !mem.Shared {
+		t.Errorf("unexpected imported memory %+v", mem)
+	}
+	if len(m.CustomSections) != 1 || m.CustomSections[0] != "name" {
+		t.Errorf("unexpected custom sections %v", m.CustomSections)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrNotWasm},
+		{"text", []byte("(module)"), ErrNotWasm},
+		{"component", []byte{0x00, 0x61, 0x73, 0x6d, 0x0d, 0x00, 0x01, 0x00}, ErrComponent},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	truncated := ocitest.HelloWasm()
+	if _, err := Parse(truncated[:len(truncated)-3]); err == nil {
+		t.Error("expected an error for a truncated module")
+	}
+}
@@ -0,0 +1,126 @@
+package wasm
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of data")
+
+// reader reads the primitive values of the binary format
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.done() {
+		return 0, errUnexpectedEnd
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, errUnexpectedEnd
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uleb reads an unsigned LEB128 value of at most bits bits
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var v uint64
+	for shift := uint(0); ; shift += 7 {
+		if shift >= bits {
+			return 0, errors.New("integer too large")
+		}
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if bits < 64 && v>>bits != 0 {
+				return 0, errors.New("integer too large")
+			}
+			return v, nil
+		}
+	}
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(n))
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", errors.New("name is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+// valTypes reads a vector of value types. It reports false, without an
+// error, on a type it doesn't know, whose encoding may be longer than a byte.
+func (r *reader) valTypes() ([]ValType, bool, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, false, err
+	}
+	types := make([]ValType, 0, n)
+	for i := uint32(0); i < n; i++ {
+		b, err := r.byte()
+		if err != nil {
+			return nil, false, err
+		}
+		switch t := ValType(b); t {
+		case I32, I64, F32, F64, V128, FuncRef, ExternRef:
+			types = append(types, t)
+		default:
+			return nil, false, nil
+		}
+	}
+	return types, true, nil
+}
+
+// limits reads a memory or table's limits. The flags also say whether a
+// memory is shared or 64-bit.
+func (r *reader) limits() (Memory, error) {
+	flags, err := r.byte()
+	if err != nil {
+		return Memory{}, err
+	}
+	if flags > 0x07 {
+		return Memory{}, errors.New("invalid limits")
+	}
+	mem := Memory{HasMax: flags&0x01 != 0, Shared: flags&0x02 != 0, Memory64: flags&0x04 != 0}
+	bits := uint(32)
+	if mem.Memory64 {
+		bits = 64
+	}
+	if mem.Min, err = r.uleb(bits); err != nil {
+		return Memory{}, err
+	}
+	if mem.HasMax {
+		if mem.Max, err = r.uleb(bits); err != nil {
+			return Memory{}, err
+		}
+	}
+	return mem, nil
+}
@@ -0,0 +1,216 @@
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Content types for Client.Patch.
+const (
+	JSONPatch           = "application/json-patch+json"
+	MergePatch          = "application/merge-patch+json"
+	StrategicMergePatch = "application/strategic-merge-patch+json"
+	// ApplyPatch needs a fieldManager in the path's query string.
+	ApplyPatch = "application/apply-patch+yaml"
+)
+
+// Request is a request for a Transport to send.
+type Request struct {
+	Method string
+	URL    string
+	Header map[string]string
+	Body   []byte
+}
+
+// Response is a response with the whole of its body. Header only has the
+// headers Client uses, keyed by their lower case names.
+type Response struct {
+	StatusCode int
+	Header     map[string]string
+	Body       []byte
+}
+
+// Transport sends requests. HostTransport sends them through the host.
+type Transport interface {
+	RoundTrip(req *Request) (*Response, error)
+}
+
+// Client sends requests to the API server.
+type Client struct {
+	config *Config
+	// Transport defaults to HostTransport.
+	Transport Transport
+}
+
+// New returns a client for the API server in config.
+func New(config *Config) *Client {
+	return &Client{config: config, Transport: HostTransport{}}
+}
+
+// Namespace returns the namespace in the client's config, which for
+// InClusterConfig is the pod's own.
+func (c *Client) Namespace() string {
+	return c.config.Namespace
+}
+
+// Path returns the path of a resource, or of a collection if name is empty,
+// such as /apis/apps/v1/namespaces/default/deployments/web for
+// Path("apps/v1", "default", "deployments", "web"). Resources that aren't
+// namespaced have an empty namespace. Append subresources, such as
+// "/status", and query strings to it.
+func Path(apiVersion, namespace, resource, name string) string {
+	p := "/apis/" + apiVersion
+	if !strings.Contains(apiVersion, "/") {
+		// The core group
+		p = "/api/" + apiVersion
+	}
+	if namespace != "" {
+		p += "/namespaces/" + namespace
+	}
+	p += "/" + resource
+	if name != "" {
+		p += "/" + name
+	}
+	return p
+}
+
+// Do sends a request for path, which includes any query string, with the
+// service account's token. Responses with a status of 300 or more are
+// returned as a *StatusError.
+func (c *Client) Do(method, path, contentType string, body []byte) (*Response, error) {
+	token, err := c.config.token()
+	if err != nil {
+		return nil, err
+	}
+	header := map[string]string{"Accept": "application/json"}
+	if token != "" {
+		header["Authorization"] = "Bearer " + token
+	}
+	if contentType != "" {
+		header["Content-Type"] = contentType
+	}
+	if c.config.UserAgent != "" {
+		header["User-Agent"] = c.config.UserAgent
+	}
+
+	res, err := c.Transport.RoundTrip(&Request{
+		Method: method,
+		URL:    strings.TrimSuffix(c.config.Host, "/") + path,
+		Header: header,
+		Body:   body,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("kube: %s %s: %w", method, path, err)
+	}
+	if res.StatusCode >= 300 {
+		return nil, newStatusError(method, path, res)
+	}
+	return res, nil
+}
+
+// Get decodes the resource or collection at path into out.
+func (c *Client) Get(path string, out any) error {
+	return c.send("GET", path, "", nil, out)
+}
+
+// Create posts obj to the collection at path, decoding the created resource
+// into out unless it is nil.
+func (c *Client) Create(path string, obj, out any) error {
+	return c.sendJSON("POST", path, obj, out)
+}
+
+// Update replaces the resource at path with obj, decoding the result into
+// out unless it is nil. Set the metadata.resourceVersion of obj to the one
+// read to fail with a conflict if it changed since.
+func (c *Client) Update(path string, obj, out any) error {
+	return c.sendJSON("PUT", path, obj, out)
+}
+
+// Patch patches the resource at path, decoding the result into out unless
+// it is nil. patchType is one of JSONPatch, MergePatch, StrategicMergePatch
+// or ApplyPatch.
+func (c *Client) Patch(path, patchType string, patch []byte, out any) error {
+	return c.send("PATCH", path, patchType, patch, out)
+}
+
+// Delete deletes the resource at path.
+func (c *Client) Delete(path string) error {
+	return c.send("DELETE", path, "", nil, nil)
+}
+
+func (c *Client) sendJSON(method, path string, obj, out any) error {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.send(method, path, "application/json", body, out)
+}
+
+func (c *Client) send(method, path, contentType string, body []byte, out any) error {
+	res, err := c.Do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(res.Body, out); err != nil {
+		return fmt.Errorf("kube: %s %s: decoding the response: %w", method, path, err)
+	}
+	return nil
+}
+
+// StatusError is the error the API server responded with.
+type StatusError struct {
+	Method, Path string
+	// Code is the HTTP status code.
+	Code int
+	// Reason is the reason in the Status the API server returned, such as
+	// NotFound or Conflict, if it returned one.
+	Reason  string
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Reason
+	}
+	return fmt.Sprintf("kube: %s %s: %d %s", e.Method, e.Path, e.Code, msg)
+}
+
+func newStatusError(method, path string, res *Response) *StatusError {
+	err := &StatusError{Method: method, Path: path, Code: res.StatusCode}
+	// Errors are usually a Status, but proxies in front of the API server
+	// can return anything
+	var status struct {
+		Kind    string `json:"kind"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(res.Body, &status) == nil && status.Kind == "Status" {
+		err.Reason, err.Message = status.Reason, status.Message
+	} else {
+		err.Message = strings.TrimSpace(string(res.Body))
+	}
+	return err
+}
+
+func hasCode(err error, code int) bool {
+	var s *StatusError
+	return errors.As(err, &s) && s.Code == code
+}
+
+// IsNotFound reports whether err is a 404 from the API server.
+func IsNotFound(err error) bool { return hasCode(err, 404) }
+
+// IsConflict reports whether err is a 409 from the API server, which is
+// returned both for updates of a resource that changed since it was read and
+// for creating a resource that already exists.
+func IsConflict(err error) bool { return hasCode(err, 409) }
+
+// IsForbidden reports whether err is a 403 from the API server, returned when
+// the service account's RBAC rules don't allow the request.
+func IsForbidden(err error) bool { return hasCode(err, 403) }
@@ -0,0 +1,115 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTransport records requests and replies with a canned response
+type fakeTransport struct {
+	requests []*Request
+	res      *Response
+}
+
+func (f *fakeTransport) RoundTrip(r *Request) (*Response, error) {
+	f.requests = append(f.requests, r)
+	return f.res, nil
+}
+
+func newTestClient(t *testing.T, res *Response) (*Client, *fakeTransport, string) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeTransport{res: res}
+	c := New(&Config{Host: "https://10.0.0.1:6443/", TokenFile: tokenFile, Namespace: "default", UserAgent: "controller"})
+	c.Transport = f
+	return c, f, tokenFile
+}
+
+func TestPath(t *testing.T) {
+	for _, tt := range []struct {
+		apiVersion, namespace, resource, name, want string
+	}{
+		{"v1", "default", "pods", "web", "/api/v1/namespaces/default/pods/web"},
+		{"v1", "", "nodes", "", "/api/v1/nodes"},
+		{"apps/v1", "default", "deployments", "", "/apis/apps/v1/namespaces/default/deployments"},
+	} {
+		if got := Path(tt.apiVersion, tt.namespace, tt.resource, tt.name); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	c, f, tokenFile := newTestClient(t, &Response{StatusCode: 200, Body: []byte(`{"metadata":{"name":"web"}}`)})
+	var pod struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	if err := c.Get("/api/v1/namespaces/default/pods/web", &pod); err != nil {
+		t.Fatal(err)
+	}
+	if pod.Metadata.Name != "web" {
+		t.Errorf("unexpected pod %+v", pod)
+	}
+	r := f.requests[0]
+	if r.Method != "GET" || r.URL != "https://10.0.0.1:6443/api/v1/namespaces/default/pods/web" {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	}
+	if r.Header["Authorization"] != "Bearer token-1" || r.Header["User-Agent"] != "controller" {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+
+	// The token is read again for each request
+	if err := os.WriteFile(tokenFile, []byte("token-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get("/api/v1/namespaces/default/pods/web", &pod); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.requests[1].Header["Authorization"]; got != "Bearer token-2" {
+		t.Errorf("expected the rewritten token to be used, got %q", got)
+	}
+}
+
+func TestCreateAndPatch(t *testing.T) {
+	c, f, _ := newTestClient(t, &Response{StatusCode: 201, Body: []byte(`{}`)})
+	cm := map[string]any{"metadata": map[string]any{"name": "state"}, "data": map[string]string{"count": "1"}}
+	if err := c.Create(Path("v1", c.Namespace(), "configmaps", ""), cm, nil); err != nil {
+		t.Fatal(err)
+	}
+	r := f.requests[0]
+	if r.Method != "POST" || r.Header["Content-Type"] != "application/json" || !strings.Contains(string(r.Body), `"count":"1"`) {
+		t.Errorf("unexpected create %s %v %s", r.Method, r.Header, r.Body)
+	}
+
+	if err := c.Patch(Path("v1", c.Namespace(), "configmaps", "state"), MergePatch, []byte(`{"data":{"count":"2"}}`), nil); err != nil {
+		t.Fatal(err)
+	}
+	if r := f.requests[1]; r.Method != "PATCH" || r.Header["Content-Type"] != MergePatch {
+		t.Errorf("unexpected patch %s %v", r.Method, r.Header)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	c, _, _ := newTestClient(t, &Response{
+		StatusCode: 404,
+		Body:       []byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"pods \"web\" not found","reason":"NotFound","code":404}`),
+	})
+	err := c.Delete("/api/v1/namespaces/default/pods/web")
+	if !IsNotFound(err) || IsConflict(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if want := `kube: DELETE /api/v1/namespaces/default/pods/web: 404 pods "web" not found`; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+
+	c, _, _ = newTestClient(t, &Response{StatusCode: 502, Body: []byte("bad gateway\n")})
+	if err := c.Get("/api/v1/nodes", nil); err == nil || !strings.HasSuffix(err.Error(), "502 bad gateway") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
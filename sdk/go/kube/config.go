@@ -0,0 +1,113 @@
+// Package kube is a small Kubernetes API client for WebAssembly modules
+// running on krustlet.
+//
+// Kubernetes mounts a service account token, the cluster's CA certificate
+// and the pod's namespace into every pod that doesn't opt out, and krustlet
+// passes that volume to the module like any other. InClusterConfig reads
+// them, and a Client sends requests authenticated with the token through the
+// outbound HTTP host functions (wasi-experimental-http) the wasi-provider
+// links into every module:
+//
+//	config, err := kube.InClusterConfig()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	client := kube.New(config)
+//	var pod map[string]any
+//	err = client.Get(kube.Path("v1", client.Namespace(), "pods", "my-pod"), &pod)
+//
+// Like the http-golang demo, the package has no dependencies and avoids
+// net/http, so modules can be built with GOOS=wasip1 GOARCH=wasm or with
+// TinyGo. Decode responses into your own structs, or into maps, rather than
+// the types in k8s.io/api, which are too large for most modules.
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The paths the service account volume is mounted at.
+const (
+	ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	TokenFile         = ServiceAccountDir + "/token"
+	CAFile            = ServiceAccountDir + "/ca.crt"
+	NamespaceFile     = ServiceAccountDir + "/namespace"
+)
+
+// ErrNotInCluster is returned by InClusterConfig when the API server's
+// address isn't known.
+var ErrNotInCluster = errors.New("kube: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set to the API server's address; krustlet doesn't set them, so add them to the container's env")
+
+// Config says how to reach and authenticate to the API server.
+type Config struct {
+	// Host is the API server's URL, such as https://10.0.0.1:6443. The
+	// pod's alpha.wasi.krustlet.dev/allowed-domains annotation must list
+	// it for the host to allow requests to it.
+	Host string
+	// TokenFile is read for the bearer token before each request, so a
+	// token that is rewritten is picked up. BearerToken is used if it is
+	// empty.
+	TokenFile   string
+	BearerToken string
+	// CAData is the cluster's CA certificate, in PEM. The wasi-provider's
+	// host functions verify the API server's certificate against the trust
+	// store of the node krustlet runs on and can't be given a CA, so it is
+	// only used by transports that can.
+	CAData []byte
+	// Namespace is the pod's namespace.
+	Namespace string
+	// UserAgent is sent with each request.
+	UserAgent string
+}
+
+// InClusterConfig returns the config for the service account mounted into
+// the pod and the API server at KUBERNETES_SERVICE_HOST and
+// KUBERNETES_SERVICE_PORT.
+func InClusterConfig() (*Config, error) {
+	return inClusterConfig(ServiceAccountDir, os.Getenv)
+}
+
+func inClusterConfig(dir string, getenv func(string) string) (*Config, error) {
+	host, port := getenv("KUBERNETES_SERVICE_HOST"), getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+	if strings.Contains(host, ":") {
+		// An IPv6 address
+		host = "[" + host + "]"
+	}
+
+	tokenFile := dir + "/token"
+	if _, err := os.Stat(tokenFile); err != nil {
+		return nil, fmt.Errorf("kube: no service account token is mounted; is automountServiceAccountToken false? %w", err)
+	}
+	ca, err := os.ReadFile(dir + "/ca.crt")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("kube: reading the cluster's CA certificate: %w", err)
+	}
+	namespace, err := os.ReadFile(dir + "/namespace")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("kube: reading the pod's namespace: %w", err)
+	}
+	return &Config{
+		Host:      "https://" + host + ":" + port,
+		TokenFile: tokenFile,
+		CAData:    ca,
+		Namespace: strings.TrimSpace(string(namespace)),
+	}, nil
+}
+
+// token returns the bearer token to send.
+func (c *Config) token() (string, error) {
+	if c.TokenFile == "" {
+		return c.BearerToken, nil
+	}
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("kube: reading the service account token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,36 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInClusterConfig(t *testing.T) {
+	dir := t.TempDir()
+	env := map[string]string{"KUBERNETES_SERVICE_HOST": "fd00::1", "KUBERNETES_SERVICE_PORT": "443"}
+	getenv := func(k string) string { return env[k] }
+	if _, err := inClusterConfig(dir, getenv); err == nil {
+		t.Fatal("expected an error without a token")
+	}
+
+	for name, data := range map[string]string{"token": "abc", "ca.crt": "pem", "namespace": "apps\n"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := inClusterConfig(dir, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://[fd00::1]:443" || config.Namespace != "apps" || string(config.CAData) != "pem" {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if token, _ := config.token(); token != "abc" {
+		t.Errorf("unexpected token %q", token)
+	}
+
+	if _, err := inClusterConfig(dir, func(string) string { return "" }); err != ErrNotInCluster {
+		t.Errorf("expected ErrNotInCluster, got %v", err)
+	}
+}
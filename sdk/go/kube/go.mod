@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/sdk/go/kube
+
+go 1.21
@@ -0,0 +1,10 @@
+package kube
+
+// HostTransport sends requests through the wasi-experimental-http host
+// functions. The host only allows requests to the domains in the pod's
+// alpha.wasi.krustlet.dev/allowed-domains annotation, and verifies TLS
+// certificates against the trust store of the node krustlet runs on.
+//
+// It is only implemented when built with GOOS=wasip1; elsewhere, such as in
+// a module's tests, RoundTrip returns an error.
+type HostTransport struct{}
@@ -0,0 +1,12 @@
+//go:build !wasip1
+
+package kube
+
+import "errors"
+
+// RoundTrip is only implemented for wasip1, where the host provides the
+// wasi-experimental-http functions. This lets modules using the package
+// build and test natively.
+func (HostTransport) RoundTrip(*Request) (*Response, error) {
+	return nil, errors.New("outbound HTTP is only available when built with GOOS=wasip1 and run by a host providing wasi-experimental-http")
+}
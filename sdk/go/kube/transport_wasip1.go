@@ -0,0 +1,125 @@
+//go:build wasip1
+
+package kube
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// These are the host functions provided by the wasi-experimental-http
+// wasmtime extension that the wasi-provider links into every module. Pointers
+// are offsets into the module's linear memory.
+
+//go:wasmimport wasi_experimental_http req
+func req(urlPtr unsafe.Pointer, urlLen uint32, methodPtr unsafe.Pointer, methodLen uint32, headersPtr unsafe.Pointer, headersLen uint32, bodyPtr unsafe.Pointer, bodyLen uint32, statusCodePtr unsafe.Pointer, handlePtr unsafe.Pointer) uint32
+
+//go:wasmimport wasi_experimental_http close
+func closeHandle(handle uint32) uint32
+
+//go:wasmimport wasi_experimental_http header_get
+func headerGet(handle uint32, namePtr unsafe.Pointer, nameLen uint32, valuePtr unsafe.Pointer, valueLen uint32, writtenPtr unsafe.Pointer) uint32
+
+//go:wasmimport wasi_experimental_http body_read
+func bodyRead(handle uint32, bufPtr unsafe.Pointer, bufLen uint32, writtenPtr unsafe.Pointer) uint32
+
+// responseHeaders are the response headers HostTransport reads. The host
+// functions can only look headers up by name.
+var responseHeaders = []string{"content-type", "retry-after"}
+
+// RoundTrip sends the request through the host and reads the whole
+// response.
+func (HostTransport) RoundTrip(r *Request) (*Response, error) {
+	// Headers are passed to the host as "name:value" lines
+	var sb strings.Builder
+	for k, v := range r.Header {
+		sb.WriteString(k + ":" + v + "\n")
+	}
+	rawHeaders := sb.String()
+
+	var statusCode uint16
+	var handle uint32
+	if code := req(
+		stringPtr(r.URL), uint32(len(r.URL)),
+		stringPtr(r.Method), uint32(len(r.Method)),
+		stringPtr(rawHeaders), uint32(len(rawHeaders)),
+		bytesPtr(r.Body), uint32(len(r.Body)),
+		unsafe.Pointer(&statusCode), unsafe.Pointer(&handle),
+	); code != 0 {
+		return nil, hostError("req", code)
+	}
+	defer closeHandle(handle)
+
+	res := &Response{StatusCode: int(statusCode), Header: map[string]string{}}
+	for _, name := range responseHeaders {
+		value, err := header(handle, name)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			res.Header[name] = value
+		}
+	}
+
+	buf := make([]byte, 16*1024)
+	for {
+		var written uint32
+		if code := bodyRead(handle, bytesPtr(buf), uint32(len(buf)), unsafe.Pointer(&written)); code != 0 {
+			return nil, hostError("body_read", code)
+		}
+		if written == 0 {
+			break
+		}
+		res.Body = append(res.Body, buf[:written]...)
+	}
+	return res, nil
+}
+
+// header returns the value of the named response header, or an empty string
+// if the response doesn't have it.
+func header(handle uint32, name string) (string, error) {
+	buf := make([]byte, 1024)
+	var written uint32
+	code := headerGet(handle, stringPtr(name), uint32(len(name)), bytesPtr(buf), uint32(len(buf)), unsafe.Pointer(&written))
+	switch code {
+	case 0:
+		return string(buf[:written]), nil
+	case 5:
+		return "", nil
+	default:
+		return "", hostError("header_get", code)
+	}
+}
+
+// errorCodes are the error values returned by the host functions.
+var errorCodes = map[uint32]string{
+	1:  "invalid handle",
+	2:  "memory not found",
+	3:  "memory access error",
+	4:  "buffer too small",
+	5:  "header not found",
+	6:  "invalid UTF-8",
+	7:  "destination not allowed, add the API server to the allowed-domains annotation",
+	8:  "invalid method",
+	9:  "invalid encoding",
+	10: "invalid URL",
+	11: "request error",
+	12: "runtime error",
+	13: "too many sessions, check the max-concurrent-requests annotation",
+}
+
+func hostError(call string, code uint32) error {
+	if msg, ok := errorCodes[code]; ok {
+		return fmt.Errorf("%s: %s (%d)", call, msg, code)
+	}
+	return fmt.Errorf("%s: unknown error (%d)", call, code)
+}
+
+func stringPtr(s string) unsafe.Pointer {
+	return unsafe.Pointer(unsafe.StringData(s))
+}
+
+func bytesPtr(b []byte) unsafe.Pointer {
+	return unsafe.Pointer(unsafe.SliceData(b))
+}
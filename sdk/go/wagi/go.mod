@@ -0,0 +1,3 @@
+module github.com/krustlet/krustlet/sdk/go/wagi
+
+go 1.21
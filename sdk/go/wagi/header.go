@@ -0,0 +1,72 @@
+package wagi
+
+import "strings"
+
+// Header holds HTTP headers, keyed by their canonical names, as
+// http.Header does.
+type Header map[string][]string
+
+// Get returns the first value of the header, or "" if it is not set.
+func (h Header) Get(key string) string {
+	if v := h[CanonicalHeaderKey(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Values returns all the values of the header.
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Set replaces the values of the header with value.
+func (h Header) Set(key, value string) {
+	h[CanonicalHeaderKey(key)] = []string{value}
+}
+
+// Add adds value to the values of the header.
+func (h Header) Add(key, value string) {
+	key = CanonicalHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Del removes the header.
+func (h Header) Del(key string) {
+	delete(h, CanonicalHeaderKey(key))
+}
+
+// CanonicalHeaderKey returns the canonical form of a header name, such as
+// Accept-Encoding for accept-encoding. Names that aren't valid header names
+// are returned unchanged.
+func CanonicalHeaderKey(key string) string {
+	if !validHeaderKey(key) {
+		return key
+	}
+	b := []byte(strings.ToLower(key))
+	upper := true
+	for i, c := range b {
+		if upper && 'a' <= c && c <= 'z' {
+			b[i] = c - 'a' + 'A'
+		}
+		upper = c == '-'
+	}
+	return string(b)
+}
+
+// validHeaderKey reports whether key is a token, as RFC 9110 requires of
+// header names.
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+// Package wagi helps write WAGI request handlers in Go.
+//
+// WAGI runs a WebAssembly module once per HTTP request using CGI conventions:
+// the request line and headers arrive as environment variables, the request
+// body on stdin, and the module writes headers, a blank line and then the
+// response body to stdout. This package turns that environment into a
+// Request, gives handlers a ResponseWriter that emits the headers WAGI
+// expects, and routes requests by method and path:
+//
+//	func main() {
+//		r := wagi.NewRouter()
+//		r.HandleFunc("GET", "/hello/:name", func(w wagi.ResponseWriter, req *wagi.Request) {
+//			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+//			fmt.Fprintf(w, "Hello, %s!\n", req.Param("name"))
+//		})
+//		wagi.Serve(r)
+//	}
+//
+// The package only uses the parts of the standard library that TinyGo
+// supports, and none of net/http, so modules can be built with either
+// GOOS=wasip1 GOARCH=wasm or TinyGo's wasi target.
+package wagi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrBodyTooLarge is returned when reading a request body larger than the
+// limit given.
+var ErrBodyTooLarge = errors.New("wagi: request body too large")
+
+// Request is an HTTP request as WAGI passes it to a module.
+type Request struct {
+	// Method is the request method, such as GET, from REQUEST_METHOD.
+	Method string
+	// Path is the request path from PATH_INFO, already decoded. WAGI gives
+	// it relative to the route the module is mounted at, which is in
+	// ScriptName. It is "/" when empty.
+	Path string
+	// RawQuery is the query string without the '?', from QUERY_STRING.
+	RawQuery string
+	// Query is the parsed query string.
+	Query url.Values
+	// Header holds the request headers, from the HTTP_* variables and
+	// CONTENT_TYPE and CONTENT_LENGTH.
+	Header Header
+	// ContentLength is the length of the body from CONTENT_LENGTH, or -1
+	// if it is not known.
+	ContentLength int64
+	// Body is the request body. It is never nil.
+	Body io.Reader
+	// Host is the host the request was sent to, from the Host header or
+	// else SERVER_NAME.
+	Host string
+	// RemoteAddr is the client's address, from REMOTE_ADDR.
+	RemoteAddr string
+	// ScriptName is the route the module is mounted at, from SCRIPT_NAME.
+	ScriptName string
+	// Route is the route in the WAGI configuration that matched the
+	// request, such as /api/..., from X_MATCHED_ROUTE.
+	Route string
+	// URL is the full URL the request was sent to, from X_FULL_URL.
+	URL string
+
+	env    map[string]string
+	params map[string]string
+}
+
+// NewRequest builds a request from a CGI environment, as returned by
+// os.Environ, and the request body. Serve calls it with the module's own
+// environment and stdin; tests can call it with their own.
+func NewRequest(environ []string, body io.Reader) (*Request, error) {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+
+	r := &Request{
+		Method:        env["REQUEST_METHOD"],
+		Path:          env["PATH_INFO"],
+		RawQuery:      env["QUERY_STRING"],
+		Header:        Header{},
+		ContentLength: -1,
+		RemoteAddr:    env["REMOTE_ADDR"],
+		ScriptName:    env["SCRIPT_NAME"],
+		Route:         env["X_MATCHED_ROUTE"],
+		URL:           env["X_FULL_URL"],
+		env:           env,
+	}
+	if r.Method == "" {
+		r.Method = "GET"
+	}
+	if r.Path == "" {
+		r.Path = "/"
+	}
+
+	query, err := url.ParseQuery(r.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("wagi: invalid query string: %w", err)
+	}
+	r.Query = query
+
+	// Request headers are passed as HTTP_<NAME> with dashes turned into
+	// underscores. Content type and length get their own variables.
+	for k, v := range env {
+		if name, ok := strings.CutPrefix(k, "HTTP_"); ok && name != "" {
+			r.Header.Add(strings.ReplaceAll(name, "_", "-"), v)
+		}
+	}
+	if v := env["CONTENT_TYPE"]; v != "" {
+		r.Header.Set("Content-Type", v)
+	}
+	if v := env["CONTENT_LENGTH"]; v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("wagi: invalid content length %q", v)
+		}
+		r.ContentLength = n
+		r.Header.Set("Content-Length", v)
+	}
+	r.Host = r.Header.Get("Host")
+	if r.Host == "" {
+		r.Host = env["SERVER_NAME"]
+	}
+
+	switch {
+	case body == nil || r.ContentLength == 0:
+		r.Body = strings.NewReader("")
+	case r.ContentLength > 0:
+		r.Body = io.LimitReader(body, r.ContentLength)
+	default:
+		r.Body = body
+	}
+	return r, nil
+}
+
+// Getenv returns a variable from the environment the request was built from,
+// for the ones that have no field of their own, such as SERVER_PROTOCOL, or
+// that the module was given in its WAGI configuration.
+func (r *Request) Getenv(key string) string {
+	return r.env[key]
+}
+
+// Param returns the value of a named parameter in the pattern of the route
+// the Router matched the request with, or "" if there is none.
+func (r *Request) Param(name string) string {
+	return r.params[name]
+}
+
+// ReadBody reads the whole request body, returning ErrBodyTooLarge if it is
+// longer than limit bytes.
+func (r *Request) ReadBody(limit int64) ([]byte, error) {
+	if r.ContentLength > limit {
+		return nil, ErrBodyTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	if r.ContentLength > 0 && int64(len(data)) < r.ContentLength {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// Form reads and parses a body of type application/x-www-form-urlencoded,
+// up to limit bytes. It returns an empty set of values for requests with
+// another content type, whose body is left unread.
+func (r *Request) Form(limit int64) (url.Values, error) {
+	mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	if !strings.EqualFold(strings.TrimSpace(mediaType), "application/x-www-form-urlencoded") {
+		return url.Values{}, nil
+	}
+	data, err := r.ReadBody(limit)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("wagi: invalid form body: %w", err)
+	}
+	return form, nil
+}
@@ -0,0 +1,134 @@
+package wagi
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewRequest(t *testing.T) {
+	env := []string{
+		"REQUEST_METHOD=POST",
+		"PATH_INFO=/users/42",
+		"QUERY_STRING=verbose=1&tag=a&tag=b",
+		"SCRIPT_NAME=/api",
+		"X_MATCHED_ROUTE=/api/...",
+		"X_FULL_URL=http://example.com:3000/api/users/42?verbose=1&tag=a&tag=b",
+		"SERVER_NAME=example.com",
+		"SERVER_PROTOCOL=HTTP/1.1",
+		"REMOTE_ADDR=10.0.0.1",
+		"HTTP_ACCEPT_ENCODING=gzip",
+		"HTTP_X_REQUEST_ID=abc",
+		"CONTENT_TYPE=application/json",
+		"CONTENT_LENGTH=7",
+	}
+	r, err := NewRequest(env, strings.NewReader(`{"a":1}trailing`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ got, want string }{
+		{r.Method, "POST"},
+		{r.Path, "/users/42"},
+		{r.ScriptName, "/api"},
+		{r.Route, "/api/..."},
+		{r.Host, "example.com"},
+		{r.RemoteAddr, "10.0.0.1"},
+		{r.Header.Get("accept-encoding"), "gzip"},
+		{r.Header.Get("X-Request-Id"), "abc"},
+		{r.Header.Get("Content-Type"), "application/json"},
+		{r.Getenv("SERVER_PROTOCOL"), "HTTP/1.1"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("got %q, want %q", tt.got, tt.want)
+		}
+	}
+	if tags := r.Query["tag"]; len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("unexpected query %v", r.Query)
+	}
+	body, err := r.ReadBody(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only CONTENT_LENGTH bytes are the body
+	if string(body) != `{"a":1}` {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestNewRequestDefaults(t *testing.T) {
+	r, err := NewRequest(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Method != "GET" || r.Path != "/" || r.ContentLength != -1 {
+		t.Errorf("unexpected request %+v", r)
+	}
+	if data, _ := io.ReadAll(r.Body); len(data) != 0 {
+		t.Errorf("expected an empty body, got %q", data)
+	}
+}
+
+func TestNewRequestInvalid(t *testing.T) {
+	for _, env := range [][]string{
+		{"CONTENT_LENGTH=-1"},
+		{"CONTENT_LENGTH=lots"},
+		{"QUERY_STRING=a=%zz"},
+	} {
+		if _, err := NewRequest(env, nil); err == nil {
+			t.Errorf("%v: expected an error", env)
+		}
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	r, _ := NewRequest([]string{"CONTENT_LENGTH=10"}, strings.NewReader("0123456789"))
+	if _, err := r.ReadBody(5); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+
+	// Without a length the body is read until stdin ends
+	r, _ = NewRequest(nil, strings.NewReader("0123456789"))
+	if _, err := r.ReadBody(5); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+
+	r, _ = NewRequest([]string{"CONTENT_LENGTH=10"}, strings.NewReader("01234"))
+	if _, err := r.ReadBody(100); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected a short body to be an error, got %v", err)
+	}
+}
+
+func TestForm(t *testing.T) {
+	r, _ := NewRequest([]string{
+		"REQUEST_METHOD=POST",
+		"CONTENT_TYPE=application/x-www-form-urlencoded; charset=utf-8",
+		"CONTENT_LENGTH=13",
+	}, strings.NewReader("name=krustlet"))
+	form, err := r.Form(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("name") != "krustlet" {
+		t.Errorf("unexpected form %v", form)
+	}
+
+	r, _ = NewRequest([]string{"CONTENT_TYPE=text/plain", "CONTENT_LENGTH=13"}, strings.NewReader("name=krustlet"))
+	form, err = r.Form(1024)
+	if err != nil || len(form) != 0 {
+		t.Errorf("expected no form values for a text body, got %v, %v", form, err)
+	}
+}
+
+func TestCanonicalHeaderKey(t *testing.T) {
+	for key, want := range map[string]string{
+		"accept-encoding": "Accept-Encoding",
+		"X-REQUEST-ID":    "X-Request-Id",
+		"etag":            "Etag",
+		"bad key":         "bad key",
+	} {
+		if got := CanonicalHeaderKey(key); got != want {
+			t.Errorf("%q: got %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package wagi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResponseWriter is what a handler writes its response with. It has the
+// methods of http.ResponseWriter, so handlers read as they would with
+// net/http.
+type ResponseWriter interface {
+	// Header returns the headers to send. Changing them after the first
+	// call to WriteHeader or Write has no effect.
+	Header() Header
+	// WriteHeader sends the headers with the status code. Calls after the
+	// first are ignored.
+	WriteHeader(status int)
+	// Write writes to the response body, first sending the headers with
+	// status 200 if WriteHeader has not been called.
+	Write(p []byte) (int, error)
+}
+
+// defaultContentType is sent for responses without a Content-Type. WAGI
+// rejects responses with neither a Content-Type nor a Location header.
+const defaultContentType = "text/plain; charset=utf-8"
+
+// response writes the CGI response WAGI reads from the module's stdout.
+type response struct {
+	out         *bufio.Writer
+	log         io.Writer
+	header      Header
+	wroteHeader bool
+	// head discards the body, for HEAD requests
+	head bool
+}
+
+func newResponse(out io.Writer, log io.Writer, head bool) *response {
+	return &response{out: bufio.NewWriter(out), log: log, header: Header{}, head: head}
+}
+
+func (w *response) Header() Header {
+	return w.header
+}
+
+func (w *response) WriteHeader(status int) {
+	if w.wroteHeader {
+		fmt.Fprintf(w.log, "wagi: ignoring superfluous WriteHeader(%d)\n", status)
+		return
+	}
+	if status < 100 || status > 999 {
+		panic(fmt.Sprintf("wagi: invalid status code %d", status))
+	}
+	w.wroteHeader = true
+
+	// The status is a header of its own in CGI, which WriteHeader sets
+	w.header.Del("Status")
+	if w.header.Get("Content-Type") == "" && w.header.Get("Location") == "" {
+		w.header.Set("Content-Type", defaultContentType)
+	}
+	keys := make([]string, 0, len(w.header))
+	for k := range w.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !validHeaderKey(k) {
+			fmt.Fprintf(w.log, "wagi: dropping header with invalid name %q\n", k)
+			continue
+		}
+		for _, v := range w.header[k] {
+			// A line break would end the header, letting the value add
+			// headers or start the body
+			v = strings.NewReplacer("\r", " ", "\n", " ").Replace(v)
+			w.out.WriteString(k + ": " + v + "\n")
+		}
+	}
+	w.out.WriteString("Status: " + strconv.Itoa(status) + "\n\n")
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if w.head {
+		return len(p), nil
+	}
+	return w.out.Write(p)
+}
+
+// finish sends the headers if nothing was written and flushes the response.
+func (w *response) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	return w.out.Flush()
+}
+
+// Error replies with a plain text error message and status code. The
+// handler should not write anything else.
+func Error(w ResponseWriter, message string, status int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, message)
+}
+
+// NotFound replies with a 404.
+func NotFound(w ResponseWriter, _ *Request) {
+	Error(w, "404 page not found", 404)
+}
+
+// Redirect replies with a redirect to location, which may be relative to
+// the request path, with a status code in the 3xx range such as 302 or 303.
+func Redirect(w ResponseWriter, r *Request, location string, status int) {
+	w.Header().Set("Location", location)
+	if r.Method == "GET" || r.Method == "HEAD" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	if r.Method == "GET" {
+		fmt.Fprintf(w, "<a href=\"%s\">Moved</a>.\n", htmlEscaper.Replace(location))
+	}
+}
+
+var htmlEscaper = strings.NewReplacer(`&`, "&amp;", `<`, "&lt;", `>`, "&gt;", `"`, "&#34;", `'`, "&#39;")
@@ -0,0 +1,140 @@
+package wagi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Router dispatches requests to handlers by method and path.
+//
+// Patterns are paths whose segments can be parameters: ":name" matches one
+// non-empty segment and "*name", which must be last, matches the rest of the
+// path, so "/files/*path" matches /files/ and everything below it. Handlers
+// get the values with Request.Param. Routes are tried in the order they were
+// added, and the first match wins.
+//
+// Paths are matched against Request.Path, so the patterns are relative to
+// the route the module is mounted at.
+type Router struct {
+	// NotFound handles requests no route matches. It defaults to the
+	// NotFound function.
+	NotFound Handler
+
+	routes []route
+}
+
+type route struct {
+	method   string
+	segments []string
+	handler  Handler
+}
+
+// NewRouter returns a router with no routes.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle routes requests with the method and a path matching pattern to h.
+// An empty method matches any method, and GET routes also match HEAD
+// requests. It panics if the pattern is invalid.
+func (rt *Router) Handle(method, pattern string, h Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("wagi: pattern %q does not start with /", pattern))
+	}
+	segments := splitPath(pattern)
+	for i, s := range segments {
+		if (s == ":" || s == "*") || (strings.HasPrefix(s, "*") && i != len(segments)-1) {
+			panic(fmt.Sprintf("wagi: invalid pattern %q", pattern))
+		}
+	}
+	rt.routes = append(rt.routes, route{method: method, segments: segments, handler: h})
+}
+
+// HandleFunc routes requests with the method and a path matching pattern to
+// f.
+func (rt *Router) HandleFunc(method, pattern string, f func(ResponseWriter, *Request)) {
+	rt.Handle(method, pattern, HandlerFunc(f))
+}
+
+// ServeWAGI calls the handler of the first route matching the request. If
+// routes match the path but not the method, it replies with a 405 and the
+// methods that are allowed.
+func (rt *Router) ServeWAGI(w ResponseWriter, r *Request) {
+	path := splitPath(r.Path)
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := match(route.segments, path)
+		if !ok {
+			continue
+		}
+		if route.method == "" || route.method == r.Method || (route.method == "GET" && r.Method == "HEAD") {
+			r.params = params
+			route.handler.ServeWAGI(w, r)
+			return
+		}
+		allowed = append(allowed, route.method)
+		if route.method == "GET" {
+			allowed = append(allowed, "HEAD")
+		}
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(dedupe(allowed), ", "))
+		Error(w, "method "+r.Method+" is not allowed", 405)
+		return
+	}
+	if rt.NotFound != nil {
+		rt.NotFound.ServeWAGI(w, r)
+		return
+	}
+	NotFound(w, r)
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+// match matches the segments of a path against those of a pattern,
+// returning the values of its parameters.
+func match(pattern, path []string) (map[string]string, bool) {
+	var params map[string]string
+	set := func(name, value string) {
+		if params == nil {
+			params = map[string]string{}
+		}
+		params[name] = value
+	}
+	for i, p := range pattern {
+		if name, ok := strings.CutPrefix(p, "*"); ok {
+			if i >= len(path) {
+				return nil, false
+			}
+			set(name, strings.Join(path[i:], "/"))
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		if name, ok := strings.CutPrefix(p, ":"); ok {
+			if path[i] == "" {
+				return nil, false
+			}
+			set(name, path[i])
+		} else if p != path[i] {
+			return nil, false
+		}
+	}
+	return params, len(pattern) == len(path)
+}
+
+func dedupe(methods []string) []string {
+	sort.Strings(methods)
+	out := methods[:0]
+	for i, m := range methods {
+		if i == 0 || m != methods[i-1] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
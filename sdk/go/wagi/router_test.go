@@ -0,0 +1,67 @@
+package wagi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	reply := func(name string) func(ResponseWriter, *Request) {
+		return func(w ResponseWriter, req *Request) {
+			fmt.Fprintf(w, "%s id=%s path=%s", name, req.Param("id"), req.Param("path"))
+		}
+	}
+	r.HandleFunc("GET", "/", reply("index"))
+	r.HandleFunc("GET", "/users/new", reply("new"))
+	r.HandleFunc("GET", "/users/:id", reply("show"))
+	r.HandleFunc("DELETE", "/users/:id", reply("delete"))
+	r.HandleFunc("", "/files/*path", reply("files"))
+
+	for _, tt := range []struct {
+		method, path, want string
+	}{
+		{"GET", "/", "Status: 200\n\nindex"},
+		{"GET", "/users/new", "new id="},
+		{"GET", "/users/42", "show id=42 "},
+		{"HEAD", "/users/42", "Status: 200\n\n"},
+		{"DELETE", "/users/42", "delete id=42"},
+		{"PUT", "/files/a/b.txt", "files id= path=a/b.txt"},
+		{"GET", "/files/", "files id= path="},
+		{"GET", "/files", "Status: 404"},
+		{"GET", "/users/", "Status: 404"},
+		{"GET", "/users/42/posts", "Status: 404"},
+		{"POST", "/users/42", "Allow: DELETE, GET, HEAD\n"},
+	} {
+		out, _ := call(t, r, []string{"REQUEST_METHOD=" + tt.method, "PATH_INFO=" + tt.path}, "")
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%s %s: expected %q in:\n%s", tt.method, tt.path, tt.want, out)
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := NewRouter()
+	r.NotFound = HandlerFunc(func(w ResponseWriter, _ *Request) {
+		w.WriteHeader(404)
+		fmt.Fprint(w, "nothing here")
+	})
+	out, _ := call(t, r, []string{"PATH_INFO=/missing"}, "")
+	if !strings.HasSuffix(out, "Status: 404\n\nnothing here") {
+		t.Errorf("unexpected response:\n%s", out)
+	}
+}
+
+func TestRouterInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"users", "/files/*path/more", "/users/:"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected a panic", pattern)
+				}
+			}()
+			NewRouter().HandleFunc("GET", pattern, func(ResponseWriter, *Request) {})
+		}()
+	}
+}
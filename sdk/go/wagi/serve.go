@@ -0,0 +1,56 @@
+package wagi
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Handler responds to a request.
+type Handler interface {
+	ServeWAGI(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc lets an ordinary function be a Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+// ServeWAGI calls f(w, r).
+func (f HandlerFunc) ServeWAGI(w ResponseWriter, r *Request) {
+	f(w, r)
+}
+
+// Serve handles the request the module was started for: it reads the
+// request from the environment and stdin, calls h, and writes the response
+// to stdout. Malformed requests get a 400 without h being called. Errors go
+// to stderr, which WAGI sends to its log. Call it from main.
+func Serve(h Handler) {
+	if err := serve(h, os.Environ(), os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "wagi: writing the response: %s\n", err)
+	}
+}
+
+func serve(h Handler, environ []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	r, err := NewRequest(environ, stdin)
+	if err != nil {
+		w := newResponse(stdout, stderr, false)
+		Error(w, err.Error(), 400)
+		return w.finish()
+	}
+
+	w := newResponse(stdout, stderr, r.Method == "HEAD")
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				fmt.Fprintf(stderr, "wagi: panic serving %s %s: %v\n", r.Method, r.Path, v)
+				// Once the headers are sent, the response can only be cut
+				// short
+				if !w.wroteHeader {
+					w.header = Header{}
+					Error(w, "500 internal server error", 500)
+				}
+			}
+		}()
+		h.ServeWAGI(w, r)
+	}()
+	return w.finish()
+}
@@ -0,0 +1,113 @@
+package wagi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// call serves a request with the environment and body, returning what was
+// written to stdout and stderr
+func call(t *testing.T, h Handler, env []string, body string) (string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if err := serve(h, env, strings.NewReader(body), &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestServe(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(201)
+		fmt.Fprintf(w, `{"path":%q}`, r.Path)
+	})
+	out, _ := call(t, h, []string{"REQUEST_METHOD=POST", "PATH_INFO=/things"}, "")
+	want := "Content-Type: application/json\n" +
+		"Set-Cookie: a=1\n" +
+		"Set-Cookie: b=2\n" +
+		"Status: 201\n" +
+		"\n" +
+		`{"path":"/things"}`
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestServeDefaults(t *testing.T) {
+	// Writing without WriteHeader sends a 200, and a handler that writes
+	// nothing still sends the headers WAGI needs
+	for _, h := range []HandlerFunc{
+		func(w ResponseWriter, _ *Request) { fmt.Fprint(w, "hi") },
+		func(ResponseWriter, *Request) {},
+	} {
+		out, _ := call(t, h, nil, "")
+		if !strings.HasPrefix(out, "Content-Type: text/plain; charset=utf-8\nStatus: 200\n\n") {
+			t.Errorf("unexpected response:\n%s", out)
+		}
+	}
+}
+
+func TestServeHead(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, _ *Request) { fmt.Fprint(w, "body") })
+	out, _ := call(t, h, []string{"REQUEST_METHOD=HEAD"}, "")
+	if strings.Contains(out, "body") {
+		t.Errorf("expected no body for HEAD, got:\n%s", out)
+	}
+}
+
+func TestServeHeaderInjection(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Name", r.Query.Get("name"))
+		w.Header().Set("Status", "200")
+		w.Header().Set("Bad Name", "x")
+		w.WriteHeader(200)
+	})
+	out, logs := call(t, h, []string{"QUERY_STRING=name=a%0D%0AStatus:%20302"}, "")
+	want := "Content-Type: text/plain; charset=utf-8\n" +
+		"X-Name: a  Status: 302\n" +
+		"Status: 200\n\n"
+	if out != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if !strings.Contains(logs, "Bad Name") {
+		t.Errorf("expected the invalid header to be logged, got %q", logs)
+	}
+}
+
+func TestServeBadRequest(t *testing.T) {
+	h := HandlerFunc(func(ResponseWriter, *Request) { t.Error("the handler should not be called") })
+	out, _ := call(t, h, []string{"CONTENT_LENGTH=lots"}, "")
+	if !strings.Contains(out, "Status: 400\n") {
+		t.Errorf("expected a 400, got:\n%s", out)
+	}
+}
+
+func TestServePanic(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, _ *Request) {
+		w.Header().Set("Content-Type", "application/json")
+		panic("oops")
+	})
+	out, logs := call(t, h, []string{"PATH_INFO=/boom"}, "")
+	if !strings.HasPrefix(out, "Content-Type: text/plain; charset=utf-8\n") || !strings.Contains(out, "Status: 500\n") {
+		t.Errorf("expected a 500, got:\n%s", out)
+	}
+	if !strings.Contains(logs, "panic serving GET /boom: oops") {
+		t.Errorf("expected the panic to be logged, got %q", logs)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	h := HandlerFunc(func(w ResponseWriter, r *Request) { Redirect(w, r, "/login?next=/a&b", 303) })
+	out, _ := call(t, h, nil, "")
+	if !strings.Contains(out, "Location: /login?next=/a&b\n") || !strings.Contains(out, "Status: 303\n") {
+		t.Errorf("unexpected redirect:\n%s", out)
+	}
+	if !strings.Contains(out, `href="/login?next=/a&amp;b"`) {
+		t.Errorf("expected the location to be escaped in the body:\n%s", out)
+	}
+}
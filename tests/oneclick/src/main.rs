@@ -234,17 +234,12 @@ fn is_resource_gone(kubectl_output: &std::process::Output) -> bool {
 }
 
 fn run_bootstrap() -> anyhow::Result<()> {
-    #[cfg(target_family = "unix")]
-    let (shell, ext) = ("bash", "sh");
-    #[cfg(target_family = "windows")]
-    let (shell, ext) = ("powershell.exe", "ps1");
-
     let repo_root = std::env!("CARGO_MANIFEST_DIR");
 
-    let bootstrap_script = format!("{}/scripts/bootstrap.{}", repo_root, ext);
-    let bootstrap_output = std::process::Command::new(shell)
-        .arg(bootstrap_script)
-        .env("CONFIG_DIR", config_dir())
+    let bootstrap_output = std::process::Command::new("go")
+        .args(&["run", "./cmd/krustlet-bootstrap", "--config-dir"])
+        .arg(config_dir())
+        .current_dir(repo_root)
         .stdout(std::process::Stdio::piped())
         .stderr(std::process::Stdio::piped())
         .output()?;